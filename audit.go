@@ -0,0 +1,334 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+const ctx_audit_old_values_key = "MYSQL_AUDIT_OLD_VALUES"
+
+// The fields of the history model added to the fields of the audited model, see EnableAudit
+const (
+	AuditIdField        = "audit_id"
+	AuditChangedAtField = "changed_at"
+	AuditChangedByField = "changed_by"
+	AuditOperationField = "operation"
+	AuditDiffField      = "diff"
+)
+
+// AuditDiffSource is the source of the old values of the edited fields the new values are compared with
+type AuditDiffSource int
+
+const (
+	// AuditPreImage selects the edited fields of the rows FOR UPDATE before the update, the fields set
+	// to the expressions are selected after the update as well
+	AuditPreImage AuditDiffSource = iota
+	// AuditOldValues compares the new values with the old ones passed with WithAuditOldValues, the fields
+	// without the old values are recorded as changed and the fields set to the expressions are not recorded
+	AuditOldValues
+)
+
+type AuditOpts struct {
+	// TableName is the name of the history table, "<model>_history" by default
+	TableName string
+	// ChangedByKey is the context key of the author of the changes, the value is stored in changed_by
+	// as a string, changed_by is NULL if there is no value
+	ChangedByKey interface{}
+	// DiffSource is the source of the old values of the edited fields, AuditPreImage by default
+	DiffSource AuditDiffSource
+}
+
+type audit struct {
+	history     *BaseModel
+	fieldsNames []string
+	opts        AuditOpts
+}
+
+// EnableAudit makes Add, Edit and Delete of the model write the history rows to the returned history model
+// in the transaction of the write. The history table has the nullable copies of the stored fields of the model
+// along with audit_id, changed_at, changed_by, operation and diff, the JSON object of the changed fields
+// with their "old" and "new" values. The history rows of the updates have the primary key and the changed fields
+// only, the updates changing nothing are not recorded. The upserts are recorded as insert.
+// It must be called before the storage is used.
+func (s *MySQL) EnableAudit(m model.IModel, opts AuditOpts) *BaseModel {
+	if opts.TableName == "" {
+		opts.TableName = m.GetId() + "_history"
+	}
+
+	a := &audit{opts: opts}
+	fields := []IMysqlFieldDefinition{
+		&BigUintField{Id: AuditIdField, Caption: "Audit ID", NotNull: true, AutoIncrement: true},
+		&DateTimeField{Id: AuditChangedAtField, Caption: "Changed at", NotNull: true},
+		&VarCharField{Id: AuditChangedByField, Caption: "Changed by", Length: 255},
+		&VarCharField{Id: AuditOperationField, Caption: "Operation", Length: 16, NotNull: true},
+		&JSONField{Id: AuditDiffField, Caption: "Diff"},
+	}
+	for _, name := range m.GetFieldsNames() {
+		field := m.GetFieldDefinition(name)
+		if field.IsDerivable() {
+			continue
+		}
+		a.fieldsNames = append(a.fieldsNames, name)
+		fields = append(fields, field.CloneForFK(name, field.GetCaption(), false).(IMysqlFieldDefinition))
+	}
+
+	a.history = NewBaseModel(s, opts.TableName, fields, nil, BaseModelOpts{
+		BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{AuditIdField}},
+		Indexes:       []Index{{FieldNames: m.GetPKFieldsNames()}},
+	})
+
+	if s.audits == nil {
+		s.audits = make(map[string]*audit)
+	}
+	s.audits[m.GetId()] = a
+
+	return a.history
+}
+
+// WithAuditOldValues passes the old values of the fields edited or deleted with the context to the audit
+// of the models with AuditOldValues
+func WithAuditOldValues(ctx context.Context, oldValues map[string]interface{}) context.Context {
+	return context.WithValue(ctx, ctx_audit_old_values_key, oldValues)
+}
+
+// auditOf returns the audit of the model, nil if the model is not audited or in the dry run
+func (s *MySQL) auditOf(ctx context.Context, m model.IModel) *audit {
+	if len(s.audits) == 0 || dryRunOf(ctx) != nil {
+		return nil
+	}
+
+	return s.audits[m.GetId()]
+}
+
+// auditImage selects FOR UPDATE the primary keys of the rows matching the filter and the fields the old values
+// of which are selected, nil if the model is not audited
+func (s *MySQL) auditImage(ctx context.Context, m model.IModel, filter model.IExpression, fieldsNames []string) (*model.Data, error) {
+	a := s.auditOf(ctx, m)
+	if a == nil {
+		return nil, nil
+	}
+
+	selected := m.GetPKFieldsNames()
+	if a.opts.DiffSource == AuditPreImage {
+		for _, name := range fieldsNames {
+			selected = appendMissing(selected[:len(selected):len(selected)], name)
+		}
+	}
+
+	// The history keeps the stored values
+	return s.Select(s.WithUnmasked(ctx), m, selected, SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter:    filter,
+		ForUpdate: true,
+	}})
+}
+
+// auditInsert writes the history rows of the inserted rows
+func (s *MySQL) auditInsert(ctx context.Context, m model.IModel, data, pks *model.Data) error {
+	a := s.auditOf(ctx, m)
+	if a == nil {
+		return nil
+	}
+
+	rows := make([]auditRow, data.Len())
+	for i, row := range data.Data() {
+		rows[i] = auditRow{values: make(map[string]interface{}), diff: make(map[string]map[string]interface{})}
+		for j, name := range data.Fields() {
+			if indexOf(a.fieldsNames, name) >= 0 {
+				rows[i].values[name] = derefValue(row[j])
+				rows[i].diff[name] = map[string]interface{}{"new": derefValue(row[j])}
+			}
+		}
+		for j, name := range pks.Fields() {
+			rows[i].values[name] = derefValue(pks.Data()[i][j])
+		}
+	}
+
+	return s.writeAudit(ctx, a, "insert", rows)
+}
+
+// auditEdit writes the history rows of the edited rows selected by auditImage, the rows without the changed
+// fields are skipped
+func (s *MySQL) auditEdit(ctx context.Context, m model.IModel, before *model.Data, newValues map[string]interface{}) error {
+	a := s.auditOf(ctx, m)
+	if a == nil || before == nil || before.Len() == 0 {
+		return nil
+	}
+
+	pkNames := m.GetPKFieldsNames()
+	names := make([]string, 0, len(newValues))
+	var exprNames []string
+	for name, value := range newValues {
+		names = append(names, name)
+		if _, ok := value.(model.IExpression); ok {
+			exprNames = append(exprNames, name)
+		}
+	}
+	sort.Strings(names)
+	sort.Strings(exprNames)
+
+	// The primary keys of the rows after the update
+	pks := model.NewEmptyData(pkNames)
+	for _, row := range before.Data() {
+		pk := make([]interface{}, len(pkNames))
+		for i, name := range pkNames {
+			pk[i] = derefValue(row[before.FieldNum(name)])
+			if value, exists := newValues[name]; exists && indexOf(exprNames, name) < 0 {
+				pk[i] = derefValue(value)
+			}
+		}
+		if err := pks.Add(pk); err != nil {
+			return err
+		}
+	}
+
+	var after *model.Data
+	if len(exprNames) > 0 && a.opts.DiffSource == AuditPreImage {
+		var err error
+		if after, err = s.selectByPKs(ctx, m, pks, exprNames); err != nil {
+			return err
+		}
+		if after.Len() != pks.Len() {
+			return qerror.Errorf("The edited rows of model '%s' are not found after the update", m.GetId())
+		}
+	}
+
+	oldValues, _ := ctx.Value(ctx_audit_old_values_key).(map[string]interface{})
+
+	var rows []auditRow
+	for i, pk := range pks.Data() {
+		row := auditRow{values: make(map[string]interface{}), diff: make(map[string]map[string]interface{})}
+		for j, name := range pkNames {
+			row.values[name] = pk[j]
+		}
+
+		for _, name := range names {
+			var newValue interface{}
+			if indexOf(exprNames, name) >= 0 {
+				if after == nil {
+					continue
+				}
+				newValue = derefValue(after.Data()[i][after.FieldNum(name)])
+			} else {
+				newValue = derefValue(newValues[name])
+			}
+
+			change := map[string]interface{}{"new": newValue}
+			if pos := before.FieldNum(name); pos >= 0 && a.opts.DiffSource == AuditPreImage {
+				change["old"] = derefValue(before.Data()[i][pos])
+			} else if oldValue, exists := oldValues[name]; exists {
+				change["old"] = derefValue(oldValue)
+			}
+			if oldValue, exists := change["old"]; exists && sameValue(oldValue, newValue) {
+				continue
+			}
+
+			row.values[name] = newValue
+			row.diff[name] = change
+		}
+
+		if len(row.diff) > 0 {
+			rows = append(rows, row)
+		}
+	}
+
+	return s.writeAudit(ctx, a, "update", rows)
+}
+
+// auditDelete writes the history rows of the deleted rows selected by auditImage
+func (s *MySQL) auditDelete(ctx context.Context, m model.IModel, before *model.Data) error {
+	a := s.auditOf(ctx, m)
+	if a == nil || before == nil {
+		return nil
+	}
+
+	oldValues, _ := ctx.Value(ctx_audit_old_values_key).(map[string]interface{})
+
+	rows := make([]auditRow, before.Len())
+	for i, row := range before.Data() {
+		rows[i] = auditRow{values: make(map[string]interface{}), diff: make(map[string]map[string]interface{})}
+		for name, value := range oldValues {
+			if indexOf(a.fieldsNames, name) >= 0 {
+				rows[i].values[name] = derefValue(value)
+				rows[i].diff[name] = map[string]interface{}{"old": derefValue(value)}
+			}
+		}
+		for j, name := range before.Fields() {
+			rows[i].values[name] = derefValue(row[j])
+			rows[i].diff[name] = map[string]interface{}{"old": derefValue(row[j])}
+		}
+	}
+
+	return s.writeAudit(ctx, a, "delete", rows)
+}
+
+type auditRow struct {
+	values map[string]interface{}
+	diff   map[string]map[string]interface{}
+}
+
+// writeAudit inserts the history rows in the transaction of the context
+func (s *MySQL) writeAudit(ctx context.Context, a *audit, op string, rows []auditRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	var changedBy *string
+	if a.opts.ChangedByKey != nil {
+		if author := ctx.Value(a.opts.ChangedByKey); author != nil {
+			changedBy = new(string)
+			*changedBy = fmt.Sprint(derefValue(author))
+		}
+	}
+	changedAt := s.now()
+
+	fieldsNames := append([]string{AuditChangedAtField, AuditChangedByField, AuditOperationField, AuditDiffField}, a.fieldsNames...)
+	// The history rows of the large writes are inserted in the chunks fitting the placeholders budget
+	chunkSize := s.placeholdersBudget() / len(fieldsNames)
+	for start := 0; start < len(rows); start += chunkSize {
+		end := start + chunkSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		data := model.NewEmptyData(fieldsNames)
+		for _, row := range rows[start:end] {
+			diff, err := json.Marshal(row.diff)
+			if err != nil {
+				return err
+			}
+
+			values := make([]interface{}, len(fieldsNames))
+			values[0], values[1], values[2], values[3] = changedAt, changedBy, op, string(diff)
+			for i, name := range a.fieldsNames {
+				values[4+i] = row.values[name]
+			}
+			if err := data.Add(values); err != nil {
+				return err
+			}
+		}
+
+		if _, err := s.insertRows(ctx, a.history, data, model.AddOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sameValue reports whether the old and the new values of the field are equal
+func sameValue(a, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if res, ok := compareValues(a, b); ok {
+		return res == 0
+	}
+
+	return reflect.DeepEqual(a, b)
+}
@@ -2,20 +2,41 @@ package mysql
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
 )
 
 type BaseModel struct {
 	*model.BaseModel
-	db      *MySQL
-	indexes []Index
+	db              *MySQL
+	database        string
+	indexes         []Index
+	fullTextIndexes []FullTextIndex
+	cache           Cache
+	cacheTTL        time.Duration
+	checkWarnings   bool
+	scope           ScopePolicy
+	writeCallbacks  []WriteCallback
+	shard           ShardSpec
 }
 
 type BaseModelOpts struct {
 	model.BaseModelOpts
-	Indexes []Index
+	Indexes         []Index
+	FullTextIndexes []FullTextIndex
+	// Database is the database of the table if it differs from the default one, see MySQL.SetDatabase
+	Database string
+	// CheckWarnings makes the INSERTs and UPDATEs of the model fail with TruncationError if the server truncates
+	// a value, see WithWarningsCheck
+	CheckWarnings bool
+	// Scope is the policy of the rows visible with the context, see ScopePolicy and Unscoped
+	Scope ScopePolicy
+	// Shard splits the rows of the model across the shard tables, see ShardSpec
+	Shard ShardSpec
 }
 
 type Index struct {
@@ -23,50 +44,304 @@ type Index struct {
 	Unique     bool
 }
 
+type FullTextIndex struct {
+	FieldNames []string
+}
+
 func NewBaseModel(db *MySQL, id string, dbFields []IMysqlFieldDefinition, derivableFields []model.IFieldDefinition, opts BaseModelOpts) *BaseModel {
-	allFields := make([]model.IFieldDefinition, 0, len(dbFields)+len(derivableFields))
+	dbFields = withBlindIndexes(dbFields)
+	if err := validateModelDefinition(id, dbFields, derivableFields, opts); err != nil {
+		panic(err)
+	}
 
+	allFields := make([]model.IFieldDefinition, 0, len(dbFields)+len(derivableFields))
 	for _, field := range dbFields {
 		allFields = append(allFields, field)
 	}
-
 	for _, field := range derivableFields {
-		if !field.IsDerivable() {
-			panic(fmt.Sprintf("The field '%s' for the model '%s' is not derivable", field.GetId(), id))
-		}
 		allFields = append(allFields, field)
 	}
 
+	// The batch derivable fields are computed for all the rows before the per row Calc
+	opts.PrepareDerivableFieldsCtx = prepareBatchDerivable(derivableFields, opts.PrepareDerivableFieldsCtx)
+
 	m := &BaseModel{
-		BaseModel: model.NewBaseModel(id, allFields, db, opts.BaseModelOpts),
-		db:        db,
-		indexes:   opts.Indexes,
+		BaseModel:       model.NewBaseModel(id, allFields, db, opts.BaseModelOpts),
+		db:              db,
+		database:        opts.Database,
+		checkWarnings:   opts.CheckWarnings,
+		scope:           opts.Scope,
+		shard:           opts.Shard,
+		indexes:         opts.Indexes,
+		fullTextIndexes: opts.FullTextIndexes,
 	}
 
 	db.modelsMtx.Lock()
 	defer db.modelsMtx.Unlock()
+	if err := db.checkTableUnique(m); err != nil {
+		delete(db.models, id)
+		panic(err)
+	}
 	db.models[id] = m
 
 	return m
 }
 
+// DefinitionProblem is the problem of the model definition, Field is empty for the problems of the whole model
+type DefinitionProblem struct {
+	Field   string
+	Problem string
+}
+
+// ModelDefinitionError lists all the problems of the model definition, NewBaseModel panics with it
+type ModelDefinitionError struct {
+	Model    string
+	Problems []DefinitionProblem
+}
+
+func (e *ModelDefinitionError) Error() string {
+	lines := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		if p.Field == "" {
+			lines[i] = p.Problem
+		} else {
+			lines[i] = fmt.Sprintf("field '%s': %s", p.Field, p.Problem)
+		}
+	}
+
+	return fmt.Sprintf("%d problems in the definition of the model '%s':\n%s", len(e.Problems), e.Model, strings.Join(lines, "\n"))
+}
+
+// validateModelDefinition checks the ids, the primary key, the auto increment fields, the indexes,
+// the dependencies of the derivable fields and the lengths of the VARCHAR and VARBINARY fields
+func validateModelDefinition(id string, dbFields []IMysqlFieldDefinition, derivableFields []model.IFieldDefinition, opts BaseModelOpts) error {
+	e := &ModelDefinitionError{Model: id}
+	add := func(field, problem string, a ...interface{}) {
+		e.Problems = append(e.Problems, DefinitionProblem{field, fmt.Sprintf(problem, a...)})
+	}
+
+	if problem := identifierProblem(id); problem != "" {
+		add("", "%s", problem)
+	}
+
+	fields := make(map[string]model.IFieldDefinition, len(dbFields)+len(derivableFields))
+	addField := func(field model.IFieldDefinition) {
+		if _, exists := fields[field.GetId()]; exists {
+			add(field.GetId(), "duplicate field id")
+			return
+		}
+		fields[field.GetId()] = field
+	}
+	for _, field := range dbFields {
+		if problem := identifierProblem(field.GetId()); problem != "" {
+			add(field.GetId(), "%s", problem)
+		}
+		switch f := field.(type) {
+		case *VarCharField:
+			if f.Length <= 0 {
+				add(f.Id, "VARCHAR length must be positive")
+			}
+		case *VarBinaryField:
+			if f.Length <= 0 {
+				add(f.Id, "VARBINARY length must be positive")
+			}
+		case ITemporalFieldDefinition:
+			if f.GetZeroDatePolicy() == ZeroDateNull && field.GetType().Kind() != reflect.Ptr {
+				add(field.GetId(), "ZeroDateNull requires a nullable field")
+			}
+		}
+		if e := encryptionOf(field); e != nil {
+			if e.Cipher == nil {
+				add(field.GetId(), "the encryption requires a cipher")
+			}
+			if e.BlindIndex != "" && len(e.BlindIndexKey) == 0 {
+				add(field.GetId(), "the blind index requires a key")
+			}
+		}
+		addField(field)
+	}
+	for _, field := range derivableFields {
+		if !field.IsDerivable() {
+			add(field.GetId(), "the field is not derivable")
+		}
+		addField(field)
+	}
+
+	stored := func(name string) bool {
+		field, exists := fields[name]
+		return exists && !field.IsDerivable()
+	}
+
+	for _, name := range opts.PkFieldsNames {
+		if !stored(name) {
+			add(name, "the primary key field is not defined")
+		} else if readMaskOf(fields[name]) != nil {
+			add(name, "the primary key field cannot be masked")
+		}
+	}
+
+	checkIndex := func(kind string, fieldNames []string) {
+		if len(fieldNames) == 0 {
+			add("", "%s without fields", kind)
+		}
+		for _, name := range fieldNames {
+			if !stored(name) {
+				add(name, "%s references the undefined field", kind)
+			}
+		}
+	}
+	for _, index := range opts.Indexes {
+		checkIndex("the index", index.FieldNames)
+	}
+	for _, index := range opts.FullTextIndexes {
+		checkIndex("the full-text index", index.FieldNames)
+	}
+
+	for _, field := range dbFields {
+		if !field.IsAutoIncremented() {
+			continue
+		}
+		fieldType := field.GetType()
+		if fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		switch fieldType.Kind() {
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			add(field.GetId(), "AUTO_INCREMENT requires an integer field")
+		}
+		// MySQL requires the auto increment field to be the first one of a key
+		isKey := len(opts.PkFieldsNames) > 0 && opts.PkFieldsNames[0] == field.GetId()
+		for _, index := range opts.Indexes {
+			isKey = isKey || len(index.FieldNames) > 0 && index.FieldNames[0] == field.GetId()
+		}
+		if !isKey {
+			add(field.GetId(), "AUTO_INCREMENT requires the field to be the first one of the primary key or an index")
+		}
+	}
+
+	for _, field := range derivableFields {
+		for _, dep := range field.GetDependsOn() {
+			if _, exists := fields[dep]; !exists {
+				add(field.GetId(), "depends on the undefined field '%s'", dep)
+			}
+		}
+	}
+
+	if opts.Shard.Count < 0 {
+		add("", "the number of the shards must not be negative")
+	} else if opts.Shard.Count > 0 {
+		if !stored(opts.Shard.KeyField) {
+			add(opts.Shard.KeyField, "the shard key field is not defined")
+		}
+		first, second := opts.Shard.table(id, 0), opts.Shard.table(id, 1)
+		if problem := identifierProblem(first); problem != "" {
+			add("", "the shard table: %s", problem)
+		} else if first == second {
+			add("", "the shard table pattern must contain the shard number")
+		}
+	}
+
+	if len(e.Problems) > 0 {
+		return e
+	}
+
+	return nil
+}
+
+// validateModelIdentifiers checks the model id and the ids of the stored fields, see ValidateIdentifier
+func validateModelIdentifiers(m model.IModel) error {
+	if err := ValidateIdentifier(m.GetId()); err != nil {
+		return err
+	}
+	for _, name := range m.GetFieldsNames() {
+		if field := m.GetFieldDefinition(name); field != nil && !field.IsDerivable() {
+			if err := ValidateIdentifier(name); err != nil {
+				return qerror.Errorf("Invalid field id for the model '%s': %s", m.GetId(), err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
 func (m *BaseModel) GetDb() *MySQL {
 	return m.db
 }
 
+// SetCache makes the query results of the model to be cached for ttl, the writes to the model invalidate them.
+// The results missed in the cache are read from the primary server, not the replicas.
+// It must be called before the model is used.
+func (m *BaseModel) SetCache(cache Cache, ttl time.Duration) {
+	m.cache, m.cacheTTL = cache, ttl
+}
+
+func (m *BaseModel) GetCache() (Cache, time.Duration) {
+	return m.cache, m.cacheTTL
+}
+
+func (m *BaseModel) GetIndexes() []Index {
+	return m.indexes
+}
+
+// indexName returns the name of the index in the created table
+func (m *BaseModel) indexName(index Index) string {
+	indexNameArr := []string{}
+	if index.Unique {
+		indexNameArr = append(indexNameArr, "uniq")
+	}
+	indexNameArr = append(indexNameArr, m.GetId(), "")
+	indexNameArr = append(indexNameArr, index.FieldNames...)
+	indexName := strings.Join(indexNameArr, "_")
+	if len(indexName) > 64 {
+		indexName = indexName[0:64]
+	}
+
+	return indexName
+}
+
+func (m *BaseModel) GetFullTextIndexes() []FullTextIndex {
+	return m.fullTextIndexes
+}
+
+// fullTextIndexName returns the name of the full-text index in the created table
+func (m *BaseModel) fullTextIndexName(index FullTextIndex) string {
+	indexName := strings.Join(append([]string{"ft", m.GetId(), ""}, index.FieldNames...), "_")
+	if len(indexName) > 64 {
+		indexName = indexName[0:64]
+	}
+
+	return indexName
+}
+
 func (m *BaseModel) AddField(field model.IFieldDefinition) {
 	if !field.IsDerivable() {
 		if _, ok := field.(IMysqlFieldDefinition); !ok {
 			panic(fmt.Sprintf("The field '%s' is not derivable and not implements IMysqlFieldDefinition", field.GetId()))
 		}
+		if err := ValidateIdentifier(field.GetId()); err != nil {
+			panic(fmt.Sprintf("Invalid field id for the model '%s': %s", m.GetId(), err.Error()))
+		}
 	}
 
 	m.BaseModel.AddField(field)
 }
 
+// WriteCreateSQL writes the CREATE TABLE statement, the statements of the shard tables of the sharded model
+// are separated by semicolons
 func (m *BaseModel) WriteCreateSQL(sqlBuf *SqlBuffer) {
+	for i, table := range m.tables() {
+		if i > 0 {
+			sqlBuf.WriteString(";\n")
+		}
+		m.writeCreateTableSQL(sqlBuf, table)
+	}
+}
+
+// writeCreateTableSQL writes the CREATE TABLE statement of the table of the model
+func (m *BaseModel) writeCreateTableSQL(sqlBuf *SqlBuffer, table string) {
 	sqlBuf.WriteString("CREATE TABLE ")
-	sqlBuf.WriteIdentifier(m.GetId())
+	sqlBuf.WriteString(quoteTableName(m, table))
 	sqlBuf.WriteString(" (")
 
 	first := true
@@ -97,19 +372,15 @@ func (m *BaseModel) WriteCreateSQL(sqlBuf *SqlBuffer) {
 			sqlBuf.WriteString("UNIQUE ")
 		}
 		sqlBuf.WriteString("INDEX ")
+		sqlBuf.WriteIdentifier(m.indexName(index))
+		sqlBuf.WriteByte('(')
+		sqlBuf.WriteIdentifiersList(index.FieldNames)
+		sqlBuf.WriteByte(')')
+	}
 
-		indexNameArr := []string{}
-		if index.Unique {
-			indexNameArr = append(indexNameArr, "uniq")
-		}
-		indexNameArr = append(indexNameArr, m.GetId(), "")
-		indexNameArr = append(indexNameArr, index.FieldNames...)
-		indexName := strings.Join(indexNameArr, "_")
-		if len(indexName) > 64 {
-			indexName = indexName[0:64]
-		}
-
-		sqlBuf.WriteIdentifier(indexName)
+	for _, index := range m.fullTextIndexes {
+		sqlBuf.WriteString(",FULLTEXT INDEX ")
+		sqlBuf.WriteIdentifier(m.fullTextIndexName(index))
 		sqlBuf.WriteByte('(')
 		sqlBuf.WriteIdentifiersList(index.FieldNames)
 		sqlBuf.WriteByte(')')
@@ -123,7 +394,12 @@ func (m *BaseModel) WriteCreateSQL(sqlBuf *SqlBuffer) {
 
 		if relation.JunctionModel == nil {
 			sqlBuf.WriteString(",FOREIGN KEY ")
-			fkNameArr := []string{"fk", m.GetId(), ""}
+			// The names of the foreign keys are unique in the database
+			fkTable := m.GetId()
+			if m.shard.Count > 0 {
+				fkTable = table
+			}
+			fkNameArr := []string{"fk", fkTable, ""}
 			fkNameArr = append(fkNameArr, relation.LocalFieldsNames...)
 			fkNameArr = append(fkNameArr, "_", relation.ExtModel.GetId(), "")
 			fkNameArr = append(fkNameArr, relation.FkFieldsNames...)
@@ -135,7 +411,7 @@ func (m *BaseModel) WriteCreateSQL(sqlBuf *SqlBuffer) {
 			sqlBuf.WriteByte('(')
 			sqlBuf.WriteIdentifiersList(relation.LocalFieldsNames)
 			sqlBuf.WriteString(")REFERENCES ")
-			sqlBuf.WriteIdentifier(relation.ExtModel.GetId())
+			sqlBuf.WriteTable(relation.ExtModel)
 			sqlBuf.WriteByte('(')
 			sqlBuf.WriteIdentifiersList(relation.FkFieldsNames)
 			sqlBuf.WriteString(")ON UPDATE RESTRICT ON DELETE RESTRICT")
@@ -0,0 +1,189 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// DefaultBatchChunkSize is the default number of the rows written by one chunk of AddBatch and EditBatch
+const DefaultBatchChunkSize = 1000
+
+// BatchOpts are the options of AddBatch and EditBatch
+type BatchOpts struct {
+	// AddOptions are the options of the inserts of AddBatch
+	model.AddOptions
+	// ChunkSize is the number of the rows written by one statement of AddBatch or one transaction of EditBatch,
+	// DefaultBatchChunkSize if it is zero
+	ChunkSize int
+	// BestEffort writes the chunks in their own transactions, or savepoints in the transaction of the context,
+	// and writes the rows of the failed chunks by the halves of the chunk down to the single rows, so all
+	// the rows but the failed ones are written
+	BestEffort bool
+}
+
+// BatchRowError is the error of the row of the batch, Row is its index
+type BatchRowError struct {
+	Row int
+	Err error
+}
+
+// BatchResult is the result of the batch, Succeeded are the indexes of the written rows in their order.
+// Ids are the primary keys of the rows inserted by AddBatch in the order of Succeeded.
+type BatchResult struct {
+	Succeeded []int
+	Failed    []BatchRowError
+	Ids       *model.Data
+}
+
+// BatchError is the error of the chunk of the batch, FirstRow and LastRow are the indexes of its first and last rows.
+// The best effort batch fails with it if the error is not caused by the rows, e.g. the connection is lost.
+type BatchError struct {
+	FirstRow int
+	LastRow  int
+	Err      error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("the batch rows %d-%d: %s", e.FirstRow, e.LastRow, e.Err.Error())
+}
+
+func (e *BatchError) Unwrap() error { return e.Err }
+
+// AddBatch inserts the rows by the chunks. Without BestEffort all the chunks are inserted in one transaction
+// and the first failed chunk fails the batch with BatchError, the result is nil then. With BestEffort the rows
+// failed by the server are reported in BatchResult.Failed with their classified errors, see Error. The result
+// has the rows written before the error is returned.
+func (s *MySQL) AddBatch(ctx context.Context, m model.IModel, data *model.Data, opts BatchOpts) (*BatchResult, error) {
+	return s.writeBatch(ctx, m.GetPKFieldsNames(), data.Len(), opts, func(ctx context.Context, from, to int) (*model.Data, error) {
+		return s.Add(ctx, m, model.NewData(data.Fields(), data.Data()[from:to]), opts.AddOptions)
+	})
+}
+
+// EditBatch sets the new values of the rows with the primary keys by the chunks, newValues[i] are the values
+// of the row keys[i], see GetByPK for the keys format and AddBatch for the options
+func (s *MySQL) EditBatch(ctx context.Context, m model.IModel, keys []interface{}, newValues []map[string]interface{}, opts BatchOpts) (*BatchResult, error) {
+	if len(keys) != len(newValues) {
+		return nil, qerror.Errorf("%d keys and %d new values of the batch of model '%s'", len(keys), len(newValues), m.GetId())
+	}
+
+	return s.writeBatch(ctx, nil, len(keys), opts, func(ctx context.Context, from, to int) (*model.Data, error) {
+		for i := from; i < to; i++ {
+			if err := s.EditByPK(ctx, m, keys[i], newValues[i]); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+}
+
+// writeBatch writes n rows by the chunks of [from, to) rows with write, the written rows and their primary keys
+// returned by write are reported in the result
+func (s *MySQL) writeBatch(ctx context.Context, pkNames []string, n int, opts BatchOpts, write func(ctx context.Context, from, to int) (*model.Data, error)) (*BatchResult, error) {
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultBatchChunkSize
+	}
+
+	res := &BatchResult{}
+	if pkNames != nil {
+		res.Ids = model.NewEmptyData(pkNames)
+	}
+	written := func(from, to int, ids *model.Data) error {
+		for i := from; i < to; i++ {
+			res.Succeeded = append(res.Succeeded, i)
+		}
+		if ids == nil {
+			return nil
+		}
+		for _, row := range ids.Data() {
+			if err := res.Ids.Add(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !opts.BestEffort {
+		err := s.DoInTransaction(ctx, func(ctx context.Context) error {
+			for from := 0; from < n; from += chunkSize {
+				to := from + chunkSize
+				if to > n {
+					to = n
+				}
+				ids, err := write(ctx, from, to)
+				if err != nil {
+					return &BatchError{FirstRow: from, LastRow: to - 1, Err: err}
+				}
+				if err := written(from, to, ids); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return res, nil
+	}
+
+	var writeChunk func(from, to int) error
+	writeChunk = func(from, to int) error {
+		var ids *model.Data
+		err := s.DoInTransaction(ctx, func(ctx context.Context) error {
+			var err error
+			ids, err = write(ctx, from, to)
+			return err
+		})
+		switch {
+		case err == nil:
+			return written(from, to, ids)
+		case !isRowError(err):
+			return &BatchError{FirstRow: from, LastRow: to - 1, Err: err}
+		case to-from == 1:
+			res.Failed = append(res.Failed, BatchRowError{Row: from, Err: err})
+			return nil
+		}
+
+		// The rows of the failed chunk are written by the halves
+		mid := from + (to-from)/2
+		if err := writeChunk(from, mid); err != nil {
+			return err
+		}
+		return writeChunk(mid, to)
+	}
+
+	for from := 0; from < n; from += chunkSize {
+		to := from + chunkSize
+		if to > n {
+			to = n
+		}
+		if err := writeChunk(from, to); err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// isRowError reports whether the statement may have failed because of its rows, the deadlocks and the lock wait
+// timeouts are not caused by the rows and the deadlock rolls back the whole transaction
+func isRowError(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+
+	return isPermanentError(err) ||
+		errors.As(err, &mysqlErr) && !errors.Is(err, ErrDeadlock) && !errors.Is(err, ErrLockWaitTimeout)
+}
+
+func (m *BaseModel) AddBatch(ctx context.Context, data *model.Data, opts BatchOpts) (*BatchResult, error) {
+	return m.db.AddBatch(ctx, m, data, opts)
+}
+
+func (m *BaseModel) EditBatch(ctx context.Context, keys []interface{}, newValues []map[string]interface{}, opts BatchOpts) (*BatchResult, error) {
+	return m.db.EditBatch(ctx, m, keys, newValues, opts)
+}
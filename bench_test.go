@@ -0,0 +1,162 @@
+package mysql_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/go-qbit/model"
+
+	mysql "github.com/go-qbit/storage-mysql"
+	"github.com/go-qbit/storage-mysql/test"
+)
+
+func BenchmarkMySQL_Select(b *testing.B) {
+	defer func(driverName string) { mysql.SqlDriver = driverName }(mysql.SqlDriver)
+	mysql.SqlDriver = "mysql_fake"
+
+	storage := mysql.NewMySQL(mysql.PoolConfig{})
+	user := test.NewUser(storage)
+	if err := storage.Connect(""); err != nil {
+		b.Fatal(err)
+	}
+	defer storage.Disconnect()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		data, err := storage.Query(context.Background(), user, []string{"id", "name", "lastname"}, model.GetAllOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if data.Len() != fakeRowsCount {
+			b.Fatalf("%d rows", data.Len())
+		}
+	}
+}
+
+func BenchmarkMySQL_GetAllMaps(b *testing.B) {
+	defer func(driverName string) { mysql.SqlDriver = driverName }(mysql.SqlDriver)
+	mysql.SqlDriver = "mysql_fake"
+
+	storage := mysql.NewMySQL(mysql.PoolConfig{})
+	user := test.NewUser(storage)
+	if err := storage.Connect(""); err != nil {
+		b.Fatal(err)
+	}
+	defer storage.Disconnect()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		data, err := user.GetAll(context.Background(), []string{"id", "name", "lastname"}, model.GetAllOptions{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if maps := data.Maps(); len(maps) != fakeRowsCount {
+			b.Fatalf("%d rows", len(maps))
+		}
+	}
+}
+
+func BenchmarkMySQL_GetAllColumnar(b *testing.B) {
+	defer func(driverName string) { mysql.SqlDriver = driverName }(mysql.SqlDriver)
+	mysql.SqlDriver = "mysql_fake"
+
+	storage := mysql.NewMySQL(mysql.PoolConfig{})
+	user := test.NewUser(storage)
+	if err := storage.Connect(""); err != nil {
+		b.Fatal(err)
+	}
+	defer storage.Disconnect()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		columnar, err := user.GetAllColumnar(context.Background(), []string{"id", "name", "lastname"}, nil, mysql.ColumnarOpts{
+			SizeHint: fakeRowsCount,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if columnar.Len != fakeRowsCount {
+			b.Fatalf("%d rows", columnar.Len)
+		}
+	}
+}
+
+// BenchmarkMySQL_GetAllDerivable compares the per row derivable field with the batch one looking up
+// the values of the whole result set at once
+func BenchmarkMySQL_GetAllDerivable(b *testing.B) {
+	defer func(driverName string) { mysql.SqlDriver = driverName }(mysql.SqlDriver)
+	mysql.SqlDriver = "mysql_fake"
+
+	storage := mysql.NewMySQL(mysql.PoolConfig{})
+	if err := storage.Connect(""); err != nil {
+		b.Fatal(err)
+	}
+	defer storage.Disconnect()
+
+	// lookup resolves the names as a dictionary query would, every call has the same overhead
+	var lookupMtx sync.Mutex
+	dictionary := map[string]string{"Name": "Resolved name"}
+	lookup := func(names []string) map[string]string {
+		lookupMtx.Lock()
+		defer lookupMtx.Unlock()
+
+		res := make(map[string]string, len(dictionary))
+		for _, name := range names {
+			res[name] = dictionary[name]
+		}
+		return res
+	}
+
+	get := func(ctx context.Context, row map[string]interface{}) (interface{}, error) {
+		name := row["name"].(string)
+		return lookup([]string{name})[name], nil
+	}
+	getBatch := func(ctx context.Context, rows []map[string]interface{}) ([]interface{}, error) {
+		names := make([]string, len(rows))
+		for i, row := range rows {
+			names[i] = row["name"].(string)
+		}
+		resolved := lookup(names)
+
+		res := make([]interface{}, len(rows))
+		for i, name := range names {
+			res[i] = resolved[name]
+		}
+		return res, nil
+	}
+
+	for _, c := range []struct {
+		name  string
+		field model.IFieldDefinition
+	}{
+		{"PerRow", &model.DerivableField{Id: "resolved", DependsOn: []string{"name"}, Get: get}},
+		{"Batch", &mysql.BatchDerivableField{Id: "resolved", DependsOn: []string{"name"}, GetBatch: getBatch}},
+	} {
+		m := mysql.NewBaseModel(storage, "person_"+c.name, []mysql.IMysqlFieldDefinition{
+			&mysql.UintField{Id: "id", NotNull: true},
+			&mysql.VarCharField{Id: "name", Length: 32, NotNull: true},
+			&mysql.VarCharField{Id: "lastname", Length: 32, NotNull: true},
+		}, []model.IFieldDefinition{c.field}, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				data, err := m.GetAll(context.Background(), []string{"id", "name", "lastname", "resolved"}, model.GetAllOptions{})
+				if err != nil {
+					b.Fatal(err)
+				}
+				if data.Len() != fakeRowsCount {
+					b.Fatalf("%d rows", data.Len())
+				}
+			}
+		})
+	}
+}
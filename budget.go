@@ -0,0 +1,105 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const ctx_query_budget_key = "MYSQL_QUERY_BUDGET"
+
+// ErrQueryBudgetExceeded is matched by the errors of the statements rejected by the query budget, see QueryBudgetError
+var ErrQueryBudgetExceeded = errors.New("query budget exceeded")
+
+// QueryBudgetError is the error of the statement rejected by the query budget of the context. Statements is
+// the number of the statements run under the budget including the rejected one, Duration is their total time.
+type QueryBudgetError struct {
+	Statements    uint64
+	Duration      time.Duration
+	MaxStatements uint64
+	MaxDuration   time.Duration
+}
+
+func (e *QueryBudgetError) Error() string {
+	return fmt.Sprintf("%s: %d statements of %d, %s of %s", ErrQueryBudgetExceeded, e.Statements, e.MaxStatements,
+		e.Duration, e.MaxDuration)
+}
+
+func (e *QueryBudgetError) Is(target error) bool { return target == ErrQueryBudgetExceeded }
+
+// QueryBudgetHandler is called once per budget when the first statement is rejected. It is called by the goroutine
+// of the rejected statement, so debug.Stack returns the offending call stack.
+type QueryBudgetHandler func(ctx context.Context, err *QueryBudgetError)
+
+type queryBudget struct {
+	statements    uint64
+	duration      int64
+	exceeded      int32
+	maxStatements uint64
+	maxDuration   time.Duration
+}
+
+// SetQueryBudgetHandler sets the handler of the exceeded query budgets, it must be called before the storage is used
+func (s *MySQL) SetQueryBudgetHandler(handler QueryBudgetHandler) {
+	s.queryBudgetHandler = handler
+}
+
+// WithQueryBudget returns the context the statements run with which are limited by the number and the total
+// execution time, 0 disables the limit. The statements after the limit is exceeded fail with QueryBudgetError
+// without being sent to the server. The budget is shared by the goroutines and the transactions started
+// with the context, the transaction control statements are not limited by it.
+func (s *MySQL) WithQueryBudget(ctx context.Context, maxStatements uint64, maxTotalDuration time.Duration) context.Context {
+	return context.WithValue(ctx, ctx_query_budget_key, &queryBudget{maxStatements: maxStatements, maxDuration: maxTotalDuration})
+}
+
+// QueryBudgetUsage returns the number of the statements and their total execution time under the budget
+// of the context, zeros if the context has no budget
+func QueryBudgetUsage(ctx context.Context) (uint64, time.Duration) {
+	b := queryBudgetOf(ctx)
+	if b == nil {
+		return 0, 0
+	}
+
+	return atomic.LoadUint64(&b.statements), time.Duration(atomic.LoadInt64(&b.duration))
+}
+
+func queryBudgetOf(ctx context.Context) *queryBudget {
+	b, _ := ctx.Value(ctx_query_budget_key).(*queryBudget)
+
+	return b
+}
+
+// checkQueryBudget counts the statement in the budget of the context and returns the error if the budget is exceeded
+func (s *MySQL) checkQueryBudget(ctx context.Context) error {
+	b := queryBudgetOf(ctx)
+	if b == nil {
+		return nil
+	}
+
+	statements := atomic.AddUint64(&b.statements, 1)
+	duration := time.Duration(atomic.LoadInt64(&b.duration))
+	if (b.maxStatements == 0 || statements <= b.maxStatements) && (b.maxDuration <= 0 || duration < b.maxDuration) {
+		return nil
+	}
+
+	err := &QueryBudgetError{
+		Statements:    statements,
+		Duration:      duration,
+		MaxStatements: b.maxStatements,
+		MaxDuration:   b.maxDuration,
+	}
+	if s.queryBudgetHandler != nil && atomic.CompareAndSwapInt32(&b.exceeded, 0, 1) {
+		s.queryBudgetHandler(ctx, err)
+	}
+
+	return err
+}
+
+// spendQueryBudget adds the execution time of the statement to the budget of the context
+func spendQueryBudget(ctx context.Context, duration time.Duration) {
+	if b := queryBudgetOf(ctx); b != nil {
+		atomic.AddInt64(&b.duration, int64(duration))
+	}
+}
@@ -0,0 +1,190 @@
+package mysql
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-qbit/model"
+)
+
+// Cache is the storage of the query results, see BaseModel.SetCache
+type Cache interface {
+	Get(key string) (interface{}, bool)
+	// Set stores the value for ttl, 0 means no expiration
+	Set(key string, value interface{}, ttl time.Duration)
+	Delete(key string)
+}
+
+type cachedModel interface {
+	GetCache() (Cache, time.Duration)
+}
+
+// cacheVersion is the source of the unique versions of the models cache namespaces
+var cacheVersion = uint64(time.Now().UnixNano())
+
+// selectCache returns the cache and the key of the query results or nil if the query must not be cached.
+// The queries in transactions are not cached as the transaction can see other rows.
+func (s *MySQL) selectCache(ctx context.Context, m model.IModel, sqlBuf *SqlBuffer, options SelectOptions) (Cache, time.Duration, string) {
+	cache, ttl := s.modelCache(m.GetId())
+	if cache == nil || ctx.Value(s.transactionKey()) != nil || options.ForUpdate || options.RowsWoLimit != nil ||
+		len(options.Parents) > 0 || len(options.Children) > 0 {
+		return nil, 0, ""
+	}
+
+	hash := sha1.New()
+	hash.Write([]byte(sqlBuf.GetSQL()))
+	for _, arg := range sqlBuf.GetArgs() {
+		arg = unwrapSensitive(arg)
+		fmt.Fprintf(hash, "\x00%T:%v", arg, derefValue(arg))
+	}
+
+	return cache, ttl, cacheNamespace(cache, m.GetId()) + hex.EncodeToString(hash.Sum(nil))
+}
+
+// cacheNamespace returns the key prefix of the model results, the model writes change it
+func cacheNamespace(cache Cache, modelId string) string {
+	versionKey := "mysql:" + modelId + ":version"
+
+	version, ok := cache.Get(versionKey)
+	if !ok {
+		version = strconv.FormatUint(atomic.AddUint64(&cacheVersion, 1), 36)
+		cache.Set(versionKey, version, 0)
+	}
+
+	return "mysql:" + modelId + ":" + version.(string) + ":"
+}
+
+// modelCache returns the cache of the registered model, the model passed to the storage by model.BaseModel
+// is not the registered one
+func (s *MySQL) modelCache(modelId string) (Cache, time.Duration) {
+	s.modelsMtx.RLock()
+	m := s.models[modelId]
+	s.modelsMtx.RUnlock()
+
+	if cm, ok := m.(cachedModel); ok {
+		return cm.GetCache()
+	}
+
+	return nil, 0
+}
+
+// invalidateCache changes the version of the model cache namespace, so the cached results are not used
+func (s *MySQL) invalidateCache(modelId string) {
+	if cache, _ := s.modelCache(modelId); cache != nil {
+		cache.Set("mysql:"+modelId+":version", strconv.FormatUint(atomic.AddUint64(&cacheVersion, 1), 36), 0)
+	}
+}
+
+func cloneData(data *model.Data) *model.Data {
+	rows := make([][]interface{}, data.Len())
+	for i, row := range data.Data() {
+		rows[i] = append([]interface{}(nil), row...)
+	}
+
+	return model.NewData(append([]string(nil), data.Fields()...), rows)
+}
+
+// LRUCache is the in-process Cache keeping at most size recently used values
+type LRUCache struct {
+	size  int
+	mtx   sync.Mutex
+	items map[string]*list.Element
+	order *list.List
+	clock Clock
+}
+
+type lruItem struct {
+	key     string
+	value   interface{}
+	expires time.Time
+}
+
+func NewLRUCache(size int) *LRUCache {
+	return &LRUCache{
+		size:  size,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// SetClock sets the clock the expiration of the values is checked with, it is SystemClock by default.
+// It must be called before the cache is used.
+func (c *LRUCache) SetClock(clock Clock) {
+	c.clock = clock
+}
+
+func (c *LRUCache) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+
+	return c.clock.Now()
+}
+
+func (c *LRUCache) Get(key string) (interface{}, bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	el, exists := c.items[key]
+	if !exists {
+		return nil, false
+	}
+
+	item := el.Value.(*lruItem)
+	if !item.expires.IsZero() && c.now().After(item.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+
+	c.order.MoveToFront(el)
+
+	return item.value, true
+}
+
+func (c *LRUCache) Set(key string, value interface{}, ttl time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	item := &lruItem{key: key, value: value}
+	if ttl > 0 {
+		item.expires = c.now().Add(ttl)
+	}
+
+	if el, exists := c.items[key]; exists {
+		el.Value = item
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(item)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem).key)
+	}
+}
+
+func (c *LRUCache) Delete(key string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if el, exists := c.items[key]; exists {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+}
+
+func (c *LRUCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,287 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-qbit/model"
+)
+
+const ctx_callback_depth_key = "MYSQL_CALLBACK_DEPTH"
+
+// DefaultMaxCallbackDepth is the number of the nested writes of the model made by its own callbacks allowed
+// by default, see SetMaxCallbackDepth
+const DefaultMaxCallbackDepth = 3
+
+// ErrCallbackRecursion is matched by the errors of the writes of the model made by its callbacks deeper
+// than the max depth, see SetMaxCallbackDepth
+var ErrCallbackRecursion = errors.New("write callback recursion")
+
+// WriteCallback is the row-level callback of the writes of the model, see BaseModel.AddWriteCallback.
+// It implements one or more of the callback interfaces below, the batch variant of the callback is called
+// instead of the row one if it is implemented.
+type WriteCallback interface{}
+
+// BeforeAddCallback receives the values of the row before the insert by the fields names, it may change them.
+// The error vetoes the insert.
+type BeforeAddCallback interface {
+	BeforeAdd(ctx context.Context, row map[string]interface{}) error
+}
+
+// BeforeAddBatchCallback receives all the rows of the insert, see BeforeAddCallback
+type BeforeAddBatchCallback interface {
+	BeforeAddBatch(ctx context.Context, rows []map[string]interface{}) error
+}
+
+// BeforeEditCallback receives the new values of the update by the fields names, it may change them.
+// The error vetoes the update.
+type BeforeEditCallback interface {
+	BeforeEdit(ctx context.Context, newValues map[string]interface{}) error
+}
+
+// AfterAddCallback receives the primary key and the values of the inserted row
+type AfterAddCallback interface {
+	AfterAdd(ctx context.Context, key, row map[string]interface{}) error
+}
+
+// AfterAddBatchCallback receives the primary keys and the values of all the inserted rows
+type AfterAddBatchCallback interface {
+	AfterAddBatch(ctx context.Context, keys, rows []map[string]interface{}) error
+}
+
+// AfterEditCallback receives the primary key of the updated row, the key the row has before the update,
+// and the new values except for the expressions
+type AfterEditCallback interface {
+	AfterEdit(ctx context.Context, key, newValues map[string]interface{}) error
+}
+
+// AfterEditBatchCallback receives the primary keys of all the updated rows, see AfterEditCallback
+type AfterEditBatchCallback interface {
+	AfterEditBatch(ctx context.Context, keys []map[string]interface{}, newValues map[string]interface{}) error
+}
+
+// AfterDeleteCallback receives the primary key of the deleted row
+type AfterDeleteCallback interface {
+	AfterDelete(ctx context.Context, key map[string]interface{}) error
+}
+
+// AfterDeleteBatchCallback receives the primary keys of all the deleted rows
+type AfterDeleteBatchCallback interface {
+	AfterDeleteBatch(ctx context.Context, keys []map[string]interface{}) error
+}
+
+// AddWriteCallback adds the callback of the writes of the model by Add, Edit, Delete and the operations built
+// on them whichever service makes them. The callbacks are called in the order of the addition in the transaction
+// of the write, it is started for the write if the context has none, and their errors roll the write back.
+// The primary keys of the edited and deleted rows are selected FOR UPDATE before the write for the after callbacks,
+// the after callbacks are not called in the dry run. It must be called before the model is used.
+func (m *BaseModel) AddWriteCallback(callback WriteCallback) {
+	m.writeCallbacks = append(m.writeCallbacks, callback)
+}
+
+func (m *BaseModel) WriteCallbacks() []WriteCallback {
+	return m.writeCallbacks
+}
+
+// SetMaxCallbackDepth sets the number of the nested writes of the model made by its own callbacks, e.g. the update
+// of the model by its AfterEdit, the deeper write fails with ErrCallbackRecursion. It is DefaultMaxCallbackDepth
+// if it is not positive. It must be called before the storage is used.
+func (s *MySQL) SetMaxCallbackDepth(depth int) {
+	s.maxCallbackDepth = depth
+}
+
+// writeCallbacks returns the callbacks of the registered model
+func (s *MySQL) writeCallbacks(m model.IModel) []WriteCallback {
+	s.modelsMtx.RLock()
+	registered := s.models[m.GetId()]
+	s.modelsMtx.RUnlock()
+
+	if dbModel, ok := registered.(interface{ WriteCallbacks() []WriteCallback }); ok {
+		return dbModel.WriteCallbacks()
+	}
+
+	return nil
+}
+
+// callbacksContext returns the context the callbacks of the write of the model are called with, it fails
+// if the write is made by the callbacks of the model deeper than the max depth
+func (s *MySQL) callbacksContext(ctx context.Context, m model.IModel) (context.Context, error) {
+	maxDepth := s.maxCallbackDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxCallbackDepth
+	}
+
+	depths, _ := ctx.Value(ctx_callback_depth_key).(map[string]int)
+	if depth := depths[m.GetId()]; depth > maxDepth {
+		return nil, fmt.Errorf("%w: model '%s' is written by its callbacks %d levels deep", ErrCallbackRecursion, m.GetId(), depth)
+	}
+
+	res := make(map[string]int, len(depths)+1)
+	for id, depth := range depths {
+		res[id] = depth
+	}
+	res[m.GetId()]++
+
+	return context.WithValue(ctx, ctx_callback_depth_key, res), nil
+}
+
+// hasAfterCallbacks reports whether the primary keys of the written rows are needed by the callbacks
+func hasAfterCallbacks(ctx context.Context, callbacks []WriteCallback, op string) bool {
+	if dryRunOf(ctx) != nil {
+		return false
+	}
+
+	for _, callback := range callbacks {
+		switch op {
+		case "insert":
+			_, row := callback.(AfterAddCallback)
+			_, batch := callback.(AfterAddBatchCallback)
+			if row || batch {
+				return true
+			}
+		case "update":
+			_, row := callback.(AfterEditCallback)
+			_, batch := callback.(AfterEditBatchCallback)
+			if row || batch {
+				return true
+			}
+		case "delete":
+			_, row := callback.(AfterDeleteCallback)
+			_, batch := callback.(AfterDeleteBatchCallback)
+			if row || batch {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// beforeAdd passes the rows to the callbacks and returns the rows they changed, the fields added by the callbacks
+// follow the fields of the data in the sorted order
+func beforeAdd(ctx context.Context, callbacks []WriteCallback, data *model.Data) (*model.Data, error) {
+	var rows []map[string]interface{}
+	for _, callback := range callbacks {
+		batch, isBatch := callback.(BeforeAddBatchCallback)
+		row, isRow := callback.(BeforeAddCallback)
+		if !isBatch && !isRow {
+			continue
+		}
+		if rows == nil {
+			rows = data.Maps()
+		}
+
+		if isBatch {
+			if err := batch.BeforeAddBatch(ctx, rows); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, values := range rows {
+			if err := row.BeforeAdd(ctx, values); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if rows == nil {
+		return data, nil
+	}
+
+	fields := append([]string(nil), data.Fields()...)
+	var added []string
+	for _, values := range rows {
+		for name := range values {
+			if data.FieldNum(name) < 0 && !containsString(added, name) {
+				added = append(added, name)
+			}
+		}
+	}
+	sort.Strings(added)
+	fields = append(fields, added...)
+
+	res := make([][]interface{}, len(rows))
+	for i, values := range rows {
+		res[i] = make([]interface{}, len(fields))
+		for j, name := range fields {
+			res[i][j] = values[name]
+		}
+	}
+
+	return model.NewData(fields, res), nil
+}
+
+// beforeEdit passes the copy of the new values to the callbacks and returns it
+func beforeEdit(ctx context.Context, callbacks []WriteCallback, newValues map[string]interface{}) (map[string]interface{}, error) {
+	res, copied := newValues, false
+	for _, callback := range callbacks {
+		callback, ok := callback.(BeforeEditCallback)
+		if !ok {
+			continue
+		}
+		if !copied {
+			res, copied = make(map[string]interface{}, len(newValues)), true
+			for name, value := range newValues {
+				res[name] = value
+			}
+		}
+		if err := callback.BeforeEdit(ctx, res); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// afterWrite passes the primary keys of the rows written by the operation to the after callbacks, rowOf returns
+// the values of the inserted row or the new values of the update
+func afterWrite(ctx context.Context, callbacks []WriteCallback, op string, pks *model.Data, rowOf func(i int) map[string]interface{}) error {
+	if pks == nil || pks.Len() == 0 || dryRunOf(ctx) != nil {
+		return nil
+	}
+
+	keys := make([]map[string]interface{}, pks.Len())
+	rows := make([]map[string]interface{}, pks.Len())
+	for i, row := range pks.Data() {
+		keys[i] = changedFields(pks.Fields(), row)
+		if rowOf != nil {
+			rows[i] = rowOf(i)
+		}
+	}
+
+	for _, callback := range callbacks {
+		var err error
+		switch op {
+		case "insert":
+			if batch, ok := callback.(AfterAddBatchCallback); ok {
+				err = batch.AfterAddBatch(ctx, keys, rows)
+			} else if row, ok := callback.(AfterAddCallback); ok {
+				for i := 0; i < len(keys) && err == nil; i++ {
+					err = row.AfterAdd(ctx, keys[i], rows[i])
+				}
+			}
+		case "update":
+			if batch, ok := callback.(AfterEditBatchCallback); ok {
+				err = batch.AfterEditBatch(ctx, keys, rows[0])
+			} else if row, ok := callback.(AfterEditCallback); ok {
+				for i := 0; i < len(keys) && err == nil; i++ {
+					err = row.AfterEdit(ctx, keys[i], rows[i])
+				}
+			}
+		case "delete":
+			if batch, ok := callback.(AfterDeleteBatchCallback); ok {
+				err = batch.AfterDeleteBatch(ctx, keys)
+			} else if row, ok := callback.(AfterDeleteCallback); ok {
+				for i := 0; i < len(keys) && err == nil; i++ {
+					err = row.AfterDelete(ctx, keys[i])
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+type DeleteAction int
+
+const (
+	// Cascade deletes the dependent rows, their own dependencies are processed first
+	Cascade DeleteAction = iota
+	// SetNull sets the foreign key of the dependent rows to NULL
+	SetNull
+	// Restrict aborts the delete with RestrictError if there are dependent rows
+	Restrict
+)
+
+// DeleteDependency is the model referencing the deleted model, see AddDeleteDependency
+type DeleteDependency struct {
+	Model model.IModel
+	// FkFieldName is the field of the dependent model referencing the primary key of the deleted model
+	FkFieldName string
+	Action      DeleteAction
+}
+
+// RestrictError is returned by DeleteCascade if the rows are referenced by a Restrict dependency
+type RestrictError struct {
+	Model string
+	Count uint64
+}
+
+func (e *RestrictError) Error() string {
+	return fmt.Sprintf("Cannot delete, there are %d dependent rows in model '%s'", e.Count, e.Model)
+}
+
+// AddDeleteDependency declares the dependency processed by DeleteCascade before the rows of the model are deleted.
+// The dependencies must not form a cycle.
+func (s *MySQL) AddDeleteDependency(m model.IModel, dep DeleteDependency) error {
+	if len(m.GetPKFieldsNames()) != 1 {
+		return qerror.Errorf("The model '%s' must have a single field primary key", m.GetId())
+	}
+
+	field := dep.Model.GetFieldDefinition(dep.FkFieldName)
+	if field == nil || field.IsDerivable() {
+		return qerror.Errorf("Unknown field '%s' in model '%s'", dep.FkFieldName, dep.Model.GetId())
+	}
+	if dep.Action == SetNull && field.IsRequired() {
+		return qerror.Errorf("The field '%s' in model '%s' cannot be set to NULL", dep.FkFieldName, dep.Model.GetId())
+	}
+
+	s.modelsMtx.Lock()
+	defer s.modelsMtx.Unlock()
+
+	if path := s.deleteDependencyPath(dep.Model.GetId(), m.GetId()); path != nil {
+		return qerror.Errorf("The delete dependency '%s' -> '%s' makes a cycle %v", m.GetId(), dep.Model.GetId(), append([]string{m.GetId()}, path...))
+	}
+
+	if s.deleteDeps == nil {
+		s.deleteDeps = make(map[string][]DeleteDependency)
+	}
+	s.deleteDeps[m.GetId()] = append(s.deleteDeps[m.GetId()], dep)
+
+	return nil
+}
+
+// deleteDependencyPath returns the models path from one model to another one by the declared dependencies or nil
+func (s *MySQL) deleteDependencyPath(from, to string) []string {
+	if from == to {
+		return []string{to}
+	}
+
+	for _, dep := range s.deleteDeps[from] {
+		if path := s.deleteDependencyPath(dep.Model.GetId(), to); path != nil {
+			return append([]string{from}, path...)
+		}
+	}
+
+	return nil
+}
+
+func (s *MySQL) getDeleteDependencies(m model.IModel) []DeleteDependency {
+	s.modelsMtx.RLock()
+	defer s.modelsMtx.RUnlock()
+
+	return s.deleteDeps[m.GetId()]
+}
+
+// DeleteCascade deletes the row with the primary key value and processes the declared dependencies depth-first
+// in one transaction. The result is the number of the deleted rows per model.
+func (s *MySQL) DeleteCascade(ctx context.Context, m model.IModel, id interface{}) (map[string]uint64, error) {
+	pk := m.GetPKFieldsNames()
+	if len(pk) != 1 {
+		return nil, qerror.Errorf("The model '%s' must have a single field primary key", m.GetId())
+	}
+
+	res := make(map[string]uint64)
+	if err := s.DoInTransaction(ctx, func(ctx context.Context) error {
+		return s.deleteCascade(ctx, m, []interface{}{id}, res)
+	}); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func (s *MySQL) deleteCascade(ctx context.Context, m model.IModel, ids []interface{}, counts map[string]uint64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	for _, dep := range s.getDeleteDependencies(m) {
+		filter := In(dep.Model.FieldExpr(dep.FkFieldName), ids)
+
+		switch dep.Action {
+		case Restrict:
+			sqlBuf := NewSqlBuffer()
+			sqlBuf.WriteString("SELECT COUNT(*) FROM ")
+			sqlBuf.WriteTable(dep.Model)
+			sqlBuf.WriteString(" WHERE ")
+			filter.GetProcessor(s.exprProcessor.ForModel(dep.Model)).(WriteFunc)(sqlBuf)
+			if err := sqlBuf.Err(); err != nil {
+				return err
+			}
+
+			rows, err := s.RawQuery(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+			if err != nil {
+				return err
+			}
+			var count uint64
+			for rows.Next() {
+				err = rows.Scan(&count)
+			}
+			if err == nil {
+				err = rows.Err()
+			}
+			rows.Close()
+			if err != nil {
+				return err
+			}
+
+			if count > 0 {
+				return &RestrictError{Model: dep.Model.GetId(), Count: count}
+			}
+
+		case SetNull:
+			if err := s.Edit(ctx, dep.Model, filter, map[string]interface{}{dep.FkFieldName: nil}); err != nil {
+				return err
+			}
+
+		case Cascade:
+			if len(s.getDeleteDependencies(dep.Model)) == 0 {
+				deleted, err := s.deleteRows(ctx, dep.Model, filter)
+				if err != nil {
+					return err
+				}
+				counts[dep.Model.GetId()] += deleted
+				continue
+			}
+
+			depPk := dep.Model.GetPKFieldsNames()[0]
+			data, err := s.Select(ctx, dep.Model, []string{depPk}, SelectOptions{
+				GetAllOptions: model.GetAllOptions{Filter: filter, ForUpdate: true},
+			})
+			if err != nil {
+				return err
+			}
+
+			depIds := make([]interface{}, 0, data.Len())
+			for _, row := range data.Data() {
+				depIds = append(depIds, derefValue(row[0]))
+			}
+
+			if err := s.deleteCascade(ctx, dep.Model, depIds, counts); err != nil {
+				return err
+			}
+
+		default:
+			return qerror.Errorf("Unknown delete action %d", dep.Action)
+		}
+	}
+
+	deleted, err := s.deleteRows(ctx, m, In(m.FieldExpr(m.GetPKFieldsNames()[0]), ids))
+	if err != nil {
+		return err
+	}
+	counts[m.GetId()] += deleted
+
+	return nil
+}
+
+// deleteRows is Delete returning the number of the deleted rows
+func (s *MySQL) deleteRows(ctx context.Context, m model.IModel, filter model.IExpression) (uint64, error) {
+	result, err := s.execDelete(ctx, m, filter)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(deleted), nil
+}
+
+// DeleteCascade deletes the row with the primary key value and its dependencies, see MySQL.DeleteCascade
+func (m *BaseModel) DeleteCascade(ctx context.Context, id interface{}) (map[string]uint64, error) {
+	return m.db.DeleteCascade(ctx, m, id)
+}
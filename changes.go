@@ -0,0 +1,213 @@
+package mysql
+
+import (
+	"context"
+
+	"github.com/go-qbit/model"
+)
+
+// Change is the row written by Add, Edit or Delete, see AddWriteObserver
+type Change struct {
+	Model string
+	// Op is insert, update or delete, the upserts are reported as insert
+	Op string
+	// PK is the primary key of the row, the update reports the key the row has before the update
+	PK map[string]interface{}
+	// Fields are the inserted values or the new values of the update except for the expressions, nil for the delete
+	Fields map[string]interface{}
+	// TxId is the id of the transaction the change is made in, it is unique within the storage
+	TxId uint64
+}
+
+// WriteObserver receives the changes after the commit, e.g. to publish them to a message broker. The changes made
+// outside a transaction are captured in the transaction started for the write. The changes of a savepoint rolled
+// back are discarded.
+type WriteObserver interface {
+	// Committed is called after the commit with the changes of the transaction in the order they are made
+	Committed(ctx context.Context, changes []Change)
+}
+
+// AddWriteObserver adds the observer. The primary keys of the edited and deleted rows are selected FOR UPDATE
+// before the write while there is an observer or the outbox, see SetOutbox.
+// It must be called before the storage is used.
+func (s *MySQL) AddWriteObserver(o WriteObserver) {
+	s.writeObservers = append(s.writeObservers, o)
+}
+
+// capturesWrite reports whether the changes of the model are captured, nothing is written in the dry run
+func (s *MySQL) capturesWrite(ctx context.Context, m model.IModel) bool {
+	if len(s.writeObservers) == 0 && s.outbox == nil || dryRunOf(ctx) != nil {
+		return false
+	}
+
+	return s.outbox == nil || m.GetId() != s.outbox.GetId()
+}
+
+// captureWrite runs the write in the transaction of the context or in a new one if the changes are captured,
+// audited, the warnings are checked or the model has the write callbacks
+func (s *MySQL) captureWrite(ctx context.Context, m model.IModel, f func(ctx context.Context) error) error {
+	if !s.capturesWrite(ctx, m) && !s.checksWarnings(ctx, m) && s.auditOf(ctx, m) == nil && len(s.writeCallbacks(m)) == 0 ||
+		ctx.Value(s.transactionKey()) != nil {
+		return f(ctx)
+	}
+
+	return s.DoInTransaction(ctx, f)
+}
+
+// changedPKs returns the primary keys of the rows matching the filter or nil if the changes are not captured
+// and the after callbacks of the operation are not set
+func (s *MySQL) changedPKs(ctx context.Context, m model.IModel, op string, filter model.IExpression) (*model.Data, error) {
+	if !s.capturesWrite(ctx, m) && !hasAfterCallbacks(ctx, s.writeCallbacks(m), op) {
+		return nil, nil
+	}
+
+	return s.Select(ctx, m, m.GetPKFieldsNames(), SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter:    filter,
+		ForUpdate: true,
+	}})
+}
+
+// recordChanges writes the changes of the rows with the primary keys to the outbox and buffers them
+// for the write observers
+func (s *MySQL) recordChanges(ctx context.Context, m model.IModel, op string, pks *model.Data, fieldsOf func(i int) map[string]interface{}) error {
+	if pks == nil || !s.capturesWrite(ctx, m) {
+		return nil
+	}
+	t, ok := ctx.Value(s.transactionKey()).(*transaction)
+	if !ok {
+		return nil
+	}
+
+	changes := make([]Change, pks.Len())
+	for i, row := range pks.Data() {
+		pk := make(map[string]interface{}, len(row))
+		for j, name := range pks.Fields() {
+			pk[name] = derefValue(row[j])
+		}
+		changes[i] = Change{Model: m.GetId(), Op: op, PK: pk, TxId: t.id}
+		if fieldsOf != nil {
+			changes[i].Fields = fieldsOf(i)
+		}
+	}
+
+	if s.outbox != nil {
+		if err := s.writeOutbox(ctx, changes); err != nil {
+			return err
+		}
+	}
+	if len(s.writeObservers) > 0 {
+		t.addChanges(changes)
+	}
+
+	return nil
+}
+
+func changedFields(names []string, row []interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(names))
+	for i, name := range names {
+		res[name] = derefValue(row[i])
+	}
+
+	return res
+}
+
+func changedValues(newValues map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(newValues))
+	for name, value := range newValues {
+		if _, ok := value.(model.IExpression); !ok {
+			res[name] = derefValue(value)
+		}
+	}
+
+	return res
+}
+
+// notifyWriteObservers passes the changes of the committed transaction to the observers
+func (s *MySQL) notifyWriteObservers(ctx context.Context, changes []Change) {
+	if len(changes) == 0 {
+		return
+	}
+
+	for _, o := range s.writeObservers {
+		o.Committed(ctx, changes)
+	}
+}
+
+// addChanges buffers the changes delivered to the write observers after the commit
+func (t *transaction) addChanges(changes []Change) {
+	t.changesMtx.Lock()
+	defer t.changesMtx.Unlock()
+
+	t.changes = append(t.changes, changes...)
+}
+
+// markChanges remembers the changes made before the savepoint
+func (t *transaction) markChanges() {
+	t.changesMtx.Lock()
+	defer t.changesMtx.Unlock()
+
+	t.changesMarks = append(t.changesMarks, len(t.changes))
+}
+
+// releaseChanges keeps the changes made after the released savepoint
+func (t *transaction) releaseChanges() {
+	t.changesMtx.Lock()
+	defer t.changesMtx.Unlock()
+
+	if len(t.changesMarks) > 0 {
+		t.changesMarks = t.changesMarks[:len(t.changesMarks)-1]
+	}
+}
+
+// discardChanges drops the changes made after the savepoint rolled back to
+func (t *transaction) discardChanges() {
+	t.changesMtx.Lock()
+	defer t.changesMtx.Unlock()
+
+	if n := len(t.changesMarks); n > 0 {
+		t.changes = t.changes[:t.changesMarks[n-1]]
+		t.changesMarks = t.changesMarks[:n-1]
+	}
+}
+
+// markNamedChanges remembers the number of the changes at the savepoint of CreateSavepoint
+func (t *transaction) markNamedChanges(name string) {
+	t.changesMtx.Lock()
+	defer t.changesMtx.Unlock()
+
+	if t.namedMarks == nil {
+		t.namedMarks = make(map[string]int)
+	}
+	t.namedMarks[name] = len(t.changes)
+}
+
+// releaseNamedChanges keeps the changes made after the released savepoint of CreateSavepoint
+func (t *transaction) releaseNamedChanges(name string) {
+	t.changesMtx.Lock()
+	defer t.changesMtx.Unlock()
+
+	delete(t.namedMarks, name)
+}
+
+// discardNamedChanges drops the changes made after the savepoint of CreateSavepoint rolled back to,
+// the marks of the nested transactions started after it are moved to it
+func (t *transaction) discardNamedChanges(name string) {
+	t.changesMtx.Lock()
+	defer t.changesMtx.Unlock()
+
+	mark := t.namedMarks[name]
+	if mark >= len(t.changes) {
+		return
+	}
+	t.changes = t.changes[:mark]
+	for i := range t.changesMarks {
+		if t.changesMarks[i] > mark {
+			t.changesMarks[i] = mark
+		}
+	}
+	for other, otherMark := range t.namedMarks {
+		if otherMark > mark {
+			t.namedMarks[other] = mark
+		}
+	}
+}
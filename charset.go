@@ -0,0 +1,201 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// UTF8Policy defines the checks of the strings written to the CHAR, VARCHAR and TEXT fields. The fields without
+// Charset are in the utf8 charset of the table, see WriteCreateSQL, the utf8 (utf8mb3) columns cannot store
+// the 4-byte characters such as emoji.
+type UTF8Policy int
+
+const (
+	// UTF8Unchecked writes the strings as is
+	UTF8Unchecked UTF8Policy = iota
+	// UTF8Reject fails the write of the invalid UTF-8 strings, of the strings longer than the field in characters
+	// and of the 4-byte characters to the utf8 columns with StringValueError
+	UTF8Reject
+	// UTF8Strip removes the 4-byte characters written to the utf8 columns, the rest is checked as by UTF8Reject
+	UTF8Strip
+	// UTF8Replace replaces the 4-byte characters written to the utf8 columns with U+FFFD, the rest is checked
+	// as by UTF8Reject
+	UTF8Replace
+)
+
+var (
+	// ErrInvalidUTF8 is matched by the errors of the strings which are not valid UTF-8, see UTF8Policy
+	ErrInvalidUTF8 = errors.New("invalid UTF-8")
+	// ErrFourByteChar is matched by the errors of the 4-byte characters written to the utf8 columns
+	ErrFourByteChar = errors.New("4-byte character")
+)
+
+// StringValueError is the error of the string written to the field, errors.Is matches it with the Kind sentinel:
+// ErrInvalidUTF8, ErrFourByteChar or ErrDataTooLong
+type StringValueError struct {
+	Kind  error
+	Field string
+}
+
+func (e *StringValueError) Error() string {
+	switch e.Kind {
+	case ErrInvalidUTF8:
+		return fmt.Sprintf("The value of the field '%s' is not valid UTF-8", e.Field)
+	case ErrFourByteChar:
+		return fmt.Sprintf("The value of the field '%s' contains the 4-byte characters the utf8 column cannot store", e.Field)
+	default:
+		return fmt.Sprintf("The value of the field '%s' is longer than the field", e.Field)
+	}
+}
+
+func (e *StringValueError) Is(target error) bool { return target == e.Kind }
+
+// isUTF8MB3 reports whether the column of the charset cannot store the 4-byte characters, the empty charset
+// is the utf8 one of the table
+func isUTF8MB3(charset string) bool {
+	switch strings.ToLower(charset) {
+	case "", "utf8", "utf8mb3":
+		return true
+	}
+
+	return false
+}
+
+// encodeUTF8 applies the policy to the string written to the field, length is the length of the field
+// in characters, 0 is unlimited
+func encodeUTF8(field string, policy UTF8Policy, charset string, length int, v interface{}) (interface{}, error) {
+	if policy == UTF8Unchecked {
+		return v, nil
+	}
+
+	switch val := v.(type) {
+	case string:
+		return checkUTF8(field, policy, charset, length, val)
+	case *string:
+		if val == nil {
+			return val, nil
+		}
+		res, err := checkUTF8(field, policy, charset, length, *val)
+		if err != nil {
+			return nil, err
+		}
+		return &res, nil
+	}
+
+	return v, nil
+}
+
+func checkUTF8(field string, policy UTF8Policy, charset string, length int, s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", &StringValueError{ErrInvalidUTF8, field}
+	}
+
+	if isUTF8MB3(charset) && hasFourByteChars(s) {
+		switch policy {
+		case UTF8Strip:
+			s = replaceFourByteChars(s, "")
+		case UTF8Replace:
+			s = replaceFourByteChars(s, string(utf8.RuneError))
+		default:
+			return "", &StringValueError{ErrFourByteChar, field}
+		}
+	}
+
+	if length > 0 && utf8.RuneCountInString(s) > length {
+		return "", &StringValueError{ErrDataTooLong, field}
+	}
+
+	return s, nil
+}
+
+func hasFourByteChars(s string) bool {
+	for _, r := range s {
+		if r > 0xFFFF {
+			return true
+		}
+	}
+
+	return false
+}
+
+func replaceFourByteChars(s, replacement string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if r > 0xFFFF {
+			b.WriteString(replacement)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// CharsetColumn is the text column of the model in the charset which cannot store all the characters
+type CharsetColumn struct {
+	Model   string
+	Field   string
+	Charset string
+}
+
+// CheckCharsets reports the text columns of the models in the utf8 (utf8mb3) and latin1 charsets if the charset
+// of the connection is utf8mb4, the 4-byte characters such as emoji written to them fail with
+// "Incorrect string value".
+func (s *MySQL) CheckCharsets(ctx context.Context) ([]CharsetColumn, error) {
+	ctx = ForcePrimary(ctx)
+
+	rows, err := s.RawQuery(ctx, "SELECT @@SESSION.character_set_connection")
+	if err != nil {
+		return nil, err
+	}
+	var connCharset string
+	if rows.Next() {
+		err = rows.Scan(&connCharset)
+	}
+	if closeErr := rows.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || connCharset != "utf8mb4" {
+		return nil, err
+	}
+
+	s.modelsMtx.RLock()
+	models := make([]*BaseModel, 0, len(s.models))
+	for _, m := range s.models {
+		if bm, ok := m.(*BaseModel); ok {
+			models = append(models, bm)
+		}
+	}
+	s.modelsMtx.RUnlock()
+	tables := newModelsTables(models)
+
+	where, args := tables.where("TABLE_SCHEMA")
+	rows, err = s.RawQuery(ctx, "SELECT TABLE_SCHEMA = DATABASE(), TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, CHARACTER_SET_NAME "+
+		"FROM information_schema.COLUMNS WHERE "+where+" AND CHARACTER_SET_NAME IN ('utf8', 'utf8mb3', 'latin1') "+
+		"ORDER BY TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []CharsetColumn
+	for rows.Next() {
+		var (
+			database, table, column, charset string
+			isCurrent                        bool
+		)
+		if err := rows.Scan(&isCurrent, &database, &table, &column, &charset); err != nil {
+			return nil, err
+		}
+
+		if modelId, exists := tables.modelOf(isCurrent, database, table); exists {
+			res = append(res, CharsetColumn{Model: modelId, Field: column, Charset: charset})
+		}
+	}
+
+	return res, rows.Err()
+}
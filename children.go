@@ -0,0 +1,189 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// Children describes the one-to-many child rows loaded along with the parent rows, see WithChildren
+type Children struct {
+	Model model.IModel
+	// FkFieldName is the field of the child model referencing the primary key of the parent model
+	FkFieldName string
+	FieldsNames []string
+	Filter      model.IExpression
+	OrderBy     []model.Order
+	// Limit is the maximum number of the child rows per parent, it requires MySQL 8.0 window functions
+	Limit uint64
+	// Key is the column of the parent rows with the child rows, the child model id by default
+	Key string
+}
+
+// WithChildren returns the eager-loading option for SelectOptions.Children
+func WithChildren(childModel model.IModel, fkFieldName string, fieldsNames []string, filter model.IExpression) Children {
+	return Children{Model: childModel, FkFieldName: fkFieldName, FieldsNames: fieldsNames, Filter: filter}
+}
+
+func (c *Children) key() string {
+	if c.Key != "" {
+		return c.Key
+	}
+
+	return c.Model.GetId()
+}
+
+// loadChildren adds the columns with the child rows to the parent data. The child rows are requested
+// with one IN query per IN chunk size parents, see SetInChunkSize.
+func (s *MySQL) loadChildren(ctx context.Context, m model.IModel, data *model.Data, children []Children) (*model.Data, error) {
+	pk := m.GetPKFieldsNames()
+	if len(pk) != 1 {
+		return nil, qerror.Errorf("Children can be loaded for a model with a single field primary key only, model '%s'", m.GetId())
+	}
+	pkPos := data.FieldNum(pk[0])
+	if pkPos < 0 {
+		return nil, qerror.Errorf("The primary key '%s' must be requested to load the children", pk[0])
+	}
+
+	var parentIds []interface{}
+	seen := make(map[interface{}]struct{})
+	for _, row := range data.Data() {
+		if _, exists := seen[row[pkPos]]; !exists {
+			seen[row[pkPos]] = struct{}{}
+			parentIds = append(parentIds, row[pkPos])
+		}
+	}
+
+	fields := data.Fields()
+	rows := data.Data()
+	for i := range children {
+		c := &children[i]
+
+		grouped, err := s.queryChildren(ctx, c, parentIds)
+		if err != nil {
+			return nil, err
+		}
+
+		fields = append(fields[:len(fields):len(fields)], c.key())
+		for j, row := range rows {
+			childRows := grouped[fmt.Sprint(row[pkPos])]
+			if childRows == nil {
+				childRows = []map[string]interface{}{}
+			}
+			rows[j] = append(row[:len(row):len(row)], childRows)
+		}
+	}
+
+	return model.NewData(fields, rows), nil
+}
+
+// queryChildren returns the child rows grouped by the formatted parent id
+func (s *MySQL) queryChildren(ctx context.Context, c *Children, parentIds []interface{}) (map[string][]map[string]interface{}, error) {
+	if c.Model.GetFieldDefinition(c.FkFieldName) == nil {
+		return nil, qerror.Errorf("Unknown field '%s' in model '%s'", c.FkFieldName, c.Model.GetId())
+	}
+
+	res := make(map[string][]map[string]interface{})
+
+	chunkSize := s.exprProcessor.InChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(parentIds)
+	}
+
+	for start := 0; start < len(parentIds); start += chunkSize {
+		end := start + chunkSize
+		if end > len(parentIds) {
+			end = len(parentIds)
+		}
+
+		sqlBuf := s.ScopedSqlBuffer(ctx)
+		s.WriteChildrenSQL(sqlBuf, *c, parentIds[start:end])
+		if err := sqlBuf.Err(); err != nil {
+			return nil, err
+		}
+
+		rows, err := s.RawQuery(withQuery(ctx, c.Model.GetId(), "select"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := scanData(rows, func(_ int, name string) model.IFieldDefinition { return c.Model.GetFieldDefinition(name) })
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		fkPos := data.FieldNum(c.FkFieldName)
+		for _, row := range data.Data() {
+			rowMap := make(map[string]interface{}, len(c.FieldsNames))
+			for _, name := range c.FieldsNames {
+				rowMap[name] = row[data.FieldNum(name)]
+			}
+
+			key := fmt.Sprint(derefValue(row[fkPos]))
+			res[key] = append(res[key], rowMap)
+		}
+	}
+
+	return res, nil
+}
+
+// WriteChildrenSQL writes the query of the child rows for the parents, the per parent limit is implemented
+// with the ROW_NUMBER() window function
+func (s *MySQL) WriteChildrenSQL(sqlBuf *SqlBuffer, c Children, parentIds []interface{}) {
+	fieldsNames := c.FieldsNames
+	if !containsString(fieldsNames, c.FkFieldName) {
+		fieldsNames = append(fieldsNames[:len(fieldsNames):len(fieldsNames)], c.FkFieldName)
+	}
+
+	filter := model.IExpression(In(c.Model.FieldExpr(c.FkFieldName), parentIds))
+	if c.Filter != nil {
+		filter = And(filter, c.Filter)
+	}
+
+	options := SelectOptions{GetAllOptions: model.GetAllOptions{Filter: filter}}
+	if c.Limit == 0 {
+		options.OrderBy = orderExprs(c.Model, c.OrderBy)
+		s.WriteSelectSQL(sqlBuf, c.Model, fieldsNames, options)
+		return
+	}
+
+	var over strings.Builder
+	over.WriteString("ROW_NUMBER() OVER (PARTITION BY " + QuoteIdent(c.FkFieldName))
+	for i, order := range c.OrderBy {
+		if i == 0 {
+			over.WriteString(" ORDER BY ")
+		} else {
+			over.WriteByte(',')
+		}
+		over.WriteString(QuoteIdent(order.FieldName))
+		if order.Desc {
+			over.WriteString(" DESC")
+		}
+	}
+	over.WriteByte(')')
+	options.Columns = []Column{{Alias: "_row_number", Expr: RawExpr(over.String())}}
+
+	sqlBuf.WriteString("SELECT ")
+	sqlBuf.WriteIdentifiersList(fieldsNames)
+	sqlBuf.WriteString(" FROM (")
+	s.WriteSelectSQL(sqlBuf, c.Model, fieldsNames, options)
+	sqlBuf.WriteString(") AS `_children` WHERE `_row_number`<=")
+	sqlBuf.WriteString(strconv.FormatUint(c.Limit, 10))
+	sqlBuf.WriteString(" ORDER BY ")
+	sqlBuf.WriteIdentifier(c.FkFieldName)
+	sqlBuf.WriteString(",`_row_number`")
+}
+
+func orderExprs(m model.IModel, orders []model.Order) []OrderExpr {
+	res := make([]OrderExpr, len(orders))
+	for i, order := range orders {
+		res[i] = OrderExpr{Expr: m.FieldExpr(order.FieldName), Desc: order.Desc}
+	}
+
+	return res
+}
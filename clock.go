@@ -0,0 +1,87 @@
+package mysql
+
+import (
+	"time"
+)
+
+// Clock is the source of the current time of the storage, see SetClock
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f in its own goroutine when the duration passes, stop prevents the call if it is not done yet
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+	// After sends the current time to the channel when the duration passes
+	After(d time.Duration) <-chan time.Time
+	// NewTicker sends the current time to the channel every duration until stop is called, the ticks are dropped
+	// if the channel is not read
+	NewTicker(d time.Duration) (c <-chan time.Time, stop func())
+}
+
+// SystemClock is the Clock of time.Now
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+func (SystemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+func (SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+func (SystemClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+
+	return ticker.C, ticker.Stop
+}
+
+// SetClock sets the clock of the storage, it is SystemClock by default. The clock is used for the audit timestamps,
+// the durations of the statements passed to the metrics sink, the slow queries handler and the query budget,
+// the health check interval, the replica retry interval, the session consistency window, the watchdog
+// of the max execution time, the retry backoffs, the flush intervals of the stream inserters and of the write-behind
+// buffers and the polling of the outbox readers. It must be called before the storage is used.
+func (s *MySQL) SetClock(clock Clock) {
+	s.clock = clock
+}
+
+func (s *MySQL) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+
+	return s.clock.Now()
+}
+
+func (s *MySQL) since(t time.Time) time.Duration {
+	return s.now().Sub(t)
+}
+
+func (s *MySQL) afterFunc(d time.Duration, f func()) func() bool {
+	if s.clock == nil {
+		return time.AfterFunc(d, f).Stop
+	}
+
+	return s.clock.AfterFunc(d, f)
+}
+
+func (s *MySQL) after(d time.Duration) <-chan time.Time {
+	if s.clock == nil {
+		return time.After(d)
+	}
+
+	return s.clock.After(d)
+}
+
+func (s *MySQL) newTicker(d time.Duration) (<-chan time.Time, func()) {
+	if s.clock == nil {
+		return SystemClock{}.NewTicker(d)
+	}
+
+	return s.clock.NewTicker(d)
+}
+
+// SetTestMode sets the deterministic mode of the tests, the SELECTs of WriteSelectSQL without the ordering
+// are ordered by the primary key then, so the rows are returned in the same order by every run. The SELECTs
+// with GROUP BY and the DISTINCT ones not selecting the primary key are not ordered. Use it with a fake Clock
+// to freeze the time. It must be called before the storage is used.
+func (s *MySQL) SetTestMode(enabled bool) {
+	s.testMode = enabled
+}
@@ -0,0 +1,112 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const ctx_flush_key = "MYSQL_FLUSH"
+
+// ErrClosing is returned by StartTransaction and DoInTransaction after Close is called
+var ErrClosing = errors.New("the storage is closing")
+
+// Close stops starting the new transactions and waits until the open ones are committed or rolled back,
+// then it drains the write-behind queues, closes the stream inserters and disconnects the storage. The transactions
+// inserting the rows of the queues and the inserters are still started. When the context is done the storage
+// is closed without waiting and the context error is returned.
+func (s *MySQL) Close(ctx context.Context) error {
+	s.closeMtx.Lock()
+	s.closing = true
+	txsDone := s.txsDone
+	if len(s.openTxs) > 0 && txsDone == nil {
+		txsDone = make(chan struct{})
+		s.txsDone = txsDone
+	}
+	s.closeMtx.Unlock()
+
+	var err error
+	if txsDone != nil {
+		select {
+		case <-txsDone:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	if drainErr := s.Drain(ctx); err == nil {
+		err = drainErr
+	}
+
+	s.closeMtx.Lock()
+	inserters := make([]*StreamInserter, 0, len(s.streamInserters))
+	for i := range s.streamInserters {
+		inserters = append(inserters, i)
+	}
+	s.closeMtx.Unlock()
+
+	for _, i := range inserters {
+		if closeErr := i.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if disconnectErr := s.Disconnect(); err == nil {
+		err = disconnectErr
+	}
+
+	return err
+}
+
+// flushContext returns the context of the inserts of the write-behind queues and the stream inserters,
+// their transactions are started after Close is called as Close flushes them
+func flushContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctx_flush_key, true)
+}
+
+// txStarted registers the started transaction with its start time and returns its key, it fails after Close
+// is called unless the transaction flushes the rows queued by the storage
+func (s *MySQL) txStarted(ctx context.Context) (uint64, error) {
+	s.closeMtx.Lock()
+	defer s.closeMtx.Unlock()
+
+	if flush, _ := ctx.Value(ctx_flush_key).(bool); s.closing && !flush {
+		return 0, ErrClosing
+	}
+	if s.openTxs == nil {
+		s.openTxs = make(map[uint64]time.Time)
+	}
+	s.openTxsSeq++
+	s.openTxs[s.openTxsSeq] = s.now()
+
+	return s.openTxsSeq, nil
+}
+
+// txFinished is called once by the final commit or rollback of the transaction with the key
+func (s *MySQL) txFinished(key uint64) {
+	s.closeMtx.Lock()
+	defer s.closeMtx.Unlock()
+
+	delete(s.openTxs, key)
+	if len(s.openTxs) == 0 && s.txsDone != nil {
+		close(s.txsDone)
+		s.txsDone = nil
+	}
+}
+
+func (s *MySQL) addStreamInserter(i *StreamInserter) {
+	s.closeMtx.Lock()
+	defer s.closeMtx.Unlock()
+
+	if s.streamInserters == nil {
+		s.streamInserters = make(map[*StreamInserter]struct{})
+	}
+	s.streamInserters[i] = struct{}{}
+}
+
+func (s *MySQL) removeStreamInserter(i *StreamInserter) {
+	s.closeMtx.Lock()
+	defer s.closeMtx.Unlock()
+
+	delete(s.streamInserters, i)
+}
@@ -0,0 +1,277 @@
+package mysql
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+type ColumnarOpts struct {
+	OrderBy []model.Order
+	Limit   uint64
+	Offset  uint64
+	// SizeHint is the expected number of the rows the columns are allocated for, they grow geometrically then
+	SizeHint int
+	// Derivable allows the derivable fields which are computed into the Values columns,
+	// otherwise they are rejected
+	Derivable bool
+}
+
+// TypedColumn is the values of a field. Only one slice is filled depending on the field type: Int64s for
+// the signed integers, Uint64s for the unsigned ones, Float64s, Bools, Strings for the text, decimal and
+// date and time fields (the same representation as GetAll uses), Bytes for the binary fields and Values for
+// the derivable fields.
+type TypedColumn struct {
+	Name     string
+	Int64s   []int64
+	Uint64s  []uint64
+	Float64s []float64
+	Bools    []bool
+	Strings  []string
+	Bytes    [][]byte
+	Values   []interface{}
+	// Nulls is the mask of the nullable field, true is NULL then the typed value is zero
+	Nulls []bool
+}
+
+// Columnar is the result of GetAllColumnar, the columns are in the order of the requested fields
+type Columnar struct {
+	Len     int
+	Columns []TypedColumn
+}
+
+// Column returns the column of the field or nil
+func (c *Columnar) Column(name string) *TypedColumn {
+	for i := range c.Columns {
+		if c.Columns[i].Name == name {
+			return &c.Columns[i]
+		}
+	}
+
+	return nil
+}
+
+// Value returns the i-th value boxed, nil for NULL
+func (c *TypedColumn) Value(i int) interface{} {
+	if c.Nulls != nil && c.Nulls[i] {
+		return nil
+	}
+
+	switch {
+	case c.Int64s != nil:
+		return c.Int64s[i]
+	case c.Uint64s != nil:
+		return c.Uint64s[i]
+	case c.Float64s != nil:
+		return c.Float64s[i]
+	case c.Bools != nil:
+		return c.Bools[i]
+	case c.Strings != nil:
+		return c.Strings[i]
+	case c.Bytes != nil:
+		return c.Bytes[i]
+	case c.Values != nil:
+		return c.Values[i]
+	}
+
+	return nil
+}
+
+// GetAllColumnar reads the fields into the typed columns instead of the rows, the fields with the read masks
+// are rejected unless the context is unmasked, see MySQL.WithUnmasked
+func (s *MySQL) GetAllColumnar(ctx context.Context, m model.IModel, fieldsNames []string, filter model.IExpression, opts ColumnarOpts) (*Columnar, error) {
+	virtual, err := virtualFieldsOf(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	lookup := fieldLookup(m, virtual)
+	if !opts.Derivable {
+		for _, name := range fieldsNames {
+			if field := lookup(name); field != nil && field.IsDerivable() {
+				return nil, qerror.Errorf("The field '%s' in model '%s' is derivable and cannot be read into a typed column", name, m.GetId())
+			}
+		}
+	}
+
+	// The dependencies of the derivable fields are selected but not returned
+	plan, err := resolveDerivable(m, lookup, fieldsNames, func(model.IFieldDefinition) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	selected := plan.read
+
+	if err := checkMaskedColumns(ctx, m, selected); err != nil {
+		return nil, err
+	}
+
+	sqlBuf := s.ScopedSqlBuffer(ctx)
+	s.WriteSelectSQL(sqlBuf, m, selected, SelectOptions{
+		GetAllOptions: model.GetAllOptions{
+			Filter:  filter,
+			OrderBy: opts.OrderBy,
+			Limit:   opts.Limit,
+			Offset:  opts.Offset,
+		},
+		MaxExecutionTime: s.maxExecutionTimeOf(ctx),
+	})
+	if err := sqlBuf.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.RawQuery(withQuery(ctx, m.GetId(), "select"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make([]TypedColumn, len(selected))
+	targets := make([]interface{}, len(selected))
+	values := make([]reflect.Value, len(selected))
+	nullable := make([]bool, len(selected))
+	decoders := make([]IEncodedFieldDefinition, len(selected))
+	for i, name := range selected {
+		fieldType := m.GetFieldDefinition(name).GetType()
+		target := reflect.New(fieldType)
+		targets[i], values[i] = target.Interface(), target.Elem()
+		decoders[i] = encodedField(m.GetFieldDefinition(name))
+
+		columns[i].Name = name
+		if fieldType.Kind() == reflect.Ptr {
+			nullable[i], fieldType = true, fieldType.Elem()
+			columns[i].Nulls = make([]bool, 0, opts.SizeHint)
+		}
+		if err := columns[i].init(fieldType, opts.SizeHint); err != nil {
+			return nil, qerror.Errorf("The field '%s' in model '%s': %s", name, m.GetId(), err.Error())
+		}
+	}
+
+	res := &Columnar{}
+	for rows.Next() {
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		}
+
+		for i := range columns {
+			value := values[i]
+			if decoders[i] != nil {
+				decoded, err := decoders[i].DecodeValue(value.Interface())
+				if err != nil {
+					return nil, err
+				}
+				value = reflect.ValueOf(decoded)
+			}
+			if nullable[i] {
+				isNull := value.IsNil()
+				columns[i].Nulls = append(columns[i].Nulls, isNull)
+				if isNull {
+					columns[i].appendZero()
+					continue
+				}
+				value = value.Elem()
+			}
+			columns[i].append(value)
+		}
+		res.Len++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	derived, err := plan.calc(ctx, res.Len, func(i int, name string) interface{} {
+		return columns[indexOf(selected, name)].Value(i)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range plan.computed {
+		columns = append(columns, TypedColumn{Name: field.GetId(), Values: derived[field.GetId()]})
+		selected = append(selected, field.GetId())
+	}
+
+	res.Columns = make([]TypedColumn, len(fieldsNames))
+	for i, name := range fieldsNames {
+		res.Columns[i] = columns[indexOf(selected, name)]
+	}
+
+	return res, nil
+}
+
+func (m *BaseModel) GetAllColumnar(ctx context.Context, fieldsNames []string, filter model.IExpression, opts ColumnarOpts) (*Columnar, error) {
+	return m.db.GetAllColumnar(ctx, m, fieldsNames, filter, opts)
+}
+
+func (c *TypedColumn) init(t reflect.Type, size int) error {
+	switch t.Kind() {
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c.Int64s = make([]int64, 0, size)
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		c.Uint64s = make([]uint64, 0, size)
+	case reflect.Float32, reflect.Float64:
+		c.Float64s = make([]float64, 0, size)
+	case reflect.Bool:
+		c.Bools = make([]bool, 0, size)
+	case reflect.String:
+		c.Strings = make([]string, 0, size)
+	case reflect.Slice:
+		c.Bytes = make([][]byte, 0, size)
+	default:
+		return qerror.Errorf("Unsupported column type %s", t.String())
+	}
+
+	return nil
+}
+
+func (c *TypedColumn) append(v reflect.Value) {
+	switch {
+	case c.Int64s != nil:
+		c.Int64s = append(c.Int64s, v.Int())
+	case c.Uint64s != nil:
+		c.Uint64s = append(c.Uint64s, v.Uint())
+	case c.Float64s != nil:
+		c.Float64s = append(c.Float64s, v.Float())
+	case c.Bools != nil:
+		c.Bools = append(c.Bools, v.Bool())
+	case c.Strings != nil:
+		c.Strings = append(c.Strings, v.String())
+	case c.Bytes != nil:
+		c.Bytes = append(c.Bytes, v.Bytes())
+	}
+}
+
+func (c *TypedColumn) appendZero() {
+	switch {
+	case c.Int64s != nil:
+		c.Int64s = append(c.Int64s, 0)
+	case c.Uint64s != nil:
+		c.Uint64s = append(c.Uint64s, 0)
+	case c.Float64s != nil:
+		c.Float64s = append(c.Float64s, 0)
+	case c.Bools != nil:
+		c.Bools = append(c.Bools, false)
+	case c.Strings != nil:
+		c.Strings = append(c.Strings, "")
+	case c.Bytes != nil:
+		c.Bytes = append(c.Bytes, nil)
+	}
+}
+
+func appendMissing(names []string, name string) []string {
+	if indexOf(names, name) < 0 {
+		return append(names, name)
+	}
+
+	return names
+}
+
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,358 @@
+package mysql
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"sort"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// Codec compresses the values of the compressed fields, the id is stored in the header of the value
+// and must be unique among the registered codecs
+type Codec interface {
+	Id() byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Compression is the Compressed option of TextField, BlobField and JSONField. The values shorter than MinSize
+// or not shrunk by the codec are stored uncompressed. The values are written with the header, the values without
+// it are read as is, so the column can contain the rows written before the compression is enabled.
+// The filters by the compressed fields compare the stored bytes.
+type Compression struct {
+	Codec   Codec
+	MinSize int
+}
+
+// DefaultCompression returns the gzip compression of the values from 1KB
+func DefaultCompression() *Compression {
+	return &Compression{
+		Codec:   GzipCodec{},
+		MinSize: 1024,
+	}
+}
+
+type GzipCodec struct {
+	// Level is the gzip compression level, 0 is gzip.DefaultCompression
+	Level int
+}
+
+func (GzipCodec) Id() byte { return 1 }
+
+func (c GzipCodec) Compress(data []byte) ([]byte, error) {
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := gzip.NewWriterLevel(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (GzipCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// codecNone is the id of the values stored uncompressed with the header
+const codecNone = 0
+
+// compressedMagic starts the header of the compressed fields values, the codec id follows it
+var compressedMagic = []byte{0, 'Q', 'Z'}
+
+var codecs = map[byte]Codec{GzipCodec{}.Id(): GzipCodec{}}
+
+// RegisterCodec makes the values compressed by the codec readable, e.g. a zstd one. The codecs other than
+// GzipCodec used by the fields must be registered, it must be called before the storage is used.
+func RegisterCodec(codec Codec) {
+	codecs[codec.Id()] = codec
+}
+
+// IEncodedFieldDefinition is implemented by the fields the values of which are stored encoded
+type IEncodedFieldDefinition interface {
+	IsEncoded() bool
+	EncodeValue(v interface{}) (interface{}, error)
+	DecodeValue(v interface{}) (interface{}, error)
+}
+
+// encodedField returns the field if its values are encoded
+func encodedField(field model.IFieldDefinition) IEncodedFieldDefinition {
+	if encoded, ok := field.(IEncodedFieldDefinition); ok && encoded.IsEncoded() {
+		return encoded
+	}
+
+	return nil
+}
+
+func (c *Compression) encodeValue(v interface{}) (interface{}, error) {
+	var data []byte
+	switch val := v.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		data = []byte(val)
+	case *string:
+		if val == nil {
+			return nil, nil
+		}
+		data = []byte(*val)
+	case []byte:
+		data = val
+	case *[]byte:
+		if val == nil {
+			return nil, nil
+		}
+		data = *val
+	default:
+		return nil, qerror.Errorf("Cannot compress the value of type %T", v)
+	}
+
+	return c.compress(data)
+}
+
+func (c *Compression) compress(data []byte) ([]byte, error) {
+	if len(data) >= c.MinSize {
+		compressed, err := c.Codec.Compress(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(compressed) < len(data) {
+			return append(append(compressedMagic[:len(compressedMagic):len(compressedMagic)], c.Codec.Id()), compressed...), nil
+		}
+	}
+
+	res := make([]byte, 0, len(compressedMagic)+1+len(data))
+	res = append(append(append(res, compressedMagic...), codecNone), data...)
+
+	return res, nil
+}
+
+func decodeValue(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		data, err := decompress([]byte(val))
+		return string(data), err
+	case *string:
+		if val == nil {
+			return val, nil
+		}
+		data, err := decompress([]byte(*val))
+		res := string(data)
+		return &res, err
+	case []byte:
+		return decompress(val)
+	case *[]byte:
+		if val == nil {
+			return val, nil
+		}
+		data, err := decompress(*val)
+		return &data, err
+	}
+
+	return v, nil
+}
+
+func isCompressed(data []byte) bool {
+	return len(data) > len(compressedMagic) && bytes.Equal(data[:len(compressedMagic)], compressedMagic)
+}
+
+func decompress(data []byte) ([]byte, error) {
+	if !isCompressed(data) {
+		return data, nil
+	}
+
+	codecId, data := data[len(compressedMagic)], data[len(compressedMagic)+1:]
+	if codecId == codecNone {
+		return data, nil
+	}
+
+	codec, exists := codecs[codecId]
+	if !exists {
+		return nil, qerror.Errorf("Unknown compression codec %d", codecId)
+	}
+
+	return codec.Decompress(data)
+}
+
+type RewriteFieldOpts struct {
+	// BatchSize is the number of the rows rewritten in one transaction, 1000 by default
+	BatchSize uint64
+	// After is the primary key the rows after which are rewritten, nil starts from the first row
+	After interface{}
+	// OnBatch receives the last primary key of the rewritten batch, the migration is resumed after it
+	OnBatch func(lastPk interface{}, rewritten uint64)
+}
+
+// CompressFieldOpts are the options of CompressField
+type CompressFieldOpts = RewriteFieldOpts
+
+// CompressField rewrites the values of the compressed field which are stored without the header, the column
+// must have the blob type already. The rows are read in the order of the single field primary key, the result
+// is the number of the rewritten rows.
+func (s *MySQL) CompressField(ctx context.Context, m model.IModel, fieldName string, opts CompressFieldOpts) (uint64, error) {
+	field := encodedField(m.GetFieldDefinition(fieldName))
+	if compressed, ok := field.(interface{ IsCompressed() bool }); !ok || !compressed.IsCompressed() {
+		return 0, qerror.Errorf("The field '%s' in model '%s' is not compressed", fieldName, m.GetId())
+	}
+
+	return s.rewriteField(ctx, m, fieldName, opts, func(value []byte) (map[string]interface{}, error) {
+		if value == nil || isCompressed(value) {
+			return nil, nil
+		}
+
+		encoded, err := field.EncodeValue(value)
+		if err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{fieldName: encoded}, nil
+	})
+}
+
+// rewriteField reads the raw values of the field in the batches locked FOR UPDATE and sets the values returned
+// by rewrite, nil values leave the row as is
+func (s *MySQL) rewriteField(ctx context.Context, m model.IModel, fieldName string, opts RewriteFieldOpts,
+	rewrite func(value []byte) (map[string]interface{}, error)) (uint64, error) {
+	pk := m.GetPKFieldsNames()
+	if len(pk) != 1 {
+		return 0, qerror.Errorf("The model '%s' must have a single field primary key", m.GetId())
+	}
+
+	if opts.BatchSize == 0 {
+		opts.BatchSize = 1000
+	}
+
+	var total uint64
+	after := opts.After
+	for {
+		var (
+			lastPk    interface{}
+			count     uint64
+			rewritten uint64
+		)
+		err := s.DoInTransaction(ctx, func(ctx context.Context) error {
+			var filter model.IExpression
+			if after != nil {
+				filter = RawExpr(QuoteIdent(pk[0])+" > ?", after)
+			}
+
+			sqlBuf := s.ScopedSqlBuffer(ctx)
+			s.WriteSelectSQL(sqlBuf, m, []string{pk[0], fieldName}, SelectOptions{
+				GetAllOptions: model.GetAllOptions{
+					Filter:    filter,
+					OrderBy:   []model.Order{{FieldName: pk[0]}},
+					Limit:     opts.BatchSize,
+					ForUpdate: true,
+				},
+			})
+			if err := sqlBuf.Err(); err != nil {
+				return err
+			}
+
+			// The values are read raw to find the ones to rewrite
+			rows, err := s.RawQuery(withQuery(ctx, m.GetId(), "select"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+			if err != nil {
+				return err
+			}
+
+			var (
+				ids       []interface{}
+				newValues []map[string]interface{}
+			)
+			for rows.Next() {
+				var (
+					id    interface{}
+					value []byte
+				)
+				if err := rows.Scan(&id, &value); err != nil {
+					rows.Close()
+					return err
+				}
+				if b, ok := id.([]byte); ok {
+					id = string(b)
+				}
+				lastPk, count = id, count+1
+
+				values, err := rewrite(value)
+				if err != nil {
+					rows.Close()
+					return err
+				}
+				if values != nil {
+					ids, newValues = append(ids, id), append(newValues, values)
+				}
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return err
+			}
+
+			for i, id := range ids {
+				names := make([]string, 0, len(newValues[i]))
+				for name := range newValues[i] {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				sqlBuf := NewSqlBuffer()
+				sqlBuf.WriteString("UPDATE ")
+				sqlBuf.WriteTable(m)
+				sqlBuf.WriteString(" SET ")
+				for j, name := range names {
+					if j > 0 {
+						sqlBuf.WriteByte(',')
+					}
+					sqlBuf.WriteIdentifier(name)
+					sqlBuf.WriteByte('=')
+					sqlBuf.WriteValue(newValues[i][name])
+				}
+				sqlBuf.WriteString(" WHERE ")
+				sqlBuf.WriteIdentifier(pk[0])
+				sqlBuf.WriteByte('=')
+				sqlBuf.WriteValue(id)
+
+				if _, err := s.Exec(withQuery(ctx, m.GetId(), "update"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...); err != nil {
+					return err
+				}
+				rewritten++
+			}
+
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		if lastPk == nil {
+			return total, nil
+		}
+
+		total += rewritten
+		after = lastPk
+		if opts.OnBatch != nil {
+			opts.OnBatch(lastPk, rewritten)
+		}
+		if count < opts.BatchSize {
+			return total, nil
+		}
+	}
+}
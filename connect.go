@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-qbit/qerror"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// DefaultCollation is the collation of the connections of NewFromDSN and NewFromConfig
+const DefaultCollation = "utf8mb4_unicode_ci"
+
+// DefaultPingTimeout is the timeout of the ping of NewFromDSN and NewFromConfig
+const DefaultPingTimeout = 10 * time.Second
+
+// Options configures the storage created by NewFromDSN, NewFromConfig or NewWithDB, the zero values keep
+// the defaults of NewMySQL
+type Options struct {
+	// PoolConfig is DefaultPoolConfig if it is nil
+	PoolConfig *PoolConfig
+	// Collation is the one set by the DSN or DefaultCollation if it is empty, the charset of the DSN is ignored
+	Collation string
+	// LazyConnect defers connecting until the first statement, otherwise the server is pinged with PingTimeout,
+	// DefaultPingTimeout if it is zero
+	LazyConnect bool
+	PingTimeout time.Duration
+
+	// SQLMode is set as the sql_mode of every connection if it is not empty, e.g. "TRADITIONAL"
+	SQLMode string
+	// RequireStrictMode fails if the sql_mode of the session is not strict, see MySQL.CheckStrictMode.
+	// It is not checked with LazyConnect.
+	RequireStrictMode bool
+	// ConnInit and ConnInitFunc set up every new connection, see MySQL.SetConnInit. They are ignored by NewWithDB.
+	ConnInit     []string
+	ConnInitFunc ConnInitFunc
+
+	Logger          Logger
+	MetricsSink     MetricsSink
+	TablePrefix     string
+	ExecutorFactory ExecutorFactory
+}
+
+// NewFromDSN returns the storage connected with the DSN, see NewFromConfig
+func NewFromDSN(dsn string, opts Options) (*MySQL, error) {
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFromConfig(cfg, opts)
+}
+
+// NewFromConfig returns the storage connected with the driver configuration. The connections use the utf8mb4
+// collation and the timeouts of the pool configuration. parseTime must be disabled since the storage reads
+// the date and time values as strings, see ParseTime.
+func NewFromConfig(cfg *mysqldriver.Config, opts Options) (*MySQL, error) {
+	if cfg.ParseTime {
+		return nil, qerror.Errorf("parseTime must be disabled, the storage reads DATE, DATETIME and TIMESTAMP values as strings")
+	}
+
+	cfg = cfg.Clone()
+	switch {
+	case opts.Collation != "":
+		cfg.Collation = opts.Collation
+	case cfg.Collation == mysqldriver.NewConfig().Collation:
+		cfg.Collation = DefaultCollation
+	}
+	// The charset parameter overrides the collation
+	delete(cfg.Params, "charset")
+	if opts.SQLMode != "" {
+		mode, err := sqlModeParam(opts.SQLMode)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Params == nil {
+			cfg.Params = make(map[string]string)
+		}
+		cfg.Params["sql_mode"] = mode
+	}
+
+	s := NewMySQL()
+	if opts.PoolConfig != nil {
+		s.poolConfig = *opts.PoolConfig
+	}
+	s.applyOptions(opts)
+	s.SetConnInit(opts.ConnInit, opts.ConnInitFunc)
+
+	if err := s.Connect(cfg.FormatDSN()); err != nil {
+		return nil, err
+	}
+	if opts.LazyConnect {
+		return s, nil
+	}
+
+	pingTimeout := opts.PingTimeout
+	if pingTimeout <= 0 {
+		pingTimeout = DefaultPingTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+
+	if err := s.db.PingContext(ctx); err != nil {
+		_ = s.db.Close()
+		return nil, err
+	}
+	if opts.RequireStrictMode {
+		if err := s.CheckStrictMode(ctx); err != nil {
+			_ = s.db.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// applyOptions applies the options which are not the connection ones
+func (s *MySQL) applyOptions(opts Options) {
+	if opts.Logger != nil {
+		s.SetLogger(opts.Logger)
+	}
+	if opts.MetricsSink != nil {
+		s.SetMetricsSink(opts.MetricsSink)
+	}
+	s.SetTablePrefix(opts.TablePrefix)
+	s.SetExecutorFactory(opts.ExecutorFactory)
+}
@@ -0,0 +1,126 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// ErrConnInit is matched by the errors of the statements which could not get a connection because the setup
+// of the new connection failed, see SetConnInit. The connection is closed and is not added to the pool.
+var ErrConnInit = errors.New("connection init failed")
+
+// ConnInitFunc sets up the new connection of the pool after the statements of SetConnInit, e.g. runs the session
+// statements depending on the environment with ExecConn
+type ConnInitFunc func(ctx context.Context, conn driver.Conn) error
+
+// SetConnInit sets the statements run once on every new connection of the pools of the primary server and
+// of the replicas, e.g. SET statements of the session variables the DSN cannot express, then f is called
+// if it is not nil. It must be called before Connect and AddReplica, the pools of NewWithDB and AddReplicaDB
+// are not set up.
+func (s *MySQL) SetConnInit(statements []string, f ConnInitFunc) {
+	s.connInit = append([]string(nil), statements...)
+	s.connInitFunc = f
+}
+
+// ExecConn runs the statement on the driver connection, e.g. in ConnInitFunc
+func ExecConn(ctx context.Context, conn driver.Conn, query string, args ...driver.NamedValue) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		if _, err := execer.ExecContext(ctx, query, args); err != driver.ErrSkip {
+			return err
+		}
+	}
+
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if preparer, ok := conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = conn.Prepare(query)
+	}
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	if stmtExecer, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = stmtExecer.ExecContext(ctx, args)
+		return err
+	}
+
+	// The legacy drivers cannot cancel the statement
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	_, err = stmt.Exec(values)
+
+	return err
+}
+
+// openDB opens the pool of the DSN, the new connections of which are set up as SetConnInit sets
+func (s *MySQL) openDB(dsn string) (*sql.DB, error) {
+	db, err := sql.Open(SqlDriver, dsn)
+	if err != nil || len(s.connInit) == 0 && s.connInitFunc == nil {
+		return db, err
+	}
+
+	drv := db.Driver()
+	_ = db.Close()
+
+	var connector driver.Connector
+	if driverCtx, ok := drv.(driver.DriverContext); ok {
+		if connector, err = driverCtx.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	} else {
+		connector = dsnConnector{dsn: dsn, driver: drv}
+	}
+
+	return sql.OpenDB(&initConnector{Connector: connector, statements: s.connInit, init: s.connInitFunc}), nil
+}
+
+// dsnConnector is the connector of the drivers which do not implement driver.DriverContext
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open(c.dsn) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.driver }
+
+// initConnector sets up the new connections, the connection failed to set up is closed
+type initConnector struct {
+	driver.Connector
+	statements []string
+	init       ConnInitFunc
+}
+
+func (c *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, statement := range c.statements {
+		if err := ExecConn(ctx, conn, statement); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w: %s: %s", ErrConnInit, truncateStatement(statement, ScriptSnippetLength), err.Error())
+		}
+	}
+	if c.init != nil {
+		if err := c.init(ctx, conn); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("%w: %s", ErrConnInit, err.Error())
+		}
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,170 @@
+package mysql
+
+import (
+	"database/sql/driver"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/go-qbit/qerror"
+)
+
+// ValueConverter converts the value of the custom Go type to the driver value, e.g. the domain type to its
+// storage representation
+type ValueConverter func(value interface{}) (driver.Value, error)
+
+// ScanConverter converts the read value of the field to the custom Go type of the struct field, the value
+// is not NULL and is dereferenced
+type ScanConverter func(value interface{}) (interface{}, error)
+
+type scanConverterKey struct {
+	fieldType reflect.Type
+	goType    reflect.Type
+}
+
+// converters are the value and the scan converters of the storage, the value converters of the pointer types
+// are resolved once and cached
+type converters struct {
+	values   map[reflect.Type]ValueConverter
+	scans    map[scanConverterKey]ScanConverter
+	resolved sync.Map
+}
+
+// RegisterValueConverter sets the converter of the arguments of type t, the values of t and *t written by Add
+// and Edit and compared by the filters are converted by it before they are sent to the server. It must be called
+// before the storage is used.
+func (s *MySQL) RegisterValueConverter(t reflect.Type, convert ValueConverter) {
+	if s.converters.values == nil {
+		s.converters.values = make(map[reflect.Type]ValueConverter)
+	}
+	s.converters.values[t] = convert
+}
+
+// RegisterScanConverter sets the converter of the values of the fields of type fieldType, e.g. *BigIntField,
+// read into the struct fields of type goType or *goType, see GetAllToStructs. It must be called before
+// the storage is used.
+func (s *MySQL) RegisterScanConverter(fieldType, goType reflect.Type, convert ScanConverter) {
+	if s.converters.scans == nil {
+		s.converters.scans = make(map[scanConverterKey]ScanConverter)
+	}
+	s.converters.scans[scanConverterKey{fieldType, goType}] = convert
+}
+
+// valueConverter returns the converter of the values of type t, nil if there is no one
+func (c *converters) valueConverter(t reflect.Type) ValueConverter {
+	if convert, ok := c.resolved.Load(t); ok {
+		return convert.(ValueConverter)
+	}
+
+	convert := c.values[t]
+	if convert == nil && t.Kind() == reflect.Ptr {
+		if elemConvert := c.values[t.Elem()]; elemConvert != nil {
+			convert = func(value interface{}) (driver.Value, error) {
+				rv := reflect.ValueOf(value)
+				if rv.IsNil() {
+					return nil, nil
+				}
+				return elemConvert(rv.Elem().Interface())
+			}
+		}
+	}
+	c.resolved.Store(t, convert)
+
+	return convert
+}
+
+// convertArgs converts the arguments of the types with the value converters, the arguments are copied
+// if any of them is converted
+func (s *MySQL) convertArgs(a []interface{}) ([]interface{}, error) {
+	if len(s.converters.values) == 0 {
+		return a, nil
+	}
+
+	var res []interface{}
+	for i, arg := range a {
+		sensitive, isSensitive := arg.(sensitiveValue)
+		if isSensitive {
+			arg = sensitive.value
+		}
+		if arg == nil {
+			continue
+		}
+
+		convert := s.converters.valueConverter(reflect.TypeOf(arg))
+		if convert == nil {
+			continue
+		}
+		value, err := convert(arg)
+		if err != nil {
+			return nil, qerror.Errorf("The argument %d of type %T cannot be converted: %s", i+1, arg, err.Error())
+		}
+
+		if res == nil {
+			res = make([]interface{}, len(a))
+			copy(res, a)
+		}
+		if isSensitive {
+			sensitive.value = value
+			res[i] = sensitive
+		} else {
+			res[i] = value
+		}
+	}
+	if res == nil {
+		return a, nil
+	}
+
+	return res, nil
+}
+
+// scanConverter returns the converter of the values of the field read into the struct field of type t
+func (s *MySQL) scanConverter(field interface{}, t reflect.Type) ScanConverter {
+	if len(s.converters.scans) == 0 {
+		return nil
+	}
+
+	return s.converters.scans[scanConverterKey{reflect.TypeOf(field), t}]
+}
+
+// DurationToMicroseconds is the value converter of time.Duration stored as the number of microseconds,
+// e.g. in BIGINT
+func DurationToMicroseconds(value interface{}) (driver.Value, error) {
+	return int64(value.(time.Duration) / time.Microsecond), nil
+}
+
+// DurationFromMicroseconds is the scan converter of the number of microseconds to time.Duration
+func DurationFromMicroseconds(value interface{}) (interface{}, error) {
+	switch rv := reflect.ValueOf(value); rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return time.Duration(rv.Int()) * time.Microsecond, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return time.Duration(rv.Uint()) * time.Microsecond, nil
+	default:
+		return nil, qerror.Errorf("The duration in microseconds must be an integer, not %T", value)
+	}
+}
+
+// IPToBytes is the value converter of net.IP stored in BINARY(16), the IPv4 addresses are stored
+// as the IPv4-mapped IPv6 ones
+func IPToBytes(value interface{}) (driver.Value, error) {
+	ip := value.(net.IP)
+	if ip == nil {
+		return nil, nil
+	}
+	if res := ip.To16(); res != nil {
+		return []byte(res), nil
+	}
+
+	return nil, qerror.Errorf("Invalid IP address of %d bytes", len(ip))
+}
+
+// IPFromBytes is the scan converter of BINARY(16) to net.IP
+func IPFromBytes(value interface{}) (interface{}, error) {
+	b, ok := value.([]byte)
+	if !ok || len(b) != net.IPv6len {
+		return nil, qerror.Errorf("The IP address must be of %d bytes", net.IPv6len)
+	}
+
+	return net.IP(append([]byte(nil), b...)), nil
+}
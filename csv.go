@@ -0,0 +1,268 @@
+package mysql
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+type CSVExportOpts struct {
+	OrderBy []model.Order
+	Limit   uint64
+	// Delimiter is the field delimiter, ',' by default
+	Delimiter rune
+	// Null is written for NULL, the empty string by default
+	Null string
+	// Formats format the values of the fields instead of the default formatting, the value is nil for NULL.
+	// DATETIME and TIMESTAMP values are written in RFC 3339 by default.
+	Formats map[string]func(value interface{}) string
+}
+
+type CSVImportOpts struct {
+	// Delimiter is the field delimiter, ',' by default
+	Delimiter rune
+	// Null is read as NULL for the nullable fields, the empty string by default
+	Null string
+	// BatchSize is the maximum number of the rows in one INSERT, 1000 by default
+	BatchSize int
+	// Upsert updates the rows with the duplicate keys instead of failing
+	Upsert bool
+	// DryRun validates the rows without inserting them
+	DryRun bool
+	// MaxErrors is the number of the invalid rows the import stops after, 100 by default
+	MaxErrors int
+}
+
+// CSVRowError is the error of the CSV row, Field is empty for the errors of the whole row
+type CSVRowError struct {
+	Line  int
+	Field string
+	Err   error
+}
+
+func (e *CSVRowError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err.Error())
+	}
+
+	return fmt.Sprintf("line %d: field '%s': %s", e.Line, e.Field, e.Err.Error())
+}
+
+func (e *CSVRowError) Unwrap() error { return e.Err }
+
+// CSVImportError is returned by ImportCSV if some rows are invalid, no rows are imported then
+type CSVImportError struct {
+	Rows []*CSVRowError
+}
+
+func (e *CSVImportError) Error() string {
+	lines := make([]string, len(e.Rows))
+	for i, rowErr := range e.Rows {
+		lines[i] = rowErr.Error()
+	}
+
+	return fmt.Sprintf("%d invalid CSV rows:\n%s", len(e.Rows), strings.Join(lines, "\n"))
+}
+
+// ExportCSV writes the header with the fields names and the rows as they are read
+func (s *MySQL) ExportCSV(ctx context.Context, m model.IModel, w io.Writer, fieldsNames []string, filter model.IExpression, opts CSVExportOpts) error {
+	writer := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		writer.Comma = opts.Delimiter
+	}
+	if err := writer.Write(fieldsNames); err != nil {
+		return err
+	}
+
+	record := make([]string, len(fieldsNames))
+	err := s.streamRows(ctx, m, fieldsNames, model.GetAllOptions{
+		Filter:  filter,
+		OrderBy: opts.OrderBy,
+		Limit:   opts.Limit,
+	}, false, func(values []interface{}) error {
+		for i, value := range values {
+			if format := opts.Formats[fieldsNames[i]]; format != nil {
+				record[i] = format(value)
+			} else if value == nil {
+				record[i] = opts.Null
+			} else {
+				record[i] = s.formatCSV(m.GetFieldDefinition(fieldsNames[i]), value)
+			}
+		}
+
+		return writer.Write(record)
+	})
+	if err != nil {
+		return err
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+// formatCSV formats the value of the field, DATETIME and TIMESTAMP values are formatted in RFC 3339
+func (s *MySQL) formatCSV(field model.IFieldDefinition, value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		switch field.(type) {
+		case *DateTimeField, *TimeStampField:
+			return s.exportTime(field, value)
+		}
+		return value
+	case []byte:
+		return string(value)
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// ImportCSV inserts the rows with the header of the fields names in one transaction, the values are converted
+// and checked by the fields. The result is the number of the imported or validated rows.
+func (s *MySQL) ImportCSV(ctx context.Context, m model.IModel, r io.Reader, opts CSVImportOpts) (int, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.MaxErrors <= 0 {
+		opts.MaxErrors = 100
+	}
+
+	reader := csv.NewReader(r)
+	if opts.Delimiter != 0 {
+		reader.Comma = opts.Delimiter
+	}
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	fields := make([]model.IFieldDefinition, len(header))
+	for i, name := range header {
+		fields[i] = m.GetFieldDefinition(name)
+		if fields[i] == nil || fields[i].IsDerivable() {
+			return 0, qerror.Errorf("line 1: Unknown field '%s' in model '%s'", name, m.GetId())
+		}
+		if containsString(header[:i], name) {
+			return 0, qerror.Errorf("line 1: Duplicate field '%s'", name)
+		}
+	}
+	for _, name := range m.GetFieldsNames() {
+		if m.GetFieldDefinition(name).IsRequired() && !containsString(header, name) {
+			return 0, qerror.Errorf("line 1: Missed required field '%s' in model '%s'", name, m.GetId())
+		}
+	}
+
+	importRows := func(ctx context.Context) (int, error) {
+		var (
+			count     int
+			batch     [][]interface{}
+			rowErrors []*CSVRowError
+		)
+		flush := func() error {
+			if len(batch) > 0 && len(rowErrors) == 0 && !opts.DryRun {
+				if _, err := m.AddMulti(ctx, model.NewData(header, batch), model.AddOptions{Replace: opts.Upsert}); err != nil {
+					return err
+				}
+			}
+			batch = batch[:0]
+			return nil
+		}
+
+		for len(rowErrors) < opts.MaxErrors {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return 0, err
+			}
+			line, _ := reader.FieldPos(0)
+
+			row, errs := s.csvRow(ctx, fields, record, line, opts.Null)
+			if len(errs) > 0 {
+				rowErrors = append(rowErrors, errs...)
+				continue
+			}
+
+			batch = append(batch, row)
+			count++
+			if len(batch) == opts.BatchSize {
+				if err := flush(); err != nil {
+					return 0, err
+				}
+			}
+		}
+		if err := flush(); err != nil {
+			return 0, err
+		}
+
+		if len(rowErrors) > 0 {
+			return 0, &CSVImportError{rowErrors}
+		}
+
+		return count, nil
+	}
+
+	if opts.DryRun {
+		return importRows(ctx)
+	}
+
+	var count int
+	err = s.DoInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		count, err = importRows(ctx)
+		return err
+	})
+
+	return count, err
+}
+
+// csvRow converts the values of the record and checks them as AddMulti does
+func (s *MySQL) csvRow(ctx context.Context, fields []model.IFieldDefinition, record []string, line int, null string) ([]interface{}, []*CSVRowError) {
+	if len(record) != len(fields) {
+		return nil, []*CSVRowError{{line, "", qerror.Errorf("%d values, must be %d", len(record), len(fields))}}
+	}
+
+	var errs []*CSVRowError
+	row := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if record[i] == null && field.GetType().Kind() == reflect.Ptr {
+			continue
+		}
+
+		value, err := s.parseText(field, record[i])
+		if err == nil {
+			var cleaned interface{}
+			if cleaned, err = field.Clean(ctx, value); err == nil {
+				err = field.Check(ctx, cleaned)
+			}
+		}
+		if err != nil {
+			errs = append(errs, &CSVRowError{line, field.GetId(), err})
+			continue
+		}
+		row[i] = value
+	}
+
+	return row, errs
+}
+
+func (m *BaseModel) ExportCSV(ctx context.Context, w io.Writer, fieldsNames []string, filter model.IExpression, opts CSVExportOpts) error {
+	return m.db.ExportCSV(ctx, m, w, fieldsNames, filter, opts)
+}
+
+func (m *BaseModel) ImportCSV(ctx context.Context, r io.Reader, opts CSVImportOpts) (int, error) {
+	return m.db.ImportCSV(ctx, m, r, opts)
+}
@@ -0,0 +1,84 @@
+package mysql
+
+import (
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// SetDatabase sets the default database the tables of the models are qualified with, the tables of the models
+// without BaseModelOpts.Database are in the database of the connection if it is empty.
+// It must be called before the storage is used.
+func (s *MySQL) SetDatabase(name string) {
+	s.database = name
+}
+
+// SetTablePrefix sets the prefix the tables of the models are named with, e.g. the table of model user
+// is app_user with the prefix app_.
+// It must be called before the storage is used.
+func (s *MySQL) SetTablePrefix(prefix string) {
+	s.tablePrefix = prefix
+}
+
+// GetTableName returns the name of the model table
+func (m *BaseModel) GetTableName() string {
+	return m.db.tablePrefix + m.GetId()
+}
+
+// TableName returns the name of the model table, the model id for the models without the table prefix
+func TableName(m model.IModel) string {
+	if dbModel, ok := m.(interface{ GetTableName() string }); ok {
+		return dbModel.GetTableName()
+	}
+
+	return m.GetId()
+}
+
+// GetDatabase returns the database of the model table, it is empty for the database of the connection
+func (m *BaseModel) GetDatabase() string {
+	if m.database != "" {
+		return m.database
+	}
+
+	return m.db.database
+}
+
+// databaseOf returns the database of the model table
+func databaseOf(m model.IModel) string {
+	if dbModel, ok := m.(interface{ GetDatabase() string }); ok {
+		return dbModel.GetDatabase()
+	}
+
+	return ""
+}
+
+// QuoteTable quotes the table of the model qualified with its database, e.g. `analytics`.`event`.
+// The columns are qualified with TableName.
+func QuoteTable(m model.IModel) string {
+	return quoteTableName(m, TableName(m))
+}
+
+// quoteTableName quotes the table qualified with the database of the model
+func quoteTableName(m model.IModel, table string) string {
+	if database := databaseOf(m); database != "" {
+		return QuoteIdent(database, table)
+	}
+
+	return QuoteIdent(table)
+}
+
+// WriteTable writes the table of the model, see QuoteTable. The shard table of the sharded model is written
+// to ScopedSqlBuffer, see WithShard.
+func (b *SqlBuffer) WriteTable(m model.IModel) {
+	b.writeTable(m)
+}
+
+// checkStorage checks that the model can be joined with the models of the storage, the models of the different
+// databases on the same connection can be joined while the models of the other storages cannot
+func (s *MySQL) checkStorage(m model.IModel) error {
+	dbModel, ok := m.(interface{ GetDb() *MySQL })
+	if s == nil || !ok || dbModel.GetDb() == s {
+		return nil
+	}
+
+	return qerror.Errorf("The model '%s' belongs to the other storage, the models of the different storages cannot be used in the same statement", m.GetId())
+}
@@ -1,7 +1,6 @@
 package mysql
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
 	"database/sql/driver"
@@ -12,9 +11,12 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
-	_ "github.com/go-sql-driver/mysql"
+	mysqldriver "github.com/go-sql-driver/mysql"
 
 	"github.com/go-qbit/model"
 	"github.com/go-qbit/qerror"
@@ -22,44 +24,183 @@ import (
 )
 
 var (
-	debugSQL  = os.Getenv("MYSQL_DEBUG") != ""
 	SqlDriver = "mysql"
 )
 
 type MySQL struct {
-	db        *sql.DB
-	models    map[string]model.IModel
-	modelsMtx sync.RWMutex
+	db            *sql.DB
+	models        map[string]model.IModel
+	modelsMtx     sync.RWMutex
+	exprProcessor *ExprProcessor
+	deleteDeps    map[string][]DeleteDependency
+	poolConfig    PoolConfig
+	replicas      []*replica
+	replicasMtx   sync.RWMutex
+	replicaNext   uint32
+	metricsSink   atomic.Value
+
+	slowQueryThreshold   time.Duration
+	slowQueryHandler     SlowQueryHandler
+	argsRedactor         ArgsRedactor
+	retryPolicy          RetryPolicy
+	maxExecutionTime     time.Duration
+	killGracePeriod      time.Duration
+	writeBehinds         map[string]*writeBehind
+	writeBehindsMtx      sync.RWMutex
+	logger               Logger
+	debug                bool
+	tracer               Tracer
+	tracingOpts          TracingOpts
+	queryHooks           []QueryHook
+	queryTags            queryTags
+	closeMtx             sync.Mutex
+	closing              bool
+	openTxs              map[uint64]time.Time
+	openTxsSeq           uint64
+	txsDone              chan struct{}
+	streamInserters      map[*StreamInserter]struct{}
+	interpolateSQL       bool
+	errorStatementLength int
+	database             string
+	loc                  *time.Location
+	executorFactory      ExecutorFactory
+	tablePrefix          string
+	health               healthCache
+	serverVersion        *ServerVersion
+	serverVersionMtx     sync.Mutex
+	maxPlaceholders      int
+	writeObservers       []WriteObserver
+	outbox               model.IModel
+	audits               map[string]*audit
+	queryBudgetHandler   QueryBudgetHandler
+	savePointPrefix      string
+	plainSavePointNames  bool
+	converters           converters
+	namedQueries         map[string]*namedQuery
+	namedQueriesMtx      sync.RWMutex
+	clock                Clock
+	testMode             bool
+	connInit             []string
+	connInitFunc         ConnInitFunc
+	recordingPolicy      RecordingPolicy
+	maxCallbackDepth     int
+	noBackslashEscapes   bool
+	// noImplicitCommitGuard disables ErrImplicitCommitInTx, see SetImplicitCommitGuard
+	noImplicitCommitGuard bool
+	// unmaskedDerivableInputs makes the derivable fields computed from the stored values, see SetMaskDerivableInputs
+	unmaskedDerivableInputs bool
+	// txSeq is the last transaction id, it starts from the time of the start to tell the ids
+	// of the restarted process
+	txSeq uint64
 }
 
-func NewMySQL() *MySQL {
+// NewMySQL returns the storage, the connections pool is configured with DefaultPoolConfig unless
+// the configuration is passed
+func NewMySQL(poolConfig ...PoolConfig) *MySQL {
 	s := &MySQL{
-		models: make(map[string]model.IModel),
+		models:          make(map[string]model.IModel),
+		exprProcessor:   &ExprProcessor{InChunkSize: 1000},
+		poolConfig:      DefaultPoolConfig(),
+		logger:          StderrLogger{},
+		debug:           os.Getenv("MYSQL_DEBUG") != "",
+		txSeq:           uint64(time.Now().UnixNano()),
+		killGracePeriod: time.Second,
 	}
 
+	if len(poolConfig) > 0 {
+		s.poolConfig = poolConfig[0]
+	}
+	s.exprProcessor.storage = s
+
 	return s
 }
 
 func (s *MySQL) Connect(dsn string) error {
-	db, err := sql.Open(SqlDriver, dsn)
+	dsn, err := s.applyTimeouts(dsn)
+	if err != nil {
+		return err
+	}
+	if dsn, err = s.applyTimeZone(dsn); err != nil {
+		return err
+	}
+
+	db, err := s.openDB(dsn)
 	if err != nil {
 		return err
 	}
 	s.db = db
+	s.applyPoolConfig()
+
+	// The location the driver formats time.Time arguments in, for the interpolated SQL only
+	if cfg, err := mysqldriver.ParseDSN(dsn); err == nil {
+		s.loc = cfg.Loc
+		if strings.Contains(strings.ToUpper(cfg.Params["sql_mode"]), "NO_BACKSLASH_ESCAPES") {
+			s.noBackslashEscapes = true
+		}
+	}
 
 	return nil
 }
 
+// SetupConnectionsPool changes the positive pool settings only.
+//
+// Deprecated: use SetPoolConfig
 func (s *MySQL) SetupConnectionsPool(maxOpenConns, maxIdleConns int, maxLifetime time.Duration) {
+	config := s.poolConfig
 	if maxOpenConns > 0 {
-		s.db.SetMaxOpenConns(maxOpenConns)
+		config.MaxOpenConns = maxOpenConns
 	}
 	if maxIdleConns > 0 {
-		s.db.SetMaxIdleConns(maxIdleConns)
+		config.MaxIdleConns = maxIdleConns
 	}
 	if maxLifetime > 0 {
-		s.db.SetConnMaxLifetime(maxLifetime)
+		config.ConnMaxLifetime = maxLifetime
 	}
+
+	s.SetPoolConfig(config)
+}
+
+// SetNilValueIsNull makes Eq and Ne compare with a nil value as IS NULL and IS NOT NULL,
+// it must be called before the storage is used
+func (s *MySQL) SetNilValueIsNull(enabled bool) {
+	s.exprProcessor.NilValueIsNull = enabled
+}
+
+// SetExpandTupleCompare makes the tuple inequalities to be expanded to the boolean expressions,
+// it must be called before the storage is used
+func (s *MySQL) SetExpandTupleCompare(enabled bool) {
+	s.exprProcessor.ExpandTupleCompare = enabled
+}
+
+// SetInChunkSize sets the maximum number of values in one IN group, 1000 by default, the longer lists are split
+// into several groups and the children and the parents are loaded by several queries, 0 disables the split.
+// It must be called before the storage is used.
+func (s *MySQL) SetInChunkSize(size int) {
+	s.exprProcessor.InChunkSize = size
+}
+
+// SetAllowAnyFunc makes Func accept any function name, not only the ones in AllowedFuncs,
+// it must be called before the storage is used
+func (s *MySQL) SetAllowAnyFunc(enabled bool) {
+	s.exprProcessor.AllowAnyFunc = enabled
+}
+
+// SetStrictMatch makes Match fail if its fields do not correspond to a fulltext index of the model, it is
+// the default, otherwise a warning is passed to the logger. It must be called before the storage is used.
+func (s *MySQL) SetStrictMatch(strict bool) {
+	s.exprProcessor.LaxMatch = !strict
+}
+
+// SetFoldCollation sets the case-insensitive collation used by EqFold and LikeFold, it is DefaultFoldCollation
+// by default. It must be called before the storage is used.
+func (s *MySQL) SetFoldCollation(collation string) {
+	s.exprProcessor.FoldCollation = collation
+}
+
+// SetDateLocation sets the time zone OnDate, InDateRange, InWeek, InMonth and InYear compute the day boundaries
+// in, it is UTC by default. It must be called before the storage is used.
+func (s *MySQL) SetDateLocation(loc *time.Location) {
+	s.exprProcessor.DateLocation = loc
 }
 
 func (s *MySQL) GetRawDB() *sql.DB {
@@ -67,6 +208,13 @@ func (s *MySQL) GetRawDB() *sql.DB {
 }
 
 func (s *MySQL) Disconnect() error {
+	s.replicasMtx.Lock()
+	for _, r := range s.replicas {
+		_ = r.db.Close()
+	}
+	s.replicas = nil
+	s.replicasMtx.Unlock()
+
 	return s.db.Close()
 }
 
@@ -80,12 +228,19 @@ func (s *MySQL) NewModel(id string, fields []model.IFieldDefinition, opts model.
 }
 
 func (s *MySQL) RegisterModel(m model.IModel) error {
+	if err := validateModelIdentifiers(m); err != nil {
+		return err
+	}
+
 	s.modelsMtx.Lock()
 	defer s.modelsMtx.Unlock()
 
 	if _, exists := s.models[m.GetId()]; exists {
 		return qerror.Errorf("Model '%s' is already exists", m.GetId())
 	}
+	if err := s.checkTableUnique(m); err != nil {
+		return err
+	}
 
 	s.models[m.GetId()] = m
 
@@ -106,7 +261,9 @@ func (s *MySQL) WriteCreateSQL(sqlBuf *SqlBuffer) {
 	}
 }
 
+// InitDB creates the tables of the models and the shard tables of the sharded ones, it is rejected in a transaction unless AllowImplicitCommit is set
 func (s *MySQL) InitDB(ctx context.Context) error {
+	ctx = withImplicitCommit(ctx)
 	modelLevels := s.getModelsLevels()
 	sort.Sort(modelLevels)
 
@@ -116,10 +273,13 @@ func (s *MySQL) InitDB(ctx context.Context) error {
 	defer s.modelsMtx.RUnlock()
 
 	for _, modelLevel := range modelLevels {
-		sqlBuf.Reset()
-		s.models[modelLevel.name].(*BaseModel).WriteCreateSQL(sqlBuf)
-		if _, err := s.Exec(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...); err != nil {
-			return err
+		m := s.models[modelLevel.name].(*BaseModel)
+		for _, table := range m.tables() {
+			sqlBuf.Reset()
+			m.writeCreateTableSQL(sqlBuf, table)
+			if _, err := s.Exec(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -127,92 +287,241 @@ func (s *MySQL) InitDB(ctx context.Context) error {
 }
 
 func (s *MySQL) Exec(ctx context.Context, sql string, a ...interface{}) (driver.Result, error) {
-	sqlBuf := &SqlBuffer{Buffer: bytes.NewBufferString(sql), args: a}
+	if len(s.queryHooks) == 0 {
+		return s.exec(ctx, sql, a)
+	}
+
+	var res driver.Result
+	info := newQueryHookInfo(ctx, sql, a)
+	_, err := s.withHooks(ctx, info, func(ctx context.Context) (driver.Result, error) {
+		var err error
+		res, err = s.exec(ctx, info.SQL, info.Args)
+		return res, err
+	})
+
+	return res, err
+}
+
+// exec runs the statement, all the statements passed to Exec are executed by it
+func (s *MySQL) exec(ctx context.Context, sql string, a []interface{}) (driver.Result, error) {
+	a, err := s.convertArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPlaceholders(a); err != nil {
+		return nil, err
+	}
+	if d := dryRunOf(ctx); d != nil {
+		return d.record(sql, a), nil
+	}
+	if err := s.checkSnapshotWrite(ctx, sql); err != nil {
+		return nil, err
+	}
+	if err := s.checkImplicitCommit(ctx, sql); err != nil {
+		return nil, err
+	}
+	if err := s.checkQueryBudget(ctx); err != nil {
+		return nil, err
+	}
 
-	ctx = timelog.Start(ctx, sqlBuf)
+	ctx = timelog.Start(ctx, recordedStatement{s, sql, a})
 	defer timelog.Finish(ctx)
+	span := s.startStatementSpan(ctx, sql)
+	// The same start is used by the metrics and the slow queries log
+	start := s.now()
 
 	ct := ctx.Value(s.transactionKey())
 
-	var (
-		res driver.Result
-		err error
-	)
+	var res driver.Result
 
-	if debugSQL {
-		println(sqlBuf.String())
+	taggedSQL := s.tagQuery(ctx, sql)
+	if maxExecutionTime := s.maxExecutionTimeOf(ctx); maxExecutionTime > 0 {
+		t, _ := ct.(*transaction)
+		res, err = s.execWithKill(ctx, t, maxExecutionTime, taggedSQL, a)
+	} else if ct == nil {
+		res, err = s.executor(s.db).ExecContext(ctx, taggedSQL, a...)
+	} else {
+		res, err = ct.(*transaction).exec.ExecContext(ctx, taggedSQL, a...)
 	}
 
-	if ct == nil {
-		res, err = s.db.Exec(sql, a...)
-	} else {
-		res, err = ct.(*transaction).tx.Exec(sql, a...)
+	var rowsAffected int64
+	if err == nil {
+		s.writeDone(ctx)
+		rowsAffected, _ = res.RowsAffected()
+	}
+	s.statementDone(ctx, sql, a, start, rowsAffected, err)
+	endSpan(span, err)
+	if err != nil {
+		info := newQueryHookInfo(ctx, sql, a)
+		return nil, s.statementError(ctx, info.Model, info.Op, sql, a, err)
 	}
 
-	return res, err
+	return res, nil
+}
+
+// isWriteQuery reports whether the statement of the context writes the rows of the model
+func isWriteQuery(ctx context.Context) bool {
+	info, ok := ctx.Value(ctx_query_key).(queryInfo)
+
+	return ok && info.model != "" && info.op != "select"
+}
+
+// writeDone invalidates the cache of the model written by the statement of the context
+func (s *MySQL) writeDone(ctx context.Context) {
+	s.markWrite(ctx)
+
+	if !isWriteQuery(ctx) {
+		return
+	}
+	info := ctx.Value(ctx_query_key).(queryInfo)
+	s.invalidateCache(info.model)
+	if t, ok := ctx.Value(s.transactionKey()).(*transaction); ok {
+		// The other transactions can cache the old rows until the commit
+		t.addModified(info.model)
+	}
 }
 
 func (s *MySQL) RawQuery(ctx context.Context, query string, a ...interface{}) (*sql.Rows, error) {
-	sqlBuf := &SqlBuffer{Buffer: bytes.NewBufferString(query), args: a}
+	if len(s.queryHooks) == 0 {
+		return s.rawQuery(ctx, query, a)
+	}
 
-	ctx = timelog.Start(ctx, sqlBuf)
+	var res *sql.Rows
+	info := newQueryHookInfo(ctx, query, a)
+	_, err := s.withHooks(ctx, info, func(ctx context.Context) (driver.Result, error) {
+		var err error
+		res, err = s.rawQuery(ctx, info.SQL, info.Args)
+		return nil, err
+	})
+
+	return res, err
+}
+
+// rawQuery runs the query, all the queries passed to RawQuery are executed by it
+func (s *MySQL) rawQuery(ctx context.Context, query string, a []interface{}) (*sql.Rows, error) {
+	a, err := s.convertArgs(a)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkPlaceholders(a); err != nil {
+		return nil, err
+	}
+	if isWriteQuery(ctx) {
+		if err := s.checkSnapshotWrite(ctx, query); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.checkImplicitCommit(ctx, query); err != nil {
+		return nil, err
+	}
+	if err := s.checkQueryBudget(ctx); err != nil {
+		return nil, err
+	}
+	query = withMaxExecutionTimeHint(query, s.maxExecutionTimeOf(ctx))
+	ctx = timelog.Start(ctx, recordedStatement{s, query, a})
 	defer timelog.Finish(ctx)
+	span := s.startStatementSpan(ctx, query)
+	start := s.now()
 
 	ct := ctx.Value(s.transactionKey())
 
-	var (
-		res *sql.Rows
-		err error
-	)
+	var res *sql.Rows
 
-	if debugSQL {
-		println(sqlBuf.String())
-	}
+	defer func() {
+		s.statementDone(ctx, query, a, start, -1, err)
+		endSpan(span, err)
+	}()
 
-	if ct == nil {
-		res, err = s.db.Query(query, a...)
+	taggedQuery := s.tagQuery(ctx, query)
+	isWrite := isWriteQuery(ctx)
+	if ct == nil && isWrite {
+		// The writes returning the rows are sent to the primary server and never retried
+		res, err = s.executor(s.db).QueryContext(ctx, taggedQuery, a...)
+	} else if ct == nil {
+		res, err = s.queryWithRetry(ctx, taggedQuery, a)
 	} else {
-		res, err = ct.(*transaction).tx.Query(query, a...)
+		res, err = ct.(*transaction).exec.QueryContext(ctx, taggedQuery, a...)
+	}
+	if err == nil && isWrite {
+		s.writeDone(ctx)
 	}
 
-	return res, err
+	if err != nil {
+		info := newQueryHookInfo(ctx, query, a)
+		return nil, s.statementError(ctx, info.Model, info.Op, query, a, err)
+	}
+
+	return res, nil
 }
 
 func (s *MySQL) Add(ctx context.Context, m model.IModel, data *model.Data, opts model.AddOptions) (*model.Data, error) {
-	sqlBuf := NewSqlBuffer()
-
-	sqlBuf.WriteString("INSERT INTO ")
-	sqlBuf.WriteIdentifier(m.GetId())
-
-	sqlBuf.WriteByte('(')
-	sqlBuf.WriteIdentifiersList(data.Fields())
-	sqlBuf.WriteByte(')')
+	if w := s.getWriteBehind(ctx, m.GetId()); w != nil {
+		data, err := s.ScopedSqlBuffer(ctx).scopeData(s, m, data)
+		if err != nil {
+			return nil, err
+		}
+		return w.add(data, opts)
+	}
 
-	sqlBuf.WriteString("VALUES")
+	return s.insert(ctx, m, data, opts)
+}
 
-	for i, row := range data.Data() {
-		if i != 0 {
-			sqlBuf.WriteByte(',')
+// insert inserts the rows and returns their primary keys, the inserted rows are captured for the write observers,
+// audited and passed to the write callbacks
+func (s *MySQL) insert(ctx context.Context, m model.IModel, data *model.Data, opts model.AddOptions) (*model.Data, error) {
+	if spec := s.shardSpec(m); spec.Count > 0 {
+		if _, ok := routedShard(ctx, m); !ok {
+			return s.insertSharded(ctx, m, spec, data, opts)
 		}
-		sqlBuf.WriteByte('(')
-		sqlBuf.WriteValuesList(row)
-		sqlBuf.WriteByte(')')
 	}
 
-	if opts.Replace {
-		sqlBuf.WriteString("ON DUPLICATE KEY UPDATE ")
-		for i, fieldName := range data.Fields() {
-			if i > 0 {
-				sqlBuf.WriteByte(',')
+	callbacks := s.writeCallbacks(m)
+	var res *model.Data
+	err := s.captureWrite(ctx, m, func(ctx context.Context) error {
+		var err error
+		callbacksCtx := ctx
+		if len(callbacks) > 0 {
+			if callbacksCtx, err = s.callbacksContext(ctx, m); err != nil {
+				return err
+			}
+			if data, err = beforeAdd(callbacksCtx, callbacks, data); err != nil {
+				return err
 			}
-			sqlBuf.WriteIdentifier(fieldName)
-			sqlBuf.WriteString("=VALUES(")
-			sqlBuf.WriteIdentifier(fieldName)
-			sqlBuf.WriteByte(')')
 		}
+
+		if res, err = s.insertRows(ctx, m, data, opts); err != nil {
+			return err
+		}
+		if err := s.checkWarnings(ctx, m); err != nil {
+			return err
+		}
+		if err := s.recordChanges(ctx, m, "insert", res, func(i int) map[string]interface{} {
+			return changedFields(data.Fields(), data.Data()[i])
+		}); err != nil {
+			return err
+		}
+		if err := s.auditInsert(ctx, m, data, res); err != nil {
+			return err
+		}
+		return afterWrite(callbacksCtx, callbacks, "insert", res, func(i int) map[string]interface{} {
+			return changedFields(data.Fields(), data.Data()[i])
+		})
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	execRes, err := s.Exec(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+	return res, nil
+}
+
+func (s *MySQL) insertRows(ctx context.Context, m model.IModel, data *model.Data, opts model.AddOptions) (*model.Data, error) {
+	sqlBuf := s.ScopedSqlBuffer(ctx)
+	data, err := s.writeInsertSQL(sqlBuf, m, data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	execRes, err := s.Exec(withQuery(ctx, m.GetId(), "insert"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
 	if err != nil {
 		return nil, err
 	}
@@ -224,6 +533,11 @@ func (s *MySQL) Add(ctx context.Context, m model.IModel, data *model.Data, opts
 	}
 
 	lastInsertId, _ := execRes.LastInsertId()
+	if dryRunRes, ok := execRes.(*dryRunResult); ok && hasAutoIncrementedPK(m) {
+		lastInsertId = dryRunRes.reserveIds(data.Len())
+	}
+	// The driver returns the unsigned ids above math.MaxInt64 as negative int64 values with the same bits
+	insertId := uint64(lastInsertId)
 
 	res := make([][]interface{}, data.Len())
 	for i, row := range data.Data() {
@@ -240,25 +554,25 @@ func (s *MySQL) Add(ctx context.Context, m model.IModel, data *model.Data, opts
 			if (!exists || isNil(rowRes[j])) && m.GetFieldDefinition(fieldName).(IMysqlFieldDefinition).IsAutoIncremented() {
 				switch m.GetFieldDefinition(fieldName).GetType().Kind() {
 				case reflect.Int8:
-					rowRes[j] = int8(lastInsertId)
+					rowRes[j] = int8(insertId)
 				case reflect.Int16:
-					rowRes[j] = int16(lastInsertId)
+					rowRes[j] = int16(insertId)
 				case reflect.Int32:
-					rowRes[j] = int32(lastInsertId)
+					rowRes[j] = int32(insertId)
 				case reflect.Int64:
-					rowRes[j] = int64(lastInsertId)
+					rowRes[j] = int64(insertId)
 				case reflect.Uint8:
-					rowRes[j] = uint8(lastInsertId)
+					rowRes[j] = uint8(insertId)
 				case reflect.Uint16:
-					rowRes[j] = uint16(lastInsertId)
+					rowRes[j] = uint16(insertId)
 				case reflect.Uint32:
-					rowRes[j] = uint32(lastInsertId)
+					rowRes[j] = uint32(insertId)
 				case reflect.Uint64:
-					rowRes[j] = uint64(lastInsertId)
+					rowRes[j] = uint64(insertId)
 				default:
 					panic("Not implemented")
 				}
-				lastInsertId++
+				insertId++
 			}
 		}
 		res[i] = rowRes
@@ -267,12 +581,151 @@ func (s *MySQL) Add(ctx context.Context, m model.IModel, data *model.Data, opts
 	return model.NewData(m.GetPKFieldsNames(), res), nil
 }
 
+// writeInsertSQL writes the INSERT of the rows with the encoded and converted values, the returned rows have
+// the values of the scoped fields
+func (s *MySQL) writeInsertSQL(sqlBuf *SqlBuffer, m model.IModel, data *model.Data, opts model.AddOptions) (*model.Data, error) {
+	data, err := sqlBuf.scopeData(s, m, data)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = blindIndexData(m, data); err != nil {
+		return nil, err
+	}
+	if err := checkFields(m, data.Fields(), PartValue); err != nil {
+		return nil, err
+	}
+	if err := checkPKPresent(m, data); err != nil {
+		return nil, err
+	}
+
+	sqlBuf.WriteString("INSERT INTO ")
+	sqlBuf.WriteTable(m)
+
+	sqlBuf.WriteByte('(')
+	sqlBuf.WriteIdentifiersList(data.Fields())
+	sqlBuf.WriteByte(')')
+
+	sqlBuf.WriteString("VALUES")
+
+	loc := s.exprProcessor.Location
+	fields := make([]model.IFieldDefinition, len(data.Fields()))
+	encoded := make([]IEncodedFieldDefinition, len(data.Fields()))
+	converted := make([]bool, len(data.Fields()))
+	hasEncoded := false
+	for i, fieldName := range data.Fields() {
+		fields[i] = m.GetFieldDefinition(fieldName)
+		encoded[i], converted[i] = encodedField(fields[i]), isConvertedField(fields[i], loc)
+		hasEncoded = hasEncoded || encoded[i] != nil || converted[i]
+	}
+
+	for i, row := range data.Data() {
+		if i != 0 {
+			sqlBuf.WriteByte(',')
+		}
+		if hasEncoded {
+			encodedRow := make([]interface{}, len(row))
+			for j, value := range row {
+				if encoded[j] != nil {
+					var err error
+					if value, err = encoded[j].EncodeValue(value); err != nil {
+						return nil, err
+					}
+				}
+				if converted[j] {
+					value = fieldValue(fields[j], value, loc)
+				}
+				encodedRow[j] = value
+			}
+			row = encodedRow
+		}
+		sqlBuf.WriteByte('(')
+		sqlBuf.WriteValuesList(row)
+		sqlBuf.WriteByte(')')
+	}
+
+	if opts.Replace {
+		// The duplicate row out of the scope is left as is
+		scope := sqlBuf.scoped(s, m, nil)
+		sqlBuf.WriteString("ON DUPLICATE KEY UPDATE ")
+		for i, fieldName := range data.Fields() {
+			if i > 0 {
+				sqlBuf.WriteByte(',')
+			}
+			sqlBuf.WriteIdentifier(fieldName)
+			if scope != nil {
+				sqlBuf.WriteString("=IF(")
+				scope.GetProcessor(s.exprProcessor.ForModel(m)).(WriteFunc)(sqlBuf)
+				sqlBuf.WriteString(",VALUES(")
+				sqlBuf.WriteIdentifier(fieldName)
+				sqlBuf.WriteString("),")
+				sqlBuf.WriteIdentifier(fieldName)
+				sqlBuf.WriteByte(')')
+				continue
+			}
+			sqlBuf.WriteString("=VALUES(")
+			sqlBuf.WriteIdentifier(fieldName)
+			sqlBuf.WriteByte(')')
+		}
+	}
+
+	return data, sqlBuf.Err()
+}
+
 func (s *MySQL) Query(ctx context.Context, m model.IModel, fieldsNames []string, options model.GetAllOptions) (*model.Data, error) {
-	sqlBuf := NewSqlBuffer()
+	return s.Select(ctx, m, fieldsNames, SelectOptions{GetAllOptions: options})
+}
+
+// SelectOptions extends model.GetAllOptions with the parts of SELECT which are specific for MySQL
+type SelectOptions struct {
+	model.GetAllOptions
+	// Columns are the computed columns returned in addition to the fields under their aliases
+	Columns []Column
+	GroupBy []model.IExpression
+	Having  model.IExpression
+	// OuterFilter wraps the query into the derived table named as the model table and filters its rows, so it may
+	// refer to the computed columns (e.g. the window functions results) with ColumnAlias. ORDER BY, LIMIT
+	// and SQL_CALC_FOUND_ROWS apply to the outer query then, the ordered fields must be selected.
+	OuterFilter model.IExpression
+	// OrderBy terms are applied after the GetAllOptions.OrderBy ones
+	OrderBy []OrderExpr
+	// Children are loaded after the rows and returned in the columns named by Children.Key,
+	// the primary key of the model must be requested then
+	Children []Children
+	// Parents fields are returned in the columns prefixed with Parent.Prefix, e.g. "user.name"
+	Parents []Parent
+	// Alias is the alias of the table of the model, e.g. for the symmetric conditions of the self-joins,
+	// the columns of the model are qualified with it
+	Alias string
+	// Joins are the tables joined under their aliases, see Join. They cannot be used with OuterFilter.
+	Joins []TableJoin
+	// MaxExecutionTime is written as the MAX_EXECUTION_TIME hint, the storage or the context one is used by Select
+	// if it is not set
+	MaxExecutionTime time.Duration
+	// LockWait is written after FOR UPDATE, NOWAIT and SKIP LOCKED require MySQL 8.0 or MariaDB 10.6
+	LockWait LockWait
+}
+
+type Column struct {
+	Alias string
+	Expr  model.IExpression
+}
+
+type OrderExpr struct {
+	Expr  model.IExpression
+	Desc  bool
+	Nulls NullsOrder
+}
+
+func (s *MySQL) WriteSelectSQL(sqlBuf *SqlBuffer, m model.IModel, fieldsNames []string, options SelectOptions) {
+	p := s.exprProcessor.ForModel(m)
 
 	sqlBuf.WriteString("SELECT ")
 
-	if options.Distinct {
+	if options.MaxExecutionTime > 0 {
+		writeMaxExecutionTimeHint(sqlBuf, options.MaxExecutionTime)
+	}
+
+	if options.Distinct && options.OuterFilter == nil {
 		sqlBuf.WriteString(" DISTINCT ")
 	}
 
@@ -280,39 +733,232 @@ func (s *MySQL) Query(ctx context.Context, m model.IModel, fieldsNames []string,
 		sqlBuf.WriteString(" SQL_CALC_FOUND_ROWS ")
 	}
 
-	sqlBuf.WriteIdentifiersList(fieldsNames)
+	if options.OuterFilter != nil {
+		if options.ForUpdate {
+			sqlBuf.SetError(qerror.Errorf("The rows of the derived table of OuterFilter cannot be locked"))
+		}
+		sqlBuf.WriteString("* FROM (SELECT ")
+		if options.Distinct {
+			sqlBuf.WriteString("DISTINCT ")
+		}
+	}
+
+	table := TableName(m)
+	joined := options.Alias != "" || len(options.Joins) > 0
+	for _, parent := range options.Parents {
+		joined = joined || parent.Strategy == ParentJoin
+	}
+	if options.Alias != "" {
+		if err := ValidateIdentifier(options.Alias); err != nil {
+			sqlBuf.SetError(qerror.Errorf("Invalid alias of the table of model '%s': %s", m.GetId(), err.Error()))
+		}
+		table = options.Alias
+	}
+	if len(options.Joins) > 0 {
+		if options.OuterFilter != nil {
+			sqlBuf.SetError(qerror.Errorf("The joined tables cannot be used with OuterFilter"))
+		}
+		tables, err := s.statementTables(m, table, options.Joins)
+		if err != nil {
+			// The invalid joins cannot be written
+			sqlBuf.SetError(err)
+			return
+		}
+		p.tables = tables
+	} else if options.Alias != "" {
+		p.tables = map[string]model.IModel{table: m}
+	}
+	if err := checkFields(m, fieldsNames, PartSelect); err != nil {
+		sqlBuf.SetError(err)
+	}
+	if joined {
+		// The fields of the joined tables may have the same names
+		p.qualify = true
+		if options.Alias != "" {
+			p.alias = options.Alias
+		}
+		for i, name := range fieldsNames {
+			if i > 0 {
+				sqlBuf.WriteByte(',')
+			}
+			sqlBuf.WriteString(QuoteIdent(table, name))
+		}
+	} else {
+		sqlBuf.WriteIdentifiersList(fieldsNames)
+	}
+
+	for i, column := range options.Columns {
+		if i > 0 || len(fieldsNames) > 0 {
+			sqlBuf.WriteByte(',')
+		}
+		if m.GetFieldDefinition(column.Alias) != nil {
+			sqlBuf.SetError(qerror.Errorf("The column alias '%s' conflicts with the field of model '%s'", column.Alias, m.GetId()))
+		}
+		column.Expr.GetProcessor(p.inPart(PartSelect)).(WriteFunc)(sqlBuf)
+		sqlBuf.WriteString(" AS ")
+		sqlBuf.WriteIdentifier(column.Alias)
+	}
+
+	s.writeParentsColumns(sqlBuf, m, options.Parents)
+	s.writeJoinsColumns(sqlBuf, options.Joins)
+
 	sqlBuf.WriteString(" FROM ")
-	sqlBuf.WriteIdentifier(m.GetId())
+	// The shard table is named as the model table for the qualified columns
+	if sharded := sqlBuf.writeTable(m); options.Alias != "" {
+		sqlBuf.WriteString(" AS ")
+		sqlBuf.WriteIdentifier(options.Alias)
+	} else if sharded && joined {
+		sqlBuf.WriteString(" AS ")
+		sqlBuf.WriteIdentifier(table)
+	}
+	s.writeParentsJoins(sqlBuf, m, table, options.Parents)
+	s.writeJoins(sqlBuf, p, options.Joins)
 
-	if options.Filter != nil {
+	if filter := sqlBuf.scoped(s, m, options.Filter); filter != nil {
 		sqlBuf.WriteString(" WHERE ")
-		options.Filter.GetProcessor(exprProcessor).(WriteFunc)(sqlBuf)
+		filter.GetProcessor(p).(WriteFunc)(sqlBuf)
+	}
+
+	if len(options.GroupBy) > 0 {
+		sqlBuf.WriteString(" GROUP BY ")
+		for i, e := range options.GroupBy {
+			if i > 0 {
+				sqlBuf.WriteByte(',')
+			}
+			e.GetProcessor(p.inPart(PartGroup)).(WriteFunc)(sqlBuf)
+		}
 	}
 
-	if len(options.OrderBy) > 0 {
+	if options.Having != nil {
+		sqlBuf.WriteString(" HAVING ")
+		options.Having.GetProcessor(p).(WriteFunc)(sqlBuf)
+	}
+
+	if options.OuterFilter != nil {
+		sqlBuf.WriteString(") AS ")
+		sqlBuf.WriteIdentifier(table)
+		sqlBuf.WriteString(" WHERE ")
+		options.OuterFilter.GetProcessor(p).(WriteFunc)(sqlBuf)
+	}
+
+	if len(options.GetAllOptions.OrderBy)+len(options.OrderBy) > 0 {
 		sqlBuf.WriteString(" ORDER BY ")
-		for i, order := range options.OrderBy {
+		for i, order := range options.GetAllOptions.OrderBy {
 			if i > 0 {
 				sqlBuf.WriteString(",")
 			}
-			sqlBuf.WriteIdentifier(order.FieldName)
+			if m.GetFieldDefinition(order.FieldName) == nil {
+				sqlBuf.SetError(unknownFieldError(m, order.FieldName, PartOrder))
+			}
+			if joined {
+				sqlBuf.WriteString(QuoteIdent(table, order.FieldName))
+			} else {
+				sqlBuf.WriteIdentifier(order.FieldName)
+			}
 			if order.Desc {
 				sqlBuf.WriteString(" DESC")
 			}
 		}
+		for i, order := range options.OrderBy {
+			if i > 0 || len(options.GetAllOptions.OrderBy) > 0 {
+				sqlBuf.WriteString(",")
+			}
+			writeOrder(sqlBuf, p.inPart(PartOrder), order)
+		}
+		for _, fieldName := range s.orderTiebreaker(m, options) {
+			sqlBuf.WriteString(",")
+			if joined {
+				sqlBuf.WriteString(QuoteIdent(table, fieldName))
+			} else {
+				sqlBuf.WriteIdentifier(fieldName)
+			}
+		}
+	} else if testOrder := s.testModeOrder(m, fieldsNames, options); len(testOrder) > 0 {
+		sqlBuf.WriteString(" ORDER BY ")
+		for i, fieldName := range testOrder {
+			if i > 0 {
+				sqlBuf.WriteString(",")
+			}
+			if joined {
+				sqlBuf.WriteString(QuoteIdent(table, fieldName))
+			} else {
+				sqlBuf.WriteIdentifier(fieldName)
+			}
+		}
 	}
 
 	if options.Limit > 0 {
 		sqlBuf.WriteString(" LIMIT ")
-		sqlBuf.WriteString(strconv.FormatUint(options.Limit, 10))
+		sqlBuf.WriteLiteral(options.Limit, LiteralOpts{})
 		if options.Offset > 0 {
 			sqlBuf.WriteString(" OFFSET ")
-			sqlBuf.WriteString(strconv.FormatUint(options.Offset, 10))
+			sqlBuf.WriteLiteral(options.Offset, LiteralOpts{})
 		}
 	}
 
 	if options.ForUpdate {
 		sqlBuf.WriteString(" FOR UPDATE")
+		writeLockWait(sqlBuf, options.LockWait)
+	} else if options.LockWait != LockWaitDefault {
+		sqlBuf.SetError(qerror.Errorf("The lock wait option requires ForUpdate"))
+	}
+}
+
+// Select is Query with the MySQL specific options, the computed columns are returned under their aliases
+func (s *MySQL) Select(ctx context.Context, m model.IModel, fieldsNames []string, options SelectOptions) (*model.Data, error) {
+	if spec := s.shardSpec(m); spec.Count > 0 {
+		if _, ok := routedShard(ctx, m); !ok {
+			return s.selectSharded(ctx, m, spec, fieldsNames, options)
+		}
+	}
+
+	requested := fieldsNames
+
+	// The foreign keys of the batch requested parents are requested but not returned
+	var hidden []string
+	for _, parent := range options.Parents {
+		if parent.Strategy == ParentBatch && !containsString(fieldsNames, parent.FkFieldName) && !containsString(hidden, parent.FkFieldName) {
+			hidden = append(hidden, parent.FkFieldName)
+		}
+	}
+	if len(hidden) > 0 {
+		fieldsNames = append(fieldsNames[:len(fieldsNames):len(fieldsNames)], hidden...)
+	}
+
+	if options.MaxExecutionTime == 0 {
+		options.MaxExecutionTime = s.maxExecutionTimeOf(ctx)
+	}
+
+	sqlBuf := s.ScopedSqlBuffer(ctx)
+	s.WriteSelectSQL(sqlBuf, m, fieldsNames, options)
+
+	if err := sqlBuf.Err(); err != nil {
+		return nil, err
+	}
+	if sqlBuf.windowFunctions {
+		if err := s.checkWindowFunctions(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if options.LockWait != LockWaitDefault {
+		if err := s.checkLockWait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if args := len(sqlBuf.GetArgs()); args > s.placeholdersBudget() {
+		if sqlBuf.windowFunctions {
+			return nil, fmt.Errorf("%w: the query with the window functions cannot be split", ErrTooManyPlaceholders)
+		}
+		return s.selectSplit(ctx, m, requested, options, args)
+	}
+
+	cache, cacheTTL, cacheKey := s.selectCache(ctx, m, sqlBuf, options)
+	if cache != nil {
+		if cached, ok := cache.Get(cacheKey); ok {
+			return maskData(ctx, m, cloneData(cached.(*model.Data))), nil
+		}
+		// The replicas may lag behind the write which changed the cache namespace
+		ctx = ForcePrimary(ctx)
 	}
 
 	if options.RowsWoLimit != nil {
@@ -321,108 +967,419 @@ func (s *MySQL) Query(ctx context.Context, m model.IModel, fieldsNames []string,
 			return nil, err
 		}
 	}
-	rows, err := s.RawQuery(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+	rows, err := s.RawQuery(withQuery(ctx, m.GetId(), "select"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	columnsNames, err := rows.Columns()
+	res, err := scanData(rows, func(_ int, name string) model.IFieldDefinition {
+		if field := m.GetFieldDefinition(name); field != nil {
+			return field
+		}
+		if field := parentColumnField(options.Parents, name); field != nil {
+			return field
+		}
+		return joinColumnField(options.Joins, name)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	res := model.NewEmptyData(columnsNames)
-
-	for rows.Next() {
-		rawRow := make([]interface{}, len(columnsNames))
-		for i, name := range columnsNames {
-			field := m.GetFieldDefinition(name)
+	if cache != nil {
+		cache.Set(cacheKey, cloneData(res), cacheTTL)
+	}
 
-			rawRow[i] = reflect.New(field.GetType()).Interface()
+	if options.RowsWoLimit != nil {
+		rows, err := s.RawQuery(withQuery(ctx, m.GetId(), "select"), "SELECT FOUND_ROWS()")
+		if err != nil {
+			return nil, err
 		}
 
-		err := rows.Scan(rawRow...)
+		rows.Next()
+		rows.Scan(options.RowsWoLimit)
+		rows.Next()
+
+		ctx, err = s.Commit(ctx)
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		row := make([]interface{}, len(columnsNames))
-		for i, _ := range columnsNames {
-			row[i] = reflect.ValueOf(rawRow[i]).Elem().Interface()
+	if len(options.Parents) > 0 {
+		if res, err = s.attachParents(ctx, m, res, options.Parents, hidden); err != nil {
+			return nil, err
 		}
-		res.Add(row)
 	}
 
-	if options.RowsWoLimit != nil {
-		rows, err := s.RawQuery(ctx, "SELECT FOUND_ROWS()")
-		if err != nil {
+	if len(options.Children) > 0 {
+		if res, err = s.loadChildren(ctx, m, res, options.Children); err != nil {
 			return nil, err
 		}
+	}
 
-		rows.Next()
-		rows.Scan(options.RowsWoLimit)
-		rows.Next()
+	return maskData(ctx, m, res), nil
+}
 
-		ctx, err = s.Commit(ctx)
-		if err != nil {
+// scanData reads the rows, the values of the columns with the fields are scanned into the field types,
+// the other ones are returned as is with []byte converted to string. The scan targets are allocated once
+// per query and the rows are cut from the shared slabs.
+func scanData(rows *sql.Rows, fieldOf func(i int, name string) model.IFieldDefinition) (*model.Data, error) {
+	columnsNames, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	columnsCount := len(columnsNames)
+
+	targets := make([]interface{}, columnsCount)
+	values := make([]reflect.Value, columnsCount)
+	isRaw := make([]bool, columnsCount)
+	decoders := make([]IEncodedFieldDefinition, columnsCount)
+	for i, name := range columnsNames {
+		if field := fieldOf(i, name); field != nil {
+			target := reflect.New(field.GetType())
+			targets[i], values[i] = target.Interface(), target.Elem()
+			decoders[i] = encodedField(field)
+		} else {
+			targets[i], isRaw[i] = new(interface{}), true
+		}
+	}
+
+	var (
+		data [][]interface{}
+		slab []interface{}
+	)
+	for rows.Next() {
+		if err := rows.Scan(targets...); err != nil {
 			return nil, err
 		}
+
+		if len(slab) < columnsCount {
+			slab = make([]interface{}, columnsCount*scanSlabRows)
+		}
+		row := slab[:columnsCount:columnsCount]
+		slab = slab[columnsCount:]
+
+		for i := range row {
+			if decoders[i] != nil {
+				if row[i], err = decoders[i].DecodeValue(values[i].Interface()); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			if !isRaw[i] {
+				row[i] = values[i].Interface()
+				continue
+			}
+
+			value := *targets[i].(*interface{})
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			row[i] = value
+		}
+		data = append(data, row)
 	}
 
-	return res, nil
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return model.NewData(columnsNames, data), nil
 }
 
-func (s *MySQL) Edit(ctx context.Context, m model.IModel, filter model.IExpression, newValues map[string]interface{}) error {
-	sqlBuf := NewSqlBuffer()
+// scanSlabRows is the number of the rows allocated at once by scanData
+const scanSlabRows = 64
+
+// WriteUpdateSQL writes the UPDATE statement, a new value which is a model.IExpression is written as an expression,
+// e.g. SetFlag(field, mask)
+func (s *MySQL) WriteUpdateSQL(sqlBuf *SqlBuffer, m model.IModel, filter model.IExpression, newValues map[string]interface{}) {
+	p := s.exprProcessor.ForModel(m)
+
+	// The rows cannot be moved out of the scope
+	if _, err := sqlBuf.scopeRow(s, m, newValues); err != nil {
+		sqlBuf.SetError(err)
+	}
+
+	// The blind indexes of the encrypted fields are set along with them
+	if indexes, err := blindIndexes(m, newValues); err != nil {
+		sqlBuf.SetError(err)
+	} else if len(indexes) > 0 {
+		values := make(map[string]interface{}, len(newValues)+len(indexes))
+		for name, value := range newValues {
+			values[name] = value
+		}
+		for name, value := range indexes {
+			values[name] = value
+		}
+		newValues = values
+	}
 
 	sqlBuf.WriteString("UPDATE ")
-	sqlBuf.WriteIdentifier(m.GetId())
+	sqlBuf.WriteTable(m)
 	sqlBuf.WriteString(" SET ")
-	first := true
-	for name, value := range newValues {
-		if first {
-			first = false
-		} else {
+
+	names := make([]string, 0, len(newValues))
+	for name := range newValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := checkFields(m, names, PartValue); err != nil {
+		sqlBuf.SetError(err)
+	}
+	for i, name := range names {
+		if i > 0 {
 			sqlBuf.WriteString(", ")
 		}
 		sqlBuf.WriteIdentifier(name)
 		sqlBuf.WriteByte('=')
-		sqlBuf.WriteValue(value)
+		if e, ok := newValues[name].(model.IExpression); ok {
+			e.GetProcessor(p.inPart(PartValue)).(WriteFunc)(sqlBuf)
+		} else if encoded := encodedField(m.GetFieldDefinition(name)); encoded != nil {
+			value, err := encoded.EncodeValue(newValues[name])
+			if err != nil {
+				sqlBuf.SetError(err)
+			}
+			sqlBuf.WriteValue(fieldValue(m.GetFieldDefinition(name), value, p.Location))
+		} else {
+			sqlBuf.WriteValue(fieldValue(m.GetFieldDefinition(name), newValues[name], p.Location))
+		}
 	}
 
-	if filter != nil {
+	if filter := sqlBuf.scoped(s, m, filter); filter != nil {
 		sqlBuf.WriteString(" WHERE ")
-		filter.GetProcessor(exprProcessor).(WriteFunc)(sqlBuf)
+		filter.GetProcessor(p).(WriteFunc)(sqlBuf)
 	}
+}
 
-	_, err := s.Exec(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+func (s *MySQL) Edit(ctx context.Context, m model.IModel, filter model.IExpression, newValues map[string]interface{}) error {
+	_, err := s.execEdit(ctx, m, filter, newValues, EditWhereOpts{})
 
 	return err
 }
 
+// execEdit updates the rows, the updated rows are captured for the write observers, audited and passed
+// to the write callbacks
+func (s *MySQL) execEdit(ctx context.Context, m model.IModel, filter model.IExpression, newValues map[string]interface{}, opts EditWhereOpts) (driver.Result, error) {
+	if spec := s.shardSpec(m); spec.Count > 0 {
+		if _, ok := routedShard(ctx, m); !ok {
+			return s.editSharded(ctx, m, spec, filter, newValues, opts)
+		}
+	}
+
+	callbacks := s.writeCallbacks(m)
+	writeSQL := func(filter model.IExpression) *SqlBuffer {
+		sqlBuf := s.ScopedSqlBuffer(ctx)
+		s.WriteUpdateSQL(sqlBuf, m, filter, newValues)
+		s.writeEditLimit(sqlBuf, m, opts)
+		return sqlBuf
+	}
+	sqlBuf := writeSQL(filter)
+
+	if err := sqlBuf.Err(); err != nil {
+		return nil, err
+	}
+
+	var res driver.Result
+	err := s.captureWrite(ctx, m, func(ctx context.Context) error {
+		callbacksCtx := ctx
+		if len(callbacks) > 0 {
+			var err error
+			if callbacksCtx, err = s.callbacksContext(ctx, m); err != nil {
+				return err
+			}
+			if newValues, err = beforeEdit(callbacksCtx, callbacks, newValues); err != nil {
+				return err
+			}
+			if sqlBuf = writeSQL(filter); sqlBuf.Err() != nil {
+				return sqlBuf.Err()
+			}
+		}
+
+		if opts.Limit > 0 && (s.capturesWrite(ctx, m) || s.auditOf(ctx, m) != nil || hasAfterCallbacks(ctx, callbacks, "update")) {
+			pksFilter, err := s.limitedPKsFilter(ctx, m, filter, opts)
+			if err != nil {
+				return err
+			}
+			if pksFilter == nil {
+				res = driver.RowsAffected(0)
+				return nil
+			}
+			filter, sqlBuf = pksFilter, writeSQL(pksFilter)
+			if err := sqlBuf.Err(); err != nil {
+				return err
+			}
+		}
+
+		pks, err := s.changedPKs(ctx, m, "update", filter)
+		if err != nil {
+			return err
+		}
+		fieldsNames := make([]string, 0, len(newValues))
+		for name := range newValues {
+			fieldsNames = append(fieldsNames, name)
+		}
+		sort.Strings(fieldsNames)
+		before, err := s.auditImage(ctx, m, filter, fieldsNames)
+		if err != nil {
+			return err
+		}
+
+		if res, err = s.Exec(withQuery(ctx, m.GetId(), "update"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...); err != nil {
+			return err
+		}
+		if err := s.checkWarnings(ctx, m); err != nil {
+			return err
+		}
+
+		if err := s.recordChanges(ctx, m, "update", pks, func(int) map[string]interface{} {
+			return changedValues(newValues)
+		}); err != nil {
+			return err
+		}
+		if err := s.auditEdit(ctx, m, before, newValues); err != nil {
+			return err
+		}
+		return afterWrite(callbacksCtx, callbacks, "update", pks, func(int) map[string]interface{} {
+			return changedValues(newValues)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
 func (s *MySQL) Delete(ctx context.Context, m model.IModel, filter model.IExpression) error {
-	sqlBuf := NewSqlBuffer()
+	_, err := s.execDelete(ctx, m, filter)
+
+	return err
+}
+
+// execDelete deletes the rows, the deleted rows are captured for the write observers, audited and passed
+// to the write callbacks
+func (s *MySQL) execDelete(ctx context.Context, m model.IModel, filter model.IExpression) (driver.Result, error) {
+	if spec := s.shardSpec(m); spec.Count > 0 {
+		if _, ok := routedShard(ctx, m); !ok {
+			return s.deleteSharded(ctx, m, spec, filter)
+		}
+	}
+
+	callbacks := s.writeCallbacks(m)
+	sqlBuf := s.ScopedSqlBuffer(ctx)
 
 	sqlBuf.WriteString("DELETE FROM ")
-	sqlBuf.WriteIdentifier(m.GetId())
+	sqlBuf.WriteTable(m)
 
-	if filter != nil {
+	if filter := sqlBuf.scoped(s, m, filter); filter != nil {
 		sqlBuf.WriteString(" WHERE ")
-		filter.GetProcessor(exprProcessor).(WriteFunc)(sqlBuf)
+		filter.GetProcessor(s.exprProcessor.ForModel(m)).(WriteFunc)(sqlBuf)
+	}
+
+	if err := sqlBuf.Err(); err != nil {
+		return nil, err
 	}
 
-	_, err := s.Exec(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+	var res driver.Result
+	err := s.captureWrite(ctx, m, func(ctx context.Context) error {
+		callbacksCtx := ctx
+		if len(callbacks) > 0 {
+			var err error
+			if callbacksCtx, err = s.callbacksContext(ctx, m); err != nil {
+				return err
+			}
+		}
 
-	return err
+		pks, err := s.changedPKs(ctx, m, "delete", filter)
+		if err != nil {
+			return err
+		}
+		var fieldsNames []string
+		if a := s.auditOf(ctx, m); a != nil {
+			fieldsNames = a.fieldsNames
+		}
+		before, err := s.auditImage(ctx, m, filter, fieldsNames)
+		if err != nil {
+			return err
+		}
+
+		if res, err = s.Exec(withQuery(ctx, m.GetId(), "delete"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...); err != nil {
+			return err
+		}
+
+		if err := s.recordChanges(ctx, m, "delete", pks, nil); err != nil {
+			return err
+		}
+		if err := s.auditDelete(ctx, m, before); err != nil {
+			return err
+		}
+		return afterWrite(callbacksCtx, callbacks, "delete", pks, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
 }
 
+// QuoteIdentifier quotes the identifier, it is QuoteIdent of one part
 func QuoteIdentifier(identifier string) string {
-	return "`" + strings.Replace(identifier, "`", "``", -1) + "`"
+	return QuoteIdent(identifier)
+}
+
+// QuoteIdent quotes the dot separated parts of a qualified identifier, e.g. QuoteIdent("user", "id") is `user`.`id`.
+// The backticks in the parts are doubled. All the identifiers of the generated SQL are quoted by it, use it
+// to embed dynamic names into RawExpr.
+func QuoteIdent(parts ...string) string {
+	var buf strings.Builder
+	for i, part := range parts {
+		if i > 0 {
+			buf.WriteByte('.')
+		}
+		buf.WriteByte('`')
+		buf.WriteString(strings.ReplaceAll(part, "`", "``"))
+		buf.WriteByte('`')
+	}
+
+	return buf.String()
+}
+
+// MaxIdentifierLength is the max length of the table and column names in MySQL
+const MaxIdentifierLength = 64
+
+// ValidateIdentifier checks the model or field id used as the table or column name. The letters, the digits,
+// '_' and '$' are allowed, so the backticks, the control characters and the spaces are rejected.
+func ValidateIdentifier(id string) error {
+	if problem := identifierProblem(id); problem != "" {
+		return qerror.Errorf("%s", problem)
+	}
+
+	return nil
+}
+
+// identifierProblem returns the description of the invalid identifier or an empty string
+func identifierProblem(id string) string {
+	if id == "" {
+		return "The identifier is empty"
+	}
+	if utf8.RuneCountInString(id) > MaxIdentifierLength {
+		return fmt.Sprintf("The identifier '%s' is longer than %d characters", id, MaxIdentifierLength)
+	}
+	for _, r := range id {
+		if r == utf8.RuneError || !(unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '$') {
+			return fmt.Sprintf("The identifier %q contains the invalid character %q", id, r)
+		}
+	}
+
+	return ""
 }
 
+// Quote formats the value to show it, it panics for the unsupported types.
+//
+// Deprecated: use FormatLiteral, the strings quoted by Quote are not escaped for the default sql_mode
 func Quote(value interface{}) string {
-	// FixMe: Replace termporary solution
 	var v string
 
 	switch value := value.(type) {
@@ -1,20 +1,43 @@
 package mysql_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
 	"io/ioutil"
+	"math"
+	"math/rand"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/go-qbit/model"
 	"github.com/go-qbit/model/expr"
 	"github.com/go-qbit/model/relation"
 	"github.com/go-qbit/timelog"
+	mysqldriver "github.com/go-sql-driver/mysql"
 
 	mysql "github.com/go-qbit/storage-mysql"
 	"github.com/go-qbit/storage-mysql/test"
+	"github.com/go-qbit/storage-mysql/testutil"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -43,6 +66,8 @@ var (
 
 	_ = mysql.IMysqlFieldDefinition(&mysql.VarCharField{})
 	_ = mysql.IMysqlFieldDefinition(&mysql.CharField{})
+	_ = mysql.IMysqlFieldDefinition(&mysql.JSONField{})
+	_ = mysql.IMysqlFieldDefinition(&mysql.SetField{})
 )
 
 func init() {
@@ -452,29 +477,5857 @@ func (s *DBTestSuite) TestBaseModel_Edit() {
 }
 
 func (s *DBTestSuite) TestBaseModel_Delete() {
-	_, err := s.user.AddFromStructs(context.Background(), []struct {
-		Name     string
-		Lastname string
-	}{
-		{Name: "Ivan", Lastname: "Sidorov"},
-		{Name: "Petr", Lastname: "Ivanov"},
-		{Name: "James", Lastname: "Bond"},
-		{Name: "John", Lastname: "Connor"},
-		{Name: "Sara", Lastname: "Connor"},
+	s.storage.AddQueryHook(testutil.CommitGuard{})
+
+	testutil.WithRollback(s.T(), s.storage, func(ctx context.Context) {
+		_, err := s.user.AddFromStructs(ctx, []struct {
+			Name     string
+			Lastname string
+		}{
+			{Name: "Ivan", Lastname: "Sidorov"},
+			{Name: "Petr", Lastname: "Ivanov"},
+			{Name: "James", Lastname: "Bond"},
+			{Name: "John", Lastname: "Connor"},
+			{Name: "Sara", Lastname: "Connor"},
+		}, model.AddOptions{})
+		s.NoError(err)
+
+		s.NoError(s.storage.DoInTransaction(ctx, func(ctx context.Context) error {
+			return s.user.Delete(ctx, expr.Eq(s.user.FieldExpr("id"), expr.Value(3)))
+		}))
+
+		data, err := s.user.GetAll(ctx, []string{"id"}, model.GetAllOptions{
+			OrderBy: []model.Order{{"id", false}},
+		})
+		s.NoError(err)
+
+		s.Equal([]map[string]interface{}{
+			{"id": uint32(1)},
+			{"id": uint32(2)},
+			{"id": uint32(4)},
+			{"id": uint32(5)},
+		}, data.Maps())
+	})
+}
+
+func (s *DBTestSuite) TestManyToMany() {
+	ctx := context.Background()
+
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	group := test.NewGroup(storage)
+	membership := storage.AddManyToMany(user, group, mysql.ManyToManyOpts{
+		TableName:   "user_group",
+		ExtraFields: []mysql.IMysqlFieldDefinition{&mysql.VarCharField{Id: "role", Length: 16}},
+	})
+
+	if !s.NoError(storage.Connect(gotestDsn)) {
+		return
+	}
+	_, err := storage.Exec(ctx, "DROP DATABASE "+dbname)
+	s.NoError(err)
+	_, err = storage.Exec(ctx, "CREATE DATABASE "+dbname)
+	s.NoError(err)
+	if !s.NoError(storage.Connect(gotestDsn)) || !s.NoError(storage.InitDB(ctx)) {
+		return
+	}
+
+	_, err = user.AddFromStructs(ctx, []struct{ Name, Lastname string }{
+		{"Ivan", "Sidorov"}, {"Petr", "Ivanov"}, {"James", "Bond"},
 	}, model.AddOptions{})
 	s.NoError(err)
+	_, err = group.AddFromStructs(ctx, []struct{ Name string }{{"admins"}, {"users"}}, model.AddOptions{})
+	s.NoError(err)
+
+	s.NoError(membership.LinkWith(ctx, 1, 1, map[string]interface{}{"role": "owner"}))
+	s.NoError(membership.Link(ctx, 1, 2))
+	s.NoError(membership.Link(ctx, 1, 2)) // Idempotent
+	s.NoError(membership.Link(ctx, 2, 2))
+	s.NoError(membership.Link(ctx, 3, 1))
+	s.NoError(membership.Unlink(ctx, 3, 1))
+	s.Error(membership.LinkWith(ctx, 3, 1, map[string]interface{}{"unknown": 1}))
+
+	linked, err := membership.GetLinked(ctx, []interface{}{1, 2, 3}, []string{"name"}, nil)
+	if s.NoError(err) {
+		s.Len(linked, 2)
+		s.ElementsMatch([]map[string]interface{}{{"name": "admins"}, {"name": "users"}}, linked[1])
+		s.Equal([]map[string]interface{}{{"name": "users"}}, linked[2])
+	}
 
-	s.NoError(s.user.Delete(context.Background(), expr.Eq(s.user.FieldExpr("id"), expr.Value(3))))
+	linked, err = membership.GetLinked(ctx, []interface{}{1}, []string{"id"}, expr.Eq(group.FieldExpr("name"), expr.Value("admins")))
+	if s.NoError(err) {
+		s.Equal(map[interface{}][]map[string]interface{}{1: {{"id": uint32(1)}}}, linked)
+	}
 
-	data, err := s.user.GetAll(context.Background(), []string{"id"}, model.GetAllOptions{
+	// The relation is usable by the model layer as well
+	data, err := user.GetAll(ctx, []string{"id"}, model.GetAllOptions{
+		Filter:  expr.Any(user, group, expr.Eq(group.FieldExpr("name"), expr.Value("users"))),
 		OrderBy: []model.Order{{"id", false}},
 	})
+	if s.NoError(err) {
+		s.Equal([]map[string]interface{}{{"id": uint32(1)}, {"id": uint32(2)}}, data.Maps())
+	}
+}
+
+func (s *DBTestSuite) TestModel_Children() {
+	s.TestModel_Add()
+
+	s.storage.SetInChunkSize(2)
+	defer s.storage.SetInChunkSize(1000)
+
+	children := mysql.WithChildren(s.message, "fk_author_id", []string{"id"}, nil)
+	children.OrderBy = []model.Order{{FieldName: "id", Desc: true}}
+	children.Key = "messages"
+
+	data, err := s.storage.Select(context.Background(), s.user, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{OrderBy: []model.Order{{FieldName: "id"}}, Limit: 3},
+		Children:      []mysql.Children{children},
+	})
+	if s.NoError(err) {
+		s.Equal([]map[string]interface{}{
+			{"id": uint32(1), "messages": []map[string]interface{}{{"id": uint32(30)}, {"id": uint32(20)}, {"id": uint32(10)}}},
+			{"id": uint32(2), "messages": []map[string]interface{}{{"id": uint32(40)}}},
+			{"id": uint32(3), "messages": []map[string]interface{}{}},
+		}, data.Maps())
+	}
+
+	children.Limit = 2
+	data, err = s.storage.Select(context.Background(), s.user, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Filter: expr.Eq(s.user.FieldExpr("id"), expr.Value(1))},
+		Children:      []mysql.Children{children},
+	})
+	if s.NoError(err) {
+		s.Equal([]map[string]interface{}{
+			{"id": uint32(1), "messages": []map[string]interface{}{{"id": uint32(30)}, {"id": uint32(20)}}},
+		}, data.Maps())
+	}
+
+	_, err = s.storage.Select(context.Background(), s.user, []string{"name"}, mysql.SelectOptions{
+		Children: []mysql.Children{children},
+	})
+	s.Error(err)
+}
+
+func (s *DBTestSuite) TestModel_Parents() {
+	s.TestModel_Add()
+
+	for _, strategy := range []mysql.ParentStrategy{mysql.ParentJoin, mysql.ParentBatch} {
+		parent := mysql.WithParent(s.user, "fk_author_id", []string{"name", "fullname"})
+		parent.Strategy = strategy
+		parent.Prefix = "author"
+
+		data, err := s.storage.Select(context.Background(), s.message, []string{"id"}, mysql.SelectOptions{
+			GetAllOptions: model.GetAllOptions{
+				Filter:  expr.Ge(s.message.FieldExpr("id"), expr.Value(20)),
+				OrderBy: []model.Order{{FieldName: "id"}},
+			},
+			Parents: []mysql.Parent{parent},
+		})
+		if s.NoError(err) {
+			s.Equal([]map[string]interface{}{
+				{"id": uint32(20), "author.name": "Ivan", "author.fullname": "Ivan Sidorov"},
+				{"id": uint32(30), "author.name": "Ivan", "author.fullname": "Ivan Sidorov"},
+				{"id": uint32(40), "author.name": "Petr", "author.fullname": "Petr Ivanov"},
+			}, data.Maps())
+		}
+	}
+}
+
+func TestMySQL_WriteSelectSQL_Parents(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	message := test.NewMessage(storage)
+	relation.AddManyToOne(message, user, relation.WithRequired(true), relation.WithAlias("author"))
+
+	parent := mysql.WithParent(user, "fk_author_id", []string{"name", "fullname"})
+	parent.Left = true
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, message, []string{"id", "text"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{
+			Filter:  expr.Eq(message.FieldExpr("id"), expr.Value(10)),
+			OrderBy: []model.Order{{FieldName: "id"}},
+		},
+		Parents: []mysql.Parent{parent},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `message`.`id`,`message`.`text`,`user`.`id` AS `user.id`,`user`.`name` AS `user.name`,"+
+			"`user`.`lastname` AS `user.lastname` FROM `message` LEFT JOIN `user` ON `user`.`id`=`message`.`fk_author_id`"+
+			" WHERE `message`.`id`=? ORDER BY `message`.`id`", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{10}, sqlBuf.GetArgs())
+	}
+
+	parent.Strategy = mysql.ParentBatch
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, message, []string{"id"}, mysql.SelectOptions{Parents: []mysql.Parent{parent}})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id` FROM `message`", sqlBuf.GetSQL())
+	}
+}
+
+func (s *DBTestSuite) TestModel_DeleteCascade() {
+	s.TestModel_Add()
+
+	junction := s.user.GetRelation("address").JunctionModel
+	s.NoError(s.storage.AddDeleteDependency(s.user, mysql.DeleteDependency{Model: s.message, FkFieldName: "fk_author_id", Action: mysql.Cascade}))
+	s.NoError(s.storage.AddDeleteDependency(s.user, mysql.DeleteDependency{Model: junction, FkFieldName: "fk_user_id", Action: mysql.Cascade}))
+
+	counts, err := s.user.DeleteCascade(context.Background(), 2)
+	if s.NoError(err) {
+		s.Equal(map[string]uint64{"user": 1, "message": 1, junction.GetId(): 2}, counts)
+	}
+}
+
+func (s *DBTestSuite) TestModel_DeleteRestrict() {
+	s.TestModel_Add()
+
+	junction := s.user.GetRelation("address").JunctionModel
+	s.NoError(s.storage.AddDeleteDependency(s.user, mysql.DeleteDependency{Model: s.message, FkFieldName: "fk_author_id", Action: mysql.Restrict}))
+	s.NoError(s.storage.AddDeleteDependency(s.user, mysql.DeleteDependency{Model: junction, FkFieldName: "fk_user_id", Action: mysql.Cascade}))
+
+	_, err := s.user.DeleteCascade(context.Background(), 2)
+	var restrictErr *mysql.RestrictError
+	if s.ErrorAs(err, &restrictErr) {
+		s.Equal(&mysql.RestrictError{Model: "message", Count: 1}, restrictErr)
+	}
+
+	counts, err := s.user.DeleteCascade(context.Background(), 4)
+	if s.NoError(err) {
+		s.Equal(map[string]uint64{"user": 1, junction.GetId(): 1}, counts)
+	}
+}
+
+func TestMySQL_AddDeleteDependency(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	message := test.NewMessage(storage)
+	group := test.NewGroup(storage)
+	relation.AddManyToOne(message, user, relation.WithRequired(true), relation.WithAlias("author"))
+	relation.AddManyToOne(user, group)
+
+	assert.NoError(t, storage.AddDeleteDependency(group, mysql.DeleteDependency{Model: user, FkFieldName: "fk_group_id", Action: mysql.SetNull}))
+	assert.NoError(t, storage.AddDeleteDependency(user, mysql.DeleteDependency{Model: message, FkFieldName: "fk_author_id", Action: mysql.Cascade}))
+
+	assert.Error(t, storage.AddDeleteDependency(user, mysql.DeleteDependency{Model: message, FkFieldName: "unknown", Action: mysql.Cascade}))
+	assert.Error(t, storage.AddDeleteDependency(user, mysql.DeleteDependency{Model: message, FkFieldName: "fk_author_id", Action: mysql.SetNull}))
+
+	// message -> group closes the group -> user -> message cycle
+	relation.AddManyToOne(group, message)
+	assert.Error(t, storage.AddDeleteDependency(message, mysql.DeleteDependency{Model: group, FkFieldName: "fk_message_id", Action: mysql.Restrict}))
+	assert.Error(t, storage.AddDeleteDependency(user, mysql.DeleteDependency{Model: user, FkFieldName: "fk_group_id", Action: mysql.Restrict}))
+}
+
+func (s *DBTestSuite) TestModel_Compressed() {
+	ctx := context.Background()
+
+	document := mysql.NewBaseModel(s.storage, "document", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.TextField{Id: "body", Compressed: mysql.DefaultCompression()},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	document.WriteCreateSQL(sqlBuf)
+	_, err := s.storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+
+	long := strings.Repeat("compressed ", 1000)
+	_, err = s.storage.Exec(ctx, "INSERT INTO document(id, body) VALUES (1, ?), (2, ?), (3, NULL)", long, "legacy")
+	if !s.NoError(err) {
+		return
+	}
+	_, err = document.AddFromStructs(ctx, []struct {
+		Id   uint32
+		Body *string
+	}{{4, &long}}, model.AddOptions{})
+	if !s.NoError(err) {
+		return
+	}
+
+	var lastPks []interface{}
+	rewritten, err := s.storage.CompressField(ctx, document, "body", mysql.CompressFieldOpts{
+		BatchSize: 2,
+		OnBatch: func(lastPk interface{}, _ uint64) {
+			lastPks = append(lastPks, lastPk)
+		},
+	})
 	s.NoError(err)
+	s.Equal(uint64(2), rewritten)
+	s.Len(lastPks, 2)
 
-	s.Equal([]map[string]interface{}{
-		{"id": uint32(1)},
-		{"id": uint32(2)},
-		{"id": uint32(4)},
-		{"id": uint32(5)},
-	}, data.Maps())
+	var storedLen int
+	s.NoError(s.storage.GetRawDB().QueryRow("SELECT LENGTH(body) FROM document WHERE id = 1").Scan(&storedLen))
+	s.Less(storedLen, len(long))
+
+	data, err := document.GetAll(ctx, []string{"id", "body"}, model.GetAllOptions{OrderBy: []model.Order{{FieldName: "id"}}})
+	if s.NoError(err) && s.Equal(4, data.Len()) {
+		s.Equal(long, *data.Data()[0][1].(*string))
+		s.Equal("legacy", *data.Data()[1][1].(*string))
+		s.Nil(data.Data()[2][1])
+		s.Equal(long, *data.Data()[3][1].(*string))
+	}
+}
+
+func (s *DBTestSuite) TestModel_ForUpdate() {
+	ctx := context.Background()
+
+	version, err := s.storage.ServerVersion(ctx)
+	s.Require().NoError(err)
+	if version.MariaDB && !version.AtLeast(10, 6) || !version.MariaDB && !version.AtLeast(8, 0) {
+		s.T().Skip("NOWAIT requires MySQL 8.0 or MariaDB 10.6")
+	}
+
+	account := mysql.NewBaseModel(s.storage, "account", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.IntField{Id: "balance", NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	account.WriteCreateSQL(sqlBuf)
+	_, err = s.storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+	_, err = s.storage.Exec(ctx, "INSERT INTO account VALUES (1, 100), (2, 200), (3, 300)")
+	if !s.NoError(err) {
+		return
+	}
+
+	_, err = account.GetByPKForUpdate(ctx, 1, []string{"balance"}, mysql.LockWaitDefault)
+	s.Error(err)
+
+	s.NoError(s.storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		// The lock taken under the savepoint is held after its release
+		if err := s.storage.DoInTransaction(ctx, func(ctx context.Context) error {
+			_, err := account.GetByPKsForUpdate(ctx, []interface{}{2, 1}, []string{"balance"}, mysql.LockWaitDefault)
+			return err
+		}); err != nil {
+			return err
+		}
+
+		return s.storage.DoInTransaction(context.Background(), func(other context.Context) error {
+			_, err := account.GetByPKForUpdate(other, 1, []string{"balance"}, mysql.LockNoWait)
+			s.ErrorIs(err, mysql.ErrRowLocked)
+
+			data, err := account.GetByPKsForUpdate(other, []interface{}{1, 2, 3}, []string{"id", "balance"}, mysql.LockSkipLocked)
+			if s.NoError(err) && s.Equal(1, data.Len()) {
+				s.Equal(int32(300), data.Data()[0][1])
+			}
+			return nil
+		})
+	}))
+}
+
+func (s *DBTestSuite) TestConsistentSnapshot() {
+	ctx := context.Background()
+	_, err := s.storage.Exec(ctx, "CREATE TABLE number (n INT) ENGINE=InnoDB")
+	if !s.NoError(err) {
+		return
+	}
+	_, err = s.storage.Exec(ctx, "INSERT INTO number VALUES (1)")
+	if !s.NoError(err) {
+		return
+	}
+
+	count := func(ctx context.Context) int {
+		var n int
+		rows, err := s.storage.RawQuery(ctx, "SELECT COUNT(*) FROM number")
+		if s.NoError(err) {
+			defer rows.Close()
+			s.True(rows.Next())
+			s.NoError(rows.Scan(&n))
+		}
+		return n
+	}
+
+	s.NoError(s.storage.WithConsistentSnapshot(ctx, func(snapshot context.Context) error {
+		s.Equal(1, count(snapshot))
+
+		// The concurrent write is not visible in the snapshot
+		_, err := s.storage.Exec(ctx, "INSERT INTO number VALUES (2)")
+		s.NoError(err)
+		s.Equal(2, count(ctx))
+		s.Equal(1, count(snapshot))
+
+		_, err = s.storage.Exec(snapshot, "INSERT INTO number VALUES (3)")
+		s.ErrorIs(err, mysql.ErrReadOnlySnapshot)
+		return nil
+	}))
+	s.Equal(2, count(ctx))
+}
+
+func (s *DBTestSuite) TestModel_Encrypted() {
+	ctx := context.Background()
+
+	oldCipher, err := mysql.NewAESGCMCipher(1, map[uint16][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	s.Require().NoError(err)
+	newCipher, err := mysql.NewAESGCMCipher(2, map[uint16][]byte{1: bytes.Repeat([]byte{1}, 32), 2: bytes.Repeat([]byte{2}, 32)})
+	s.Require().NoError(err)
+
+	define := func(storage *mysql.MySQL, c mysql.Cipher) *mysql.BaseModel {
+		return mysql.NewBaseModel(storage, "secret", []mysql.IMysqlFieldDefinition{
+			&mysql.UintField{Id: "id", NotNull: true},
+			&mysql.VarCharField{Id: "ssn", Length: 11, Encrypted: &mysql.Encryption{Cipher: c, BlindIndex: "ssn_hash", BlindIndexKey: []byte("key")}},
+		}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	}
+	secret := define(s.storage, oldCipher)
+
+	sqlBuf := mysql.NewSqlBuffer()
+	secret.WriteCreateSQL(sqlBuf)
+	_, err = s.storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+
+	_, err = s.storage.Exec(ctx, "INSERT INTO secret(id, ssn) VALUES (1, 'legacy'), (2, NULL)")
+	if !s.NoError(err) {
+		return
+	}
+	_, err = secret.AddMulti(ctx, model.NewData([]string{"id", "ssn"}, [][]interface{}{{uint32(3), "123-45-6789"}}), model.AddOptions{})
+	if !s.NoError(err) {
+		return
+	}
+
+	var stored []byte
+	s.NoError(s.storage.GetRawDB().QueryRow("SELECT ssn FROM secret WHERE id = 3").Scan(&stored))
+	s.NotContains(string(stored), "123-45-6789")
+
+	data, err := secret.GetAll(ctx, []string{"id", "ssn"}, model.GetAllOptions{Filter: expr.Eq(secret.FieldExpr("ssn"), expr.Value("123-45-6789"))})
+	if s.NoError(err) && s.Equal(1, data.Len()) {
+		s.Equal("123-45-6789", *data.Data()[0][1].(*string))
+	}
+
+	// The rotation encrypts the legacy value and re-encrypts the value encrypted with the old key
+	rotated := define(s.storage, newCipher)
+	rewritten, err := s.storage.ReencryptField(ctx, rotated, "ssn", mysql.RewriteFieldOpts{BatchSize: 2})
+	s.NoError(err)
+	s.Equal(uint64(2), rewritten)
+
+	rewritten, err = s.storage.ReencryptField(ctx, rotated, "ssn", mysql.RewriteFieldOpts{})
+	s.NoError(err)
+	s.Zero(rewritten)
+
+	data, err = rotated.GetAll(ctx, []string{"id", "ssn"}, model.GetAllOptions{
+		Filter:  mysql.In(rotated.FieldExpr("ssn"), []interface{}{"legacy", "123-45-6789"}),
+		OrderBy: []model.Order{{FieldName: "id"}},
+	})
+	if s.NoError(err) && s.Equal(2, data.Len()) {
+		s.Equal("legacy", *data.Data()[0][1].(*string))
+		s.Equal("123-45-6789", *data.Data()[1][1].(*string))
+	}
+}
+
+func (s *DBTestSuite) TestModel_ZeroDates() {
+	ctx := context.Background()
+
+	define := func(storage *mysql.MySQL, policy mysql.ZeroDatePolicy) *mysql.BaseModel {
+		return mysql.NewBaseModel(storage, "legacy", []mysql.IMysqlFieldDefinition{
+			&mysql.UintField{Id: "id", NotNull: true},
+			&mysql.DateField{Id: "day", ZeroDate: mysql.ZeroDateNull},
+			&mysql.DateTimeField{Id: "happened_at", NotNull: true, ZeroDate: policy},
+		}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	}
+
+	legacy := define(s.storage, mysql.ZeroDateZeroTime)
+	sqlBuf := mysql.NewSqlBuffer()
+	legacy.WriteCreateSQL(sqlBuf)
+	_, err := s.storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+
+	// The zero dates are rejected by the strict sql_mode
+	conn, err := s.storage.GetRawDB().Conn(ctx)
+	if !s.NoError(err) {
+		return
+	}
+	_, err = conn.ExecContext(ctx, "SET SESSION sql_mode=''")
+	s.NoError(err)
+	_, err = conn.ExecContext(ctx, "INSERT INTO legacy(id, day, happened_at) VALUES (1, '0000-00-00', '0000-00-00 00:00:00'), (2, '2024-01-02', '2024-01-02 03:04:05')")
+	s.NoError(err)
+	s.NoError(conn.Close())
+
+	for _, dsn := range []string{gotestDsn, gotestDsn + "parseTime=true"} {
+		storage := mysql.NewMySQL()
+		if !s.NoError(storage.Connect(dsn)) {
+			return
+		}
+
+		data, err := define(storage, mysql.ZeroDateZeroTime).GetAll(ctx, []string{"id", "day", "happened_at"}, model.GetAllOptions{OrderBy: []model.Order{{FieldName: "id"}}})
+		if s.NoError(err, dsn) && s.Equal(2, data.Len()) {
+			s.Nil(data.Data()[0][1])
+			s.Equal("0000-00-00 00:00:00", data.Data()[0][2])
+			s.NotNil(data.Data()[1][1])
+			s.NotEqual("0000-00-00 00:00:00", data.Data()[1][2])
+		}
+		storage.Disconnect()
+
+		storage = mysql.NewMySQL()
+		if !s.NoError(storage.Connect(dsn)) {
+			return
+		}
+		_, err = define(storage, mysql.ZeroDateError).GetAll(ctx, []string{"id", "happened_at"}, model.GetAllOptions{})
+		s.True(errors.Is(err, mysql.ErrZeroDate), dsn)
+		storage.Disconnect()
+	}
+
+	_, err = legacy.AddMulti(ctx, model.NewData([]string{"id", "happened_at"}, [][]interface{}{{uint32(3), time.Time{}}}), model.AddOptions{})
+	s.True(errors.Is(err, mysql.ErrZeroTime))
+}
+
+func (s *DBTestSuite) TestModel_BigUnsigned() {
+	ctx := context.Background()
+
+	hashes := mysql.NewBaseModel(s.storage, "hash", []mysql.IMysqlFieldDefinition{
+		&mysql.BigUintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.BigUintField{Id: "value", NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	hashes.WriteCreateSQL(sqlBuf)
+	_, err := s.storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+	_, err = s.storage.Exec(ctx, "ALTER TABLE hash AUTO_INCREMENT=18446744073709551614")
+	s.NoError(err)
+
+	const (
+		half = uint64(1) << 63
+		max  = ^uint64(0)
+	)
+	pks, err := hashes.AddMulti(ctx, model.NewData([]string{"value"}, [][]interface{}{{max}, {half}}), model.AddOptions{})
+	if s.NoError(err) {
+		s.Equal([][]interface{}{{max - 1}, {max}}, pks.Data())
+	}
+
+	data, err := hashes.GetAll(ctx, []string{"id", "value"}, model.GetAllOptions{
+		Filter:  mysql.In(hashes.FieldExpr("value"), []interface{}{half, max}),
+		OrderBy: []model.Order{{FieldName: "value"}},
+	})
+	if s.NoError(err) {
+		s.Equal([][]interface{}{{max, half}, {max - 1, max}}, data.Data())
+	}
+}
+
+func (s *DBTestSuite) TestModel_Warnings() {
+	ctx := context.Background()
+
+	_, err := mysql.NewFromDSN(gotestDsn, mysql.Options{SQLMode: "NO_ENGINE_SUBSTITUTION", RequireStrictMode: true})
+	s.True(errors.Is(err, mysql.ErrNotStrictMode))
+
+	storage, err := mysql.NewFromDSN(gotestDsn, mysql.Options{SQLMode: "NO_ENGINE_SUBSTITUTION"})
+	if !s.NoError(err) {
+		return
+	}
+	defer storage.Disconnect()
+
+	notes := mysql.NewBaseModel(storage, "note", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "title", Length: 4, NotNull: true},
+		&mysql.TinyUintField{Id: "rank", NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}, CheckWarnings: true})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	notes.WriteCreateSQL(sqlBuf)
+	_, err = storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+
+	_, err = notes.AddMulti(ctx, model.NewData([]string{"id", "title", "rank"}, [][]interface{}{
+		{uint32(1), "abc", uint8(1)},
+		{uint32(2), "abcdef", uint8(1)},
+	}), model.AddOptions{})
+	var truncationErr *mysql.TruncationError
+	if s.True(errors.As(err, &truncationErr)) {
+		s.Equal("title", truncationErr.Field)
+		s.Equal(2, truncationErr.Row)
+	}
+
+	// The transaction of the write is rolled back
+	data, err := notes.GetAll(ctx, []string{"id"}, model.GetAllOptions{})
+	if s.NoError(err) {
+		s.Equal(0, data.Len())
+	}
+
+	_, err = notes.AddMulti(mysql.WithWarningsCheck(ctx, false), model.NewData([]string{"id", "title", "rank"}, [][]interface{}{
+		{uint32(3), "abcdef", uint8(1)},
+	}), model.AddOptions{})
+	s.NoError(err)
+
+	err = notes.Edit(ctx, mysql.In(notes.FieldExpr("id"), []interface{}{3}), map[string]interface{}{"rank": 1000})
+	if s.True(errors.As(err, &truncationErr)) {
+		s.Equal("rank", truncationErr.Field)
+	}
+}
+
+func (s *DBTestSuite) TestCheckCharsets() {
+	ctx := context.Background()
+
+	storage, err := mysql.NewFromDSN(gotestDsn, mysql.Options{})
+	if !s.NoError(err) {
+		return
+	}
+	defer storage.Disconnect()
+
+	notes := mysql.NewBaseModel(storage, "note", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "title", Length: 255, NotNull: true, UTF8: mysql.UTF8Reject},
+		&mysql.VarCharField{Id: "body", Length: 255, Charset: "utf8mb4", UTF8: mysql.UTF8Reject},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	notes.WriteCreateSQL(sqlBuf)
+	_, err = storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+
+	columns, err := storage.CheckCharsets(ctx)
+	if s.NoError(err) && s.Len(columns, 1) {
+		s.Equal("note", columns[0].Model)
+		s.Equal("title", columns[0].Field)
+	}
+
+	// 255 emoji fit VARCHAR(255) in utf8mb4
+	body := strings.Repeat("😀", 255)
+	_, err = notes.AddMulti(ctx, model.NewData([]string{"id", "title", "body"}, [][]interface{}{{uint32(1), "title", &body}}), model.AddOptions{})
+	s.NoError(err)
+	_, err = notes.AddMulti(ctx, model.NewData([]string{"id", "title"}, [][]interface{}{{uint32(2), "😀"}}), model.AddOptions{})
+	s.True(errors.Is(err, mysql.ErrFourByteChar))
+}
+
+func (s *DBTestSuite) TestModel_CompositePK() {
+	ctx := context.Background()
+
+	roles := mysql.NewBaseModel(s.storage, "user_role", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "user_id", NotNull: true},
+		&mysql.UintField{Id: "role_id", NotNull: true},
+		&mysql.VarCharField{Id: "comment", Length: 32},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"user_id", "role_id"}}})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	roles.WriteCreateSQL(sqlBuf)
+	_, err := s.storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+
+	_, err = roles.AddMulti(ctx, model.NewData([]string{"user_id", "role_id", "comment"}, [][]interface{}{
+		{uint32(1), uint32(1), "admin"},
+		{uint32(1), uint32(2), "editor"},
+		{uint32(2), uint32(1), "admin"},
+	}), model.AddOptions{})
+	if !s.NoError(err) {
+		return
+	}
+
+	data, err := roles.GetByPKs(ctx, []interface{}{
+		[]interface{}{2, 1},
+		map[string]interface{}{"user_id": 1, "role_id": 2},
+		[]interface{}{2, 2},
+	}, []string{"comment"})
+	if s.NoError(err) {
+		s.Equal([]map[string]interface{}{{"comment": &[]string{"admin"}[0]}, {"comment": &[]string{"editor"}[0]}}, data.Maps())
+	}
+
+	s.NoError(roles.EditByPK(ctx, []interface{}{1, 2}, map[string]interface{}{"comment": "author"}))
+	s.NoError(roles.DeleteByPK(ctx, map[string]interface{}{"user_id": 1, "role_id": 1}))
+
+	data, err = roles.GetAll(ctx, []string{"user_id", "role_id", "comment"}, model.GetAllOptions{
+		OrderBy: []model.Order{{FieldName: "user_id"}, {FieldName: "role_id"}},
+	})
+	if s.NoError(err) {
+		s.Equal([]map[string]interface{}{
+			{"user_id": uint32(1), "role_id": uint32(2), "comment": &[]string{"author"}[0]},
+			{"user_id": uint32(2), "role_id": uint32(1), "comment": &[]string{"admin"}[0]},
+		}, data.Maps())
+	}
+
+	_, err = roles.GetByPK(ctx, map[string]interface{}{"user_id": 1}, []string{"comment"})
+	s.True(errors.Is(err, mysql.ErrIncompletePK))
+}
+
+func (s *DBTestSuite) TestModel_StablePages() {
+	ctx := context.Background()
+
+	scores := mysql.NewBaseModel(s.storage, "score", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.IntField{Id: "points"},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	scores.WriteCreateSQL(sqlBuf)
+	_, err := s.storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+
+	rows := make([][]interface{}, 30)
+	for i := range rows {
+		switch i % 3 {
+		case 0:
+			rows[i] = []interface{}{(*int32)(nil)}
+		case 1:
+			rows[i] = []interface{}{int32(1)}
+		default:
+			rows[i] = []interface{}{int32(2)}
+		}
+	}
+	if _, err := scores.AddMulti(ctx, model.NewData([]string{"points"}, rows), model.AddOptions{}); !s.NoError(err) {
+		return
+	}
+
+	// The pages of the rows with the equal points neither repeat nor miss the rows, the NULLs are the last
+	var ids []uint32
+	for offset := uint64(0); offset < 30; offset += 7 {
+		data, err := s.storage.Select(ctx, scores, []string{"id", "points"}, mysql.SelectOptions{
+			GetAllOptions: model.GetAllOptions{Limit: 7, Offset: offset},
+			OrderBy:       []mysql.OrderExpr{mysql.OrderExpr{Expr: scores.FieldExpr("points")}.NullsLast()},
+		})
+		if !s.NoError(err) {
+			return
+		}
+		for _, row := range data.Maps() {
+			id := row["id"].(uint32)
+			if len(ids) >= 20 {
+				s.Nil(row["points"])
+			} else {
+				s.NotNil(row["points"])
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	s.Len(ids, 30)
+	seen := make(map[uint32]bool)
+	for _, id := range ids {
+		s.False(seen[id])
+		seen[id] = true
+	}
+}
+
+func (s *DBTestSuite) TestAnalyzeIndexes() {
+	ctx := context.Background()
+
+	_, err := s.user.GetAll(ctx, []string{"id"}, model.GetAllOptions{Filter: expr.Eq(s.user.FieldExpr("lastname"), expr.Value("Ivanov"))})
+	s.NoError(err)
+
+	report, err := s.storage.AnalyzeIndexes(ctx)
+	if !s.NoError(err) {
+		return
+	}
+
+	for _, index := range report.Redundant {
+		s.NotEqual("user", index.Model)
+	}
+	for _, column := range report.Unindexed {
+		s.NotEqual("user.lastname", column.Model+"."+column.Field)
+	}
+}
+
+func (s *DBTestSuite) TestLogger() {
+	logger := &testLogger{}
+	s.storage.SetLogger(logger)
+	s.storage.SetDebug(true)
+	defer s.storage.SetDebug(false)
+	s.storage.SetPlainSavepointNames(true)
+	defer s.storage.SetPlainSavepointNames(false)
+
+	s.NoError(s.storage.DoInTransaction(context.Background(), func(ctx context.Context) error {
+		return s.storage.DoInTransaction(ctx, func(ctx context.Context) error {
+			_, err := s.storage.Exec(ctx, "DO 1")
+			return err
+		})
+	}))
+
+	var (
+		statements []string
+		depths     []int
+	)
+	for _, entry := range logger.entries {
+		statements, depths = append(statements, entry.SQL), append(depths, entry.TxDepth)
+	}
+	s.Equal([]string{"BEGIN", "SAVEPOINT SP1", "DO 1", "RELEASE SAVEPOINT SP1", "COMMIT"}, statements)
+	s.Equal([]int{1, 2, 2, 2, 1}, depths)
+}
+
+func (s *DBTestSuite) TestModel_Tree() {
+	ctx := context.Background()
+
+	category := test.NewCategory(s.storage)
+	sqlBuf := mysql.NewSqlBuffer()
+	category.WriteCreateSQL(sqlBuf)
+	_, err := s.storage.Exec(ctx, sqlBuf.GetSQL())
+	if !s.NoError(err) {
+		return
+	}
+
+	_, err = category.AddFromStructs(ctx, []struct {
+		Id       int
+		ParentId *int
+		Name     string
+		Position int
+	}{
+		{Id: 1, Name: "Root", Position: 0},
+		{Id: 2, ParentId: intPtr(1), Name: "Books", Position: 2},
+		{Id: 3, ParentId: intPtr(1), Name: "Music", Position: 1},
+		{Id: 4, ParentId: intPtr(2), Name: "Fiction", Position: 0},
+		{Id: 5, ParentId: intPtr(4), Name: "Fantasy", Position: 0},
+	}, model.AddOptions{})
+	if !s.NoError(err) {
+		return
+	}
+
+	tree, err := s.storage.NewTree(category, "parent_id")
+	if !s.NoError(err) {
+		return
+	}
+	tree.OrderFieldName = "position"
+
+	for _, iterative := range []bool{false, true} {
+		tree.Iterative = iterative
+
+		data, err := tree.GetSubtree(ctx, 1, 2, []string{"name"})
+		if s.NoError(err) {
+			s.Equal([][]interface{}{{"Root", 0}, {"Music", 1}, {"Books", 1}, {"Fiction", 2}}, data.Data())
+		}
+
+		data, err = tree.GetAncestors(ctx, 5, []string{"id"})
+		if s.NoError(err) {
+			s.Equal([][]interface{}{{uint32(1), 0}, {uint32(2), 1}, {uint32(4), 2}}, data.Data())
+		}
+	}
+
+	s.Error(tree.MoveSubtree(ctx, 2, 5))
+	s.Error(tree.MoveSubtree(ctx, 2, 2))
+	s.NoError(tree.MoveSubtree(ctx, 4, 3))
+
+	data, err := tree.GetSubtree(ctx, 3, 0, []string{"name"})
+	if s.NoError(err) {
+		s.Equal([][]interface{}{{"Music", 0}, {"Fiction", 1}, {"Fantasy", 2}}, data.Data())
+	}
+}
+
+func intPtr(v int) *int { return &v }
+
+func TestTree_WriteSubtreeSQL(t *testing.T) {
+	storage := mysql.NewMySQL()
+	category := test.NewCategory(storage)
+
+	tree, err := storage.NewTree(category, "parent_id")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	sqlBuf := mysql.NewSqlBuffer()
+	tree.WriteSubtreeSQL(sqlBuf, 1, 3, []string{"id", "parent_id"})
+	assert.Equal(t, "WITH RECURSIVE `_tree` AS (SELECT `id`,`parent_id`,0 AS `_depth` FROM `category` WHERE `id`=?"+
+		" UNION ALL SELECT `_node`.`id`,`_node`.`parent_id`,`_tree`.`_depth`+1 FROM `category` AS `_node`"+
+		" JOIN `_tree` ON `_node`.`parent_id`=`_tree`.`id` WHERE `_tree`.`_depth`<?) SELECT `id`,`parent_id` FROM `_tree`",
+		sqlBuf.GetSQL())
+	assert.Equal(t, []interface{}{1, 3}, sqlBuf.GetArgs())
+
+	sqlBuf.Reset()
+	tree.WriteAncestorsSQL(sqlBuf, 5, []string{"id", "parent_id"})
+	assert.Equal(t, "WITH RECURSIVE `_tree` AS (SELECT `id`,`parent_id`,0 AS `_depth` FROM `category` WHERE `id`=?"+
+		" UNION ALL SELECT `_node`.`id`,`_node`.`parent_id`,`_tree`.`_depth`+1 FROM `category` AS `_node`"+
+		" JOIN `_tree` ON `_node`.`id`=`_tree`.`parent_id`) SELECT `id`,`parent_id` FROM `_tree`", sqlBuf.GetSQL())
+
+	_, err = storage.NewTree(category, "unknown")
+	assert.Error(t, err)
+}
+
+func (s *DBTestSuite) TestModel_CheckIntegrity() {
+	s.TestModel_Add()
+
+	ctx, err := s.storage.StartTransaction(context.Background())
+	if !s.NoError(err) {
+		return
+	}
+	_, err = s.storage.Exec(ctx, "SET FOREIGN_KEY_CHECKS=0")
+	s.NoError(err)
+	_, err = s.storage.Exec(ctx, "INSERT INTO `message`(`id`,`text`,`fk_author_id`) VALUES (50,'Orphan 1',100),(60,'Orphan 2',200)")
+	s.NoError(err)
+	_, err = s.storage.Exec(ctx, "SET FOREIGN_KEY_CHECKS=1")
+	s.NoError(err)
+	_, err = s.storage.Commit(ctx)
+	s.NoError(err)
+
+	report, err := s.storage.CheckIntegrity(context.Background(), mysql.IntegrityOpts{ChunkSize: 2, SamplesCount: 1})
+	if s.NoError(err) && s.True(report.HasOrphans()) {
+		for _, relation := range report {
+			if relation.Model == "message" {
+				s.Equal(mysql.RelationIntegrity{
+					Model: "message", Relation: "author", ParentModel: "user", Orphans: 2, SampleIds: [][]interface{}{{uint32(50)}},
+				}, relation)
+			} else {
+				s.Zero(relation.Orphans, relation.Model)
+			}
+		}
+	}
+
+	_, err = s.storage.CheckIntegrity(context.Background(), mysql.IntegrityOpts{Repair: mysql.RepairDelete})
+	s.Error(err)
+
+	report, err = s.storage.CheckIntegrity(context.Background(), mysql.IntegrityOpts{ChunkSize: 2, Repair: mysql.RepairDelete, Destructive: true})
+	if s.NoError(err) {
+		for _, relation := range report {
+			s.Equal(relation.Orphans, relation.Repaired)
+		}
+	}
+
+	report, err = s.storage.CheckIntegrity(context.Background(), mysql.IntegrityOpts{})
+	if s.NoError(err) {
+		s.False(report.HasOrphans())
+	}
+}
+
+func TestMySQL_WriteOrphansSQL(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	message := test.NewMessage(storage)
+	relation.AddManyToOne(message, user, relation.WithRequired(true), relation.WithAlias("author"))
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteOrphansSQL(sqlBuf, message, "author", []interface{}{10}, []interface{}{20})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `message`.`id` FROM `message` LEFT JOIN `user` AS `_parent` ON `_parent`.`id`=`message`.`fk_author_id`"+
+			" WHERE `message`.`fk_author_id` IS NOT NULL AND `_parent`.`id` IS NULL AND (`message`.`id`)>(?) AND (`message`.`id`)<=(?)",
+			sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{10, 20}, sqlBuf.GetArgs())
+	}
+
+	sqlBuf.Reset()
+	storage.WriteOrphansSQL(sqlBuf, message, "unknown", nil, nil)
+	assert.Error(t, sqlBuf.Err())
+}
+
+func TestMySQL_PoolConfig(t *testing.T) {
+	storage := mysql.NewMySQL()
+	assert.Equal(t, mysql.DefaultPoolConfig(), storage.GetPoolConfig())
+	assert.Equal(t, sql.DBStats{}, storage.Stats())
+
+	config := mysql.DefaultPoolConfig()
+	config.MaxOpenConns = 10
+	config.ReadTimeout = 30 * time.Second
+	storage = mysql.NewMySQL(config)
+	if assert.NoError(t, storage.Connect("user:pass@tcp(localhost:3306)/test?timeout=5s")) {
+		defer storage.Disconnect()
+
+		assert.Equal(t, 10, storage.Stats().MaxOpenConnections)
+
+		storage.SetupConnectionsPool(20, 0, 0)
+		assert.Equal(t, 20, storage.Stats().MaxOpenConnections)
+		assert.Equal(t, 30*time.Second, storage.GetPoolConfig().ReadTimeout)
+	}
+
+	assert.Error(t, storage.Connect("not a dsn"))
+}
+
+func (s *DBTestSuite) TestModel_Replicas() {
+	s.TestModel_Add()
+
+	if !s.NoError(s.storage.AddReplica(gotestDsn)) {
+		return
+	}
+
+	ctx := mysql.WithSessionConsistency(context.Background(), time.Minute)
+	s.NoError(s.storage.Edit(ctx, s.user, expr.Eq(s.user.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "Ivan"}))
+
+	for _, ctx := range []context.Context{ctx, mysql.ForcePrimary(context.Background()), context.Background()} {
+		data, err := s.storage.Query(ctx, s.user, []string{"name"}, model.GetAllOptions{Filter: expr.Eq(s.user.FieldExpr("id"), expr.Value(1))})
+		if s.NoError(err) {
+			s.Equal([]map[string]interface{}{{"name": "Ivan"}}, data.Maps())
+		}
+	}
+	s.Equal(1, s.storage.ReplicasAlive())
+}
+
+func TestMySQL_AddReplica(t *testing.T) {
+	defer func(maxFailures int) { mysql.ReplicaMaxFailures = maxFailures }(mysql.ReplicaMaxFailures)
+	mysql.ReplicaMaxFailures = 2
+
+	storage := mysql.NewMySQL()
+	if !assert.NoError(t, storage.Connect("user:pass@tcp(127.0.0.1:1)/test")) {
+		return
+	}
+	defer storage.Disconnect()
+
+	assert.Error(t, storage.AddReplica("not a dsn"))
+	if !assert.NoError(t, storage.AddReplica("user:pass@tcp(127.0.0.1:2)/test")) {
+		return
+	}
+	assert.Equal(t, 1, storage.ReplicasAlive())
+
+	// The replica refuses the connections and the queries fall back to the primary which refuses them as well
+	for i := 0; i < 2; i++ {
+		_, err := storage.RawQuery(context.Background(), "SELECT 1")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 0, storage.ReplicasAlive())
+}
+
+type observedQuery struct {
+	model, op string
+	rows      int64
+	failed    bool
+}
+
+type testMetricsSink struct {
+	mtx     sync.Mutex
+	queries []observedQuery
+}
+
+func (m *testMetricsSink) ObserveQuery(model, op string, _ time.Duration, rows int64, err error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	m.queries = append(m.queries, observedQuery{model, op, rows, err != nil})
+}
+
+type panicMetricsSink struct{}
+
+func (panicMetricsSink) ObserveQuery(string, string, time.Duration, int64, error) { panic("sink") }
+
+func TestMySQL_SetMetricsSink(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	if !assert.NoError(t, storage.Connect("user:pass@tcp(127.0.0.1:1)/test")) {
+		return
+	}
+	defer storage.Disconnect()
+
+	sink := &testMetricsSink{}
+	storage.SetMetricsSink(sink)
+
+	ctx := context.Background()
+	_, _ = storage.RawQuery(ctx, "SELECT 1")
+	_ = storage.Edit(ctx, user, expr.Eq(user.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "Ivan"})
+	_, _ = storage.Query(ctx, user, []string{"id"}, model.GetAllOptions{})
+	_, _ = storage.StartTransaction(ctx)
+
+	assert.Equal(t, []observedQuery{
+		{"", "select", -1, true},
+		{"user", "update", 0, true},
+		{"user", "select", -1, true},
+		{mysql.TxModel, "begin", 0, true},
+	}, sink.queries)
+
+	storage.SetMetricsSink(panicMetricsSink{})
+	assert.NotPanics(t, func() {
+		_, err := storage.RawQuery(ctx, "SELECT 1")
+		assert.Error(t, err)
+	})
+
+	// The panic is logged in the debug mode
+	logger := &warningLogger{}
+	storage.SetLogger(logger)
+	_, _ = storage.RawQuery(ctx, "SELECT 1")
+	assert.Empty(t, logger.warnings)
+	_, _ = storage.RawQuery(storage.WithDebug(ctx), "SELECT 1")
+	assert.Equal(t, []string{"Metrics sink panic: sink"}, logger.warnings)
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	metrics := mysql.NewExpvarMetrics("mysql_test")
+	metrics.ObserveQuery("user", "update", time.Millisecond, 3, nil)
+	metrics.ObserveQuery("user", "update", time.Millisecond, 0, fmt.Errorf("failed"))
+
+	assert.Equal(t, "2", metrics.Count.Get("user.update").String())
+	assert.Equal(t, "1", metrics.Errors.Get("user.update").String())
+	assert.Equal(t, "3", metrics.Rows.Get("user.update").String())
+	assert.Equal(t, "2000000", metrics.Duration.Get("user.update").String())
+}
+
+type snapshotSink struct {
+	testMetricsSink
+	snapshots chan mysql.MetricsSnapshot
+}
+
+func (m *snapshotSink) ObserveSnapshot(snapshot mysql.MetricsSnapshot) { m.snapshots <- snapshot }
+
+func TestMySQL_MetricsSnapshot(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	clock := testutil.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage.SetClock(clock)
+
+	snapshot := storage.MetricsSnapshot()
+	assert.Equal(t, 0, snapshot.OpenTransactions)
+	assert.Equal(t, time.Duration(0), snapshot.MaxTransactionAge)
+
+	// The transaction is held open
+	txCtx, err := storage.StartTransaction(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	clock.Advance(time.Minute)
+	_, err = storage.StartTransaction(context.Background())
+	assert.NoError(t, err)
+	clock.Advance(time.Second)
+
+	snapshot = storage.MetricsSnapshot()
+	assert.Equal(t, clock.Now(), snapshot.Time)
+	assert.Equal(t, 2, snapshot.OpenTransactions)
+	assert.Equal(t, time.Minute+time.Second, snapshot.MaxTransactionAge)
+	assert.Equal(t, 2, snapshot.InUse)
+
+	sink := &snapshotSink{snapshots: make(chan mysql.MetricsSnapshot, 1)}
+	storage.SetMetricsSink(sink)
+	stop := storage.StartMetricsSampler(10 * time.Second)
+	clock.Advance(10 * time.Second)
+	snapshot = <-sink.snapshots
+	assert.Equal(t, 2, snapshot.OpenTransactions)
+	assert.Equal(t, time.Minute+11*time.Second, snapshot.MaxTransactionAge)
+
+	_, err = storage.Commit(txCtx)
+	assert.NoError(t, err)
+	clock.Advance(10 * time.Second)
+	snapshot = <-sink.snapshots
+	assert.Equal(t, 1, snapshot.OpenTransactions)
+	assert.Equal(t, 21*time.Second, snapshot.MaxTransactionAge)
+
+	stop()
+	clock.Advance(10 * time.Second)
+	select {
+	case <-sink.snapshots:
+		t.Error("The sampler is stopped")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	metrics := mysql.NewExpvarMetrics("mysql_snapshot_test")
+	metrics.ObserveSnapshot(snapshot)
+	assert.Equal(t, "1", metrics.Gauges.Get("open_transactions").String())
+	assert.Equal(t, "21000000000", metrics.Gauges.Get("max_transaction_age_ns").String())
+}
+
+func (s *DBTestSuite) TestSlowQueryHandler() {
+	var queries []mysql.SlowQuery
+	s.storage.SetSlowQueryHandler(100*time.Millisecond, func(_ context.Context, query mysql.SlowQuery) {
+		queries = append(queries, query)
+	})
+	defer s.storage.SetSlowQueryHandler(0, nil)
+
+	_, err := s.storage.Exec(context.Background(), "SELECT 1")
+	s.NoError(err)
+
+	rows, err := s.storage.RawQuery(context.Background(), "SELECT SLEEP(?)", 0.2)
+	if s.NoError(err) {
+		rows.Close()
+	}
+
+	if s.Len(queries, 1) {
+		s.Equal("SELECT SLEEP(?)", queries[0].SQL)
+		s.Equal([]interface{}{0.2}, queries[0].Args)
+		s.GreaterOrEqual(int64(queries[0].Duration), int64(200*time.Millisecond))
+		s.False(queries[0].InTransaction)
+	}
+}
+
+func (s *DBTestSuite) TestMaxExecutionTime() {
+	ctx := mysql.WithMaxExecutionTime(context.Background(), 100*time.Millisecond)
+
+	// The interrupted SLEEP returns 1 without the error
+	start := time.Now()
+	rows, err := s.storage.RawQuery(ctx, "SELECT SLEEP(2)")
+	if s.NoError(err) {
+		rows.Close()
+	}
+	s.Less(int64(time.Since(start)), int64(time.Second))
+
+	start = time.Now()
+	_, err = s.storage.Exec(ctx, "DO SLEEP(2)")
+	s.NoError(err)
+	s.Less(int64(time.Since(start)), int64(time.Second))
+
+	start = time.Now()
+	err = s.storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.storage.Exec(ctx, "UPDATE user SET name=name WHERE SLEEP(2)=0")
+		return err
+	})
+	s.True(mysql.IsMaxExecutionTimeExceeded(err))
+	s.Less(int64(time.Since(start)), int64(time.Second))
+}
+
+func (s *DBTestSuite) TestCanceledContext() {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := s.storage.RawQuery(ctx, "SELECT SLEEP(10)")
+	s.True(errors.Is(err, context.Canceled))
+	var stmtErr *mysql.StatementError
+	if s.True(errors.As(err, &stmtErr)) {
+		s.Equal("SELECT SLEEP(10)", stmtErr.SQL)
+	}
+	s.Less(int64(time.Since(start)), int64(time.Second))
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start = time.Now()
+	err = s.storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.storage.Exec(ctx, "DO SLEEP(10)")
+		return err
+	})
+	s.True(errors.Is(err, context.Canceled))
+	s.Less(int64(time.Since(start)), int64(time.Second))
+}
+
+// firedTimerClock is the clock the timers of which are fired already when they are stopped
+type firedTimerClock struct {
+	mysql.SystemClock
+	f func()
+}
+
+func (c *firedTimerClock) AfterFunc(_ time.Duration, f func()) func() bool {
+	c.f = f
+	return func() bool { return false }
+}
+
+func TestMySQL_MaxExecutionTime_FiredTimer(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	clock := &firedTimerClock{}
+	storage.SetClock(clock)
+
+	_, err := storage.Exec(mysql.WithMaxExecutionTime(context.Background(), time.Hour), "UPDATE user SET name=?", "a")
+	assert.NoError(t, err)
+
+	// The timer which fired as the statement was done does not kill the next statement of the connection
+	clock.f()
+	assert.Equal(t, "UPDATE user SET name=?", lastFakeQuery())
+}
+
+// TestMySQL_ContextVariants fails if the package runs a statement without the context of the caller
+func TestMySQL_ContextVariants(t *testing.T) {
+	// The calls without the caller, by the file and the function
+	allowed := map[string]bool{
+		"conninit.go:ExecConn":        true, // the legacy drivers without the context variants
+		"named.go:RegisterNamedQuery": true, // the check of the template by the registration
+		"timeout.go:execWithKill":     true, // the kill of the statement the context of which is done
+	}
+	nonContext := map[string]bool{"Exec": true, "Query": true, "QueryRow": true, "Prepare": true, "Begin": true, "Ping": true}
+
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, pkg := range pkgs {
+		for fileName, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Body == nil || allowed[filepath.Base(fileName)+":"+fn.Name.Name] {
+					continue
+				}
+
+				ast.Inspect(fn.Body, func(n ast.Node) bool {
+					call, ok := n.(*ast.CallExpr)
+					if !ok {
+						return true
+					}
+					sel, ok := call.Fun.(*ast.SelectorExpr)
+					if !ok {
+						return true
+					}
+
+					pos := fset.Position(call.Pos())
+					// The methods of the storage take the context first, the ones of database/sql take the query
+					if nonContext[sel.Sel.Name] && !isContextArg(call.Args) {
+						t.Errorf("%s: %s is called without the context", pos, sel.Sel.Name)
+					}
+					if strings.HasSuffix(sel.Sel.Name, "Context") || sel.Sel.Name == "Conn" || sel.Sel.Name == "BeginTx" {
+						if len(call.Args) > 0 && isBackgroundContext(call.Args[0]) {
+							t.Errorf("%s: %s is called with the background context", pos, sel.Sel.Name)
+						}
+					}
+
+					return true
+				})
+			}
+		}
+	}
+}
+
+func isContextArg(args []ast.Expr) bool {
+	if len(args) == 0 {
+		return false
+	}
+
+	switch arg := args[0].(type) {
+	case *ast.Ident:
+		return arg.Name == "ctx"
+	case *ast.SelectorExpr:
+		return arg.Sel.Name == "ctx"
+	case *ast.CallExpr:
+		return true
+	}
+
+	return false
+}
+
+func isBackgroundContext(arg ast.Expr) bool {
+	call, ok := arg.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+
+	return ok && pkg.Name == "context" && (sel.Sel.Name == "Background" || sel.Sel.Name == "TODO")
+}
+
+func TestMySQL_SetSlowQueryHandler(t *testing.T) {
+	storage := mysql.NewMySQL()
+	if !assert.NoError(t, storage.Connect("user:pass@tcp(127.0.0.1:1)/test")) {
+		return
+	}
+	defer storage.Disconnect()
+
+	var queries []mysql.SlowQuery
+	storage.SetSlowQueryHandler(time.Nanosecond, func(_ context.Context, query mysql.SlowQuery) {
+		queries = append(queries, query)
+	})
+	storage.SetArgsRedactor(func(_ string, args []interface{}) []interface{} {
+		for i := range args {
+			args[i] = "***"
+		}
+		return args
+	})
+
+	args := []interface{}{"secret"}
+	_, err := storage.Exec(context.Background(), "UPDATE `user` SET `password`=?", args...)
+	assert.Error(t, err)
+
+	if assert.Len(t, queries, 1) {
+		assert.Equal(t, "UPDATE `user` SET `password`=?", queries[0].SQL)
+		assert.Equal(t, []interface{}{"***"}, queries[0].Args)
+	}
+	assert.Equal(t, []interface{}{"secret"}, args)
+}
+
+func (s *DBTestSuite) TestModel_Cache() {
+	s.TestModel_Add()
+
+	ctx := context.Background()
+	cache := mysql.NewLRUCache(100)
+	s.user.SetCache(cache, time.Minute)
+
+	getName := func(ctx context.Context) interface{} {
+		data, err := s.user.GetAll(ctx, []string{"name"}, model.GetAllOptions{Filter: expr.Eq(s.user.FieldExpr("id"), expr.Value(1))})
+		if s.NoError(err) && s.Equal(1, data.Len()) {
+			return data.Maps()[0]["name"]
+		}
+		return nil
+	}
+
+	s.Equal("Ivan", getName(ctx))
+	cachedLen := cache.Len()
+	s.Equal("Ivan", getName(ctx))
+	s.Equal(cachedLen, cache.Len())
+
+	// The direct change is not seen until the model is written through the storage
+	_, err := s.storage.Exec(ctx, "UPDATE `user` SET `name`='Ivan2' WHERE `id`=1")
+	s.NoError(err)
+	s.Equal("Ivan", getName(ctx))
+
+	s.NoError(s.storage.Edit(ctx, s.user, expr.Eq(s.user.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "Ivan3"}))
+	s.Equal("Ivan3", getName(ctx))
+
+	// The transaction bypasses the cache and the commit invalidates it
+	s.NoError(s.storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		s.Equal("Ivan3", getName(ctx))
+		s.NoError(s.storage.Edit(ctx, s.user, expr.Eq(s.user.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "Ivan4"}))
+		s.Equal("Ivan4", getName(ctx))
+		s.Equal("Ivan3", getName(context.Background()))
+		return nil
+	}))
+	s.Equal("Ivan4", getName(ctx))
+}
+
+func TestLRUCache(t *testing.T) {
+	clock := testutil.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := mysql.NewLRUCache(2)
+	cache.SetClock(clock)
+
+	cache.Set("a", 1, 0)
+	cache.Set("b", 2, 0)
+	_, _ = cache.Get("a")
+	cache.Set("c", 3, 0)
+
+	_, ok := cache.Get("b")
+	assert.False(t, ok)
+	value, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, value)
+	assert.Equal(t, 2, cache.Len())
+
+	cache.Delete("a")
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+
+	cache.Set("d", 4, time.Minute)
+	_, ok = cache.Get("d")
+	assert.True(t, ok)
+	clock.Advance(time.Minute + time.Nanosecond)
+	_, ok = cache.Get("d")
+	assert.False(t, ok)
+}
+
+func TestMySQL_Cache_Replica(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	replica, err := sql.Open("mysql_fake", "replica")
+	if !assert.NoError(t, err) {
+		return
+	}
+	storage.AddReplicaDB(replica)
+
+	lastDSN := func() string {
+		fakeExecMtx.Lock()
+		defer fakeExecMtx.Unlock()
+		return fakeLastDSN
+	}
+	fakeExecMtx.Lock()
+	fakeLastDSN = ""
+	fakeExecMtx.Unlock()
+
+	// The missed results are read from the primary server as the replica can lag behind the cache version
+	user := test.NewUser(storage)
+	user.SetCache(mysql.NewLRUCache(10), time.Minute)
+	_, err = user.GetAll(context.Background(), []string{"id"}, model.GetAllOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "", lastDSN())
+
+	_, err = storage.RawQuery(context.Background(), "SELECT 1")
+	assert.NoError(t, err)
+	assert.Equal(t, "replica", lastDSN())
+}
+
+func (s *DBTestSuite) TestParallel_InTransaction() {
+	s.NoError(s.storage.DoInTransaction(context.Background(), func(ctx context.Context) error {
+		var order []int
+		s.NoError(s.storage.Parallel(ctx,
+			func(ctx context.Context) error { order = append(order, 1); return nil },
+			func(ctx context.Context) error { order = append(order, 2); return nil },
+		))
+		s.Equal([]int{1, 2}, order)
+
+		s.Error(s.storage.ParallelWithOpts(ctx, mysql.ParallelOpts{FailInTransaction: true}, func(ctx context.Context) error { return nil }))
+
+		return nil
+	}))
+}
+
+func TestMySQL_Parallel(t *testing.T) {
+	storage := mysql.NewMySQL()
+	sink := &testMetricsSink{}
+	storage.SetMetricsSink(sink)
+
+	var (
+		mtx              sync.Mutex
+		running, maxRuns int
+	)
+	f := func(ctx context.Context) error {
+		mtx.Lock()
+		running++
+		if running > maxRuns {
+			maxRuns = running
+		}
+		mtx.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mtx.Lock()
+		running--
+		mtx.Unlock()
+
+		return nil
+	}
+
+	assert.NoError(t, storage.ParallelWithOpts(context.Background(), mysql.ParallelOpts{Workers: 2}, f, f, f, f, f))
+	assert.Equal(t, 2, maxRuns)
+	assert.Len(t, sink.queries, 5)
+
+	// The second callback is either skipped or cancelled
+	var timedOut bool
+	err := storage.Parallel(context.Background(),
+		func(ctx context.Context) error { return fmt.Errorf("failed") },
+		func(ctx context.Context) error {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+				timedOut = true
+			}
+			return nil
+		},
+	)
+	assert.EqualError(t, err, "failed")
+	assert.False(t, timedOut)
+
+	assert.Error(t, storage.Parallel(context.Background(), func(ctx context.Context) error { panic("callback") }))
+}
+
+func TestMySQL_SetRetryPolicy(t *testing.T) {
+	defer func(driverName string) { mysql.SqlDriver = driverName }(mysql.SqlDriver)
+	mysql.SqlDriver = "mysql_fake"
+
+	storage := mysql.NewMySQL(mysql.PoolConfig{})
+	user := test.NewUser(storage)
+	if !assert.NoError(t, storage.Connect("")) {
+		return
+	}
+	defer storage.Disconnect()
+
+	sink := &testMetricsSink{}
+	storage.SetMetricsSink(sink)
+
+	atomic.StoreInt32(&fakeFailures, 1)
+	_, err := storage.Query(context.Background(), user, []string{"id"}, model.GetAllOptions{})
+	assert.Error(t, err)
+
+	policy := mysql.DefaultRetryPolicy()
+	policy.Backoff = time.Millisecond
+	storage.SetRetryPolicy(policy)
+
+	sink.queries = nil
+	atomic.StoreInt32(&fakeFailures, 2)
+	data, err := storage.Query(context.Background(), user, []string{"id"}, model.GetAllOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, fakeRowsCount, data.Len())
+	}
+	assert.Equal(t, []observedQuery{
+		{"user", "retry", -1, true},
+		{"user", "retry", -1, true},
+		{"user", "select", -1, false},
+	}, sink.queries)
+
+	atomic.StoreInt32(&fakeFailures, 3)
+	_, err = storage.Query(context.Background(), user, []string{"id"}, model.GetAllOptions{})
+	assert.Error(t, err)
+	atomic.StoreInt32(&fakeFailures, 0)
+}
+
+func newFakeStorage(t *testing.T) *mysql.MySQL {
+	driverName := mysql.SqlDriver
+	mysql.SqlDriver = "mysql_fake"
+	defer func() { mysql.SqlDriver = driverName }()
+
+	storage := mysql.NewMySQL(mysql.PoolConfig{})
+	if !assert.NoError(t, storage.Connect("")) {
+		t.FailNow()
+	}
+
+	fakeExecMtx.Lock()
+	fakeExecArgs = nil
+	fakeExecMtx.Unlock()
+
+	return storage
+}
+
+// argsRecordingExecutor records the arguments of the queries run by the base executor
+type argsRecordingExecutor struct {
+	mysql.Executor
+	args *[]interface{}
+}
+
+func (e argsRecordingExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	*e.args = args
+	return e.Executor.QueryContext(ctx, query, args...)
+}
+
+func TestMySQL_GetByPKsForUpdate(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var args []interface{}
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return argsRecordingExecutor{base, &args}
+	}})
+	defer storage.Disconnect()
+	assert.NoError(t, storage.SetServerVersion("8.0.32"))
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+
+	_, err = user.GetByPKForUpdate(ctx, 1, []string{"name"}, mysql.LockWaitDefault)
+	assert.Error(t, err)
+
+	assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		// The keys are sorted, the rows are returned in the order of the keys
+		data, err := user.GetByPKsForUpdate(ctx, []interface{}{3, 1, 2}, []string{"id", "name"}, mysql.LockWaitDefault)
+		if assert.NoError(t, err) && assert.Equal(t, 3, data.Len()) {
+			assert.Equal(t, []interface{}{uint32(3), uint32(1), uint32(2)}, []interface{}{data.Data()[0][0], data.Data()[1][0], data.Data()[2][0]})
+		}
+		assert.Equal(t, "SELECT `id`,`name` FROM `user` WHERE `id` IN (?,?,?) ORDER BY `id` FOR UPDATE", lastFakeQuery())
+		assert.Equal(t, []interface{}{1, 2, 3}, args)
+
+		return storage.DoInTransaction(ctx, func(ctx context.Context) error {
+			row, err := user.GetByPKForUpdate(ctx, 1, []string{"name"}, mysql.LockNoWait)
+			if assert.NoError(t, err) {
+				assert.Equal(t, map[string]interface{}{"name": "Name"}, row)
+			}
+			assert.Equal(t, "SELECT `name`,`id` FROM `user` WHERE `id` IN (?) ORDER BY `id` FOR UPDATE NOWAIT", lastFakeQuery())
+
+			_, err = user.GetByPKsForUpdate(ctx, []interface{}{1}, []string{"name"}, mysql.LockSkipLocked)
+			assert.NoError(t, err)
+			assert.Equal(t, "SELECT `name`,`id` FROM `user` WHERE `id` IN (?) ORDER BY `id` FOR UPDATE SKIP LOCKED", lastFakeQuery())
+			return nil
+		})
+	}))
+
+	assert.NoError(t, storage.SetServerVersion("5.7.44"))
+	assert.Error(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		_, err := user.GetByPKForUpdate(ctx, 1, []string{"name"}, mysql.LockNoWait)
+		return err
+	}))
+
+	_, err = storage.Select(ctx, user, []string{"id"}, mysql.SelectOptions{LockWait: mysql.LockSkipLocked})
+	assert.Error(t, err)
+}
+
+func TestMySQL_ClaimNext(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	}})
+	defer storage.Disconnect()
+	assert.NoError(t, storage.SetServerVersion("8.0.32"))
+
+	// The fake driver ignores LIMIT, all its rows are claimed in the single IN
+	storage.SetInChunkSize(0)
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+	unclaimed := expr.Eq(user.FieldExpr("lastname"), expr.Value(""))
+	orderBy := []model.Order{{FieldName: "id"}}
+
+	_, err = user.ClaimNext(ctx, unclaimed, orderBy, nil)
+	assert.Error(t, err)
+
+	row, err := user.ClaimNext(ctx, unclaimed, orderBy, map[string]interface{}{"lastname": "worker1"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{"id": uint32(1), "name": "Name", "lastname": "Lastname"}, row)
+	}
+	if assert.True(t, len(queries) >= 3) {
+		assert.Equal(t, "SELECT `id` FROM `user` WHERE `lastname`=? ORDER BY `id` LIMIT 1 FOR UPDATE SKIP LOCKED", queries[0])
+		assert.True(t, strings.HasPrefix(queries[1], "UPDATE `user` SET `lastname`=? WHERE `id` IN ("), queries[1])
+		assert.True(t, strings.HasPrefix(queries[2], "SELECT `id`,`name`,`lastname` FROM `user` WHERE `id` IN ("), queries[2])
+	}
+
+	// Without SKIP LOCKED the claim waits for the locked rows shortly
+	assert.NoError(t, storage.SetServerVersion("5.7.44"))
+	queries = nil
+	_, err = user.ReleaseStale(ctx, expr.Eq(user.FieldExpr("lastname"), expr.Value("worker1")), map[string]interface{}{"lastname": ""})
+	assert.NoError(t, err)
+	if assert.True(t, len(queries) >= 3) {
+		assert.Equal(t, "SET @claim_lock_wait_timeout=@@SESSION.innodb_lock_wait_timeout,SESSION innodb_lock_wait_timeout=1", queries[0])
+		assert.Equal(t, "SELECT `id` FROM `user` WHERE `lastname`=? FOR UPDATE", queries[1])
+		assert.Equal(t, "SET SESSION innodb_lock_wait_timeout=@claim_lock_wait_timeout", queries[2])
+	}
+}
+
+func TestMySQL_WithConsistentSnapshot(t *testing.T) {
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	}})
+	defer storage.Disconnect()
+	storage.SetPlainSavepointNames(true)
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+
+	assert.NoError(t, storage.WithConsistentSnapshot(ctx, func(ctx context.Context) error {
+		_, err := storage.Select(ctx, user, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{Limit: 1}})
+		assert.NoError(t, err)
+		assert.Nil(t, storage.GetTransaction(ctx))
+
+		err = user.Edit(ctx, expr.Eq(user.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "Ivan"})
+		assert.ErrorIs(t, err, mysql.ErrReadOnlySnapshot)
+		_, err = storage.Exec(ctx, "DELETE FROM `user`")
+		assert.ErrorIs(t, err, mysql.ErrReadOnlySnapshot)
+
+		// The nested transactions are the savepoints of the snapshot
+		assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error { return nil }))
+		_, err = storage.Commit(ctx)
+		assert.Error(t, err)
+		return nil
+	}))
+	assert.Equal(t, []string{
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY",
+		"SELECT `id` FROM `user` LIMIT 1",
+		"SAVEPOINT SP1",
+		"RELEASE SAVEPOINT SP1",
+		"ROLLBACK",
+	}, queries)
+
+	// The error of the function is returned after the rollback
+	assert.EqualError(t, storage.WithConsistentSnapshot(ctx, func(ctx context.Context) error {
+		return errors.New("failed")
+	}), "failed")
+	assert.Equal(t, "ROLLBACK", queries[len(queries)-1])
+
+	// The snapshot is rolled back if the function panics
+	queries = nil
+	assert.Panics(t, func() {
+		_ = storage.WithConsistentSnapshot(ctx, func(ctx context.Context) error { panic("failed") })
+	})
+	assert.Equal(t, []string{"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY", "ROLLBACK"}, queries)
+
+	// The connection is not returned to the pool if the rollback fails
+	fakeExecMtx.Lock()
+	opens := fakeOpens
+	fakeExecMtx.Unlock()
+	assert.Error(t, storage.WithConsistentSnapshot(ctx, func(ctx context.Context) error {
+		fakeExecMtx.Lock()
+		defer fakeExecMtx.Unlock()
+		fakeExecErr, fakeExecErrs = errors.New("rollback failed"), 1
+		return nil
+	}))
+	assert.NoError(t, storage.WithConsistentSnapshot(ctx, func(ctx context.Context) error { return nil }))
+	fakeExecMtx.Lock()
+	assert.Equal(t, opens+1, fakeOpens)
+	fakeExecMtx.Unlock()
+
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+	assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		assert.Error(t, storage.WithConsistentSnapshot(ctx, func(ctx context.Context) error { return nil }))
+		return nil
+	}))
+}
+
+func TestMySQL_Models(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	phone := test.NewPhone(storage)
+	relation.AddOneToOne(phone, user)
+
+	status := "new"
+	task := mysql.NewBaseModel(storage, "task", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.VarCharField{Id: "status", Length: 16, NotNull: true, Default: &status},
+		&mysql.TextField{Id: "description"},
+	}, nil, mysql.BaseModelOpts{
+		BaseModelOpts:   model.BaseModelOpts{PkFieldsNames: []string{"id"}},
+		FullTextIndexes: []mysql.FullTextIndex{{FieldNames: []string{"description"}}},
+	})
+
+	info := task.Describe()
+	assert.Equal(t, mysql.ModelInfo{
+		Id:    "task",
+		Table: "task",
+		Fields: []mysql.FieldInfo{
+			{Id: "id", SQLType: "INT UNSIGNED", AutoIncrement: true},
+			{Id: "status", SQLType: "VARCHAR(16)", Default: &status},
+			{Id: "description", SQLType: "TEXT", Nullable: true},
+		},
+		PK:        []string{"id"},
+		Indexes:   []mysql.IndexInfo{{Model: "task", Name: "ft_task__description", FieldsNames: []string{"description"}, FullText: true}},
+		Relations: []mysql.RelationInfo{},
+	}, info)
+
+	models := storage.Models()
+	if assert.Len(t, models, 3) {
+		assert.Equal(t, []string{"phone", "task", "user"}, []string{models[0].Id, models[1].Id, models[2].Id})
+
+		userInfo := models[2]
+		assert.Equal(t, []string{"id"}, userInfo.PK)
+		assert.Equal(t, mysql.FieldInfo{Id: "fullname", Caption: "Full name", Derivable: true, DependsOn: []string{"name", "lastname"}},
+			userInfo.Fields[len(userInfo.Fields)-1])
+		assert.Equal(t, []mysql.IndexInfo{
+			{Model: "user", Name: "user__name", FieldsNames: []string{"name"}},
+			{Model: "user", Name: "user__lastname_name", FieldsNames: []string{"lastname", "name"}},
+		}, userInfo.Indexes)
+		if assert.Len(t, userInfo.Relations, 1) {
+			assert.Equal(t, "phone", userInfo.Relations[0].ExtModel)
+			assert.Equal(t, "one-to-one", userInfo.Relations[0].Type)
+			assert.True(t, userInfo.Relations[0].Back)
+		}
+	}
+
+	// The descriptions are copies
+	info.PK[0] = "status"
+	assert.Equal(t, []string{"id"}, task.GetPKFieldsNames())
+
+	_, err := json.Marshal(models)
+	assert.NoError(t, err)
+
+	// The models with the same table are rejected
+	storage.SetTablePrefix("app_")
+	assert.Panics(t, func() {
+		model.NewBaseModel("app_user", []model.IFieldDefinition{&mysql.UintField{Id: "id"}}, storage, model.BaseModelOpts{})
+	})
+}
+
+// argFailingExecutor fails the statements with the argument the error of which is in errs
+type argFailingExecutor struct {
+	mysql.Executor
+	errs map[interface{}]error
+}
+
+func (e argFailingExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	for _, arg := range args {
+		if err, exists := e.errs[arg]; exists {
+			return nil, err
+		}
+	}
+	return e.Executor.ExecContext(ctx, query, args...)
+}
+
+func TestMySQL_AddBatch(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return argFailingExecutor{base, map[interface{}]error{
+			"duplicate": &mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry 'duplicate' for key 'name'"},
+			"deadlock":  &mysqldriver.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+		}}
+	}})
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+
+	rows := func(names ...string) *model.Data {
+		data := model.NewEmptyData([]string{"id", "name", "lastname"})
+		for i, name := range names {
+			assert.NoError(t, data.Add([]interface{}{uint32(i + 1), name, "Lastname"}))
+		}
+		return data
+	}
+	data := rows("a", "b", "c", "d", "e", "duplicate", "g", "h")
+
+	// The failed chunk is bisected down to the failed row
+	res, err := user.AddBatch(ctx, data, mysql.BatchOpts{ChunkSize: 8, BestEffort: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []int{0, 1, 2, 3, 4, 6, 7}, res.Succeeded)
+		if assert.Len(t, res.Failed, 1) {
+			assert.Equal(t, 5, res.Failed[0].Row)
+			assert.ErrorIs(t, res.Failed[0].Err, mysql.ErrDuplicateKey)
+		}
+		assert.Equal(t, 7, res.Ids.Len())
+		assert.Equal(t, uint32(7), res.Ids.Data()[5][0])
+	}
+
+	// The fail-fast batch reports the range of the failed chunk
+	_, err = user.AddBatch(ctx, data, mysql.BatchOpts{ChunkSize: 3})
+	var batchErr *mysql.BatchError
+	if assert.ErrorAs(t, err, &batchErr) {
+		assert.Equal(t, 3, batchErr.FirstRow)
+		assert.Equal(t, 5, batchErr.LastRow)
+		assert.ErrorIs(t, err, mysql.ErrDuplicateKey)
+	}
+
+	// The errors not caused by the rows stop the batch
+	res, err = user.AddBatch(ctx, rows("a", "b", "deadlock", "d"), mysql.BatchOpts{ChunkSize: 2, BestEffort: true})
+	assert.ErrorIs(t, err, mysql.ErrDeadlock)
+	if assert.NotNil(t, res) {
+		assert.Equal(t, []int{0, 1}, res.Succeeded)
+	}
+
+	// The edits are bisected in the transaction of the context by the savepoints
+	assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		res, err := user.EditBatch(ctx, []interface{}{1, 2, 3, 4}, []map[string]interface{}{
+			{"name": "a"}, {"name": "b"}, {"name": "duplicate"}, {"name": "d"},
+		}, mysql.BatchOpts{BestEffort: true})
+		if assert.NoError(t, err) {
+			assert.Equal(t, []int{0, 1, 3}, res.Succeeded)
+			assert.Len(t, res.Failed, 1)
+			assert.Nil(t, res.Ids)
+		}
+		return nil
+	}))
+}
+
+func TestMySQL_UnknownFields(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+
+	check := func(err error, field string, part mysql.ExprPart, suggestion string) {
+		var unknownErr *mysql.UnknownFieldError
+		if assert.ErrorIs(t, err, mysql.ErrUnknownField) && assert.ErrorAs(t, err, &unknownErr) {
+			assert.Equal(t, mysql.UnknownFieldError{Model: "user", Field: field, Part: part, Suggestion: suggestion}, *unknownErr)
+		}
+	}
+
+	_, err := storage.Select(ctx, user, []string{"id", "naem"}, mysql.SelectOptions{})
+	check(err, "naem", mysql.PartSelect, "name")
+	assert.EqualError(t, err, "Unknown field 'naem' in model 'user' in the select, did you mean 'name'?")
+
+	_, err = storage.Select(ctx, user, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: expr.Eq(mysql.Col("lastnme"), expr.Value("Smith")),
+	}})
+	check(err, "lastnme", mysql.PartCondition, "lastname")
+
+	_, err = storage.Select(ctx, user, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		OrderBy: []model.Order{{FieldName: "ID"}},
+	}})
+	check(err, "ID", mysql.PartOrder, "id")
+
+	_, err = storage.Select(ctx, user, []string{"name"}, mysql.SelectOptions{GroupBy: []model.IExpression{mysql.Col("surname")}})
+	check(err, "surname", mysql.PartGroup, "")
+
+	check(user.Edit(ctx, expr.Eq(user.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"nmae": "Ivan"}), "nmae", mysql.PartValue, "name")
+
+	_, err = user.AddMulti(ctx, model.NewData([]string{"name", "lastnam"}, [][]interface{}{{"Ivan", "Ivanov"}}), model.AddOptions{})
+	check(err, "lastnam", mysql.PartValue, "lastname")
+
+	// The raw expressions are not validated
+	_, err = storage.Select(ctx, user, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: mysql.RawExpr("naem = ?", "Ivan"),
+	}})
+	assert.NoError(t, err)
+}
+
+func TestMySQL_BatchDerivableField(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	var calls, rowsCount int
+	person := mysql.NewBaseModel(storage, "person", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "name", Length: 32, NotNull: true},
+		&mysql.VarCharField{Id: "lastname", Length: 32, NotNull: true},
+	}, []model.IFieldDefinition{
+		&mysql.BatchDerivableField{
+			Id:        "initials",
+			DependsOn: []string{"name", "lastname"},
+			GetBatch: func(ctx context.Context, rows []map[string]interface{}) ([]interface{}, error) {
+				if len(rows) == 0 {
+					return nil, errors.New("no rows")
+				}
+				calls, rowsCount = calls+1, rowsCount+len(rows)
+				res := make([]interface{}, len(rows))
+				for i, row := range rows {
+					res[i] = row["name"].(string)[:1] + row["lastname"].(string)[:1]
+				}
+				return res, nil
+			},
+		},
+		&mysql.BatchDerivableField{
+			Id:        "fullname",
+			DependsOn: []string{"name", "lastname"},
+			GetBatch: mysql.BatchOf(func(ctx context.Context, row map[string]interface{}) (interface{}, error) {
+				return row["name"].(string) + " " + row["lastname"].(string), nil
+			}),
+		},
+		&mysql.BatchDerivableField{
+			Id:        "broken",
+			DependsOn: []string{"name"},
+			GetBatch: func(ctx context.Context, rows []map[string]interface{}) ([]interface{}, error) {
+				return nil, nil
+			},
+		},
+	}, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	ctx := context.Background()
+
+	data, err := person.GetAll(ctx, []string{"id", "name", "lastname", "initials", "fullname"}, model.GetAllOptions{})
+	if assert.NoError(t, err) && assert.Equal(t, fakeRowsCount, data.Len()) {
+		assert.Equal(t, "NL", data.Data()[0][data.FieldNum("initials")])
+		assert.Equal(t, "Name Lastname", data.Data()[fakeRowsCount-1][data.FieldNum("fullname")])
+	}
+	assert.Equal(t, 1, calls)
+
+	columnar, err := person.GetAllColumnar(ctx, []string{"id", "initials"}, nil, mysql.ColumnarOpts{Derivable: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "NL", columnar.Columns[1].Values[0])
+	}
+	assert.Equal(t, 2, calls)
+
+	// The streamed rows are computed in chunks, the rows count is a multiple of the chunk size
+	// and there is no empty last chunk
+	buf := &strings.Builder{}
+	_, err = person.ExportJSONL(ctx, buf, []string{"id", "initials"}, nil, mysql.JSONLExportOpts{})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), `{"id":1,"initials":"NL"}`))
+	assert.Equal(t, 2+fakeRowsCount/1000, calls)
+	assert.Equal(t, 3*fakeRowsCount, rowsCount)
+
+	_, err = person.GetAllColumnar(ctx, []string{"id", "broken"}, nil, mysql.ColumnarOpts{Derivable: true})
+	assert.Error(t, err)
+}
+
+func TestMySQL_GetAllColumnar(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+	user := test.NewUser(storage)
+
+	_, err := user.GetAllColumnar(context.Background(), []string{"id", "fullname"}, nil, mysql.ColumnarOpts{})
+	assert.Error(t, err)
+
+	columnar, err := user.GetAllColumnar(context.Background(), []string{"id", "fullname"}, nil, mysql.ColumnarOpts{
+		SizeHint:  10,
+		Derivable: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, fakeRowsCount, columnar.Len)
+	if assert.Len(t, columnar.Columns, 2) {
+		assert.Equal(t, "id", columnar.Columns[0].Name)
+		assert.Len(t, columnar.Columns[0].Uint64s, fakeRowsCount)
+		assert.Nil(t, columnar.Columns[0].Nulls)
+		assert.Equal(t, uint64(2), columnar.Columns[0].Value(1))
+		assert.Equal(t, "Name Lastname", columnar.Column("fullname").Value(0))
+		assert.Nil(t, columnar.Column("name"))
+	}
+}
+
+func TestMySQL_AnalyzeIndexes(t *testing.T) {
+	storage := mysql.NewMySQL()
+	if !assert.NoError(t, storage.Connect("user:pass@tcp(127.0.0.1:1)/test")) {
+		return
+	}
+	defer storage.Disconnect()
+
+	mysql.NewBaseModel(storage, "event", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.UintField{Id: "user_id", NotNull: true},
+		&mysql.VarCharField{Id: "kind", Length: 32, NotNull: true},
+	}, nil, mysql.BaseModelOpts{
+		BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}},
+		Indexes: []mysql.Index{
+			{FieldNames: []string{"user_id"}},
+			{FieldNames: []string{"user_id", "kind"}},
+			{FieldNames: []string{"id"}},
+			{FieldNames: []string{"kind"}, Unique: true},
+		},
+	})
+
+	// The server is not available, the report is built from the models
+	report, err := storage.AnalyzeIndexes(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Len(t, report.Warnings, 3)
+	assert.Empty(t, report.Unused)
+	assert.Empty(t, report.Unindexed)
+	if assert.Len(t, report.Redundant, 2) {
+		assert.Equal(t, "event__user_id", report.Redundant[0].Name)
+		assert.Equal(t, "event__user_id_kind", report.Redundant[0].CoveredBy.Name)
+		assert.Equal(t, "event__id", report.Redundant[1].Name)
+		assert.Equal(t, "PRIMARY", report.Redundant[1].CoveredBy.Name)
+	}
+}
+
+type testLogger struct {
+	mtx     sync.Mutex
+	entries []mysql.LogEntry
+}
+
+func (l *testLogger) Debug(_ context.Context, entry mysql.LogEntry) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.entries = append(l.entries, entry)
+}
+
+// warningLogger records the warnings as well
+type warningLogger struct {
+	testLogger
+	warnings []string
+}
+
+func (l *warningLogger) Warn(_ context.Context, message string) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	l.warnings = append(l.warnings, message)
+}
+
+func TestMySQL_SetLogger(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	logger := &testLogger{}
+	storage.SetLogger(logger)
+	storage.SetDebug(true)
+
+	_, err := storage.Exec(context.Background(), "UPDATE user SET name = ?", "Ivan")
+	assert.NoError(t, err)
+	_, err = storage.StartTransaction(context.Background())
+	assert.Error(t, err)
+
+	storage.SetDebug(false)
+	_, err = storage.Exec(context.Background(), "UPDATE user SET name = ?", "Petr")
+	assert.NoError(t, err)
+
+	if assert.Len(t, logger.entries, 2) {
+		assert.Equal(t, "UPDATE user SET name = ?", logger.entries[0].SQL)
+		assert.Equal(t, []interface{}{"Ivan"}, logger.entries[0].Args)
+		assert.Equal(t, int64(1), logger.entries[0].RowsAffected)
+		assert.Equal(t, 0, logger.entries[0].TxDepth)
+		assert.NoError(t, logger.entries[0].Err)
+
+		assert.Equal(t, "BEGIN", logger.entries[1].SQL)
+		assert.Equal(t, int64(-1), logger.entries[1].RowsAffected)
+		assert.Equal(t, 1, logger.entries[1].TxDepth)
+		assert.Error(t, logger.entries[1].Err)
+	}
+}
+
+func TestMySQL_WithDebug(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	logger := &testLogger{}
+	storage.SetLogger(logger)
+
+	_, err := storage.Exec(context.Background(), "UPDATE user SET name = ?", "Ivan")
+	assert.NoError(t, err)
+	assert.Empty(t, logger.entries)
+
+	ctx := storage.WithDebug(context.Background())
+	assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		_, err := storage.Exec(ctx, "UPDATE user SET name = ?", "Petr")
+		return err
+	}))
+
+	if assert.Len(t, logger.entries, 3) {
+		assert.Equal(t, "BEGIN", logger.entries[0].SQL)
+		assert.Equal(t, "UPDATE user SET name = ?", logger.entries[1].SQL)
+		assert.Equal(t, []interface{}{"Petr"}, logger.entries[1].Args)
+		assert.Equal(t, 1, logger.entries[1].TxDepth)
+		assert.Equal(t, "COMMIT", logger.entries[2].SQL)
+	}
+}
+
+func TestMySQL_SetWriteBehind(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	assert.Error(t, test.NewUser(storage).SetWriteBehind(mysql.WriteBehindOpts{}))
+
+	audit := mysql.NewBaseModel(storage, "audit", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "action", Length: 32, NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	var (
+		mtx  sync.Mutex
+		dead []*model.Data
+	)
+	if !assert.NoError(t, audit.SetWriteBehind(mysql.WriteBehindOpts{
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		MaxRetries:    1,
+		RetryBackoff:  time.Millisecond,
+		DeadLetter: func(err error, data *model.Data) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			dead = append(dead, data)
+		},
+	})) {
+		return
+	}
+
+	// The first batch fails twice and goes to the dead letter
+	atomic.StoreInt32(&fakeFailures, 2)
+	for i := 1; i <= 4; i++ {
+		pk, err := audit.AddMulti(context.Background(), model.NewData([]string{"id", "action"}, [][]interface{}{{uint32(i), "login"}}), model.AddOptions{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, [][]interface{}{{uint32(i)}}, pk.Data())
+		}
+	}
+
+	assert.NoError(t, storage.Drain(context.Background()))
+	_, err := audit.AddMulti(context.Background(), model.NewData([]string{"id", "action"}, [][]interface{}{{uint32(5), "login"}}), model.AddOptions{})
+	assert.Equal(t, mysql.ErrWriteBehindClosed, err)
+
+	assert.Equal(t, []int{2}, fakeExecArgs)
+	if assert.Len(t, dead, 1) {
+		assert.Equal(t, 3, dead[0].Len())
+	}
+}
+
+func TestStreamInserter(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+	user := test.NewUser(storage)
+
+	inserter := storage.NewStreamInserter(context.Background(), user, []string{"name", "lastname"}, mysql.StreamInserterOpts{
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, inserter.Add([]interface{}{"Ivan", "Ivanov"}))
+		}()
+	}
+	wg.Wait()
+
+	assert.Error(t, inserter.Add([]interface{}{"Ivan"}))
+	assert.NoError(t, inserter.Close())
+	assert.Equal(t, mysql.ErrStreamInserterClosed, inserter.Add([]interface{}{"Ivan", "Ivanov"}))
+	assert.Equal(t, []int{6, 2}, fakeExecArgs)
+}
+
+func TestStreamInserter_FlushInterval(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+	user := test.NewUser(storage)
+
+	var (
+		mtx    sync.Mutex
+		failed [][]interface{}
+	)
+	atomic.StoreInt32(&fakeFailures, 1)
+	inserter := storage.NewStreamInserter(context.Background(), user, []string{"name"}, mysql.StreamInserterOpts{
+		FlushInterval: 10 * time.Millisecond,
+		OnError: func(err error, rows [][]interface{}) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			failed = append(failed, rows...)
+		},
+	})
+
+	assert.NoError(t, inserter.Add([]interface{}{"Ivan"}))
+	assert.Eventually(t, func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return len(failed) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, inserter.Add([]interface{}{"Petr"}))
+	assert.Eventually(t, func() bool {
+		fakeExecMtx.Lock()
+		defer fakeExecMtx.Unlock()
+		return len(fakeExecArgs) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, inserter.Close())
+}
+
+func TestStreamInserter_FakeClock(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+	clock := testutil.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage.SetClock(clock)
+
+	inserter := storage.NewStreamInserter(context.Background(), test.NewUser(storage), []string{"name"}, mysql.StreamInserterOpts{
+		FlushInterval: time.Hour,
+	})
+	defer inserter.Close()
+
+	// The rows are flushed by the interval of the clock of the storage
+	assert.NoError(t, inserter.Add([]interface{}{"Ivan"}))
+	executed := func() bool {
+		fakeExecMtx.Lock()
+		defer fakeExecMtx.Unlock()
+		return len(fakeExecArgs) == 1
+	}
+	assert.Never(t, executed, 50*time.Millisecond, 5*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		clock.Advance(time.Hour)
+		return executed()
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestStreamInserter_DropWhenFull(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+	user := test.NewUser(storage)
+
+	atomic.StoreInt32(&fakeFailures, 1)
+	inserter := storage.NewStreamInserter(context.Background(), user, []string{"name"}, mysql.StreamInserterOpts{
+		BatchSize:     1,
+		BufferSize:    1,
+		FlushInterval: time.Hour,
+		DropWhenFull:  true,
+	})
+
+	var dropped int
+	for i := 0; i < 100; i++ {
+		if err := inserter.Add([]interface{}{"Ivan"}); err == mysql.ErrStreamInserterFull {
+			dropped++
+		}
+	}
+	assert.Error(t, inserter.Close())
+	assert.Greater(t, dropped, 0)
+}
+
+func TestMySQL_AddManyToMany(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	group := test.NewGroup(storage)
+	membership := storage.AddManyToMany(user, group, mysql.ManyToManyOpts{})
+
+	assert.Equal(t, "_junction__user__group", membership.GetJunctionModel().GetId())
+	assert.NotNil(t, user.GetRelation("group"))
+	assert.NotNil(t, group.GetRelation("user"))
+
+	sqlBuf := mysql.NewSqlBuffer()
+	membership.GetJunctionModel().WriteCreateSQL(sqlBuf)
+	assert.Equal(t, "CREATE TABLE `_junction__user__group` ("+
+		"`fk_user_id` INT UNSIGNED NOT NULL,"+
+		"`fk_group_id` INT UNSIGNED NOT NULL,"+
+		"PRIMARY KEY (`fk_user_id`,`fk_group_id`),"+
+		"INDEX `_junction__user__group__fk_group_id`(`fk_group_id`),"+
+		"FOREIGN KEY `fk__junction__user__group__fk_group_id___group__id`(`fk_group_id`)"+
+		"REFERENCES `group`(`id`)ON UPDATE RESTRICT ON DELETE RESTRICT,"+
+		"FOREIGN KEY `fk__junction__user__group__fk_user_id___user__id`(`fk_user_id`)"+
+		"REFERENCES `user`(`id`)ON UPDATE RESTRICT ON DELETE RESTRICT"+
+		")ENGINE='InnoDB' DEFAULT CHARACTER SET 'UTF8'", sqlBuf.GetSQL())
+}
+
+func TestMySQL_WriteSelectSQL(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"lastname"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{
+			Filter:  mysql.RawExpr("id > ?", 1),
+			OrderBy: []model.Order{{FieldName: "lastname"}},
+			Limit:   10,
+		},
+		Columns: []mysql.Column{{Alias: "cnt", Expr: mysql.RawExpr("COUNT(*) + ?", 2)}},
+		GroupBy: []model.IExpression{user.FieldExpr("lastname")},
+		Having:  mysql.RawExpr("COUNT(*) > ?", 3),
+		OrderBy: []mysql.OrderExpr{{Expr: mysql.RawExpr("MAX(id) - ?", 4), Desc: true}},
+	})
+
+	if !assert.NoError(t, sqlBuf.Err()) {
+		return
+	}
+	assert.Equal(t, "SELECT `lastname`,(COUNT(*) + ?) AS `cnt` FROM `user` WHERE (id > ?) GROUP BY `lastname`"+
+		" HAVING (COUNT(*) > ?) ORDER BY `lastname`,(MAX(id) - ?) DESC LIMIT 10", sqlBuf.GetSQL())
+	assert.Equal(t, []interface{}{2, 1, 3, 4}, sqlBuf.GetArgs())
+
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"id"}, mysql.SelectOptions{
+		Columns: []mysql.Column{{Alias: "name", Expr: mysql.RawExpr("1")}},
+	})
+	assert.Error(t, sqlBuf.Err())
+}
+
+func TestMySQL_SetTestMode(t *testing.T) {
+	storage := mysql.NewMySQL()
+	storage.SetTestMode(true)
+	user := test.NewUser(storage)
+
+	write := func(fieldsNames []string, options mysql.SelectOptions) string {
+		sqlBuf := mysql.NewSqlBuffer()
+		storage.WriteSelectSQL(sqlBuf, user, fieldsNames, options)
+		assert.NoError(t, sqlBuf.Err())
+		return sqlBuf.GetSQL()
+	}
+
+	// The SELECTs without the ordering are ordered by the primary key
+	assert.Equal(t, "SELECT `name` FROM `user` ORDER BY `id` LIMIT 5", write([]string{"name"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Limit: 5},
+	}))
+	assert.Equal(t, "SELECT `name` FROM `user` ORDER BY `name`", write([]string{"name"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{OrderBy: []model.Order{{FieldName: "name"}}},
+	}))
+	assert.Equal(t, "SELECT  DISTINCT `name` FROM `user`", write([]string{"name"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Distinct: true},
+	}))
+	assert.Equal(t, "SELECT `name`,(COUNT(*)) AS `cnt` FROM `user` GROUP BY `name`", write([]string{"name"}, mysql.SelectOptions{
+		Columns: []mysql.Column{{Alias: "cnt", Expr: mysql.RawExpr("COUNT(*)")}},
+		GroupBy: []model.IExpression{user.FieldExpr("name")},
+	}))
+}
+
+func TestMySQL_WriteSelectSQL_MaxExecutionTime(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions:    model.GetAllOptions{Distinct: true},
+		MaxExecutionTime: 1500 * time.Millisecond,
+	})
+
+	assert.NoError(t, sqlBuf.Err())
+	assert.Equal(t, "SELECT /*+ MAX_EXECUTION_TIME(1500) */  DISTINCT `id` FROM `user`", sqlBuf.GetSQL())
+	assert.False(t, mysql.IsMaxExecutionTimeExceeded(errors.New("error")))
+	assert.True(t, mysql.IsMaxExecutionTimeExceeded(&mysqldriver.MySQLError{Number: 3024}))
+	assert.True(t, mysql.IsMaxExecutionTimeExceeded(mysql.ErrMaxExecutionTime))
+}
+
+func TestTextField_Compressed(t *testing.T) {
+	field := &mysql.TextField{Id: "body", Charset: "utf8", Compressed: &mysql.Compression{Codec: mysql.GzipCodec{}, MinSize: 16}}
+
+	sqlBuf := mysql.NewSqlBuffer()
+	field.WriteSQL(sqlBuf)
+	assert.Equal(t, "`body` BLOB", sqlBuf.GetSQL())
+	assert.Equal(t, "LONGBLOB", (&mysql.JSONField{Compressed: mysql.DefaultCompression()}).GetStorageType())
+
+	long := strings.Repeat("compressed ", 100)
+	for _, value := range []string{"short", long} {
+		encoded, err := field.EncodeValue(&value)
+		if !assert.NoError(t, err) {
+			return
+		}
+		if value == long {
+			assert.Less(t, len(encoded.([]byte)), len(long))
+		}
+
+		decoded, err := field.DecodeValue(string(encoded.([]byte)))
+		assert.NoError(t, err)
+		assert.Equal(t, value, decoded)
+	}
+
+	decoded, err := field.DecodeValue("legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", decoded)
+
+	encoded, err := field.EncodeValue((*string)(nil))
+	assert.NoError(t, err)
+	assert.Nil(t, encoded)
+}
+
+func TestTextField_Encrypted(t *testing.T) {
+	oldCipher, err := mysql.NewAESGCMCipher(1, map[uint16][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	if !assert.NoError(t, err) {
+		return
+	}
+	newCipher, err := mysql.NewAESGCMCipher(2, map[uint16][]byte{1: bytes.Repeat([]byte{1}, 32), 2: bytes.Repeat([]byte{2}, 16)})
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = mysql.NewAESGCMCipher(3, map[uint16][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	assert.Error(t, err)
+
+	field := &mysql.TextField{Id: "token", Compressed: &mysql.Compression{Codec: mysql.GzipCodec{}, MinSize: 16}, Encrypted: &mysql.Encryption{Cipher: oldCipher}}
+	sqlBuf := mysql.NewSqlBuffer()
+	field.WriteSQL(sqlBuf)
+	assert.Equal(t, "`token` MEDIUMBLOB", sqlBuf.GetSQL())
+	assert.Equal(t, "VARBINARY(73)", (&mysql.VarCharField{Length: 10, Encrypted: &mysql.Encryption{Cipher: oldCipher}}).GetStorageType())
+
+	long := strings.Repeat("encrypted ", 100)
+	for _, value := range []string{"short", long} {
+		encoded, err := field.EncodeValue(&value)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.NotContains(t, string(encoded.([]byte)), "short")
+		if value == long {
+			assert.Less(t, len(encoded.([]byte)), len(long))
+		}
+
+		decoded, err := field.DecodeValue(string(encoded.([]byte)))
+		assert.NoError(t, err)
+		assert.Equal(t, value, decoded)
+
+		// The values encrypted with the previous key are decrypted after the rotation
+		rotated := &mysql.TextField{Id: "token", Compressed: field.Compressed, Encrypted: &mysql.Encryption{Cipher: newCipher}}
+		decoded, err = rotated.DecodeValue(string(encoded.([]byte)))
+		assert.NoError(t, err)
+		assert.Equal(t, value, decoded)
+	}
+
+	decoded, err := field.DecodeValue("legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", decoded)
+
+	encoded, err := field.EncodeValue((*string)(nil))
+	assert.NoError(t, err)
+	assert.Nil(t, encoded)
+}
+
+func TestMySQL_EncryptedFields(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	c, err := mysql.NewAESGCMCipher(1, map[uint16][]byte{1: bytes.Repeat([]byte{1}, 32)})
+	if !assert.NoError(t, err) {
+		return
+	}
+	encryption := &mysql.Encryption{Cipher: c, BlindIndex: "ssn_hash", BlindIndexKey: []byte("key")}
+	person := mysql.NewBaseModel(storage, "person", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "ssn", Caption: "SSN", Length: 11, NotNull: true, Encrypted: encryption},
+		&mysql.TextField{Id: "token", Encrypted: &mysql.Encryption{Cipher: c}},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	person.WriteCreateSQL(sqlBuf)
+	assert.Equal(t, "CREATE TABLE `person` (`id` INT UNSIGNED NOT NULL,`ssn` VARBINARY(77) NOT NULL,`token` MEDIUMBLOB,"+
+		"`ssn_hash` VARBINARY(32),PRIMARY KEY (`id`))ENGINE='InnoDB' DEFAULT CHARACTER SET 'UTF8'", sqlBuf.String())
+
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("123-45-6789"))
+	hash := mac.Sum(nil)
+
+	_, err = person.AddMulti(context.Background(), model.NewData([]string{"id", "ssn"}, [][]interface{}{{uint32(1), "123-45-6789"}}), model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `person`(`id`,`ssn`,`ssn_hash`)VALUES(?,?,?)", lastFakeQuery())
+	if assert.Len(t, fakeExecValues, 3) {
+		assert.Equal(t, []byte{0, 'Q', 'E', 0, 1}, fakeExecValues[1].([]byte)[:5])
+		assert.NotContains(t, string(fakeExecValues[1].([]byte)), "123-45-6789")
+		assert.Equal(t, hash, fakeExecValues[2])
+	}
+
+	assert.NoError(t, person.Edit(context.Background(), expr.Eq(person.FieldExpr("ssn"), expr.Value("123-45-6789")), map[string]interface{}{"ssn": "987-65-4321"}))
+	assert.Equal(t, "UPDATE `person` SET `ssn`=?, `ssn_hash`=? WHERE `ssn_hash`=?", lastFakeQuery())
+	if assert.Len(t, fakeExecValues, 3) {
+		assert.Equal(t, hash, fakeExecValues[2])
+	}
+
+	sqlBuf = mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, person, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: expr.And(
+			mysql.In(person.FieldExpr("ssn"), []interface{}{"123-45-6789", nil}),
+			expr.Ne(expr.Value("000-00-0000"), person.FieldExpr("ssn")),
+		),
+	}})
+	assert.NoError(t, sqlBuf.Err())
+	assert.Equal(t, "SELECT `id` FROM `person` WHERE (`ssn_hash` IN (?,?))AND(`ssn_hash`<>?)", sqlBuf.GetSQL())
+	if assert.Len(t, sqlBuf.GetArgs(), 3) {
+		assert.Equal(t, hash, sqlBuf.GetArgs()[0])
+		assert.Nil(t, sqlBuf.GetArgs()[1])
+	}
+
+	for _, filter := range []model.IExpression{
+		expr.Eq(person.FieldExpr("token"), expr.Value("secret")),
+		expr.Func("LIKE", person.FieldExpr("ssn"), expr.Value("123%")),
+		expr.Lt(person.FieldExpr("ssn"), expr.Value("123")),
+	} {
+		sqlBuf := mysql.NewSqlBuffer()
+		storage.WriteSelectSQL(sqlBuf, person, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{Filter: filter}})
+		assert.ErrorIs(t, sqlBuf.Err(), mysql.ErrEncryptedField)
+	}
+
+	assert.ErrorIs(t, person.Edit(context.Background(), nil, map[string]interface{}{"ssn": mysql.RawExpr("UPPER(`ssn`)")}), mysql.ErrEncryptedField)
+
+	assert.Panics(t, func() {
+		mysql.NewBaseModel(storage, "invalid", []mysql.IMysqlFieldDefinition{
+			&mysql.UintField{Id: "id", NotNull: true},
+			&mysql.BlobField{Id: "data", Encrypted: &mysql.Encryption{BlindIndex: "data_hash"}},
+		}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	})
+}
+
+func TestMySQL_WriteChildrenSQL(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	message := test.NewMessage(storage)
+	relation.AddManyToOne(message, user, relation.WithRequired(true), relation.WithAlias("author"))
+
+	children := mysql.WithChildren(message, "fk_author_id", []string{"id", "text"}, expr.Ne(message.FieldExpr("text"), expr.Value("")))
+	children.OrderBy = []model.Order{{FieldName: "id", Desc: true}}
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteChildrenSQL(sqlBuf, children, []interface{}{uint32(1), uint32(2)})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id`,`text`,`fk_author_id` FROM `message` WHERE (`fk_author_id` IN (?,?))AND(`text`<>?)"+
+			" ORDER BY `id` DESC", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{uint32(1), uint32(2), ""}, sqlBuf.GetArgs())
+	}
+
+	children.Limit = 3
+	sqlBuf.Reset()
+	storage.WriteChildrenSQL(sqlBuf, children, []interface{}{uint32(1)})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id`,`text`,`fk_author_id` FROM (SELECT `id`,`text`,`fk_author_id`,"+
+			"(ROW_NUMBER() OVER (PARTITION BY `fk_author_id` ORDER BY `id` DESC)) AS `_row_number`"+
+			" FROM `message` WHERE (`fk_author_id` IN (?))AND(`text`<>?)) AS `_children`"+
+			" WHERE `_row_number`<=3 ORDER BY `fk_author_id`,`_row_number`", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{uint32(1), ""}, sqlBuf.GetArgs())
+	}
+}
+
+func TestInterpolateSQL(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*3600)
+
+	assert.Equal(t,
+		`SELECT 'It\'s \"quoted\" \\ here', X'000a', NULL, NULL, 1, -2, 3.5, '2020-01-02 06:04:05.123', '2020-01-02', '0000-00-00'`,
+		mysql.InterpolateSQL("SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?", []interface{}{
+			`It's "quoted" \ here`,
+			[]byte{0, '\n'},
+			nil,
+			(*string)(nil),
+			true,
+			int8(-2),
+			3.5,
+			time.Date(2020, 1, 2, 3, 4, 5, 123000000, time.UTC),
+			time.Date(2020, 1, 2, 0, 0, 0, 0, moscow),
+			time.Time{},
+		}, moscow),
+	)
+
+	// The placeholders in the literals are not substituted, the extra ones are left as is
+	assert.Equal(t, "SELECT '?', `a?`, 'x', ?", mysql.InterpolateSQL("SELECT '?', `a?`, ?, ?", []interface{}{"x"}, nil))
+	assert.Equal(t, "SELECT '2020-01-02 03:04:05'", mysql.InterpolateSQL("SELECT ?", []interface{}{time.Date(2020, 1, 2, 6, 4, 5, 0, moscow)}, nil))
+}
+
+func TestMySQL_SetInterpolateSQL(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	logger := &testLogger{}
+	storage.SetLogger(logger)
+	storage.SetDebug(true)
+	storage.SetInterpolateSQL(true)
+
+	accounts := mysql.NewBaseModel(storage, "account", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "login", Length: 32, NotNull: true},
+		&mysql.VarCharField{Id: "password", Length: 64, NotNull: true, Sensitive: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	_, err := accounts.AddMulti(context.Background(), model.NewData([]string{"id", "login", "password"}, [][]interface{}{
+		{uint32(1), "ivan", "secret'1"},
+	}), model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []driver.Value{int64(1), "ivan", "secret'1"}, fakeExecValues)
+
+	assert.NoError(t, accounts.Edit(context.Background(), expr.Eq(expr.ModelField(accounts, "password"), expr.Value("secret'1")),
+		map[string]interface{}{"password": "secret'2"},
+	))
+	assert.Equal(t, []driver.Value{"secret'2", "secret'1"}, fakeExecValues)
+
+	if assert.Len(t, logger.entries, 2) {
+		assert.Equal(t, "INSERT INTO `account`(`id`,`login`,`password`)VALUES(1,'ivan',[redacted])", logger.entries[0].InterpolatedSQL)
+		assert.Equal(t, "UPDATE `account` SET `password`=[redacted] WHERE `password`=[redacted]", logger.entries[1].InterpolatedSQL)
+		assert.Equal(t, "[[redacted] [redacted]]", fmt.Sprint(logger.entries[1].Args))
+	}
+}
+
+func TestRecordingPolicy_Sanitize(t *testing.T) {
+	sensitive := map[string]bool{"password": true, "token": true}
+
+	for _, tc := range []struct {
+		name   string
+		policy mysql.RecordingPolicy
+		query  string
+		res    string
+	}{
+		{"zero", mysql.RecordingPolicy{}, "SELECT 1 /* c */ FROM `t` WHERE `id` IN (1,2)", "SELECT 1 /* c */ FROM `t` WHERE `id` IN (1,2)"},
+		{"where", mysql.RecordingPolicy{}, "SELECT `id` FROM `user` WHERE `login`='ivan' AND `password` = 'sec''ret' AND token<>X'0a'",
+			"SELECT `id` FROM `user` WHERE `login`='ivan' AND `password` = " + mysql.RedactedValue + " AND token<>" + mysql.RedactedValue},
+		{"reversed", mysql.RecordingPolicy{}, "SELECT 1 FROM `user` WHERE 'secret'=`user`.`password`", "SELECT 1 FROM `user` WHERE " + mysql.RedactedValue + "=`user`.`password`"},
+		{"in and like", mysql.RecordingPolicy{}, "SELECT 1 FROM `user` WHERE `password` NOT IN ('a',_binary'b') OR `password` LIKE 'c%'",
+			"SELECT 1 FROM `user` WHERE `password` NOT IN (" + mysql.RedactedValue + "," + mysql.RedactedValue + ") OR `password` LIKE " + mysql.RedactedValue},
+		{"set", mysql.RecordingPolicy{}, "UPDATE `user` SET `login`='ivan', `password`='secret' WHERE `id`=1",
+			"UPDATE `user` SET `login`='ivan', `password`=" + mysql.RedactedValue + " WHERE `id`=1"},
+		{"insert", mysql.RecordingPolicy{}, "INSERT INTO `user`(`id`,`password`,`login`)VALUES(1,'a','ivan'),(2,'b','petr')",
+			"INSERT INTO `user`(`id`,`password`,`login`)VALUES(1," + mysql.RedactedValue + ",'ivan'),(2," + mysql.RedactedValue + ",'petr')"},
+		{"function", mysql.RecordingPolicy{}, "SELECT 1 FROM `user` WHERE `password`=SHA2('secret',256)", "SELECT 1 FROM `user` WHERE `password`=SHA2('secret',256)"},
+		{"collapse", mysql.RecordingPolicy{CollapseInLists: true}, "SELECT 1 FROM `t` WHERE `id` IN (1, 2, 3) AND `a` IN (SELECT `b` FROM `c`) AND `d` IN ('x')",
+			"SELECT 1 FROM `t` WHERE `id` IN (…3 values…) AND `a` IN (SELECT `b` FROM `c`) AND `d` IN (…1 values…)"},
+		{"comments", mysql.RecordingPolicy{StripComments: true}, "SELECT /*+ MAX_EXECUTION_TIME(10) */ 1 /* c */ FROM `t` -- tail",
+			"SELECT /*+ MAX_EXECUTION_TIME(10) */ 1 FROM `t` "},
+		{"truncate", mysql.RecordingPolicy{MaxLength: 21}, "SELECT `a` FROM `t` WHERE `id`=123456789", "SELECT `a…123456789"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			res := tc.policy.Sanitize(tc.query, sensitive)
+			assert.Equal(t, tc.res, res)
+			if tc.policy.MaxLength > 0 {
+				assert.LessOrEqual(t, len(res), tc.policy.MaxLength)
+			}
+		})
+	}
+}
+
+func TestMySQL_ReadMask(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	logger := &testLogger{}
+	storage.SetLogger(logger)
+	storage.SetDebug(true)
+	storage.SetInterpolateSQL(true)
+
+	fakeRowValues = [][]driver.Value{{"Ivan", "Sidorov"}}
+	defer func() { fakeRowValues = nil }()
+
+	mask := func(value interface{}) interface{} {
+		s := value.(string)
+		return strings.Repeat("*", len(s)-2) + s[len(s)-2:]
+	}
+	cards := mysql.NewBaseModel(storage, "card", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "name", Length: 32, NotNull: true, ReadMask: mask},
+		&mysql.VarCharField{Id: "lastname", Length: 32, NotNull: true},
+	}, []model.IFieldDefinition{
+		&model.DerivableField{Id: "initial", DependsOn: []string{"name"}, Get: func(ctx context.Context, row map[string]interface{}) (interface{}, error) {
+			return row["name"].(string)[:1], nil
+		}},
+	}, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	// The filter compares the stored value, the output and the logged statement are masked
+	filter := expr.Eq(expr.ModelField(cards, "name"), expr.Value("Ivan"))
+	data, err := cards.GetAll(context.Background(), []string{"id", "name", "lastname", "initial"}, model.GetAllOptions{Filter: filter, Limit: 1})
+	if assert.NoError(t, err) {
+		row := data.Maps()[0]
+		assert.Equal(t, "**an", row["name"])
+		assert.Equal(t, "Sidorov", row["lastname"])
+		assert.Equal(t, "*", row["initial"])
+	}
+	assert.Equal(t, []driver.Value{"Ivan"}, fakeQueryValues)
+	if assert.NotEmpty(t, logger.entries) {
+		assert.Contains(t, logger.entries[len(logger.entries)-1].InterpolatedSQL, "WHERE `name`='**an'")
+	}
+
+	data, err = cards.GetAll(storage.WithUnmasked(context.Background()), []string{"name", "initial"}, model.GetAllOptions{Filter: filter})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{"name": "Ivan", "initial": "I"}, data.Maps()[0])
+	}
+
+	// The derivable fields may be computed from the stored values
+	storage.SetMaskDerivableInputs(false)
+	data, err = cards.GetAll(context.Background(), []string{"name", "initial"}, model.GetAllOptions{Filter: filter})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{"name": "**an", "initial": "I"}, data.Maps()[0])
+	}
+	storage.SetMaskDerivableInputs(true)
+
+	buf := &bytes.Buffer{}
+	if assert.NoError(t, cards.ExportCSV(context.Background(), buf, []string{"id", "name"}, filter, mysql.CSVExportOpts{})) {
+		assert.True(t, strings.HasPrefix(buf.String(), "id,name\n1,**an\n2,**an\n"))
+	}
+	buf.Reset()
+	if assert.NoError(t, cards.ExportCSV(storage.WithUnmasked(context.Background()), buf, []string{"id", "name"}, filter, mysql.CSVExportOpts{})) {
+		assert.True(t, strings.HasPrefix(buf.String(), "id,name\n1,Ivan\n"))
+	}
+
+	_, err = cards.GetAllColumnar(context.Background(), []string{"name"}, nil, mysql.ColumnarOpts{})
+	assert.Error(t, err)
+
+	assert.Panics(t, func() {
+		mysql.NewBaseModel(storage, "masked_pk", []mysql.IMysqlFieldDefinition{
+			&mysql.VarCharField{Id: "id", Length: 32, NotNull: true, ReadMask: mask},
+		}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	})
+}
+
+func TestMySQL_SetLocation(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if !assert.NoError(t, err) {
+		return
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	storage := mysql.NewMySQL(mysql.PoolConfig{})
+	storage.SetLocation(berlin)
+	assert.Error(t, storage.Connect("/?time_zone=%27Europe%2FBerlin%27"))
+
+	driverName := mysql.SqlDriver
+	mysql.SqlDriver = "mysql_fake"
+	err = storage.Connect("")
+	mysql.SqlDriver = driverName
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer storage.Disconnect()
+
+	events := mysql.NewBaseModel(storage, "event", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.DateTimeField{Id: "local_at", NotNull: true},
+		&mysql.DateTimeField{Id: "legacy_at", NotNull: true, Location: newYork},
+		&mysql.TimeStampField{Id: "created_at", NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	for _, c := range []struct {
+		name                     string
+		instant                  time.Time
+		local, legacy, createdAt string
+	}{
+		{"regular", time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC), "2024-01-15 13:00:00", "2024-01-15 07:00:00", "2024-01-15 12:00:00"},
+		{"before spring forward", time.Date(2024, 3, 31, 0, 59, 59, 0, time.UTC), "2024-03-31 01:59:59", "2024-03-30 20:59:59", "2024-03-31 00:59:59"},
+		{"spring forward", time.Date(2024, 3, 31, 1, 0, 0, 0, time.UTC), "2024-03-31 03:00:00", "2024-03-30 21:00:00", "2024-03-31 01:00:00"},
+		{"fractional", time.Date(2024, 7, 1, 10, 0, 0, 123456000, time.UTC), "2024-07-01 12:00:00.123456", "2024-07-01 06:00:00.123456", "2024-07-01 10:00:00.123456"},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			// The location of the value does not matter
+			instant := c.instant.In(time.FixedZone("UTC+9", 9*3600))
+
+			_, err := events.AddMulti(context.Background(), model.NewData([]string{"id", "local_at", "legacy_at", "created_at"}, [][]interface{}{
+				{uint32(1), instant, instant, &instant},
+			}), model.AddOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, []driver.Value{int64(1), c.local, c.legacy, c.createdAt}, fakeExecValues)
+
+			assert.NoError(t, events.Edit(context.Background(), expr.Eq(expr.ModelField(events, "legacy_at"), expr.Value(instant)),
+				map[string]interface{}{"local_at": instant},
+			))
+			assert.Equal(t, []driver.Value{c.local, c.legacy}, fakeExecValues)
+
+			for _, f := range []struct {
+				name, value string
+			}{{"local_at", c.local}, {"legacy_at", c.legacy}, {"created_at", c.createdAt}} {
+				parsed, err := storage.ParseTime(events.GetFieldDefinition(f.name), f.value)
+				if assert.NoError(t, err) {
+					assert.True(t, c.instant.Equal(parsed), "%s: %s != %s", f.name, parsed, c.instant)
+				}
+			}
+		})
+	}
+
+	parsed, err := storage.ParseTime(events.GetFieldDefinition("local_at"), "0000-00-00 00:00:00")
+	assert.NoError(t, err)
+	assert.True(t, parsed.IsZero())
+
+	_, err = storage.ParseTime(events.GetFieldDefinition("id"), "1")
+	assert.Error(t, err)
+}
+
+func TestMySQL_StatementError(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+	storage.SetLogger(&testLogger{})
+
+	accounts := mysql.NewBaseModel(storage, "account", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "login", Length: 32, NotNull: true},
+		&mysql.VarCharField{Id: "password", Length: 64, NotNull: true, Sensitive: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	data := model.NewData([]string{"id", "login", "password"}, [][]interface{}{{uint32(1), "ivan", "secret"}})
+
+	setFakeExecErr(&mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}, 1)
+	_, err := accounts.AddMulti(context.Background(), data, model.AddOptions{})
+	assert.True(t, errors.Is(err, mysql.ErrDuplicateKey))
+	var statementErr *mysql.StatementError
+	if assert.True(t, errors.As(err, &statementErr)) {
+		assert.Equal(t, "account", statementErr.Model)
+		assert.Equal(t, "insert", statementErr.Op)
+		assert.Equal(t, 3, statementErr.ArgsCount)
+		assert.Nil(t, statementErr.Args)
+	}
+	assert.Contains(t, err.Error(), "account.insert: Error 1062: Duplicate entry '1' for key 'PRIMARY'\nSQL: INSERT INTO `account`")
+	assert.Contains(t, err.Error(), "\nArgs: 3\n")
+	assert.NotContains(t, err.Error(), "ivan")
+	assert.NotContains(t, err.Error(), "secret")
+
+	// The arguments are added in the debug mode except for the sensitive ones
+	setFakeExecErr(&mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry '1' for key 'PRIMARY'"}, 1)
+	_, err = accounts.AddMulti(storage.WithDebug(context.Background()), data, model.AddOptions{})
+	assert.Contains(t, err.Error(), "\nArgs: 3 [1 ivan [redacted]]\n")
+	assert.NotContains(t, err.Error(), "secret")
+
+	storage.SetErrorStatementLength(11)
+	setFakeExecErr(errors.New("broken"), 1)
+	_, err = storage.Exec(context.Background(), "DELETE FROM account")
+	if assert.True(t, errors.As(err, &statementErr)) {
+		assert.Equal(t, "", statementErr.Model)
+		assert.Equal(t, "delete", statementErr.Op)
+		assert.Equal(t, "DELETE FROM...", statementErr.SQL)
+	}
+}
+
+func TestMySQL_SetDatabase(t *testing.T) {
+	storage := mysql.NewMySQL()
+	storage.SetDatabase("app")
+
+	user := test.NewUser(storage)
+	visit := mysql.NewBaseModel(storage, "visit", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "url", Length: 255, NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}, Database: "analytics"})
+	relation.AddManyToOne(visit, user)
+
+	assert.Equal(t, "app", user.GetDatabase())
+	assert.Equal(t, "analytics", visit.GetDatabase())
+	assert.Equal(t, "`analytics`.`visit`", mysql.QuoteTable(visit))
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, visit, []string{"url"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Filter: expr.Any(visit, user, expr.Eq(user.FieldExpr("name"), expr.Value("Ivan")))},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `url` FROM `analytics`.`visit` WHERE (`fk_user_id`)=ANY(SELECT `id` FROM `app`.`user` WHERE `name`=?)", sqlBuf.GetSQL())
+	}
+
+	sqlBuf.Reset()
+	visit.WriteCreateSQL(sqlBuf)
+	assert.Contains(t, sqlBuf.GetSQL(), "CREATE TABLE `analytics`.`visit` (")
+	assert.Contains(t, sqlBuf.GetSQL(), "REFERENCES `app`.`user`(`id`)")
+
+	// The models of the other storages cannot be joined even on the same server
+	other := mysql.NewMySQL()
+	otherUser := mysql.NewBaseModel(other, "user", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, visit, []string{"url"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Filter: mysql.Exists(otherUser, nil)},
+	})
+	if assert.Error(t, sqlBuf.Err()) {
+		assert.Contains(t, sqlBuf.Err().Error(), "The model 'user' belongs to the other storage")
+	}
+}
+
+type testSpan struct {
+	name   string
+	parent *testSpan
+	attrs  map[string]string
+	events []string
+	ended  bool
+	err    error
+}
+
+func (s *testSpan) AddEvent(name string, _ []mysql.SpanAttribute) { s.events = append(s.events, name) }
+func (s *testSpan) End(err error)                                 { s.ended, s.err = true, err }
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (t *testTracer) Start(_ context.Context, parent mysql.Span, name string, attrs []mysql.SpanAttribute) mysql.Span {
+	span := &testSpan{name: name, attrs: make(map[string]string)}
+	if parent != nil {
+		span.parent = parent.(*testSpan)
+	}
+	for _, attr := range attrs {
+		span.attrs[attr.Key] = attr.Value
+	}
+	t.spans = append(t.spans, span)
+
+	return span
+}
+
+func TestMySQL_SetTracer(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	tracer := &testTracer{}
+	storage.SetTracer(tracer, mysql.TracingOpts{MaxStatementLength: 12})
+
+	ctx := context.Background()
+	_, err := storage.Exec(ctx, "UPDATE user SET name = ?", "Ivan")
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := storage.Exec(ctx, "DELETE FROM user"); err != nil {
+			return err
+		}
+
+		return storage.DoInTransaction(ctx, func(ctx context.Context) error {
+			rows, err := storage.RawQuery(ctx, "SELECT 1")
+			if err == nil {
+				rows.Close()
+			}
+			return err
+		})
+	}))
+
+	if !assert.Len(t, tracer.spans, 4) {
+		return
+	}
+
+	update, tx, del, sel := tracer.spans[0], tracer.spans[1], tracer.spans[2], tracer.spans[3]
+
+	assert.Equal(t, "update", update.name)
+	assert.Nil(t, update.parent)
+	assert.Equal(t, map[string]string{
+		"db.system":    "mysql",
+		"db.operation": "update",
+		"db.statement": "UPDATE user ...",
+	}, update.attrs)
+
+	assert.Equal(t, "transaction", tx.name)
+	assert.Nil(t, tx.parent)
+	assert.Equal(t, []string{"savepoint", "release", "commit"}, tx.events)
+
+	assert.Equal(t, "delete", del.name)
+	assert.Same(t, tx, del.parent)
+	assert.Equal(t, "select", sel.name)
+	assert.Same(t, tx, sel.parent)
+
+	for _, span := range tracer.spans {
+		assert.True(t, span.ended, span.name)
+		assert.NoError(t, span.err, span.name)
+	}
+}
+
+func setFakeExecErr(err error, count int) {
+	fakeExecMtx.Lock()
+	defer fakeExecMtx.Unlock()
+	fakeExecErr, fakeExecErrs = err, count
+}
+
+func TestMySQL_ClassifyErrors(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+	defer setFakeExecErr(nil, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	accounts := mysql.NewBaseModel(storage, "account", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "login", Length: 5, NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	ctx := context.Background()
+
+	setFakeExecErr(&mysqldriver.MySQLError{Number: 1062, Message: "Duplicate entry 'ivan' for key 'account.login'"}, 1)
+	_, err := accounts.AddMulti(ctx, model.NewData([]string{"id", "login"}, [][]interface{}{{uint32(1), "ivan"}}), model.AddOptions{})
+	assert.True(t, errors.Is(err, mysql.ErrDuplicateKey))
+	assert.False(t, errors.Is(err, mysql.ErrDeadlock))
+	var classified *mysql.Error
+	if assert.True(t, errors.As(err, &classified)) {
+		assert.Equal(t, "account", classified.Model)
+		assert.Equal(t, "login", classified.Index)
+		assert.Equal(t, "ivan", classified.Value)
+	}
+	var mysqlErr *mysqldriver.MySQLError
+	if assert.True(t, errors.As(err, &mysqlErr)) {
+		assert.Equal(t, uint16(1062), mysqlErr.Number)
+	}
+
+	setFakeExecErr(&mysqldriver.MySQLError{Number: 1406, Message: "Data too long for column 'login' at row 1"}, 1)
+	err = accounts.Edit(ctx, nil, map[string]interface{}{"login": "ivanov"})
+	assert.True(t, errors.Is(err, mysql.ErrDataTooLong))
+	if assert.True(t, errors.As(err, &classified)) {
+		assert.Equal(t, "login", classified.Field)
+	}
+
+	setFakeExecErr(&mysqldriver.MySQLError{Number: 1451, Message: "Cannot delete or update a parent row"}, 1)
+	assert.True(t, errors.Is(accounts.Delete(ctx, nil), mysql.ErrForeignKeyViolation))
+
+	// The unclassified errors are wrapped with the statement context only
+	unknown := &mysqldriver.MySQLError{Number: 1064, Message: "You have an error in your SQL syntax"}
+	setFakeExecErr(unknown, 1)
+	_, err = storage.Exec(ctx, "UPDATE")
+	if assert.True(t, errors.As(err, &mysqlErr)) {
+		assert.Same(t, unknown, mysqlErr)
+	}
+	assert.False(t, errors.As(err, &classified))
+
+	attempts := 0
+	transfer := func(ctx context.Context) error {
+		attempts++
+		_, err := storage.Exec(ctx, "UPDATE account SET login = ?", "petr")
+		return err
+	}
+
+	setFakeExecErr(&mysqldriver.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"}, 2)
+	assert.NoError(t, storage.DoInTransactionRetry(ctx, 2, transfer))
+	assert.Equal(t, 3, attempts)
+
+	attempts = 0
+	setFakeExecErr(&mysqldriver.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, 2)
+	assert.True(t, errors.Is(storage.DoInTransactionRetry(ctx, 1, transfer), mysql.ErrLockWaitTimeout))
+	assert.Equal(t, 2, attempts)
+}
+
+func TestMySQL_DryRun(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	orders := mysql.NewBaseModel(storage, "order", []mysql.IMysqlFieldDefinition{
+		&mysql.BigIntField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.VarCharField{Id: "item", Length: 32, NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	user := test.NewUser(storage)
+
+	ctx, dryRun := storage.DryRun(context.Background())
+
+	assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		// The reads are executed
+		users, err := user.GetAll(ctx, []string{"id"}, model.GetAllOptions{})
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, fakeRowsCount, users.Len())
+
+		ids, err := orders.AddMulti(ctx, model.NewData([]string{"item"}, [][]interface{}{{"book"}, {"pen's"}}), model.AddOptions{})
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, [][]interface{}{{int64(-2)}, {int64(-1)}}, ids.Data())
+
+		ids, err = orders.AddMulti(ctx, model.NewData([]string{"item"}, [][]interface{}{{"cup"}}), model.AddOptions{})
+		if err != nil {
+			return err
+		}
+		assert.Equal(t, [][]interface{}{{int64(-3)}}, ids.Data())
+
+		return orders.Edit(ctx, expr.Eq(expr.ModelField(orders, "id"), expr.Value(int64(-3))), map[string]interface{}{"item": "mug"})
+	}))
+
+	// Nothing is executed
+	fakeExecMtx.Lock()
+	assert.Empty(t, fakeExecArgs)
+	fakeExecMtx.Unlock()
+
+	statements := dryRun.Statements()
+	if assert.Len(t, statements, 5) {
+		assert.Equal(t, "BEGIN", statements[0].SQL)
+		assert.Equal(t, []int64{-2, -1}, statements[1].InsertIds)
+		assert.Equal(t, []int64{-3}, statements[2].InsertIds)
+		assert.Nil(t, statements[3].InsertIds)
+		assert.Equal(t, "COMMIT", statements[4].SQL)
+	}
+
+	assert.Equal(t, "BEGIN;\n"+
+		"INSERT INTO `order`(`item`)VALUES('book'),('pen\\'s');\n"+
+		"INSERT INTO `order`(`item`)VALUES('cup');\n"+
+		"UPDATE `order` SET `item`='mug' WHERE `id`=-3;\n"+
+		"COMMIT;\n",
+		dryRun.Script(),
+	)
+}
+
+func TestMySQL_QueryBudget(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	var exceeded []*mysql.QueryBudgetError
+	storage.SetQueryBudgetHandler(func(ctx context.Context, err *mysql.QueryBudgetError) {
+		exceeded = append(exceeded, err)
+	})
+
+	ctx := storage.WithQueryBudget(context.Background(), 2, 0)
+	_, err := storage.Exec(ctx, "DELETE FROM t")
+	assert.NoError(t, err)
+
+	// The transaction shares the budget, its control statements are not counted
+	err = storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := storage.Exec(ctx, "DELETE FROM t"); err != nil {
+			return err
+		}
+		_, err := storage.Exec(ctx, "DELETE FROM t")
+		return err
+	})
+	assert.ErrorIs(t, err, mysql.ErrQueryBudgetExceeded)
+	var budgetErr *mysql.QueryBudgetError
+	if assert.ErrorAs(t, err, &budgetErr) {
+		assert.Equal(t, uint64(3), budgetErr.Statements)
+		assert.Equal(t, uint64(2), budgetErr.MaxStatements)
+	}
+
+	_, err = storage.RawQuery(ctx, "SELECT 1")
+	assert.ErrorIs(t, err, mysql.ErrQueryBudgetExceeded)
+	assert.Len(t, exceeded, 1)
+
+	statements, _ := mysql.QueryBudgetUsage(ctx)
+	assert.Equal(t, uint64(4), statements)
+
+	ctx = storage.WithQueryBudget(context.Background(), 0, time.Nanosecond)
+	_, err = storage.Exec(ctx, "DELETE FROM t")
+	assert.NoError(t, err)
+	_, err = storage.Exec(ctx, "DELETE FROM t")
+	assert.ErrorIs(t, err, mysql.ErrQueryBudgetExceeded)
+	assert.Len(t, exceeded, 2)
+
+	_, err = storage.Exec(context.Background(), "DELETE FROM t")
+	assert.NoError(t, err)
+}
+
+type testHookKey string
+
+type testHook struct {
+	name  string
+	veto  string
+	calls *[]string
+}
+
+func (h testHook) BeforeQuery(ctx context.Context, info *mysql.QueryHookInfo) (context.Context, error) {
+	*h.calls = append(*h.calls, h.name+" before "+info.Model+"."+info.Op)
+	if info.Op == h.veto {
+		return nil, errors.New("vetoed by " + h.name)
+	}
+	info.SQL += " /* " + h.name + " */"
+
+	return context.WithValue(ctx, testHookKey(h.name), true), nil
+}
+
+func (h testHook) AfterQuery(ctx context.Context, info *mysql.QueryHookInfo, result driver.Result, err error) {
+	call := h.name + " after " + info.SQL
+	if ctx.Value(testHookKey(h.name)) == nil {
+		call += " without context"
+	}
+	if result != nil {
+		rows, _ := result.RowsAffected()
+		call += fmt.Sprintf(" %d rows", rows)
+	}
+	if err != nil {
+		call += " " + err.Error()
+	}
+	*h.calls = append(*h.calls, call)
+}
+
+func TestMySQL_AddQueryHook(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	var calls []string
+	storage.AddQueryHook(testHook{name: "a", calls: &calls})
+	storage.AddQueryHook(testHook{name: "b", veto: "delete", calls: &calls})
+
+	logger := &testLogger{}
+	storage.SetLogger(logger)
+	storage.SetDebug(true)
+
+	ctx := context.Background()
+	assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		_, err := test.NewUser(storage).AddMulti(ctx, model.NewData([]string{"name", "lastname"}, [][]interface{}{{"Ivan", "Ivanov"}}), model.AddOptions{})
+		return err
+	}))
+
+	_, err := storage.Exec(ctx, "DELETE FROM user")
+	assert.EqualError(t, err, "vetoed by b")
+
+	assert.Equal(t, []string{
+		"a before tx.begin",
+		"b before tx.begin",
+		"b after BEGIN /* a */ /* b */",
+		"a after BEGIN /* a */ /* b */",
+		"a before user.insert",
+		"b before user.insert",
+		"b after INSERT INTO `user`(`name`,`lastname`)VALUES(?,?) /* a */ /* b */ 1 rows",
+		"a after INSERT INTO `user`(`name`,`lastname`)VALUES(?,?) /* a */ /* b */ 1 rows",
+		"a before tx.commit",
+		"b before tx.commit",
+		"b after COMMIT /* a */ /* b */",
+		"a after COMMIT /* a */ /* b */",
+		"a before .delete",
+		"b before .delete",
+		"a after DELETE FROM user /* a */ vetoed by b",
+	}, calls)
+
+	// The statements are executed with the changed SQL except for the transaction control ones,
+	// the vetoed one is not executed
+	if assert.Len(t, logger.entries, 3) {
+		assert.Equal(t, "BEGIN", logger.entries[0].SQL)
+		assert.Equal(t, "COMMIT", logger.entries[2].SQL)
+		assert.Equal(t, "INSERT INTO `user`(`name`,`lastname`)VALUES(?,?) /* a */ /* b */", logger.entries[1].SQL)
+	}
+}
+
+// errorMessage returns the message of the qerror error without its time and stacktrace
+func errorMessage(err error) string {
+	return strings.SplitN(err.Error(), "\n", 2)[0]
+}
+
+func lastFakeQuery() string {
+	fakeExecMtx.Lock()
+	defer fakeExecMtx.Unlock()
+
+	return fakeLastQuery
+}
+
+func TestMySQL_QueryTags(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	logger := &testLogger{}
+	storage.SetLogger(logger)
+	storage.SetDebug(true)
+	storage.SetQueryTags(map[string]string{"service": "billing", "route": "none"})
+
+	ctx := context.Background()
+	_, err := storage.Exec(ctx, "UPDATE user SET name = ?", "Ivan")
+	assert.NoError(t, err)
+	assert.Equal(t, "/*route='none',service='billing'*/ UPDATE user SET name = ?", lastFakeQuery())
+
+	ctx = storage.WithQueryTags(ctx, map[string]string{"route": "/users/{id}"})
+	ctx = storage.WithQueryTags(ctx, map[string]string{"note": "it's */ done"})
+	rows, err := storage.RawQuery(ctx, "SELECT 1")
+	if assert.NoError(t, err) {
+		rows.Close()
+	}
+	assert.Equal(t, "/*note='it%27s%20%2A%2F%20done',route='%2Fusers%2F%7Bid%7D',service='billing'*/ SELECT 1", lastFakeQuery())
+
+	_, err = storage.Exec(mysql.WithoutQueryTags(ctx), "DELETE FROM user")
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM user", lastFakeQuery())
+
+	// The logged statements are not tagged
+	if assert.Len(t, logger.entries, 3) {
+		assert.Equal(t, "SELECT 1", logger.entries[1].SQL)
+	}
+}
+
+func TestMySQL_Close(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+
+	inserter := storage.NewStreamInserter(context.Background(), test.NewUser(storage), []string{"name", "lastname"},
+		mysql.StreamInserterOpts{FlushInterval: time.Hour})
+	assert.NoError(t, inserter.Add([]interface{}{"Ivan", "Ivanov"}))
+
+	txCtx, err := storage.StartTransaction(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	closed := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		closed <- storage.Close(ctx)
+	}()
+
+	// The new transactions are rejected once Close is called
+	assert.Eventually(t, func() bool {
+		err := storage.DoInTransaction(context.Background(), func(context.Context) error { return nil })
+		return errors.Is(err, mysql.ErrClosing)
+	}, time.Second, time.Millisecond)
+
+	select {
+	case <-closed:
+		t.Fatal("Close must wait for the open transaction")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// The transaction started before Close can still be finished
+	_, err = storage.Exec(txCtx, "UPDATE user SET name = ?", "Petr")
+	assert.NoError(t, err)
+	_, err = storage.Commit(txCtx)
+	assert.NoError(t, err)
+
+	assert.NoError(t, <-closed)
+
+	// The stream inserter is flushed
+	fakeExecMtx.Lock()
+	assert.Equal(t, []int{1, 2}, fakeExecArgs)
+	fakeExecMtx.Unlock()
+	assert.Equal(t, mysql.ErrStreamInserterClosed, inserter.Add([]interface{}{"Ivan", "Ivanov"}))
+}
+
+func TestMySQL_Close_Deadline(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+
+	txCtx, err := storage.StartTransaction(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, storage.Close(ctx))
+
+	_, err = storage.Rollback(txCtx)
+	assert.NoError(t, err)
+}
+
+// countingAddCallback counts the rows it is called for
+type countingAddCallback struct{ rows int32 }
+
+func (c *countingAddCallback) BeforeAdd(context.Context, map[string]interface{}) error {
+	atomic.AddInt32(&c.rows, 1)
+	return nil
+}
+
+func TestMySQL_Close_WriteBehind(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+
+	// The inserts of the model with the write callbacks run in the transactions
+	audit := mysql.NewBaseModel(storage, "audit", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "action", Length: 32, NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	callback := &countingAddCallback{}
+	audit.AddWriteCallback(callback)
+
+	var (
+		mtx  sync.Mutex
+		dead []error
+	)
+	if !assert.NoError(t, audit.SetWriteBehind(mysql.WriteBehindOpts{
+		BatchSize:     10,
+		FlushInterval: time.Hour,
+		DeadLetter: func(err error, data *model.Data) {
+			mtx.Lock()
+			defer mtx.Unlock()
+			dead = append(dead, err)
+		},
+	})) {
+		return
+	}
+
+	_, err := audit.AddMulti(context.Background(), model.NewData([]string{"id", "action"}, [][]interface{}{{uint32(1), "login"}}), model.AddOptions{})
+	assert.NoError(t, err)
+
+	// The queued row is inserted by Close
+	assert.NoError(t, storage.Close(context.Background()))
+	assert.Empty(t, dead)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&callback.rows))
+	assert.Equal(t, "INSERT INTO `audit`(`id`,`action`)VALUES(?,?)", lastFakeQuery())
+}
+
+func TestMySQL_LoadFixtures(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	message := mysql.NewBaseModel(storage, "message", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.VarCharField{Id: "text", Length: 255, NotNull: true},
+		&mysql.BooleanField{Id: "pinned", NotNull: true},
+		&mysql.DateTimeField{Id: "created_at"},
+		&mysql.SetField{Id: "flags", Values: []string{"read", "starred"}},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	relation.AddManyToOne(message, user, relation.WithRequired(true), relation.WithAlias("author"))
+
+	logger := &testLogger{}
+	storage.SetLogger(logger)
+	storage.SetInterpolateSQL(true)
+	ctx := storage.WithDebug(context.Background())
+
+	fsys := fstest.MapFS{
+		"message.yaml": {Data: []byte(`
+message:
+  - fk_author_id: $user.alice.id
+    text: $$100
+    pinned: yes
+    created_at: 2021-03-04 05:06:07
+    flags: read,starred
+  - fk_author_id: $user.bob.id
+    text: Hello
+    pinned: false
+    created_at: null
+`)},
+		"user.json": {Data: []byte(`{"user": [
+  {"_name": "alice", "id": 7, "name": "Alice", "lastname": "Smith"},
+  {"_name": "bob", "id": 8, "name": "Bob", "lastname": "Jones"}
+]}`)},
+	}
+
+	if assert.NoError(t, storage.LoadFixtures(ctx, fsys, "message.yaml", "user.json")) {
+		var statements []string
+		for _, entry := range logger.entries {
+			statements = append(statements, entry.InterpolatedSQL)
+		}
+		assert.Equal(t, []string{
+			"BEGIN",
+			"INSERT INTO `user`(`id`,`name`,`lastname`)VALUES(7,'Alice','Smith')",
+			"INSERT INTO `user`(`id`,`name`,`lastname`)VALUES(8,'Bob','Jones')",
+			"INSERT INTO `message`(`fk_author_id`,`text`,`pinned`,`created_at`,`flags`)VALUES(7,'$100',1,'2021-03-04 05:06:07','read,starred')",
+			"INSERT INTO `message`(`fk_author_id`,`text`,`pinned`,`created_at`)VALUES(8,'Hello',0,NULL)",
+			"COMMIT",
+		}, statements)
+	}
+
+	for _, tc := range []struct {
+		data, err string
+	}{
+		{"user:\n  - id: 1\n    nickname: Al\n", "fixture.yaml:3: Unknown field 'nickname' in model 'user'"},
+		{"user:\n  - id: one\n", "fixture.yaml:2: The field 'id' of model 'user': cannot convert 'one' to uint32"},
+		{"message:\n  - created_at: 04.03.2021\n", "fixture.yaml:2: The field 'created_at' of model 'message': '04.03.2021' does not match the format 2006-01-02 15:04:05"},
+		{"message:\n  - flags: unread\n", "fixture.yaml:2: The field 'flags' of model 'message': 'unread' is not one of read, starred"},
+		{"message:\n  - fk_author_id: $user.carol.id\n", "fixture.yaml:2: Unknown row 'carol' of model 'user'"},
+		{"user:\n  - _name: alice\n    id: $user.alice.id\n", "fixture.yaml:2: The rows of model 'user' reference each other cyclically"},
+		{"post:\n  - id: 1\n", "fixture.yaml:1: Unknown model 'post'"},
+	} {
+		err := storage.LoadFixtures(ctx, fstest.MapFS{"fixture.yaml": {Data: []byte(tc.data)}}, "fixture.yaml")
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), tc.err)
+		}
+	}
+}
+
+func TestMySQL_DumpFixtures(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+
+	buf := &strings.Builder{}
+	if assert.NoError(t, storage.DumpFixtures(context.Background(), buf, mysql.FixtureSelection{Model: user, NameField: "name"})) {
+		assert.True(t, strings.HasPrefix(buf.String(), "user:\n"+
+			"  - _name: Name\n"+
+			"    id: 1\n"+
+			"    name: Name\n"+
+			"    lastname: Lastname\n"+
+			"  - _name: Name\n"+
+			"    id: 2\n"), buf.String()[:200])
+	}
+	assert.Contains(t, lastFakeQuery(), "ORDER BY `id`")
+}
+
+func TestMySQL_ExportCSV(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+
+	buf := &strings.Builder{}
+	if assert.NoError(t, user.ExportCSV(context.Background(), buf, []string{"id", "name", "lastname"}, nil, mysql.CSVExportOpts{
+		Delimiter: ';',
+		Formats: map[string]func(interface{}) string{
+			"lastname": func(value interface{}) string { return strings.ToUpper(value.(string)) },
+		},
+	})) {
+		lines := strings.Split(buf.String(), "\n")
+		assert.Equal(t, []string{"id;name;lastname", "1;Name;LASTNAME", "2;Name;LASTNAME"}, lines[:3])
+		assert.Len(t, lines, fakeRowsCount+2)
+	}
+
+	assert.Error(t, user.ExportCSV(context.Background(), buf, []string{"fullname"}, nil, mysql.CSVExportOpts{}))
+}
+
+func TestMySQL_ImportCSV(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+
+	data := "id,name,lastname\n" +
+		"1,Ivan,Sidorov\n" +
+		"2,Petr,Ivanov\n" +
+		"3,James,Bond\n"
+
+	count, err := user.ImportCSV(ctx, strings.NewReader(data), mysql.CSVImportOpts{BatchSize: 2, DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Empty(t, fakeExecArgs)
+
+	count, err = user.ImportCSV(ctx, strings.NewReader(data), mysql.CSVImportOpts{BatchSize: 2, Upsert: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, []int{6, 3}, fakeExecArgs)
+	assert.Contains(t, lastFakeQuery(), "ON DUPLICATE KEY UPDATE")
+
+	_, err = user.ImportCSV(ctx, strings.NewReader("id,name,lastname\n"+
+		"1,Ivan,Sidorov\n"+
+		"x,Petr,Ivanov\n"+
+		"\"3\",\"James\"\n"), mysql.CSVImportOpts{BatchSize: 1})
+	var importErr *mysql.CSVImportError
+	if assert.True(t, errors.As(err, &importErr)) && assert.Len(t, importErr.Rows, 2) {
+		assert.Equal(t, 3, importErr.Rows[0].Line)
+		assert.Equal(t, "id", importErr.Rows[0].Field)
+		assert.True(t, strings.HasPrefix(importErr.Rows[1].Error(), "line 4: 2 values, must be 3\n"))
+	}
+	// The rows inserted before the invalid one are rolled back, the following ones are validated only
+	assert.Equal(t, []int{6, 3, 3}, fakeExecArgs)
+
+	_, err = user.ImportCSV(ctx, strings.NewReader("id,nickname\n"), mysql.CSVImportOpts{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 1: Unknown field 'nickname' in model 'user'")
+	}
+	_, err = user.ImportCSV(ctx, strings.NewReader("id,name\n"), mysql.CSVImportOpts{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 1: Missed required field 'lastname' in model 'user'")
+	}
+}
+
+// writesCounter counts the writes and cancels the context after the first one if cancel is set
+type writesCounter struct {
+	strings.Builder
+	writes int
+	cancel context.CancelFunc
+}
+
+func (w *writesCounter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.cancel != nil {
+		w.cancel()
+	}
+
+	return w.Builder.Write(p)
+}
+
+func TestMySQL_ExportJSONL(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+
+	w := &writesCounter{}
+	summary, err := user.ExportJSONL(context.Background(), w, []string{"id", "fullname"}, nil, mysql.JSONLExportOpts{FlushRows: 100})
+	if assert.NoError(t, err) {
+		lines := strings.Split(w.String(), "\n")
+		assert.Equal(t, []string{`{"id":1,"fullname":"Name Lastname"}`, `{"id":2,"fullname":"Name Lastname"}`}, lines[:2])
+		assert.Len(t, lines, fakeRowsCount+1)
+		assert.Equal(t, mysql.JSONLExportSummary{Rows: fakeRowsCount, Bytes: int64(w.Len())}, summary)
+		assert.Equal(t, fakeRowsCount/100, w.writes)
+	}
+	assert.Contains(t, lastFakeQuery(), "SELECT `id`,`name`,`lastname` FROM `user`")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w = &writesCounter{cancel: cancel}
+	summary, err = user.ExportJSONL(ctx, w, []string{"id", "name", "lastname"}, nil, mysql.JSONLExportOpts{FlushRows: 10})
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, mysql.JSONLExportSummary{Rows: 10, Bytes: int64(w.Len())}, summary)
+}
+
+// recordingExecutor records the statements run by the base executor
+type recordingExecutor struct {
+	mysql.Executor
+	mtx     *sync.Mutex
+	queries *[]string
+}
+
+func (e recordingExecutor) record(query string) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	*e.queries = append(*e.queries, query)
+}
+
+func (e recordingExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	e.record(query)
+	return e.Executor.ExecContext(ctx, query, args...)
+}
+
+func (e recordingExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	e.record(query)
+	return e.Executor.QueryContext(ctx, query, args...)
+}
+
+func TestMySQL_NewWithDB(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	}})
+	defer storage.Disconnect()
+	assert.Same(t, db, storage.GetRawDB())
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+
+	_, err = storage.Select(ctx, user, []string{"id", "name", "lastname"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{Limit: 1}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		return storage.DoInTransaction(ctx, func(ctx context.Context) error {
+			return user.Edit(ctx, expr.Eq(user.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "Ivan"})
+		})
+	}))
+
+	// BEGIN and COMMIT are sent by *sql.Tx
+	if assert.Len(t, queries, 4) {
+		assert.Equal(t, "SELECT `id`,`name`,`lastname` FROM `user` LIMIT 1", queries[0])
+		assert.Regexp(t, "^SAVEPOINT SP_[0-9a-f]{4}_1$", queries[1])
+		assert.Equal(t, "UPDATE `user` SET `name`=? WHERE `id`=?", queries[2])
+		assert.Equal(t, "RELEASE "+queries[1], queries[3])
+	}
+}
+
+func TestMySQL_CreateSavepoint(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	}})
+	defer storage.Disconnect()
+	storage.SetSavepointPrefix("STORAGE")
+
+	_, err = storage.CreateSavepoint(context.Background())
+	assert.Error(t, err)
+
+	assert.NoError(t, storage.DoInTransaction(context.Background(), func(ctx context.Context) error {
+		name, err := storage.CreateSavepoint(ctx)
+		if err != nil {
+			return err
+		}
+		assert.Regexp(t, "^STORAGE_[0-9a-f]{4}_n1$", name)
+
+		if err := storage.DoInTransaction(ctx, func(ctx context.Context) error { return nil }); err != nil {
+			return err
+		}
+		if err := storage.RollbackSavepoint(ctx, name); err != nil {
+			return err
+		}
+		assert.Error(t, storage.ReleaseSavepoint(ctx, "SP1"))
+
+		return storage.ReleaseSavepoint(ctx, name)
+	}))
+
+	if assert.Len(t, queries, 5) {
+		name := strings.TrimPrefix(queries[0], "SAVEPOINT ")
+		assert.Equal(t, "SAVEPOINT "+strings.TrimSuffix(name, "n1")+"1", queries[1])
+		assert.Equal(t, "ROLLBACK TO SAVEPOINT "+name, queries[3])
+		assert.Equal(t, "RELEASE SAVEPOINT "+name, queries[4])
+	}
+
+	// The compatible names have no random part
+	storage.SetSavepointPrefix("")
+	storage.SetPlainSavepointNames(true)
+	queries = nil
+	assert.NoError(t, storage.DoInTransaction(context.Background(), func(ctx context.Context) error {
+		return storage.DoInTransaction(ctx, func(ctx context.Context) error { return nil })
+	}))
+	assert.Equal(t, []string{"SAVEPOINT SP1", "RELEASE SAVEPOINT SP1"}, queries)
+}
+
+func TestMySQL_NewFromDSN(t *testing.T) {
+	driverName := mysql.SqlDriver
+	mysql.SqlDriver = "mysql_fake"
+	defer func() { mysql.SqlDriver = driverName }()
+
+	lastDSN := func() string {
+		fakeExecMtx.Lock()
+		defer fakeExecMtx.Unlock()
+		return fakeLastDSN
+	}
+
+	_, err := mysql.NewFromDSN("user@tcp(localhost:3306)/test?parseTime=true", mysql.Options{})
+	assert.Error(t, err)
+
+	fakeExecMtx.Lock()
+	fakeLastDSN = ""
+	fakeExecMtx.Unlock()
+
+	poolConfig := mysql.PoolConfig{MaxOpenConns: 3, MaxIdleConns: 2}
+	storage, err := mysql.NewFromDSN("user@tcp(localhost:3306)/test?charset=latin1", mysql.Options{
+		PoolConfig:  &poolConfig,
+		LazyConnect: true,
+		TablePrefix: "app_",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer storage.Disconnect()
+	assert.Equal(t, "", lastDSN())
+	assert.Equal(t, poolConfig, storage.GetPoolConfig())
+
+	user := test.NewUser(storage)
+	message := test.NewMessage(storage)
+	relation.AddManyToOne(message, user, relation.WithRequired(true), relation.WithAlias("author"))
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, message, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{OrderBy: []model.Order{{FieldName: "id"}}},
+		Parents:       []mysql.Parent{mysql.WithParent(user, "fk_author_id", []string{"name"})},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `app_message`.`id`,`app_user`.`id` AS `user.id`,`app_user`.`name` AS `user.name`"+
+			" FROM `app_message` JOIN `app_user` ON `app_user`.`id`=`app_message`.`fk_author_id` ORDER BY `app_message`.`id`", sqlBuf.GetSQL())
+	}
+
+	storage, err = mysql.NewFromDSN("user@tcp(localhost:3306)/test", mysql.Options{PingTimeout: time.Second})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer storage.Disconnect()
+	assert.Contains(t, lastDSN(), "collation="+mysql.DefaultCollation)
+	assert.NotContains(t, lastDSN(), "charset")
+	assert.Equal(t, mysql.DefaultPoolConfig(), storage.GetPoolConfig())
+
+	_, err = mysql.NewFromDSN("user@tcp(localhost:3306)/test?collation=utf8mb4_bin", mysql.Options{})
+	assert.NoError(t, err)
+	assert.Contains(t, lastDSN(), "collation=utf8mb4_bin")
+}
+
+func TestMySQL_SetConnInit(t *testing.T) {
+	driverName := mysql.SqlDriver
+	mysql.SqlDriver = "mysql_fake"
+	defer func() { mysql.SqlDriver = driverName }()
+
+	var (
+		inits      int
+		statements []string
+		initErr    error
+	)
+	storage := mysql.NewMySQL(mysql.PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1})
+	storage.SetConnInit([]string{"SET SESSION group_concat_max_len=1048576"}, func(ctx context.Context, conn driver.Conn) error {
+		inits++
+		statements = append(statements, lastFakeQuery())
+		if initErr != nil {
+			return initErr
+		}
+		return mysql.ExecConn(ctx, conn, "SET SESSION optimizer_switch='index_merge=off'")
+	})
+	if !assert.NoError(t, storage.Connect("")) {
+		return
+	}
+	defer storage.Disconnect()
+
+	fakeExecMtx.Lock()
+	opens := fakeOpens
+	fakeExecMtx.Unlock()
+
+	// The init runs once per connection, not per checkout
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		_, err := storage.Exec(ctx, "UPDATE `user` SET `name`='a'")
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, inits)
+	assert.Equal(t, []string{"SET SESSION group_concat_max_len=1048576"}, statements)
+	fakeExecMtx.Lock()
+	assert.Equal(t, opens+1, fakeOpens)
+	fakeExecMtx.Unlock()
+
+	// The connection failed to set up is not added to the pool, the idle connection is closed to open a new one
+	storage.SetPoolConfig(mysql.PoolConfig{MaxOpenConns: 1, MaxIdleConns: -1})
+	initErr = errors.New("no optimizer switch")
+	_, err := storage.Exec(ctx, "UPDATE `user` SET `name`='a'")
+	if assert.Error(t, err) {
+		assert.True(t, errors.Is(err, mysql.ErrConnInit))
+		assert.Contains(t, err.Error(), "no optimizer switch")
+	}
+
+	initErr = nil
+	storage.SetPoolConfig(mysql.PoolConfig{MaxOpenConns: 1, MaxIdleConns: 1})
+	_, err = storage.Exec(ctx, "UPDATE `user` SET `name`='a'")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, inits)
+}
+
+func TestMySQL_HealthCheck(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	ctx := context.Background()
+	assert.Equal(t, mysql.ErrNotConnected, mysql.NewMySQL().HealthCheck(ctx).Endpoints[0].Err)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+	clock := testutil.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	storage.SetClock(clock)
+
+	replica, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer replica.Close()
+	storage.AddReplicaDB(replica)
+
+	deadReplica, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	deadReplica.Close()
+	storage.AddReplicaDB(deadReplica)
+
+	status := storage.HealthCheck(ctx)
+	assert.True(t, status.Healthy)
+	if assert.Len(t, status.Endpoints, 3) {
+		assert.Equal(t, "primary", status.Endpoints[0].Name)
+		assert.True(t, status.Endpoints[0].Healthy)
+		assert.True(t, status.Endpoints[1].Healthy)
+		assert.Equal(t, "replica 1", status.Endpoints[2].Name)
+		assert.False(t, status.Endpoints[2].Healthy)
+		assert.Error(t, status.Endpoints[2].Err)
+	}
+	assert.Equal(t, "SELECT 1", lastFakeQuery())
+	assert.Equal(t, 1, storage.ReplicasAlive())
+
+	// The pool of the single connection is saturated by the transaction
+	storage.SetPoolConfig(mysql.PoolConfig{MaxOpenConns: 1})
+	txCtx, err := storage.StartTransaction(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+	status = storage.HealthCheck(ctx)
+	assert.False(t, status.Healthy)
+	assert.Equal(t, mysql.ErrPoolSaturated, status.Endpoints[0].Err)
+	_, err = storage.Rollback(txCtx)
+	assert.NoError(t, err)
+
+	assert.True(t, storage.HealthCheck(ctx).Healthy)
+
+	// The dead replica is retried when the retry interval passes
+	clock.Advance(time.Hour)
+	assert.Equal(t, 2, storage.ReplicasAlive())
+
+	// The last status is returned until the interval passes
+	storage.SetHealthCheckInterval(time.Minute)
+	status = storage.HealthCheck(ctx)
+	assert.Equal(t, clock.Now(), status.CheckedAt)
+	clock.Advance(time.Minute - time.Second)
+	assert.Same(t, status, storage.HealthCheck(ctx))
+	clock.Advance(time.Second)
+	assert.NotSame(t, status, storage.HealthCheck(ctx))
+}
+
+type userNames struct {
+	Name string
+}
+
+type userRow struct {
+	userNames
+	ID       int64
+	Lastname *string
+	FullName []byte `mysql:"fullname"`
+	Ignored  string `mysql:"-"`
+}
+
+func TestMySQL_Converters(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	type userID uint32
+	storage.RegisterValueConverter(reflect.TypeOf(time.Duration(0)), mysql.DurationToMicroseconds)
+	storage.RegisterValueConverter(reflect.TypeOf(net.IP{}), mysql.IPToBytes)
+	storage.RegisterValueConverter(reflect.TypeOf(userID(0)), func(value interface{}) (driver.Value, error) {
+		return int64(value.(userID)), nil
+	})
+	storage.RegisterScanConverter(reflect.TypeOf(&mysql.UintField{}), reflect.TypeOf(time.Duration(0)), mysql.DurationFromMicroseconds)
+	storage.RegisterScanConverter(reflect.TypeOf(&mysql.VarCharField{}), reflect.TypeOf(net.IP{}), func(value interface{}) (interface{}, error) {
+		return net.ParseIP(value.(string)), nil
+	})
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+
+	// The values and the pointers of the registered types are converted in the written values and the filters
+	timeout := 1500 * time.Millisecond
+	id := userID(7)
+	assert.NoError(t, user.Edit(ctx, expr.Eq(expr.ModelField(user, "id"), expr.Value(&id)), map[string]interface{}{
+		"name":     net.ParseIP("192.0.2.1"),
+		"lastname": &timeout,
+	}))
+	assert.Equal(t, []driver.Value{int64(1500000), []byte(net.ParseIP("192.0.2.1").To16()), int64(7)}, fakeExecValues)
+
+	fakeRowValues = [][]driver.Value{{"192.0.2.1", "Sidorov"}}
+	defer func() { fakeRowValues = nil }()
+
+	var rows []struct {
+		ID   time.Duration
+		Name net.IP
+	}
+	if assert.NoError(t, user.GetAllToStructs(ctx, &rows, nil, nil, mysql.StructsOpts{})) && assert.NotEmpty(t, rows) {
+		assert.Equal(t, 2*time.Microsecond, rows[1].ID)
+		assert.Equal(t, net.ParseIP("192.0.2.1"), rows[1].Name)
+	}
+}
+
+func TestMySQL_VirtualFields(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	ctx := mysql.WithVirtualFields(context.Background(), user,
+		&model.DerivableField{Id: "label", DependsOn: []string{"id", "fullname"}, Get: func(ctx context.Context, row map[string]interface{}) (interface{}, error) {
+			return fmt.Sprintf("#%d %s", row["id"], row["fullname"]), nil
+		}},
+		&model.DerivableField{Id: "label_len", DependsOn: []string{"label"}, Get: func(ctx context.Context, row map[string]interface{}) (interface{}, error) {
+			return len(row["label"].(string)), nil
+		}},
+	)
+
+	// The dependencies are read but not returned
+	data, err := user.GetAll(ctx, []string{"name", "label", "label_len"}, model.GetAllOptions{})
+	if assert.NoError(t, err) && assert.Equal(t, fakeRowsCount, data.Len()) {
+		assert.Equal(t, []string{"name", "label", "label_len"}, data.Fields())
+		assert.Equal(t, map[string]interface{}{"name": "Name", "label": "#1 Name Lastname", "label_len": 16}, data.Maps()[0])
+	}
+
+	var rows []struct {
+		ID    uint32
+		Label string
+	}
+	if assert.NoError(t, user.GetAllToStructs(ctx, &rows, []string{"id", "label"}, nil, mysql.StructsOpts{})) &&
+		assert.Len(t, rows, fakeRowsCount) {
+		assert.Equal(t, "#2 Name Lastname", rows[1].Label)
+	}
+
+	buf := &strings.Builder{}
+	_, err = user.ExportJSONL(ctx, buf, []string{"id", "label_len"}, nil, mysql.JSONLExportOpts{})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(buf.String(), `{"id":1,"label_len":16}`))
+
+	columnar, err := user.GetAllColumnar(ctx, []string{"label"}, nil, mysql.ColumnarOpts{Derivable: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "#1 Name Lastname", columnar.Column("label").Value(0))
+	}
+
+	// The virtual fields are not the fields of the model without the context
+	_, err = user.GetAll(context.Background(), []string{"label"}, model.GetAllOptions{})
+	assert.Error(t, err)
+
+	_, err = user.GetAll(mysql.WithVirtualFields(ctx, user, &model.DerivableField{Id: "name", DependsOn: []string{"id"}}),
+		[]string{"id"}, model.GetAllOptions{})
+	if assert.Error(t, err) {
+		assert.Equal(t, "The virtual field 'name' collides with the field of model 'user'", errorMessage(err))
+	}
+
+	_, err = user.GetAll(mysql.WithVirtualFields(context.Background(), user,
+		&model.DerivableField{Id: "a", DependsOn: []string{"b"}},
+		&model.DerivableField{Id: "b", DependsOn: []string{"a"}},
+	), []string{"a"}, model.GetAllOptions{})
+	if assert.Error(t, err) {
+		assert.Equal(t, "The derivable field 'a' in model 'user' depends on itself", errorMessage(err))
+	}
+}
+
+func TestMySQL_GetAllToStructs(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+
+	// The fake driver returns all rows ignoring the limit
+	var rows []userRow
+	if assert.NoError(t, user.GetAllToStructs(ctx, &rows, nil, nil, mysql.StructsOpts{Limit: 2})) {
+		assert.Equal(t, "SELECT `id`,`name`,`lastname` FROM `user` LIMIT 2", lastFakeQuery())
+		lastname := "Lastname"
+		if assert.Len(t, rows, 10000) {
+			assert.Equal(t, []userRow{
+				{userNames: userNames{Name: "Name"}, ID: 1, Lastname: &lastname, FullName: []byte("Name Lastname")},
+				{userNames: userNames{Name: "Name"}, ID: 2, Lastname: &lastname, FullName: []byte("Name Lastname")},
+			}, rows[:2])
+		}
+	}
+
+	var ptrRows []*userRow
+	if assert.NoError(t, user.GetAllToStructs(ctx, &ptrRows, []string{"id", "fullname"}, nil, mysql.StructsOpts{})) &&
+		assert.Len(t, ptrRows, 10000) {
+		assert.Equal(t, &userRow{ID: 1, FullName: []byte("Name Lastname")}, ptrRows[0])
+	}
+
+	// The ids above 255 overflow uint8
+	var small []struct {
+		ID             uint8
+		Name, Lastname string
+	}
+	err := user.GetAllToStructs(ctx, &small, nil, nil, mysql.StructsOpts{})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "The value 256 of the field 'id' in model 'user' cannot be assigned")
+	}
+
+	var wrong []struct {
+		ID             bool
+		Name, Lastname string
+	}
+	assert.Error(t, user.GetAllToStructs(ctx, &wrong, nil, nil, mysql.StructsOpts{}))
+
+	var unknown []struct {
+		Name string `mysql:"unknown"`
+	}
+	assert.Error(t, user.GetAllToStructs(ctx, &unknown, nil, nil, mysql.StructsOpts{}))
+	assert.Error(t, user.GetAllToStructs(ctx, &rows, []string{"id", "unknown"}, nil, mysql.StructsOpts{}))
+	assert.Error(t, user.GetAllToStructs(ctx, rows, nil, nil, mysql.StructsOpts{}))
+}
+
+// failingExecutor fails the statements containing "missing" with the unknown table error
+type failingExecutor struct {
+	mysql.Executor
+}
+
+func (e failingExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if strings.Contains(query, "missing") {
+		return nil, &mysqldriver.MySQLError{Number: 1146, Message: "Table 'test.missing' doesn't exist"}
+	}
+	return e.Executor.ExecContext(ctx, query, args...)
+}
+
+func TestMySQL_ExecScript(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage.SetExecutorFactory(func(base mysql.Executor) mysql.Executor {
+		return failingExecutor{recordingExecutor{base, &mtx, &queries}}
+	})
+
+	script := `-- The vendor script
+SET @a = 'x;y'; # the comment
+CREATE TABLE t (id INT) /*!50100 ENGINE=InnoDB */;
+INSERT INTO t VALUES (1), /* the comment; */ (2);
+
+DELIMITER $$
+CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN
+  SET NEW.id = NEW.id + 1;
+END$$
+DELIMITER ;
+UPDATE t SET id = 3 WHERE id = "a\"; b"`
+
+	ctx := context.Background()
+	if assert.NoError(t, storage.ExecScript(ctx, script, mysql.ScriptOpts{Transaction: true})) {
+		assert.Equal(t, []string{
+			"BEGIN",
+			"SET @a = 'x;y'",
+			"COMMIT",
+			"CREATE TABLE t (id INT) /*!50100 ENGINE=InnoDB */",
+			"BEGIN",
+			"INSERT INTO t VALUES (1),   (2)",
+			"COMMIT",
+			"CREATE TRIGGER tr BEFORE INSERT ON t FOR EACH ROW BEGIN\n  SET NEW.id = NEW.id + 1;\nEND",
+			"BEGIN",
+			`UPDATE t SET id = 3 WHERE id = "a\"; b"`,
+			"COMMIT",
+		}, queries)
+	}
+
+	queries = nil
+	err := storage.ExecScript(ctx, "INSERT INTO t VALUES (1);\n\nINSERT INTO\n  missing VALUES (1);\nINSERT INTO t VALUES (2)", mysql.ScriptOpts{Transaction: true})
+	var scriptErr *mysql.ScriptError
+	if assert.True(t, errors.As(err, &scriptErr)) {
+		assert.Equal(t, 1, scriptErr.Index)
+		assert.Equal(t, 3, scriptErr.Line)
+		assert.Equal(t, "INSERT INTO missing VALUES (1)", scriptErr.Snippet)
+		var mysqlErr *mysqldriver.MySQLError
+		assert.True(t, errors.As(err, &mysqlErr))
+	}
+	assert.Equal(t, []string{"BEGIN", "INSERT INTO t VALUES (1)", "ROLLBACK"}, queries)
+
+	assert.Error(t, storage.ExecScript(ctx, "SELECT 'unterminated", mysql.ScriptOpts{}))
+	assert.Error(t, storage.ExecScript(ctx, "SELECT 1 /* unterminated", mysql.ScriptOpts{}))
+}
+
+func TestMySQL_NamedQuery(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+
+	assert.NoError(t, storage.RegisterNamedQuery(user, "by_name",
+		"SELECT `id`,`name`,`lastname` FROM `user` WHERE (`name` = :name OR `lastname` = :name) AND `lastname` <> ':skipped' LIMIT :limit",
+		[]string{"name", "limit"}))
+	assert.NoError(t, storage.RegisterNamedQuery(user, "rename", "UPDATE `user` SET `name` = :name WHERE `id` = :id", []string{"id", "name"}))
+
+	data, err := user.QueryNamed(ctx, "by_name", map[string]interface{}{"name": "Ivan", "limit": 1})
+	if assert.NoError(t, err) && assert.NotZero(t, data.Len()) {
+		assert.Equal(t, map[string]interface{}{"id": uint32(1), "name": "Name", "lastname": "Lastname"}, data.Maps()[0])
+	}
+	assert.Equal(t, "SELECT `id`,`name`,`lastname` FROM `user` WHERE (`name` = ? OR `lastname` = ?) AND `lastname` <> ':skipped' LIMIT ?", lastFakeQuery())
+	assert.Equal(t, []driver.Value{"Ivan", "Ivan", int64(1)}, fakeQueryValues)
+
+	_, err = user.ExecNamed(ctx, "rename", map[string]interface{}{"id": 7, "name": "Petr"})
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE `user` SET `name` = ? WHERE `id` = ?", lastFakeQuery())
+	assert.Equal(t, []driver.Value{"Petr", int64(7)}, fakeExecValues)
+
+	// The parameters are validated
+	_, err = user.ExecNamed(ctx, "rename", map[string]interface{}{"id": 7})
+	if assert.Error(t, err) {
+		assert.Equal(t, "The parameter 'name' of the named query 'rename' of model 'user' is not passed", errorMessage(err))
+	}
+	_, err = user.ExecNamed(ctx, "rename", map[string]interface{}{"id": 7, "name": "Petr", "age": 30})
+	if assert.Error(t, err) {
+		assert.Equal(t, "Unknown parameters age of the named query 'rename' of model 'user'", errorMessage(err))
+	}
+	_, err = user.ExecNamed(ctx, "missing", nil)
+	assert.Error(t, err)
+
+	// The templates are validated
+	assert.Error(t, storage.RegisterNamedQuery(user, "undeclared", "DELETE FROM `user` WHERE `id` = :id", nil))
+	assert.Error(t, storage.RegisterNamedQuery(user, "unused", "DELETE FROM `user`", []string{"id"}))
+	assert.Error(t, storage.RegisterNamedQuery(user, "positional", "DELETE FROM `user` WHERE `id` = ?", nil))
+}
+
+func TestMySQL_Executor(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	_, err := storage.Executor(context.Background()).ExecContext(context.Background(), "DELETE FROM `user`")
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM `user`", lastFakeQuery())
+
+	ctx, err := storage.StartTransaction(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, storage.InTransaction(ctx))
+
+	exec := storage.Executor(ctx)
+	_, isTx := exec.(*sql.Tx)
+	assert.False(t, isTx)
+	_, err = exec.ExecContext(context.Background(), "DELETE FROM `phone`")
+	assert.NoError(t, err)
+
+	// The transaction committed bypassing the storage cannot be committed or rolled back again
+	assert.NoError(t, storage.GetTransaction(ctx).Commit())
+	_, err = storage.Commit(ctx)
+	assert.True(t, errors.Is(err, mysql.ErrTxAlreadyFinished))
+	_, err = storage.Rollback(ctx)
+	assert.True(t, errors.Is(err, mysql.ErrTxAlreadyFinished))
+}
+
+func TestMySQL_ImplicitCommitGuard(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+
+	ctx, err := storage.StartTransaction(context.Background())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer storage.Rollback(ctx)
+
+	// Raw Exec
+	_, err = storage.Exec(ctx, "ALTER TABLE `user` ADD `age` INT")
+	assert.True(t, errors.Is(err, mysql.ErrImplicitCommitInTx))
+	_, err = storage.Exec(ctx, "  lock tables `user` WRITE")
+	assert.True(t, errors.Is(err, mysql.ErrImplicitCommitInTx))
+	for _, query := range []string{
+		"/* x */ TRUNCATE `user`",
+		"-- x\nALTER TABLE `user` ADD `age` INT",
+		"# x\n/* y */ DROP TABLE `user`",
+		"/*!50001 DROP TABLE `user` */",
+		"CREATE /* x */ TABLE `t` (`id` INT)",
+	} {
+		_, err = storage.Exec(ctx, query)
+		assert.True(t, errors.Is(err, mysql.ErrImplicitCommitInTx), query)
+	}
+	_, err = storage.Exec(ctx, "/* x */ CREATE /* y */ TEMPORARY TABLE `tmp` (`id` INT)")
+	assert.NoError(t, err)
+	_, err = storage.Exec(ctx, "CREATE TEMPORARY TABLE `tmp` (`id` INT)")
+	assert.NoError(t, err)
+	_, err = storage.Exec(mysql.AllowImplicitCommit(ctx), "ALTER TABLE `user` ADD `age` INT")
+	assert.NoError(t, err)
+	_, err = storage.Exec(context.Background(), "ALTER TABLE `user` ADD `age` INT")
+	assert.NoError(t, err)
+
+	// TruncateTable and InitDB are marked as committing the transaction
+	assert.True(t, errors.Is(user.TruncateTable(ctx), mysql.ErrImplicitCommitInTx))
+	assert.True(t, errors.Is(storage.InitDB(ctx), mysql.ErrImplicitCommitInTx))
+	assert.NoError(t, user.TruncateTable(context.Background()))
+	assert.Equal(t, "TRUNCATE TABLE `user`", lastFakeQuery())
+
+	// The migration script run in the transaction
+	err = storage.ExecScript(ctx, "INSERT INTO `user` VALUES (1, 'a', 'b');\nDROP TABLE `user`", mysql.ScriptOpts{})
+	var scriptErr *mysql.ScriptError
+	if assert.True(t, errors.As(err, &scriptErr)) {
+		assert.Equal(t, 1, scriptErr.Index)
+		assert.True(t, errors.Is(err, mysql.ErrImplicitCommitInTx))
+	}
+	assert.NoError(t, storage.ExecScript(mysql.AllowImplicitCommit(ctx), "DROP TABLE `user`", mysql.ScriptOpts{}))
+
+	storage.SetImplicitCommitGuard(false)
+	assert.NoError(t, user.TruncateTable(ctx))
+}
+
+func TestMySQL_WriteSelectSQL_Window(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+
+	// The window function arguments are bound in the order they are written
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Filter: expr.Gt(user.FieldExpr("id"), expr.Value(5))},
+		Columns: []mysql.Column{
+			{Alias: "prev", Expr: mysql.Lag(mysql.Col("name"), 2, expr.Value("none")).
+				PartitionBy(mysql.Func("LOWER", mysql.Col("lastname"))).
+				OrderBy(mysql.OrderExpr{Expr: mysql.Col("id")})},
+			{Alias: "next", Expr: mysql.Lead(mysql.Col("name"), 1, nil).OrderBy(mysql.OrderExpr{Expr: mysql.Col("id")})},
+			{Alias: "total", Expr: mysql.SumOver(mysql.Col("id")).
+				OrderBy(mysql.OrderExpr{Expr: mysql.Col("id")}).
+				Frame("rows between 6 preceding and  current row")},
+		},
+		OrderBy: []mysql.OrderExpr{{Expr: mysql.RowNumber().OrderBy(mysql.OrderExpr{Expr: mysql.Col("name"), Desc: true})}},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id`,LAG(`user`.`name`,2,?) OVER (PARTITION BY LOWER(`user`.`lastname`) ORDER BY `user`.`id`) AS `prev`,"+
+			"LEAD(`user`.`name`,1,NULL) OVER (ORDER BY `user`.`id`) AS `next`,"+
+			"SUM(`user`.`id`) OVER (ORDER BY `user`.`id` ROWS BETWEEN 6 PRECEDING AND CURRENT ROW) AS `total` FROM `user` WHERE `id`>?"+
+			" ORDER BY ROW_NUMBER() OVER (ORDER BY `user`.`name` DESC)", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{"none", 5}, sqlBuf.GetArgs())
+	}
+
+	// The window functions are computed over the groups
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"lastname"}, mysql.SelectOptions{
+		Columns: []mysql.Column{
+			{Alias: "users", Expr: mysql.Func("COUNT", mysql.Col("id"))},
+			{Alias: "place", Expr: mysql.Rank().OrderBy(mysql.OrderExpr{Expr: mysql.Func("COUNT", mysql.Col("id")), Desc: true})},
+		},
+		GroupBy: []model.IExpression{mysql.Col("lastname")},
+		Having:  expr.Gt(mysql.ColumnAlias("users"), expr.Value(1)),
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `lastname`,COUNT(`user`.`id`) AS `users`,RANK() OVER (ORDER BY COUNT(`user`.`id`) DESC) AS `place`"+
+			" FROM `user` GROUP BY `user`.`lastname` HAVING `users`>?", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{1}, sqlBuf.GetArgs())
+	}
+
+	// The latest user by the last name
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"id", "name"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{
+			Filter:  expr.Gt(user.FieldExpr("id"), expr.Value(5)),
+			OrderBy: []model.Order{{FieldName: "name"}},
+			Limit:   10,
+		},
+		Columns: []mysql.Column{{Alias: "rn", Expr: mysql.RowNumber().
+			PartitionBy(mysql.Col("lastname")).
+			OrderBy(mysql.OrderExpr{Expr: mysql.Col("id"), Desc: true})}},
+		OuterFilter: expr.Eq(mysql.ColumnAlias("rn"), expr.Value(1)),
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT * FROM (SELECT `id`,`name`,ROW_NUMBER() OVER (PARTITION BY `user`.`lastname` ORDER BY `user`.`id` DESC) AS `rn`"+
+			" FROM `user` WHERE `id`>?) AS `user` WHERE `rn`=? ORDER BY `name` LIMIT 10", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{5, 1}, sqlBuf.GetArgs())
+	}
+
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"id"}, mysql.SelectOptions{
+		Columns: []mysql.Column{{Alias: "total", Expr: mysql.SumOver(mysql.Col("id")).Frame("ROWS 1; DROP TABLE user")}},
+	})
+	assert.Error(t, sqlBuf.Err())
+}
+
+func TestMySQL_ServerVersion(t *testing.T) {
+	for raw, expected := range map[string]mysql.ServerVersion{
+		"8.0.32":                      {Raw: "8.0.32", Major: 8, Minor: 0, Patch: 32},
+		"5.7.44-log":                  {Raw: "5.7.44-log", Major: 5, Minor: 7, Patch: 44},
+		"10.6.12-MariaDB-1:10.6.12":   {Raw: "10.6.12-MariaDB-1:10.6.12", Major: 10, Minor: 6, Patch: 12, MariaDB: true},
+		"5.5.5-10.1.48-MariaDB-0+deb": {Raw: "5.5.5-10.1.48-MariaDB-0+deb", Major: 10, Minor: 1, Patch: 48, MariaDB: true},
+	} {
+		version, err := mysql.ParseServerVersion(raw)
+		if assert.NoError(t, err, raw) {
+			assert.Equal(t, expected, version, raw)
+		}
+	}
+	_, err := mysql.ParseServerVersion("unknown")
+	assert.Error(t, err)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+	options := mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Limit: 1},
+		Columns:       []mysql.Column{{Alias: "rn", Expr: mysql.RowNumber()}},
+	}
+
+	assert.NoError(t, storage.SetServerVersion("5.7.44-log"))
+	_, err = storage.Select(ctx, user, []string{"id", "name"}, options)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "The window functions require MySQL 8.0 or MariaDB 10.2, the server version is 5.7.44-log")
+	}
+
+	assert.NoError(t, storage.SetServerVersion("10.6.12-MariaDB"))
+	_, err = storage.Select(ctx, user, []string{"id", "name"}, options)
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT `id`,`name`,ROW_NUMBER() OVER () AS `rn` FROM `user` LIMIT 1", lastFakeQuery())
+}
+
+func TestMySQL_Returning(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+	data := model.NewData([]string{"id", "name", "lastname"}, [][]interface{}{{uint32(1), "Ivan", "Sidorov"}})
+
+	_, err := storage.AddReturning(ctx, user, data, model.AddOptions{}, []string{"unknown"})
+	assert.Error(t, err)
+
+	assert.NoError(t, storage.SetServerVersion("10.6.12-MariaDB"))
+	flavor, err := storage.Flavor(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, mysql.FlavorMariaDB, flavor)
+
+	_, err = storage.AddReturning(ctx, user, data, model.AddOptions{}, []string{"id", "name"})
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `user`(`id`,`name`,`lastname`)VALUES(?,?,?) RETURNING `id`,`name`", lastFakeQuery())
+
+	_, err = storage.DeleteReturning(ctx, user, mysql.In(user.FieldExpr("id"), []interface{}{1}), []string{"id", "name"})
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM `user` WHERE `id` IN (?) RETURNING `id`,`name`", lastFakeQuery())
+
+	assert.NoError(t, storage.SetServerVersion("8.0.32"))
+	flavor, err = storage.Flavor(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, mysql.FlavorMySQL, flavor)
+
+	res, err := storage.AddReturning(ctx, user, data, model.AddOptions{}, []string{"name"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, model.NewData([]string{"name"}, [][]interface{}{{res.Data()[0][0]}}), res)
+	}
+	assert.Equal(t, "SELECT `name`,`id` FROM `user` WHERE `id` IN (?)", lastFakeQuery())
+
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	_, err = storage.DeleteReturning(ctx, user, mysql.In(user.FieldExpr("id"), []interface{}{1}), []string{"id", "name"})
+	assert.NoError(t, err)
+	assert.Equal(t, "DELETE FROM `user` WHERE `id` IN (?)", lastFakeQuery())
+
+	// The stored fields are selected by the primary keys in the transaction of the insert
+	rows, err := user.AddAndFetch(ctx, data, model.AddOptions{})
+	if assert.NoError(t, err) && assert.Len(t, rows, 1) {
+		assert.Equal(t, "Name", rows[0]["name"])
+		assert.Equal(t, "Lastname", rows[0]["lastname"])
+		assert.NotContains(t, rows[0], "fullname")
+	}
+	assert.Equal(t, "SELECT `id`,`name`,`lastname` FROM `user` WHERE `id` IN (?)", lastFakeQuery())
+
+	assert.NoError(t, storage.SetServerVersion("10.6.12-MariaDB"))
+	_, err = user.AddAndFetch(ctx, data, model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `user`(`id`,`name`,`lastname`)VALUES(?,?,?) RETURNING `id`,`name`,`lastname`", lastFakeQuery())
+}
+
+type writeObserverFunc func(ctx context.Context, changes []mysql.Change)
+
+func (f writeObserverFunc) Committed(ctx context.Context, changes []mysql.Change) { f(ctx, changes) }
+
+func TestMySQL_WriteObserver(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	var committed [][]mysql.Change
+	storage.AddWriteObserver(writeObserverFunc(func(ctx context.Context, changes []mysql.Change) {
+		assert.False(t, storage.InTransaction(ctx))
+		committed = append(committed, changes)
+	}))
+
+	user := test.NewUser(storage)
+	add := func(ctx context.Context, id uint32) {
+		_, err := user.AddMulti(ctx, model.NewData([]string{"id", "name", "lastname"}, [][]interface{}{{id, "Ivan", "Sidorov"}}), model.AddOptions{})
+		assert.NoError(t, err)
+	}
+
+	add(context.Background(), 1)
+	if assert.Len(t, committed, 1) && assert.Len(t, committed[0], 1) {
+		change := committed[0][0]
+		assert.NotZero(t, change.TxId)
+		change.TxId = 0
+		assert.Equal(t, mysql.Change{
+			Model:  "user",
+			Op:     "insert",
+			PK:     map[string]interface{}{"id": uint32(1)},
+			Fields: map[string]interface{}{"id": uint32(1), "name": "Ivan", "lastname": "Sidorov"},
+		}, change)
+	}
+
+	committed = nil
+	ctx, err := storage.StartTransaction(context.Background())
+	assert.NoError(t, err)
+	add(ctx, 2)
+	ctx, err = storage.StartTransaction(ctx)
+	assert.NoError(t, err)
+	add(ctx, 3)
+	ctx, err = storage.Rollback(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, committed)
+	_, err = storage.Commit(ctx)
+	assert.NoError(t, err)
+	if assert.Len(t, committed, 1) && assert.Len(t, committed[0], 1) {
+		assert.Equal(t, map[string]interface{}{"id": uint32(2)}, committed[0][0].PK)
+	}
+
+	committed = nil
+	ctx, err = storage.StartTransaction(context.Background())
+	assert.NoError(t, err)
+	add(ctx, 4)
+	_, err = storage.Rollback(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, committed)
+}
+
+func TestMySQL_Outbox(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	outbox := mysql.NewOutboxModel(storage, "")
+	storage.SetOutbox(outbox)
+	user := test.NewUser(storage)
+
+	_, err := user.AddMulti(context.Background(), model.NewData([]string{"id", "name", "lastname"}, [][]interface{}{{uint32(1), "Ivan", "Sidorov"}}), model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `_outbox`(`tx_id`,`model`,`op`,`pk`,`fields`)VALUES(?,?,?,?,?)", lastFakeQuery())
+	if assert.Len(t, fakeExecValues, 5) {
+		assert.Equal(t, "user", fakeExecValues[1])
+		assert.Equal(t, "insert", fakeExecValues[2])
+		assert.Equal(t, `{"id":1}`, fakeExecValues[3])
+		assert.Equal(t, `{"id":1,"lastname":"Sidorov","name":"Ivan"}`, fakeExecValues[4])
+	}
+
+	ctx, _ := storage.DryRun(context.Background())
+	_, err = user.AddMulti(ctx, model.NewData([]string{"id", "name", "lastname"}, [][]interface{}{{uint32(2), "Ivan", "Sidorov"}}), model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `_outbox`(`tx_id`,`model`,`op`,`pk`,`fields`)VALUES(?,?,?,?,?)", lastFakeQuery())
+}
+
+func TestMySQL_ReservedWords(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	group := mysql.NewBaseModel(storage, "group", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "key", NotNull: true},
+		&mysql.VarCharField{Id: "order", Length: 32, NotNull: true},
+		&mysql.VarCharField{Id: "select", Length: 32, NotNull: true},
+	}, nil, mysql.BaseModelOpts{
+		BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"key"}},
+		Indexes:       []mysql.Index{{FieldNames: []string{"order", "select"}}},
+	})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	group.WriteCreateSQL(sqlBuf)
+	assert.Equal(t, "CREATE TABLE `group` (`key` INT UNSIGNED NOT NULL,`order` VARCHAR(32) NOT NULL,`select` VARCHAR(32) NOT NULL,"+
+		"PRIMARY KEY (`key`),INDEX `group__order_select`(`order`,`select`))ENGINE='InnoDB' DEFAULT CHARACTER SET 'UTF8'", sqlBuf.String())
+
+	_, err := group.AddMulti(context.Background(), model.NewData([]string{"key", "order", "select"}, [][]interface{}{
+		{uint32(1), "asc", "from"},
+	}), model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `group`(`key`,`order`,`select`)VALUES(?,?,?)", lastFakeQuery())
+
+	_, err = storage.Select(context.Background(), group, []string{"key", "order"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter:  expr.Eq(expr.ModelField(group, "select"), expr.Value("from")),
+		OrderBy: []model.Order{{FieldName: "order", Desc: true}},
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT `key`,`order` FROM `group` WHERE `select`=? ORDER BY `order` DESC", lastFakeQuery())
+
+	assert.Equal(t, "`a``b`.`c`", mysql.QuoteIdent("a`b", "c"))
+	for _, id := range []string{"", "a`b", "a b", "a\nb", "a.b", strings.Repeat("a", mysql.MaxIdentifierLength+1)} {
+		assert.Error(t, mysql.ValidateIdentifier(id), id)
+	}
+	assert.NoError(t, mysql.ValidateIdentifier("_junction__user$2"))
+
+	assert.Panics(t, func() {
+		mysql.NewBaseModel(storage, "bad`model", []mysql.IMysqlFieldDefinition{&mysql.UintField{Id: "id"}}, nil, mysql.BaseModelOpts{})
+	})
+	assert.Panics(t, func() {
+		mysql.NewBaseModel(storage, "bad_field", []mysql.IMysqlFieldDefinition{&mysql.UintField{Id: "i\td"}}, nil, mysql.BaseModelOpts{})
+	})
+}
+
+func TestMySQL_ModelDefinitionValidation(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	define := func() (err error) {
+		defer func() { err, _ = recover().(error) }()
+		mysql.NewBaseModel(storage, "invalid", []mysql.IMysqlFieldDefinition{
+			&mysql.UintField{Id: "id", NotNull: true},
+			&mysql.VarCharField{Id: "name"},
+			&mysql.UintField{Id: "name", AutoIncrement: true},
+		}, []model.IFieldDefinition{
+			&model.DerivableField{Id: "id", DependsOn: []string{"name"}},
+			&model.DerivableField{Id: "title", DependsOn: []string{"unknown"}},
+		}, mysql.BaseModelOpts{
+			BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id", "missing"}},
+			Indexes:       []mysql.Index{{FieldNames: []string{"title"}}},
+		})
+		return nil
+	}
+
+	var defErr *mysql.ModelDefinitionError
+	if err := define(); assert.True(t, errors.As(err, &defErr)) {
+		assert.Equal(t, "invalid", defErr.Model)
+		assert.Equal(t, []mysql.DefinitionProblem{
+			{"name", "VARCHAR length must be positive"},
+			{"name", "duplicate field id"},
+			{"id", "duplicate field id"},
+			{"missing", "the primary key field is not defined"},
+			{"title", "the index references the undefined field"},
+			{"name", "AUTO_INCREMENT requires the field to be the first one of the primary key or an index"},
+			{"title", "depends on the undefined field 'unknown'"},
+		}, defErr.Problems)
+		assert.Contains(t, err.Error(), "7 problems in the definition of the model 'invalid':\nfield 'name': VARCHAR length must be positive\n")
+	}
+}
+
+func TestMySQL_SplitPlaceholders(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	}})
+	defer storage.Disconnect()
+	storage.SetMaxPlaceholders(3)
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+	ids := func(n int) []interface{} {
+		res := make([]interface{}, n)
+		for i := range res {
+			res[i] = uint32(i + 1)
+		}
+		return res
+	}
+
+	data, err := storage.Select(ctx, user, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: mysql.In(user.FieldExpr("id"), ids(3)),
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, fakeRowsCount, data.Len())
+	assert.Equal(t, []string{"SELECT `id` FROM `user` WHERE `id` IN (?,?,?)"}, queries)
+
+	queries = nil
+	var found uint64
+	data, err = storage.Select(ctx, user, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter:      mysql.In(user.FieldExpr("id"), append(ids(4), uint32(4))),
+		OrderBy:     []model.Order{{FieldName: "id", Desc: true}},
+		Limit:       3,
+		Offset:      1,
+		RowsWoLimit: &found,
+	}})
+	assert.NoError(t, err)
+	if assert.Equal(t, 3, data.Len()) {
+		assert.Equal(t, []interface{}{uint32(fakeRowsCount), uint32(fakeRowsCount - 1), uint32(fakeRowsCount - 1)},
+			[]interface{}{data.Data()[0][0], data.Data()[1][0], data.Data()[2][0]})
+	}
+	assert.Equal(t, []string{
+		"SELECT  SQL_CALC_FOUND_ROWS `id` FROM `user` WHERE `id` IN (?,?,?) ORDER BY `id` DESC LIMIT 4",
+		"SELECT FOUND_ROWS()",
+		"SELECT  SQL_CALC_FOUND_ROWS `id` FROM `user` WHERE `id` IN (?) ORDER BY `id` DESC LIMIT 4",
+		"SELECT FOUND_ROWS()",
+	}, queries)
+
+	queries = nil
+	data, err = storage.Select(ctx, user, []string{"name"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter:  mysql.And(mysql.In(user.FieldExpr("id"), ids(3)), mysql.In(user.FieldExpr("name"), []string{"Name"})),
+		OrderBy: []model.Order{{FieldName: "id"}},
+		Limit:   2,
+	}})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, data.Len())
+	assert.Equal(t, "name", data.Fields()[0])
+	assert.NotContains(t, data.Fields(), "id")
+	assert.Equal(t, []string{
+		"SELECT `name`,`id` FROM `user` WHERE (`id` IN (?,?))AND(`name` IN (?)) ORDER BY `id` LIMIT 2",
+		"SELECT `name`,`id` FROM `user` WHERE (`id` IN (?))AND(`name` IN (?)) ORDER BY `id` LIMIT 2",
+	}, queries)
+
+	for _, filter := range []model.IExpression{
+		mysql.Or(mysql.In(user.FieldExpr("id"), ids(4))),
+		mysql.NotIn(user.FieldExpr("id"), ids(4)),
+	} {
+		_, err = storage.Select(ctx, user, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{Filter: filter}})
+		assert.True(t, errors.Is(err, mysql.ErrTooManyPlaceholders), err)
+	}
+
+	queries = nil
+	assert.True(t, errors.Is(user.Delete(ctx, mysql.In(user.FieldExpr("id"), ids(4))), mysql.ErrTooManyPlaceholders))
+	assert.Empty(t, queries)
+}
+
+func TestMySQL_ZeroDates(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	define := func(id string, policy mysql.ZeroDatePolicy, allowZeroTime bool) *mysql.BaseModel {
+		return mysql.NewBaseModel(storage, id, []mysql.IMysqlFieldDefinition{
+			&mysql.UintField{Id: "id", NotNull: true},
+			&mysql.DateTimeField{Id: "name", ZeroDate: mysql.ZeroDateNull},
+			&mysql.DateField{Id: "lastname", NotNull: true, ZeroDate: policy, AllowZeroTime: allowZeroTime},
+		}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	}
+	legacy := define("legacy", mysql.ZeroDateZeroTime, false)
+	strict := define("strict", mysql.ZeroDateError, false)
+	relaxed := define("relaxed", mysql.ZeroDateZeroTime, true)
+
+	// The zero dates are returned as is without parseTime and as the zero time.Time with it
+	for _, values := range [][]driver.Value{
+		{[]byte("0000-00-00 00:00:00"), []byte("0000-00-00")},
+		{time.Time{}, time.Time{}},
+	} {
+		fakeRowValues = [][]driver.Value{values}
+
+		data, err := legacy.GetAll(context.Background(), []string{"id", "name", "lastname"}, model.GetAllOptions{})
+		if assert.NoError(t, err) {
+			assert.Equal(t, map[string]interface{}{"id": uint32(1), "name": (*string)(nil), "lastname": "0000-00-00"}, data.Maps()[0])
+		}
+
+		columnar, err := legacy.GetAllColumnar(context.Background(), []string{"id", "name", "lastname"}, nil, mysql.ColumnarOpts{})
+		if assert.NoError(t, err) {
+			assert.True(t, columnar.Columns[1].Nulls[0])
+			assert.Equal(t, "0000-00-00", columnar.Columns[2].Strings[0])
+		}
+
+		_, err = strict.GetAll(context.Background(), []string{"id", "lastname"}, model.GetAllOptions{})
+		var temporalErr *mysql.TemporalValueError
+		if assert.True(t, errors.As(err, &temporalErr)) {
+			assert.True(t, errors.Is(err, mysql.ErrZeroDate))
+			assert.Equal(t, "lastname", temporalErr.Field)
+		}
+	}
+	fakeRowValues = [][]driver.Value{{[]byte("2024-01-02 03:04:05"), []byte("2024-01-02")}}
+	data, err := strict.GetAll(context.Background(), []string{"name", "lastname"}, model.GetAllOptions{})
+	if assert.NoError(t, err) {
+		name := "2024-01-02 03:04:05"
+		assert.Equal(t, map[string]interface{}{"name": &name, "lastname": "2024-01-02"}, data.Maps()[0])
+	}
+	fakeRowValues = nil
+
+	// The zero time is refused by the NOT NULL fields without AllowZeroTime
+	_, err = legacy.AddMulti(context.Background(), model.NewData([]string{"id", "name", "lastname"}, [][]interface{}{
+		{uint32(1), &time.Time{}, time.Time{}},
+	}), model.AddOptions{})
+	var temporalErr *mysql.TemporalValueError
+	if assert.True(t, errors.As(err, &temporalErr)) {
+		assert.True(t, errors.Is(err, mysql.ErrZeroTime))
+		assert.Equal(t, "lastname", temporalErr.Field)
+	}
+	assert.True(t, errors.Is(legacy.Edit(context.Background(), expr.Eq(expr.ModelField(legacy, "id"), expr.Value(1)),
+		map[string]interface{}{"lastname": time.Time{}},
+	), mysql.ErrZeroTime))
+
+	_, err = relaxed.AddMulti(context.Background(), model.NewData([]string{"id", "name", "lastname"}, [][]interface{}{
+		{uint32(1), &time.Time{}, time.Time{}},
+	}), model.AddOptions{})
+	assert.NoError(t, err)
+
+	assert.Panics(t, func() {
+		mysql.NewBaseModel(storage, "invalid", []mysql.IMysqlFieldDefinition{
+			&mysql.DateField{Id: "day", NotNull: true, ZeroDate: mysql.ZeroDateNull},
+		}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"day"}}})
+	})
+}
+
+func TestMySQL_BigUnsigned(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	hashes := mysql.NewBaseModel(storage, "hash", []mysql.IMysqlFieldDefinition{
+		&mysql.BigUintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.BigUintField{Id: "name", NotNull: true},
+		&mysql.BigUintField{Id: "lastname"},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	const (
+		half = uint64(1) << 63
+		max  = ^uint64(0)
+	)
+	ctx := context.Background()
+
+	// The driver returns the id 2^64-2 as -2
+	fakeLastInsertId = -2
+	defer func() { fakeLastInsertId = 0 }()
+	pks, err := hashes.AddMulti(ctx, model.NewData([]string{"name", "lastname"}, [][]interface{}{
+		{half, uint64(1)},
+		{max, &[]uint64{max}[0]},
+	}), model.AddOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]interface{}{{max - 1}, {max}}, pks.Data())
+	}
+	assert.Equal(t, []driver.Value{half, uint64(1), max, max}, fakeExecValues)
+
+	fakeRowValues = [][]driver.Value{
+		{[]byte("9223372036854775808"), []byte("18446744073709551615")},
+		{[]byte("18446744073709551615"), nil},
+		{[]byte("9223372036854775807"), []byte("1")},
+	}
+	defer func() { fakeRowValues = nil }()
+
+	data, err := hashes.GetAll(ctx, []string{"name", "lastname"}, model.GetAllOptions{})
+	if assert.NoError(t, err) {
+		maxValue := max
+		assert.Equal(t, []map[string]interface{}{
+			{"name": half, "lastname": &maxValue},
+			{"name": max, "lastname": (*uint64)(nil)},
+		}, data.Maps()[:2])
+	}
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, hashes, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: mysql.And(
+			mysql.In(hashes.FieldExpr("name"), []interface{}{half, max}),
+			mysql.Between(hashes.FieldExpr("lastname"), int64(math.MaxInt64), half+1),
+		),
+	}})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, []interface{}{half, max, int64(math.MaxInt64), half + 1}, sqlBuf.GetArgs())
+	}
+
+	// 2^63+1 and math.MaxInt64 are equal as float64
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, hashes, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: mysql.Between(hashes.FieldExpr("lastname"), half+1, int64(math.MaxInt64)),
+	}})
+	assert.Error(t, sqlBuf.Err())
+
+	// The rows of the split select are ordered in memory, math.MaxInt64 and 2^63 are equal as float64
+	storage.SetMaxPlaceholders(2)
+	data, err = storage.Select(ctx, hashes, []string{"name"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter:  mysql.In(hashes.FieldExpr("id"), []interface{}{uint64(1), half, max}),
+		OrderBy: []model.Order{{FieldName: "name"}},
+	}})
+	if assert.NoError(t, err) && assert.Equal(t, 2*fakeRowsCount, data.Len()) {
+		maps := data.Maps()
+		assert.Equal(t, uint64(math.MaxInt64), maps[0]["name"])
+		assert.Equal(t, max, maps[len(maps)-1]["name"])
+		for i := 1; i < len(maps); i++ {
+			if !assert.LessOrEqual(t, maps[i-1]["name"], maps[i]["name"]) {
+				break
+			}
+		}
+	}
+}
+
+func TestMySQL_WarningsCheck(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	}})
+	defer storage.Disconnect()
+
+	define := func(id string, checkWarnings bool) *mysql.BaseModel {
+		return mysql.NewBaseModel(storage, id, []mysql.IMysqlFieldDefinition{
+			&mysql.UintField{Id: "id", NotNull: true},
+			&mysql.VarCharField{Id: "name", Length: 4, NotNull: true},
+		}, nil, mysql.BaseModelOpts{
+			BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}},
+			CheckWarnings: checkWarnings,
+		})
+	}
+	checked := define("checked", true)
+	unchecked := define("unchecked", false)
+
+	// SHOW WARNINGS returns the Level, Code and Message columns
+	fakeRowValues = [][]driver.Value{
+		{[]byte("1292"), []byte("Truncated incorrect INTEGER value: 'x'")},
+		{[]byte("1406"), []byte("Data too long for column 'name' at row 2")},
+	}
+	defer func() { fakeRowValues = nil }()
+
+	ctx := context.Background()
+	rows := model.NewData([]string{"id", "name"}, [][]interface{}{{uint32(1), "Ivan"}, {uint32(2), "Ivanov"}})
+
+	_, err = checked.AddMulti(ctx, rows, model.AddOptions{})
+	var truncationErr *mysql.TruncationError
+	if assert.True(t, errors.As(err, &truncationErr)) {
+		assert.True(t, errors.Is(err, mysql.ErrDataTruncated))
+		assert.True(t, errors.Is(err, mysql.ErrDataTooLong))
+		assert.Equal(t, "checked", truncationErr.Model)
+		assert.Equal(t, "name", truncationErr.Field)
+		assert.Equal(t, 2, truncationErr.Row)
+		assert.Equal(t, uint16(1406), truncationErr.Warning.Code)
+	}
+	assert.Equal(t, []string{
+		"INSERT INTO `checked`(`id`,`name`)VALUES(?,?),(?,?)",
+		"SHOW WARNINGS",
+	}, queries)
+
+	queries = nil
+	_, err = unchecked.AddMulti(ctx, rows, model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"INSERT INTO `unchecked`(`id`,`name`)VALUES(?,?),(?,?)"}, queries)
+
+	// The check is enabled and disabled per call
+	queries = nil
+	_, err = unchecked.AddMulti(mysql.WithWarningsCheck(ctx, true), rows, model.AddOptions{})
+	assert.True(t, errors.Is(err, mysql.ErrDataTruncated))
+	_, err = checked.AddMulti(mysql.WithWarningsCheck(ctx, false), rows, model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"INSERT INTO `unchecked`(`id`,`name`)VALUES(?,?),(?,?)",
+		"SHOW WARNINGS",
+		"INSERT INTO `checked`(`id`,`name`)VALUES(?,?),(?,?)",
+	}, queries)
+
+	queries = nil
+	err = checked.Edit(ctx, mysql.In(checked.FieldExpr("id"), []interface{}{1}), map[string]interface{}{"name": "Ivanov"})
+	assert.True(t, errors.Is(err, mysql.ErrDataTruncated))
+	assert.Equal(t, []string{"UPDATE `checked` SET `name`=? WHERE `id` IN (?)", "SHOW WARNINGS"}, queries)
+
+	// The warnings without the column are not the truncations
+	fakeRowValues = fakeRowValues[:1]
+	_, err = checked.AddMulti(ctx, rows, model.AddOptions{})
+	assert.NoError(t, err)
+}
+
+func TestMySQL_SQLMode(t *testing.T) {
+	driverName := mysql.SqlDriver
+	mysql.SqlDriver = "mysql_fake"
+	defer func() { mysql.SqlDriver = driverName }()
+
+	_, err := mysql.NewFromDSN("user@tcp(localhost:3306)/test", mysql.Options{SQLMode: "TRADITIONAL'; DROP"})
+	assert.Error(t, err)
+
+	storage, err := mysql.NewFromDSN("user@tcp(localhost:3306)/test", mysql.Options{SQLMode: "STRICT_TRANS_TABLES,NO_ZERO_DATE"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer storage.Disconnect()
+
+	fakeExecMtx.Lock()
+	dsn := fakeLastDSN
+	fakeExecMtx.Unlock()
+	assert.Contains(t, dsn, "sql_mode=%27STRICT_TRANS_TABLES%2CNO_ZERO_DATE%27")
+}
+
+func TestMySQL_UTF8Policy(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	notes := mysql.NewBaseModel(storage, "note", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "title", Length: 4, NotNull: true, UTF8: mysql.UTF8Reject},
+		&mysql.VarCharField{Id: "emoji", Length: 4, Charset: "utf8mb4", UTF8: mysql.UTF8Reject},
+		&mysql.TextField{Id: "stripped", UTF8: mysql.UTF8Strip},
+		&mysql.TextField{Id: "replaced", UTF8: mysql.UTF8Replace},
+		&mysql.TextField{Id: "raw"},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	ctx := context.Background()
+	add := func(title string, emoji string) error {
+		_, err := notes.AddMulti(ctx, model.NewData([]string{"id", "title", "emoji", "stripped", "replaced", "raw"}, [][]interface{}{
+			{uint32(1), title, &emoji, "a😀b", "a😀b", "a😀b\xff"},
+		}), model.AddOptions{})
+		return err
+	}
+
+	// The length is counted in characters
+	if assert.NoError(t, add("Ivan", "😀😀😀😀")) {
+		assert.Equal(t, []driver.Value{int64(1), "Ivan", "😀😀😀😀", "ab", "a\uFFFDb", "a😀b\xff"}, fakeExecValues)
+	}
+
+	for _, c := range []struct {
+		title, emoji string
+		kind         error
+		field        string
+	}{
+		{"Ivan", "😀😀😀😀😀", mysql.ErrDataTooLong, "emoji"},
+		{"Ivanov", "", mysql.ErrDataTooLong, "title"},
+		{"Iv😀", "", mysql.ErrFourByteChar, "title"},
+		{"Iv\xff", "", mysql.ErrInvalidUTF8, "title"},
+	} {
+		err := add(c.title, c.emoji)
+		var valueErr *mysql.StringValueError
+		if assert.True(t, errors.As(err, &valueErr), c.title) {
+			assert.True(t, errors.Is(err, c.kind))
+			assert.Equal(t, c.field, valueErr.Field)
+		}
+	}
+
+	assert.True(t, errors.Is(notes.Edit(ctx, mysql.In(notes.FieldExpr("id"), []interface{}{1}),
+		map[string]interface{}{"title": "😀"},
+	), mysql.ErrFourByteChar))
+	assert.NoError(t, notes.Edit(ctx, mysql.In(notes.FieldExpr("id"), []interface{}{1}),
+		map[string]interface{}{"stripped": "😀x"},
+	))
+	assert.Equal(t, []driver.Value{"x", int64(1)}, fakeExecValues)
+}
+
+func TestMySQL_CompositePK(t *testing.T) {
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	}})
+	defer storage.Disconnect()
+
+	// The fake driver returns the rows with the ids 1, 2, ... and the name 'Name'
+	roles := mysql.NewBaseModel(storage, "user_role", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "name", Length: 32, NotNull: true},
+		&mysql.VarCharField{Id: "lastname", Length: 32},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id", "name"}}})
+
+	ctx := context.Background()
+
+	_, err = storage.Add(ctx, roles, model.NewData([]string{"id", "lastname"}, [][]interface{}{{uint32(1), "Ivanov"}}), model.AddOptions{})
+	if assert.ErrorIs(t, err, mysql.ErrIncompletePK) {
+		assert.Contains(t, err.Error(), "'name'")
+	}
+	_, err = storage.Add(ctx, roles, model.NewData([]string{"id", "name"}, [][]interface{}{
+		{uint32(1), "admin"},
+		{uint32(2), (*string)(nil)},
+	}), model.AddOptions{})
+	if assert.ErrorIs(t, err, mysql.ErrIncompletePK) {
+		assert.Contains(t, err.Error(), "'name'")
+		assert.Contains(t, err.Error(), "row 2")
+	}
+	assert.Empty(t, queries)
+
+	pks, err := storage.Add(ctx, roles, model.NewData([]string{"id", "name"}, [][]interface{}{{uint32(1), "admin"}}), model.AddOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, [][]interface{}{{uint32(1), "admin"}}, pks.Data())
+	}
+
+	// The keys are the slices or the maps, the result is in the order of the keys
+	data, err := roles.GetByPKs(ctx, []interface{}{
+		[]interface{}{uint32(3), "Name"},
+		map[string]interface{}{"id": 1, "name": "Name"},
+		[]interface{}{uint32(5), "Other"},
+	}, []string{"id", "lastname"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []map[string]interface{}{
+			{"id": uint32(3), "lastname": &[]string{"Lastname"}[0]},
+			{"id": uint32(1), "lastname": &[]string{"Lastname"}[0]},
+		}, data.Maps())
+	}
+
+	row, err := roles.GetByPK(ctx, []interface{}{7, "Name"}, []string{"lastname"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]interface{}{"lastname": &[]string{"Lastname"}[0]}, row)
+	}
+
+	assert.NoError(t, roles.EditByPK(ctx, map[string]interface{}{"id": 1, "name": "admin"}, map[string]interface{}{"lastname": "Petrov"}))
+	assert.NoError(t, roles.DeleteByPK(ctx, []interface{}{1, "admin"}))
+
+	assert.Equal(t, []string{
+		"INSERT INTO `user_role`(`id`,`name`)VALUES(?,?)",
+		"SELECT `id`,`lastname`,`name` FROM `user_role` WHERE (`id`,`name`) IN ((?,?),(?,?),(?,?))",
+		"SELECT `lastname`,`id`,`name` FROM `user_role` WHERE (`id`,`name`) IN ((?,?))",
+		"UPDATE `user_role` SET `lastname`=? WHERE (`id` IN (?))AND(`name` IN (?))",
+		"DELETE FROM `user_role` WHERE (`id` IN (?))AND(`name` IN (?))",
+	}, queries)
+
+	for _, key := range []interface{}{
+		map[string]interface{}{"id": 1},
+		[]interface{}{1},
+		[]interface{}{1, nil},
+	} {
+		_, err := roles.GetByPK(ctx, key, []string{"lastname"})
+		if assert.ErrorIs(t, err, mysql.ErrIncompletePK) {
+			assert.Contains(t, err.Error(), "'name'")
+		}
+		assert.ErrorIs(t, roles.EditByPK(ctx, key, map[string]interface{}{"lastname": "Petrov"}), mysql.ErrIncompletePK)
+		assert.ErrorIs(t, roles.DeleteByPK(ctx, key), mysql.ErrIncompletePK)
+	}
+
+	_, err = roles.GetByPK(ctx, 1, []string{"lastname"})
+	assert.Error(t, err)
+	_, err = roles.GetByPK(ctx, map[string]interface{}{"id": 1, "name": "admin", "role": 2}, []string{"lastname"})
+	assert.Error(t, err)
+}
+
+func TestMySQL_WriteSelectSQL_Order(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	group := test.NewGroup(storage)
+	scores := mysql.NewBaseModel(storage, "score", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.IntField{Id: "points"},
+		&mysql.VarCharField{Id: "code", Length: 8},
+	}, nil, mysql.BaseModelOpts{
+		BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}},
+		Indexes:       []mysql.Index{{FieldNames: []string{"code"}, Unique: true}},
+	})
+
+	for _, c := range []struct {
+		m       model.IModel
+		options mysql.SelectOptions
+		sql     string
+	}{
+		// The IS NULL sort key is prepended only if the position of the NULLs differs from the default one
+		{scores, mysql.SelectOptions{OrderBy: []mysql.OrderExpr{
+			mysql.OrderExpr{Expr: scores.FieldExpr("points")}.NullsLast(),
+			mysql.OrderExpr{Expr: scores.FieldExpr("code"), Desc: true}.NullsFirst(),
+		}}, "SELECT `id` FROM `score` ORDER BY `points` IS NULL,`points`,`code` IS NULL DESC,`code` DESC"},
+		{scores, mysql.SelectOptions{OrderBy: []mysql.OrderExpr{
+			mysql.OrderExpr{Expr: scores.FieldExpr("points")}.NullsFirst(),
+			mysql.OrderExpr{Expr: scores.FieldExpr("code"), Desc: true}.NullsLast(),
+		}}, "SELECT `id` FROM `score` ORDER BY `points`,`code` DESC"},
+		{scores, mysql.SelectOptions{Columns: []mysql.Column{
+			{Alias: "rank", Expr: mysql.RowNumber().OrderBy(mysql.OrderExpr{Expr: mysql.Col("points"), Desc: true}.NullsFirst())},
+		}}, "SELECT `id`,ROW_NUMBER() OVER (ORDER BY `score`.`points` IS NULL DESC,`score`.`points` DESC) AS `rank` FROM `score`"},
+
+		// The primary key is the tiebreaker of the pages
+		{user, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+			OrderBy: []model.Order{{FieldName: "name", Desc: true}}, Limit: 10, Offset: 20,
+		}}, "SELECT `id` FROM `user` ORDER BY `name` DESC,`id` LIMIT 10 OFFSET 20"},
+		{scores, mysql.SelectOptions{
+			GetAllOptions: model.GetAllOptions{Limit: 10},
+			OrderBy:       []mysql.OrderExpr{mysql.OrderExpr{Expr: scores.FieldExpr("points")}.NullsLast()},
+		}, "SELECT `id` FROM `score` ORDER BY `points` IS NULL,`points`,`id` LIMIT 10"},
+		{scores, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+			OrderBy: []model.Order{{FieldName: "code"}}, Limit: 10,
+		}}, "SELECT `id` FROM `score` ORDER BY `code`,`id` LIMIT 10"},
+		{user, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+			OrderBy: []model.Order{{FieldName: "name"}},
+		}}, "SELECT `id` FROM `user` ORDER BY `name`"},
+		{user, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+			OrderBy: []model.Order{{FieldName: "id", Desc: true}, {FieldName: "name"}}, Limit: 10,
+		}}, "SELECT `id` FROM `user` ORDER BY `id` DESC,`name` LIMIT 10"},
+		{group, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+			OrderBy: []model.Order{{FieldName: "name"}}, Limit: 10,
+		}}, "SELECT `id` FROM `group` ORDER BY `name` LIMIT 10"},
+		{user, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+			OrderBy: []model.Order{{FieldName: "name"}}, Limit: 10, Distinct: true,
+		}}, "SELECT  DISTINCT `id` FROM `user` ORDER BY `name` LIMIT 10"},
+	} {
+		sqlBuf := mysql.NewSqlBuffer()
+		storage.WriteSelectSQL(sqlBuf, c.m, []string{"id"}, c.options)
+		if assert.NoError(t, sqlBuf.Err()) {
+			assert.Equal(t, c.sql, sqlBuf.GetSQL())
+		}
+	}
+}
+
+type tenantCtxKey struct{}
+
+// unscopedHook records whether the statements are unscoped
+type unscopedHook struct {
+	mtx      *sync.Mutex
+	unscoped *[]bool
+}
+
+func (h unscopedHook) BeforeQuery(ctx context.Context, info *mysql.QueryHookInfo) (context.Context, error) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	*h.unscoped = append(*h.unscoped, info.Unscoped)
+	return ctx, nil
+}
+
+func (h unscopedHook) AfterQuery(context.Context, *mysql.QueryHookInfo, driver.Result, error) {}
+
+func TestMySQL_ScopePolicy(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	db, err := sql.Open("mysql_fake", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var (
+		mtx      sync.Mutex
+		queries  []string
+		unscoped []bool
+	)
+	storage := mysql.NewWithDB(db, mysql.Options{ExecutorFactory: func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	}})
+	defer storage.Disconnect()
+	storage.AddQueryHook(unscopedHook{&mtx, &unscoped})
+	reset := func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		queries, unscoped = nil, nil
+	}
+
+	var owners, docs *mysql.BaseModel
+	tenantScope := func(m **mysql.BaseModel) mysql.ScopePolicy {
+		return func(ctx context.Context) (model.IExpression, error) {
+			tenant, ok := ctx.Value(tenantCtxKey{}).(uint32)
+			if !ok {
+				return nil, errors.New("no tenant")
+			}
+			return expr.Eq((*m).FieldExpr("tenant_id"), expr.Value(tenant)), nil
+		}
+	}
+	owners = mysql.NewBaseModel(storage, "owner", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.UintField{Id: "tenant_id", NotNull: true},
+		&mysql.VarCharField{Id: "name", Length: 32, NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}, Scope: tenantScope(&owners)})
+	docs = mysql.NewBaseModel(storage, "doc", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.UintField{Id: "tenant_id", NotNull: true},
+		&mysql.UintField{Id: "owner_id", NotNull: true},
+		&mysql.VarCharField{Id: "name", Length: 32, NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}, Scope: tenantScope(&docs)})
+	var junction *mysql.BaseModel
+	links := storage.AddManyToMany(owners, docs, mysql.ManyToManyOpts{
+		ExtraFields: []mysql.IMysqlFieldDefinition{&mysql.UintField{Id: "tenant_id", NotNull: true}},
+		Scope:       tenantScope(&junction),
+	})
+	junction = links.GetJunctionModel()
+	user := test.NewUser(storage)
+
+	ctx := context.WithValue(context.Background(), tenantCtxKey{}, uint32(7))
+
+	// The scope values are set by the INSERT, the other ones are refused
+	_, err = docs.AddMulti(ctx, model.NewData([]string{"owner_id", "name"}, [][]interface{}{{uint32(1), "a"}, {uint32(2), "b"}}), model.AddOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []driver.Value{int64(1), "a", int64(7), int64(2), "b", int64(7)}, fakeExecValues)
+	}
+	_, err = docs.AddMulti(ctx, model.NewData([]string{"tenant_id", "owner_id", "name"}, [][]interface{}{
+		{uint32(7), uint32(1), "a"},
+		{uint32(8), uint32(1), "b"},
+	}), model.AddOptions{})
+	if assert.ErrorIs(t, err, mysql.ErrOutOfScope) {
+		assert.Contains(t, err.Error(), "row 2")
+	}
+
+	// The duplicate row of the other tenant is not updated
+	reset()
+	_, err = docs.AddMulti(ctx, model.NewData([]string{"tenant_id", "owner_id", "name"}, [][]interface{}{{uint32(7), uint32(1), "a"}}),
+		model.AddOptions{Replace: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"INSERT INTO `doc`(`tenant_id`,`owner_id`,`name`)VALUES(?,?,?)ON DUPLICATE KEY UPDATE " +
+		"`tenant_id`=IF(`tenant_id`=?,VALUES(`tenant_id`),`tenant_id`),`owner_id`=IF(`tenant_id`=?,VALUES(`owner_id`),`owner_id`)," +
+		"`name`=IF(`tenant_id`=?,VALUES(`name`),`name`)"}, queries)
+
+	// The scope filter is ANDed into the statements, the joins and the subqueries
+	sqlBuf := storage.ScopedSqlBuffer(ctx)
+	storage.WriteSelectSQL(sqlBuf, docs, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Filter: expr.Eq(docs.FieldExpr("name"), expr.Value("a"))},
+		Parents:       []mysql.Parent{{Model: owners, FkFieldName: "owner_id", FieldsNames: []string{"name"}, Left: true}},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `doc`.`id`,`owner`.`id` AS `owner.id`,`owner`.`name` AS `owner.name` FROM `doc` LEFT JOIN `owner` ON `owner`.`id`=`doc`.`owner_id`"+
+			" AND (`owner`.`tenant_id`=?) WHERE (`doc`.`name`=?)AND(`doc`.`tenant_id`=?)", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{uint32(7), "a", uint32(7)}, sqlBuf.GetArgs())
+	}
+
+	reset()
+	_, err = user.GetAll(ctx, []string{"id"}, model.GetAllOptions{
+		Filter: mysql.Exists(docs, expr.Eq(docs.FieldExpr("owner_id"), mysql.OuterCol("id"))),
+	})
+	assert.NoError(t, err)
+	_, err = owners.GetAll(ctx, []string{"id"}, model.GetAllOptions{
+		Filter: expr.Any(owners, docs, expr.Eq(docs.FieldExpr("name"), expr.Value("a"))),
+	})
+	assert.NoError(t, err)
+	linked, err := links.GetLinked(ctx, []interface{}{1}, []string{"name"}, nil)
+	if assert.NoError(t, err) {
+		// The rows are keyed by the id passed, not by the scanned value
+		assert.Equal(t, []map[string]interface{}{{"name": "Name"}}, linked[1])
+	}
+	assert.NoError(t, docs.Edit(ctx, expr.Eq(docs.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "c"}))
+	assert.NoError(t, docs.Delete(ctx, expr.Eq(docs.FieldExpr("id"), expr.Value(1))))
+	assert.ErrorIs(t, docs.Edit(ctx, nil, map[string]interface{}{"tenant_id": uint32(8)}), mysql.ErrOutOfScope)
+	assert.Equal(t, []string{
+		"SELECT `id` FROM `user` WHERE EXISTS(SELECT 1 FROM `doc` AS `sq1` WHERE (`owner_id`=`user`.`id`)AND(`tenant_id`=?))",
+		"SELECT `id` FROM `owner` WHERE (`id`=ANY(SELECT `fk_owner_id` FROM `_junction__owner__doc` WHERE (`fk_doc_id`)=ANY(SELECT `id` FROM `doc` WHERE (`name`=?)AND(`tenant_id`=?)) AND (`tenant_id`=?)))AND(`tenant_id`=?)",
+		"SELECT `_junction__owner__doc`.`fk_owner_id`,`doc`.`name` FROM `doc` JOIN `_junction__owner__doc` ON `_junction__owner__doc`.`fk_doc_id`=`doc`.`id` WHERE `_junction__owner__doc`.`fk_owner_id` IN (?) AND (`doc`.`tenant_id`=?) AND (`_junction__owner__doc`.`tenant_id`=?)",
+		"UPDATE `doc` SET `name`=? WHERE (`id`=?)AND(`tenant_id`=?)",
+		"DELETE FROM `doc` WHERE (`id`=?)AND(`tenant_id`=?)",
+	}, queries)
+
+	sqlBuf = mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, docs, []string{"id"}, mysql.SelectOptions{})
+	assert.Error(t, sqlBuf.Err())
+	sqlBuf = storage.ScopedSqlBuffer(ctx)
+	storage.WriteSelectSQL(sqlBuf, docs, []string{"id"}, mysql.SelectOptions{})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id` FROM `doc` WHERE `tenant_id`=?", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{uint32(7)}, sqlBuf.GetArgs())
+	}
+
+	// The policy error fails the statement
+	reset()
+	_, err = docs.GetAll(context.Background(), []string{"id"}, model.GetAllOptions{})
+	assert.EqualError(t, err, "no tenant")
+	assert.Empty(t, queries)
+
+	// The unscoped statements are reported to the hooks
+	_, err = docs.GetAll(mysql.Unscoped(context.Background()), []string{"id"}, model.GetAllOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"SELECT `id` FROM `doc`"}, queries)
+	assert.Equal(t, []bool{true}, unscoped)
+}
+
+type auditorCtxKey struct{}
+
+func TestMySQL_Audit(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+	fakeRowValues = [][]driver.Value{{"Ivan", "Sidorov"}}
+	defer func() { fakeRowValues = nil }()
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage.SetExecutorFactory(func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	})
+	reset := func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		queries = nil
+	}
+	const historyInsert = "INSERT INTO `user_history`(`changed_at`,`changed_by`,`operation`,`diff`,`id`,`name`,`lastname`)VALUES(?,?,?,?,?,?,?)"
+
+	user := test.NewUser(storage)
+	history := storage.EnableAudit(user, mysql.AuditOpts{ChangedByKey: auditorCtxKey{}})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	history.WriteCreateSQL(sqlBuf)
+	assert.Equal(t, "CREATE TABLE `user_history` (`audit_id` BIGINT UNSIGNED NOT NULL AUTO_INCREMENT,`changed_at` DATETIME NOT NULL,"+
+		"`changed_by` VARCHAR(255),`operation` VARCHAR(16) NOT NULL,`diff` JSON,`id` INT UNSIGNED,`name` VARCHAR(255),`lastname` VARCHAR(255),"+
+		"PRIMARY KEY (`audit_id`),INDEX `user_history__id`(`id`))ENGINE='InnoDB' DEFAULT CHARACTER SET 'UTF8'", sqlBuf.String())
+
+	ctx := context.WithValue(context.Background(), auditorCtxKey{}, "admin")
+	_, err := user.AddMulti(ctx, model.NewData([]string{"id", "name", "lastname"}, [][]interface{}{{uint32(1), "Ivan", "Sidorov"}}), model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"INSERT INTO `user`(`id`,`name`,`lastname`)VALUES(?,?,?)", historyInsert}, queries)
+	if assert.Len(t, fakeExecValues, 7) {
+		assert.Equal(t, []driver.Value{"admin", "insert", `{"id":{"new":1},"lastname":{"new":"Sidorov"},"name":{"new":"Ivan"}}`, int64(1), "Ivan", "Sidorov"}, fakeExecValues[1:])
+	}
+
+	// Nothing is changed
+	reset()
+	assert.NoError(t, user.Edit(ctx, expr.Eq(user.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "Ivan"}))
+	assert.Equal(t, []string{"SELECT `id`,`name` FROM `user` WHERE `id`=? FOR UPDATE", "UPDATE `user` SET `name`=? WHERE `id`=?"}, queries)
+
+	// The fake driver returns many rows, the history rows are inserted in chunks
+	reset()
+	assert.NoError(t, storage.EditByPK(ctx, user, 1, map[string]interface{}{"name": "Ivan", "lastname": "Petrov"}))
+	if assert.Len(t, queries, 4) {
+		assert.Equal(t, []string{"SELECT `id`,`lastname`,`name` FROM `user` WHERE `id` IN (?) FOR UPDATE", "UPDATE `user` SET `lastname`=?, `name`=? WHERE `id` IN (?)"}, queries[:2])
+		assert.True(t, strings.HasPrefix(queries[3], historyInsert+",(?"))
+	}
+	assert.Equal(t, []driver.Value{"admin", "update", `{"lastname":{"new":"Petrov","old":"Sidorov"}}`}, fakeExecValues[1:4])
+	assert.Equal(t, []driver.Value{nil, "Petrov"}, fakeExecValues[5:7])
+
+	reset()
+	assert.NoError(t, storage.DeleteByPK(context.Background(), user, 1))
+	if assert.Len(t, queries, 4) {
+		assert.Equal(t, []string{"SELECT `id`,`name`,`lastname` FROM `user` WHERE `id` IN (?) FOR UPDATE", "DELETE FROM `user` WHERE `id` IN (?)"}, queries[:2])
+	}
+	assert.Equal(t, []driver.Value{nil, "delete"}, fakeExecValues[1:3])
+	assert.Contains(t, fakeExecValues[3], `"lastname":{"old":"Sidorov"}`)
+
+	// The old values are passed by the caller
+	person := mysql.NewBaseModel(storage, "person", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "name", Length: 255, NotNull: true},
+		&mysql.VarCharField{Id: "lastname", Length: 255, NotNull: true},
+	}, nil, mysql.BaseModelOpts{
+		BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}},
+	})
+	storage.EnableAudit(person, mysql.AuditOpts{DiffSource: mysql.AuditOldValues})
+
+	reset()
+	ctx = mysql.WithAuditOldValues(context.Background(), map[string]interface{}{"name": "Ivan", "lastname": "Sidorov"})
+	assert.NoError(t, person.Edit(ctx, expr.Eq(person.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "Ivan", "lastname": "Petrov"}))
+	if assert.Len(t, queries, 4) {
+		assert.Equal(t, []string{"SELECT `id` FROM `person` WHERE `id`=? FOR UPDATE", "UPDATE `person` SET `lastname`=?, `name`=? WHERE `id`=?"}, queries[:2])
+	}
+	assert.Equal(t, []driver.Value{nil, "update", `{"lastname":{"new":"Petrov","old":"Sidorov"}}`}, fakeExecValues[1:4])
+
+	// Nothing is audited in the dry run
+	reset()
+	ctx, _ = storage.DryRun(context.Background())
+	assert.NoError(t, user.Edit(ctx, expr.Eq(user.FieldExpr("id"), expr.Value(1)), map[string]interface{}{"name": "Petr"}))
+	assert.Empty(t, queries)
+}
+
+func TestMySQL_EditWhere(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+	ctx := context.Background()
+	filter := expr.Eq(user.FieldExpr("name"), expr.Value("Ivan"))
+
+	count, err := user.EditWhere(ctx, map[string]interface{}{"lastname": "Expired"}, filter, mysql.EditWhereOpts{
+		OrderBy: []mysql.OrderExpr{{Expr: user.FieldExpr("id"), Desc: true}},
+		Limit:   100,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), count)
+	assert.Equal(t, "UPDATE `user` SET `lastname`=? WHERE `name`=? ORDER BY `id` DESC LIMIT 100", lastFakeQuery())
+	assert.Equal(t, []driver.Value{"Expired", "Ivan"}, fakeExecValues)
+
+	// The empty filter must be allowed explicitly
+	_, err = user.EditWhere(ctx, map[string]interface{}{"lastname": "Expired"}, nil, mysql.EditWhereOpts{})
+	assert.True(t, errors.Is(err, mysql.ErrFullTableUpdate))
+	_, err = user.EditWhere(ctx, map[string]interface{}{"lastname": "Expired"}, nil, mysql.EditWhereOpts{AllowFullTableUpdate: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE `user` SET `lastname`=?", lastFakeQuery())
+
+	// The fake driver updates one row
+	assert.NoError(t, user.EditWhereExpect(ctx, map[string]interface{}{"lastname": "Expired"}, filter, 1, mysql.EditWhereOpts{}))
+	err = user.EditWhereExpect(ctx, map[string]interface{}{"lastname": "Expired"}, filter, 2, mysql.EditWhereOpts{})
+	assert.True(t, errors.Is(err, mysql.ErrUnexpectedRowCount))
+	var countErr *mysql.UnexpectedRowCountError
+	if assert.True(t, errors.As(err, &countErr)) {
+		assert.Equal(t, mysql.UnexpectedRowCountError{Expected: 2, Actual: 1}, *countErr)
+	}
+	assert.True(t, errors.Is(user.EditWhereExpect(ctx, map[string]interface{}{"lastname": "Expired"}, nil, 1, mysql.EditWhereOpts{}), mysql.ErrFullTableUpdate))
+}
+
+func TestMySQL_SelfJoin(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	user := test.NewUser(storage)
+
+	// The users with the same name, each pair is returned once
+	_, err := storage.Select(context.Background(), user, []string{"id", "name"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{
+			Filter:  expr.Eq(user.FieldExpr("lastname"), expr.Value("Ivanov")),
+			OrderBy: []model.Order{{FieldName: "id"}},
+		},
+		Alias: "u1",
+		Joins: []mysql.TableJoin{mysql.Join(mysql.As(user, "u2"), mysql.And(
+			expr.Eq(mysql.Lower(mysql.AliasField("u1", "name")), mysql.Lower(mysql.AliasField("u2", "name"))),
+			expr.Lt(user.FieldExpr("id"), mysql.AliasField("u2", "id")),
+		), mysql.InnerJoin, "id", "lastname")},
+		OrderBy: []mysql.OrderExpr{{Expr: mysql.AliasField("u2", "id")}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT `u1`.`id`,`u1`.`name`,`u2`.`id` AS `u2.id`,`u2`.`lastname` AS `u2.lastname` FROM `user` AS `u1` "+
+		"JOIN `user` AS `u2` ON (LOWER(`u1`.`name`)=LOWER(`u2`.`name`))AND(`u1`.`id`<`u2`.`id`) WHERE `u1`.`lastname`=? "+
+		"ORDER BY `u1`.`id`,`u2`.`id`", lastFakeQuery())
+
+	// The names shared by several users
+	_, err = storage.Select(context.Background(), user, []string{"name"}, mysql.SelectOptions{
+		Joins: []mysql.TableJoin{mysql.Join(mysql.As(user, "u2"), mysql.And(
+			expr.Eq(user.FieldExpr("name"), mysql.AliasField("u2", "name")),
+			expr.Ne(user.FieldExpr("id"), mysql.AliasField("u2", "id")),
+		), mysql.LeftJoin)},
+		GroupBy: []model.IExpression{user.FieldExpr("name")},
+		Having:  expr.Gt(mysql.Func("COUNT", mysql.AliasField("u2", "id")), expr.Value(0)),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "SELECT `user`.`name` FROM `user` LEFT JOIN `user` AS `u2` ON (`user`.`name`=`u2`.`name`)AND(`user`.`id`<>`u2`.`id`) "+
+		"GROUP BY `user`.`name` HAVING COUNT(`u2`.`id`)>?", lastFakeQuery())
+
+	for _, join := range []mysql.TableJoin{
+		mysql.Join(mysql.As(user, "user"), expr.Eq(user.FieldExpr("id"), mysql.AliasField("user", "id")), mysql.InnerJoin),
+		mysql.Join(mysql.As(user, "u2"), expr.Eq(user.FieldExpr("id"), mysql.AliasField("u3", "id")), mysql.InnerJoin),
+		mysql.Join(mysql.As(user, "u2"), expr.Eq(user.FieldExpr("id"), mysql.AliasField("u2", "fullname")), mysql.InnerJoin),
+		mysql.Join(mysql.As(user, "u2"), nil, mysql.InnerJoin),
+		mysql.Join(mysql.As(user, "u2"), expr.Eq(user.FieldExpr("id"), mysql.AliasField("u2", "id")), mysql.InnerJoin, "fullname"),
+	} {
+		_, err = storage.Select(context.Background(), user, []string{"id"}, mysql.SelectOptions{Joins: []mysql.TableJoin{join}})
+		assert.Error(t, err)
+	}
+}
+
+type accountCallbacks struct {
+	calls []string
+	veto  error
+	// editOnEdit makes AfterEditBatch to update the account again
+	editOnEdit *mysql.BaseModel
+}
+
+func (c *accountCallbacks) BeforeAdd(_ context.Context, row map[string]interface{}) error {
+	c.calls = append(c.calls, fmt.Sprintf("before add %v", row["login"]))
+	row["login"] = strings.ToLower(row["login"].(string))
+	row["status"] = "new"
+	return c.veto
+}
+
+func (c *accountCallbacks) AfterAdd(_ context.Context, key, row map[string]interface{}) error {
+	c.calls = append(c.calls, fmt.Sprintf("after add %v %v %v", key["id"], row["login"], row["status"]))
+	return nil
+}
+
+func (c *accountCallbacks) BeforeEdit(_ context.Context, newValues map[string]interface{}) error {
+	c.calls = append(c.calls, "before edit")
+	newValues["status"] = "edited"
+	return nil
+}
+
+func (c *accountCallbacks) AfterEditBatch(ctx context.Context, keys []map[string]interface{}, newValues map[string]interface{}) error {
+	c.calls = append(c.calls, fmt.Sprintf("after edit %d %v", len(keys), newValues["status"]))
+	if c.editOnEdit != nil {
+		return c.editOnEdit.EditByPK(ctx, keys[0]["id"], map[string]interface{}{"status": "again"})
+	}
+	return nil
+}
+
+func (c *accountCallbacks) AfterDelete(_ context.Context, key map[string]interface{}) error {
+	c.calls = append(c.calls, fmt.Sprintf("after delete %v", key["id"]))
+	return nil
+}
+
+type secondCallback struct{ calls *[]string }
+
+func (c secondCallback) BeforeAddBatch(_ context.Context, rows []map[string]interface{}) error {
+	*c.calls = append(*c.calls, fmt.Sprintf("before add batch %d %v", len(rows), rows[0]["login"]))
+	return nil
+}
+
+func TestMySQL_WriteCallbacks(t *testing.T) {
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	accounts := mysql.NewBaseModel(storage, "account", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true},
+		&mysql.VarCharField{Id: "login", Length: 32, NotNull: true},
+		&mysql.VarCharField{Id: "status", Length: 32},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	callbacks := &accountCallbacks{}
+	accounts.AddWriteCallback(callbacks)
+	accounts.AddWriteCallback(secondCallback{&callbacks.calls})
+	ctx := context.Background()
+
+	// The callbacks are called in the order of the addition, the values are changed by them
+	_, err := accounts.AddMulti(ctx, model.NewData([]string{"id", "login"}, [][]interface{}{{uint32(1), "Ivan"}}), model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT INTO `account`(`id`,`login`,`status`)VALUES(?,?,?)", lastFakeQuery())
+	assert.Equal(t, []driver.Value{int64(1), "ivan", "new"}, fakeExecValues)
+	assert.Equal(t, []string{"before add Ivan", "before add batch 1 ivan", "after add 1 ivan new"}, callbacks.calls)
+
+	// The veto rolls the insert back
+	callbacks.calls, callbacks.veto = nil, errors.New("veto")
+	_, err = accounts.AddMulti(ctx, model.NewData([]string{"id", "login"}, [][]interface{}{{uint32(2), "Petr"}}), model.AddOptions{})
+	assert.EqualError(t, err, "veto")
+	assert.Equal(t, []string{"before add Petr"}, callbacks.calls)
+	callbacks.veto = nil
+
+	// The keys of the rows are selected for the after callbacks, the new values of the caller are not changed
+	callbacks.calls = nil
+	newValues := map[string]interface{}{"login": "petr"}
+	assert.NoError(t, accounts.Edit(ctx, expr.Eq(accounts.FieldExpr("login"), expr.Value("Petr")), newValues))
+	assert.Equal(t, "UPDATE `account` SET `login`=?, `status`=? WHERE `login`=?", lastFakeQuery())
+	assert.Equal(t, map[string]interface{}{"login": "petr"}, newValues)
+	assert.Equal(t, []string{"before edit", fmt.Sprintf("after edit %d edited", fakeRowsCount)}, callbacks.calls)
+
+	callbacks.calls = nil
+	assert.NoError(t, accounts.DeleteByPK(ctx, 1))
+	assert.Equal(t, "DELETE FROM `account` WHERE `id` IN (?)", lastFakeQuery())
+	if assert.Len(t, callbacks.calls, fakeRowsCount) {
+		assert.Equal(t, "after delete 1", callbacks.calls[0])
+	}
+
+	// The model updated by its own callbacks
+	callbacks.calls, callbacks.editOnEdit = nil, accounts
+	err = accounts.EditByPK(ctx, 1, map[string]interface{}{"login": "ivan"})
+	assert.True(t, errors.Is(err, mysql.ErrCallbackRecursion))
+	assert.Len(t, callbacks.calls, 2*(mysql.DefaultMaxCallbackDepth+1))
+
+	storage.SetMaxCallbackDepth(1)
+	callbacks.calls = nil
+	assert.True(t, errors.Is(accounts.EditByPK(ctx, 1, map[string]interface{}{"login": "ivan"}), mysql.ErrCallbackRecursion))
+	assert.Len(t, callbacks.calls, 4)
+}
+
+func TestMySQL_Sharding(t *testing.T) {
+	storage := newFakeStorage(t)
+	defer storage.Disconnect()
+
+	var (
+		mtx     sync.Mutex
+		queries []string
+	)
+	storage.SetExecutorFactory(func(base mysql.Executor) mysql.Executor {
+		return recordingExecutor{base, &mtx, &queries}
+	})
+
+	event := mysql.NewBaseModel(storage, "event", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.VarCharField{Id: "name", Length: 255, NotNull: true},
+		&mysql.VarCharField{Id: "lastname", Length: 255, NotNull: true},
+	}, nil, mysql.BaseModelOpts{
+		BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}},
+		Shard:         mysql.ShardSpec{Count: 4, KeyField: "name"},
+	})
+	ctx := context.Background()
+
+	sqlBuf := mysql.NewSqlBuffer()
+	event.WriteCreateSQL(sqlBuf)
+	assert.Equal(t, 4, strings.Count(sqlBuf.GetSQL(), "CREATE TABLE"))
+	assert.Contains(t, sqlBuf.GetSQL(), "CREATE TABLE `event_03` (")
+
+	// The integer keys of the different types are in the same shard
+	shardA, err := event.ShardForKey("a")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, shardA)
+	shardB, _ := event.ShardForKey("b")
+	assert.Equal(t, 1, shardB)
+	shardInt, _ := event.ShardForKey(42)
+	shardUint, _ := event.ShardForKey(uint8(42))
+	assert.Equal(t, shardInt, shardUint)
+
+	// The rows are added to the shards of their keys and the primary keys are returned in the order of the rows
+	res, err := storage.Add(ctx, event, model.NewData([]string{"name", "lastname"}, [][]interface{}{
+		{"a", "Ivanov"}, {"b", "Petrov"}, {"a", "Sidorov"},
+	}), model.AddOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.Len())
+	assert.Equal(t, []string{
+		"INSERT INTO `event_00`(`name`,`lastname`)VALUES(?,?),(?,?)",
+		"INSERT INTO `event_01`(`name`,`lastname`)VALUES(?,?)",
+	}, queries)
+
+	// The key-scoped reads and writes are sent to the shard of the key
+	queries = nil
+	byName := expr.Eq(event.FieldExpr("name"), expr.Value("b"))
+	_, err = storage.Select(ctx, event, []string{"id", "name"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{Filter: byName}})
+	assert.NoError(t, err)
+	assert.NoError(t, storage.Edit(ctx, event, byName, map[string]interface{}{"lastname": "Smirnov"}))
+	assert.NoError(t, storage.Delete(ctx, event, byName))
+	assert.Equal(t, []string{
+		"SELECT `id`,`name` FROM `event_01` WHERE `name`=?",
+		"UPDATE `event_01` SET `lastname`=? WHERE `name`=?",
+		"DELETE FROM `event_01` WHERE `name`=?",
+	}, queries)
+
+	// The rows cannot be moved to the other shard
+	assert.Error(t, storage.Edit(ctx, event, byName, map[string]interface{}{"name": "a"}))
+
+	// The unscoped reads are merged from all the shards, every shard returns the same fake rows
+	queries = nil
+	data, err := storage.Select(ctx, event, []string{"id", "name"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		OrderBy: []model.Order{{FieldName: "id"}},
+		Limit:   3,
+		Offset:  3,
+	}})
+	assert.NoError(t, err)
+	if assert.Equal(t, 3, data.Len()) {
+		assert.Equal(t, []interface{}{uint32(1), uint32(2), uint32(2)}, []interface{}{data.Data()[0][0], data.Data()[1][0], data.Data()[2][0]})
+	}
+	assert.Equal(t, []string{
+		"SELECT `id`,`name` FROM `event_00` ORDER BY `id` LIMIT 6",
+		"SELECT `id`,`name` FROM `event_01` ORDER BY `id` LIMIT 6",
+		"SELECT `id`,`name` FROM `event_02` ORDER BY `id` LIMIT 6",
+		"SELECT `id`,`name` FROM `event_03` ORDER BY `id` LIMIT 6",
+	}, queries)
+	_, err = storage.Select(ctx, event, []string{"name"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		OrderBy: []model.Order{{FieldName: "id"}},
+	}})
+	assert.Error(t, err)
+
+	// The transaction is bound to the first shard
+	atomic.StoreInt32(&fakeTransactions, 1)
+	defer atomic.StoreInt32(&fakeTransactions, 0)
+
+	err = storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		if _, err := storage.Add(ctx, event, model.NewData([]string{"name", "lastname"}, [][]interface{}{{"a", "Ivanov"}}), model.AddOptions{}); err != nil {
+			return err
+		}
+		return storage.Edit(ctx, event, byName, map[string]interface{}{"lastname": "Smirnov"})
+	})
+	assert.True(t, errors.Is(err, mysql.ErrCrossShard))
+	var shardErr *mysql.CrossShardError
+	if assert.True(t, errors.As(err, &shardErr)) {
+		assert.Equal(t, mysql.CrossShardError{Model: "event", Shard: 1, TxShard: 0}, *shardErr)
+	}
+
+	err = storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		_, err := storage.Select(ctx, event, []string{"id"}, mysql.SelectOptions{})
+		return err
+	})
+	assert.True(t, errors.Is(err, mysql.ErrCrossShard))
+}
+
+func TestFormatLiteral(t *testing.T) {
+	loc := time.FixedZone("UTC+3", 3*60*60)
+	moment := time.Date(2024, 3, 1, 21, 30, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		value interface{}
+		opts  mysql.LiteralOpts
+		res   string
+	}{
+		{nil, mysql.LiteralOpts{}, "NULL"},
+		{(*string)(nil), mysql.LiteralOpts{}, "NULL"},
+		{uint64(math.MaxUint64), mysql.LiteralOpts{}, "18446744073709551615"},
+		{-7, mysql.LiteralOpts{}, "-7"},
+		{1.5, mysql.LiteralOpts{}, "1.5"},
+		{true, mysql.LiteralOpts{}, "1"},
+		{[]byte("a'\x00"), mysql.LiteralOpts{}, "X'612700'"},
+		{"a'b\\c\x00", mysql.LiteralOpts{}, `'a\'b\\c\0'`},
+		{"a'b\\c", mysql.LiteralOpts{NoBackslashEscapes: true}, `'a''b\c'`},
+		{moment, mysql.LiteralOpts{Location: loc}, "'2024-03-02 00:30:00'"},
+		{moment, mysql.LiteralOpts{Field: &mysql.DateField{Id: "day"}}, "'2024-03-01'"},
+	} {
+		res, err := mysql.FormatLiteral(tc.value, tc.opts)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.res, res)
+	}
+
+	_, err := mysql.FormatLiteral(math.NaN(), mysql.LiteralOpts{})
+	assert.Error(t, err)
+	_, err = mysql.FormatLiteral(struct{}{}, mysql.LiteralOpts{})
+	assert.Error(t, err)
+
+	// The sql_mode of the DSN is respected
+	driverName := mysql.SqlDriver
+	mysql.SqlDriver = "mysql_fake"
+	defer func() { mysql.SqlDriver = driverName }()
+	storage := mysql.NewMySQL()
+	if assert.NoError(t, storage.Connect("user:pass@tcp(127.0.0.1:1)/test?sql_mode=ANSI_QUOTES,NO_BACKSLASH_ESCAPES")) {
+		res, err := storage.Literal(nil, `it's\`)
+		assert.NoError(t, err)
+		assert.Equal(t, `'it''s\'`, res)
+	}
+}
+
+// TestFormatLiteral_Injection checks with the random strings that the string literal is read by the server
+// as the value and ends where the literal ends
+func TestFormatLiteral_Injection(t *testing.T) {
+	alphabet := []string{"'", "\\", `"`, "\x00", "\n", "\r", "\x1a", "%", "_", "`", ";", "-", " ", "a", "Ω", "\xff"}
+	seeds := []string{"", "'", "\\", "\\'", "'' OR 1=1 -- ", "\\' OR 1=1 -- ", "\x00'", "a\\\\'b", "\\\x00"}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		value := ""
+		if i < len(seeds) {
+			value = seeds[i]
+		} else {
+			for n := rnd.Intn(16); n > 0; n-- {
+				value += alphabet[rnd.Intn(len(alphabet))]
+			}
+		}
+
+		for _, noBackslashEscapes := range []bool{false, true} {
+			literal, err := mysql.FormatLiteral(value, mysql.LiteralOpts{NoBackslashEscapes: noBackslashEscapes})
+			if !assert.NoError(t, err) {
+				return
+			}
+			read, n := readStringLiteral(literal+" OR 1=1", noBackslashEscapes)
+			if n != len(literal) || read != value {
+				t.Fatalf("the literal %q of %q is read as %q of %d bytes", literal, value, read, n)
+			}
+		}
+	}
+}
+
+// readStringLiteral reads the single-quoted string literal at the start of the SQL as the server does
+// and returns its value and length, the length is 0 if the literal is not closed
+func readStringLiteral(sql string, noBackslashEscapes bool) (string, int) {
+	if sql == "" || sql[0] != '\'' {
+		return "", 0
+	}
+
+	var res strings.Builder
+	for i := 1; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == '\\' && !noBackslashEscapes && i+1 < len(sql):
+			i++
+			switch sql[i] {
+			case '0':
+				res.WriteByte(0)
+			case 'n':
+				res.WriteByte('\n')
+			case 'r':
+				res.WriteByte('\r')
+			case 't':
+				res.WriteByte('\t')
+			case 'b':
+				res.WriteByte('\b')
+			case 'Z':
+				res.WriteByte('\x1a')
+			case '%', '_':
+				res.WriteByte('\\')
+				res.WriteByte(sql[i])
+			default:
+				res.WriteByte(sql[i])
+			}
+		case c == '\'' && i+1 < len(sql) && sql[i+1] == '\'':
+			res.WriteByte('\'')
+			i++
+		case c == '\'':
+			return res.String(), i + 1
+		default:
+			res.WriteByte(c)
+		}
+	}
+
+	return "", 0
 }
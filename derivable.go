@@ -0,0 +1,162 @@
+package mysql
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+	"github.com/go-qbit/rbac"
+)
+
+// derivableChunkSize is the number of the streamed rows the batch derivable fields are computed for at once
+const derivableChunkSize = 1000
+
+// BatchDerivable is the derivable field computing the values of many rows at once, e.g. with one lookup.
+// The read paths call CalcBatch once per result set or per chunk of the streamed rows, Calc is called
+// for the other fields.
+type BatchDerivable interface {
+	model.IFieldDefinition
+	// CalcBatch returns the values of the rows in their order
+	CalcBatch(ctx context.Context, rows []map[string]interface{}) ([]interface{}, error)
+}
+
+// BatchDerivableField is model.DerivableField computed by GetBatch for all the rows of the result set
+type BatchDerivableField struct {
+	Id             string
+	Caption        string
+	ViewPermission *rbac.Permission
+	DependsOn      []string
+	GetBatch       func(ctx context.Context, rows []map[string]interface{}) ([]interface{}, error)
+}
+
+// BatchOf adapts the per row function of model.DerivableField to BatchDerivableField.GetBatch
+func BatchOf(get func(ctx context.Context, row map[string]interface{}) (interface{}, error)) func(ctx context.Context, rows []map[string]interface{}) ([]interface{}, error) {
+	return func(ctx context.Context, rows []map[string]interface{}) ([]interface{}, error) {
+		res := make([]interface{}, len(rows))
+		for i, row := range rows {
+			var err error
+			if res[i], err = get(ctx, row); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+	}
+}
+
+// batchValue is the value computed by CalcBatch before model.BaseModel calls Calc for the row
+type batchValue struct{ value interface{} }
+
+func (f *BatchDerivableField) GetId() string                       { return f.Id }
+func (f *BatchDerivableField) GetCaption() string                  { return f.Caption }
+func (f *BatchDerivableField) GetType() reflect.Type               { var v interface{}; return reflect.TypeOf(v) }
+func (f *BatchDerivableField) GetStorageType() string              { return "interface{}" }
+func (f *BatchDerivableField) IsRequired() bool                    { return false }
+func (f *BatchDerivableField) GetViewPermission() *rbac.Permission { return f.ViewPermission }
+func (f *BatchDerivableField) GetEditPermission() *rbac.Permission { return nil }
+func (f *BatchDerivableField) IsDerivable() bool                   { return true }
+func (f *BatchDerivableField) GetDependsOn() []string              { return f.DependsOn }
+func (f *BatchDerivableField) Calc(ctx context.Context, row map[string]interface{}) (interface{}, error) {
+	if v, ok := row[f.Id].(batchValue); ok {
+		return v.value, nil
+	}
+
+	res, err := f.CalcBatch(ctx, []map[string]interface{}{row})
+	if err != nil {
+		return nil, err
+	}
+
+	return res[0], nil
+}
+func (f *BatchDerivableField) CalcBatch(ctx context.Context, rows []map[string]interface{}) ([]interface{}, error) {
+	res, err := f.GetBatch(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(res) != len(rows) {
+		return nil, qerror.Errorf("The derivable field '%s' returned %d values for %d rows", f.Id, len(res), len(rows))
+	}
+
+	return res, nil
+}
+func (f *BatchDerivableField) Check(ctx context.Context, v interface{}) error { return nil }
+func (f *BatchDerivableField) Clean(ctx context.Context, v interface{}) (interface{}, error) {
+	return v, nil
+}
+func (f *BatchDerivableField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
+	panic("Derivable field cannot be FK")
+}
+
+// calcDerivable returns the values of the derivable field for n rows filled with the dependencies by fill,
+// CalcBatch is preferred to Calc. The row map is reused by the per row Calc.
+func calcDerivable(ctx context.Context, field model.IFieldDefinition, n int, fill func(i int, row map[string]interface{})) ([]interface{}, error) {
+	if n == 0 {
+		return nil, nil
+	}
+
+	if batch, ok := field.(BatchDerivable); ok {
+		rows := make([]map[string]interface{}, n)
+		for i := range rows {
+			rows[i] = make(map[string]interface{}, len(field.GetDependsOn()))
+			fill(i, rows[i])
+		}
+
+		return batch.CalcBatch(ctx, rows)
+	}
+
+	res := make([]interface{}, n)
+	row := make(map[string]interface{}, len(field.GetDependsOn()))
+	for i := range res {
+		fill(i, row)
+
+		var err error
+		if res[i], err = field.Calc(ctx, row); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// prepareBatchDerivable returns the PrepareDerivableFieldsCtx of model.BaseModel which computes the requested
+// batch derivable fields for all the rows before the per row Calc, it is prepare if the model has no such fields
+func prepareBatchDerivable(derivableFields []model.IFieldDefinition, prepare model.PrepareDerivableFieldsCtxFunc) model.PrepareDerivableFieldsCtxFunc {
+	var batchFields []BatchDerivable
+	for _, field := range derivableFields {
+		if batch, ok := field.(BatchDerivable); ok {
+			batchFields = append(batchFields, batch)
+		}
+	}
+	if len(batchFields) == 0 {
+		return prepare
+	}
+
+	return func(ctx context.Context, m model.IModel, requestedFields map[string]struct{}, rows []map[string]interface{}) error {
+		if prepare != nil {
+			if err := prepare(ctx, m, requestedFields, rows); err != nil {
+				return err
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		for _, field := range batchFields {
+			if _, requested := requestedFields[field.GetId()]; !requested {
+				continue
+			}
+
+			values, err := field.CalcBatch(ctx, rows)
+			if err != nil {
+				return err
+			}
+			// Calc returns the value and model.BaseModel replaces it in the row
+			for i, row := range rows {
+				row[field.GetId()] = batchValue{values[i]}
+			}
+		}
+
+		return nil
+	}
+}
@@ -0,0 +1,214 @@
+package mysql_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// fakeDriver returns fakeRowsCount rows of the user model for any query and records the executed statements,
+// the first fakeFailures statements fail with the invalid connection error. The rows of the SELECT of the fields
+// of the user model have the selected columns, the rows of the other queries have all the fields.
+type fakeDriver struct{}
+
+type fakeConn struct{}
+
+type fakeStmt struct{ query string }
+
+type fakeTx struct{}
+
+// fakeResult is the result of a single affected row with the last insert id
+type fakeResult int64
+
+type fakeRows struct {
+	columns []string
+	pos     int
+}
+
+const fakeRowsCount = 10000
+
+var (
+	fakeFailures int32
+	// fakeTransactions makes Begin to succeed, otherwise the transactions are not supported
+	fakeTransactions int32
+	fakeExecMtx      sync.Mutex
+	// fakeExecArgs are the numbers of the arguments of the executed statements
+	fakeExecArgs []int
+	// fakeExecValues are the arguments of the last executed statement
+	fakeExecValues []driver.Value
+	// fakeQueryValues are the arguments of the last query
+	fakeQueryValues []driver.Value
+	// fakeLastQuery is the last statement sent to the driver
+	fakeLastQuery string
+	// fakeExecErr is returned by the next fakeExecErrs executed statements
+	fakeExecErr  error
+	fakeExecErrs int
+	// fakeLastDSN is the DSN of the last opened connection, the empty one if no connections are opened
+	fakeLastDSN string
+	// fakeOpens is the number of the opened connections
+	fakeOpens int
+	// fakeRowValues replace the name and lastname values of the rows if set, the rows are cycled through
+	fakeRowValues [][]driver.Value
+	// fakeLastInsertId is the last insert id of the executed statements
+	fakeLastInsertId int64
+)
+
+func init() {
+	sql.Register("mysql_fake", fakeDriver{})
+}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	fakeExecMtx.Lock()
+	defer fakeExecMtx.Unlock()
+	fakeLastDSN = dsn
+	fakeOpens++
+
+	return fakeConn{}, nil
+}
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) {
+	fakeExecMtx.Lock()
+	defer fakeExecMtx.Unlock()
+	fakeLastQuery = query
+
+	return fakeStmt{query}, nil
+}
+
+func (fakeConn) Close() error { return nil }
+
+// CheckNamedValue passes uint64 values as is like the MySQL driver, the default converter rejects the ones
+// above math.MaxInt64
+func (fakeConn) CheckNamedValue(nv *driver.NamedValue) error {
+	switch v := nv.Value.(type) {
+	case uint64:
+		return nil
+	case *uint64:
+		if v != nil {
+			nv.Value = *v
+			return nil
+		}
+	}
+
+	return driver.ErrSkip
+}
+func (fakeConn) Begin() (driver.Tx, error) {
+	if atomic.LoadInt32(&fakeTransactions) == 0 {
+		return nil, driver.ErrSkip
+	}
+
+	return fakeTx{}, nil
+}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+func (fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if atomic.AddInt32(&fakeFailures, -1) >= 0 {
+		return nil, mysqldriver.ErrInvalidConn
+	}
+
+	fakeExecMtx.Lock()
+	defer fakeExecMtx.Unlock()
+	if fakeExecErrs > 0 {
+		fakeExecErrs--
+		return nil, fakeExecErr
+	}
+	fakeExecArgs = append(fakeExecArgs, len(args))
+	fakeExecValues = args
+
+	return fakeResult(fakeLastInsertId), nil
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if atomic.AddInt32(&fakeFailures, -1) >= 0 {
+		return nil, mysqldriver.ErrInvalidConn
+	}
+
+	fakeExecMtx.Lock()
+	fakeQueryValues = args
+	fakeExecMtx.Unlock()
+
+	return &fakeRows{columns: fakeColumns(s.query)}, nil
+}
+
+// fakeColumns returns the columns of the SELECT of the fields of the user model, all the fields for the other
+// queries
+func fakeColumns(query string) []string {
+	all := []string{"id", "name", "lastname"}
+	if query == "SELECT CONNECTION_ID()" {
+		return []string{"id"}
+	}
+	if !strings.HasPrefix(query, "SELECT `") {
+		return all
+	}
+
+	list := query[len("SELECT "):]
+	depth, end := 0, -1
+	for i := 0; i < len(list) && end < 0; i++ {
+		switch list[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ' ':
+			if depth == 0 && strings.HasPrefix(list[i:], " FROM ") {
+				end = i
+			}
+		}
+	}
+	if end < 0 {
+		return all
+	}
+
+	var res []string
+	for _, column := range strings.Split(list[:end], ",") {
+		if pos := strings.LastIndex(column, " AS "); pos >= 0 {
+			column = column[pos+len(" AS "):]
+		} else if pos := strings.LastIndex(column, "."); pos >= 0 {
+			column = column[pos+1:]
+		}
+		name := strings.Trim(column, "`")
+		if name != "id" && name != "name" && name != "lastname" {
+			return all
+		}
+		res = append(res, name)
+	}
+
+	return res
+}
+
+func (r fakeResult) LastInsertId() (int64, error) { return int64(r), nil }
+func (fakeResult) RowsAffected() (int64, error)   { return 1, nil }
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos == fakeRowsCount {
+		return io.EOF
+	}
+	r.pos++
+
+	values := []driver.Value{[]byte(strconv.Itoa(r.pos)), []byte("Name"), []byte("Lastname")}
+	if fakeRowValues != nil {
+		copy(values[1:], fakeRowValues[(r.pos-1)%len(fakeRowValues)])
+	}
+	for i, column := range r.columns {
+		switch column {
+		case "id":
+			dest[i] = values[0]
+		case "name":
+			dest[i] = values[1]
+		case "lastname":
+			dest[i] = values[2]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package mysql
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-qbit/model"
+)
+
+const ctx_dry_run_key = "MYSQL_DRY_RUN"
+
+// DryRunStatement is the recorded statement, InsertIds are the synthetic ids of the auto-incremented
+// primary key of the rows inserted by the statement
+type DryRunStatement struct {
+	SQL       string
+	Args      []interface{}
+	InsertIds []int64
+}
+
+// DryRun records the statements of the dry run context
+type DryRun struct {
+	mtx        sync.Mutex
+	statements []DryRunStatement
+	lastId     int64
+	loc        *time.Location
+}
+
+// DryRun returns the context in which the statements passed to Exec (the inserts, the updates, the deletes and DDL)
+// are recorded instead of being executed while the reads are executed as usual. The inserted rows get the negative
+// synthetic ids of the auto-incremented primary keys. The transactions are started to keep the reads consistent,
+// their commits are recorded and the transactions are rolled back instead.
+func (s *MySQL) DryRun(ctx context.Context) (context.Context, *DryRun) {
+	d := &DryRun{loc: s.loc}
+
+	return context.WithValue(ctx, ctx_dry_run_key, d), d
+}
+
+func dryRunOf(ctx context.Context) *DryRun {
+	d, _ := ctx.Value(ctx_dry_run_key).(*DryRun)
+
+	return d
+}
+
+// Statements returns the recorded statements in the order of the execution
+func (d *DryRun) Statements() []DryRunStatement {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	return append([]DryRunStatement(nil), d.statements...)
+}
+
+// Script returns the recorded statements with the interpolated arguments, see InterpolateSQL
+func (d *DryRun) Script() string {
+	buf := &strings.Builder{}
+	for _, statement := range d.Statements() {
+		buf.WriteString(InterpolateSQL(statement.SQL, statement.Args, d.loc))
+		buf.WriteString(";\n")
+	}
+
+	return buf.String()
+}
+
+func (d *DryRun) record(query string, args []interface{}) *dryRunResult {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.statements = append(d.statements, DryRunStatement{SQL: query, Args: args})
+
+	return &dryRunResult{d: d, pos: len(d.statements) - 1}
+}
+
+// dryRunResult is the result of the recorded statement, no rows are affected
+type dryRunResult struct {
+	d   *DryRun
+	pos int
+}
+
+func (r *dryRunResult) LastInsertId() (int64, error) { return 0, nil }
+func (r *dryRunResult) RowsAffected() (int64, error) { return 0, nil }
+
+// reserveIds returns the first of the count synthetic ids of the rows inserted by the statement,
+// the ids are negative and unique in the dry run
+func (r *dryRunResult) reserveIds(count int) int64 {
+	r.d.mtx.Lock()
+	defer r.d.mtx.Unlock()
+
+	r.d.lastId -= int64(count)
+	statement := &r.d.statements[r.pos]
+	for i := 0; i < count; i++ {
+		statement.InsertIds = append(statement.InsertIds, r.d.lastId+int64(i))
+	}
+
+	return r.d.lastId
+}
+
+func hasAutoIncrementedPK(m model.IModel) bool {
+	for _, name := range m.GetPKFieldsNames() {
+		if field, ok := m.GetFieldDefinition(name).(IMysqlFieldDefinition); ok && field.IsAutoIncremented() {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-qbit/model"
+)
+
+// ErrFullTableUpdate is returned by EditWhere and EditWhereExpect for the nil filter without AllowFullTableUpdate
+var ErrFullTableUpdate = errors.New("full table update")
+
+// ErrUnexpectedRowCount is matched by the errors of EditWhereExpect updating the unexpected number of the rows,
+// see UnexpectedRowCountError
+var ErrUnexpectedRowCount = errors.New("unexpected row count")
+
+// UnexpectedRowCountError is the error of EditWhereExpect, Actual rows are updated instead of Expected ones
+type UnexpectedRowCountError struct {
+	Expected uint64
+	Actual   uint64
+}
+
+func (e *UnexpectedRowCountError) Error() string {
+	return fmt.Sprintf("%s: %d rows are updated, %d are expected", ErrUnexpectedRowCount, e.Actual, e.Expected)
+}
+
+func (e *UnexpectedRowCountError) Is(target error) bool { return target == ErrUnexpectedRowCount }
+
+// EditWhereOpts are the options of EditWhere and EditWhereExpect
+type EditWhereOpts struct {
+	// OrderBy and Limit bound the rows updated by the statement, e.g. to roll the change out by the chunks.
+	// The rows are not limited if Limit is zero.
+	OrderBy []OrderExpr
+	Limit   uint64
+	// AllowFullTableUpdate allows the nil filter updating all the rows of the table
+	AllowFullTableUpdate bool
+}
+
+// EditWhere sets the new values of the rows matching the filter with one UPDATE statement and returns the number
+// of the updated rows, the new values may be expressions as in Edit. The server does not count the matched rows
+// the values of which are not changed unless the DSN sets clientFoundRows. The limited rows of the models
+// the changes of which are captured or audited are locked and updated by their primary keys.
+func (s *MySQL) EditWhere(ctx context.Context, m model.IModel, newValues map[string]interface{}, filter model.IExpression, opts EditWhereOpts) (uint64, error) {
+	if filter == nil && !opts.AllowFullTableUpdate {
+		return 0, fmt.Errorf("%w: the filter of model '%s' is empty", ErrFullTableUpdate, m.GetId())
+	}
+
+	res, err := s.execEdit(ctx, m, filter, newValues, opts)
+	if err != nil {
+		return 0, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return uint64(count), nil
+}
+
+// EditWhereExpect is EditWhere which updates the rows in the transaction, or the savepoint of the transaction
+// of the context, and rolls it back with UnexpectedRowCountError if the number of the updated rows is not
+// expectedCount, e.g. to move the batch of the rows to the next state only if none of them is moved by another
+// process. The count is not checked in the dry run.
+func (s *MySQL) EditWhereExpect(ctx context.Context, m model.IModel, newValues map[string]interface{}, filter model.IExpression, expectedCount uint64, opts EditWhereOpts) error {
+	return s.DoInTransaction(ctx, func(ctx context.Context) error {
+		count, err := s.EditWhere(ctx, m, newValues, filter, opts)
+		if err != nil {
+			return err
+		}
+		if count != expectedCount && dryRunOf(ctx) == nil {
+			return &UnexpectedRowCountError{Expected: expectedCount, Actual: count}
+		}
+
+		return nil
+	})
+}
+
+// writeEditLimit writes the ORDER BY and the LIMIT of the UPDATE statement
+func (s *MySQL) writeEditLimit(sqlBuf *SqlBuffer, m model.IModel, opts EditWhereOpts) {
+	if len(opts.OrderBy) > 0 {
+		p := s.exprProcessor.ForModel(m).inPart(PartOrder)
+		sqlBuf.WriteString(" ORDER BY ")
+		for i, order := range opts.OrderBy {
+			if i > 0 {
+				sqlBuf.WriteString(",")
+			}
+			writeOrder(sqlBuf, p, order)
+		}
+	}
+
+	if opts.Limit > 0 {
+		sqlBuf.WriteString(" LIMIT ")
+		sqlBuf.WriteLiteral(opts.Limit, LiteralOpts{})
+	}
+}
+
+// limitedPKsFilter locks the rows of the limited update and returns the filter of their primary keys,
+// nil if there are no rows
+func (s *MySQL) limitedPKsFilter(ctx context.Context, m model.IModel, filter model.IExpression, opts EditWhereOpts) (model.IExpression, error) {
+	pks, err := s.Select(ctx, m, m.GetPKFieldsNames(), SelectOptions{
+		GetAllOptions: model.GetAllOptions{Filter: filter, Limit: opts.Limit, ForUpdate: true},
+		OrderBy:       opts.OrderBy,
+	})
+	if err != nil || pks.Len() == 0 {
+		return nil, err
+	}
+
+	keys := make([]interface{}, pks.Len())
+	for i, row := range pks.Data() {
+		keys[i] = row
+	}
+
+	return PKsFilter(m, keys)
+}
+
+func (m *BaseModel) EditWhere(ctx context.Context, newValues map[string]interface{}, filter model.IExpression, opts EditWhereOpts) (uint64, error) {
+	return m.db.EditWhere(ctx, m, newValues, filter, opts)
+}
+
+func (m *BaseModel) EditWhereExpect(ctx context.Context, newValues map[string]interface{}, filter model.IExpression, expectedCount uint64, opts EditWhereOpts) error {
+	return m.db.EditWhereExpect(ctx, m, newValues, filter, expectedCount, opts)
+}
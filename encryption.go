@@ -0,0 +1,376 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// ErrEncryptedField is matched by the errors of the expressions over the encrypted fields, only Eq, Ne and In
+// by the fields with the blind index are supported
+var ErrEncryptedField = errors.New("encrypted field")
+
+// Cipher encrypts the values of the encrypted fields. The id of the key the value is encrypted with is stored
+// in the header of the value, so the values encrypted with the previous keys are decrypted after the rotation.
+type Cipher interface {
+	// KeyId is the id of the current key the values are encrypted with
+	KeyId() uint16
+	Encrypt(data []byte) ([]byte, error)
+	Decrypt(keyId uint16, data []byte) ([]byte, error)
+	// Overhead is the max difference of the lengths of the encrypted and the plain data
+	Overhead() int
+}
+
+// Encryption is the Encrypted option of VarCharField, TextField and BlobField. The values are encrypted after
+// the compression and stored with the header in VARBINARY of the adequate length for VARCHAR and MEDIUMBLOB
+// for the others, the columns have no defaults then. The values without the header are read as is, so the column
+// can contain the rows written before the encryption is enabled, see ReencryptField. The expressions over
+// the encrypted fields fail with ErrEncryptedField except for Eq, Ne and In by the fields with the blind index.
+type Encryption struct {
+	Cipher Cipher
+	// BlindIndex is the VARBINARY(32) field storing the HMAC-SHA256 of the values with BlindIndexKey, it is set
+	// by the writes of the encrypted field and compared by Eq, Ne and In instead of it. NewBaseModel adds the field
+	// if the model does not define it.
+	BlindIndex    string
+	BlindIndexKey []byte
+}
+
+// encryptedMagic starts the header of the encrypted fields values, the key id follows it
+var encryptedMagic = []byte{0, 'Q', 'E'}
+
+const encryptedHeaderLength = 5
+
+// BlindIndexLength is the length of the values of the blind index fields
+const BlindIndexLength = sha256.Size
+
+func (e *Encryption) storageType(mysqlType string, length int) string {
+	if mysqlType != "VARCHAR" {
+		return "MEDIUMBLOB"
+	}
+
+	// The chars take up to 4 bytes
+	return "VARBINARY(" + strconv.Itoa(encryptedHeaderLength+e.Cipher.Overhead()+4*length) + ")"
+}
+
+func (e *Encryption) encodeValue(v interface{}) (interface{}, error) {
+	data, isNull, err := valueBytes(v)
+	if err != nil || isNull {
+		return nil, err
+	}
+
+	return e.encrypt(data)
+}
+
+func (e *Encryption) encrypt(data []byte) ([]byte, error) {
+	encrypted, err := e.Cipher.Encrypt(data)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]byte, encryptedHeaderLength, encryptedHeaderLength+len(encrypted))
+	copy(res, encryptedMagic)
+	binary.BigEndian.PutUint16(res[len(encryptedMagic):], e.Cipher.KeyId())
+
+	return append(res, encrypted...), nil
+}
+
+func (e *Encryption) decodeValue(v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		data, err := e.decrypt([]byte(val))
+		return string(data), err
+	case *string:
+		if val == nil {
+			return val, nil
+		}
+		data, err := e.decrypt([]byte(*val))
+		res := string(data)
+		return &res, err
+	case []byte:
+		return e.decrypt(val)
+	case *[]byte:
+		if val == nil {
+			return val, nil
+		}
+		data, err := e.decrypt(*val)
+		return &data, err
+	}
+
+	return v, nil
+}
+
+func isEncrypted(data []byte) bool {
+	return len(data) >= encryptedHeaderLength && bytes.Equal(data[:len(encryptedMagic)], encryptedMagic)
+}
+
+func (e *Encryption) decrypt(data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+
+	return e.Cipher.Decrypt(binary.BigEndian.Uint16(data[len(encryptedMagic):]), data[encryptedHeaderLength:])
+}
+
+// blindIndexValue returns the HMAC of the value, nil for NULL
+func (e *Encryption) blindIndexValue(v interface{}) (interface{}, error) {
+	data, isNull, err := valueBytes(v)
+	if err != nil || isNull {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, e.BlindIndexKey)
+	mac.Write(data)
+
+	return mac.Sum(nil), nil
+}
+
+func valueBytes(v interface{}) ([]byte, bool, error) {
+	switch val := v.(type) {
+	case nil:
+		return nil, true, nil
+	case string:
+		return []byte(val), false, nil
+	case *string:
+		if val == nil {
+			return nil, true, nil
+		}
+		return []byte(*val), false, nil
+	case []byte:
+		return val, false, nil
+	case *[]byte:
+		if val == nil {
+			return nil, true, nil
+		}
+		return *val, false, nil
+	}
+
+	return nil, false, qerror.Errorf("Cannot encrypt the value of type %T", v)
+}
+
+// encryptionOf returns the encryption of the field, nil if the field is not encrypted
+func encryptionOf(field model.IFieldDefinition) *Encryption {
+	switch f := field.(type) {
+	case *VarCharField:
+		return f.Encrypted
+	case *TextField:
+		return f.Encrypted
+	case *BlobField:
+		return f.Encrypted
+	}
+
+	return nil
+}
+
+// withBlindIndexes adds the blind index fields the model does not define
+func withBlindIndexes(dbFields []IMysqlFieldDefinition) []IMysqlFieldDefinition {
+	defined := make(map[string]bool, len(dbFields))
+	for _, field := range dbFields {
+		defined[field.GetId()] = true
+	}
+
+	res := dbFields
+	for _, field := range dbFields {
+		if e := encryptionOf(field); e != nil && e.BlindIndex != "" && !defined[e.BlindIndex] {
+			defined[e.BlindIndex] = true
+			res = append(res[:len(res):len(res)], &VarBinaryField{
+				Id:      e.BlindIndex,
+				Caption: "Blind index of " + field.GetCaption(),
+				Length:  BlindIndexLength,
+			})
+		}
+	}
+
+	return res
+}
+
+// blindIndexes returns the values of the blind indexes of the encrypted fields with the values
+func blindIndexes(m model.IModel, values map[string]interface{}) (map[string]interface{}, error) {
+	var res map[string]interface{}
+	for name, value := range values {
+		e := encryptionOf(m.GetFieldDefinition(name))
+		if e == nil || e.BlindIndex == "" {
+			continue
+		}
+		if _, ok := value.(model.IExpression); ok {
+			return nil, fmt.Errorf("%w: the field '%s' of model '%s' with the blind index cannot be set to an expression",
+				ErrEncryptedField, name, m.GetId())
+		}
+
+		hash, err := e.blindIndexValue(value)
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			res = make(map[string]interface{})
+		}
+		res[e.BlindIndex] = hash
+	}
+
+	return res, nil
+}
+
+// blindIndexData returns the rows with the values of the blind indexes of the encrypted fields
+func blindIndexData(m model.IModel, data *model.Data) (*model.Data, error) {
+	var indexed, indexes []string
+	for _, name := range data.Fields() {
+		if e := encryptionOf(m.GetFieldDefinition(name)); e != nil && e.BlindIndex != "" {
+			indexed, indexes = append(indexed, name), append(indexes, e.BlindIndex)
+		}
+	}
+	if len(indexed) == 0 {
+		return data, nil
+	}
+
+	fieldsNames := data.Fields()
+	for _, name := range indexes {
+		fieldsNames = appendMissing(fieldsNames[:len(fieldsNames):len(fieldsNames)], name)
+	}
+
+	rows := make([][]interface{}, data.Len())
+	for i, row := range data.Data() {
+		rows[i] = append(row[:len(row):len(row)], make([]interface{}, len(fieldsNames)-len(row))...)
+		for j, name := range indexed {
+			hash, err := encryptionOf(m.GetFieldDefinition(name)).blindIndexValue(row[data.FieldNum(name)])
+			if err != nil {
+				return nil, err
+			}
+			rows[i][indexOf(fieldsNames, indexes[j])] = hash
+		}
+	}
+
+	return model.NewData(fieldsNames, rows), nil
+}
+
+// encryptedFieldError returns the error of the expression over the encrypted field
+func encryptedFieldError(m model.IModel, fieldName string) error {
+	return fmt.Errorf("%w: the field '%s' of model '%s' is encrypted, only Eq, Ne and In by the field with the blind index are supported",
+		ErrEncryptedField, fieldName, m.GetId())
+}
+
+// blindIndexOperand returns the encryption of the encrypted field with the blind index and the field
+// expression info, nil if the operand is not such a field
+func blindIndexOperand(op model.IExpression) (*Encryption, *exprInfo) {
+	info := inspectExpr(op)
+	if !info.isField || info.model == nil {
+		return nil, info
+	}
+	if e := encryptionOf(info.model.GetFieldDefinition(info.fieldName)); e != nil && e.BlindIndex != "" {
+		return e, info
+	}
+
+	return nil, info
+}
+
+// AESGCMCipher is the AES-GCM cipher, the random nonce is stored before the ciphertext
+type AESGCMCipher struct {
+	keyId uint16
+	aeads map[uint16]cipher.AEAD
+}
+
+// NewAESGCMCipher returns the cipher encrypting with the key keyId, the other keys decrypt the values encrypted
+// with them before the rotation. The keys must be 16, 24 or 32 bytes long.
+func NewAESGCMCipher(keyId uint16, keys map[uint16][]byte) (*AESGCMCipher, error) {
+	if _, exists := keys[keyId]; !exists {
+		return nil, qerror.Errorf("Unknown encryption key %d", keyId)
+	}
+
+	c := &AESGCMCipher{keyId: keyId, aeads: make(map[uint16]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if c.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (c *AESGCMCipher) KeyId() uint16 { return c.keyId }
+
+func (c *AESGCMCipher) Overhead() int {
+	aead := c.aeads[c.keyId]
+	return aead.NonceSize() + aead.Overhead()
+}
+
+func (c *AESGCMCipher) Encrypt(data []byte) ([]byte, error) {
+	aead := c.aeads[c.keyId]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+func (c *AESGCMCipher) Decrypt(keyId uint16, data []byte) ([]byte, error) {
+	aead, exists := c.aeads[keyId]
+	if !exists {
+		return nil, qerror.Errorf("Unknown encryption key %d", keyId)
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, qerror.Errorf("The encrypted value is too short")
+	}
+
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+}
+
+// ReencryptField rewrites the values of the encrypted field which are stored without the header or encrypted
+// with the keys other than the current one of the cipher, the blind index is rewritten as well. The column must
+// have the binary type already. The rows are read in the order of the single field primary key, the result
+// is the number of the rewritten rows.
+func (s *MySQL) ReencryptField(ctx context.Context, m model.IModel, fieldName string, opts RewriteFieldOpts) (uint64, error) {
+	field := m.GetFieldDefinition(fieldName)
+	e := encryptionOf(field)
+	if e == nil {
+		return 0, qerror.Errorf("The field '%s' in model '%s' is not encrypted", fieldName, m.GetId())
+	}
+	compressed, _ := field.(interface{ IsCompressed() bool })
+	keyId := make([]byte, 2)
+	binary.BigEndian.PutUint16(keyId, e.Cipher.KeyId())
+
+	return s.rewriteField(ctx, m, fieldName, opts, func(value []byte) (map[string]interface{}, error) {
+		if value == nil || isEncrypted(value) && bytes.Equal(value[len(encryptedMagic):encryptedHeaderLength], keyId) {
+			return nil, nil
+		}
+
+		// The stored value is compressed before the encryption
+		stored, err := e.decrypt(value)
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := e.encrypt(stored)
+		if err != nil {
+			return nil, err
+		}
+		res := map[string]interface{}{fieldName: encrypted}
+
+		if e.BlindIndex != "" {
+			plain := stored
+			if compressed != nil && compressed.IsCompressed() {
+				if plain, err = decompress(stored); err != nil {
+					return nil, err
+				}
+			}
+			if res[e.BlindIndex], err = e.blindIndexValue(plain); err != nil {
+				return nil, err
+			}
+		}
+
+		return res, nil
+	})
+}
@@ -0,0 +1,182 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-qbit/qerror"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+var (
+	// ErrDuplicateKey is matched by the duplicate entry errors, see Error.Index and Error.Value
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrForeignKeyViolation is matched by the errors of the rows referencing a missing row or referenced by the other rows
+	ErrForeignKeyViolation = errors.New("foreign key violation")
+	// ErrDeadlock is matched by the deadlock errors, the transaction is rolled back by the server
+	ErrDeadlock = errors.New("deadlock")
+	// ErrLockWaitTimeout is matched by the lock wait timeout errors
+	ErrLockWaitTimeout = errors.New("lock wait timeout")
+	// ErrRowLocked is matched by the errors of the NOWAIT locking reads of the rows locked by the other transactions
+	ErrRowLocked = errors.New("row locked")
+	// ErrDataTooLong is matched by the errors of the values longer than the column, see Error.Field
+	ErrDataTooLong = errors.New("data too long")
+)
+
+// Error is the classified MySQL error returned by the statements, errors.Is matches it with the Kind sentinel
+// and errors.As finds the original *mysql.MySQLError
+type Error struct {
+	Kind error
+	// Model is the model of the statement, it is empty for the raw statements
+	Model string
+	// Index and Value are the index and the conflicting value of the duplicate key error
+	Index string
+	Value string
+	// Field is the field of the too long value if the column belongs to the model
+	Field string
+	Err   *mysqldriver.MySQLError
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+
+func (e *Error) Unwrap() error { return e.Err }
+
+func (e *Error) Is(target error) bool { return target == e.Kind }
+
+var (
+	duplicateEntryRe = regexp.MustCompile(`^Duplicate entry '(.*)' for key '(.*)'$`)
+	dataTooLongRe    = regexp.MustCompile(`^Data too long for column '(.*)' at row \d+$`)
+)
+
+// classifyError wraps the MySQL errors of the known kinds into Error, the other errors are returned as is
+func (s *MySQL) classifyError(ctx context.Context, err error) error {
+	var (
+		classified *Error
+		mysqlErr   *mysqldriver.MySQLError
+	)
+	if err == nil || errors.As(err, &classified) || !errors.As(err, &mysqlErr) {
+		return err
+	}
+
+	res := &Error{Err: mysqlErr}
+	if info, ok := ctx.Value(ctx_query_key).(queryInfo); ok {
+		res.Model = info.model
+	}
+
+	switch mysqlErr.Number {
+	case 1062, 1586: // ER_DUP_ENTRY, ER_DUP_ENTRY_WITH_KEY_NAME
+		res.Kind = ErrDuplicateKey
+		if match := duplicateEntryRe.FindStringSubmatch(mysqlErr.Message); match != nil {
+			res.Value, res.Index = match[1], match[2]
+			// MySQL 8 qualifies the index with the table name
+			if pos := strings.LastIndexByte(res.Index, '.'); pos >= 0 {
+				res.Index = res.Index[pos+1:]
+			}
+		}
+	case 1216, 1217, 1451, 1452: // ER_NO_REFERENCED_ROW, ER_ROW_IS_REFERENCED and their _2 versions
+		res.Kind = ErrForeignKeyViolation
+	case 1213: // ER_LOCK_DEADLOCK
+		res.Kind = ErrDeadlock
+	case 1205: // ER_LOCK_WAIT_TIMEOUT
+		res.Kind = ErrLockWaitTimeout
+	case 3572: // ER_LOCK_NOWAIT
+		res.Kind = ErrRowLocked
+	case 1406: // ER_DATA_TOO_LONG
+		res.Kind = ErrDataTooLong
+		if match := dataTooLongRe.FindStringSubmatch(mysqlErr.Message); match != nil && res.Model != "" {
+			s.modelsMtx.RLock()
+			m := s.models[res.Model]
+			s.modelsMtx.RUnlock()
+			if m != nil && m.GetFieldDefinition(match[1]) != nil {
+				res.Field = match[1]
+			}
+		}
+	default:
+		return err
+	}
+
+	return res
+}
+
+// isPermanentError reports whether the statement failed because of the data and fails again if it is repeated
+func isPermanentError(err error) bool {
+	return errors.Is(err, ErrDuplicateKey) || errors.Is(err, ErrForeignKeyViolation) || errors.Is(err, ErrDataTooLong)
+}
+
+// DefaultErrorStatementLength is the default length the statements are truncated to in StatementError
+const DefaultErrorStatementLength = 512
+
+// StatementError is the error of the failed statement with the context of the statement, it wraps the error
+// of the driver or Error so errors.Is and errors.As see through it
+type StatementError struct {
+	*qerror.BaseError
+	Model string
+	Op    string
+	// SQL is sanitized with the recording policy and truncated to the length set by SetErrorStatementLength
+	SQL       string
+	ArgsCount int
+	// Args are set in the debug mode only, the values of the sensitive fields are redacted
+	Args []interface{}
+	Err  error
+}
+
+func (e *StatementError) Error() string {
+	buf := &bytes.Buffer{}
+	buf.WriteString(e.Model)
+	buf.WriteByte('.')
+	buf.WriteString(e.Op)
+	buf.WriteString(": ")
+	buf.WriteString(e.Err.Error())
+	buf.WriteString("\nSQL: ")
+	buf.WriteString(e.SQL)
+	buf.WriteString("\nArgs: ")
+	buf.WriteString(strconv.Itoa(e.ArgsCount))
+	if e.Args != nil {
+		buf.WriteByte(' ')
+		buf.WriteString(fmt.Sprint(e.Args))
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(e.BaseError.Error())
+
+	return buf.String()
+}
+
+func (e *StatementError) Unwrap() error { return e.Err }
+
+// SetErrorStatementLength sets the length the statements are truncated to in StatementError,
+// the default one is DefaultErrorStatementLength. It must be called before the storage is used.
+func (s *MySQL) SetErrorStatementLength(length int) {
+	s.errorStatementLength = length
+}
+
+// statementError classifies the error of the statement and wraps it into StatementError
+func (s *MySQL) statementError(ctx context.Context, modelId, op, query string, args []interface{}, err error) error {
+	var wrapped *StatementError
+	if err = s.classifyError(ctx, err); err == nil || errors.As(err, &wrapped) {
+		return err
+	}
+
+	maxLength := s.errorStatementLength
+	if maxLength == 0 {
+		maxLength = DefaultErrorStatementLength
+	}
+
+	res := &StatementError{
+		BaseError: qerror.New(1),
+		Model:     modelId,
+		Op:        op,
+		SQL:       truncateStatement(s.recordStatement(query), maxLength),
+		ArgsCount: len(args),
+		Err:       err,
+	}
+	if s.isDebug(ctx) {
+		res.Args = args
+	}
+
+	return res
+}
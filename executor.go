@@ -0,0 +1,60 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+)
+
+// Executor runs the statements, it is implemented by *sql.DB, *sql.Tx and *sql.Conn
+type Executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// ExecutorFactory returns the executor the statements sent to the base one are run by, e.g. the one recording
+// the statements or returning the canned results. The base is the connections pool, a replica, a transaction
+// or a connection.
+type ExecutorFactory func(base Executor) Executor
+
+// NewWithDB returns the storage using the connections pool, e.g. the one of go-sqlmock. The pool is used as is,
+// the pool configuration, the collation and the DSN settings of Connect are not applied.
+func NewWithDB(db *sql.DB, opts Options) *MySQL {
+	s := NewMySQL()
+	s.applyOptions(opts)
+	s.db = db
+
+	return s
+}
+
+// SetExecutorFactory sets the factory of the executors of the statements.
+// It must be called before the storage is used.
+func (s *MySQL) SetExecutorFactory(f ExecutorFactory) {
+	s.executorFactory = f
+}
+
+// executor returns the executor of the statements sent to the base one.
+// The statements are run with the context of the caller and are canceled with it, the executor must pass
+// the context to the base one.
+func (s *MySQL) executor(base Executor) Executor {
+	if s.executorFactory == nil {
+		return base
+	}
+
+	return s.executorFactory(base)
+}
+
+// newTransaction returns the transaction the statements of which are run by the executor of tx
+func (s *MySQL) newTransaction(tx *sql.Tx, span Span, key uint64) *transaction {
+	return &transaction{
+		tx:              tx,
+		id:              atomic.AddUint64(&s.txSeq, 1),
+		exec:            s.executor(tx),
+		span:            span,
+		key:             key,
+		savePointSuffix: fmt.Sprintf("%04x", rand.Intn(0x10000)),
+	}
+}
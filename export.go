@@ -0,0 +1,166 @@
+package mysql
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// streamRows reads the rows one by one without buffering them, the values are passed to f in the order of
+// the fields with the pointers dereferenced. The derivable fields and the virtual fields of the context are computed
+// if derivable is set, otherwise they are rejected, the rows are buffered in chunks of derivableChunkSize then
+// to compute the batch derivable fields once per chunk. The values of the fields with the read masks are masked
+// unless the context is unmasked, see SetMaskDerivableInputs for the derivable fields. The values are valid until
+// f returns.
+func (s *MySQL) streamRows(ctx context.Context, m model.IModel, fieldsNames []string, options model.GetAllOptions, derivable bool, f func(values []interface{}) error) error {
+	virtual, err := virtualFieldsOf(ctx, m)
+	if err != nil {
+		return err
+	}
+	lookup := fieldLookup(m, virtual)
+	if !derivable {
+		for _, name := range fieldsNames {
+			if field := lookup(name); field != nil && field.IsDerivable() {
+				return qerror.Errorf("The field '%s' in model '%s' is derivable and cannot be exported", name, m.GetId())
+			}
+		}
+	}
+
+	// The dependencies of the derivable fields are selected but not passed
+	plan, err := resolveDerivable(m, lookup, fieldsNames, func(model.IFieldDefinition) bool { return true })
+	if err != nil {
+		return err
+	}
+	selected := plan.read
+	derivableNames := make([]string, len(plan.computed))
+	for i, field := range plan.computed {
+		derivableNames[i] = field.GetId()
+	}
+
+	sqlBuf := s.ScopedSqlBuffer(ctx)
+	s.WriteSelectSQL(sqlBuf, m, selected, SelectOptions{
+		GetAllOptions:    options,
+		MaxExecutionTime: s.maxExecutionTimeOf(ctx),
+	})
+	if err := sqlBuf.Err(); err != nil {
+		return err
+	}
+
+	rows, err := s.RawQuery(withQuery(ctx, m.GetId(), "select"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	targets := make([]interface{}, len(selected))
+	values := make([]reflect.Value, len(selected))
+	decoders := make([]IEncodedFieldDefinition, len(selected))
+	masks := make([]func(value interface{}) interface{}, len(selected))
+	for i, name := range selected {
+		target := reflect.New(m.GetFieldDefinition(name).GetType())
+		targets[i], values[i] = target.Interface(), target.Elem()
+		decoders[i] = encodedField(m.GetFieldDefinition(name))
+		if masksApplied(ctx, m) {
+			masks[i] = readMaskOf(m.GetFieldDefinition(name))
+		}
+	}
+	masked := func(row []interface{}, pos int) interface{} {
+		if pos < len(masks) && masks[pos] != nil {
+			return maskValue(masks[pos], row[pos])
+		}
+		return row[pos]
+	}
+
+	positions := make([]int, len(fieldsNames))
+	for i, name := range fieldsNames {
+		if positions[i] = indexOf(selected, name); positions[i] < 0 {
+			positions[i] = len(selected) + indexOf(derivableNames, name)
+		}
+	}
+
+	// The rows are reused unless the derivable fields are computed for the chunk
+	var chunk [][]interface{}
+	row := make([]interface{}, len(selected)+len(derivableNames))
+	res := make([]interface{}, len(fieldsNames))
+	flush := func() error {
+		// The rows count may be a multiple of the chunk size
+		if len(chunk) == 0 {
+			return nil
+		}
+		derived, err := plan.calc(ctx, len(chunk), func(j int, name string) interface{} {
+			if s.unmaskedDerivableInputs {
+				return chunk[j][indexOf(selected, name)]
+			}
+			return masked(chunk[j], indexOf(selected, name))
+		})
+		if err != nil {
+			return err
+		}
+		for i, name := range derivableNames {
+			for j, value := range derived[name] {
+				chunk[j][len(selected)+i] = value
+			}
+		}
+
+		for _, row := range chunk {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			for i, pos := range positions {
+				res[i] = masked(row, pos)
+			}
+			if err := f(res); err != nil {
+				return err
+			}
+		}
+		chunk = chunk[:0]
+
+		return nil
+	}
+
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return err
+		}
+
+		if len(derivableNames) > 0 {
+			row = make([]interface{}, len(selected)+len(derivableNames))
+		}
+		for i := range selected {
+			value := values[i].Interface()
+			if decoders[i] != nil {
+				if value, err = decoders[i].DecodeValue(value); err != nil {
+					return err
+				}
+			}
+			row[i] = derefValue(value)
+		}
+
+		chunk = append(chunk, row)
+		if len(derivableNames) == 0 || len(chunk) >= derivableChunkSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// exportTime formats the DATETIME or TIMESTAMP value in RFC 3339, the zero and invalid values are returned as is
+func (s *MySQL) exportTime(field model.IFieldDefinition, value string) string {
+	if t, err := s.ParseTime(field, value); err == nil && !t.IsZero() {
+		return t.Format(time.RFC3339Nano)
+	}
+
+	return value
+}
@@ -1,83 +1,273 @@
 package mysql
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
 )
 
-var exprProcessor = &ExprProcessor{}
+type ExprProcessor struct {
+	// NilValueIsNull makes Eq and Ne with a nil value to be written as IS NULL and IS NOT NULL
+	NilValueIsNull bool
 
-type ExprProcessor struct{}
+	// ExpandTupleCompare makes the tuple inequalities to be written as the equivalent boolean expressions,
+	// (a,b)<(x,y) becomes (a<x OR (a=x AND b<y)) which MySQL can use indexes for
+	ExpandTupleCompare bool
+
+	// Location is the location the time.Time values compared with DATETIME fields are converted to,
+	// see MySQL.SetLocation
+	Location *time.Location
+
+	// InChunkSize is the maximum number of values in one IN group, longer lists are split into several groups,
+	// 0 disables the split, see MySQL.SetInChunkSize
+	InChunkSize int
+
+	// AllowAnyFunc makes Func accept the functions which are not in AllowedFuncs, see MySQL.SetAllowAnyFunc
+	AllowAnyFunc bool
+
+	// LaxMatch makes Match warn instead of failing if its fields do not correspond to a fulltext index of the model,
+	// see MySQL.SetStrictMatch
+	LaxMatch bool
+
+	// FoldCollation is the collation of EqFold and LikeFold, DefaultFoldCollation if it is empty,
+	// see MySQL.SetFoldCollation
+	FoldCollation string
+
+	// DateLocation is the time zone the date helpers compute the day boundaries in, UTC if it is nil,
+	// see MySQL.SetDateLocation
+	DateLocation *time.Location
+
+	storage *MySQL
+	model   model.IModel
+	qualify bool
+	alias   string
+	outer   *ExprProcessor
+	depth   int
+	info    *exprInfo
+	// part is the part of the statement the expression is written in, PartCondition if it is empty
+	part ExprPart
+	// tables are the tables of the statement by their aliases, see AliasField
+	tables map[string]model.IModel
+}
+
+// ForModel returns a processor for the statements over the model, the columns referenced by Col are resolved against it
+func (p *ExprProcessor) ForModel(m model.IModel) *ExprProcessor {
+	res := *p
+	res.model = m
+	res.qualify = false
+	res.alias = ""
+	res.outer = nil
+	res.part = ""
+
+	return &res
+}
+
+// inPart returns the processor for the expressions of the part of the statement
+func (p *ExprProcessor) inPart(part ExprPart) *ExprProcessor {
+	res := *p
+	res.part = part
+
+	return &res
+}
+
+// partOrCondition returns the part of the statement the expression is written in
+func (p *ExprProcessor) partOrCondition() ExprPart {
+	if p.part == "" {
+		return PartCondition
+	}
+
+	return p.part
+}
+
+// tableName is the name the columns of the model are qualified with in the statement
+func (p *ExprProcessor) tableName() string {
+	if p.alias != "" {
+		return p.alias
+	}
+
+	return p.model.GetId()
+}
 
 type WriteFunc func(*SqlBuffer)
 
+// exprInfo describes the top level node of an expression, see inspectExpr
+type exprInfo struct {
+	model      model.IModel
+	fieldName  string
+	isField    bool
+	value      interface{}
+	isValue    bool
+	logicalOp  string
+	comparison string
+	ops        []model.IExpression
+	isJSON     bool
+}
+
+// inspectExpr reports whether the expression is a plain model field or a value without writing it
+func inspectExpr(e model.IExpression) *exprInfo {
+	info := &exprInfo{}
+	if e != nil {
+		e.GetProcessor(&ExprProcessor{info: info})
+	}
+
+	return info
+}
+
+// fieldDefinitionOf returns the definition of the field the expression refers to or nil if it is not a model field
+func fieldDefinitionOf(e model.IExpression) model.IFieldDefinition {
+	info := inspectExpr(e)
+	if !info.isField || info.model == nil {
+		return nil
+	}
+
+	return info.model.GetFieldDefinition(info.fieldName)
+}
+
 func (p *ExprProcessor) Eq(op1, op2 model.IExpression) interface{} {
+	if p.info != nil {
+		p.info.comparison, p.info.ops = "=", []model.IExpression{op1, op2}
+	}
+
 	return WriteFunc(func(buf *SqlBuffer) {
-		op1.GetProcessor(p).(WriteFunc)(buf)
+		if p.writeBlindIndexCompare(buf, op1, op2, "=", " IS NULL") {
+			return
+		}
+
+		p.writeOperand(buf, op1, op2)
 
-		if op2 == nil {
+		if p.isNull(op2) {
 			buf.WriteString(" IS NULL")
 		} else {
 			buf.WriteByte('=')
-			op2.GetProcessor(p).(WriteFunc)(buf)
+			p.writeOperand(buf, op2, op1)
 		}
 	})
 }
 
 func (p *ExprProcessor) Ne(op1, op2 model.IExpression) interface{} {
 	return WriteFunc(func(buf *SqlBuffer) {
-		op1.GetProcessor(p).(WriteFunc)(buf)
+		if p.writeBlindIndexCompare(buf, op1, op2, "<>", " IS NOT NULL") {
+			return
+		}
+
+		p.writeOperand(buf, op1, op2)
 
-		if op2 == nil {
+		if p.isNull(op2) {
 			buf.WriteString(" IS NOT NULL")
 		} else {
 			buf.WriteString("<>")
-			op2.GetProcessor(p).(WriteFunc)(buf)
+			p.writeOperand(buf, op2, op1)
 		}
 	})
 }
 
+func (p *ExprProcessor) isNull(op model.IExpression) bool {
+	if op == nil {
+		return true
+	}
+
+	if !p.NilValueIsNull {
+		return false
+	}
+
+	info := inspectExpr(op)
+
+	return info.isValue && isNil(info.value)
+}
+
+// writeOperand writes the operand of a comparison, a non-string value compared with
+// a JSON member is marshalled to JSON
+func (p *ExprProcessor) writeOperand(buf *SqlBuffer, op, other model.IExpression) {
+	if field := fieldDefinitionOf(other); isConvertedField(field, p.Location) {
+		if info := inspectExpr(op); info.isValue {
+			buf.WriteValue(fieldValue(field, info.value, p.Location))
+			return
+		}
+	}
+
+	if other != nil && inspectExpr(other).isJSON {
+		if info := inspectExpr(op); info.isValue && !isNil(info.value) {
+			if _, isString := info.value.(string); !isString {
+				p.writeJSONValue(buf, info.value)
+				return
+			}
+		}
+	}
+
+	op.GetProcessor(p).(WriteFunc)(buf)
+}
+
 func (p *ExprProcessor) Lt(op1, op2 model.IExpression) interface{} {
 	return WriteFunc(func(buf *SqlBuffer) {
-		op1.GetProcessor(p).(WriteFunc)(buf)
+		p.writeOperand(buf, op1, op2)
 		buf.WriteByte('<')
-		op2.GetProcessor(p).(WriteFunc)(buf)
+		p.writeOperand(buf, op2, op1)
 	})
 }
 
 func (p *ExprProcessor) Le(op1, op2 model.IExpression) interface{} {
 	return WriteFunc(func(buf *SqlBuffer) {
-		op1.GetProcessor(p).(WriteFunc)(buf)
+		p.writeOperand(buf, op1, op2)
 		buf.WriteString("<=")
-		op2.GetProcessor(p).(WriteFunc)(buf)
+		p.writeOperand(buf, op2, op1)
 	})
 }
 
 func (p *ExprProcessor) Gt(op1, op2 model.IExpression) interface{} {
 	return WriteFunc(func(buf *SqlBuffer) {
-		op1.GetProcessor(p).(WriteFunc)(buf)
+		p.writeOperand(buf, op1, op2)
 		buf.WriteByte('>')
-		op2.GetProcessor(p).(WriteFunc)(buf)
+		p.writeOperand(buf, op2, op1)
 	})
 }
 
 func (p *ExprProcessor) Ge(op1, op2 model.IExpression) interface{} {
 	return WriteFunc(func(buf *SqlBuffer) {
-		op1.GetProcessor(p).(WriteFunc)(buf)
+		p.writeOperand(buf, op1, op2)
 		buf.WriteString(">=")
-		op2.GetProcessor(p).(WriteFunc)(buf)
+		p.writeOperand(buf, op2, op1)
 	})
 }
 
 func (p *ExprProcessor) In(op model.IExpression, values []model.IExpression) interface{} {
 	return WriteFunc(func(buf *SqlBuffer) {
+		if len(values) == 0 {
+			buf.WriteString("FALSE")
+			return
+		}
+
+		if e, info := blindIndexOperand(op); e != nil {
+			hashes := make([]interface{}, len(values))
+			for i, value := range values {
+				valueInfo := inspectExpr(value)
+				if !valueInfo.isValue {
+					buf.SetError(encryptedFieldError(info.model, info.fieldName))
+					return
+				}
+				hashes[i] = valueInfo.value
+			}
+			p.writeBlindIndexIn(buf, e, info, hashes, false)
+			return
+		}
+
+		field := fieldDefinitionOf(op)
 		op.GetProcessor(p).(WriteFunc)(buf)
 		buf.WriteString(" IN (")
 		for i, value := range values {
 			if i > 0 {
 				buf.WriteByte(',')
 			}
+			if isConvertedField(field, p.Location) {
+				if info := inspectExpr(value); info.isValue {
+					buf.WriteValue(fieldValue(field, info.value, p.Location))
+					continue
+				}
+			}
 			value.GetProcessor(p).(WriteFunc)(buf)
 		}
 		buf.WriteByte(')')
@@ -85,64 +275,116 @@ func (p *ExprProcessor) In(op model.IExpression, values []model.IExpression) int
 }
 
 func (p *ExprProcessor) And(ops []model.IExpression) interface{} {
+	return p.logical("AND", ops)
+}
+
+func (p *ExprProcessor) Or(ops []model.IExpression) interface{} {
+	return p.logical("OR", ops)
+}
+
+// logical writes the operands parenthesized, the nested groups of the same operator are flattened,
+// nil operands are skipped. An empty AND is TRUE, an empty OR is FALSE.
+func (p *ExprProcessor) logical(operator string, ops []model.IExpression) interface{} {
+	if p.info != nil {
+		p.info.logicalOp, p.info.ops = operator, ops
+	}
+
 	return WriteFunc(func(buf *SqlBuffer) {
-		for i, op := range ops {
-			if i > 0 {
-				buf.WriteString("AND")
+		ops := flattenLogical(operator, ops, nil)
+
+		switch len(ops) {
+		case 0:
+			if operator == "AND" {
+				buf.WriteString("TRUE")
+			} else {
+				buf.WriteString("FALSE")
+			}
+
+		case 1:
+			ops[0].GetProcessor(p).(WriteFunc)(buf)
+
+		default:
+			for i, op := range ops {
+				if i > 0 {
+					buf.WriteString(operator)
+				}
+				buf.WriteByte('(')
+				op.GetProcessor(p).(WriteFunc)(buf)
+				buf.WriteByte(')')
 			}
-			buf.WriteByte('(')
-			op.GetProcessor(p).(WriteFunc)(buf)
-			buf.WriteByte(')')
 		}
 	})
 }
 
-func (p *ExprProcessor) Or(ops []model.IExpression) interface{} {
-	return WriteFunc(func(buf *SqlBuffer) {
-		for i, op := range ops {
-			if i > 0 {
-				buf.WriteString("OR")
+func flattenLogical(operator string, ops, res []model.IExpression) []model.IExpression {
+	for _, op := range ops {
+		if op == nil {
+			continue
+		}
+
+		info := inspectExpr(op)
+		switch {
+		case info.logicalOp == operator:
+			res = flattenLogical(operator, info.ops, res)
+		case info.logicalOp != "":
+			// A group of the other operator with the single operand is the operand itself
+			if inner := flattenLogical(info.logicalOp, info.ops, nil); len(inner) == 1 {
+				res = flattenLogical(operator, inner, res)
+			} else {
+				res = append(res, op)
 			}
-			buf.WriteByte('(')
-			op.GetProcessor(p).(WriteFunc)(buf)
-			buf.WriteByte(')')
+		default:
+			res = append(res, op)
 		}
-	})
+	}
+
+	return res
 }
 
 func (p *ExprProcessor) Any(localModel, extModel model.IModel, filter model.IExpression) interface{} {
 	return WriteFunc(func(buf *SqlBuffer) {
+		if err := p.storage.checkStorage(extModel); err != nil {
+			buf.SetError(err)
+			return
+		}
+
 		relation := localModel.GetRelation(extModel.GetId())
 		if relation.RelationType == model.RELATION_MANY_TO_MANY {
 			buf.WriteIdentifiersList(relation.LocalFieldsNames)
 			buf.WriteString("=ANY(SELECT ")
 			buf.WriteIdentifiersList(relation.JunctionLocalFieldsNames)
 			buf.WriteString(" FROM ")
-			buf.WriteIdentifier(relation.JunctionModel.GetId())
+			buf.WriteTable(relation.JunctionModel)
 			buf.WriteString(" WHERE (")
 			buf.WriteIdentifiersList(relation.JunctionFkFieldsNames)
 			buf.WriteString(")=ANY(SELECT ")
 			buf.WriteIdentifiersList(relation.FkFieldsNames)
 			buf.WriteString(" FROM ")
-			buf.WriteIdentifier(extModel.GetId())
+			buf.WriteTable(extModel)
 
-			if filter != nil {
+			if filter := buf.scoped(p.storage, extModel, filter); filter != nil {
 				buf.WriteString(" WHERE ")
-				filter.GetProcessor(p).(WriteFunc)(buf)
+				filter.GetProcessor(p.ForModel(extModel)).(WriteFunc)(buf)
 			}
 
-			buf.WriteString("))")
+			buf.WriteByte(')')
+			if scope := buf.scoped(p.storage, relation.JunctionModel, nil); scope != nil {
+				buf.WriteString(" AND (")
+				scope.GetProcessor(p.ForModel(relation.JunctionModel)).(WriteFunc)(buf)
+				buf.WriteByte(')')
+			}
+			buf.WriteByte(')')
 		} else {
 			buf.WriteRune('(')
 			buf.WriteIdentifiersList(relation.LocalFieldsNames)
 			buf.WriteString(")=ANY(SELECT ")
 			buf.WriteIdentifiersList(relation.FkFieldsNames)
 			buf.WriteString(" FROM ")
-			buf.WriteIdentifier(extModel.GetId())
+			buf.WriteTable(extModel)
 
-			if filter != nil {
+			if filter := buf.scoped(p.storage, extModel, filter); filter != nil {
 				buf.WriteString(" WHERE ")
-				filter.GetProcessor(p).(WriteFunc)(buf)
+				filter.GetProcessor(p.ForModel(extModel)).(WriteFunc)(buf)
 			}
 
 			buf.WriteRune(')')
@@ -151,16 +393,98 @@ func (p *ExprProcessor) Any(localModel, extModel model.IModel, filter model.IExp
 }
 
 func (p *ExprProcessor) ModelField(m model.IModel, fieldName string) interface{} {
+	if p.info != nil {
+		p.info.model, p.info.fieldName, p.info.isField = m, fieldName, true
+	}
+
 	return WriteFunc(func(buf *SqlBuffer) {
-		/*
-			buf.WriteIdentifier(m.GetId())
-			buf.WriteRune('.')
-		*/
-		buf.WriteIdentifier(fieldName)
+		if m.GetFieldDefinition(fieldName) == nil {
+			buf.SetError(unknownFieldError(m, fieldName, p.partOrCondition()))
+			return
+		}
+		if encryptionOf(m.GetFieldDefinition(fieldName)) != nil {
+			buf.SetError(encryptedFieldError(m, fieldName))
+			return
+		}
+
+		p.writeModelField(buf, m, fieldName)
 	})
 }
 
+func (p *ExprProcessor) writeModelField(buf *SqlBuffer, m model.IModel, fieldName string) {
+	if p.qualify {
+		if p.alias != "" && p.model != nil && p.model.GetId() == m.GetId() {
+			buf.WriteIdentifier(p.alias)
+		} else if alias := p.joinedAlias(m); alias != "" {
+			buf.WriteIdentifier(alias)
+		} else {
+			buf.WriteIdentifier(TableName(m))
+		}
+		buf.WriteRune('.')
+	}
+	buf.WriteIdentifier(fieldName)
+}
+
+// writeBlindIndexCompare compares the blind index of the encrypted field with the HMAC of the value, it reports
+// whether the operands are such field and value
+func (p *ExprProcessor) writeBlindIndexCompare(buf *SqlBuffer, op1, op2 model.IExpression, operator, nullOperator string) bool {
+	e, info := blindIndexOperand(op1)
+	value := op2
+	if e == nil {
+		e, info = blindIndexOperand(op2)
+		value = op1
+	}
+	if e == nil || value == nil {
+		return false
+	}
+	valueInfo := inspectExpr(value)
+	if !valueInfo.isValue {
+		return false
+	}
+
+	hash, err := e.blindIndexValue(valueInfo.value)
+	if err != nil {
+		buf.SetError(err)
+		return true
+	}
+
+	p.writeModelField(buf, info.model, e.BlindIndex)
+	if hash == nil {
+		buf.WriteString(nullOperator)
+		return true
+	}
+	buf.WriteString(operator)
+	buf.WriteValue(hash)
+
+	return true
+}
+
+// writeBlindIndexIn writes IN of the blind index of the encrypted field with the HMACs of the values
+func (p *ExprProcessor) writeBlindIndexIn(buf *SqlBuffer, e *Encryption, info *exprInfo, values []interface{}, not bool) {
+	hashes := make([]interface{}, len(values))
+	for i, value := range values {
+		var err error
+		if hashes[i], err = e.blindIndexValue(value); err != nil {
+			buf.SetError(err)
+			return
+		}
+	}
+
+	p.writeModelField(buf, info.model, e.BlindIndex)
+	if not {
+		buf.WriteString(" NOT IN (")
+	} else {
+		buf.WriteString(" IN (")
+	}
+	buf.WriteValuesList(hashes)
+	buf.WriteByte(')')
+}
+
 func (p *ExprProcessor) Value(value interface{}) interface{} {
+	if p.info != nil {
+		p.info.value, p.info.isValue = value, true
+	}
+
 	return WriteFunc(func(buf *SqlBuffer) {
 		buf.WriteValue(value)
 	})
@@ -175,7 +499,11 @@ func (p *ExprProcessor) Func(name string, params ...model.IExpression) interface
 			params[1].GetProcessor(p).(WriteFunc)(buf)
 
 		default:
-			buf.WriteIdentifier(name)
+			if AllowedFuncs[strings.ToUpper(name)] {
+				buf.WriteString(strings.ToUpper(name))
+			} else {
+				buf.WriteIdentifier(name)
+			}
 			buf.WriteByte('(')
 			for i, param := range params {
 				if i > 0 {
@@ -187,3 +515,921 @@ func (p *ExprProcessor) Func(name string, params ...model.IExpression) interface
 		}
 	})
 }
+
+func (p *ExprProcessor) like(e *like) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+
+		if e.not {
+			buf.WriteString(" NOT LIKE ")
+		} else {
+			buf.WriteString(" LIKE ")
+		}
+
+		if e.binary {
+			buf.WriteString("BINARY ")
+		}
+
+		if e.pattern != nil {
+			e.pattern.GetProcessor(p).(WriteFunc)(buf)
+		} else {
+			pattern := escapeLike(e.fragment, e.escape)
+			if e.leading {
+				pattern = "%" + pattern
+			}
+			if e.trailing {
+				pattern += "%"
+			}
+			buf.WriteValue(pattern)
+		}
+
+		if e.collation != "" {
+			if !isPlainName(e.collation) {
+				buf.SetError(qerror.Errorf("Invalid collation name '%s'", e.collation))
+				return
+			}
+			buf.WriteString(" COLLATE ")
+			buf.WriteString(e.collation)
+		}
+
+		if e.escape != '\\' {
+			buf.WriteString(" ESCAPE '")
+			if e.escape == '\'' {
+				buf.WriteString("''")
+			} else {
+				buf.WriteRune(e.escape)
+			}
+			buf.WriteByte('\'')
+		}
+	})
+}
+
+// isPlainName reports whether s can be written to SQL without quoting, e.g. as a collation or a charset name
+func isPlainName(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (p *ExprProcessor) inValues(e *in) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if e.err != nil {
+			buf.SetError(e.err)
+			return
+		}
+
+		if e.maxValues > 0 && len(e.values) > e.maxValues {
+			buf.SetError(qerror.Errorf("Too many values in the IN list: %d, the limit is %d", len(e.values), e.maxValues))
+			return
+		}
+
+		if field := fieldDefinitionOf(e.op); field != nil {
+			for _, value := range e.values {
+				if err := checkFieldValue(field, value); err != nil {
+					buf.SetError(err)
+					return
+				}
+			}
+		}
+
+		if encryption, info := blindIndexOperand(e.op); encryption != nil && len(e.values) > 0 {
+			p.writeBlindIndexIn(buf, encryption, info, e.values, e.not)
+			return
+		}
+
+		if len(e.values) == 0 {
+			if e.not {
+				buf.WriteString("TRUE")
+			} else {
+				buf.WriteString("FALSE")
+			}
+			return
+		}
+
+		chunkSize := p.InChunkSize
+		if chunkSize <= 0 {
+			chunkSize = len(e.values)
+		}
+
+		chunked := len(e.values) > chunkSize
+		if chunked {
+			buf.WriteByte('(')
+		}
+
+		for start := 0; start < len(e.values); start += chunkSize {
+			if start > 0 {
+				if e.not {
+					buf.WriteString(" AND ")
+				} else {
+					buf.WriteString(" OR ")
+				}
+			}
+
+			end := start + chunkSize
+			if end > len(e.values) {
+				end = len(e.values)
+			}
+
+			e.op.GetProcessor(p).(WriteFunc)(buf)
+			if e.not {
+				buf.WriteString(" NOT IN (")
+			} else {
+				buf.WriteString(" IN (")
+			}
+			if field := fieldDefinitionOf(e.op); isConvertedField(field, p.Location) {
+				for i, value := range e.values[start:end] {
+					if i > 0 {
+						buf.WriteByte(',')
+					}
+					buf.WriteValue(fieldValue(field, value, p.Location))
+				}
+			} else {
+				buf.WriteValuesList(e.values[start:end])
+			}
+			buf.WriteByte(')')
+		}
+
+		if chunked {
+			buf.WriteByte(')')
+		}
+	})
+}
+
+func (p *ExprProcessor) between(e *between) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		lo, hi := e.lo, e.hi
+		if isNil(lo) {
+			lo = nil
+		}
+		if isNil(hi) {
+			hi = nil
+		}
+
+		field := fieldDefinitionOf(e.op)
+		for _, bound := range []interface{}{lo, hi} {
+			if field != nil && bound != nil {
+				if err := checkFieldValue(field, bound); err != nil {
+					buf.SetError(err)
+					return
+				}
+			}
+		}
+
+		// The bounds are compared as they are sent, e.g. the times of the same day are equal for a DATE field
+		loValue, hiValue := fieldValue(field, lo, p.Location), fieldValue(field, hi, p.Location)
+		if lo != nil && hi != nil {
+			if cmp, ok := compareValues(unwrapSensitive(loValue), unwrapSensitive(hiValue)); ok && cmp > 0 {
+				buf.SetError(qerror.Errorf("Invalid range, the lower bound %v is greater than the upper bound %v", lo, hi))
+				return
+			}
+		}
+
+		switch {
+		case lo == nil && hi == nil:
+			buf.WriteString("TRUE")
+
+		case lo != nil && hi != nil && e.loInclusive && e.hiInclusive:
+			e.op.GetProcessor(p).(WriteFunc)(buf)
+			buf.WriteString(" BETWEEN ")
+			buf.WriteValue(loValue)
+			buf.WriteString(" AND ")
+			buf.WriteValue(hiValue)
+
+		case lo != nil && hi != nil:
+			buf.WriteByte('(')
+			p.writeBound(buf, e.op, ">", e.loInclusive, loValue)
+			buf.WriteString(" AND ")
+			p.writeBound(buf, e.op, "<", e.hiInclusive, hiValue)
+			buf.WriteByte(')')
+
+		case lo != nil:
+			p.writeBound(buf, e.op, ">", e.loInclusive, loValue)
+
+		default:
+			p.writeBound(buf, e.op, "<", e.hiInclusive, hiValue)
+		}
+	})
+}
+
+func (p *ExprProcessor) writeBound(buf *SqlBuffer, op model.IExpression, operator string, inclusive bool, value interface{}) {
+	op.GetProcessor(p).(WriteFunc)(buf)
+	buf.WriteString(operator)
+	if inclusive {
+		buf.WriteByte('=')
+	}
+	buf.WriteValue(value)
+}
+
+func (p *ExprProcessor) isNullCheck(e *isNull) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		if e.not {
+			buf.WriteString(" IS NOT NULL")
+		} else {
+			buf.WriteString(" IS NULL")
+		}
+	})
+}
+
+func (p *ExprProcessor) nullSafeEq(e *nullSafeEq) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		e.op1.GetProcessor(p).(WriteFunc)(buf)
+		buf.WriteString("<=>")
+		if e.op2 == nil {
+			buf.WriteString("NULL")
+		} else {
+			e.op2.GetProcessor(p).(WriteFunc)(buf)
+		}
+	})
+}
+
+func (p *ExprProcessor) not(e *not) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if e.op == nil {
+			buf.SetError(qerror.Errorf("No expression to negate"))
+			return
+		}
+
+		buf.WriteString("NOT(")
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) col(e *col) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if p.model == nil {
+			buf.SetError(qerror.Errorf("The column '%s' is used outside of a model statement", e.name))
+			return
+		}
+
+		p.writeColumn(buf, e.name)
+	})
+}
+
+// writeColumn writes the qualified column of the processor model, the name may be qualified
+// with the model id or the table alias
+func (p *ExprProcessor) writeColumn(buf *SqlBuffer, name string) {
+	fieldName := name
+	if pos := strings.IndexByte(fieldName, '.'); pos >= 0 {
+		if fieldName[:pos] != p.model.GetId() && fieldName[:pos] != p.alias {
+			buf.SetError(qerror.Errorf("Unknown model '%s' in the column '%s', expected '%s'", fieldName[:pos], name, p.model.GetId()))
+			return
+		}
+		fieldName = fieldName[pos+1:]
+	}
+
+	field := p.model.GetFieldDefinition(fieldName)
+	if field == nil {
+		buf.SetError(unknownFieldError(p.model, fieldName, p.partOrCondition()))
+		return
+	}
+	if field.IsDerivable() {
+		buf.SetError(qerror.Errorf("The field '%s' in model '%s' is derivable and cannot be used as a column", fieldName, p.model.GetId()))
+		return
+	}
+
+	buf.WriteIdentifier(p.tableName())
+	buf.WriteByte('.')
+	buf.WriteIdentifier(fieldName)
+}
+
+func (p *ExprProcessor) literal(e *literal) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		opts := p.storage.literalOpts(nil)
+		opts.Location = p.Location
+		buf.WriteLiteral(e.value, opts)
+	})
+}
+
+func (p *ExprProcessor) raw(e *raw) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		buf.WriteByte('(')
+
+		argPos := 0
+		var quote byte
+		for i := 0; i < len(e.sql); i++ {
+			c := e.sql[i]
+
+			switch {
+			case quote != 0:
+				if c == '\\' && quote != '`' && i+1 < len(e.sql) {
+					buf.WriteByte(c)
+					i++
+					c = e.sql[i]
+				} else if c == quote {
+					quote = 0
+				}
+				buf.WriteByte(c)
+
+			case c == '\'' || c == '"' || c == '`':
+				quote = c
+				buf.WriteByte(c)
+
+			case c == '?':
+				if argPos >= len(e.args) {
+					buf.SetError(qerror.Errorf("Not enough arguments for the raw expression '%s'", e.sql))
+					return
+				}
+				if arg, ok := e.args[argPos].(model.IExpression); ok {
+					arg.GetProcessor(p).(WriteFunc)(buf)
+				} else {
+					buf.WriteValue(e.args[argPos])
+				}
+				argPos++
+
+			default:
+				buf.WriteByte(c)
+			}
+		}
+
+		if argPos != len(e.args) {
+			buf.SetError(qerror.Errorf("Too many arguments for the raw expression '%s': %d placeholders, %d arguments", e.sql, argPos, len(e.args)))
+		}
+
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) function(e *function) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if !isPlainName(e.name) || !p.AllowAnyFunc && !AllowedFuncs[e.name] {
+			buf.SetError(qerror.Errorf("The function '%s' is not allowed", e.name))
+			return
+		}
+
+		buf.WriteString(e.name)
+		buf.WriteByte('(')
+		for i, arg := range e.args {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			arg.GetProcessor(p).(WriteFunc)(buf)
+		}
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) jsonExtract(e *jsonExtract) WriteFunc {
+	if p.info != nil && !e.unquote {
+		p.info.isJSON = true
+	}
+
+	return WriteFunc(func(buf *SqlBuffer) {
+		if err := checkJSONPath(e.path); err != nil {
+			buf.SetError(err)
+			return
+		}
+
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		if e.unquote {
+			buf.WriteString("->>")
+		} else {
+			buf.WriteString("->")
+		}
+		// The path of the operator cannot be a placeholder
+		buf.WriteLiteral(e.path, p.storage.literalOpts(nil))
+	})
+}
+
+func (p *ExprProcessor) jsonContains(e *jsonContains) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if e.path != "" {
+			if err := checkJSONPath(e.path); err != nil {
+				buf.SetError(err)
+				return
+			}
+		}
+
+		buf.WriteString("JSON_CONTAINS(")
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		buf.WriteByte(',')
+		p.writeJSONValue(buf, e.candidate)
+		if e.path != "" {
+			buf.WriteByte(',')
+			buf.WriteLiteral(e.path, p.storage.literalOpts(nil))
+		}
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) writeJSONValue(buf *SqlBuffer, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		buf.SetError(qerror.Errorf("Cannot marshal %T to JSON: %s", value, err.Error()))
+		return
+	}
+
+	buf.WriteString("CAST(")
+	buf.WriteValue(string(data))
+	buf.WriteString(" AS JSON)")
+}
+
+func (p *ExprProcessor) match(e *match) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if len(e.fieldsNames) == 0 {
+			buf.SetError(qerror.Errorf("No fields to match"))
+			return
+		}
+		if p.model == nil {
+			buf.SetError(qerror.Errorf("MATCH is used outside of a model statement"))
+			return
+		}
+		for _, fieldName := range e.fieldsNames {
+			if field := p.model.GetFieldDefinition(fieldName); field == nil || field.IsDerivable() {
+				buf.SetError(qerror.Errorf("Unknown field '%s' in model '%s'", fieldName, p.model.GetId()))
+				return
+			}
+		}
+		if !hasFullTextIndex(p.model, e.fieldsNames) {
+			message := fmt.Sprintf("There is no fulltext index on (%s) in model '%s'", strings.Join(e.fieldsNames, ","), p.model.GetId())
+			if !p.LaxMatch {
+				buf.SetError(qerror.Errorf("%s", message))
+				return
+			}
+			p.storage.logWarning(buf.context(), message)
+		}
+
+		buf.WriteString("MATCH(")
+		buf.WriteIdentifiersList(e.fieldsNames)
+		buf.WriteString(") AGAINST(")
+		buf.WriteValue(e.query)
+		switch e.mode {
+		case NaturalLanguage:
+			buf.WriteString(" IN NATURAL LANGUAGE MODE")
+		case Boolean:
+			buf.WriteString(" IN BOOLEAN MODE")
+		case QueryExpansion:
+			buf.WriteString(" WITH QUERY EXPANSION")
+		default:
+			buf.SetError(qerror.Errorf("Unknown match mode %d", e.mode))
+			return
+		}
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) regexpMatch(e *regexpMatch) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if err := checkRegexp(e.pattern); err != nil {
+			buf.SetError(err)
+			return
+		}
+
+		if !e.useFunc {
+			e.op.GetProcessor(p).(WriteFunc)(buf)
+			if e.not {
+				buf.WriteString(" NOT")
+			}
+			buf.WriteString(" REGEXP ")
+			buf.WriteValue(e.pattern)
+			return
+		}
+
+		if strings.Trim(e.flags, "cimnu") != "" {
+			buf.SetError(qerror.Errorf("Invalid regular expression flags '%s'", e.flags))
+			return
+		}
+
+		if e.not {
+			buf.WriteString("NOT ")
+		}
+		buf.WriteString("REGEXP_LIKE(")
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		buf.WriteByte(',')
+		buf.WriteValue(e.pattern)
+		if e.flags != "" {
+			buf.WriteByte(',')
+			buf.WriteValue(e.flags)
+		}
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) tuple(e *tuple) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if len(e.ops) == 0 {
+			buf.SetError(qerror.Errorf("Empty tuple"))
+			return
+		}
+
+		buf.WriteByte('(')
+		for i, op := range e.ops {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			op.GetProcessor(p).(WriteFunc)(buf)
+		}
+		buf.WriteByte(')')
+	})
+}
+
+// writeTupleValues writes the values in the shape of the tuple, ops and values are the flattened tuple
+func writeTupleValues(buf *SqlBuffer, t *tuple, ops []model.IExpression, values []interface{}, loc *time.Location) ([]model.IExpression, []interface{}) {
+	buf.WriteByte('(')
+	for i, op := range t.ops {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if nested, ok := op.(*tuple); ok {
+			ops, values = writeTupleValues(buf, nested, ops, values, loc)
+			continue
+		}
+		buf.WriteValue(fieldValue(fieldDefinitionOf(ops[0]), values[0], loc))
+		ops, values = ops[1:], values[1:]
+	}
+	buf.WriteByte(')')
+
+	return ops, values
+}
+
+// checkTupleValues returns the flattened tuple and values, the values are checked against the fields
+func checkTupleValues(t *tuple, values []interface{}) ([]model.IExpression, []interface{}, error) {
+	ops, flatValues, err := flattenTuple(t, values, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for i, op := range ops {
+		if field := fieldDefinitionOf(op); field != nil && !isNil(flatValues[i]) {
+			if err := checkFieldValue(field, flatValues[i]); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return ops, flatValues, nil
+}
+
+func (p *ExprProcessor) tupleCompare(e *tupleCompare) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		ops, values, err := checkTupleValues(e.t, e.values)
+		if err != nil {
+			buf.SetError(err)
+			return
+		}
+
+		if !p.ExpandTupleCompare || e.operator == "=" || e.operator == "<>" {
+			p.tuple(e.t)(buf)
+			buf.WriteString(e.operator)
+			writeTupleValues(buf, e.t, ops, values, p.Location)
+			return
+		}
+
+		// (a,b)<(x,y) is (a<x OR (a=x AND b<y)), only the last element uses the inclusive operator
+		strict := e.operator[:1]
+		if len(ops) > 1 {
+			buf.WriteByte('(')
+		}
+		for i := range ops {
+			if i > 0 {
+				buf.WriteString(" OR (")
+			}
+			for j := 0; j < i; j++ {
+				ops[j].GetProcessor(p).(WriteFunc)(buf)
+				buf.WriteByte('=')
+				buf.WriteValue(fieldValue(fieldDefinitionOf(ops[j]), values[j], p.Location))
+				buf.WriteString(" AND ")
+			}
+			ops[i].GetProcessor(p).(WriteFunc)(buf)
+			if i == len(ops)-1 {
+				buf.WriteString(e.operator)
+			} else {
+				buf.WriteString(strict)
+			}
+			buf.WriteValue(fieldValue(fieldDefinitionOf(ops[i]), values[i], p.Location))
+			if i > 0 {
+				buf.WriteByte(')')
+			}
+		}
+		if len(ops) > 1 {
+			buf.WriteByte(')')
+		}
+	})
+}
+
+func (p *ExprProcessor) tupleIn(e *tupleIn) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		type row struct {
+			ops    []model.IExpression
+			values []interface{}
+		}
+
+		rows := make([]row, len(e.rows))
+		for i, values := range e.rows {
+			ops, flatValues, err := checkTupleValues(e.t, values)
+			if err != nil {
+				buf.SetError(qerror.Errorf("Row %d: %s", i, err.Error()))
+				return
+			}
+			rows[i] = row{ops, flatValues}
+		}
+
+		if len(rows) == 0 {
+			if e.not {
+				buf.WriteString("TRUE")
+			} else {
+				buf.WriteString("FALSE")
+			}
+			return
+		}
+
+		p.tuple(e.t)(buf)
+		if e.not {
+			buf.WriteString(" NOT IN (")
+		} else {
+			buf.WriteString(" IN (")
+		}
+		for i, r := range rows {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeTupleValues(buf, e.t, r.ops, r.values, p.Location)
+		}
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) collate(e *collate) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if !isPlainName(e.collation) {
+			buf.SetError(qerror.Errorf("Invalid collation name '%s'", e.collation))
+			return
+		}
+
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		buf.WriteString(" COLLATE ")
+		buf.WriteString(e.collation)
+	})
+}
+
+func (p *ExprProcessor) fold(e *fold) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		operator := "="
+		if e.like {
+			operator = " LIKE "
+		}
+
+		if e.lower {
+			buf.WriteString("LOWER(")
+			e.op.GetProcessor(p).(WriteFunc)(buf)
+			buf.WriteString(")" + operator + "LOWER(")
+			buf.WriteValue(e.value)
+			buf.WriteByte(')')
+			return
+		}
+
+		collation := e.collation
+		if collation == "" {
+			collation = p.FoldCollation
+		}
+		if collation == "" {
+			collation = DefaultFoldCollation
+		}
+		if !isPlainName(collation) {
+			buf.SetError(qerror.Errorf("Invalid collation name '%s'", collation))
+			return
+		}
+
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		buf.WriteString(operator)
+		buf.WriteValue(e.value)
+		buf.WriteString(" COLLATE ")
+		buf.WriteString(collation)
+	})
+}
+
+func (p *ExprProcessor) exists(e *exists) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if e.model == nil {
+			buf.SetError(qerror.Errorf("No model for the EXISTS subquery"))
+			return
+		}
+		if err := p.storage.checkStorage(e.model); err != nil {
+			buf.SetError(err)
+			return
+		}
+
+		sub := p.ForModel(e.model)
+		sub.depth = p.depth + 1
+		sub.alias = "sq" + strconv.Itoa(sub.depth)
+		if p.model != nil {
+			sub.outer = p
+		}
+
+		if e.not {
+			buf.WriteString("NOT ")
+		}
+		buf.WriteString("EXISTS(SELECT 1 FROM ")
+		buf.WriteTable(e.model)
+		buf.WriteString(" AS ")
+		buf.WriteIdentifier(sub.alias)
+		if filter := buf.scoped(p.storage, e.model, e.filter); filter != nil {
+			buf.WriteString(" WHERE ")
+			filter.GetProcessor(sub).(WriteFunc)(buf)
+		}
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) outerCol(e *outerCol) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if p.outer == nil {
+			buf.SetError(qerror.Errorf("The outer column '%s' is used outside of a correlated subquery", e.name))
+			return
+		}
+
+		p.outer.writeColumn(buf, e.name)
+	})
+}
+
+func (p *ExprProcessor) caseWhen(e *caseWhen) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if len(e.conds) == 0 {
+			buf.SetError(qerror.Errorf("CASE without WHEN"))
+			return
+		}
+
+		buf.WriteString("CASE")
+		for i, cond := range e.conds {
+			if cond == nil {
+				buf.SetError(qerror.Errorf("No condition in WHEN %d", i))
+				return
+			}
+			buf.WriteString(" WHEN ")
+			cond.GetProcessor(p).(WriteFunc)(buf)
+			buf.WriteString(" THEN ")
+			p.writeResult(buf, e.results[i])
+		}
+		if e.elseResult != nil {
+			buf.WriteString(" ELSE ")
+			p.writeResult(buf, e.elseResult)
+		}
+		buf.WriteString(" END")
+	})
+}
+
+func (p *ExprProcessor) writeResult(buf *SqlBuffer, result model.IExpression) {
+	if result == nil {
+		buf.WriteString("NULL")
+	} else {
+		result.GetProcessor(p).(WriteFunc)(buf)
+	}
+}
+
+func (p *ExprProcessor) bitOp(e *bitOp) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if e.operator == "~" {
+			if err := checkMask(e.op, 0); err != nil {
+				buf.SetError(err)
+				return
+			}
+			buf.WriteString("~")
+			e.op.GetProcessor(p).(WriteFunc)(buf)
+			return
+		}
+
+		if err := checkMask(e.op, e.mask); err != nil {
+			buf.SetError(err)
+			return
+		}
+
+		buf.WriteByte('(')
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		buf.WriteString(e.operator)
+		buf.WriteValue(e.mask)
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) hasFlag(e *hasFlag) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if err := checkMask(e.op, e.mask); err != nil {
+			buf.SetError(err)
+			return
+		}
+
+		buf.WriteByte('(')
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		buf.WriteByte('&')
+		buf.WriteValue(e.mask)
+		buf.WriteString(")=")
+		buf.WriteValue(e.mask)
+	})
+}
+
+func (p *ExprProcessor) inSet(e *inSet) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if strings.IndexByte(e.value, ',') >= 0 {
+			buf.SetError(qerror.Errorf("The set element '%s' contains a comma", e.value))
+			return
+		}
+		if field, ok := fieldDefinitionOf(e.op).(*SetField); ok && !containsString(field.Values, e.value) {
+			buf.SetError(qerror.Errorf("'%s' is not an element of the set field '%s'", e.value, field.Id))
+			return
+		}
+
+		buf.WriteString("FIND_IN_SET(")
+		buf.WriteValue(e.value)
+		buf.WriteByte(',')
+		e.op.GetProcessor(p).(WriteFunc)(buf)
+		if e.not {
+			buf.WriteString(")=0")
+		} else {
+			buf.WriteString(")>0")
+		}
+	})
+}
+
+func (p *ExprProcessor) dateRange(e *dateRange) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		start, end := e.bounds(p.DateLocation)
+		if !end.After(start) {
+			buf.SetError(qerror.Errorf("Invalid date range, %s is after %s", e.from.Format("2006-01-02"), e.to.Format("2006-01-02")))
+			return
+		}
+
+		field := fieldDefinitionOf(e.op)
+		if field != nil {
+			if err := checkFieldValue(field, start); err != nil {
+				buf.SetError(err)
+				return
+			}
+		}
+
+		buf.WriteByte('(')
+		p.writeBound(buf, e.op, ">", true, fieldValue(field, start, p.Location))
+		buf.WriteString(" AND ")
+		p.writeBound(buf, e.op, "<", false, fieldValue(field, end, p.Location))
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) window(e *window) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		if e.frame != "" && !windowFrameRe.MatchString(strings.TrimSpace(e.frame)) {
+			buf.SetError(qerror.Errorf("Invalid window frame '%s'", e.frame))
+			return
+		}
+		buf.windowFunctions = true
+
+		if e.aggregate != nil {
+			p.function(e.aggregate)(buf)
+		} else {
+			buf.WriteString(e.name)
+			buf.WriteByte('(')
+			if e.op != nil {
+				e.op.GetProcessor(p).(WriteFunc)(buf)
+				buf.WriteByte(',')
+				buf.WriteString(strconv.FormatUint(uint64(e.offset), 10))
+				buf.WriteByte(',')
+				p.writeResult(buf, e.def)
+			}
+			buf.WriteByte(')')
+		}
+
+		buf.WriteString(" OVER (")
+		if len(e.partition) > 0 {
+			buf.WriteString("PARTITION BY ")
+			for i, op := range e.partition {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				op.GetProcessor(p).(WriteFunc)(buf)
+			}
+		}
+		if len(e.order) > 0 {
+			if len(e.partition) > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString("ORDER BY ")
+			for i, order := range e.order {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				writeOrder(buf, p, order)
+			}
+		}
+		if e.frame != "" {
+			if len(e.partition)+len(e.order) > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(strings.ToUpper(strings.Join(strings.Fields(e.frame), " ")))
+		}
+		buf.WriteByte(')')
+	})
+}
+
+func (p *ExprProcessor) columnAlias(e *columnAlias) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		buf.WriteIdentifier(e.alias)
+	})
+}
@@ -0,0 +1,764 @@
+package mysql_test
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/model/expr"
+	"github.com/go-qbit/model/relation"
+	"github.com/stretchr/testify/assert"
+
+	mysql "github.com/go-qbit/storage-mysql"
+	"github.com/go-qbit/storage-mysql/test"
+)
+
+type exprTestCase struct {
+	name string
+	expr model.IExpression
+	sql  string
+	args []interface{}
+	err  bool
+}
+
+func writeExpr(e model.IExpression) (string, []interface{}, error) {
+	return writeExprWith(&mysql.ExprProcessor{}, e)
+}
+
+func writeExprWith(p *mysql.ExprProcessor, e model.IExpression) (string, []interface{}, error) {
+	sqlBuf := mysql.NewSqlBuffer()
+	e.GetProcessor(p).(mysql.WriteFunc)(sqlBuf)
+
+	return sqlBuf.GetSQL(), sqlBuf.GetArgs(), sqlBuf.Err()
+}
+
+func runExprTestCases(t *testing.T, cases []exprTestCase) {
+	runExprTestCasesWith(t, &mysql.ExprProcessor{}, cases)
+}
+
+func runExprTestCasesWith(t *testing.T, p *mysql.ExprProcessor, cases []exprTestCase) {
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sql, args, err := writeExprWith(p, c.expr)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, c.sql, sql)
+			assert.Equal(t, c.args, args)
+		})
+	}
+}
+
+func TestExprProcessor_Like(t *testing.T) {
+	user := test.NewUser(mysql.NewMySQL())
+	name := user.FieldExpr("name")
+
+	runExprTestCases(t, []exprTestCase{
+		{"like", mysql.Like(name, expr.Value("Iv%")), "`name` LIKE ?", []interface{}{"Iv%"}, false},
+		{"not like", mysql.NotLike(name, expr.Value("Iv%")), "`name` NOT LIKE ?", []interface{}{"Iv%"}, false},
+		{"contains", mysql.Contains(name, "van"), "`name` LIKE ?", []interface{}{"%van%"}, false},
+		{"prefix", mysql.HasPrefix(name, "Iv"), "`name` LIKE ?", []interface{}{"Iv%"}, false},
+		{"suffix", mysql.HasSuffix(name, "an"), "`name` LIKE ?", []interface{}{"%an"}, false},
+		{"literal percent", mysql.Contains(name, "100%"), "`name` LIKE ?", []interface{}{`%100\%%`}, false},
+		{"literal underscore and backslash", mysql.HasPrefix(name, `a_b\c`), "`name` LIKE ?", []interface{}{`a\_b\\c%`}, false},
+		{"custom escape", mysql.HasSuffix(name, "5%!").Escape('!'), "`name` LIKE ? ESCAPE '!'", []interface{}{"%5!%!!"}, false},
+		{"quote escape", mysql.HasPrefix(name, "'").Escape('\''), "`name` LIKE ? ESCAPE ''''", []interface{}{"''%"}, false},
+		{"case sensitive", mysql.Contains(name, "Iv").CaseSensitive(), "`name` LIKE BINARY ?", []interface{}{"%Iv%"}, false},
+		{"collation", mysql.Contains(name, "Iv").Collate("utf8mb4_bin"), "`name` LIKE ? COLLATE utf8mb4_bin", []interface{}{"%Iv%"}, false},
+		{"invalid collation", mysql.Contains(name, "Iv").Collate("bin; DROP"), "", nil, true},
+	})
+}
+
+func TestEscapeLike(t *testing.T) {
+	for _, c := range []struct{ in, out string }{
+		{"", ""},
+		{"abc", "abc"},
+		{"50%", `50\%`},
+		{"a_b", `a\_b`},
+		{`c:\dir`, `c:\\dir`},
+		{`%_\`, `\%\_\\`},
+	} {
+		assert.Equal(t, c.out, mysql.EscapeLike(c.in), c.in)
+	}
+}
+
+func TestExprProcessor_In(t *testing.T) {
+	user := test.NewUser(mysql.NewMySQL())
+	id := user.FieldExpr("id")
+
+	runExprTestCasesWith(t, &mysql.ExprProcessor{InChunkSize: 3}, []exprTestCase{
+		{"in", mysql.In(id, []int{1, 2}), "`id` IN (?,?)", []interface{}{1, 2}, false},
+		{"not in", mysql.NotIn(id, []uint32{1, 2}), "`id` NOT IN (?,?)", []interface{}{uint32(1), uint32(2)}, false},
+		{"empty in", mysql.In(id, []int{}), "FALSE", nil, false},
+		{"empty not in", mysql.NotIn(id, []int{}), "TRUE", nil, false},
+		{"chunk boundary", mysql.In(id, []int{1, 2, 3}), "`id` IN (?,?,?)", []interface{}{1, 2, 3}, false},
+		{"chunked in", mysql.In(id, []int{1, 2, 3, 4}), "(`id` IN (?,?,?) OR `id` IN (?))", []interface{}{1, 2, 3, 4}, false},
+		{"chunked not in", mysql.NotIn(id, []int{1, 2, 3, 4}), "(`id` NOT IN (?,?,?) AND `id` NOT IN (?))", []interface{}{1, 2, 3, 4}, false},
+		{"max values", mysql.In(id, []int{1, 2, 3, 4}).MaxValues(3), "", nil, true},
+		{"not a slice", mysql.In(id, 1), "", nil, true},
+		{"string into int field", mysql.In(id, []string{"1"}), "", nil, true},
+		{"negative into unsigned field", mysql.In(id, []int{-1}), "", nil, true},
+		{"int into string field", mysql.In(user.FieldExpr("name"), []int{1}), "", nil, true},
+		{"strings", mysql.In(user.FieldExpr("name"), []string{"Ivan"}), "`name` IN (?)", []interface{}{"Ivan"}, false},
+	})
+}
+
+func newEventModel(storage *mysql.MySQL) *mysql.BaseModel {
+	return mysql.NewBaseModel(storage, "event", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.DateField{Id: "day", NotNull: true},
+		&mysql.DateTimeField{Id: "created_at", NotNull: true},
+		&mysql.IntField{Id: "score"},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+}
+
+func TestExprProcessor_Between(t *testing.T) {
+	event := newEventModel(mysql.NewMySQL())
+	score, day, createdAt := event.FieldExpr("score"), event.FieldExpr("day"), event.FieldExpr("created_at")
+
+	from := time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)
+	to := time.Date(2024, 5, 2, 0, 0, 0, 500000000, time.UTC)
+
+	runExprTestCases(t, []exprTestCase{
+		{"between", mysql.Between(score, 1, 10), "`score` BETWEEN ? AND ?", []interface{}{1, 10}, false},
+		{"half open", mysql.Range(score, 1, 10, true, false), "(`score`>=? AND `score`<?)", []interface{}{1, 10}, false},
+		{"open", mysql.Range(score, 1, 10, false, false), "(`score`>? AND `score`<?)", []interface{}{1, 10}, false},
+		{"no lower bound", mysql.Range(score, nil, 10, true, true), "`score`<=?", []interface{}{10}, false},
+		{"no upper bound", mysql.Range(score, 1, nil, false, true), "`score`>?", []interface{}{1}, false},
+		{"nil pointer bound", mysql.Between(score, (*int)(nil), 10), "`score`<=?", []interface{}{10}, false},
+		{"no bounds", mysql.Between(score, nil, nil), "TRUE", nil, false},
+		{"equal bounds", mysql.Between(score, 5, 5), "`score` BETWEEN ? AND ?", []interface{}{5, 5}, false},
+		{"inverted", mysql.Between(score, 10, 1), "", nil, true},
+		{"inverted times", mysql.Between(createdAt, to, from), "", nil, true},
+		{"invalid type", mysql.Between(score, "a", "b"), "", nil, true},
+		{"date", mysql.Between(day, from, to), "`day` BETWEEN ? AND ?", []interface{}{"2024-05-01", "2024-05-02"}, false},
+		{"datetime", mysql.Range(createdAt, from, to, true, false), "(`created_at`>=? AND `created_at`<?)",
+			[]interface{}{"2024-05-01 10:30:00", "2024-05-02 00:00:00.5"}, false},
+		// The times of the same day are the same date
+		{"date of the same day", mysql.Between(day, from.Add(5*time.Hour), from), "`day` BETWEEN ? AND ?",
+			[]interface{}{"2024-05-01", "2024-05-01"}, false},
+		{"inverted dates", mysql.Between(day, to, from), "", nil, true},
+	})
+}
+
+func TestExprProcessor_Null(t *testing.T) {
+	event := newEventModel(mysql.NewMySQL())
+	score, id := event.FieldExpr("score"), event.FieldExpr("id")
+
+	runExprTestCases(t, []exprTestCase{
+		{"is null", mysql.IsNull(score), "`score` IS NULL", nil, false},
+		{"is not null", mysql.IsNotNull(score), "`score` IS NOT NULL", nil, false},
+		{"null safe eq", mysql.NullSafeEq(score, id), "`score`<=>`id`", nil, false},
+		{"null safe eq value", mysql.NullSafeEq(score, expr.Value(nil)), "`score`<=>?", []interface{}{nil}, false},
+		{"eq nil expression", expr.Eq(score, nil), "`score` IS NULL", nil, false},
+		{"eq nil value without option", expr.Eq(score, expr.Value(nil)), "`score`=?", []interface{}{nil}, false},
+		{"ne nil value without option", expr.Ne(score, expr.Value(nil)), "`score`<>?", []interface{}{nil}, false},
+	})
+
+	for _, c := range []exprTestCase{
+		{"eq nil value", expr.Eq(score, expr.Value(nil)), "`score` IS NULL", nil, false},
+		{"eq nil pointer", expr.Eq(score, expr.Value((*int)(nil))), "`score` IS NULL", nil, false},
+		{"ne nil value", expr.Ne(score, expr.Value(nil)), "`score` IS NOT NULL", nil, false},
+		{"eq value", expr.Eq(score, expr.Value(1)), "`score`=?", []interface{}{1}, false},
+	} {
+		sqlBuf := mysql.NewSqlBuffer()
+		c.expr.GetProcessor(&mysql.ExprProcessor{NilValueIsNull: true}).(mysql.WriteFunc)(sqlBuf)
+		assert.Equal(t, c.sql, sqlBuf.GetSQL(), c.name)
+		assert.Equal(t, c.args, sqlBuf.GetArgs(), c.name)
+	}
+}
+
+func TestExprProcessor_Logical(t *testing.T) {
+	event := newEventModel(mysql.NewMySQL())
+	id, score := event.FieldExpr("id"), event.FieldExpr("score")
+
+	eq := func(v int) model.IExpression { return expr.Eq(id, expr.Value(v)) }
+
+	runExprTestCases(t, []exprTestCase{
+		{"empty and", mysql.And(), "TRUE", nil, false},
+		{"empty or", mysql.Or(), "FALSE", nil, false},
+		{"single", mysql.And(eq(1)), "`id`=?", []interface{}{1}, false},
+		{"nil operands", mysql.Or(nil, eq(1), nil), "`id`=?", []interface{}{1}, false},
+		{"and", mysql.And(eq(1), eq(2)), "(`id`=?)AND(`id`=?)", []interface{}{1, 2}, false},
+		{"or in and", mysql.And(mysql.Or(eq(1), eq(2)), eq(3)), "((`id`=?)OR(`id`=?))AND(`id`=?)", []interface{}{1, 2, 3}, false},
+		{"flatten", mysql.Or(eq(1), mysql.Or(eq(2), expr.Or(eq(3), eq(4)))), "(`id`=?)OR(`id`=?)OR(`id`=?)OR(`id`=?)", []interface{}{1, 2, 3, 4}, false},
+		{"flatten through single", mysql.And(mysql.Or(mysql.And(eq(1), eq(2))), eq(3)), "(`id`=?)AND(`id`=?)AND(`id`=?)", []interface{}{1, 2, 3}, false},
+		{"not", mysql.Not(mysql.And(mysql.IsNotNull(score), expr.Gt(score, expr.Value(5)))), "NOT((`score` IS NOT NULL)AND(`score`>?))", []interface{}{5}, false},
+		{"status example", mysql.And(mysql.Or(eq(1), eq(2)), mysql.Not(mysql.And(mysql.IsNotNull(score), eq(3)))),
+			"((`id`=?)OR(`id`=?))AND(NOT((`score` IS NOT NULL)AND(`id`=?)))", []interface{}{1, 2, 3}, false},
+		{"not nil", mysql.Not(nil), "", nil, true},
+	})
+}
+
+func TestExprProcessor_Col(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	event := newEventModel(storage)
+	relation.AddManyToOne(event, user)
+
+	for _, c := range []exprTestCase{
+		{"col", expr.Gt(event.FieldExpr("created_at"), mysql.Col("day")), "`created_at`>`event`.`day`", nil, false},
+		{"both sides", expr.Le(mysql.Col("score"), mysql.Col("id")), "`event`.`score`<=`event`.`id`", nil, false},
+		{"qualified", expr.Eq(mysql.Col("event.score"), expr.Value(1)), "`event`.`score`=?", []interface{}{1}, false},
+		{"other model", expr.Eq(mysql.Col("user.id"), expr.Value(1)), "", nil, true},
+		{"unknown field", expr.Eq(mysql.Col("naem"), expr.Value(1)), "", nil, true},
+		{"any subquery", expr.Any(event, user, expr.Eq(mysql.Col("name"), mysql.Col("lastname"))),
+			"(`fk_user_id`)=ANY(SELECT `id` FROM `user` WHERE `user`.`name`=`user`.`lastname`)", nil, false},
+		{"any subquery outer column", expr.Any(event, user, expr.Eq(mysql.Col("score"), expr.Value(1))), "", nil, true},
+	} {
+		sqlBuf := mysql.NewSqlBuffer()
+		c.expr.GetProcessor((&mysql.ExprProcessor{}).ForModel(event)).(mysql.WriteFunc)(sqlBuf)
+		if c.err {
+			assert.Error(t, sqlBuf.Err(), c.name)
+			continue
+		}
+		assert.NoError(t, sqlBuf.Err(), c.name)
+		assert.Equal(t, c.sql, sqlBuf.GetSQL(), c.name)
+		assert.Equal(t, c.args, sqlBuf.GetArgs(), c.name)
+	}
+
+	_, _, err := writeExpr(expr.Eq(mysql.Col("id"), expr.Value(1)))
+	assert.Error(t, err)
+}
+
+func TestExprProcessor_Raw(t *testing.T) {
+	event := newEventModel(mysql.NewMySQL())
+	p := (&mysql.ExprProcessor{}).ForModel(event)
+
+	for _, c := range []exprTestCase{
+		{"raw", mysql.RawExpr("score % ? = ?", 2, 0), "(score % ? = ?)", []interface{}{2, 0}, false},
+		{"no args", mysql.RawExpr("TRUE"), "(TRUE)", nil, false},
+		{"quoted placeholder", mysql.RawExpr(`name = '?' AND x = "a\"?" AND `+"`?` = ?", 1), `(name = '?' AND x = "a\"?" AND ` + "`?` = ?)", []interface{}{1}, false},
+		{"expression arg", expr.Gt(mysql.Col("created_at"), mysql.RawExpr("? + INTERVAL ? DAY", mysql.Col("day"), 1)),
+			"`event`.`created_at`>(`event`.`day` + INTERVAL ? DAY)", []interface{}{1}, false},
+		{"quote ident", mysql.RawExpr(mysql.QuoteIdent("event", "score")+" > ?", 1), "(`event`.`score` > ?)", []interface{}{1}, false},
+		{"literal", mysql.RawExpr("? COLLATE utf8mb4_bin", mysql.LiteralValue(`it's\`)), `('it\'s\\' COLLATE utf8mb4_bin)`, nil, false},
+		{"unsupported literal", mysql.RawExpr("?", mysql.LiteralValue(struct{}{})), "", nil, true},
+		{"not enough args", mysql.RawExpr("a = ? AND b = ?", 1), "", nil, true},
+		{"too many args", mysql.RawExpr("a = ?", 1, 2), "", nil, true},
+	} {
+		sqlBuf := mysql.NewSqlBuffer()
+		c.expr.GetProcessor(p).(mysql.WriteFunc)(sqlBuf)
+		if c.err {
+			assert.Error(t, sqlBuf.Err(), c.name)
+			continue
+		}
+		assert.NoError(t, sqlBuf.Err(), c.name)
+		assert.Equal(t, c.sql, sqlBuf.GetSQL(), c.name)
+		assert.Equal(t, c.args, sqlBuf.GetArgs(), c.name)
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	assert.Equal(t, "`order`", mysql.QuoteIdent("order"))
+	assert.Equal(t, "`db`.`t``x`", mysql.QuoteIdent("db", "t`x"))
+}
+
+func TestExprProcessor_Func(t *testing.T) {
+	event := newEventModel(mysql.NewMySQL())
+	score, day := event.FieldExpr("score"), event.FieldExpr("day")
+
+	runExprTestCases(t, []exprTestCase{
+		{"lower", expr.Eq(mysql.Lower(day), expr.Value("a")), "LOWER(`day`)=?", []interface{}{"a"}, false},
+		{"upper", mysql.Upper(day), "UPPER(`day`)", nil, false},
+		{"date", expr.Eq(mysql.DateOf(event.FieldExpr("created_at")), expr.Value("2024-05-01")), "DATE(`created_at`)=?", []interface{}{"2024-05-01"}, false},
+		{"coalesce", mysql.Coalesce(score, expr.Value(0)), "COALESCE(`score`,?)", []interface{}{0}, false},
+		{"ifnull", mysql.IfNull(score, expr.Value(-1)), "IFNULL(`score`,?)", []interface{}{-1}, false},
+		{"nested", expr.Gt(mysql.Abs(mysql.IfNull(score, expr.Value(1))), mysql.Length(mysql.Lower(mysql.RawExpr("?", "x")))),
+			"ABS(IFNULL(`score`,?))>LENGTH(LOWER((?)))", []interface{}{1, "x"}, false},
+		{"generic", mysql.Func("greatest", score, expr.Value(1)), "GREATEST(`score`,?)", []interface{}{1}, false},
+		{"not allowed", mysql.Func("SLEEP", expr.Value(1)), "", nil, true},
+		{"model func", expr.Func("lower", day), "LOWER(`day`)", nil, false},
+		{"model stored func", expr.Func("my_func", day), "`my_func`(`day`)", nil, false},
+	})
+
+	runExprTestCasesWith(t, &mysql.ExprProcessor{AllowAnyFunc: true}, []exprTestCase{
+		{"any", mysql.Func("my_func", score), "MY_FUNC(`score`)", nil, false},
+		{"invalid name", mysql.Func("a(b", score), "", nil, true},
+	})
+
+	// The setting is of the storage
+	storage := mysql.NewMySQL()
+	storage.SetAllowAnyFunc(true)
+	event = newEventModel(storage)
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, event, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: expr.Gt(mysql.Func("my_func", event.FieldExpr("score")), expr.Value(1)),
+	}})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id` FROM `event` WHERE MY_FUNC(`score`)>?", sqlBuf.GetSQL())
+	}
+}
+
+func newDocModel(storage *mysql.MySQL) *mysql.BaseModel {
+	return mysql.NewBaseModel(storage, "doc", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.JSONField{Id: "data", NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+}
+
+func TestExprProcessor_JSON(t *testing.T) {
+	storage := mysql.NewMySQL()
+	doc := newDocModel(storage)
+	data := doc.FieldExpr("data")
+
+	runExprTestCases(t, []exprTestCase{
+		{"extract", mysql.JSONExtract(data, "$.items[0].sku"), "`data`->'$.items[0].sku'", nil, false},
+		{"unquote", mysql.JSONUnquote(data, "$.country"), "`data`->>'$.country'", nil, false},
+		{"quoted member", mysql.JSONUnquote(data, `$."first name"`), "`data`->>'$.\\\"first name\\\"'", nil, false},
+		{"extract eq string", expr.Eq(mysql.JSONExtract(data, "$.country"), expr.Value("DE")), "`data`->'$.country'=?", []interface{}{"DE"}, false},
+		{"unquote eq string", expr.Eq(mysql.JSONUnquote(data, "$.country"), expr.Value("DE")), "`data`->>'$.country'=?", []interface{}{"DE"}, false},
+		{"extract eq number", expr.Eq(mysql.JSONExtract(data, "$.qty"), expr.Value(5)), "`data`->'$.qty'=CAST(? AS JSON)", []interface{}{"5"}, false},
+		{"extract eq bool", expr.Ne(mysql.JSONExtract(data, "$.active"), expr.Value(true)), "`data`->'$.active'<>CAST(? AS JSON)", []interface{}{"true"}, false},
+		{"value on the left", expr.Lt(expr.Value(1.5), mysql.JSONExtract(data, "$.price")), "CAST(? AS JSON)<`data`->'$.price'", []interface{}{"1.5"}, false},
+		{"unquote eq number", expr.Ge(mysql.JSONUnquote(data, "$.qty"), expr.Value(5)), "`data`->>'$.qty'>=?", []interface{}{5}, false},
+		{"extract is null", expr.Eq(mysql.JSONExtract(data, "$.qty"), nil), "`data`->'$.qty' IS NULL", nil, false},
+		{"contains", mysql.JSONContains(data, "red", "$.tags"), "JSON_CONTAINS(`data`,CAST(? AS JSON),'$.tags')", []interface{}{`"red"`}, false},
+		{"contains document", mysql.JSONContains(data, map[string]int{"qty": 1}, ""), "JSON_CONTAINS(`data`,CAST(? AS JSON))", []interface{}{`{"qty":1}`}, false},
+		{"contains unmarshallable", mysql.JSONContains(data, func() {}, "$"), "", nil, true},
+		{"no dollar", mysql.JSONExtract(data, "country"), "", nil, true},
+		{"unbalanced brackets", mysql.JSONExtract(data, "$.items[0"), "", nil, true},
+		{"unclosed quotes", mysql.JSONExtract(data, `$."name`), "", nil, true},
+		{"empty member", mysql.JSONExtract(data, "$..name"), "", nil, true},
+		{"single quote", mysql.JSONUnquote(data, "$.a' OR 1=1 -- "), "", nil, true},
+		{"invalid contains path", mysql.JSONContains(data, 1, "tags"), "", nil, true},
+	})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, doc, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{
+			Filter: expr.Eq(mysql.JSONUnquote(data, "$.country"), expr.Value("DE")),
+		},
+		Columns: []mysql.Column{{Alias: "sku", Expr: mysql.JSONExtract(data, "$.items[0].sku")}},
+		OrderBy: []mysql.OrderExpr{{Expr: mysql.JSONUnquote(data, "$.name")}},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id`,`data`->'$.items[0].sku' AS `sku` FROM `doc` WHERE `data`->>'$.country'=?"+
+			" ORDER BY `data`->>'$.name'", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{"DE"}, sqlBuf.GetArgs())
+	}
+}
+
+func TestExprProcessor_Match(t *testing.T) {
+	storage := mysql.NewMySQL()
+	article := mysql.NewBaseModel(storage, "article", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.VarCharField{Id: "title", Length: 255, NotNull: true},
+		&mysql.TextField{Id: "body", NotNull: true},
+	}, nil, mysql.BaseModelOpts{
+		BaseModelOpts:   model.BaseModelOpts{PkFieldsNames: []string{"id"}},
+		FullTextIndexes: []mysql.FullTextIndex{{FieldNames: []string{"title", "body"}}},
+	})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	article.WriteCreateSQL(sqlBuf)
+	assert.Contains(t, sqlBuf.GetSQL(), ",FULLTEXT INDEX `ft_article__title_body`(`title`,`body`)")
+
+	writeMatch := func(p *mysql.ExprProcessor, e model.IExpression) (string, []interface{}, error) {
+		sqlBuf := mysql.NewSqlBuffer()
+		e.GetProcessor(p.ForModel(article)).(mysql.WriteFunc)(sqlBuf)
+		return sqlBuf.GetSQL(), sqlBuf.GetArgs(), sqlBuf.Err()
+	}
+
+	for _, c := range []exprTestCase{
+		{"natural", mysql.Match([]string{"title", "body"}, "go orm", mysql.NaturalLanguage),
+			"MATCH(`title`,`body`) AGAINST(? IN NATURAL LANGUAGE MODE)", []interface{}{"go orm"}, false},
+		{"boolean", mysql.Match([]string{"body", "title"}, "+go -java", mysql.Boolean),
+			"MATCH(`body`,`title`) AGAINST(? IN BOOLEAN MODE)", []interface{}{"+go -java"}, false},
+		{"expansion", mysql.Match([]string{"title", "body"}, "go", mysql.QueryExpansion),
+			"MATCH(`title`,`body`) AGAINST(? WITH QUERY EXPANSION)", []interface{}{"go"}, false},
+		{"no index", mysql.Match([]string{"title"}, "go", mysql.NaturalLanguage), "", nil, true},
+		{"unknown field", mysql.Match([]string{"title", "text"}, "go", mysql.NaturalLanguage), "", nil, true},
+		{"no fields", mysql.Match(nil, "go", mysql.NaturalLanguage), "", nil, true},
+		{"unknown mode", mysql.Match([]string{"title", "body"}, "go", mysql.MatchMode(10)), "", nil, true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			sql, args, err := writeMatch(&mysql.ExprProcessor{}, c.expr)
+			if c.err {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.sql, sql)
+				assert.Equal(t, c.args, args)
+			}
+		})
+	}
+
+	_, _, err := writeMatch(&mysql.ExprProcessor{LaxMatch: true}, mysql.Match([]string{"title"}, "go", mysql.NaturalLanguage))
+	assert.NoError(t, err)
+
+	// The warning is passed to the logger of the storage
+	logger := &warningLogger{}
+	storage.SetLogger(logger)
+	storage.SetStrictMatch(false)
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, article, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: mysql.Match([]string{"title"}, "go", mysql.NaturalLanguage),
+	}})
+	storage.SetStrictMatch(true)
+	assert.NoError(t, sqlBuf.Err())
+	assert.Equal(t, []string{"There is no fulltext index on (title) in model 'article'"}, logger.warnings)
+
+	_, _, err = writeExpr(mysql.Match([]string{"title", "body"}, "go", mysql.NaturalLanguage))
+	assert.Error(t, err)
+
+	score := mysql.Match([]string{"title", "body"}, mysql.EscapeBooleanQuery(`"go" +orm`), mysql.Boolean)
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, article, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Filter: score},
+		Columns:       []mysql.Column{{Alias: "score", Expr: score}},
+		OrderBy:       []mysql.OrderExpr{{Expr: score, Desc: true}},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		m := "MATCH(`title`,`body`) AGAINST(? IN BOOLEAN MODE)"
+		assert.Equal(t, "SELECT `id`,"+m+" AS `score` FROM `article` WHERE "+m+" ORDER BY "+m+" DESC", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{" go   orm", " go   orm", " go   orm"}, sqlBuf.GetArgs())
+	}
+}
+
+func TestEscapeBooleanQuery(t *testing.T) {
+	assert.Equal(t, "go orm", mysql.EscapeBooleanQuery("go orm"))
+	assert.Equal(t, " go  java  orm ", mysql.EscapeBooleanQuery("+go -java (orm)"))
+	assert.Equal(t, "a b c d e f", mysql.EscapeBooleanQuery("a>b<c~d*e@f"))
+}
+
+func TestExprProcessor_Regexp(t *testing.T) {
+	user := test.NewUser(mysql.NewMySQL())
+	name := user.FieldExpr("name")
+
+	runExprTestCases(t, []exprTestCase{
+		{"regexp", mysql.Regexp(name, "^SKU-[0-9]+$"), "`name` REGEXP ?", []interface{}{"^SKU-[0-9]+$"}, false},
+		{"not regexp", mysql.NotRegexp(name, "^a"), "`name` NOT REGEXP ?", []interface{}{"^a"}, false},
+		{"flags", mysql.Regexp(name, "^sku").Flags("im"), "REGEXP_LIKE(`name`,?,?)", []interface{}{"^sku", "im"}, false},
+		{"not flags", mysql.NotRegexp(name, "^sku").Flags("i"), "NOT REGEXP_LIKE(`name`,?,?)", []interface{}{"^sku", "i"}, false},
+		{"no flags", mysql.Regexp(name, "^sku").Flags(""), "REGEXP_LIKE(`name`,?)", []interface{}{"^sku"}, false},
+		{"lookahead is left to server", mysql.Regexp(name, "a(?=b)"), "`name` REGEXP ?", []interface{}{"a(?=b)"}, false},
+		{"invalid flags", mysql.Regexp(name, "a").Flags("x"), "", nil, true},
+		{"missing paren", mysql.Regexp(name, "(a"), "", nil, true},
+		{"unexpected paren", mysql.Regexp(name, "a)"), "", nil, true},
+		{"missing bracket", mysql.Regexp(name, "[a-"), "", nil, true},
+		{"bad range", mysql.Regexp(name, "[z-a]"), "", nil, true},
+		{"missing repeat argument", mysql.Regexp(name, "*a"), "", nil, true},
+		{"trailing backslash", mysql.Regexp(name, `a\`), "", nil, true},
+	})
+}
+
+func TestExprProcessor_Tuple(t *testing.T) {
+	user := test.NewUser(mysql.NewMySQL())
+	id, name, lastname := user.FieldExpr("id"), user.FieldExpr("name"), user.FieldExpr("lastname")
+	pair := mysql.Tuple(lastname, name)
+	nested := mysql.Tuple(id, mysql.Tuple(lastname, name))
+
+	runExprTestCases(t, []exprTestCase{
+		{"tuple", pair, "(`lastname`,`name`)", nil, false},
+		{"lt", pair.Lt("Bond", "James"), "(`lastname`,`name`)<(?,?)", []interface{}{"Bond", "James"}, false},
+		{"ge", pair.Ge("Bond", "James"), "(`lastname`,`name`)>=(?,?)", []interface{}{"Bond", "James"}, false},
+		{"eq", pair.Eq("Bond", "James"), "(`lastname`,`name`)=(?,?)", []interface{}{"Bond", "James"}, false},
+		{"nested", nested.Gt(1, []interface{}{"Bond", "James"}), "(`id`,(`lastname`,`name`))>(?,(?,?))", []interface{}{1, "Bond", "James"}, false},
+		{"nested typed slice", nested.Ne(1, []string{"Bond", "James"}), "(`id`,(`lastname`,`name`))<>(?,(?,?))", []interface{}{1, "Bond", "James"}, false},
+		{"in", pair.In([]interface{}{"Bond", "James"}, []interface{}{"Connor", "Sara"}),
+			"(`lastname`,`name`) IN ((?,?),(?,?))", []interface{}{"Bond", "James", "Connor", "Sara"}, false},
+		{"not in", pair.NotIn([]interface{}{"Bond", "James"}), "(`lastname`,`name`) NOT IN ((?,?))", []interface{}{"Bond", "James"}, false},
+		{"nested in", mysql.Tuple(mysql.Tuple(lastname, name), id).In([]interface{}{[]interface{}{"Bond", "James"}, 7}, []interface{}{[]interface{}{"Connor", "Sara"}, 8}),
+			"((`lastname`,`name`),`id`) IN (((?,?),?),((?,?),?))", []interface{}{"Bond", "James", 7, "Connor", "Sara", 8}, false},
+		{"empty in", pair.In(), "FALSE", nil, false},
+		{"empty not in", pair.NotIn(), "TRUE", nil, false},
+		{"too few values", pair.Lt("Bond"), "", nil, true},
+		{"too many values", pair.Lt("Bond", "James", 1), "", nil, true},
+		{"nested arity", nested.Lt(1, []interface{}{"Bond"}), "", nil, true},
+		{"nested not a slice", nested.Lt(1, "Bond"), "", nil, true},
+		{"in row arity", pair.In([]interface{}{"Bond", "James"}, []interface{}{"Connor"}), "", nil, true},
+		{"value type", pair.Eq("Bond", 1), "", nil, true},
+		{"empty tuple", mysql.Tuple().Eq(), "", nil, true},
+	})
+
+	expanded := &mysql.ExprProcessor{ExpandTupleCompare: true}
+	for _, c := range []exprTestCase{
+		{"single", mysql.Tuple(id).Lt(1), "`id`<?", []interface{}{1}, false},
+		{"lt", pair.Lt("Bond", "James"), "(`lastname`<? OR (`lastname`=? AND `name`<?))", []interface{}{"Bond", "Bond", "James"}, false},
+		{"le", pair.Le("Bond", "James"), "(`lastname`<? OR (`lastname`=? AND `name`<=?))", []interface{}{"Bond", "Bond", "James"}, false},
+		{"gt three", mysql.Tuple(lastname, name, id).Gt("Bond", "James", 7),
+			"(`lastname`>? OR (`lastname`=? AND `name`>?) OR (`lastname`=? AND `name`=? AND `id`>?))",
+			[]interface{}{"Bond", "Bond", "James", "Bond", "James", 7}, false},
+		{"ge nested", nested.Ge(7, []interface{}{"Bond", "James"}),
+			"(`id`>? OR (`id`=? AND `lastname`>?) OR (`id`=? AND `lastname`=? AND `name`>=?))",
+			[]interface{}{7, 7, "Bond", 7, "Bond", "James"}, false},
+		{"eq is not expanded", pair.Eq("Bond", "James"), "(`lastname`,`name`)=(?,?)", []interface{}{"Bond", "James"}, false},
+		{"arity", pair.Lt("Bond"), "", nil, true},
+	} {
+		t.Run("expanded "+c.name, func(t *testing.T) {
+			sqlBuf := mysql.NewSqlBuffer()
+			c.expr.GetProcessor(expanded).(mysql.WriteFunc)(sqlBuf)
+			if c.err {
+				assert.Error(t, sqlBuf.Err())
+				return
+			}
+			if assert.NoError(t, sqlBuf.Err()) {
+				assert.Equal(t, c.sql, sqlBuf.GetSQL())
+				assert.Equal(t, c.args, sqlBuf.GetArgs())
+			}
+		})
+	}
+}
+
+func TestExprProcessor_Fold(t *testing.T) {
+	user := test.NewUser(mysql.NewMySQL())
+	name := user.FieldExpr("name")
+
+	runExprTestCases(t, []exprTestCase{
+		{"eq collate", mysql.EqFold(name, "Ivan"), "`name`=? COLLATE utf8mb4_0900_ai_ci", []interface{}{"Ivan"}, false},
+		{"eq lower", mysql.EqFold(name, "Ivan").UseLower(), "LOWER(`name`)=LOWER(?)", []interface{}{"Ivan"}, false},
+		{"eq collation", mysql.EqFold(name, "Ivan").Collation("utf8mb4_general_ci"), "`name`=? COLLATE utf8mb4_general_ci", []interface{}{"Ivan"}, false},
+		{"like collate", mysql.LikeFold(name, "iv%"), "`name` LIKE ? COLLATE utf8mb4_0900_ai_ci", []interface{}{"iv%"}, false},
+		{"like lower", mysql.LikeFold(name, "iv%").UseLower(), "LOWER(`name`) LIKE LOWER(?)", []interface{}{"iv%"}, false},
+		{"invalid collation", mysql.EqFold(name, "Ivan").Collation("x; DROP"), "", nil, true},
+		{"collate", expr.Eq(mysql.Collate(name, "utf8mb4_0900_as_ci"), expr.Value("Ivan")), "`name` COLLATE utf8mb4_0900_as_ci=?", []interface{}{"Ivan"}, false},
+		{"collate in", mysql.In(mysql.Collate(name, "utf8mb4_bin"), []string{"a"}), "`name` COLLATE utf8mb4_bin IN (?)", []interface{}{"a"}, false},
+		{"collate invalid", mysql.Collate(name, "bin'"), "", nil, true},
+	})
+
+	runExprTestCasesWith(t, &mysql.ExprProcessor{FoldCollation: "utf8mb4_general_ci"}, []exprTestCase{
+		{"fold collation", mysql.EqFold(name, "Ivan"), "`name`=? COLLATE utf8mb4_general_ci", []interface{}{"Ivan"}, false},
+		{"fold collation overridden", mysql.EqFold(name, "Ivan").Collation("utf8mb4_bin"), "`name`=? COLLATE utf8mb4_bin", []interface{}{"Ivan"}, false},
+	})
+
+	// The setting is of the storage
+	storage := mysql.NewMySQL()
+	storage.SetFoldCollation("utf8mb4_general_ci")
+	user = test.NewUser(storage)
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: mysql.LikeFold(user.FieldExpr("name"), "iv%"),
+	}})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id` FROM `user` WHERE `name` LIKE ? COLLATE utf8mb4_general_ci", sqlBuf.GetSQL())
+	}
+}
+
+func TestExprProcessor_Exists(t *testing.T) {
+	storage := mysql.NewMySQL()
+	user := test.NewUser(storage)
+	message := test.NewMessage(storage)
+	relation.AddManyToOne(message, user, relation.WithRequired(true), relation.WithAlias("author"))
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{
+			Filter: expr.And(
+				expr.Eq(user.FieldExpr("name"), expr.Value("Ivan")),
+				mysql.NotExists(message, expr.And(
+					expr.Eq(mysql.Col("fk_author_id"), mysql.OuterCol("id")),
+					expr.Ne(message.FieldExpr("text"), expr.Value("")),
+				)),
+			),
+		},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id` FROM `user` WHERE (`name`=?)AND(NOT EXISTS(SELECT 1 FROM `message` AS `sq1`"+
+			" WHERE (`sq1`.`fk_author_id`=`user`.`id`)AND(`text`<>?)))", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{"Ivan", ""}, sqlBuf.GetArgs())
+	}
+
+	// Self-correlated and nested subqueries
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{
+			Filter: mysql.Exists(user, expr.And(
+				expr.Eq(mysql.Col("user.lastname"), mysql.OuterCol("user.lastname")),
+				expr.Lt(mysql.Col("sq1.id"), expr.Value(10)),
+				mysql.Exists(message, expr.Eq(mysql.Col("fk_author_id"), mysql.OuterCol("id"))),
+			)),
+		},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id` FROM `user` WHERE EXISTS(SELECT 1 FROM `user` AS `sq1`"+
+			" WHERE (`sq1`.`lastname`=`user`.`lastname`)AND(`sq1`.`id`<?)"+
+			"AND(EXISTS(SELECT 1 FROM `message` AS `sq2` WHERE `sq2`.`fk_author_id`=`sq1`.`id`)))", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{10}, sqlBuf.GetArgs())
+	}
+
+	runExprTestCases(t, []exprTestCase{
+		{"no filter", mysql.Exists(message, nil), "EXISTS(SELECT 1 FROM `message` AS `sq1`)", nil, false},
+		{"outer col without outer query", mysql.Exists(message, expr.Eq(mysql.Col("id"), mysql.OuterCol("id"))), "", nil, true},
+		{"outer col outside", mysql.OuterCol("id"), "", nil, true},
+		{"no model", mysql.Exists(nil, nil), "", nil, true},
+	})
+
+	sqlBuf.Reset()
+	storage.WriteSelectSQL(sqlBuf, user, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{
+			Filter: mysql.Exists(message, expr.Eq(mysql.Col("fk_author_id"), mysql.OuterCol("unknown"))),
+		},
+	})
+	assert.Error(t, sqlBuf.Err())
+}
+
+func TestExprProcessor_Case(t *testing.T) {
+	storage := mysql.NewMySQL()
+	event := newEventModel(storage)
+	score := event.FieldExpr("score")
+
+	status := mysql.Case().
+		When(expr.Gt(score, expr.Value(0)), expr.Value("positive")).
+		When(mysql.IsNull(score), event.FieldExpr("day")).
+		Else(expr.Value("other"))
+
+	runExprTestCases(t, []exprTestCase{
+		{"case", status, "CASE WHEN `score`>? THEN ? WHEN `score` IS NULL THEN `day` ELSE ? END", []interface{}{0, "positive", "other"}, false},
+		{"no else", mysql.Case().When(expr.Eq(score, expr.Value(1)), expr.Value("one")), "CASE WHEN `score`=? THEN ? END", []interface{}{1, "one"}, false},
+		{"null result", mysql.Case().When(expr.Eq(score, expr.Value(1)), nil), "CASE WHEN `score`=? THEN NULL END", []interface{}{1}, false},
+		{"nested", mysql.Case().When(expr.Eq(score, expr.Value(1)), mysql.Case().When(mysql.IsNull(score), expr.Value(2)).Else(expr.Value(3))),
+			"CASE WHEN `score`=? THEN CASE WHEN `score` IS NULL THEN ? ELSE ? END END", []interface{}{1, 2, 3}, false},
+		{"sum", mysql.Func("SUM", mysql.Case().When(expr.Gt(score, expr.Value(10)), expr.Value(1)).Else(expr.Value(0))),
+			"SUM(CASE WHEN `score`>? THEN ? ELSE ? END)", []interface{}{10, 1, 0}, false},
+		{"no when", mysql.Case().Else(expr.Value(1)), "", nil, true},
+		{"nil condition", mysql.Case().When(nil, expr.Value(1)), "", nil, true},
+	})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, event, []string{"id"}, mysql.SelectOptions{
+		GetAllOptions: model.GetAllOptions{Filter: expr.Lt(score, expr.Value(100))},
+		Columns:       []mysql.Column{{Alias: "status", Expr: status}},
+		OrderBy:       []mysql.OrderExpr{{Expr: mysql.Case().When(mysql.IsNull(score), expr.Value(1)).Else(expr.Value(0))}},
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "SELECT `id`,CASE WHEN `score`>? THEN ? WHEN `score` IS NULL THEN `day` ELSE ? END AS `status`"+
+			" FROM `event` WHERE `score`<? ORDER BY CASE WHEN `score` IS NULL THEN ? ELSE ? END", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{0, "positive", "other", 100, 1, 0}, sqlBuf.GetArgs())
+	}
+}
+
+func TestExprProcessor_Bitwise(t *testing.T) {
+	storage := mysql.NewMySQL()
+	account := mysql.NewBaseModel(storage, "account", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.TinyUintField{Id: "flags", NotNull: true},
+		&mysql.SmallIntField{Id: "sflags", NotNull: true},
+		&mysql.TinyUintField{Id: "nflags"},
+		&mysql.VarCharField{Id: "name", Length: 64, NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+	flags, sflags, nflags := account.FieldExpr("flags"), account.FieldExpr("sflags"), account.FieldExpr("nflags")
+
+	runExprTestCases(t, []exprTestCase{
+		{"has flag", mysql.HasFlag(flags, 0x05), "(`flags`&?)=?", []interface{}{uint64(5), uint64(5)}, false},
+		{"and eq", expr.Eq(mysql.BitAnd(flags, 0x03), expr.Value(1)), "(`flags`&?)=?", []interface{}{uint64(3), 1}, false},
+		{"or", mysql.BitOr(flags, 0x80), "(`flags`|?)", []interface{}{uint64(0x80)}, false},
+		{"xor", mysql.BitXor(flags, 0xff), "(`flags`^?)", []interface{}{uint64(0xff)}, false},
+		{"not", mysql.BitNot(flags), "~`flags`", nil, false},
+		{"clear", mysql.ClearFlag(flags, 0x02), "(`flags`&~?)", []interface{}{uint64(2)}, false},
+		{"signed fits", mysql.HasFlag(sflags, 0x4000), "(`sflags`&?)=?", []interface{}{uint64(0x4000), uint64(0x4000)}, false},
+		{"unsigned overflow", mysql.SetFlag(flags, 0x100), "", nil, true},
+		{"signed overflow", mysql.SetFlag(sflags, 0x8000), "", nil, true},
+		{"nullable", mysql.HasFlag(nflags, 0x80), "(`nflags`&?)=?", []interface{}{uint64(0x80), uint64(0x80)}, false},
+		{"nullable overflow", mysql.SetFlag(nflags, 0x100), "", nil, true},
+		{"not integer", mysql.HasFlag(account.FieldExpr("name"), 1), "", nil, true},
+	})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteUpdateSQL(sqlBuf, account, mysql.HasFlag(flags, 0x01), map[string]interface{}{
+		"name":   "Ivan",
+		"flags":  mysql.ClearFlag(mysql.SetFlag(flags, 0x04), 0x01),
+		"sflags": 1,
+	})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, "UPDATE `account` SET `flags`=((`flags`|?)&~?), `name`=?, `sflags`=? WHERE (`flags`&?)=?", sqlBuf.GetSQL())
+		assert.Equal(t, []interface{}{uint64(4), uint64(1), "Ivan", 1, uint64(1), uint64(1)}, sqlBuf.GetArgs())
+	}
+}
+
+func TestExprProcessor_InSet(t *testing.T) {
+	storage := mysql.NewMySQL()
+	item := mysql.NewBaseModel(storage, "item", []mysql.IMysqlFieldDefinition{
+		&mysql.UintField{Id: "id", NotNull: true, AutoIncrement: true},
+		&mysql.VarCharField{Id: "tag_ids", Length: 255, NotNull: true},
+		&mysql.SetField{Id: "colors", Values: []string{"red", "green", "it's"}, NotNull: true},
+	}, nil, mysql.BaseModelOpts{BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}}})
+
+	sqlBuf := mysql.NewSqlBuffer()
+	item.WriteCreateSQL(sqlBuf)
+	assert.Contains(t, sqlBuf.GetSQL(), "`colors` SET('red','green','it''s') NOT NULL")
+
+	tagIds, colors := item.FieldExpr("tag_ids"), item.FieldExpr("colors")
+	runExprTestCases(t, []exprTestCase{
+		{"varchar", mysql.InSetColumn(tagIds, "15"), "FIND_IN_SET(?,`tag_ids`)>0", []interface{}{"15"}, false},
+		{"varchar not", mysql.NotInSetColumn(tagIds, "15"), "FIND_IN_SET(?,`tag_ids`)=0", []interface{}{"15"}, false},
+		{"varchar comma", mysql.InSetColumn(tagIds, "1,5"), "", nil, true},
+		{"set", mysql.InSetColumn(colors, "green"), "FIND_IN_SET(?,`colors`)>0", []interface{}{"green"}, false},
+		{"set not", mysql.NotInSetColumn(colors, "red"), "FIND_IN_SET(?,`colors`)=0", []interface{}{"red"}, false},
+		{"set unknown element", mysql.InSetColumn(colors, "blue"), "", nil, true},
+		{"set comma", mysql.InSetColumn(colors, "red,green"), "", nil, true},
+	})
+}
+
+func TestExprProcessor_DateRange(t *testing.T) {
+	event := newEventModel(mysql.NewMySQL())
+	day, createdAt := event.FieldExpr("day"), event.FieldExpr("created_at")
+	date := time.Date(2024, 5, 1, 15, 30, 0, 0, time.UTC) // Wednesday
+
+	runExprTestCases(t, []exprTestCase{
+		{"on date datetime", mysql.OnDate(createdAt, date), "(`created_at`>=? AND `created_at`<?)",
+			[]interface{}{"2024-05-01 00:00:00", "2024-05-02 00:00:00"}, false},
+		{"on date date", mysql.OnDate(day, date), "(`day`>=? AND `day`<?)", []interface{}{"2024-05-01", "2024-05-02"}, false},
+		{"range", mysql.InDateRange(createdAt, date, date.AddDate(0, 0, 2)), "(`created_at`>=? AND `created_at`<?)",
+			[]interface{}{"2024-05-01 00:00:00", "2024-05-04 00:00:00"}, false},
+		{"range of one day", mysql.InDateRange(day, date, date), "(`day`>=? AND `day`<?)", []interface{}{"2024-05-01", "2024-05-02"}, false},
+		{"inverted range", mysql.InDateRange(day, date, date.AddDate(0, 0, -1)), "", nil, true},
+		{"week", mysql.InWeek(day, date), "(`day`>=? AND `day`<?)", []interface{}{"2024-04-29", "2024-05-06"}, false},
+		{"week on sunday", mysql.InWeek(day, time.Date(2024, 5, 5, 0, 0, 0, 0, time.UTC)), "(`day`>=? AND `day`<?)",
+			[]interface{}{"2024-04-29", "2024-05-06"}, false},
+		{"month", mysql.InMonth(createdAt, time.Date(2024, 12, 31, 0, 0, 0, 0, time.UTC)), "(`created_at`>=? AND `created_at`<?)",
+			[]interface{}{"2024-12-01 00:00:00", "2025-01-01 00:00:00"}, false},
+		{"year", mysql.InYear(day, date), "(`day`>=? AND `day`<?)", []interface{}{"2024-01-01", "2025-01-01"}, false},
+		{"not a date field", mysql.OnDate(event.FieldExpr("score"), date), "", nil, true},
+	})
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, c := range []struct {
+		name  string
+		date  time.Time
+		hours time.Duration
+	}{
+		{"spring forward", time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC), 23},
+		{"fall back", time.Date(2024, 10, 27, 0, 0, 0, 0, time.UTC), 25},
+		{"regular", time.Date(2024, 10, 28, 0, 0, 0, 0, time.UTC), 24},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, args, err := writeExpr(mysql.OnDate(mysql.RawExpr("ts"), c.date).In(berlin))
+			if !assert.NoError(t, err) {
+				return
+			}
+			start, end := args[0].(time.Time), args[1].(time.Time)
+			assert.Equal(t, time.Date(c.date.Year(), c.date.Month(), c.date.Day(), 0, 0, 0, 0, berlin), start)
+			assert.Equal(t, c.hours*time.Hour, end.Sub(start))
+
+			// The wall clock boundaries of a DATETIME column are midnights whatever the day length is
+			_, args, _ = writeExpr(mysql.OnDate(createdAt, c.date).In(berlin))
+			assert.Equal(t, []interface{}{c.date.Format("2006-01-02") + " 00:00:00", c.date.AddDate(0, 0, 1).Format("2006-01-02") + " 00:00:00"}, args)
+		})
+	}
+
+	_, args, _ := writeExprWith(&mysql.ExprProcessor{DateLocation: berlin}, mysql.OnDate(mysql.RawExpr("ts"), date))
+	assert.Equal(t, berlin, args[0].(time.Time).Location())
+
+	// The setting is of the storage
+	storage := mysql.NewMySQL()
+	storage.SetDateLocation(berlin)
+	event = newEventModel(storage)
+	sqlBuf := mysql.NewSqlBuffer()
+	storage.WriteSelectSQL(sqlBuf, event, []string{"id"}, mysql.SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter: mysql.OnDate(mysql.RawExpr("ts"), date),
+	}})
+	if assert.NoError(t, sqlBuf.Err()) {
+		assert.Equal(t, berlin, sqlBuf.GetArgs()[0].(time.Time).Location())
+	}
+}
@@ -0,0 +1,923 @@
+package mysql
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+func mysqlProcessor(processor model.IExpressionProcessor) *ExprProcessor {
+	p, ok := processor.(*ExprProcessor)
+	if !ok {
+		panic(fmt.Sprintf("The expression can be processed by the MySQL storage only, got %T", processor))
+	}
+
+	return p
+}
+
+// Like
+type like struct {
+	op, pattern model.IExpression
+	fragment    string
+	leading     bool
+	trailing    bool
+	not         bool
+	escape      rune
+	binary      bool
+	collation   string
+}
+
+func Like(op, pattern model.IExpression) *like {
+	return &like{op: op, pattern: pattern, escape: '\\'}
+}
+
+func NotLike(op, pattern model.IExpression) *like {
+	return &like{op: op, pattern: pattern, not: true, escape: '\\'}
+}
+
+// Contains matches the rows where op contains the fragment, wildcards in the fragment are escaped
+func Contains(op model.IExpression, fragment string) *like {
+	return &like{op: op, fragment: fragment, leading: true, trailing: true, escape: '\\'}
+}
+
+// HasPrefix matches the rows where op starts with the fragment, wildcards in the fragment are escaped
+func HasPrefix(op model.IExpression, fragment string) *like {
+	return &like{op: op, fragment: fragment, trailing: true, escape: '\\'}
+}
+
+// HasSuffix matches the rows where op ends with the fragment, wildcards in the fragment are escaped
+func HasSuffix(op model.IExpression, fragment string) *like {
+	return &like{op: op, fragment: fragment, leading: true, escape: '\\'}
+}
+
+// Escape sets the escape character of the pattern, the default one is a backslash
+func (e *like) Escape(c rune) *like {
+	e.escape = c
+	return e
+}
+
+// CaseSensitive forces the byte by byte comparison
+func (e *like) CaseSensitive() *like {
+	e.binary = true
+	return e
+}
+
+// Collate overrides the collation used for the comparison
+func (e *like) Collate(collation string) *like {
+	e.collation = collation
+	return e
+}
+
+func (e *like) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).like(e)
+}
+
+// EscapeLike escapes the LIKE wildcards and the default escape character in s
+func EscapeLike(s string) string {
+	return escapeLike(s, '\\')
+}
+
+func escapeLike(s string, escape rune) string {
+	var buf strings.Builder
+	buf.Grow(len(s))
+
+	for _, c := range s {
+		if c == '%' || c == '_' || c == escape {
+			buf.WriteRune(escape)
+		}
+		buf.WriteRune(c)
+	}
+
+	return buf.String()
+}
+
+// In
+type in struct {
+	op        model.IExpression
+	values    []interface{}
+	not       bool
+	maxValues int
+	err       error
+}
+
+// In matches the rows where op is equal to one of the values, values must be a slice
+func In(op model.IExpression, values interface{}) *in {
+	e := &in{op: op}
+	e.values, e.err = sliceValues(values)
+	return e
+}
+
+// NotIn matches the rows where op is not equal to any of the values, values must be a slice
+func NotIn(op model.IExpression, values interface{}) *in {
+	e := In(op, values)
+	e.not = true
+	return e
+}
+
+// MaxValues makes the expression fail instead of chunking if there are more than n values
+func (e *in) MaxValues(n int) *in {
+	e.maxValues = n
+	return e
+}
+
+func (e *in) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).inValues(e)
+}
+
+func sliceValues(values interface{}) ([]interface{}, error) {
+	if values, ok := values.([]interface{}); ok {
+		return values, nil
+	}
+
+	rv := reflect.ValueOf(values)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, qerror.Errorf("Invalid type '%T', must be a slice", values)
+	}
+
+	res := make([]interface{}, rv.Len())
+	for i := range res {
+		res[i] = rv.Index(i).Interface()
+	}
+
+	return res, nil
+}
+
+// Between
+type between struct {
+	op                       model.IExpression
+	lo, hi                   interface{}
+	loInclusive, hiInclusive bool
+}
+
+// Between matches the rows where op is in the closed range [lo, hi], a nil bound is omitted
+func Between(op model.IExpression, lo, hi interface{}) *between {
+	return Range(op, lo, hi, true, true)
+}
+
+// Range matches the rows where op is between lo and hi, a nil bound is omitted.
+// An inverted range (lo > hi) is an error.
+func Range(op model.IExpression, lo, hi interface{}, loInclusive, hiInclusive bool) *between {
+	return &between{op, lo, hi, loInclusive, hiInclusive}
+}
+
+func (e *between) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).between(e)
+}
+
+// IsNull
+type isNull struct {
+	op  model.IExpression
+	not bool
+}
+
+func IsNull(op model.IExpression) *isNull    { return &isNull{op, false} }
+func IsNotNull(op model.IExpression) *isNull { return &isNull{op, true} }
+
+func (e *isNull) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).isNullCheck(e)
+}
+
+// NullSafeEq
+type nullSafeEq struct {
+	op1, op2 model.IExpression
+}
+
+// NullSafeEq compares the operands with the <=> operator, it is true when both of them are NULL
+func NullSafeEq(op1, op2 model.IExpression) *nullSafeEq { return &nullSafeEq{op1, op2} }
+
+func (e *nullSafeEq) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).nullSafeEq(e)
+}
+
+// And
+type and struct {
+	ops []model.IExpression
+}
+
+// And joins any number of operands, unlike expr.And it accepts zero or one operand and skips nil ones
+func And(ops ...model.IExpression) *and { return &and{ops} }
+
+func (e *and) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return processor.And(e.ops)
+}
+
+// Or
+type or struct {
+	ops []model.IExpression
+}
+
+// Or joins any number of operands, unlike expr.Or it accepts zero or one operand and skips nil ones
+func Or(ops ...model.IExpression) *or { return &or{ops} }
+
+func (e *or) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return processor.Or(e.ops)
+}
+
+// Not
+type not struct {
+	op model.IExpression
+}
+
+func Not(op model.IExpression) *not { return &not{op} }
+
+func (e *not) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).not(e)
+}
+
+// Col
+type col struct {
+	name string
+}
+
+// Col refers to a column of the model the statement is built for, it may be used on any side of a comparison.
+// The name may be qualified with the model id, e.g. "user.id".
+func Col(fieldName string) *col { return &col{fieldName} }
+
+func (e *col) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).col(e)
+}
+
+// Raw
+type raw struct {
+	sql  string
+	args []interface{}
+}
+
+// RawExpr is an SQL fragment with ? placeholders, it is written in parentheses. An argument which is
+// a model.IExpression is written in place of its placeholder, the others are bound in the positional order.
+func RawExpr(sql string, args ...interface{}) *raw { return &raw{sql, args} }
+
+func (e *raw) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).raw(e)
+}
+
+// Literal
+type literal struct {
+	value interface{}
+}
+
+// LiteralValue is the value written as the SQL literal instead of the placeholder, see MySQL.Literal. It is for
+// the positions which do not take the placeholders, e.g. RawExpr("JSON_TABLE(?, ?)", doc, LiteralValue(path))
+// or the column positions of ORDER BY and GROUP BY.
+func LiteralValue(value interface{}) *literal { return &literal{value} }
+
+func (e *literal) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).literal(e)
+}
+
+// AllowedFuncs are the SQL functions accepted by Func, see MySQL.SetAllowAnyFunc to accept any function name
+var AllowedFuncs = map[string]bool{
+	"ABS": true, "AVG": true, "CEIL": true, "CHAR_LENGTH": true, "COALESCE": true, "CONCAT": true,
+	"COUNT": true, "DATE": true, "FLOOR": true, "GREATEST": true, "IF": true, "IFNULL": true,
+	"LEAST": true, "LENGTH": true, "LOWER": true, "MAX": true, "MIN": true, "MONTH": true,
+	"NULLIF": true, "ROUND": true, "SUM": true, "TRIM": true, "UPPER": true, "YEAR": true,
+}
+
+// Func
+type function struct {
+	name string
+	args []model.IExpression
+}
+
+// Func calls the SQL function, the name must be in AllowedFuncs unless any function is allowed by the processor
+func Func(name string, args ...model.IExpression) *function {
+	return &function{strings.ToUpper(name), args}
+}
+
+func Lower(op model.IExpression) *function  { return Func("LOWER", op) }
+func Upper(op model.IExpression) *function  { return Func("UPPER", op) }
+func DateOf(op model.IExpression) *function { return Func("DATE", op) }
+func Abs(op model.IExpression) *function    { return Func("ABS", op) }
+
+// Length returns the length in bytes
+func Length(op model.IExpression) *function { return Func("LENGTH", op) }
+
+func Coalesce(ops ...model.IExpression) *function { return Func("COALESCE", ops...) }
+func IfNull(op, alt model.IExpression) *function  { return Func("IFNULL", op, alt) }
+
+func (e *function) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).function(e)
+}
+
+// JSONExtract
+type jsonExtract struct {
+	op      model.IExpression
+	path    string
+	unquote bool
+}
+
+// JSONExtract returns the member of the JSON document at the path (op->'path'). The result is a JSON value,
+// a string member keeps its double quotes, and the non-string values compared with it are marshalled to JSON.
+func JSONExtract(op model.IExpression, path string) *jsonExtract {
+	return &jsonExtract{op, path, false}
+}
+
+// JSONUnquote returns the unquoted member of the JSON document at the path (op->>'path'), the result is a string
+func JSONUnquote(op model.IExpression, path string) *jsonExtract { return &jsonExtract{op, path, true} }
+
+func (e *jsonExtract) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).jsonExtract(e)
+}
+
+// JSONContains
+type jsonContains struct {
+	op        model.IExpression
+	candidate interface{}
+	path      string
+}
+
+// JSONContains matches the rows where the JSON document contains the candidate at the path, the whole document
+// is checked if the path is empty. The candidate is marshalled to JSON, a json.RawMessage is passed as is.
+func JSONContains(op model.IExpression, candidate interface{}, path string) *jsonContains {
+	return &jsonContains{op, candidate, path}
+}
+
+func (e *jsonContains) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).jsonContains(e)
+}
+
+// checkJSONPath validates the basic syntax of a JSON path: the leading $, closed quotes and balanced brackets
+func checkJSONPath(path string) error {
+	if !strings.HasPrefix(path, "$") {
+		return qerror.Errorf("Invalid JSON path '%s': must start with '$'", path)
+	}
+
+	inQuotes, inBrackets := false, false
+	for i := 1; i < len(path); i++ {
+		c := path[i]
+
+		switch {
+		case c == '\'' || c == '\\':
+			return qerror.Errorf("Invalid JSON path '%s': unexpected character %q", path, c)
+		case inQuotes:
+			if c == '"' {
+				inQuotes = false
+			}
+		case c == '"':
+			inQuotes = true
+		case c == '[':
+			if inBrackets {
+				return qerror.Errorf("Invalid JSON path '%s': nested brackets", path)
+			}
+			inBrackets = true
+		case c == ']':
+			if !inBrackets {
+				return qerror.Errorf("Invalid JSON path '%s': unbalanced brackets", path)
+			}
+			inBrackets = false
+		case c == '.' && (i+1 == len(path) || path[i+1] == '.' || path[i+1] == '['):
+			return qerror.Errorf("Invalid JSON path '%s': empty member name", path)
+		}
+	}
+
+	if inQuotes {
+		return qerror.Errorf("Invalid JSON path '%s': unclosed quotes", path)
+	}
+	if inBrackets {
+		return qerror.Errorf("Invalid JSON path '%s': unbalanced brackets", path)
+	}
+
+	return nil
+}
+
+type MatchMode int
+
+const (
+	NaturalLanguage MatchMode = iota
+	Boolean
+	QueryExpansion
+)
+
+// Match
+type match struct {
+	fieldsNames []string
+	query       string
+	mode        MatchMode
+}
+
+// Match is the MATCH ... AGAINST full-text search, the fields must correspond to a fulltext index of the model.
+// Used as a column or in ORDER BY it is the relevance score.
+func Match(fieldsNames []string, query string, mode MatchMode) *match {
+	return &match{fieldsNames, query, mode}
+}
+
+func (e *match) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).match(e)
+}
+
+// EscapeBooleanQuery replaces the boolean mode operators in s with spaces, so the user input is searched as plain words
+func EscapeBooleanQuery(s string) string {
+	return strings.Map(func(c rune) rune {
+		if strings.ContainsRune(`+-><()~*"@`, c) {
+			return ' '
+		}
+		return c
+	}, s)
+}
+
+type fullTextIndexedModel interface {
+	GetFullTextIndexes() []FullTextIndex
+}
+
+// hasFullTextIndex reports whether the model has a fulltext index over exactly the fields, in any order
+func hasFullTextIndex(m model.IModel, fieldsNames []string) bool {
+	im, ok := m.(fullTextIndexedModel)
+	if !ok {
+		return false
+	}
+
+	for _, index := range im.GetFullTextIndexes() {
+		if len(index.FieldNames) != len(fieldsNames) {
+			continue
+		}
+
+		found := true
+		for _, name := range fieldsNames {
+			if !containsString(index.FieldNames, name) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Regexp
+type regexpMatch struct {
+	op      model.IExpression
+	pattern string
+	not     bool
+	flags   string
+	useFunc bool
+}
+
+// Regexp matches the rows where op matches the pattern (op REGEXP ?), the pattern is always a bound argument
+func Regexp(op model.IExpression, pattern string) *regexpMatch {
+	return &regexpMatch{op: op, pattern: pattern}
+}
+
+func NotRegexp(op model.IExpression, pattern string) *regexpMatch {
+	return &regexpMatch{op: op, pattern: pattern, not: true}
+}
+
+// Flags switches to the REGEXP_LIKE function form (MySQL 8.0+) with the match flags, e.g. "i" or "im"
+func (e *regexpMatch) Flags(flags string) *regexpMatch {
+	e.flags, e.useFunc = flags, true
+	return e
+}
+
+func (e *regexpMatch) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).regexpMatch(e)
+}
+
+// checkRegexp reports the pattern errors which are invalid in any regexp dialect. MySQL uses ICU regular
+// expressions, so the constructs Go does not support (e.g. lookarounds) are left to the server.
+func checkRegexp(pattern string) error {
+	_, err := syntax.Parse(pattern, syntax.Perl)
+	if err, ok := err.(*syntax.Error); ok {
+		switch err.Code {
+		case syntax.ErrMissingBracket, syntax.ErrMissingParen, syntax.ErrUnexpectedParen,
+			syntax.ErrTrailingBackslash, syntax.ErrMissingRepeatArgument, syntax.ErrInvalidCharRange:
+			return qerror.Errorf("Invalid regular expression '%s': %s", pattern, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// Tuple
+type tuple struct {
+	ops []model.IExpression
+}
+
+// Tuple is a row constructor (a, b, ...), the elements may be nested tuples. The values compared with
+// a nested tuple are passed as a slice.
+func Tuple(ops ...model.IExpression) *tuple { return &tuple{ops} }
+
+func (e *tuple) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).tuple(e)
+}
+
+func (e *tuple) Eq(values ...interface{}) *tupleCompare { return &tupleCompare{e, "=", values} }
+func (e *tuple) Ne(values ...interface{}) *tupleCompare { return &tupleCompare{e, "<>", values} }
+func (e *tuple) Lt(values ...interface{}) *tupleCompare { return &tupleCompare{e, "<", values} }
+func (e *tuple) Le(values ...interface{}) *tupleCompare { return &tupleCompare{e, "<=", values} }
+func (e *tuple) Gt(values ...interface{}) *tupleCompare { return &tupleCompare{e, ">", values} }
+func (e *tuple) Ge(values ...interface{}) *tupleCompare { return &tupleCompare{e, ">=", values} }
+
+// In matches the rows where the tuple is equal to one of the rows of values
+func (e *tuple) In(rows ...[]interface{}) *tupleIn { return &tupleIn{e, rows, false} }
+
+// NotIn matches the rows where the tuple is not equal to any of the rows of values
+func (e *tuple) NotIn(rows ...[]interface{}) *tupleIn { return &tupleIn{e, rows, true} }
+
+// TupleCompare
+type tupleCompare struct {
+	t        *tuple
+	operator string
+	values   []interface{}
+}
+
+func (e *tupleCompare) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).tupleCompare(e)
+}
+
+// TupleIn
+type tupleIn struct {
+	t    *tuple
+	rows [][]interface{}
+	not  bool
+}
+
+func (e *tupleIn) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).tupleIn(e)
+}
+
+// flattenTuple returns the leaf operands of the tuple paired with the values, the arity of the values is
+// checked at every nesting level
+func flattenTuple(t *tuple, values []interface{}, ops []model.IExpression, res []interface{}) ([]model.IExpression, []interface{}, error) {
+	if len(t.ops) == 0 {
+		return nil, nil, qerror.Errorf("Empty tuple")
+	}
+	if len(values) != len(t.ops) {
+		return nil, nil, qerror.Errorf("The tuple has %d elements, got %d values", len(t.ops), len(values))
+	}
+
+	for i, op := range t.ops {
+		if nested, ok := op.(*tuple); ok {
+			nestedValues, err := sliceValues(values[i])
+			if err != nil {
+				return nil, nil, qerror.Errorf("The value for the nested tuple %d: %s", i, err.Error())
+			}
+			if ops, res, err = flattenTuple(nested, nestedValues, ops, res); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		ops, res = append(ops, op), append(res, values[i])
+	}
+
+	return ops, res, nil
+}
+
+// Collate
+type collate struct {
+	op        model.IExpression
+	collation string
+}
+
+// Collate overrides the collation of op, e.g. expr.Eq(Collate(field, "utf8mb4_0900_as_ci"), value)
+// for an accent-sensitive comparison on an accent-insensitive column
+func Collate(op model.IExpression, collation string) *collate { return &collate{op, collation} }
+
+func (e *collate) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).collate(e)
+}
+
+// DefaultFoldCollation is the case-insensitive collation used by EqFold and LikeFold by default,
+// see MySQL.SetFoldCollation
+const DefaultFoldCollation = "utf8mb4_0900_ai_ci"
+
+// Fold
+type fold struct {
+	op        model.IExpression
+	value     string
+	like      bool
+	lower     bool
+	collation string
+}
+
+// EqFold compares op with the value case-insensitively regardless of the column collation. By default
+// the comparison gets an explicit COLLATE clause with the fold collation, which keeps the index on op usable
+// if the column has the same collation; see UseLower for the other strategy.
+func EqFold(op model.IExpression, value string) *fold { return &fold{op: op, value: value} }
+
+// LikeFold is the case-insensitive LIKE, the pattern is used as is
+func LikeFold(op model.IExpression, pattern string) *fold {
+	return &fold{op: op, value: pattern, like: true}
+}
+
+// UseLower applies LOWER() to both sides instead of COLLATE, it works with any character set
+// but makes the indexes on op unusable
+func (e *fold) UseLower() *fold {
+	e.lower = true
+	return e
+}
+
+// Collation overrides the fold collation of the storage for the comparison
+func (e *fold) Collation(collation string) *fold {
+	e.collation = collation
+	return e
+}
+
+func (e *fold) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).fold(e)
+}
+
+// Exists
+type exists struct {
+	model  model.IModel
+	filter model.IExpression
+	not    bool
+}
+
+// Exists matches the rows for which the subquery over the model returns any row. The subquery table
+// is aliased as sq<n>, where n is the nesting level, its filter may refer to the outer query with OuterCol.
+func Exists(m model.IModel, filter model.IExpression) *exists { return &exists{m, filter, false} }
+
+func NotExists(m model.IModel, filter model.IExpression) *exists { return &exists{m, filter, true} }
+
+func (e *exists) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).exists(e)
+}
+
+// OuterCol
+type outerCol struct {
+	name string
+}
+
+// OuterCol refers to a column of the query enclosing the Exists subquery
+func OuterCol(fieldName string) *outerCol { return &outerCol{fieldName} }
+
+func (e *outerCol) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).outerCol(e)
+}
+
+// Case
+type caseWhen struct {
+	conds, results []model.IExpression
+	elseResult     model.IExpression
+}
+
+// Case starts a CASE WHEN ... THEN ... ELSE ... END expression, the conditions and the results
+// may be any expressions, e.g. Func("SUM", Case().When(cond, expr.Value(1)).Else(expr.Value(0)))
+func Case() *caseWhen { return &caseWhen{} }
+
+func (e *caseWhen) When(cond, result model.IExpression) *caseWhen {
+	e.conds = append(e.conds, cond)
+	e.results = append(e.results, result)
+	return e
+}
+
+func (e *caseWhen) Else(result model.IExpression) *caseWhen {
+	e.elseResult = result
+	return e
+}
+
+func (e *caseWhen) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).caseWhen(e)
+}
+
+// Bit
+type bitOp struct {
+	op       model.IExpression
+	operator string
+	mask     uint64
+}
+
+// BitAnd is op & mask, the mask must fit the width of the op field
+func BitAnd(op model.IExpression, mask uint64) *bitOp { return &bitOp{op, "&", mask} }
+func BitOr(op model.IExpression, mask uint64) *bitOp  { return &bitOp{op, "|", mask} }
+func BitXor(op model.IExpression, mask uint64) *bitOp { return &bitOp{op, "^", mask} }
+
+// BitNot is ~op, the result is a 64 bit unsigned integer whatever the width of op is
+func BitNot(op model.IExpression) *bitOp { return &bitOp{op, "~", 0} }
+
+// SetFlag is the update value which sets the bits of the mask, e.g. Edit(ctx, filter, map[string]interface{}{"flags": SetFlag(...)})
+func SetFlag(op model.IExpression, mask uint64) *bitOp { return BitOr(op, mask) }
+
+// ClearFlag is the update value which clears the bits of the mask
+func ClearFlag(op model.IExpression, mask uint64) *bitOp { return &bitOp{op, "&~", mask} }
+
+func (e *bitOp) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).bitOp(e)
+}
+
+// HasFlag
+type hasFlag struct {
+	op   model.IExpression
+	mask uint64
+}
+
+// HasFlag matches the rows where all the bits of the mask are set in op
+func HasFlag(op model.IExpression, mask uint64) *hasFlag { return &hasFlag{op, mask} }
+
+func (e *hasFlag) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).hasFlag(e)
+}
+
+// checkMask checks that the mask fits the integer field the expression refers to
+func checkMask(op model.IExpression, mask uint64) error {
+	field := fieldDefinitionOf(op)
+	if field == nil {
+		return nil
+	}
+
+	t := field.GetType()
+	// The nullable fields are pointers
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	var bits uint
+	switch {
+	case isUintKind(t.Kind()):
+		bits = uint(t.Bits())
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Int64:
+		bits = uint(t.Bits()) - 1
+	default:
+		return qerror.Errorf("The field '%s' is not an integer and cannot be used with bitwise operators", field.GetId())
+	}
+
+	if bits < 64 && mask >= 1<<bits {
+		return qerror.Errorf("The mask %#x does not fit the field '%s'", mask, field.GetId())
+	}
+
+	return nil
+}
+
+// InSet
+type inSet struct {
+	op    model.IExpression
+	value string
+	not   bool
+}
+
+// InSetColumn matches the rows where the value is an element of the comma separated list in op, it is
+// the membership test for the SET columns as well. The value must not contain commas.
+func InSetColumn(op model.IExpression, value string) *inSet { return &inSet{op, value, false} }
+
+func NotInSetColumn(op model.IExpression, value string) *inSet { return &inSet{op, value, true} }
+
+func (e *inSet) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).inSet(e)
+}
+
+type dateUnit int
+
+const (
+	dateUnitDay dateUnit = iota
+	dateUnitWeek
+	dateUnitMonth
+	dateUnitYear
+)
+
+// DateRange
+type dateRange struct {
+	op       model.IExpression
+	from, to time.Time
+	unit     dateUnit
+	loc      *time.Location
+}
+
+// OnDate matches the rows where op is on the calendar date of date. It is written as the half-open range
+// (op>=day AND op<next day), so the index on op is used unlike with DATE(op)=?. Only the year, month and day
+// of the date are used, the boundaries are computed in the date location of the storage, see MySQL.SetDateLocation.
+func OnDate(op model.IExpression, date time.Time) *dateRange {
+	return &dateRange{op: op, from: date, to: date, unit: dateUnitDay}
+}
+
+// InDateRange matches the rows where op is between the calendar dates from and to, both inclusive
+func InDateRange(op model.IExpression, from, to time.Time) *dateRange {
+	return &dateRange{op: op, from: from, to: to, unit: dateUnitDay}
+}
+
+// InWeek matches the rows where op is in the week (Monday to Sunday) of the date
+func InWeek(op model.IExpression, date time.Time) *dateRange {
+	return &dateRange{op: op, from: date, to: date, unit: dateUnitWeek}
+}
+
+func InMonth(op model.IExpression, date time.Time) *dateRange {
+	return &dateRange{op: op, from: date, to: date, unit: dateUnitMonth}
+}
+
+func InYear(op model.IExpression, date time.Time) *dateRange {
+	return &dateRange{op: op, from: date, to: date, unit: dateUnitYear}
+}
+
+// In overrides the date location of the storage for the expression
+func (e *dateRange) In(loc *time.Location) *dateRange {
+	e.loc = loc
+	return e
+}
+
+func (e *dateRange) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).dateRange(e)
+}
+
+// bounds returns the half-open range [start, end) of the expression, the boundaries are computed in loc
+// unless the expression has its own location
+func (e *dateRange) bounds(loc *time.Location) (start, end time.Time) {
+	if e.loc != nil {
+		loc = e.loc
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	y, m, d := e.from.Date()
+	toY, toM, toD := e.to.Date()
+
+	switch e.unit {
+	case dateUnitWeek:
+		weekday := (int(e.from.Weekday()) + 6) % 7 // Monday is 0
+		return time.Date(y, m, d-weekday, 0, 0, 0, 0, loc), time.Date(y, m, d-weekday+7, 0, 0, 0, 0, loc)
+	case dateUnitMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, loc), time.Date(y, m+1, 1, 0, 0, 0, 0, loc)
+	case dateUnitYear:
+		return time.Date(y, 1, 1, 0, 0, 0, 0, loc), time.Date(y+1, 1, 1, 0, 0, 0, 0, loc)
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, loc), time.Date(toY, toM, toD+1, 0, 0, 0, 0, loc)
+	}
+}
+
+// Window
+type window struct {
+	name      string
+	op        model.IExpression
+	offset    uint
+	def       model.IExpression
+	aggregate *function
+	partition []model.IExpression
+	order     []OrderExpr
+	frame     string
+}
+
+// RowNumber is ROW_NUMBER() OVER (...), the window functions require MySQL 8.0 or MariaDB 10.2. They are allowed
+// in the computed columns and ORDER BY only, the rows are filtered by their results with SelectOptions.OuterFilter.
+func RowNumber() *window { return &window{name: "ROW_NUMBER"} }
+func Rank() *window      { return &window{name: "RANK"} }
+func DenseRank() *window { return &window{name: "DENSE_RANK"} }
+
+// Lag is LAG(op, offset, def) OVER (...), the value of op in the row offset rows before the current one
+// or def if there is no such row. The offset is written as a literal, def may be nil for NULL.
+func Lag(op model.IExpression, offset uint, def model.IExpression) *window {
+	return &window{name: "LAG", op: op, offset: offset, def: def}
+}
+
+// Lead is LEAD(op, offset, def) OVER (...), the value of op in the row offset rows after the current one
+func Lead(op model.IExpression, offset uint, def model.IExpression) *window {
+	return &window{name: "LEAD", op: op, offset: offset, def: def}
+}
+
+// Over computes the aggregate function over the window, e.g. Over(Func("AVG", ...))
+func Over(aggregate *function) *window { return &window{aggregate: aggregate} }
+
+// SumOver is SUM(op) OVER (...), the running total with an ORDER BY
+func SumOver(op model.IExpression) *window { return Over(Func("SUM", op)) }
+
+func (e *window) PartitionBy(ops ...model.IExpression) *window {
+	e.partition = append(e.partition, ops...)
+	return e
+}
+
+func (e *window) OrderBy(order ...OrderExpr) *window {
+	e.order = append(e.order, order...)
+	return e
+}
+
+// Frame sets the frame clause, ROWS or RANGE with the bounds UNBOUNDED PRECEDING, N PRECEDING, CURRENT ROW,
+// N FOLLOWING or UNBOUNDED FOLLOWING, e.g. "ROWS BETWEEN 6 PRECEDING AND CURRENT ROW"
+func (e *window) Frame(frame string) *window {
+	e.frame = frame
+	return e
+}
+
+func (e *window) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).window(e)
+}
+
+var windowFrameRe = regexp.MustCompile(`^(?i)(ROWS|RANGE)\s+(BETWEEN\s+(UNBOUNDED\s+PRECEDING|\d+\s+PRECEDING|CURRENT\s+ROW|\d+\s+FOLLOWING)\s+AND\s+(UNBOUNDED\s+FOLLOWING|\d+\s+PRECEDING|CURRENT\s+ROW|\d+\s+FOLLOWING)|UNBOUNDED\s+PRECEDING|\d+\s+PRECEDING|CURRENT\s+ROW)$`)
+
+// ColumnAlias
+type columnAlias struct {
+	alias string
+}
+
+// ColumnAlias refers to the computed column of SelectOptions.Columns by its alias in OuterFilter, HAVING
+// and ORDER BY
+func ColumnAlias(alias string) *columnAlias { return &columnAlias{alias} }
+
+func (e *columnAlias) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).columnAlias(e)
+}
@@ -3,6 +3,8 @@
 package mysql
 
 import (
+	"strings"
+
 	"github.com/go-qbit/model"
 )
 
@@ -11,3 +13,13 @@ type IMysqlFieldDefinition interface {
 	IsAutoIncremented() bool
 	WriteSQL(buf *SqlBuffer)
 }
+
+// quoteValues writes the values of the SET column definition as the comma separated string literals
+func quoteValues(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = "'" + strings.NewReplacer(`\`, `\\`, `'`, `''`).Replace(value) + "'"
+	}
+
+	return strings.Join(quoted, ",")
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"reflect"
 	"strconv"
+	"time"
 
 	"github.com/go-qbit/model"
 	"github.com/go-qbit/rbac"
@@ -14,12 +15,16 @@ import (
 type DateField struct {
 	Id             string
 	Caption        string
+	ZeroDate       ZeroDatePolicy
+	AllowZeroTime  bool
 	NotNull        bool
 	Default        *string
 	ViewPermission *rbac.Permission
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *DateField) GetId() string      { return f.Id }
@@ -80,9 +85,19 @@ func (f *DateField) Clean(ctx context.Context, v interface{}) (interface{}, erro
 	return v, nil
 }
 func (f *DateField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &DateField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &DateField{id, caption, f.ZeroDate, f.AllowZeroTime, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *DateField) IsAutoIncremented() bool                    { return false }
+func (f *DateField) IsSensitive() bool                          { return f.Sensitive }
+func (f *DateField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *DateField) GetZeroDatePolicy() ZeroDatePolicy          { return f.ZeroDate }
+func (f *DateField) IsEncoded() bool                            { return true }
+func (f *DateField) EncodeValue(v interface{}) (interface{}, error) {
+	return encodeTemporal(f.Id, f.NotNull && !f.AllowZeroTime, v)
+}
+func (f *DateField) DecodeValue(v interface{}) (interface{}, error) {
+	return decodeTemporal(f.Id, f.ZeroDate, "0000-00-00", v)
 }
-func (f *DateField) IsAutoIncremented() bool { return false }
 func (f *DateField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -109,6 +124,8 @@ type TimeField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *TimeField) GetId() string      { return f.Id }
@@ -169,9 +186,11 @@ func (f *TimeField) Clean(ctx context.Context, v interface{}) (interface{}, erro
 	return v, nil
 }
 func (f *TimeField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &TimeField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &TimeField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *TimeField) IsAutoIncremented() bool { return false }
+func (f *TimeField) IsAutoIncremented() bool                    { return false }
+func (f *TimeField) IsSensitive() bool                          { return f.Sensitive }
+func (f *TimeField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *TimeField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -192,12 +211,16 @@ func (f *TimeField) WriteSQL(sqlBuf *SqlBuffer) {
 type TimeStampField struct {
 	Id             string
 	Caption        string
+	ZeroDate       ZeroDatePolicy
+	AllowZeroTime  bool
 	NotNull        bool
 	Default        *string
 	ViewPermission *rbac.Permission
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *TimeStampField) GetId() string      { return f.Id }
@@ -260,9 +283,19 @@ func (f *TimeStampField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *TimeStampField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &TimeStampField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &TimeStampField{id, caption, f.ZeroDate, f.AllowZeroTime, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *TimeStampField) IsAutoIncremented() bool                    { return false }
+func (f *TimeStampField) IsSensitive() bool                          { return f.Sensitive }
+func (f *TimeStampField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *TimeStampField) GetZeroDatePolicy() ZeroDatePolicy          { return f.ZeroDate }
+func (f *TimeStampField) IsEncoded() bool                            { return true }
+func (f *TimeStampField) EncodeValue(v interface{}) (interface{}, error) {
+	return encodeTemporal(f.Id, f.NotNull && !f.AllowZeroTime, v)
+}
+func (f *TimeStampField) DecodeValue(v interface{}) (interface{}, error) {
+	return decodeTemporal(f.Id, f.ZeroDate, "0000-00-00 00:00:00", v)
 }
-func (f *TimeStampField) IsAutoIncremented() bool { return false }
 func (f *TimeStampField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -283,12 +316,17 @@ func (f *TimeStampField) WriteSQL(sqlBuf *SqlBuffer) {
 type DateTimeField struct {
 	Id             string
 	Caption        string
+	Location       *time.Location
+	ZeroDate       ZeroDatePolicy
+	AllowZeroTime  bool
 	NotNull        bool
 	Default        *string
 	ViewPermission *rbac.Permission
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *DateTimeField) GetId() string      { return f.Id }
@@ -351,9 +389,19 @@ func (f *DateTimeField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *DateTimeField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &DateTimeField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &DateTimeField{id, caption, f.Location, f.ZeroDate, f.AllowZeroTime, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *DateTimeField) IsAutoIncremented() bool                    { return false }
+func (f *DateTimeField) IsSensitive() bool                          { return f.Sensitive }
+func (f *DateTimeField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *DateTimeField) GetZeroDatePolicy() ZeroDatePolicy          { return f.ZeroDate }
+func (f *DateTimeField) IsEncoded() bool                            { return true }
+func (f *DateTimeField) EncodeValue(v interface{}) (interface{}, error) {
+	return encodeTemporal(f.Id, f.NotNull && !f.AllowZeroTime, v)
+}
+func (f *DateTimeField) DecodeValue(v interface{}) (interface{}, error) {
+	return decodeTemporal(f.Id, f.ZeroDate, "0000-00-00 00:00:00", v)
 }
-func (f *DateTimeField) IsAutoIncremented() bool { return false }
 func (f *DateTimeField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -380,6 +428,8 @@ type YearField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *YearField) GetId() string      { return f.Id }
@@ -440,9 +490,11 @@ func (f *YearField) Clean(ctx context.Context, v interface{}) (interface{}, erro
 	return v, nil
 }
 func (f *YearField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &YearField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &YearField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *YearField) IsAutoIncremented() bool { return false }
+func (f *YearField) IsAutoIncremented() bool                    { return false }
+func (f *YearField) IsSensitive() bool                          { return f.Sensitive }
+func (f *YearField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *YearField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -469,6 +521,8 @@ type TinyBlobField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value []byte) error
 	CleanFunc      func(ctx context.Context, value []byte) ([]byte, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *TinyBlobField) GetId() string      { return f.Id }
@@ -531,9 +585,11 @@ func (f *TinyBlobField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *TinyBlobField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &TinyBlobField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &TinyBlobField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *TinyBlobField) IsAutoIncremented() bool { return false }
+func (f *TinyBlobField) IsAutoIncremented() bool                    { return false }
+func (f *TinyBlobField) IsSensitive() bool                          { return f.Sensitive }
+func (f *TinyBlobField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *TinyBlobField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -554,12 +610,16 @@ func (f *TinyBlobField) WriteSQL(sqlBuf *SqlBuffer) {
 type BlobField struct {
 	Id             string
 	Caption        string
+	Compressed     *Compression
+	Encrypted      *Encryption
 	NotNull        bool
 	Default        *[]byte
 	ViewPermission *rbac.Permission
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value []byte) error
 	CleanFunc      func(ctx context.Context, value []byte) ([]byte, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *BlobField) GetId() string      { return f.Id }
@@ -572,6 +632,10 @@ func (f *BlobField) GetType() reflect.Type {
 	}
 }
 func (f *BlobField) GetStorageType() string {
+	if f.Encrypted != nil {
+		return f.Encrypted.storageType("BLOB", 0)
+	}
+
 	res := "BLOB"
 
 	return res
@@ -620,13 +684,50 @@ func (f *BlobField) Clean(ctx context.Context, v interface{}) (interface{}, erro
 	return v, nil
 }
 func (f *BlobField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &BlobField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &BlobField{id, caption, f.Compressed, f.Encrypted, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *BlobField) IsAutoIncremented() bool                    { return false }
+func (f *BlobField) IsSensitive() bool                          { return f.Sensitive }
+func (f *BlobField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *BlobField) IsCompressed() bool                         { return f.Compressed != nil }
+func (f *BlobField) IsEncrypted() bool                          { return f.Encrypted != nil }
+func (f *BlobField) IsEncoded() bool                            { return f.Compressed != nil || f.Encrypted != nil }
+func (f *BlobField) EncodeValue(v interface{}) (interface{}, error) {
+	var err error
+	if f.Compressed != nil {
+		if v, err = f.Compressed.encodeValue(v); err != nil {
+			return nil, err
+		}
+	}
+	if f.Encrypted != nil {
+		return f.Encrypted.encodeValue(v)
+	}
+	return v, err
+}
+func (f *BlobField) DecodeValue(v interface{}) (interface{}, error) {
+	var err error
+	if f.Encrypted != nil {
+		if v, err = f.Encrypted.decodeValue(v); err != nil {
+			return nil, err
+		}
+	}
+	if f.Compressed != nil {
+		return decodeValue(v)
+	}
+	return v, nil
 }
-func (f *BlobField) IsAutoIncremented() bool { return false }
 func (f *BlobField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
 	sqlBuf.WriteByte(' ')
+	if f.Encrypted != nil {
+		sqlBuf.WriteString(f.Encrypted.storageType("BLOB", 0))
+		if f.NotNull {
+			sqlBuf.WriteString(" NOT NULL")
+		}
+		return
+	}
+
 	sqlBuf.WriteString("BLOB")
 
 	if f.NotNull {
@@ -649,6 +750,8 @@ type MediumBlobField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value []byte) error
 	CleanFunc      func(ctx context.Context, value []byte) ([]byte, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *MediumBlobField) GetId() string      { return f.Id }
@@ -711,9 +814,11 @@ func (f *MediumBlobField) Clean(ctx context.Context, v interface{}) (interface{}
 	return v, nil
 }
 func (f *MediumBlobField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &MediumBlobField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &MediumBlobField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *MediumBlobField) IsAutoIncremented() bool { return false }
+func (f *MediumBlobField) IsAutoIncremented() bool                    { return false }
+func (f *MediumBlobField) IsSensitive() bool                          { return f.Sensitive }
+func (f *MediumBlobField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *MediumBlobField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -740,6 +845,8 @@ type LongBlobField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value []byte) error
 	CleanFunc      func(ctx context.Context, value []byte) ([]byte, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *LongBlobField) GetId() string      { return f.Id }
@@ -802,9 +909,11 @@ func (f *LongBlobField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *LongBlobField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &LongBlobField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &LongBlobField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *LongBlobField) IsAutoIncremented() bool { return false }
+func (f *LongBlobField) IsAutoIncremented() bool                    { return false }
+func (f *LongBlobField) IsSensitive() bool                          { return f.Sensitive }
+func (f *LongBlobField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *LongBlobField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -831,6 +940,8 @@ type BooleanField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value bool) error
 	CleanFunc      func(ctx context.Context, value bool) (bool, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *BooleanField) GetId() string      { return f.Id }
@@ -893,9 +1004,11 @@ func (f *BooleanField) Clean(ctx context.Context, v interface{}) (interface{}, e
 	return v, nil
 }
 func (f *BooleanField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &BooleanField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &BooleanField{id, caption, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *BooleanField) IsAutoIncremented() bool { return false }
+func (f *BooleanField) IsAutoIncremented() bool                    { return false }
+func (f *BooleanField) IsSensitive() bool                          { return f.Sensitive }
+func (f *BooleanField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *BooleanField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -925,6 +1038,8 @@ type TinyIntField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value int8) error
 	CleanFunc      func(ctx context.Context, value int8) (int8, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *TinyIntField) GetId() string      { return f.Id }
@@ -991,9 +1106,11 @@ func (f *TinyIntField) Clean(ctx context.Context, v interface{}) (interface{}, e
 	return v, nil
 }
 func (f *TinyIntField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &TinyIntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &TinyIntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *TinyIntField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *TinyIntField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *TinyIntField) IsSensitive() bool                          { return f.Sensitive }
+func (f *TinyIntField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *TinyIntField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -1037,6 +1154,8 @@ type SmallIntField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value int16) error
 	CleanFunc      func(ctx context.Context, value int16) (int16, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *SmallIntField) GetId() string      { return f.Id }
@@ -1103,9 +1222,11 @@ func (f *SmallIntField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *SmallIntField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &SmallIntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &SmallIntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *SmallIntField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *SmallIntField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *SmallIntField) IsSensitive() bool                          { return f.Sensitive }
+func (f *SmallIntField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *SmallIntField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -1149,6 +1270,8 @@ type MediumIntField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value int32) error
 	CleanFunc      func(ctx context.Context, value int32) (int32, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *MediumIntField) GetId() string      { return f.Id }
@@ -1215,9 +1338,11 @@ func (f *MediumIntField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *MediumIntField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &MediumIntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &MediumIntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *MediumIntField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *MediumIntField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *MediumIntField) IsSensitive() bool                          { return f.Sensitive }
+func (f *MediumIntField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *MediumIntField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -1261,6 +1386,8 @@ type IntField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value int32) error
 	CleanFunc      func(ctx context.Context, value int32) (int32, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *IntField) GetId() string      { return f.Id }
@@ -1281,12 +1408,14 @@ func (f *IntField) GetStorageType() string {
 
 	return res
 }
-func (f *IntField) IsDerivable() bool                                                 { return false }
-func (f *IntField) IsRequired() bool                                                  { return f.NotNull && f.Default == nil && !f.IsAutoIncremented() }
-func (f *IntField) GetViewPermission() *rbac.Permission                               { return f.ViewPermission }
-func (f *IntField) GetEditPermission() *rbac.Permission                               { return f.EditPermission }
-func (f *IntField) GetDependsOn() []string                                            { return nil }
-func (f *IntField) Calc(context.Context, map[string]interface{}) (interface{}, error) { return nil, nil }
+func (f *IntField) IsDerivable() bool                   { return false }
+func (f *IntField) IsRequired() bool                    { return f.NotNull && f.Default == nil && !f.IsAutoIncremented() }
+func (f *IntField) GetViewPermission() *rbac.Permission { return f.ViewPermission }
+func (f *IntField) GetEditPermission() *rbac.Permission { return f.EditPermission }
+func (f *IntField) GetDependsOn() []string              { return nil }
+func (f *IntField) Calc(context.Context, map[string]interface{}) (interface{}, error) {
+	return nil, nil
+}
 func (f *IntField) Check(ctx context.Context, v interface{}) error {
 	if f.CheckFunc == nil {
 		return nil
@@ -1323,9 +1452,11 @@ func (f *IntField) Clean(ctx context.Context, v interface{}) (interface{}, error
 	return v, nil
 }
 func (f *IntField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &IntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &IntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *IntField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *IntField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *IntField) IsSensitive() bool                          { return f.Sensitive }
+func (f *IntField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *IntField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -1369,6 +1500,8 @@ type BigIntField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value int64) error
 	CleanFunc      func(ctx context.Context, value int64) (int64, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *BigIntField) GetId() string      { return f.Id }
@@ -1435,9 +1568,11 @@ func (f *BigIntField) Clean(ctx context.Context, v interface{}) (interface{}, er
 	return v, nil
 }
 func (f *BigIntField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &BigIntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &BigIntField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *BigIntField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *BigIntField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *BigIntField) IsSensitive() bool                          { return f.Sensitive }
+func (f *BigIntField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *BigIntField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -1481,6 +1616,8 @@ type TinyUintField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value uint8) error
 	CleanFunc      func(ctx context.Context, value uint8) (uint8, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *TinyUintField) GetId() string      { return f.Id }
@@ -1549,9 +1686,11 @@ func (f *TinyUintField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *TinyUintField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &TinyUintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &TinyUintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *TinyUintField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *TinyUintField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *TinyUintField) IsSensitive() bool                          { return f.Sensitive }
+func (f *TinyUintField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *TinyUintField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -1597,6 +1736,8 @@ type SmallUintField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value uint16) error
 	CleanFunc      func(ctx context.Context, value uint16) (uint16, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *SmallUintField) GetId() string      { return f.Id }
@@ -1665,9 +1806,11 @@ func (f *SmallUintField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *SmallUintField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &SmallUintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &SmallUintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *SmallUintField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *SmallUintField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *SmallUintField) IsSensitive() bool                          { return f.Sensitive }
+func (f *SmallUintField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *SmallUintField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -1713,6 +1856,8 @@ type MediumUintField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value uint32) error
 	CleanFunc      func(ctx context.Context, value uint32) (uint32, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *MediumUintField) GetId() string      { return f.Id }
@@ -1781,9 +1926,11 @@ func (f *MediumUintField) Clean(ctx context.Context, v interface{}) (interface{}
 	return v, nil
 }
 func (f *MediumUintField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &MediumUintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &MediumUintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *MediumUintField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *MediumUintField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *MediumUintField) IsSensitive() bool                          { return f.Sensitive }
+func (f *MediumUintField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *MediumUintField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -1829,6 +1976,8 @@ type UintField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value uint32) error
 	CleanFunc      func(ctx context.Context, value uint32) (uint32, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *UintField) GetId() string      { return f.Id }
@@ -1895,9 +2044,11 @@ func (f *UintField) Clean(ctx context.Context, v interface{}) (interface{}, erro
 	return v, nil
 }
 func (f *UintField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &UintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &UintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *UintField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *UintField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *UintField) IsSensitive() bool                          { return f.Sensitive }
+func (f *UintField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *UintField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -1943,6 +2094,8 @@ type BigUintField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value uint64) error
 	CleanFunc      func(ctx context.Context, value uint64) (uint64, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *BigUintField) GetId() string      { return f.Id }
@@ -2011,9 +2164,11 @@ func (f *BigUintField) Clean(ctx context.Context, v interface{}) (interface{}, e
 	return v, nil
 }
 func (f *BigUintField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &BigUintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &BigUintField{id, caption, f.Length, false, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *BigUintField) IsAutoIncremented() bool { return f.AutoIncrement }
+func (f *BigUintField) IsAutoIncremented() bool                    { return f.AutoIncrement }
+func (f *BigUintField) IsSensitive() bool                          { return f.Sensitive }
+func (f *BigUintField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *BigUintField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -2059,6 +2214,8 @@ type RealField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value float64) error
 	CleanFunc      func(ctx context.Context, value float64) (float64, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *RealField) GetId() string      { return f.Id }
@@ -2123,9 +2280,11 @@ func (f *RealField) Clean(ctx context.Context, v interface{}) (interface{}, erro
 	return v, nil
 }
 func (f *RealField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &RealField{id, caption, f.Length, f.Decimals, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &RealField{id, caption, f.Length, f.Decimals, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *RealField) IsAutoIncremented() bool { return false }
+func (f *RealField) IsAutoIncremented() bool                    { return false }
+func (f *RealField) IsSensitive() bool                          { return f.Sensitive }
+func (f *RealField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *RealField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -2170,6 +2329,8 @@ type FloatField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value float64) error
 	CleanFunc      func(ctx context.Context, value float64) (float64, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *FloatField) GetId() string      { return f.Id }
@@ -2190,8 +2351,10 @@ func (f *FloatField) GetStorageType() string {
 
 	return res
 }
-func (f *FloatField) IsDerivable() bool                   { return false }
-func (f *FloatField) IsRequired() bool                    { return f.NotNull && f.Default == nil && !f.IsAutoIncremented() }
+func (f *FloatField) IsDerivable() bool { return false }
+func (f *FloatField) IsRequired() bool {
+	return f.NotNull && f.Default == nil && !f.IsAutoIncremented()
+}
 func (f *FloatField) GetViewPermission() *rbac.Permission { return f.ViewPermission }
 func (f *FloatField) GetEditPermission() *rbac.Permission { return f.EditPermission }
 func (f *FloatField) GetDependsOn() []string              { return nil }
@@ -2234,9 +2397,11 @@ func (f *FloatField) Clean(ctx context.Context, v interface{}) (interface{}, err
 	return v, nil
 }
 func (f *FloatField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &FloatField{id, caption, f.Length, f.Decimals, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &FloatField{id, caption, f.Length, f.Decimals, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *FloatField) IsAutoIncremented() bool { return false }
+func (f *FloatField) IsAutoIncremented() bool                    { return false }
+func (f *FloatField) IsSensitive() bool                          { return f.Sensitive }
+func (f *FloatField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *FloatField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -2281,6 +2446,8 @@ type DecimalField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *DecimalField) GetId() string      { return f.Id }
@@ -2347,9 +2514,11 @@ func (f *DecimalField) Clean(ctx context.Context, v interface{}) (interface{}, e
 	return v, nil
 }
 func (f *DecimalField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &DecimalField{id, caption, f.Length, f.Decimals, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &DecimalField{id, caption, f.Length, f.Decimals, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *DecimalField) IsAutoIncremented() bool { return false }
+func (f *DecimalField) IsAutoIncremented() bool                    { return false }
+func (f *DecimalField) IsSensitive() bool                          { return f.Sensitive }
+func (f *DecimalField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *DecimalField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -2394,6 +2563,8 @@ type NumericField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *NumericField) GetId() string      { return f.Id }
@@ -2460,9 +2631,11 @@ func (f *NumericField) Clean(ctx context.Context, v interface{}) (interface{}, e
 	return v, nil
 }
 func (f *NumericField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &NumericField{id, caption, f.Length, f.Decimals, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &NumericField{id, caption, f.Length, f.Decimals, f.Zerofill, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *NumericField) IsAutoIncremented() bool { return false }
+func (f *NumericField) IsAutoIncremented() bool                    { return false }
+func (f *NumericField) IsSensitive() bool                          { return f.Sensitive }
+func (f *NumericField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *NumericField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -2505,6 +2678,8 @@ type BitField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *BitField) GetId() string      { return f.Id }
@@ -2525,12 +2700,14 @@ func (f *BitField) GetStorageType() string {
 
 	return res
 }
-func (f *BitField) IsDerivable() bool                                                 { return false }
-func (f *BitField) IsRequired() bool                                                  { return f.NotNull && f.Default == nil && !f.IsAutoIncremented() }
-func (f *BitField) GetViewPermission() *rbac.Permission                               { return f.ViewPermission }
-func (f *BitField) GetEditPermission() *rbac.Permission                               { return f.EditPermission }
-func (f *BitField) GetDependsOn() []string                                            { return nil }
-func (f *BitField) Calc(context.Context, map[string]interface{}) (interface{}, error) { return nil, nil }
+func (f *BitField) IsDerivable() bool                   { return false }
+func (f *BitField) IsRequired() bool                    { return f.NotNull && f.Default == nil && !f.IsAutoIncremented() }
+func (f *BitField) GetViewPermission() *rbac.Permission { return f.ViewPermission }
+func (f *BitField) GetEditPermission() *rbac.Permission { return f.EditPermission }
+func (f *BitField) GetDependsOn() []string              { return nil }
+func (f *BitField) Calc(context.Context, map[string]interface{}) (interface{}, error) {
+	return nil, nil
+}
 func (f *BitField) Check(ctx context.Context, v interface{}) error {
 	if f.CheckFunc == nil {
 		return nil
@@ -2567,9 +2744,11 @@ func (f *BitField) Clean(ctx context.Context, v interface{}) (interface{}, error
 	return v, nil
 }
 func (f *BitField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &BitField{id, caption, f.Length, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &BitField{id, caption, f.Length, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *BitField) IsAutoIncremented() bool { return false }
+func (f *BitField) IsAutoIncremented() bool                    { return false }
+func (f *BitField) IsSensitive() bool                          { return f.Sensitive }
+func (f *BitField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *BitField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -2603,6 +2782,8 @@ type BinaryField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value []byte) error
 	CleanFunc      func(ctx context.Context, value []byte) ([]byte, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *BinaryField) GetId() string      { return f.Id }
@@ -2669,9 +2850,11 @@ func (f *BinaryField) Clean(ctx context.Context, v interface{}) (interface{}, er
 	return v, nil
 }
 func (f *BinaryField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &BinaryField{id, caption, f.Length, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &BinaryField{id, caption, f.Length, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *BinaryField) IsAutoIncremented() bool { return false }
+func (f *BinaryField) IsAutoIncremented() bool                    { return false }
+func (f *BinaryField) IsSensitive() bool                          { return f.Sensitive }
+func (f *BinaryField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *BinaryField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -2705,6 +2888,8 @@ type VarBinaryField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value []byte) error
 	CleanFunc      func(ctx context.Context, value []byte) ([]byte, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *VarBinaryField) GetId() string      { return f.Id }
@@ -2771,9 +2956,11 @@ func (f *VarBinaryField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *VarBinaryField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &VarBinaryField{id, caption, f.Length, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &VarBinaryField{id, caption, f.Length, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *VarBinaryField) IsAutoIncremented() bool { return false }
+func (f *VarBinaryField) IsAutoIncremented() bool                    { return false }
+func (f *VarBinaryField) IsSensitive() bool                          { return f.Sensitive }
+func (f *VarBinaryField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
 func (f *VarBinaryField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -2803,12 +2990,15 @@ type CharField struct {
 	Length         int
 	Charset        string
 	Collate        string
+	UTF8           UTF8Policy
 	NotNull        bool
 	Default        *string
 	ViewPermission *rbac.Permission
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *CharField) GetId() string      { return f.Id }
@@ -2873,9 +3063,16 @@ func (f *CharField) Clean(ctx context.Context, v interface{}) (interface{}, erro
 	return v, nil
 }
 func (f *CharField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &CharField{id, caption, f.Length, f.Charset, f.Collate, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &CharField{id, caption, f.Length, f.Charset, f.Collate, f.UTF8, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *CharField) IsAutoIncremented() bool { return false }
+func (f *CharField) IsAutoIncremented() bool                    { return false }
+func (f *CharField) IsSensitive() bool                          { return f.Sensitive }
+func (f *CharField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *CharField) IsEncoded() bool                            { return f.UTF8 != UTF8Unchecked }
+func (f *CharField) EncodeValue(v interface{}) (interface{}, error) {
+	return encodeUTF8(f.Id, f.UTF8, f.Charset, f.Length, v)
+}
+func (f *CharField) DecodeValue(v interface{}) (interface{}, error) { return v, nil }
 func (f *CharField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -2915,12 +3112,16 @@ type VarCharField struct {
 	Length         int
 	Charset        string
 	Collate        string
+	UTF8           UTF8Policy
+	Encrypted      *Encryption
 	NotNull        bool
 	Default        *string
 	ViewPermission *rbac.Permission
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *VarCharField) GetId() string      { return f.Id }
@@ -2933,6 +3134,10 @@ func (f *VarCharField) GetType() reflect.Type {
 	}
 }
 func (f *VarCharField) GetStorageType() string {
+	if f.Encrypted != nil {
+		return f.Encrypted.storageType("VARCHAR", f.Length)
+	}
+
 	res := "VARCHAR"
 
 	if f.Length != 0 {
@@ -2987,13 +3192,44 @@ func (f *VarCharField) Clean(ctx context.Context, v interface{}) (interface{}, e
 	return v, nil
 }
 func (f *VarCharField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &VarCharField{id, caption, f.Length, f.Charset, f.Collate, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &VarCharField{id, caption, f.Length, f.Charset, f.Collate, f.UTF8, f.Encrypted, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *VarCharField) IsAutoIncremented() bool                    { return false }
+func (f *VarCharField) IsSensitive() bool                          { return f.Sensitive }
+func (f *VarCharField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *VarCharField) IsEncrypted() bool                          { return f.Encrypted != nil }
+func (f *VarCharField) IsEncoded() bool                            { return f.UTF8 != UTF8Unchecked || f.Encrypted != nil }
+func (f *VarCharField) EncodeValue(v interface{}) (interface{}, error) {
+	var err error
+	if v, err = encodeUTF8(f.Id, f.UTF8, f.Charset, f.Length, v); err != nil {
+		return nil, err
+	}
+	if f.Encrypted != nil {
+		return f.Encrypted.encodeValue(v)
+	}
+	return v, err
+}
+func (f *VarCharField) DecodeValue(v interface{}) (interface{}, error) {
+	var err error
+	if f.Encrypted != nil {
+		if v, err = f.Encrypted.decodeValue(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
 }
-func (f *VarCharField) IsAutoIncremented() bool { return false }
 func (f *VarCharField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
 	sqlBuf.WriteByte(' ')
+	if f.Encrypted != nil {
+		sqlBuf.WriteString(f.Encrypted.storageType("VARCHAR", f.Length))
+		if f.NotNull {
+			sqlBuf.WriteString(" NOT NULL")
+		}
+		return
+	}
+
 	sqlBuf.WriteString("VARCHAR")
 
 	if f.Length != 0 {
@@ -3029,6 +3265,7 @@ type TinyTextField struct {
 	Length         int
 	Charset        string
 	Collate        string
+	UTF8           UTF8Policy
 	Binary         bool
 	NotNull        bool
 	Default        *string
@@ -3036,6 +3273,8 @@ type TinyTextField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *TinyTextField) GetId() string      { return f.Id }
@@ -3102,9 +3341,16 @@ func (f *TinyTextField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *TinyTextField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &TinyTextField{id, caption, f.Length, f.Charset, f.Collate, f.Binary, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &TinyTextField{id, caption, f.Length, f.Charset, f.Collate, f.UTF8, f.Binary, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *TinyTextField) IsAutoIncremented() bool                    { return false }
+func (f *TinyTextField) IsSensitive() bool                          { return f.Sensitive }
+func (f *TinyTextField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *TinyTextField) IsEncoded() bool                            { return f.UTF8 != UTF8Unchecked }
+func (f *TinyTextField) EncodeValue(v interface{}) (interface{}, error) {
+	return encodeUTF8(f.Id, f.UTF8, f.Charset, f.Length, v)
 }
-func (f *TinyTextField) IsAutoIncremented() bool { return false }
+func (f *TinyTextField) DecodeValue(v interface{}) (interface{}, error) { return v, nil }
 func (f *TinyTextField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -3148,13 +3394,18 @@ type TextField struct {
 	Length         int
 	Charset        string
 	Collate        string
+	UTF8           UTF8Policy
 	Binary         bool
+	Compressed     *Compression
+	Encrypted      *Encryption
 	NotNull        bool
 	Default        *string
 	ViewPermission *rbac.Permission
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *TextField) GetId() string      { return f.Id }
@@ -3167,8 +3418,16 @@ func (f *TextField) GetType() reflect.Type {
 	}
 }
 func (f *TextField) GetStorageType() string {
+	if f.Encrypted != nil {
+		return f.Encrypted.storageType("TEXT", f.Length)
+	}
+
 	res := "TEXT"
 
+	if f.Compressed != nil {
+		res = "BLOB"
+	}
+
 	if f.Length != 0 {
 		res += "(" + strconv.Itoa(f.Length) + ")"
 	}
@@ -3219,14 +3478,60 @@ func (f *TextField) Clean(ctx context.Context, v interface{}) (interface{}, erro
 	return v, nil
 }
 func (f *TextField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &TextField{id, caption, f.Length, f.Charset, f.Collate, f.Binary, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &TextField{id, caption, f.Length, f.Charset, f.Collate, f.UTF8, f.Binary, f.Compressed, f.Encrypted, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *TextField) IsAutoIncremented() bool                    { return false }
+func (f *TextField) IsSensitive() bool                          { return f.Sensitive }
+func (f *TextField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *TextField) IsCompressed() bool                         { return f.Compressed != nil }
+func (f *TextField) IsEncrypted() bool                          { return f.Encrypted != nil }
+func (f *TextField) IsEncoded() bool {
+	return f.UTF8 != UTF8Unchecked || f.Compressed != nil || f.Encrypted != nil
+}
+func (f *TextField) EncodeValue(v interface{}) (interface{}, error) {
+	var err error
+	if v, err = encodeUTF8(f.Id, f.UTF8, f.Charset, f.Length, v); err != nil {
+		return nil, err
+	}
+	if f.Compressed != nil {
+		if v, err = f.Compressed.encodeValue(v); err != nil {
+			return nil, err
+		}
+	}
+	if f.Encrypted != nil {
+		return f.Encrypted.encodeValue(v)
+	}
+	return v, err
+}
+func (f *TextField) DecodeValue(v interface{}) (interface{}, error) {
+	var err error
+	if f.Encrypted != nil {
+		if v, err = f.Encrypted.decodeValue(v); err != nil {
+			return nil, err
+		}
+	}
+	if f.Compressed != nil {
+		return decodeValue(v)
+	}
+	return v, nil
 }
-func (f *TextField) IsAutoIncremented() bool { return false }
 func (f *TextField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
 	sqlBuf.WriteByte(' ')
-	sqlBuf.WriteString("TEXT")
+	if f.Encrypted != nil {
+		sqlBuf.WriteString(f.Encrypted.storageType("TEXT", f.Length))
+		if f.NotNull {
+			sqlBuf.WriteString(" NOT NULL")
+		}
+		return
+	}
+
+	if f.Compressed != nil {
+		sqlBuf.WriteString("BLOB")
+	} else {
+		sqlBuf.WriteString("TEXT")
+	}
 
 	if f.Length != 0 {
 		sqlBuf.WriteByte('(')
@@ -3234,16 +3539,16 @@ func (f *TextField) WriteSQL(sqlBuf *SqlBuffer) {
 		sqlBuf.WriteByte(')')
 	}
 
-	if f.Binary {
+	if f.Binary && f.Compressed == nil {
 		sqlBuf.WriteString(" BINARY")
 	}
 
-	if f.Charset != "" {
+	if f.Charset != "" && f.Compressed == nil {
 		sqlBuf.WriteString(" CHARACTER SET ")
 		sqlBuf.WriteValue(f.Charset)
 	}
 
-	if f.Collate != "" {
+	if f.Collate != "" && f.Compressed == nil {
 		sqlBuf.WriteString(" COLLATE ")
 		sqlBuf.WriteValue(f.Collate)
 	}
@@ -3265,6 +3570,7 @@ type MediumTextField struct {
 	Length         int
 	Charset        string
 	Collate        string
+	UTF8           UTF8Policy
 	Binary         bool
 	NotNull        bool
 	Default        *string
@@ -3272,6 +3578,8 @@ type MediumTextField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *MediumTextField) GetId() string      { return f.Id }
@@ -3338,9 +3646,16 @@ func (f *MediumTextField) Clean(ctx context.Context, v interface{}) (interface{}
 	return v, nil
 }
 func (f *MediumTextField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &MediumTextField{id, caption, f.Length, f.Charset, f.Collate, f.Binary, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &MediumTextField{id, caption, f.Length, f.Charset, f.Collate, f.UTF8, f.Binary, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *MediumTextField) IsAutoIncremented() bool                    { return false }
+func (f *MediumTextField) IsSensitive() bool                          { return f.Sensitive }
+func (f *MediumTextField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *MediumTextField) IsEncoded() bool                            { return f.UTF8 != UTF8Unchecked }
+func (f *MediumTextField) EncodeValue(v interface{}) (interface{}, error) {
+	return encodeUTF8(f.Id, f.UTF8, f.Charset, f.Length, v)
 }
-func (f *MediumTextField) IsAutoIncremented() bool { return false }
+func (f *MediumTextField) DecodeValue(v interface{}) (interface{}, error) { return v, nil }
 func (f *MediumTextField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -3384,6 +3699,7 @@ type LongTextField struct {
 	Length         int
 	Charset        string
 	Collate        string
+	UTF8           UTF8Policy
 	Binary         bool
 	NotNull        bool
 	Default        *string
@@ -3391,6 +3707,8 @@ type LongTextField struct {
 	EditPermission *rbac.Permission
 	CheckFunc      func(ctx context.Context, value string) error
 	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
 }
 
 func (f *LongTextField) GetId() string      { return f.Id }
@@ -3457,9 +3775,16 @@ func (f *LongTextField) Clean(ctx context.Context, v interface{}) (interface{},
 	return v, nil
 }
 func (f *LongTextField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
-	return &LongTextField{id, caption, f.Length, f.Charset, f.Collate, f.Binary, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}
+	return &LongTextField{id, caption, f.Length, f.Charset, f.Collate, f.UTF8, f.Binary, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
 }
-func (f *LongTextField) IsAutoIncremented() bool { return false }
+func (f *LongTextField) IsAutoIncremented() bool                    { return false }
+func (f *LongTextField) IsSensitive() bool                          { return f.Sensitive }
+func (f *LongTextField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *LongTextField) IsEncoded() bool                            { return f.UTF8 != UTF8Unchecked }
+func (f *LongTextField) EncodeValue(v interface{}) (interface{}, error) {
+	return encodeUTF8(f.Id, f.UTF8, f.Charset, f.Length, v)
+}
+func (f *LongTextField) DecodeValue(v interface{}) (interface{}, error) { return v, nil }
 func (f *LongTextField) WriteSQL(sqlBuf *SqlBuffer) {
 	sqlBuf.WriteIdentifier(f.Id)
 
@@ -3496,3 +3821,225 @@ func (f *LongTextField) WriteSQL(sqlBuf *SqlBuffer) {
 	}
 
 }
+
+type JSONField struct {
+	Id             string
+	Caption        string
+	Compressed     *Compression
+	NotNull        bool
+	Default        *string
+	ViewPermission *rbac.Permission
+	EditPermission *rbac.Permission
+	CheckFunc      func(ctx context.Context, value string) error
+	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
+}
+
+func (f *JSONField) GetId() string      { return f.Id }
+func (f *JSONField) GetCaption() string { return f.Caption }
+func (f *JSONField) GetType() reflect.Type {
+	if f.NotNull {
+		return reflect.TypeOf(string(""))
+	} else {
+		return reflect.PtrTo(reflect.TypeOf(string("")))
+	}
+}
+func (f *JSONField) GetStorageType() string {
+	res := "JSON"
+
+	if f.Compressed != nil {
+		res = "LONGBLOB"
+	}
+
+	return res
+}
+func (f *JSONField) IsDerivable() bool                   { return false }
+func (f *JSONField) IsRequired() bool                    { return f.NotNull && f.Default == nil && !f.IsAutoIncremented() }
+func (f *JSONField) GetViewPermission() *rbac.Permission { return f.ViewPermission }
+func (f *JSONField) GetEditPermission() *rbac.Permission { return f.EditPermission }
+func (f *JSONField) GetDependsOn() []string              { return nil }
+func (f *JSONField) Calc(context.Context, map[string]interface{}) (interface{}, error) {
+	return nil, nil
+}
+func (f *JSONField) Check(ctx context.Context, v interface{}) error {
+	if f.CheckFunc == nil {
+		return nil
+	}
+	if f.NotNull {
+		return f.CheckFunc(ctx, v.(string))
+	} else {
+		switch val := v.(type) {
+		case *string:
+			var t string
+			if val != nil {
+				t = *val
+			}
+			return f.CheckFunc(ctx, t)
+		case string:
+			return f.CheckFunc(ctx, val)
+		default:
+			panic("UnknownType")
+		}
+	}
+	return nil
+}
+func (f *JSONField) Clean(ctx context.Context, v interface{}) (interface{}, error) {
+	if f.CleanFunc == nil {
+		return v, nil
+	}
+	if f.NotNull {
+		return f.CleanFunc(ctx, v.(string))
+	} else {
+		if v.(*string) != nil {
+			return f.CleanFunc(ctx, *v.(*string))
+		}
+	}
+	return v, nil
+}
+func (f *JSONField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
+	return &JSONField{id, caption, f.Compressed, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *JSONField) IsAutoIncremented() bool                    { return false }
+func (f *JSONField) IsSensitive() bool                          { return f.Sensitive }
+func (f *JSONField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *JSONField) IsCompressed() bool                         { return f.Compressed != nil }
+func (f *JSONField) IsEncoded() bool                            { return f.Compressed != nil }
+func (f *JSONField) EncodeValue(v interface{}) (interface{}, error) {
+	return f.Compressed.encodeValue(v)
+}
+func (f *JSONField) DecodeValue(v interface{}) (interface{}, error) {
+	return decodeValue(v)
+}
+func (f *JSONField) WriteSQL(sqlBuf *SqlBuffer) {
+	sqlBuf.WriteIdentifier(f.Id)
+
+	sqlBuf.WriteByte(' ')
+	if f.Compressed != nil {
+		sqlBuf.WriteString("LONGBLOB")
+	} else {
+		sqlBuf.WriteString("JSON")
+	}
+
+	if f.NotNull {
+		sqlBuf.WriteString(" NOT NULL")
+	}
+
+	if f.Default != nil {
+		sqlBuf.WriteString(" DEFAULT ")
+		sqlBuf.WriteValue(*f.Default)
+	}
+
+}
+
+type SetField struct {
+	Id             string
+	Caption        string
+	Values         []string
+	Charset        string
+	Collate        string
+	NotNull        bool
+	Default        *string
+	ViewPermission *rbac.Permission
+	EditPermission *rbac.Permission
+	CheckFunc      func(ctx context.Context, value string) error
+	CleanFunc      func(ctx context.Context, value string) (string, error)
+	Sensitive      bool
+	ReadMask       func(value interface{}) interface{}
+}
+
+func (f *SetField) GetId() string      { return f.Id }
+func (f *SetField) GetCaption() string { return f.Caption }
+func (f *SetField) GetType() reflect.Type {
+	if f.NotNull {
+		return reflect.TypeOf(string(""))
+	} else {
+		return reflect.PtrTo(reflect.TypeOf(string("")))
+	}
+}
+func (f *SetField) GetStorageType() string {
+	res := "SET"
+
+	res += "(" + quoteValues(f.Values) + ")"
+
+	return res
+}
+func (f *SetField) IsDerivable() bool                   { return false }
+func (f *SetField) IsRequired() bool                    { return f.NotNull && f.Default == nil && !f.IsAutoIncremented() }
+func (f *SetField) GetViewPermission() *rbac.Permission { return f.ViewPermission }
+func (f *SetField) GetEditPermission() *rbac.Permission { return f.EditPermission }
+func (f *SetField) GetDependsOn() []string              { return nil }
+func (f *SetField) Calc(context.Context, map[string]interface{}) (interface{}, error) {
+	return nil, nil
+}
+func (f *SetField) Check(ctx context.Context, v interface{}) error {
+	if f.CheckFunc == nil {
+		return nil
+	}
+	if f.NotNull {
+		return f.CheckFunc(ctx, v.(string))
+	} else {
+		switch val := v.(type) {
+		case *string:
+			var t string
+			if val != nil {
+				t = *val
+			}
+			return f.CheckFunc(ctx, t)
+		case string:
+			return f.CheckFunc(ctx, val)
+		default:
+			panic("UnknownType")
+		}
+	}
+	return nil
+}
+func (f *SetField) Clean(ctx context.Context, v interface{}) (interface{}, error) {
+	if f.CleanFunc == nil {
+		return v, nil
+	}
+	if f.NotNull {
+		return f.CleanFunc(ctx, v.(string))
+	} else {
+		if v.(*string) != nil {
+			return f.CleanFunc(ctx, *v.(*string))
+		}
+	}
+	return v, nil
+}
+func (f *SetField) CloneForFK(id string, caption string, required bool) model.IFieldDefinition {
+	return &SetField{id, caption, f.Values, f.Charset, f.Collate, required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}
+}
+func (f *SetField) IsAutoIncremented() bool                    { return false }
+func (f *SetField) IsSensitive() bool                          { return f.Sensitive }
+func (f *SetField) GetReadMask() func(interface{}) interface{} { return f.ReadMask }
+func (f *SetField) WriteSQL(sqlBuf *SqlBuffer) {
+	sqlBuf.WriteIdentifier(f.Id)
+
+	sqlBuf.WriteByte(' ')
+	sqlBuf.WriteString("SET")
+
+	sqlBuf.WriteByte('(')
+	sqlBuf.WriteString(quoteValues(f.Values))
+	sqlBuf.WriteByte(')')
+
+	if f.Charset != "" {
+		sqlBuf.WriteString(" CHARACTER SET ")
+		sqlBuf.WriteValue(f.Charset)
+	}
+
+	if f.Collate != "" {
+		sqlBuf.WriteString(" COLLATE ")
+		sqlBuf.WriteValue(f.Collate)
+	}
+
+	if f.NotNull {
+		sqlBuf.WriteString(" NOT NULL")
+	}
+
+	if f.Default != nil {
+		sqlBuf.WriteString(" DEFAULT ")
+		sqlBuf.WriteValue(*f.Default)
+	}
+
+}
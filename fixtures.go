@@ -0,0 +1,386 @@
+package mysql
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+	"gopkg.in/yaml.v3"
+)
+
+// FixtureNameKey is the key of the fixture row the row is referenced by, e.g.
+//
+//	user:
+//	  - _name: alice
+//	    name: Alice
+//	phone:
+//	  - fk_user_id: $user.alice.id
+//
+// The string values starting with $ are the references $model.name.field, $$ escapes the dollar sign.
+const FixtureNameKey = "_name"
+
+// FixtureSelection is the rows of the model exported by DumpFixtures
+type FixtureSelection struct {
+	Model  model.IModel
+	Filter model.IExpression
+	// NameField is the field the rows are named by, the rows are not named if it is empty
+	NameField string
+}
+
+type fixtureRow struct {
+	file   string
+	line   int
+	model  model.IModel
+	name   string
+	fields []string
+	values []interface{}
+	refs   map[int]*fixtureRef
+	// loaded are the values of the inserted row the references are resolved with
+	loaded map[string]interface{}
+}
+
+type fixtureRef struct {
+	line                 int
+	model, name, fieldId string
+}
+
+// LoadFixtures loads the YAML or JSON documents of the form model → list of rows, the rows of all the files
+// are inserted in one transaction after the rows they reference. The values are converted to the types of the fields.
+func (s *MySQL) LoadFixtures(ctx context.Context, fsys fs.FS, paths ...string) error {
+	var rows []*fixtureRow
+	for _, path := range paths {
+		fileRows, err := s.parseFixtures(fsys, path)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, fileRows...)
+	}
+
+	named := make(map[string]*fixtureRow)
+	for _, row := range rows {
+		if row.name == "" {
+			continue
+		}
+		key := row.model.GetId() + "." + row.name
+		if prev, exists := named[key]; exists {
+			return qerror.Errorf("%s:%d: The row '%s' of model '%s' is already defined at %s:%d", row.file, row.line, row.name, row.model.GetId(), prev.file, prev.line)
+		}
+		named[key] = row
+	}
+	for _, row := range rows {
+		for _, ref := range row.refs {
+			if _, exists := named[ref.model+"."+ref.name]; !exists {
+				return qerror.Errorf("%s:%d: Unknown row '%s' of model '%s'", row.file, ref.line, ref.name, ref.model)
+			}
+		}
+	}
+
+	levels := make(map[string]int)
+	for _, level := range s.getModelsLevels() {
+		levels[level.name] = level.level
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return levels[rows[i].model.GetId()] < levels[rows[j].model.GetId()] })
+
+	return s.DoInTransaction(ctx, func(ctx context.Context) error {
+		for len(rows) > 0 {
+			pending := rows[:0]
+			for _, row := range rows {
+				if !row.isResolved(named) {
+					pending = append(pending, row)
+					continue
+				}
+				if err := s.loadFixture(ctx, row, named); err != nil {
+					return err
+				}
+			}
+
+			if len(pending) == len(rows) {
+				return qerror.Errorf("%s:%d: The rows of model '%s' reference each other cyclically", pending[0].file, pending[0].line, pending[0].model.GetId())
+			}
+			rows = pending
+		}
+
+		return nil
+	})
+}
+
+// parseFixtures parses the documents of the file
+func (s *MySQL) parseFixtures(fsys fs.FS, path string) ([]*fixtureRow, error) {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rows []*fixtureRow
+	decoder := yaml.NewDecoder(f)
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, qerror.Errorf("%s: %s", path, err.Error())
+		}
+
+		if len(doc.Content) == 0 {
+			continue
+		}
+		root := doc.Content[0]
+		if root.Kind != yaml.MappingNode {
+			return nil, qerror.Errorf("%s:%d: The fixtures must be the map of the models to the lists of the rows", path, root.Line)
+		}
+
+		for i := 0; i < len(root.Content); i += 2 {
+			keyNode, rowsNode := root.Content[i], root.Content[i+1]
+
+			s.modelsMtx.RLock()
+			m := s.models[keyNode.Value]
+			s.modelsMtx.RUnlock()
+			if m == nil {
+				return nil, qerror.Errorf("%s:%d: Unknown model '%s'", path, keyNode.Line, keyNode.Value)
+			}
+			if rowsNode.Kind != yaml.SequenceNode {
+				return nil, qerror.Errorf("%s:%d: The rows of model '%s' must be the list", path, rowsNode.Line, m.GetId())
+			}
+
+			for _, rowNode := range rowsNode.Content {
+				row, err := s.parseFixtureRow(path, m, rowNode)
+				if err != nil {
+					return nil, err
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+func (s *MySQL) parseFixtureRow(path string, m model.IModel, node *yaml.Node) (*fixtureRow, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, qerror.Errorf("%s:%d: The row of model '%s' must be the map of the fields to the values", path, node.Line, m.GetId())
+	}
+
+	row := &fixtureRow{file: path, line: node.Line, model: m, refs: make(map[int]*fixtureRef)}
+	for i := 0; i < len(node.Content); i += 2 {
+		keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+		if keyNode.Value == FixtureNameKey {
+			row.name = valueNode.Value
+			continue
+		}
+
+		field := m.GetFieldDefinition(keyNode.Value)
+		if field == nil || field.IsDerivable() {
+			return nil, qerror.Errorf("%s:%d: Unknown field '%s' in model '%s'", path, keyNode.Line, keyNode.Value, m.GetId())
+		}
+
+		if valueNode.Kind == yaml.ScalarNode && valueNode.Tag == "!!str" && strings.HasPrefix(valueNode.Value, "$") {
+			if strings.HasPrefix(valueNode.Value, "$$") {
+				valueNode.Value = valueNode.Value[1:]
+			} else {
+				parts := strings.Split(valueNode.Value[1:], ".")
+				if len(parts) != 3 {
+					return nil, qerror.Errorf("%s:%d: Invalid reference '%s', it must be $model.name.field", path, valueNode.Line, valueNode.Value)
+				}
+				row.refs[len(row.fields)] = &fixtureRef{valueNode.Line, parts[0], parts[1], parts[2]}
+				row.fields, row.values = append(row.fields, field.GetId()), append(row.values, nil)
+				continue
+			}
+		}
+
+		value, err := s.fixtureValue(field, valueNode)
+		if err != nil {
+			return nil, qerror.Errorf("%s:%d: The field '%s' of model '%s': %s", path, valueNode.Line, field.GetId(), m.GetId(), err.Error())
+		}
+		row.fields, row.values = append(row.fields, field.GetId()), append(row.values, value)
+	}
+
+	return row, nil
+}
+
+// fixtureValue converts the value to the type of the field
+func (s *MySQL) fixtureValue(field model.IFieldDefinition, node *yaml.Node) (interface{}, error) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return nil, nil
+	}
+
+	t := field.GetType()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == bytesType && node.Kind == yaml.ScalarNode && node.Tag != "!!binary" {
+		return []byte(node.Value), nil
+	}
+
+	value := reflect.New(t)
+	if err := node.Decode(value.Interface()); err != nil {
+		return nil, qerror.Errorf("cannot convert '%s' to %s", node.Value, t.String())
+	}
+
+	if t.Kind() == reflect.String {
+		return s.textValue(field, value.Elem().String())
+	}
+
+	return value.Elem().Interface(), nil
+}
+
+func (r *fixtureRow) isResolved(named map[string]*fixtureRow) bool {
+	for _, ref := range r.refs {
+		if named[ref.model+"."+ref.name].loaded == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// loadFixture inserts the row with the references resolved
+func (s *MySQL) loadFixture(ctx context.Context, row *fixtureRow, named map[string]*fixtureRow) error {
+	for i, ref := range row.refs {
+		value, exists := named[ref.model+"."+ref.name].loaded[ref.fieldId]
+		if !exists {
+			return qerror.Errorf("%s:%d: The field '%s' of the row '%s' of model '%s' is not set by the fixtures", row.file, ref.line, ref.fieldId, ref.name, ref.model)
+		}
+		var err error
+		if row.values[i], err = convertFixtureRef(value, row.model.GetFieldDefinition(row.fields[i])); err != nil {
+			return qerror.Errorf("%s:%d: The field '%s' of model '%s': %s", row.file, ref.line, row.fields[i], row.model.GetId(), err.Error())
+		}
+	}
+
+	pk, err := row.model.AddMulti(ctx, model.NewData(row.fields, [][]interface{}{row.values}), model.AddOptions{})
+	if err != nil {
+		return qerror.Errorf("%s:%d: Cannot add the row of model '%s': %s", row.file, row.line, row.model.GetId(), err.Error())
+	}
+
+	row.loaded = make(map[string]interface{}, len(row.fields))
+	for i, fieldId := range row.fields {
+		row.loaded[fieldId] = row.values[i]
+	}
+	for i, fieldId := range pk.Fields() {
+		row.loaded[fieldId] = pk.Data()[0][i]
+	}
+
+	return nil
+}
+
+// convertFixtureRef converts the referenced value to the type of the field
+func convertFixtureRef(value interface{}, field model.IFieldDefinition) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	t := field.GetType()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	rv := reflect.ValueOf(value)
+	switch {
+	case rv.Type() == t:
+		return value, nil
+	case t.Kind() == reflect.String:
+		return fmt.Sprint(value), nil
+	case rv.Kind() != reflect.String && rv.Type().ConvertibleTo(t):
+		return rv.Convert(t).Interface(), nil
+	}
+
+	return nil, qerror.Errorf("cannot convert the referenced value %v to %s", value, t.String())
+}
+
+// DumpFixtures writes the selected rows as the YAML fixtures LoadFixtures loads, the rows are ordered
+// by the primary key. The foreign keys are written as the values, not the references.
+func (s *MySQL) DumpFixtures(ctx context.Context, w io.Writer, selections ...FixtureSelection) error {
+	root := &yaml.Node{Kind: yaml.MappingNode}
+	for _, selection := range selections {
+		m := selection.Model
+
+		var fieldsNames []string
+		for _, fieldName := range m.GetFieldsNames() {
+			if !m.GetFieldDefinition(fieldName).IsDerivable() {
+				fieldsNames = append(fieldsNames, fieldName)
+			}
+		}
+
+		orderBy := make([]model.Order, len(m.GetPKFieldsNames()))
+		for i, fieldName := range m.GetPKFieldsNames() {
+			orderBy[i] = model.Order{FieldName: fieldName}
+		}
+
+		data, err := s.Query(ctx, m, fieldsNames, model.GetAllOptions{Filter: selection.Filter, OrderBy: orderBy})
+		if err != nil {
+			return err
+		}
+
+		rowsNode := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, row := range data.Maps() {
+			rowNode := &yaml.Node{Kind: yaml.MappingNode}
+			if selection.NameField != "" {
+				rowNode.Content = append(rowNode.Content,
+					&yaml.Node{Kind: yaml.ScalarNode, Value: FixtureNameKey},
+					&yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(fixtureDumpValue(row[selection.NameField]))},
+				)
+			}
+			for _, fieldName := range fieldsNames {
+				rowNode.Content = append(rowNode.Content,
+					&yaml.Node{Kind: yaml.ScalarNode, Value: fieldName},
+					fixtureDumpNode(row[fieldName]),
+				)
+			}
+			rowsNode.Content = append(rowsNode.Content, rowNode)
+		}
+
+		root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: m.GetId()}, rowsNode)
+	}
+
+	encoder := yaml.NewEncoder(w)
+	// The default indent of yaml.v3 is 4 spaces
+	encoder.SetIndent(2)
+	if err := encoder.Encode(root); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
+
+// fixtureDumpValue dereferences the value
+func fixtureDumpValue(value interface{}) interface{} {
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		value = rv.Elem().Interface()
+	}
+
+	return value
+}
+
+// fixtureDumpNode returns the node of the value, the strings starting with $ are escaped and the bytes
+// which are not valid UTF-8 are written as !!binary
+func fixtureDumpNode(value interface{}) *yaml.Node {
+	switch value := fixtureDumpValue(value).(type) {
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+	case []byte:
+		if !utf8.Valid(value) {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!binary", Value: base64.StdEncoding.EncodeToString(value)}
+		}
+		return fixtureDumpNode(string(value))
+	case string:
+		if strings.HasPrefix(value, "$") {
+			value = "$" + value
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	default:
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: fmt.Sprint(value)}
+	}
+}
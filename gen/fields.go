@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"go/format"
 	"os"
+	"strings"
 )
 
 var mysqlTypes = []struct {
@@ -14,13 +15,13 @@ var mysqlTypes = []struct {
 	baseClass    IBaseClass
 	goType       string
 }{
-	{"DATE", "Date", EmptyClass{}, "string"},
+	{"DATE", "Date", TemporalClass{}, "string"},
 	{"TIME", "Time", EmptyClass{}, "string"},
-	{"TIMESTAMP", "TimeStamp", EmptyClass{}, "string"},
-	{"DATETIME", "DateTime", EmptyClass{}, "string"},
+	{"TIMESTAMP", "TimeStamp", TemporalClass{}, "string"},
+	{"DATETIME", "DateTime", DateTimeClass{}, "string"},
 	{"YEAR", "Year", EmptyClass{}, "string"},
 	{"TINYBLOB", "TinyBlob", EmptyClass{}, "[]byte"},
-	{"BLOB", "Blob", EmptyClass{}, "[]byte"},
+	{"BLOB", "Blob", CompressedClass{"BLOB", true}, "[]byte"},
 	{"MEDIUMBLOB", "MediumBlob", EmptyClass{}, "[]byte"},
 	{"LONGBLOB", "LongBlob", EmptyClass{}, "[]byte"},
 	{"BOOLEAN", "Boolean", EmptyClass{}, "bool"},
@@ -42,14 +43,15 @@ var mysqlTypes = []struct {
 	{"BINARY", "Binary", BinaryClass{}, "[]byte"},
 	{"VARBINARY", "VarBinary", BinaryClass{}, "[]byte"},
 	{"CHAR", "Char", CharClass{}, "string"},
-	{"VARCHAR", "VarChar", CharClass{}, "string"},
+	{"VARCHAR", "VarChar", EncryptedCharClass{}, "string"},
 	{"TINYTEXT", "TinyText", TextClass{}, "string"},
-	{"TEXT", "Text", TextClass{}, "string"},
+	{"TEXT", "Text", CompressedTextClass{"BLOB", true}, "string"},
 	{"MEDIUMTEXT", "MediumText", TextClass{}, "string"},
 	{"LONGTEXT", "LongText", TextClass{}, "string"},
+	{"JSON", "JSON", CompressedClass{"LONGBLOB", false}, "string"},
+	{"SET", "Set", SetClass{}, "string"},
 	// ToDo:
 	//{"ENUM", "Enum", "ENUM", ""},
-	//{"SET", "Set", "ENUM", ""},
 }
 
 type IBaseClass interface {
@@ -94,16 +96,76 @@ func (BinaryClass) IsUnsigned() bool { return false }
 
 type CharClass struct{}
 
-func (CharClass) Fields() []IField { return []IField{LengthField{}, CharsetField{}, CollateField{}} }
+func (CharClass) Fields() []IField {
+	return []IField{LengthField{}, CharsetField{}, CollateField{}, UTF8Field{}}
+}
 func (CharClass) IsUnsigned() bool { return false }
 
+// EncryptedCharClass is the class of VARCHAR, the encrypted values are stored in VARBINARY
+type EncryptedCharClass struct{}
+
+func (EncryptedCharClass) Fields() []IField {
+	return append(CharClass{}.Fields(), EncryptedField{})
+}
+func (EncryptedCharClass) IsUnsigned() bool { return false }
+
 type TextClass struct{}
 
 func (TextClass) Fields() []IField {
-	return []IField{LengthField{}, CharsetField{}, CollateField{}, &BinaryField{}}
+	return []IField{LengthField{}, CharsetField{}, CollateField{}, UTF8Field{}, &BinaryField{}}
 }
 func (TextClass) IsUnsigned() bool { return false }
 
+// CompressedClass is the class of the types which can be compressed, the compressed values are stored
+// in the blob type. The values of the encrypted class can be encrypted as well.
+type CompressedClass struct {
+	blobType  string
+	encrypted bool
+}
+
+func (c CompressedClass) Fields() []IField {
+	if c.encrypted {
+		return []IField{CompressedField{}, EncryptedField{}}
+	}
+	return []IField{CompressedField{}}
+}
+func (CompressedClass) IsUnsigned() bool   { return false }
+func (c CompressedClass) BlobType() string { return c.blobType }
+
+type CompressedTextClass struct {
+	blobType  string
+	encrypted bool
+}
+
+func (c CompressedTextClass) Fields() []IField {
+	if c.encrypted {
+		return append(TextClass{}.Fields(), CompressedField{}, EncryptedField{})
+	}
+	return append(TextClass{}.Fields(), CompressedField{})
+}
+func (CompressedTextClass) IsUnsigned() bool   { return false }
+func (c CompressedTextClass) BlobType() string { return c.blobType }
+
+// DateTimeClass is the class of DATETIME, the values are stored verbatim so the location of the time.Time values
+// can be set per field
+type DateTimeClass struct{}
+
+func (DateTimeClass) Fields() []IField {
+	return append([]IField{LocationField{}}, TemporalClass{}.Fields()...)
+}
+func (DateTimeClass) IsUnsigned() bool { return false }
+
+// TemporalClass is the class of the types with the zero date, see ZeroDatePolicy
+type TemporalClass struct{}
+
+func (TemporalClass) Fields() []IField { return []IField{ZeroDateField{}, AllowZeroTimeField{}} }
+func (TemporalClass) IsUnsigned() bool { return false }
+
+type SetClass struct{}
+
+func (SetClass) Fields() []IField { return []IField{ValuesField{}, CharsetField{}, CollateField{}} }
+func (SetClass) IsUnsigned() bool { return false }
+
 // Fields
 type AutoIncrementField struct{}
 
@@ -135,11 +197,46 @@ type CharsetField struct{}
 func (CharsetField) Name() string   { return "Charset" }
 func (CharsetField) GoType() string { return "string" }
 
+type ValuesField struct{}
+
+func (ValuesField) Name() string   { return "Values" }
+func (ValuesField) GoType() string { return "[]string" }
+
+type UTF8Field struct{}
+
+func (UTF8Field) Name() string   { return "UTF8" }
+func (UTF8Field) GoType() string { return "UTF8Policy" }
+
+type CompressedField struct{}
+
+func (CompressedField) Name() string   { return "Compressed" }
+func (CompressedField) GoType() string { return "*Compression" }
+
+type EncryptedField struct{}
+
+func (EncryptedField) Name() string   { return "Encrypted" }
+func (EncryptedField) GoType() string { return "*Encryption" }
+
 type CollateField struct{}
 
 func (CollateField) Name() string   { return "Collate" }
 func (CollateField) GoType() string { return "string" }
 
+type LocationField struct{}
+
+func (LocationField) Name() string   { return "Location" }
+func (LocationField) GoType() string { return "*time.Location" }
+
+type ZeroDateField struct{}
+
+func (ZeroDateField) Name() string   { return "ZeroDate" }
+func (ZeroDateField) GoType() string { return "ZeroDatePolicy" }
+
+type AllowZeroTimeField struct{}
+
+func (AllowZeroTimeField) Name() string   { return "AllowZeroTime" }
+func (AllowZeroTimeField) GoType() string { return "bool" }
+
 //
 var typeOf = map[string]string{
 	"string":  `string("")`,
@@ -182,6 +279,7 @@ func main() {
 	buf.WriteString(`"reflect"` + "\n")
 	buf.WriteString(`"strconv"` + "\n")
 	buf.WriteString(`"context"` + "\n")
+	buf.WriteString(`"time"` + "\n")
 	buf.WriteByte('\n')
 	buf.WriteString(`"github.com/go-qbit/model"` + "\n")
 	buf.WriteString(`"github.com/go-qbit/rbac"` + "\n")
@@ -209,6 +307,8 @@ func main() {
 
 		buf.WriteString("CheckFunc func(ctx context.Context, value " + mysqlType.goType + ") error\n")
 		buf.WriteString("CleanFunc func(ctx context.Context, value " + mysqlType.goType + ") (" + mysqlType.goType + ", error)\n")
+		buf.WriteString("Sensitive bool\n")
+		buf.WriteString("ReadMask func(value interface{}) interface{}\n")
 		buf.WriteString("}\n")
 
 		buf.WriteString("func (f *" + typeName + ") GetId() string { return f.Id }\n")
@@ -222,14 +322,43 @@ func main() {
 			"}\n")
 		/**/
 		buf.WriteString("" +
-			"func (f *" + typeName + ") GetStorageType() string {\n" +
-			"	res := \"" + mysqlType.mysqlType + "\"\n\n")
+			"func (f *" + typeName + ") GetStorageType() string {\n")
+
+		// The encrypted values are stored in the binary type
+		_, hasEncrypted := typeFields["Encrypted"]
+		var encryptedLength string
+		if hasEncrypted {
+			encryptedLength = "0"
+			if _, exists := typeFields["Length"]; exists {
+				encryptedLength = "f.Length"
+			}
+			buf.WriteString("" +
+				"	if f.Encrypted != nil {\n" +
+				"		return f.Encrypted.storageType(\"" + mysqlType.mysqlType + "\", " + encryptedLength + ")\n" +
+				"	}\n\n")
+		}
+
+		buf.WriteString("	res := \"" + mysqlType.mysqlType + "\"\n\n")
+
+		// The compressed values are stored in the blob type without the charset
+		var notCompressed string
+		blobClass, compressed := mysqlType.baseClass.(interface{ BlobType() string })
+		if compressed && blobClass.BlobType() != mysqlType.mysqlType {
+			notCompressed = " && f.Compressed == nil"
+			buf.WriteString("" +
+				"	if f.Compressed != nil {\n" +
+				"		res = \"" + blobClass.BlobType() + "\"\n" +
+				"	}\n\n")
+		}
 
 		if _, exists := typeFields["Length"]; exists {
 			buf.WriteString(`if f.Length != 0 {
 				res += "(" + strconv.Itoa(f.Length) + ")"
 			}` + "\n\n")
 		}
+		if _, exists := typeFields["Values"]; exists {
+			buf.WriteString(`res += "(" + quoteValues(f.Values) + ")"` + "\n\n")
+		}
 		if mysqlType.baseClass.IsUnsigned() {
 			buf.WriteString(`res += " UNSIGNED"` + "\n\n")
 		}
@@ -289,7 +418,7 @@ func main() {
 		}
 
 		buf.WriteString("func (f *" + typeName + ") CloneForFK(id string, caption string, required bool) model.IFieldDefinition {\n" +
-			"return &" + typeName + "{id, caption, " + cloneFields + " required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc}\n" +
+			"return &" + typeName + "{id, caption, " + cloneFields + " required, f.Default, f.ViewPermission, f.EditPermission, f.CheckFunc, f.CleanFunc, f.Sensitive, nil}\n" +
 			"}\n")
 
 		buf.WriteString("func (f *" + typeName + ") IsAutoIncremented() bool { return ")
@@ -299,12 +428,136 @@ func main() {
 			buf.WriteString("false")
 		}
 		buf.WriteString(" }\n")
+		buf.WriteString("func (f *" + typeName + ") IsSensitive() bool { return f.Sensitive }\n")
+		buf.WriteString("func (f *" + typeName + ") GetReadMask() func(interface{}) interface{} { return f.ReadMask }\n")
+
+		_, hasCompressed := typeFields["Compressed"]
+		_, hasUTF8 := typeFields["UTF8"]
+		switch {
+		case hasEncrypted:
+			var encodedBy []string
+			if hasUTF8 {
+				encodedBy = append(encodedBy, "f.UTF8 != UTF8Unchecked")
+			}
+			if hasCompressed {
+				buf.WriteString("func (f *" + typeName + ") IsCompressed() bool { return f.Compressed != nil }\n")
+				encodedBy = append(encodedBy, "f.Compressed != nil")
+			}
+			encodedBy = append(encodedBy, "f.Encrypted != nil")
+			buf.WriteString("func (f *" + typeName + ") IsEncrypted() bool { return f.Encrypted != nil }\n")
+			buf.WriteString("func (f *" + typeName + ") IsEncoded() bool { return " + strings.Join(encodedBy, " || ") + " }\n")
+
+			// The values are compressed before the encryption and decompressed after the decryption
+			buf.WriteString("func (f *" + typeName + ") EncodeValue(v interface{}) (interface{}, error) {\n" +
+				"	var err error\n")
+			if hasUTF8 {
+				buf.WriteString("" +
+					"	if v, err = encodeUTF8(f.Id, f.UTF8, f.Charset, f.Length, v); err != nil {\n" +
+					"		return nil, err\n" +
+					"	}\n")
+			}
+			if hasCompressed {
+				buf.WriteString("" +
+					"	if f.Compressed != nil {\n" +
+					"		if v, err = f.Compressed.encodeValue(v); err != nil {\n" +
+					"			return nil, err\n" +
+					"		}\n" +
+					"	}\n")
+			}
+			buf.WriteString("" +
+				"	if f.Encrypted != nil {\n" +
+				"		return f.Encrypted.encodeValue(v)\n" +
+				"	}\n" +
+				"	return v, err\n" +
+				"}\n")
+			buf.WriteString("func (f *" + typeName + ") DecodeValue(v interface{}) (interface{}, error) {\n" +
+				"	var err error\n" +
+				"	if f.Encrypted != nil {\n" +
+				"		if v, err = f.Encrypted.decodeValue(v); err != nil {\n" +
+				"			return nil, err\n" +
+				"		}\n" +
+				"	}\n")
+			if hasCompressed {
+				buf.WriteString("" +
+					"	if f.Compressed != nil {\n" +
+					"		return decodeValue(v)\n" +
+					"	}\n")
+			}
+			buf.WriteString("" +
+				"	return v, nil\n" +
+				"}\n")
+		case hasCompressed && hasUTF8:
+			buf.WriteString("func (f *" + typeName + ") IsCompressed() bool { return f.Compressed != nil }\n")
+			buf.WriteString("func (f *" + typeName + ") IsEncoded() bool { return f.Compressed != nil || f.UTF8 != UTF8Unchecked }\n")
+			buf.WriteString("func (f *" + typeName + ") EncodeValue(v interface{}) (interface{}, error) {\n" +
+				"	v, err := encodeUTF8(f.Id, f.UTF8, f.Charset, f.Length, v)\n" +
+				"	if err != nil || f.Compressed == nil {\n" +
+				"		return v, err\n" +
+				"	}\n" +
+				"	return f.Compressed.encodeValue(v)\n" +
+				"}\n")
+			buf.WriteString("func (f *" + typeName + ") DecodeValue(v interface{}) (interface{}, error) {\n" +
+				"	if f.Compressed == nil {\n" +
+				"		return v, nil\n" +
+				"	}\n" +
+				"	return decodeValue(v)\n" +
+				"}\n")
+		case hasCompressed:
+			buf.WriteString("func (f *" + typeName + ") IsCompressed() bool { return f.Compressed != nil }\n")
+			buf.WriteString("func (f *" + typeName + ") IsEncoded() bool { return f.Compressed != nil }\n")
+			buf.WriteString("func (f *" + typeName + ") EncodeValue(v interface{}) (interface{}, error) {\n" +
+				"	return f.Compressed.encodeValue(v)\n" +
+				"}\n")
+			buf.WriteString("func (f *" + typeName + ") DecodeValue(v interface{}) (interface{}, error) {\n" +
+				"	return decodeValue(v)\n" +
+				"}\n")
+		case hasUTF8:
+			buf.WriteString("func (f *" + typeName + ") IsEncoded() bool { return f.UTF8 != UTF8Unchecked }\n")
+			buf.WriteString("func (f *" + typeName + ") EncodeValue(v interface{}) (interface{}, error) {\n" +
+				"	return encodeUTF8(f.Id, f.UTF8, f.Charset, f.Length, v)\n" +
+				"}\n")
+			buf.WriteString("func (f *" + typeName + ") DecodeValue(v interface{}) (interface{}, error) { return v, nil }\n")
+		}
+
+		if _, exists := typeFields["ZeroDate"]; exists {
+			zeroDate := "0000-00-00 00:00:00"
+			if mysqlType.mysqlType == "DATE" {
+				zeroDate = "0000-00-00"
+			}
+			buf.WriteString("func (f *" + typeName + ") GetZeroDatePolicy() ZeroDatePolicy { return f.ZeroDate }\n")
+			buf.WriteString("func (f *" + typeName + ") IsEncoded() bool { return true }\n")
+			buf.WriteString("func (f *" + typeName + ") EncodeValue(v interface{}) (interface{}, error) {\n" +
+				"	return encodeTemporal(f.Id, f.NotNull && !f.AllowZeroTime, v)\n" +
+				"}\n")
+			buf.WriteString("func (f *" + typeName + ") DecodeValue(v interface{}) (interface{}, error) {\n" +
+				"	return decodeTemporal(f.Id, f.ZeroDate, \"" + zeroDate + "\", v)\n" +
+				"}\n")
+		}
 
 		buf.WriteString("" +
 			"func (f *" + typeName + ") WriteSQL(sqlBuf *SqlBuffer) {\n" +
 			"	sqlBuf.WriteIdentifier(f.Id)\n\n" +
-			"	sqlBuf.WriteByte(' ')\n" +
-			"	sqlBuf.WriteString(\"" + mysqlType.mysqlType + "\")\n\n")
+			"	sqlBuf.WriteByte(' ')\n")
+		if hasEncrypted {
+			buf.WriteString("" +
+				"	if f.Encrypted != nil {\n" +
+				"		sqlBuf.WriteString(f.Encrypted.storageType(\"" + mysqlType.mysqlType + "\", " + encryptedLength + "))\n" +
+				"		if f.NotNull {\n" +
+				"			sqlBuf.WriteString(\" NOT NULL\")\n" +
+				"		}\n" +
+				"		return\n" +
+				"	}\n\n")
+		}
+		if notCompressed != "" {
+			buf.WriteString("" +
+				"	if f.Compressed != nil {\n" +
+				"		sqlBuf.WriteString(\"" + blobClass.BlobType() + "\")\n" +
+				"	} else {\n" +
+				"		sqlBuf.WriteString(\"" + mysqlType.mysqlType + "\")\n" +
+				"	}\n\n")
+		} else {
+			buf.WriteString("	sqlBuf.WriteString(\"" + mysqlType.mysqlType + "\")\n\n")
+		}
 
 		if _, exists := typeFields["Length"]; exists {
 			buf.WriteString("" +
@@ -322,6 +575,13 @@ func main() {
 				"	}\n\n")
 		}
 
+		if _, exists := typeFields["Values"]; exists {
+			buf.WriteString("" +
+				"	sqlBuf.WriteByte('(')\n" +
+				"	sqlBuf.WriteString(quoteValues(f.Values))\n" +
+				"	sqlBuf.WriteByte(')')\n\n")
+		}
+
 		if mysqlType.baseClass.IsUnsigned() {
 			buf.WriteString("	sqlBuf.WriteString(\" UNSIGNED\")\n\n")
 		}
@@ -335,14 +595,14 @@ func main() {
 
 		if _, exists := typeFields["Binary"]; exists {
 			buf.WriteString("" +
-				"	if f.Binary {\n" +
+				"	if f.Binary" + notCompressed + " {\n" +
 				"		sqlBuf.WriteString(\" BINARY\")\n" +
 				"	}\n\n")
 		}
 
 		if _, exists := typeFields["Charset"]; exists {
 			buf.WriteString("" +
-				"	if f.Charset != \"\" {\n" +
+				"	if f.Charset != \"\"" + notCompressed + " {\n" +
 				"		sqlBuf.WriteString(\" CHARACTER SET \")\n" +
 				"		sqlBuf.WriteValue(f.Charset)\n" +
 				"	}\n\n")
@@ -350,7 +610,7 @@ func main() {
 
 		if _, exists := typeFields["Collate"]; exists {
 			buf.WriteString("" +
-				"	if f.Collate != \"\" {\n" +
+				"	if f.Collate != \"\"" + notCompressed + " {\n" +
 				"		sqlBuf.WriteString(\" COLLATE \")\n" +
 				"		sqlBuf.WriteValue(f.Collate)\n" +
 				"	}\n\n")
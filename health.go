@@ -0,0 +1,120 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrPoolSaturated is the health check error of the pool all connections of which are in use
+	ErrPoolSaturated = errors.New("all connections of the pool are in use")
+	// ErrNotConnected is the health check error of the storage before Connect
+	ErrNotConnected = errors.New("the storage is not connected")
+)
+
+// EndpointHealth is the health of the primary or a replica, Latency is the time of the ping and SELECT 1
+type EndpointHealth struct {
+	// Name is "primary" or "replica N", N is the number of the replica in the order they are added from 0
+	Name    string
+	Healthy bool
+	Latency time.Duration
+	Err     error
+	Stats   sql.DBStats
+}
+
+// HealthStatus is the result of HealthCheck, the storage is healthy if the primary is. The replicas are listed
+// after the primary.
+type HealthStatus struct {
+	Healthy   bool
+	CheckedAt time.Time
+	Endpoints []EndpointHealth
+}
+
+type healthCache struct {
+	mtx      sync.Mutex
+	interval time.Duration
+	last     *HealthStatus
+}
+
+// SetHealthCheckInterval makes HealthCheck return the last status until the interval passes, so the probes
+// do not load the servers. The concurrent checks wait for the running one and share its status.
+// It must be called before the storage is used.
+func (s *MySQL) SetHealthCheckInterval(interval time.Duration) {
+	s.health.interval = interval
+}
+
+// HealthCheck pings the primary and the replicas, runs SELECT 1 on them and checks that their pools are
+// not saturated. The replicas failing the check are not used for the reads until ReplicaRetryInterval passes,
+// the healthy ones are used again at once.
+func (s *MySQL) HealthCheck(ctx context.Context) *HealthStatus {
+	s.health.mtx.Lock()
+	defer s.health.mtx.Unlock()
+
+	if last := s.health.last; last != nil && s.since(last.CheckedAt) < s.health.interval {
+		return last
+	}
+
+	status := &HealthStatus{CheckedAt: s.now()}
+
+	primary := EndpointHealth{Name: "primary"}
+	switch {
+	case s.db == nil:
+		primary.Err = ErrNotConnected
+	case s.isClosing():
+		primary.Err = ErrClosing
+	default:
+		primary = s.checkEndpoint(ctx, "primary", s.db)
+	}
+	primary.Healthy = primary.Err == nil
+	status.Healthy = primary.Healthy
+	status.Endpoints = append(status.Endpoints, primary)
+
+	s.replicasMtx.RLock()
+	replicas := append([]*replica(nil), s.replicas...)
+	s.replicasMtx.RUnlock()
+
+	for i, r := range replicas {
+		health := s.checkEndpoint(ctx, "replica "+strconv.Itoa(i), r.db)
+		r.setHealth(s.now(), health.Err)
+		status.Endpoints = append(status.Endpoints, health)
+	}
+
+	s.health.last = status
+
+	return status
+}
+
+// checkEndpoint checks the pool, the saturated pool is not pinged since the ping would wait for a connection
+func (s *MySQL) checkEndpoint(ctx context.Context, name string, db *sql.DB) EndpointHealth {
+	res := EndpointHealth{Name: name, Stats: db.Stats()}
+	if res.Stats.MaxOpenConnections > 0 && res.Stats.InUse >= res.Stats.MaxOpenConnections {
+		res.Err = ErrPoolSaturated
+		return res
+	}
+
+	start := s.now()
+	res.Err = db.PingContext(ctx)
+	if res.Err == nil {
+		var rows *sql.Rows
+		if rows, res.Err = s.executor(db).QueryContext(ctx, "SELECT 1"); res.Err == nil {
+			rows.Next()
+			res.Err = rows.Err()
+			rows.Close()
+		}
+	}
+	res.Latency = s.since(start)
+	res.Healthy = res.Err == nil
+
+	return res
+}
+
+func (s *MySQL) isClosing() bool {
+	s.closeMtx.Lock()
+	defer s.closeMtx.Unlock()
+
+	return s.closing
+}
@@ -0,0 +1,85 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+)
+
+// QueryHookInfo is the statement passed to the query hooks. The operation and the model are the same as
+// MetricsSink receives. SQL and Args can be changed by BeforeQuery except for the transaction control statements.
+type QueryHookInfo struct {
+	Model string
+	Op    string
+	SQL   string
+	Args  []interface{}
+	// Unscoped is set for the statements run with the context of Unscoped, the scope policies are not applied
+	// to them
+	Unscoped bool
+}
+
+// QueryHook is called around every statement including the transaction control ones and DDL.
+// BeforeQuery returns the context of the statement or an error which vetoes the statement, it is returned
+// by the statement then. AfterQuery receives the result of Exec, it is nil for the other statements.
+// The hooks must not start or finish the transaction of the statement. The statements killing the queries
+// exceeding the max execution time are not passed to the hooks.
+type QueryHook interface {
+	BeforeQuery(ctx context.Context, info *QueryHookInfo) (context.Context, error)
+	AfterQuery(ctx context.Context, info *QueryHookInfo, result driver.Result, err error)
+}
+
+// AddQueryHook adds the hook, BeforeQuery of the hooks are called in the order of the addition and AfterQuery
+// in the reverse one. It must be called before the storage is used.
+func (s *MySQL) AddQueryHook(hook QueryHook) {
+	s.queryHooks = append(s.queryHooks, hook)
+}
+
+// newQueryHookInfo returns the info of the statement with the model and the operation of the context
+func newQueryHookInfo(ctx context.Context, query string, args []interface{}) *QueryHookInfo {
+	info, ok := ctx.Value(ctx_query_key).(queryInfo)
+	if !ok {
+		info.op = statementOp(query)
+	}
+
+	return &QueryHookInfo{Model: info.model, Op: info.op, SQL: query, Args: args, Unscoped: isUnscoped(ctx)}
+}
+
+// withHooks runs the statement between the hooks, AfterQuery is called for the hooks BeforeQuery of which
+// is called. The result is the context returned by the hooks.
+func (s *MySQL) withHooks(ctx context.Context, info *QueryHookInfo, run func(ctx context.Context) (driver.Result, error)) (context.Context, error) {
+	var (
+		res    driver.Result
+		err    error
+		called int
+	)
+	for _, hook := range s.queryHooks {
+		var hookCtx context.Context
+		if hookCtx, err = hook.BeforeQuery(ctx, info); err != nil {
+			break
+		}
+		if hookCtx != nil {
+			ctx = hookCtx
+		}
+		called++
+	}
+
+	if err == nil {
+		res, err = run(ctx)
+	}
+
+	for i := called - 1; i >= 0; i-- {
+		s.queryHooks[i].AfterQuery(ctx, info, res, err)
+	}
+
+	return ctx, err
+}
+
+// withTxHooks runs the transaction control statement between the hooks
+func (s *MySQL) withTxHooks(ctx context.Context, op, query string, run func(ctx context.Context) error) (context.Context, error) {
+	if len(s.queryHooks) == 0 {
+		return ctx, run(ctx)
+	}
+
+	return s.withHooks(ctx, &QueryHookInfo{Model: TxModel, Op: op, SQL: query}, func(ctx context.Context) (driver.Result, error) {
+		return nil, run(ctx)
+	})
+}
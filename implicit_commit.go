@@ -0,0 +1,112 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-qbit/model"
+)
+
+const (
+	ctx_allow_implicit_commit_key = "MYSQL_ALLOW_IMPLICIT_COMMIT"
+	ctx_implicit_commit_key       = "MYSQL_IMPLICIT_COMMIT"
+)
+
+// ErrImplicitCommitInTx is matched by the errors of the statements rejected in the transaction because MySQL
+// commits the open transaction before them, e.g. DDL, TRUNCATE and LOCK TABLES, see AllowImplicitCommit
+var ErrImplicitCommitInTx = errors.New("implicit commit in transaction")
+
+// AllowImplicitCommit allows the statements committing the open transaction of the context implicitly,
+// e.g. the intended DDL of the migration run in the transaction
+func AllowImplicitCommit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctx_allow_implicit_commit_key, true)
+}
+
+// SetImplicitCommitGuard sets whether the statements committing the open transaction implicitly are rejected
+// with ErrImplicitCommitInTx, it is enabled by default. It must be called before the storage is used.
+func (s *MySQL) SetImplicitCommitGuard(enabled bool) {
+	s.noImplicitCommitGuard = !enabled
+}
+
+// withImplicitCommit marks the statements of the DDL helpers as committing the open transaction
+func withImplicitCommit(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctx_implicit_commit_key, true)
+}
+
+// isImplicitCommit reports whether MySQL commits the open transaction before the statement,
+// the temporary tables and the savepoints do not commit it
+func isImplicitCommit(query string) bool {
+	query = skipLeadingComments(query)
+	keyword := firstKeyword(query)
+	if !containsString(implicitCommitKeywords, keyword) {
+		return false
+	}
+
+	switch next := firstKeyword(skipLeadingComments(query[len(keyword):])); keyword {
+	case "CREATE", "DROP":
+		return next != "TEMPORARY"
+	case "ROLLBACK":
+		return next != "TO"
+	}
+
+	return true
+}
+
+// skipLeadingComments returns the query after the leading spaces, parentheses and comments skipped as splitScript
+// skips them, the content of the executable comment /*! ... */ is the statement
+func skipLeadingComments(query string) string {
+	for {
+		query = strings.TrimLeft(query, " \t\r\n(")
+		switch {
+		case strings.HasPrefix(query, "/*!"):
+			query = strings.TrimLeft(query[len("/*!"):], "0123456789")
+		case strings.HasPrefix(query, "/*"):
+			end := strings.Index(query[len("/*"):], "*/")
+			if end < 0 {
+				return ""
+			}
+			query = query[len("/*")+end+len("*/"):]
+		case strings.HasPrefix(query, "#") ||
+			strings.HasPrefix(query, "--") && (len(query) == 2 || strings.IndexByte(" \t\r\n", query[2]) >= 0):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end+1:]
+		default:
+			return query
+		}
+	}
+}
+
+// checkImplicitCommit returns ErrImplicitCommitInTx for the statement committing the transaction of the context
+func (s *MySQL) checkImplicitCommit(ctx context.Context, query string) error {
+	if s.noImplicitCommitGuard || ctx.Value(s.transactionKey()) == nil {
+		return nil
+	}
+	if allowed, _ := ctx.Value(ctx_allow_implicit_commit_key).(bool); allowed {
+		return nil
+	}
+	if marked, _ := ctx.Value(ctx_implicit_commit_key).(bool); !marked && !isImplicitCommit(query) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: MySQL commits the open transaction before the statement, so the writes of the transaction "+
+		"would be committed and the rest of it would run without one, use AllowImplicitCommit if it is intended: %s",
+		ErrImplicitCommitInTx, truncateStatement(query, ScriptSnippetLength))
+}
+
+// TruncateTable deletes all the rows of the model table with TRUNCATE TABLE, the auto increment counter is reset.
+// MySQL commits the open transaction before it, so it is rejected in a transaction unless it is allowed
+// with AllowImplicitCommit.
+func (s *MySQL) TruncateTable(ctx context.Context, m model.IModel) error {
+	_, err := s.Exec(withImplicitCommit(withQuery(ctx, m.GetId(), "truncate")), "TRUNCATE TABLE "+QuoteTable(m))
+
+	return err
+}
+
+func (m *BaseModel) TruncateTable(ctx context.Context) error {
+	return m.db.TruncateTable(ctx, m)
+}
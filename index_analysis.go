@@ -0,0 +1,339 @@
+package mysql
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// IndexInfo is an index of the model table, the primary key is named PRIMARY
+type IndexInfo struct {
+	Model       string
+	Name        string
+	FieldsNames []string
+	Unique      bool
+	FullText    bool
+}
+
+// RedundantIndex is the declared index which is a left prefix of the CoveredBy one
+type RedundantIndex struct {
+	IndexInfo
+	CoveredBy IndexInfo
+}
+
+// UnindexedColumn is the field filtered on by the statements which use no index
+type UnindexedColumn struct {
+	Model        string
+	Field        string
+	Executions   uint64
+	RowsExamined uint64
+}
+
+// IndexesReport is the result of AnalyzeIndexes, Warnings are the reasons the parts of the report are missing
+type IndexesReport struct {
+	Unused    []IndexInfo
+	Redundant []RedundantIndex
+	Unindexed []UnindexedColumn
+	Warnings  []string
+}
+
+// AnalyzeIndexes reports the declared indexes of the models which are not used since the server start or
+// are redundant, and the fields filtered on by the statements without an index. The usage is read from
+// performance_schema, the report has no unused indexes and unindexed fields if it is not available.
+func (s *MySQL) AnalyzeIndexes(ctx context.Context) (*IndexesReport, error) {
+	s.modelsMtx.RLock()
+	models := make([]*BaseModel, 0, len(s.models))
+	for _, m := range s.models {
+		if bm, ok := m.(*BaseModel); ok {
+			models = append(models, bm)
+		}
+	}
+	s.modelsMtx.RUnlock()
+	sort.Slice(models, func(i, j int) bool { return models[i].GetId() < models[j].GetId() })
+
+	report := &IndexesReport{}
+
+	tables := newModelsTables(models)
+
+	tablesIndexes, err := s.readIndexes(ctx, tables)
+	if err != nil {
+		report.Warnings = append(report.Warnings, "information_schema.STATISTICS is not available, the declared indexes are analyzed: "+err.Error())
+		tablesIndexes = make(map[string][]IndexInfo)
+		for _, m := range models {
+			tablesIndexes[m.GetId()] = declaredIndexes(m)
+		}
+	}
+
+	for _, m := range models {
+		for _, index := range declaredIndexes(m) {
+			if index.Unique || index.Name == "PRIMARY" {
+				continue
+			}
+			if coveredBy, ok := findCoveringIndex(index, tablesIndexes[m.GetId()]); ok {
+				report.Redundant = append(report.Redundant, RedundantIndex{IndexInfo: index, CoveredBy: coveredBy})
+			}
+		}
+	}
+
+	usage, err := s.readIndexesUsage(ctx, tables)
+	if err != nil || len(usage) == 0 {
+		report.Warnings = append(report.Warnings, "performance_schema index usage is not available, the unused indexes are not reported")
+	} else {
+		for _, m := range models {
+			for _, index := range declaredIndexes(m) {
+				if count, exists := usage[m.GetId()+"."+index.Name]; exists && count == 0 && index.Name != "PRIMARY" {
+					report.Unused = append(report.Unused, index)
+				}
+			}
+		}
+	}
+
+	if report.Unindexed, err = s.readUnindexedColumns(ctx, models, tablesIndexes); err != nil {
+		report.Warnings = append(report.Warnings, "performance_schema statements digests are not available, the unindexed fields are not reported")
+	}
+
+	return report, nil
+}
+
+func declaredIndexes(m *BaseModel) []IndexInfo {
+	var res []IndexInfo
+	if pk := m.GetPKFieldsNames(); len(pk) > 0 {
+		res = append(res, IndexInfo{Model: m.GetId(), Name: "PRIMARY", FieldsNames: pk, Unique: true})
+	}
+	for _, index := range m.GetIndexes() {
+		res = append(res, IndexInfo{Model: m.GetId(), Name: m.indexName(index), FieldsNames: index.FieldNames, Unique: index.Unique})
+	}
+
+	return res
+}
+
+// findCoveringIndex finds the other index the fields of the index are a left prefix of, the index with the same
+// fields covers it if it is unique or goes first by name
+func findCoveringIndex(index IndexInfo, indexes []IndexInfo) (IndexInfo, bool) {
+	for _, other := range indexes {
+		if other.Name == index.Name || len(other.FieldsNames) < len(index.FieldsNames) {
+			continue
+		}
+		if len(other.FieldsNames) == len(index.FieldsNames) && !other.Unique && other.Name > index.Name {
+			continue
+		}
+
+		isPrefix := true
+		for i, name := range index.FieldsNames {
+			if !strings.EqualFold(other.FieldsNames[i], name) {
+				isPrefix = false
+				break
+			}
+		}
+		if isPrefix {
+			return other, true
+		}
+	}
+
+	return IndexInfo{}, false
+}
+
+// modelsTables maps the tables of the models to the models, the tables of the connection database
+// have the empty database
+type modelsTables struct {
+	models    map[[2]string]string
+	databases []string
+}
+
+func newModelsTables(models []*BaseModel) modelsTables {
+	res := modelsTables{models: make(map[[2]string]string, len(models))}
+	for _, m := range models {
+		database := m.GetDatabase()
+		if database != "" && !containsString(res.databases, database) {
+			res.databases = append(res.databases, database)
+		}
+		res.models[[2]string{database, m.GetTableName()}] = m.GetId()
+	}
+
+	return res
+}
+
+// where returns the condition of the schema column matching the databases of the models and its arguments
+func (t modelsTables) where(column string) (string, []interface{}) {
+	if len(t.databases) == 0 {
+		return column + " = DATABASE()", nil
+	}
+
+	args := make([]interface{}, len(t.databases))
+	for i, database := range t.databases {
+		args[i] = database
+	}
+
+	return "(" + column + " = DATABASE() OR " + column + " IN (?" + strings.Repeat(",?", len(t.databases)-1) + "))", args
+}
+
+// modelOf returns the model of the table, isCurrent is true for the tables of the connection database
+func (t modelsTables) modelOf(isCurrent bool, database, table string) (string, bool) {
+	if isCurrent {
+		if modelId, exists := t.models[[2]string{"", table}]; exists {
+			return modelId, true
+		}
+	}
+	modelId, exists := t.models[[2]string{database, table}]
+
+	return modelId, exists
+}
+
+// readIndexes returns the indexes of the tables of the models by the models
+func (s *MySQL) readIndexes(ctx context.Context, tables modelsTables) (map[string][]IndexInfo, error) {
+	where, args := tables.where("TABLE_SCHEMA")
+	rows, err := s.RawQuery(ctx, "SELECT TABLE_SCHEMA = DATABASE(), TABLE_SCHEMA, TABLE_NAME, INDEX_NAME, NON_UNIQUE, COLUMN_NAME "+
+		"FROM information_schema.STATISTICS WHERE "+where+" ORDER BY TABLE_SCHEMA, TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string][]IndexInfo)
+	for rows.Next() {
+		var (
+			database, table, name, column string
+			isCurrent, nonUnique          bool
+		)
+		if err := rows.Scan(&isCurrent, &database, &table, &name, &nonUnique, &column); err != nil {
+			return nil, err
+		}
+
+		modelId, exists := tables.modelOf(isCurrent, database, table)
+		if !exists {
+			continue
+		}
+
+		indexes := res[modelId]
+		if len(indexes) == 0 || indexes[len(indexes)-1].Name != name {
+			indexes = append(indexes, IndexInfo{Model: modelId, Name: name, Unique: !nonUnique})
+		}
+		last := &indexes[len(indexes)-1]
+		last.FieldsNames = append(last.FieldsNames, column)
+		res[modelId] = indexes
+	}
+
+	return res, rows.Err()
+}
+
+// readIndexesUsage returns the numbers of the rows read by the indexes of the tables of the models by "model.index"
+func (s *MySQL) readIndexesUsage(ctx context.Context, tables modelsTables) (map[string]uint64, error) {
+	where, args := tables.where("OBJECT_SCHEMA")
+	rows, err := s.RawQuery(ctx, "SELECT OBJECT_SCHEMA = DATABASE(), OBJECT_SCHEMA, OBJECT_NAME, INDEX_NAME, COUNT_STAR "+
+		"FROM performance_schema.table_io_waits_summary_by_index_usage WHERE "+where+" AND INDEX_NAME IS NOT NULL", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string]uint64)
+	for rows.Next() {
+		var (
+			database, table, index string
+			isCurrent              bool
+			count                  uint64
+		)
+		if err := rows.Scan(&isCurrent, &database, &table, &index, &count); err != nil {
+			return nil, err
+		}
+		if modelId, exists := tables.modelOf(isCurrent, database, table); exists {
+			res[modelId+"."+index] = count
+		}
+	}
+
+	return res, rows.Err()
+}
+
+var (
+	digestTablesRe  = regexp.MustCompile("(?i)\\b(?:FROM|JOIN|UPDATE)\\s+(?:`\\w+`\\s*\\.\\s*)?`(\\w+)`")
+	digestFiltersRe = regexp.MustCompile("(?i)(?:`(\\w+)`\\s*\\.\\s*)?`(\\w+)`\\s*(?:=|<|>|!=|IN\\b|LIKE\\b|BETWEEN\\b|IS\\b)")
+)
+
+// readUnindexedColumns finds the fields of the models filtered on by the statements without an index
+// which are not the first field of any index
+func (s *MySQL) readUnindexedColumns(ctx context.Context, models []*BaseModel, tablesIndexes map[string][]IndexInfo) ([]UnindexedColumn, error) {
+	rows, err := s.RawQuery(ctx, "SELECT DIGEST_TEXT, COUNT_STAR, SUM_ROWS_EXAMINED FROM performance_schema.events_statements_summary_by_digest "+
+		"WHERE SCHEMA_NAME = DATABASE() AND SUM_NO_INDEX_USED > 0 AND DIGEST_TEXT IS NOT NULL")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	modelsByTable := make(map[string]*BaseModel, len(models))
+	for _, m := range models {
+		modelsByTable[m.GetTableName()] = m
+	}
+
+	found := make(map[string]*UnindexedColumn)
+	for rows.Next() {
+		var (
+			digest               string
+			executions, examined uint64
+		)
+		if err := rows.Scan(&digest, &executions, &examined); err != nil {
+			return nil, err
+		}
+
+		var tables []string
+		for _, match := range digestTablesRe.FindAllStringSubmatch(digest, -1) {
+			if _, exists := modelsByTable[match[1]]; exists {
+				tables = appendMissing(tables, match[1])
+			}
+		}
+
+		wherePos := strings.Index(strings.ToUpper(digest), " WHERE ")
+		if wherePos < 0 || len(tables) == 0 {
+			continue
+		}
+
+		for _, match := range digestFiltersRe.FindAllStringSubmatch(digest[wherePos:], -1) {
+			table, field := match[1], match[2]
+			if table == "" {
+				// The unqualified fields are attributed to the single table statements only
+				if len(tables) > 1 {
+					continue
+				}
+				table = tables[0]
+			}
+
+			m := modelsByTable[table]
+			if m == nil || m.GetFieldDefinition(field) == nil || isIndexed(field, tablesIndexes[m.GetId()]) {
+				continue
+			}
+
+			key := m.GetId() + "." + field
+			if found[key] == nil {
+				found[key] = &UnindexedColumn{Model: m.GetId(), Field: field}
+			}
+			found[key].Executions += executions
+			found[key].RowsExamined += examined
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	res := make([]UnindexedColumn, 0, len(found))
+	for _, column := range found {
+		res = append(res, *column)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Executions != res[j].Executions {
+			return res[i].Executions > res[j].Executions
+		}
+		return res[i].Model+"."+res[i].Field < res[j].Model+"."+res[j].Field
+	})
+
+	return res, nil
+}
+
+func isIndexed(field string, indexes []IndexInfo) bool {
+	for _, index := range indexes {
+		if len(index.FieldsNames) > 0 && strings.EqualFold(index.FieldsNames[0], field) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,300 @@
+package mysql
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+type RepairAction int
+
+const (
+	RepairNone RepairAction = iota
+	// RepairDelete deletes the orphaned rows
+	RepairDelete
+	// RepairSetNull sets the foreign key of the orphaned rows to NULL
+	RepairSetNull
+)
+
+type IntegrityOpts struct {
+	// ChunkSize is the number of rows checked by one query in the primary key order, 10000 by default
+	ChunkSize uint64
+	// SamplesCount is the maximum number of the orphaned rows primary keys in the report, 10 by default
+	SamplesCount int
+	Repair       RepairAction
+	// Destructive must be set to repair the orphaned rows, each chunk is repaired in its own transaction
+	Destructive bool
+}
+
+// RelationIntegrity is the result of the check of one relation, SampleIds are the primary keys of the orphaned rows
+type RelationIntegrity struct {
+	Model       string
+	Relation    string
+	ParentModel string
+	Orphans     uint64
+	SampleIds   [][]interface{}
+	Repaired    uint64
+}
+
+type IntegrityReport []RelationIntegrity
+
+// HasOrphans returns true if any relation has the orphaned rows
+func (r IntegrityReport) HasOrphans() bool {
+	for _, relation := range r {
+		if relation.Orphans > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckIntegrity finds the rows referencing the missing parent rows by the many-to-one and one-to-one relations
+// of all models. The rows are checked with the anti-join queries by the chunks in the primary key order, so none
+// of the queries holds a snapshot for long.
+func (s *MySQL) CheckIntegrity(ctx context.Context, opts IntegrityOpts) (IntegrityReport, error) {
+	if opts.Repair != RepairNone && !opts.Destructive {
+		return nil, qerror.Errorf("The repair of the orphaned rows requires the Destructive option")
+	}
+	if opts.ChunkSize == 0 {
+		opts.ChunkSize = 10000
+	}
+	if opts.SamplesCount == 0 {
+		opts.SamplesCount = 10
+	}
+
+	s.modelsMtx.RLock()
+	models := make([]model.IModel, 0, len(s.models))
+	for _, m := range s.models {
+		models = append(models, m)
+	}
+	s.modelsMtx.RUnlock()
+	sort.Slice(models, func(i, j int) bool { return models[i].GetId() < models[j].GetId() })
+
+	var res IntegrityReport
+	for _, m := range models {
+		for _, relationName := range m.GetRelations() {
+			relation := m.GetRelation(relationName)
+			if relation.IsBack || relation.RelationType != model.RELATION_MANY_TO_ONE && relation.RelationType != model.RELATION_ONE_TO_ONE {
+				continue
+			}
+
+			check, err := s.checkRelation(ctx, m, relationName, opts)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, check)
+		}
+	}
+
+	return res, nil
+}
+
+func (s *MySQL) checkRelation(ctx context.Context, m model.IModel, relationName string, opts IntegrityOpts) (RelationIntegrity, error) {
+	relation := m.GetRelation(relationName)
+	res := RelationIntegrity{Model: m.GetId(), Relation: relationName, ParentModel: relation.ExtModel.GetId()}
+	if err := s.checkStorage(relation.ExtModel); err != nil {
+		return res, err
+	}
+
+	if opts.Repair == RepairSetNull {
+		for _, name := range relation.LocalFieldsNames {
+			if m.GetFieldDefinition(name).IsRequired() || containsString(m.GetPKFieldsNames(), name) {
+				return res, qerror.Errorf("The field '%s' in model '%s' cannot be set to NULL", name, m.GetId())
+			}
+		}
+	}
+
+	var after []interface{}
+	for {
+		upTo, err := s.chunkBound(ctx, m, after, opts.ChunkSize)
+		if err != nil {
+			return res, err
+		}
+
+		sqlBuf := NewSqlBuffer()
+		s.WriteOrphansSQL(sqlBuf, m, relationName, after, upTo)
+		if err := sqlBuf.Err(); err != nil {
+			return res, err
+		}
+
+		orphans, err := s.queryRows(ctx, m, sqlBuf)
+		if err != nil {
+			return res, err
+		}
+
+		res.Orphans += uint64(len(orphans))
+		for _, id := range orphans {
+			if len(res.SampleIds) < opts.SamplesCount {
+				res.SampleIds = append(res.SampleIds, id)
+			}
+		}
+
+		if len(orphans) > 0 && opts.Repair != RepairNone {
+			sqlBuf.Reset()
+			s.writeRepairSQL(sqlBuf, m, relation, opts.Repair, after, upTo)
+			if err := s.DoInTransaction(ctx, func(ctx context.Context) error {
+				op := "delete"
+				if opts.Repair == RepairSetNull {
+					op = "update"
+				}
+				result, err := s.Exec(withQuery(ctx, m.GetId(), op), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+				if err != nil {
+					return err
+				}
+
+				repaired, err := result.RowsAffected()
+				res.Repaired += uint64(repaired)
+
+				return err
+			}); err != nil {
+				return res, err
+			}
+		}
+
+		if upTo == nil {
+			return res, nil
+		}
+		after = upTo
+	}
+}
+
+// chunkBound returns the primary key of the last row of the chunk following the after primary key,
+// nil if it is the last chunk
+func (s *MySQL) chunkBound(ctx context.Context, m model.IModel, after []interface{}, chunkSize uint64) ([]interface{}, error) {
+	sqlBuf := NewSqlBuffer()
+	sqlBuf.WriteString("SELECT ")
+	sqlBuf.WriteIdentifiersList(m.GetPKFieldsNames())
+	sqlBuf.WriteString(" FROM ")
+	sqlBuf.WriteTable(m)
+	if after != nil {
+		sqlBuf.WriteString(" WHERE ")
+		writePKCompare(sqlBuf, m, ">", after)
+	}
+	sqlBuf.WriteString(" ORDER BY ")
+	sqlBuf.WriteIdentifiersList(m.GetPKFieldsNames())
+	sqlBuf.WriteString(" LIMIT 1 OFFSET ")
+	sqlBuf.WriteLiteral(chunkSize-1, LiteralOpts{})
+
+	ids, err := s.queryRows(ctx, m, sqlBuf)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	return ids[0], nil
+}
+
+// queryRows returns the rows of the query with the dereferenced values of the model fields
+func (s *MySQL) queryRows(ctx context.Context, m model.IModel, sqlBuf *SqlBuffer) ([][]interface{}, error) {
+	rows, err := s.RawQuery(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data, err := scanData(rows, func(_ int, name string) model.IFieldDefinition { return m.GetFieldDefinition(name) })
+	if err != nil {
+		return nil, err
+	}
+
+	res := data.Data()
+	for _, row := range res {
+		for i := range row {
+			row[i] = derefValue(row[i])
+		}
+	}
+
+	return res, nil
+}
+
+// WriteOrphansSQL writes the query of the primary keys of the rows in the (after, upTo] primary key range
+// referencing the missing parent rows by the relation, nil bounds are not checked
+func (s *MySQL) WriteOrphansSQL(sqlBuf *SqlBuffer, m model.IModel, relationName string, after, upTo []interface{}) {
+	relation := m.GetRelation(relationName)
+	if relation == nil {
+		sqlBuf.SetError(qerror.Errorf("Unknown relation '%s' in model '%s'", relationName, m.GetId()))
+		return
+	}
+
+	sqlBuf.WriteString("SELECT ")
+	for i, name := range m.GetPKFieldsNames() {
+		if i > 0 {
+			sqlBuf.WriteByte(',')
+		}
+		sqlBuf.WriteString(QuoteIdent(TableName(m), name))
+	}
+	sqlBuf.WriteString(" FROM ")
+	writeOrphansFrom(sqlBuf, m, relation)
+	writeOrphansWhere(sqlBuf, m, relation, after, upTo)
+}
+
+func (s *MySQL) writeRepairSQL(sqlBuf *SqlBuffer, m model.IModel, relation *model.Relation, repair RepairAction, after, upTo []interface{}) {
+	switch repair {
+	case RepairDelete:
+		sqlBuf.WriteString("DELETE ")
+		sqlBuf.WriteTable(m)
+		sqlBuf.WriteString(" FROM ")
+		writeOrphansFrom(sqlBuf, m, relation)
+	case RepairSetNull:
+		sqlBuf.WriteString("UPDATE ")
+		writeOrphansFrom(sqlBuf, m, relation)
+		sqlBuf.WriteString(" SET ")
+		for i, name := range relation.LocalFieldsNames {
+			if i > 0 {
+				sqlBuf.WriteByte(',')
+			}
+			sqlBuf.WriteString(QuoteIdent(TableName(m), name) + "=NULL")
+		}
+	default:
+		sqlBuf.SetError(qerror.Errorf("Unknown repair action %d", repair))
+		return
+	}
+
+	writeOrphansWhere(sqlBuf, m, relation, after, upTo)
+}
+
+func writeOrphansFrom(sqlBuf *SqlBuffer, m model.IModel, relation *model.Relation) {
+	sqlBuf.WriteTable(m)
+	sqlBuf.WriteString(" LEFT JOIN ")
+	sqlBuf.WriteTable(relation.ExtModel)
+	sqlBuf.WriteString(" AS `_parent` ON ")
+	for i, name := range relation.FkFieldsNames {
+		if i > 0 {
+			sqlBuf.WriteString(" AND ")
+		}
+		sqlBuf.WriteString(QuoteIdent("_parent", name) + "=" + QuoteIdent(TableName(m), relation.LocalFieldsNames[i]))
+	}
+}
+
+func writeOrphansWhere(sqlBuf *SqlBuffer, m model.IModel, relation *model.Relation, after, upTo []interface{}) {
+	sqlBuf.WriteString(" WHERE ")
+	for _, name := range relation.LocalFieldsNames {
+		sqlBuf.WriteString(QuoteIdent(TableName(m), name) + " IS NOT NULL AND ")
+	}
+	sqlBuf.WriteString(QuoteIdent("_parent", relation.FkFieldsNames[0]) + " IS NULL")
+
+	if after != nil {
+		sqlBuf.WriteString(" AND ")
+		writePKCompare(sqlBuf, m, ">", after)
+	}
+	if upTo != nil {
+		sqlBuf.WriteString(" AND ")
+		writePKCompare(sqlBuf, m, "<=", upTo)
+	}
+}
+
+// writePKCompare writes the comparison of the qualified primary key with the values as the row constructors
+func writePKCompare(sqlBuf *SqlBuffer, m model.IModel, op string, values []interface{}) {
+	sqlBuf.WriteByte('(')
+	for i, name := range m.GetPKFieldsNames() {
+		if i > 0 {
+			sqlBuf.WriteByte(',')
+		}
+		sqlBuf.WriteString(QuoteIdent(TableName(m), name))
+	}
+	sqlBuf.WriteString(")" + op + "(")
+	sqlBuf.WriteValuesList(values)
+	sqlBuf.WriteByte(')')
+}
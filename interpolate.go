@@ -0,0 +1,182 @@
+package mysql
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-qbit/model"
+)
+
+// RedactedValue replaces the values of the sensitive fields in the interpolated SQL and the logged arguments
+const RedactedValue = "[redacted]"
+
+// ISensitiveFieldDefinition is implemented by the fields the values of which must not be shown,
+// see the Sensitive option of the fields
+type ISensitiveFieldDefinition interface {
+	IsSensitive() bool
+}
+
+func isSensitiveField(field model.IFieldDefinition) bool {
+	sensitive, ok := field.(ISensitiveFieldDefinition)
+
+	return ok && sensitive.IsSensitive()
+}
+
+// sensitiveValue is the argument written for a sensitive or masked field. The driver receives the value itself,
+// it is redacted, or masked with the read mask, when the arguments are printed or interpolated.
+type sensitiveValue struct {
+	value interface{}
+	mask  func(value interface{}) interface{}
+}
+
+func (v sensitiveValue) Value() (driver.Value, error) {
+	value := derefValue(v.value)
+	if valuer, ok := value.(driver.Valuer); ok {
+		return valuer.Value()
+	}
+	// database/sql does not accept the uint64 values with the high bit set
+	if u, ok := value.(uint64); ok && u > math.MaxInt64 {
+		return strconv.FormatUint(u, 10), nil
+	}
+
+	return driver.DefaultParameterConverter.ConvertValue(value)
+}
+
+func (v sensitiveValue) String() string {
+	if v.mask != nil {
+		return fmt.Sprint(maskValue(v.mask, v.value))
+	}
+
+	return RedactedValue
+}
+
+// sensitiveFieldValue marks the value of the sensitive or masked field
+func sensitiveFieldValue(field model.IFieldDefinition, value interface{}) interface{} {
+	if _, isMarked := value.(sensitiveValue); isMarked {
+		return value
+	}
+	if isSensitiveField(field) {
+		return sensitiveValue{value, nil}
+	}
+	if mask := readMaskOf(field); mask != nil {
+		return sensitiveValue{value, mask}
+	}
+
+	return value
+}
+
+// unwrapSensitive returns the value of the sensitive field argument
+func unwrapSensitive(value interface{}) interface{} {
+	if v, ok := value.(sensitiveValue); ok {
+		return v.value
+	}
+
+	return value
+}
+
+// SetInterpolateSQL makes the logger and the slow queries handler to receive the statement with the arguments
+// substituted, see InterpolateSQL. It must be called before the storage is used.
+func (s *MySQL) SetInterpolateSQL(enabled bool) {
+	s.interpolateSQL = enabled
+}
+
+// InterpolateSQL substitutes the arguments for the placeholders of the statement to show it, e.g. in the logs.
+// The values are written as FormatLiteral writes them, time.Time values are formatted in the location (UTC if it
+// is nil), the values of the sensitive fields are replaced with RedactedValue and the values of the masked fields
+// are masked.
+// The result is best-effort and is never sent to the server.
+func InterpolateSQL(query string, args []interface{}, loc *time.Location) string {
+	if len(args) == 0 {
+		return query
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	buf := &strings.Builder{}
+	buf.Grow(len(query) + 16*len(args))
+
+	argPos := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+
+		switch {
+		case quote != 0:
+			if c == '\\' && quote != '`' && i+1 < len(query) {
+				buf.WriteByte(c)
+				i++
+				c = query[i]
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '?' && argPos < len(args):
+			writeInterpolatedValue(buf, args[argPos], loc)
+			argPos++
+			continue
+		}
+
+		buf.WriteByte(c)
+	}
+
+	return buf.String()
+}
+
+func writeInterpolatedValue(buf *strings.Builder, value interface{}, loc *time.Location) {
+	if v, ok := value.(sensitiveValue); ok {
+		if v.mask == nil {
+			buf.WriteString(RedactedValue)
+			return
+		}
+		value = maskValue(v.mask, v.value)
+	}
+
+	literal, err := FormatLiteral(value, LiteralOpts{Location: loc})
+	if err != nil {
+		// The values which are not the literals are shown as the strings
+		literal, _ = FormatLiteral(fmt.Sprint(value), LiteralOpts{})
+	}
+	buf.WriteString(literal)
+}
+
+// formatDateTime formats the time as the driver does, the zero time is 0000-00-00
+func formatDateTime(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return "0000-00-00"
+	}
+
+	t = t.In(loc)
+	hour, min, sec := t.Clock()
+	if hour == 0 && min == 0 && sec == 0 && t.Nanosecond() == 0 {
+		return t.Format("2006-01-02")
+	}
+
+	return t.Format("2006-01-02 15:04:05.999999999")
+}
+
+// writeEscaped escapes the string with backslashes as the server expects by default
+func writeEscaped(buf *strings.Builder, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case 0:
+			buf.WriteString(`\0`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\r':
+			buf.WriteString(`\r`)
+		case '\x1a':
+			buf.WriteString(`\Z`)
+		case '\'', '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+}
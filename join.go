@@ -0,0 +1,211 @@
+package mysql
+
+import (
+	"strings"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// JoinKind is the kind of TableJoin
+type JoinKind int
+
+const (
+	// InnerJoin drops the rows without a joined row
+	InnerJoin JoinKind = iota
+	// LeftJoin keeps the rows without a joined row, the joined columns are NULL then
+	LeftJoin
+)
+
+// AliasedTable is the table of the model under the alias in the statement, see As
+type AliasedTable struct {
+	Model model.IModel
+	Alias string
+}
+
+// As returns the table of the model under the alias, e.g. to join the table of the model to itself
+func As(m model.IModel, alias string) AliasedTable {
+	return AliasedTable{Model: m, Alias: alias}
+}
+
+// TableJoin is the table joined to the SELECT with the condition, see Join. The conditions refer to the fields
+// of the joined tables and of the aliased primary table with AliasField, the fields of the model of the statement
+// are the ones of the primary table. FieldsNames are the stored fields of the joined table returned
+// in the columns named "alias.field".
+type TableJoin struct {
+	Table       AliasedTable
+	On          model.IExpression
+	Kind        JoinKind
+	FieldsNames []string
+}
+
+// Join returns the option for SelectOptions.Joins joining the table under its alias
+func Join(table AliasedTable, on model.IExpression, kind JoinKind, fieldsNames ...string) TableJoin {
+	return TableJoin{Table: table, On: on, Kind: kind, FieldsNames: fieldsNames}
+}
+
+func (j *TableJoin) column(fieldName string) string {
+	return j.Table.Alias + "." + fieldName
+}
+
+type aliasField struct {
+	alias     string
+	fieldName string
+}
+
+// AliasField refers to the field of the table with the alias in the statement, the primary table aliased
+// by SelectOptions.Alias or the table of TableJoin, e.g. AliasField("u2", "email") is `u2`.`email`
+func AliasField(alias, fieldName string) *aliasField { return &aliasField{alias, fieldName} }
+
+func (e *aliasField) GetProcessor(processor model.IExpressionProcessor) interface{} {
+	return mysqlProcessor(processor).aliasField(e)
+}
+
+func (p *ExprProcessor) aliasField(e *aliasField) WriteFunc {
+	return WriteFunc(func(buf *SqlBuffer) {
+		m := p.tables[e.alias]
+		if m == nil {
+			buf.SetError(qerror.Errorf("Unknown table alias '%s' of the field '%s'", e.alias, e.fieldName))
+			return
+		}
+		field := m.GetFieldDefinition(e.fieldName)
+		if field == nil {
+			buf.SetError(unknownFieldError(m, e.fieldName, p.partOrCondition()))
+			return
+		}
+		if field.IsDerivable() {
+			buf.SetError(qerror.Errorf("The field '%s' in model '%s' is derivable and cannot be used as a column", e.fieldName, m.GetId()))
+			return
+		}
+		if encryptionOf(field) != nil {
+			buf.SetError(encryptedFieldError(m, e.fieldName))
+			return
+		}
+
+		buf.WriteIdentifier(e.alias)
+		buf.WriteByte('.')
+		buf.WriteIdentifier(e.fieldName)
+	})
+}
+
+// joinedAlias returns the alias of the only joined table of the model which is not the model of the processor,
+// the empty string if there is no such table
+func (p *ExprProcessor) joinedAlias(m model.IModel) string {
+	if p.model != nil && p.model.GetId() == m.GetId() {
+		return ""
+	}
+
+	var res string
+	for alias, joined := range p.tables {
+		if joined.GetId() != m.GetId() {
+			continue
+		}
+		if res != "" {
+			return ""
+		}
+		res = alias
+	}
+
+	return res
+}
+
+// statementTables returns the tables of the SELECT by their aliases, the primary table is under its alias
+// or the table name
+func (s *MySQL) statementTables(m model.IModel, table string, joins []TableJoin) (map[string]model.IModel, error) {
+	res := map[string]model.IModel{table: m}
+	for i := range joins {
+		join := &joins[i]
+		if join.Table.Model == nil {
+			return nil, qerror.Errorf("No model of the joined table '%s'", join.Table.Alias)
+		}
+		if err := s.checkStorage(join.Table.Model); err != nil {
+			return nil, err
+		}
+		if err := ValidateIdentifier(join.Table.Alias); err != nil {
+			return nil, qerror.Errorf("Invalid alias of the joined table: %s", err.Error())
+		}
+		if strings.Contains(join.Table.Alias, ".") {
+			return nil, qerror.Errorf("Invalid alias '%s' of the joined table", join.Table.Alias)
+		}
+		if res[join.Table.Alias] != nil {
+			return nil, qerror.Errorf("Duplicate table alias '%s'", join.Table.Alias)
+		}
+		if join.On == nil {
+			return nil, qerror.Errorf("No condition of the joined table '%s'", join.Table.Alias)
+		}
+		for _, name := range join.FieldsNames {
+			if field := join.Table.Model.GetFieldDefinition(name); field == nil {
+				return nil, unknownFieldError(join.Table.Model, name, PartSelect)
+			} else if field.IsDerivable() {
+				return nil, qerror.Errorf("The field '%s' of the joined table '%s' must be stored", name, join.Table.Alias)
+			}
+		}
+		res[join.Table.Alias] = join.Table.Model
+	}
+
+	return res, nil
+}
+
+func (s *MySQL) writeJoinsColumns(sqlBuf *SqlBuffer, joins []TableJoin) {
+	for i := range joins {
+		join := &joins[i]
+		if join.Table.Model == nil {
+			continue
+		}
+		for _, name := range join.FieldsNames {
+			sqlBuf.WriteByte(',')
+			sqlBuf.WriteString(QuoteIdent(join.Table.Alias, name))
+			sqlBuf.WriteString(" AS ")
+			sqlBuf.WriteIdentifier(join.column(name))
+		}
+	}
+}
+
+func (s *MySQL) writeJoins(sqlBuf *SqlBuffer, p *ExprProcessor, joins []TableJoin) {
+	for i := range joins {
+		join := &joins[i]
+		if join.Table.Model == nil || join.On == nil {
+			sqlBuf.SetError(qerror.Errorf("No model or condition of the joined table '%s'", join.Table.Alias))
+			return
+		}
+		if join.Kind == LeftJoin {
+			sqlBuf.WriteString(" LEFT JOIN ")
+		} else {
+			sqlBuf.WriteString(" JOIN ")
+		}
+		sqlBuf.WriteTable(join.Table.Model)
+		sqlBuf.WriteString(" AS ")
+		sqlBuf.WriteIdentifier(join.Table.Alias)
+		sqlBuf.WriteString(" ON ")
+		join.On.GetProcessor(p).(WriteFunc)(sqlBuf)
+
+		// The rows out of the scope are not joined
+		if scope := sqlBuf.scoped(s, join.Table.Model, nil); scope != nil {
+			scopeP := p.ForModel(join.Table.Model)
+			scopeP.qualify, scopeP.alias = true, join.Table.Alias
+			sqlBuf.WriteString(" AND (")
+			scope.GetProcessor(scopeP).(WriteFunc)(sqlBuf)
+			sqlBuf.WriteByte(')')
+		}
+	}
+}
+
+// joinColumnField returns the nullable definition of the joined column or nil if it is not a joined column
+func joinColumnField(joins []TableJoin, name string) model.IFieldDefinition {
+	pos := strings.IndexByte(name, '.')
+	if pos < 0 {
+		return nil
+	}
+
+	for i := range joins {
+		join := &joins[i]
+		if join.Table.Alias != name[:pos] || !containsString(join.FieldsNames, name[pos+1:]) {
+			continue
+		}
+		if field := join.Table.Model.GetFieldDefinition(name[pos+1:]); field != nil {
+			return field.CloneForFK(name, field.GetCaption(), false)
+		}
+	}
+
+	return nil
+}
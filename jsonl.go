@@ -0,0 +1,113 @@
+package mysql
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/go-qbit/model"
+)
+
+type JSONLExportOpts struct {
+	OrderBy []model.Order
+	Limit   uint64
+	// FlushRows is the number of the rows the output is flushed after, 1000 by default
+	FlushRows int
+}
+
+// JSONLExportSummary is the number of the exported rows and the written bytes
+type JSONLExportSummary struct {
+	Rows  int64
+	Bytes int64
+}
+
+// countingWriter counts the written bytes
+type countingWriter struct {
+	w     io.Writer
+	bytes int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.bytes += int64(n)
+
+	return n, err
+}
+
+// ExportJSONL writes the rows as they are read, one JSON object with the fields in the requested order per line.
+// DATETIME and TIMESTAMP values are written in RFC 3339, the binary values in base64 and JSON values as is.
+// The derivable fields are computed. The summary is returned on error too.
+func (s *MySQL) ExportJSONL(ctx context.Context, m model.IModel, w io.Writer, fieldsNames []string, filter model.IExpression, opts JSONLExportOpts) (JSONLExportSummary, error) {
+	if opts.FlushRows <= 0 {
+		opts.FlushRows = 1000
+	}
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	keys := make([][]byte, len(fieldsNames))
+	for i, name := range fieldsNames {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return JSONLExportSummary{}, err
+		}
+		keys[i] = append(key, ':')
+	}
+
+	var rowsCount int64
+	err := s.streamRows(ctx, m, fieldsNames, model.GetAllOptions{
+		Filter:  filter,
+		OrderBy: opts.OrderBy,
+		Limit:   opts.Limit,
+	}, true, func(values []interface{}) error {
+		bw.WriteByte('{')
+		for i, value := range values {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			bw.Write(keys[i])
+
+			data, err := json.Marshal(s.jsonValue(m.GetFieldDefinition(fieldsNames[i]), value))
+			if err != nil {
+				return err
+			}
+			bw.Write(data)
+		}
+		bw.WriteString("}\n")
+
+		if rowsCount++; rowsCount%int64(opts.FlushRows) == 0 {
+			return bw.Flush()
+		}
+
+		return nil
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+
+	return JSONLExportSummary{Rows: rowsCount, Bytes: cw.bytes}, err
+}
+
+// jsonValue returns the value of the field marshalled as ExportJSONL writes it
+func (s *MySQL) jsonValue(field model.IFieldDefinition, value interface{}) interface{} {
+	str, ok := value.(string)
+	if !ok {
+		return value
+	}
+
+	switch field.(type) {
+	case *DateTimeField, *TimeStampField:
+		return s.exportTime(field, str)
+	case *JSONField:
+		if json.Valid([]byte(str)) {
+			return json.RawMessage(str)
+		}
+	}
+
+	return value
+}
+
+func (m *BaseModel) ExportJSONL(ctx context.Context, w io.Writer, fieldsNames []string, filter model.IExpression, opts JSONLExportOpts) (JSONLExportSummary, error) {
+	return m.db.ExportJSONL(ctx, m, w, fieldsNames, filter, opts)
+}
@@ -0,0 +1,158 @@
+package mysql
+
+import (
+	"database/sql/driver"
+	"encoding/hex"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// LiteralOpts are the options of FormatLiteral
+type LiteralOpts struct {
+	// Field formats the time.Time values by its storage type and location, e.g. as DATE
+	Field model.IFieldDefinition
+	// Location is the location of the time.Time values of the fields without their own location, UTC if it is nil
+	Location *time.Location
+	// NoBackslashEscapes escapes the strings for the NO_BACKSLASH_ESCAPES sql_mode, the quotes are doubled
+	// and the backslashes are written as is
+	NoBackslashEscapes bool
+}
+
+// FormatLiteral formats the value as the SQL literal for the positions of the statement which do not take
+// the placeholders, e.g. the hint arguments or the column positions of ORDER BY. The strings are quoted
+// and escaped, []byte values are the hexadecimal literals, time.Time values are formatted by the field,
+// bool values are 1 and 0 as the driver sends them and nil is NULL. The values of driver.Valuer are formatted
+// by their values, the other types fail. Use MySQL.Literal to format with the settings of the storage,
+// see also LiteralValue for RawExpr.
+func FormatLiteral(value interface{}, opts LiteralOpts) (string, error) {
+	buf := &strings.Builder{}
+	if err := writeLiteral(buf, value, opts); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// Literal formats the value of the field as the SQL literal with the location and the sql_mode of the storage,
+// see FormatLiteral. The field may be nil.
+func (s *MySQL) Literal(field model.IFieldDefinition, value interface{}) (string, error) {
+	return FormatLiteral(value, s.literalOpts(field))
+}
+
+// SetNoBackslashEscapes makes the literals escaped for the NO_BACKSLASH_ESCAPES sql_mode, it is set by Connect
+// if the sql_mode of the DSN has it. It must be called before the storage is used.
+func (s *MySQL) SetNoBackslashEscapes(enabled bool) {
+	s.noBackslashEscapes = enabled
+}
+
+// literalOpts returns the options of the literals of the field written by the storage
+func (s *MySQL) literalOpts(field model.IFieldDefinition) LiteralOpts {
+	if s == nil {
+		return LiteralOpts{Field: field}
+	}
+
+	return LiteralOpts{Field: field, Location: s.exprProcessor.Location, NoBackslashEscapes: s.noBackslashEscapes}
+}
+
+// WriteLiteral writes the value as the SQL literal, see FormatLiteral. The error is stored in the buffer.
+func (b *SqlBuffer) WriteLiteral(value interface{}, opts LiteralOpts) {
+	literal, err := FormatLiteral(value, opts)
+	if err != nil {
+		b.SetError(err)
+		return
+	}
+
+	b.WriteString(literal)
+}
+
+func writeLiteral(buf *strings.Builder, value interface{}, opts LiteralOpts) error {
+	if opts.Field != nil {
+		value = unwrapSensitive(fieldValue(opts.Field, value, opts.Location))
+	}
+
+	if valuer, ok := value.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(valuer); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			buf.WriteString("NULL")
+			return nil
+		}
+		v, err := valuer.Value()
+		if err != nil {
+			return err
+		}
+		value = v
+	}
+
+	switch v := derefValue(value).(type) {
+	case nil:
+		buf.WriteString("NULL")
+	case []byte:
+		if v == nil {
+			buf.WriteString("NULL")
+			return nil
+		}
+		buf.WriteString("X'")
+		buf.WriteString(hex.EncodeToString(v))
+		buf.WriteByte('\'')
+	case string:
+		writeStringLiteral(buf, v, opts.NoBackslashEscapes)
+	case time.Time:
+		loc := opts.Location
+		if loc == nil {
+			loc = time.UTC
+		}
+		writeStringLiteral(buf, formatDateTime(v, loc), opts.NoBackslashEscapes)
+	case bool:
+		// As the driver interpolates them
+		if v {
+			buf.WriteByte('1')
+		} else {
+			buf.WriteByte('0')
+		}
+	default:
+		rv := reflect.ValueOf(v)
+		switch {
+		case isUintKind(rv.Kind()):
+			buf.WriteString(strconv.FormatUint(rv.Uint(), 10))
+		case isIntegerKind(rv.Kind()):
+			buf.WriteString(strconv.FormatInt(rv.Int(), 10))
+		case rv.Kind() == reflect.Float32 || rv.Kind() == reflect.Float64:
+			f := rv.Float()
+			if math.IsNaN(f) || math.IsInf(f, 0) {
+				return qerror.Errorf("The float value %v cannot be written as the literal", f)
+			}
+			bitSize := 64
+			if rv.Kind() == reflect.Float32 {
+				bitSize = 32
+			}
+			buf.WriteString(strconv.FormatFloat(f, 'g', -1, bitSize))
+		case rv.Kind() == reflect.String:
+			writeStringLiteral(buf, rv.String(), opts.NoBackslashEscapes)
+		case rv.Kind() == reflect.Bool:
+			return writeLiteral(buf, rv.Bool(), LiteralOpts{})
+		case rv.Type().ConvertibleTo(bytesType):
+			return writeLiteral(buf, rv.Convert(bytesType).Interface(), LiteralOpts{})
+		default:
+			return qerror.Errorf("The value of type %T cannot be written as the literal", v)
+		}
+	}
+
+	return nil
+}
+
+// writeStringLiteral quotes the string, the quotes and the backslashes are escaped with backslashes unless
+// the backslashes are not escapes in the NO_BACKSLASH_ESCAPES sql_mode
+func writeStringLiteral(buf *strings.Builder, s string, noBackslashEscapes bool) {
+	buf.WriteByte('\'')
+	if noBackslashEscapes {
+		buf.WriteString(strings.Replace(s, "'", "''", -1))
+	} else {
+		writeEscaped(buf, s)
+	}
+	buf.WriteByte('\'')
+}
@@ -0,0 +1,136 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// LockWait is the behaviour of the locking read on the rows locked by the other transactions
+type LockWait uint8
+
+const (
+	// LockWaitDefault waits for the locks up to innodb_lock_wait_timeout
+	LockWaitDefault LockWait = iota
+	// LockNoWait fails with ErrRowLocked at once
+	LockNoWait
+	// LockSkipLocked skips the locked rows
+	LockSkipLocked
+)
+
+func writeLockWait(sqlBuf *SqlBuffer, wait LockWait) {
+	switch wait {
+	case LockNoWait:
+		sqlBuf.WriteString(" NOWAIT")
+	case LockSkipLocked:
+		sqlBuf.WriteString(" SKIP LOCKED")
+	}
+}
+
+// lockedRowError returns ErrRowLocked for the lock wait timeout of the NOWAIT read, MariaDB reports it so
+func lockedRowError(wait LockWait, err error) error {
+	if wait == LockNoWait && errors.Is(err, ErrLockWaitTimeout) && !errors.Is(err, ErrRowLocked) {
+		return fmt.Errorf("%w: %s", ErrRowLocked, err.Error())
+	}
+
+	return err
+}
+
+// GetByPKForUpdate locks the row with the primary key with SELECT ... FOR UPDATE and returns its fields or nil
+// if there is no such row, see GetByPKsForUpdate
+func (s *MySQL) GetByPKForUpdate(ctx context.Context, m model.IModel, key interface{}, fieldsNames []string, wait LockWait) (map[string]interface{}, error) {
+	data, err := s.GetByPKsForUpdate(ctx, m, []interface{}{key}, fieldsNames, wait)
+	if err != nil || data.Len() == 0 {
+		return nil, err
+	}
+
+	return data.Maps()[0], nil
+}
+
+// GetByPKsForUpdate locks the rows with the primary keys with SELECT ... FOR UPDATE in the transaction of the context
+// and returns their fields in the order of the keys, the missing and the skipped rows are not returned. The keys
+// are sorted and the rows are locked in the order of the primary key, so the transactions locking the overlapping
+// sets of the rows with it do not deadlock each other. NOWAIT and SKIP LOCKED require MySQL 8.0 or MariaDB 10.6.
+//
+// The locks are held until the end of the transaction. The locks taken in a nested transaction survive the release
+// of its savepoint and the rollback to it as well.
+func (s *MySQL) GetByPKsForUpdate(ctx context.Context, m model.IModel, keys []interface{}, fieldsNames []string, wait LockWait) (*model.Data, error) {
+	if ctx.Value(s.transactionKey()) == nil {
+		return nil, qerror.Errorf("The rows of model '%s' can be locked in a transaction only", m.GetId())
+	}
+	if len(keys) == 0 {
+		return model.NewEmptyData(fieldsNames), nil
+	}
+
+	pkNames := m.GetPKFieldsNames()
+	pks := model.NewEmptyData(pkNames)
+	sorted := make([][]interface{}, len(keys))
+	for i, key := range keys {
+		values, err := keyValues(m, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := pks.Add(values); err != nil {
+			return nil, err
+		}
+		sorted[i] = values
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return compareKeys(sorted[i], sorted[j]) < 0 })
+
+	sortedKeys := make([]interface{}, len(sorted))
+	for i, values := range sorted {
+		sortedKeys[i] = values
+	}
+	filter, err := PKsFilter(m, sortedKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	orderBy := make([]model.Order, len(pkNames))
+	for i, name := range pkNames {
+		orderBy[i] = model.Order{FieldName: name}
+	}
+
+	selected := fieldsNames
+	for _, name := range pkNames {
+		selected = appendMissing(selected[:len(selected):len(selected)], name)
+	}
+
+	data, err := s.Select(ctx, m, selected, SelectOptions{
+		GetAllOptions: model.GetAllOptions{Filter: filter, OrderBy: orderBy, ForUpdate: true},
+		LockWait:      wait,
+	})
+	if err != nil {
+		return nil, lockedRowError(wait, err)
+	}
+
+	return orderByPKs(data, pks, fieldsNames)
+}
+
+// compareKeys compares the primary keys field by field, the incomparable values are compared as strings
+func compareKeys(a, b []interface{}) int {
+	for i := range a {
+		res, ok := compareValues(a[i], b[i])
+		if !ok {
+			sa, sb := fmt.Sprint(a[i]), fmt.Sprint(b[i])
+			res = compareOrdered(sa < sb, sa > sb)
+		}
+		if res != 0 {
+			return res
+		}
+	}
+
+	return 0
+}
+
+func (m *BaseModel) GetByPKForUpdate(ctx context.Context, key interface{}, fieldsNames []string, wait LockWait) (map[string]interface{}, error) {
+	return m.db.GetByPKForUpdate(ctx, m, key, fieldsNames, wait)
+}
+
+func (m *BaseModel) GetByPKsForUpdate(ctx context.Context, keys []interface{}, fieldsNames []string, wait LockWait) (*model.Data, error) {
+	return m.db.GetByPKsForUpdate(ctx, m, keys, fieldsNames, wait)
+}
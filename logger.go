@@ -0,0 +1,121 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+const ctx_debug_key = "MYSQL_DEBUG"
+
+// LogEntry is the executed statement. RowsAffected is -1 for the queries and the transaction control statements,
+// TxDepth is 0 outside of the transactions, 1 in the transaction and it is increased by each savepoint.
+// InterpolatedSQL is set if SetInterpolateSQL is enabled.
+type LogEntry struct {
+	SQL             string
+	Args            []interface{}
+	InterpolatedSQL string
+	Duration        time.Duration
+	RowsAffected    int64
+	TxDepth         int
+	Err             error
+}
+
+// Logger receives the executed statements at the debug level
+type Logger interface {
+	Debug(ctx context.Context, entry LogEntry)
+}
+
+// WarningLogger is the Logger receiving the warnings of the storage which are not the errors of the statements,
+// e.g. the panics of the metrics sink, the warnings are written to stderr for the loggers without Warn
+type WarningLogger interface {
+	Logger
+	Warn(ctx context.Context, message string)
+}
+
+// StderrLogger writes the statements and the warnings to stderr, it is the default logger
+type StderrLogger struct{}
+
+func (StderrLogger) Warn(_ context.Context, message string) {
+	fmt.Fprintln(os.Stderr, "Warning: "+message)
+}
+
+func (StderrLogger) Debug(_ context.Context, entry LogEntry) {
+	line := fmt.Sprintf("%s %v [%s", entry.SQL, entry.Args, entry.Duration)
+	if entry.InterpolatedSQL != "" {
+		line = fmt.Sprintf("%s [%s", entry.InterpolatedSQL, entry.Duration)
+	}
+	if entry.RowsAffected >= 0 {
+		line += fmt.Sprintf(", %d rows", entry.RowsAffected)
+	}
+	if entry.TxDepth > 0 {
+		line += fmt.Sprintf(", tx depth %d", entry.TxDepth)
+	}
+	if entry.Err != nil {
+		line += ", " + entry.Err.Error()
+	}
+
+	fmt.Fprintln(os.Stderr, line+"]")
+}
+
+// SetLogger sets the logger of the statements, nil disables the logging,
+// it must be called before the storage is used
+func (s *MySQL) SetLogger(logger Logger) {
+	s.logger = logger
+}
+
+// SetDebug enables the logging of every statement, it is enabled by default if the MYSQL_DEBUG environment
+// variable is set. It must be called before the storage is used.
+func (s *MySQL) SetDebug(enabled bool) {
+	s.debug = enabled
+}
+
+// WithDebug returns the context the statements of which are logged regardless of SetDebug,
+// including the transactions started with it
+func (s *MySQL) WithDebug(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctx_debug_key, true)
+}
+
+// isDebug reports whether the statements of the context are logged
+func (s *MySQL) isDebug(ctx context.Context) bool {
+	return s.debug || ctx.Value(ctx_debug_key) != nil
+}
+
+// logWarning passes the warning to the logger if it is WarningLogger and writes it to stderr otherwise,
+// the warnings are dropped if the logging is disabled
+func (s *MySQL) logWarning(ctx context.Context, message string) {
+	var logger Logger = StderrLogger{}
+	if s != nil {
+		logger = s.logger
+	}
+
+	switch logger := logger.(type) {
+	case nil:
+	case WarningLogger:
+		logger.Warn(ctx, message)
+	default:
+		StderrLogger{}.Warn(ctx, message)
+	}
+}
+
+// logStatement passes the statement to the logger if the debug is enabled
+func (s *MySQL) logStatement(ctx context.Context, query string, args []interface{}, duration time.Duration, rows int64, txDepth int, err error) {
+	if s.logger == nil || !s.isDebug(ctx) {
+		return
+	}
+
+	entry := LogEntry{
+		SQL:          query,
+		Args:         args,
+		Duration:     duration,
+		RowsAffected: rows,
+		TxDepth:      txDepth,
+		Err:          err,
+	}
+	if s.interpolateSQL {
+		entry.InterpolatedSQL = InterpolateSQL(query, args, s.loc)
+	}
+
+	s.logger.Debug(ctx, entry)
+}
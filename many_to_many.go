@@ -0,0 +1,397 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+type ManyToManyOpts struct {
+	// TableName is the name of the junction table, "_junction__<model1>__<model2>" by default
+	TableName string
+	// ExtraFields are stored in the junction table along with the foreign keys, see LinkWith
+	ExtraFields []IMysqlFieldDefinition
+	// Scope is the scope policy of the junction model, the scoped fields must be the extra ones
+	Scope ScopePolicy
+}
+
+// ManyToMany is the many-to-many relation between two models over a junction table
+type ManyToMany struct {
+	storage          *MySQL
+	model1, model2   model.IModel
+	junction         *BaseModel
+	fk1Fields        []string
+	fk2Fields        []string
+	extraFieldsNames []string
+}
+
+// AddManyToMany synthesizes the junction model with the foreign keys to both models as the composite primary key
+// and adds the many-to-many relations to the models, so they can be used with expr.Any and in GetAll as well
+func (s *MySQL) AddManyToMany(model1, model2 model.IModel, opts ManyToManyOpts) *ManyToMany {
+	tableName := opts.TableName
+	if tableName == "" {
+		tableName = "_junction__" + model1.GetId() + "__" + model2.GetId()
+	}
+
+	r := &ManyToMany{storage: s, model1: model1, model2: model2}
+
+	fields := make([]IMysqlFieldDefinition, 0, len(model1.GetPKFieldsNames())+len(model2.GetPKFieldsNames())+len(opts.ExtraFields))
+	for _, pkFieldName := range model1.GetPKFieldsNames() {
+		fkName := "fk_" + model1.GetId() + "_" + pkFieldName
+		r.fk1Fields = append(r.fk1Fields, fkName)
+		fields = append(fields, model1.GetFieldDefinition(pkFieldName).CloneForFK(fkName, "FK field", true).(IMysqlFieldDefinition))
+	}
+	for _, pkFieldName := range model2.GetPKFieldsNames() {
+		fkName := "fk_" + model2.GetId() + "_" + pkFieldName
+		r.fk2Fields = append(r.fk2Fields, fkName)
+		fields = append(fields, model2.GetFieldDefinition(pkFieldName).CloneForFK(fkName, "FK field", true).(IMysqlFieldDefinition))
+	}
+	for _, field := range opts.ExtraFields {
+		r.extraFieldsNames = append(r.extraFieldsNames, field.GetId())
+		fields = append(fields, field)
+	}
+
+	r.junction = NewBaseModel(s, tableName, fields, nil, BaseModelOpts{
+		BaseModelOpts: model.BaseModelOpts{
+			PkFieldsNames: append(append([]string{}, r.fk1Fields...), r.fk2Fields...),
+		},
+		Indexes: []Index{{FieldNames: r.fk2Fields}},
+		Scope:   opts.Scope,
+	})
+
+	model1.AddRelation(model.Relation{
+		ExtModel:                 model2,
+		RelationType:             model.RELATION_MANY_TO_MANY,
+		IsRequired:               true,
+		LocalFieldsNames:         model1.GetPKFieldsNames(),
+		FkFieldsNames:            model2.GetPKFieldsNames(),
+		JunctionModel:            r.junction,
+		JunctionLocalFieldsNames: r.fk1Fields,
+		JunctionFkFieldsNames:    r.fk2Fields,
+	}, "", nil)
+
+	model2.AddRelation(model.Relation{
+		ExtModel:                 model1,
+		RelationType:             model.RELATION_MANY_TO_MANY,
+		IsRequired:               true,
+		LocalFieldsNames:         model2.GetPKFieldsNames(),
+		FkFieldsNames:            model1.GetPKFieldsNames(),
+		JunctionModel:            r.junction,
+		JunctionLocalFieldsNames: r.fk2Fields,
+		JunctionFkFieldsNames:    r.fk1Fields,
+		IsBack:                   true,
+	}, "", nil)
+
+	r.junction.AddRelation(model.Relation{
+		ExtModel:         model1,
+		RelationType:     model.RELATION_MANY_TO_ONE,
+		IsRequired:       true,
+		LocalFieldsNames: r.fk1Fields,
+		FkFieldsNames:    model1.GetPKFieldsNames(),
+	}, "", nil)
+
+	r.junction.AddRelation(model.Relation{
+		ExtModel:         model2,
+		RelationType:     model.RELATION_MANY_TO_ONE,
+		IsRequired:       true,
+		LocalFieldsNames: r.fk2Fields,
+		FkFieldsNames:    model2.GetPKFieldsNames(),
+	}, "", nil)
+
+	return r
+}
+
+func (r *ManyToMany) GetJunctionModel() *BaseModel {
+	return r.junction
+}
+
+// Link links the rows by their primary keys, a composite key is passed as a slice. Linking the linked rows
+// is not an error.
+func (r *ManyToMany) Link(ctx context.Context, id1, id2 interface{}) error {
+	return r.LinkWith(ctx, id1, id2, nil)
+}
+
+// LinkWith links the rows and sets the extra fields of the link, the extra fields of an existing link are updated
+func (r *ManyToMany) LinkWith(ctx context.Context, id1, id2 interface{}, values map[string]interface{}) error {
+	sqlBuf := r.storage.ScopedSqlBuffer(ctx)
+	if err := r.writeLinkSQL(sqlBuf, id1, id2, values); err != nil {
+		return err
+	}
+
+	_, err := r.storage.Exec(withQuery(ctx, r.junction.GetId(), "insert"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+
+	return err
+}
+
+func (r *ManyToMany) writeLinkSQL(sqlBuf *SqlBuffer, id1, id2 interface{}, values map[string]interface{}) error {
+	pk1, err := pkValues(r.model1, id1)
+	if err != nil {
+		return err
+	}
+	pk2, err := pkValues(r.model2, id2)
+	if err != nil {
+		return err
+	}
+
+	fieldsNames := append(append([]string{}, r.fk1Fields...), r.fk2Fields...)
+	row := append(append([]interface{}{}, pk1...), pk2...)
+	var extraNames []string
+	for _, name := range r.extraFieldsNames {
+		if value, exists := values[name]; exists {
+			extraNames = append(extraNames, name)
+			row = append(row, value)
+		}
+	}
+	if len(extraNames) != len(values) {
+		return qerror.Errorf("Unknown extra field in the link values, the junction fields are %v", r.extraFieldsNames)
+	}
+	fieldsNames = append(fieldsNames, extraNames...)
+
+	linkValues := make(map[string]interface{}, len(fieldsNames))
+	for i, name := range fieldsNames {
+		linkValues[name] = row[i]
+	}
+	missing, err := sqlBuf.scopeRow(r.storage, r.junction, linkValues)
+	if err != nil {
+		return err
+	}
+	scopeNames := make([]string, 0, len(missing))
+	for name := range missing {
+		scopeNames = append(scopeNames, name)
+	}
+	sort.Strings(scopeNames)
+	for _, name := range scopeNames {
+		fieldsNames, row = append(fieldsNames, name), append(row, missing[name])
+	}
+
+	if len(extraNames) == 0 {
+		sqlBuf.WriteString("INSERT IGNORE INTO ")
+	} else {
+		sqlBuf.WriteString("INSERT INTO ")
+	}
+	sqlBuf.WriteTable(r.junction)
+	sqlBuf.WriteByte('(')
+	sqlBuf.WriteIdentifiersList(fieldsNames)
+	sqlBuf.WriteString(")VALUES(")
+	sqlBuf.WriteValuesList(row)
+	sqlBuf.WriteByte(')')
+
+	if len(extraNames) > 0 {
+		sqlBuf.WriteString("ON DUPLICATE KEY UPDATE ")
+		for i, name := range extraNames {
+			if i > 0 {
+				sqlBuf.WriteByte(',')
+			}
+			sqlBuf.WriteIdentifier(name)
+			sqlBuf.WriteString("=VALUES(")
+			sqlBuf.WriteIdentifier(name)
+			sqlBuf.WriteByte(')')
+		}
+	}
+
+	return nil
+}
+
+// Unlink removes the link between the rows, unlinking the rows which are not linked is not an error
+func (r *ManyToMany) Unlink(ctx context.Context, id1, id2 interface{}) error {
+	pk1, err := pkValues(r.model1, id1)
+	if err != nil {
+		return err
+	}
+	pk2, err := pkValues(r.model2, id2)
+	if err != nil {
+		return err
+	}
+
+	sqlBuf := r.storage.ScopedSqlBuffer(ctx)
+	sqlBuf.WriteString("DELETE FROM ")
+	sqlBuf.WriteTable(r.junction)
+	sqlBuf.WriteString(" WHERE ")
+	for i, name := range append(append([]string{}, r.fk1Fields...), r.fk2Fields...) {
+		if i > 0 {
+			sqlBuf.WriteString(" AND ")
+		}
+		sqlBuf.WriteIdentifier(name)
+		sqlBuf.WriteByte('=')
+		sqlBuf.WriteValue(append(append([]interface{}{}, pk1...), pk2...)[i])
+	}
+	if scope := sqlBuf.scoped(r.storage, r.junction, nil); scope != nil {
+		sqlBuf.WriteString(" AND (")
+		scope.GetProcessor(r.storage.exprProcessor.ForModel(r.junction)).(WriteFunc)(sqlBuf)
+		sqlBuf.WriteByte(')')
+	}
+	if err := sqlBuf.Err(); err != nil {
+		return err
+	}
+
+	_, err = r.storage.Exec(withQuery(ctx, r.junction.GetId(), "delete"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+
+	return err
+}
+
+// GetLinked returns the fields of the model2 rows linked to the model1 rows with the primary keys ids in one query.
+// The rows are grouped by the ids as they are passed, not by the scanned values of the junction, so GetLinked(ctx,
+// []interface{}{1}, ...) returns the rows under the key 1. A composite key is formatted with fmt.Sprint.
+func (r *ManyToMany) GetLinked(ctx context.Context, ids []interface{}, fieldsNames []string, filter model.IExpression) (map[interface{}][]map[string]interface{}, error) {
+	res := make(map[interface{}][]map[string]interface{})
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	sqlBuf := r.storage.ScopedSqlBuffer(ctx)
+	if err := r.writeGetLinkedSQL(sqlBuf, ids, fieldsNames, filter); err != nil {
+		return nil, err
+	}
+
+	rows, err := r.storage.RawQuery(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data, err := scanData(rows, func(i int, name string) model.IFieldDefinition {
+		if i < len(r.fk1Fields) {
+			return r.junction.GetFieldDefinition(name)
+		}
+		return r.model2.GetFieldDefinition(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The scanned values have the driver types, the ids are found by their text
+	keys := make(map[string]interface{}, len(ids))
+	if len(r.fk1Fields) == 1 {
+		for _, id := range ids {
+			keys[fmt.Sprint(derefValue(id))] = id
+		}
+	}
+
+	for _, row := range data.Data() {
+		var key interface{} = fmt.Sprint(row[:len(r.fk1Fields)])
+		if len(r.fk1Fields) == 1 {
+			var ok bool
+			if key, ok = keys[fmt.Sprint(derefValue(row[0]))]; !ok {
+				key = row[0]
+			}
+		}
+
+		rowMap := make(map[string]interface{}, len(fieldsNames))
+		for i, name := range fieldsNames {
+			rowMap[name] = row[len(r.fk1Fields)+i]
+		}
+		res[key] = append(res[key], rowMap)
+	}
+
+	return res, nil
+}
+
+func (r *ManyToMany) writeGetLinkedSQL(sqlBuf *SqlBuffer, ids []interface{}, fieldsNames []string, filter model.IExpression) error {
+	if err := r.storage.checkStorage(r.model2); err != nil {
+		return err
+	}
+
+	junctionTable, model2Table := TableName(r.junction), TableName(r.model2)
+
+	sqlBuf.WriteString("SELECT ")
+	for i, name := range r.fk1Fields {
+		if i > 0 {
+			sqlBuf.WriteByte(',')
+		}
+		sqlBuf.WriteString(QuoteIdent(junctionTable, name))
+	}
+	for _, name := range fieldsNames {
+		field := r.model2.GetFieldDefinition(name)
+		if field == nil || field.IsDerivable() {
+			return qerror.Errorf("Unknown field '%s' in model '%s'", name, r.model2.GetId())
+		}
+		sqlBuf.WriteByte(',')
+		sqlBuf.WriteString(QuoteIdent(model2Table, name))
+	}
+
+	sqlBuf.WriteString(" FROM ")
+	sqlBuf.WriteTable(r.model2)
+	sqlBuf.WriteString(" JOIN ")
+	sqlBuf.WriteTable(r.junction)
+	sqlBuf.WriteString(" ON ")
+	for i, name := range r.fk2Fields {
+		if i > 0 {
+			sqlBuf.WriteString(" AND ")
+		}
+		sqlBuf.WriteString(QuoteIdent(junctionTable, name))
+		sqlBuf.WriteByte('=')
+		sqlBuf.WriteString(QuoteIdent(model2Table, r.model2.GetPKFieldsNames()[i]))
+	}
+
+	sqlBuf.WriteString(" WHERE ")
+	if len(r.fk1Fields) > 1 {
+		sqlBuf.WriteByte('(')
+	}
+	for i, name := range r.fk1Fields {
+		if i > 0 {
+			sqlBuf.WriteByte(',')
+		}
+		sqlBuf.WriteString(QuoteIdent(junctionTable, name))
+	}
+	if len(r.fk1Fields) > 1 {
+		sqlBuf.WriteByte(')')
+	}
+	sqlBuf.WriteString(" IN (")
+	for i, id := range ids {
+		pk, err := pkValues(r.model1, id)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			sqlBuf.WriteByte(',')
+		}
+		if len(pk) > 1 {
+			sqlBuf.WriteByte('(')
+			sqlBuf.WriteValuesList(pk)
+			sqlBuf.WriteByte(')')
+		} else {
+			sqlBuf.WriteValue(pk[0])
+		}
+	}
+	sqlBuf.WriteByte(')')
+
+	if filter != nil {
+		sqlBuf.WriteString(" AND (")
+		filter.GetProcessor(r.storage.exprProcessor.ForModel(r.model2)).(WriteFunc)(sqlBuf)
+		sqlBuf.WriteByte(')')
+	}
+
+	// The columns of the scope filters are qualified, both tables may have the scoped fields
+	for _, m := range []model.IModel{r.model2, r.junction} {
+		if scope := sqlBuf.scoped(r.storage, m, nil); scope != nil {
+			p := r.storage.exprProcessor.ForModel(m)
+			p.qualify = true
+			sqlBuf.WriteString(" AND (")
+			scope.GetProcessor(p).(WriteFunc)(sqlBuf)
+			sqlBuf.WriteByte(')')
+		}
+	}
+
+	return sqlBuf.Err()
+}
+
+// pkValues returns the values of the primary key of the model, a composite key must be passed as a slice
+func pkValues(m model.IModel, id interface{}) ([]interface{}, error) {
+	pkLen := len(m.GetPKFieldsNames())
+	if pkLen == 1 {
+		return []interface{}{id}, nil
+	}
+
+	values, err := sliceValues(id)
+	if err != nil {
+		return nil, qerror.Errorf("The primary key of model '%s' is composite: %s", m.GetId(), err.Error())
+	}
+	if len(values) != pkLen {
+		return nil, qerror.Errorf("The primary key of model '%s' has %d fields, got %d values", m.GetId(), pkLen, len(values))
+	}
+
+	return values, nil
+}
@@ -0,0 +1,118 @@
+package mysql
+
+import (
+	"context"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+const (
+	ctx_unmasked_key       = "MYSQL_UNMASKED"
+	ctx_masks_deferred_key = "MYSQL_MASKS_DEFERRED"
+)
+
+// IMaskedFieldDefinition is implemented by the fields the read values of which are masked, see the ReadMask option
+// of the fields. The mask is called with the non-NULL values dereferenced, e.g. it returns the last four digits
+// of the card number, and its result replaces the value as is. The filters compare the stored values.
+type IMaskedFieldDefinition interface {
+	GetReadMask() func(value interface{}) interface{}
+}
+
+func readMaskOf(field model.IFieldDefinition) func(value interface{}) interface{} {
+	if masked, ok := field.(IMaskedFieldDefinition); ok {
+		return masked.GetReadMask()
+	}
+
+	return nil
+}
+
+// maskValue returns the masked value, NULL is not masked
+func maskValue(mask func(value interface{}) interface{}, value interface{}) interface{} {
+	if value = derefValue(value); value == nil {
+		return nil
+	}
+
+	return mask(value)
+}
+
+// WithUnmasked disables the read masks of the fields for the reads with the context, e.g. for the privileged paths
+func (s *MySQL) WithUnmasked(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctx_unmasked_key, true)
+}
+
+func isUnmasked(ctx context.Context) bool {
+	unmasked, _ := ctx.Value(ctx_unmasked_key).(bool)
+	return unmasked
+}
+
+// SetMaskDerivableInputs sets whether the derivable fields are computed from the masked values of the fields
+// they depend on, it is true by default. Otherwise they are computed from the stored values and the masks
+// are applied to the requested fields only. It must be called before the storage is used.
+func (s *MySQL) SetMaskDerivableInputs(enabled bool) {
+	s.unmaskedDerivableInputs = !enabled
+}
+
+// masksApplied reports whether the read masks of the model are applied to the read values with the context
+func masksApplied(ctx context.Context, m model.IModel) bool {
+	if isUnmasked(ctx) {
+		return false
+	}
+	deferred, _ := ctx.Value(ctx_masks_deferred_key).(string)
+
+	return deferred != m.GetId()
+}
+
+// maskData masks the columns of the model fields with the read masks in place
+func maskData(ctx context.Context, m model.IModel, data *model.Data) *model.Data {
+	if data == nil || !masksApplied(ctx, m) {
+		return data
+	}
+
+	for i, name := range data.Fields() {
+		field := m.GetFieldDefinition(name)
+		if field == nil {
+			continue
+		}
+		mask := readMaskOf(field)
+		if mask == nil {
+			continue
+		}
+		for _, row := range data.Data() {
+			row[i] = maskValue(mask, row[i])
+		}
+	}
+
+	return data
+}
+
+// checkMaskedColumns rejects the masked fields of the typed columns unless the context is unmasked,
+// the masked values may be of the other type
+func checkMaskedColumns(ctx context.Context, m model.IModel, fieldsNames []string) error {
+	if !masksApplied(ctx, m) {
+		return nil
+	}
+
+	for _, name := range fieldsNames {
+		if field := m.GetFieldDefinition(name); field != nil && readMaskOf(field) != nil {
+			return qerror.Errorf("The field '%s' in model '%s' is masked and cannot be read into a typed column", name, m.GetId())
+		}
+	}
+
+	return nil
+}
+
+// GetAll is model.BaseModel.GetAll with the virtual fields of the context, see WithVirtualFields. The derivable
+// fields are computed from the stored values if the storage does not mask their inputs, see SetMaskDerivableInputs
+func (m *BaseModel) GetAll(ctx context.Context, fieldsNames []string, opts model.GetAllOptions) (*model.Data, error) {
+	if !m.db.unmaskedDerivableInputs || !masksApplied(ctx, m) {
+		return m.getAllVirtual(ctx, fieldsNames, opts)
+	}
+
+	data, err := m.getAllVirtual(context.WithValue(ctx, ctx_masks_deferred_key, m.GetId()), fieldsNames, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return maskData(ctx, m, data), nil
+}
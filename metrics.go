@@ -0,0 +1,264 @@
+package mysql
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ctx_query_key = "MYSQL_QUERY"
+	// TxModel is the model of the transaction control statements passed to MetricsSink
+	TxModel = "tx"
+)
+
+// MetricsSink receives the results of the statements. The operation is select, insert, update, delete or other,
+// for the transaction control statements it is begin, savepoint, release, commit or rollback and the model is TxModel.
+// The model is empty for the raw statements. The rows are the affected rows, -1 for the queries.
+// The callbacks of Parallel are passed with the parallel operation and the empty model,
+// the failed attempts of the retried reads are passed with the retry operation, see RetryPolicy.
+type MetricsSink interface {
+	ObserveQuery(model, op string, duration time.Duration, rows int64, err error)
+}
+
+type queryInfo struct {
+	model, op string
+}
+
+type metricsSinkHolder struct {
+	sink MetricsSink
+}
+
+// SetMetricsSink sets the sink of the statements metrics, nil disables the metrics
+func (s *MySQL) SetMetricsSink(sink MetricsSink) {
+	s.metricsSink.Store(metricsSinkHolder{sink})
+}
+
+// withQuery returns the context the model and the operation of the next statement are passed to the metrics with
+func withQuery(ctx context.Context, model, op string) context.Context {
+	return context.WithValue(ctx, ctx_query_key, queryInfo{model, op})
+}
+
+// statementDone passes the statement result to the metrics sink and to the slow queries handler.
+// It must not be called with a mutex locked.
+func (s *MySQL) statementDone(ctx context.Context, query string, args []interface{}, start time.Time, rows int64, err error) {
+	duration := s.since(start)
+	spendQueryBudget(ctx, duration)
+
+	if holder, _ := s.metricsSink.Load().(metricsSinkHolder); holder.sink != nil {
+		info, ok := ctx.Value(ctx_query_key).(queryInfo)
+		if !ok {
+			info.op = statementOp(query)
+		}
+
+		s.observeQuery(ctx, holder.sink, info.model, info.op, duration, rows, err)
+	}
+
+	t, _ := ctx.Value(s.transactionKey()).(*transaction)
+	s.checkSlowQuery(ctx, query, args, duration, t != nil)
+	if s.isDebug(ctx) {
+		s.logStatement(ctx, query, args, duration, rows, t.depth(), err)
+	}
+}
+
+// txStatementDone reports the transaction control statement, the depth is the transaction depth
+// the statement is executed at
+func (s *MySQL) txStatementDone(ctx context.Context, op, query string, depth int, start time.Time, err error) {
+	duration := s.since(start)
+
+	if holder, _ := s.metricsSink.Load().(metricsSinkHolder); holder.sink != nil {
+		s.observeQuery(ctx, holder.sink, TxModel, op, duration, 0, err)
+	}
+
+	s.checkSlowQuery(ctx, query, nil, duration, op != "begin")
+	s.logStatement(ctx, query, nil, duration, -1, depth, err)
+}
+
+// observeQuery calls the sink, a panic in the sink is recovered and logged as the warning in the debug mode
+func (s *MySQL) observeQuery(ctx context.Context, sink MetricsSink, model, op string, duration time.Duration, rows int64, err error) {
+	defer func() {
+		if r := recover(); r != nil && s.isDebug(ctx) {
+			s.logWarning(ctx, fmt.Sprintf("Metrics sink panic: %v", r))
+		}
+	}()
+
+	sink.ObserveQuery(model, op, duration, rows, err)
+}
+
+// MetricsSnapshot are the gauges of the connections pool of the primary server and of the transactions
+// of the storage at Time, see MySQL.MetricsSnapshot
+type MetricsSnapshot struct {
+	Time time.Time
+	// OpenConnections, InUse, Idle, WaitCount and WaitDuration are the ones of sql.DBStats
+	OpenConnections int
+	InUse           int
+	Idle            int
+	WaitCount       int64
+	WaitDuration    time.Duration
+	// OpenTransactions is the number of the transactions started by the storage and not finished yet
+	// including the consistent snapshots, MaxTransactionAge is the age of the oldest of them
+	OpenTransactions  int
+	MaxTransactionAge time.Duration
+}
+
+// SnapshotSink is implemented by the MetricsSink receiving the snapshots of StartMetricsSampler
+type SnapshotSink interface {
+	ObserveSnapshot(snapshot MetricsSnapshot)
+}
+
+// MetricsSnapshot returns the current gauges of the pool and of the transactions, it may be called
+// by the collector of any metrics library on scrape
+func (s *MySQL) MetricsSnapshot() MetricsSnapshot {
+	stats := s.Stats()
+	res := MetricsSnapshot{
+		Time:            s.now(),
+		OpenConnections: stats.OpenConnections,
+		InUse:           stats.InUse,
+		Idle:            stats.Idle,
+		WaitCount:       stats.WaitCount,
+		WaitDuration:    stats.WaitDuration,
+	}
+
+	s.closeMtx.Lock()
+	defer s.closeMtx.Unlock()
+
+	res.OpenTransactions = len(s.openTxs)
+	for _, start := range s.openTxs {
+		if age := res.Time.Sub(start); age > res.MaxTransactionAge {
+			res.MaxTransactionAge = age
+		}
+	}
+
+	return res
+}
+
+// StartMetricsSampler passes MetricsSnapshot to the metrics sink implementing SnapshotSink every interval
+// of the clock of the storage until stop is called, the sink set later by SetMetricsSink is used too
+func (s *MySQL) StartMetricsSampler(interval time.Duration) (stop func()) {
+	var (
+		mtx       sync.Mutex
+		stopped   bool
+		stopTimer func() bool
+	)
+
+	var sample func()
+	sample = func() {
+		mtx.Lock()
+		if stopped {
+			mtx.Unlock()
+			return
+		}
+		stopTimer = s.afterFunc(interval, sample)
+		mtx.Unlock()
+
+		if holder, _ := s.metricsSink.Load().(metricsSinkHolder); holder.sink != nil {
+			if sink, ok := holder.sink.(SnapshotSink); ok {
+				s.observeSnapshot(sink, s.MetricsSnapshot())
+			}
+		}
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	stopTimer = s.afterFunc(interval, sample)
+
+	return func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if !stopped {
+			stopped = true
+			stopTimer()
+		}
+	}
+}
+
+// observeSnapshot calls the sink, a panic in the sink is recovered and logged as the warning in the debug mode
+func (s *MySQL) observeSnapshot(sink SnapshotSink, snapshot MetricsSnapshot) {
+	defer func() {
+		if r := recover(); r != nil && s.debug {
+			s.logWarning(context.Background(), fmt.Sprintf("Metrics sink panic: %v", r))
+		}
+	}()
+
+	sink.ObserveSnapshot(snapshot)
+}
+
+// statementOp returns the operation of the raw statement by its first keyword
+func statementOp(query string) string {
+	query = strings.TrimLeft(query, " \t\r\n(")
+	if i := strings.IndexAny(query, " \t\r\n("); i > 0 {
+		query = query[:i]
+	}
+
+	switch op := strings.ToLower(query); op {
+	case "select", "insert", "update", "delete":
+		return op
+	case "replace":
+		return "insert"
+	case "with":
+		return "select"
+	default:
+		return "other"
+	}
+}
+
+// ExpvarMetrics is the MetricsSink publishing the counters of the statements by "model.op" keys:
+// count, errors, rows and duration in nanoseconds. The gauges of the snapshots of StartMetricsSampler
+// are published by their names in snake case, the durations are in nanoseconds.
+type ExpvarMetrics struct {
+	Count    *expvar.Map
+	Errors   *expvar.Map
+	Rows     *expvar.Map
+	Duration *expvar.Map
+	Gauges   *expvar.Map
+}
+
+// NewExpvarMetrics publishes the maps with the name prefix, e.g. "mysql.count", so it must be called once per name
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{
+		Count:    expvar.NewMap(name + ".count"),
+		Errors:   expvar.NewMap(name + ".errors"),
+		Rows:     expvar.NewMap(name + ".rows"),
+		Duration: expvar.NewMap(name + ".duration_ns"),
+		Gauges:   expvar.NewMap(name + ".gauges"),
+	}
+}
+
+func (m *ExpvarMetrics) ObserveQuery(model, op string, duration time.Duration, rows int64, err error) {
+	key := op
+	if model != "" {
+		key = model + "." + op
+	}
+
+	m.Count.Add(key, 1)
+	m.Duration.Add(key, int64(duration))
+	if rows > 0 {
+		m.Rows.Add(key, rows)
+	}
+	if err != nil {
+		m.Errors.Add(key, 1)
+	}
+}
+
+func (m *ExpvarMetrics) ObserveSnapshot(snapshot MetricsSnapshot) {
+	if m.Gauges == nil {
+		return
+	}
+
+	for name, value := range map[string]int64{
+		"open_connections":       int64(snapshot.OpenConnections),
+		"in_use":                 int64(snapshot.InUse),
+		"idle":                   int64(snapshot.Idle),
+		"wait_count":             snapshot.WaitCount,
+		"wait_duration_ns":       int64(snapshot.WaitDuration),
+		"open_transactions":      int64(snapshot.OpenTransactions),
+		"max_transaction_age_ns": int64(snapshot.MaxTransactionAge),
+	} {
+		v := new(expvar.Int)
+		v.Set(value)
+		m.Gauges.Set(name, v)
+	}
+}
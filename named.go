@@ -0,0 +1,220 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"strings"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// namedQuery is the statement template of RegisterNamedQuery, params are the names of the positional placeholders
+// of the SQL in their order
+type namedQuery struct {
+	sql    string
+	op     string
+	params []string
+}
+
+// RegisterNamedQuery registers the hand-written statement of the model run by ExecNamed or QueryNamed by the name.
+// The parameters are referred to as :name in the template and must be listed in paramNames, each of them may be
+// used several times. The template is prepared on the server to check it if the storage is connected.
+func (s *MySQL) RegisterNamedQuery(m model.IModel, name, sqlTemplate string, paramNames []string) error {
+	query, params, err := parseNamedQuery(sqlTemplate)
+	if err != nil {
+		return qerror.Errorf("The named query '%s' of model '%s': %s", name, m.GetId(), err.Error())
+	}
+
+	declared := make(map[string]bool, len(paramNames))
+	for _, param := range paramNames {
+		declared[param] = false
+	}
+	for _, param := range params {
+		if _, exists := declared[param]; !exists {
+			return qerror.Errorf("The named query '%s' of model '%s' uses the undeclared parameter '%s'", name, m.GetId(), param)
+		}
+		declared[param] = true
+	}
+	for _, param := range paramNames {
+		if !declared[param] {
+			return qerror.Errorf("The named query '%s' of model '%s' does not use the parameter '%s'", name, m.GetId(), param)
+		}
+	}
+
+	if s.db != nil {
+		// There is no caller to cancel the check, the template is checked by the registration of the model
+		stmt, err := s.db.PrepareContext(context.Background(), query)
+		var mysqlErr *mysqldriver.MySQLError
+		if errors.As(err, &mysqlErr) {
+			return qerror.Errorf("The named query '%s' of model '%s' is rejected by the server: %s", name, m.GetId(), err.Error())
+		}
+		// The other errors mean the server is not available to check the template
+		if err == nil {
+			stmt.Close()
+		}
+	}
+
+	s.namedQueriesMtx.Lock()
+	defer s.namedQueriesMtx.Unlock()
+	if s.namedQueries == nil {
+		s.namedQueries = make(map[string]*namedQuery)
+	}
+	s.namedQueries[namedQueryKey(m, name)] = &namedQuery{sql: query, op: statementOp(query), params: params}
+
+	return nil
+}
+
+// ExecNamed runs the statement registered with RegisterNamedQuery with the parameters, the values of the parameters
+// named as the fields of the model are converted as the values of the fields are
+func (s *MySQL) ExecNamed(ctx context.Context, m model.IModel, name string, params map[string]interface{}) (driver.Result, error) {
+	q, args, err := s.namedQueryArgs(m, name, params)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Exec(withQuery(ctx, m.GetId(), q.op), q.sql, args...)
+}
+
+// QueryNamed runs the query registered with RegisterNamedQuery as ExecNamed does and returns its rows,
+// the columns named as the fields of the model are converted to the field types as Select does
+func (s *MySQL) QueryNamed(ctx context.Context, m model.IModel, name string, params map[string]interface{}) (*model.Data, error) {
+	q, args, err := s.namedQueryArgs(m, name, params)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.RawQuery(withQuery(ctx, m.GetId(), q.op), q.sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res, err := scanData(rows, func(_ int, name string) model.IFieldDefinition {
+		if field := m.GetFieldDefinition(name); field != nil && !field.IsDerivable() {
+			return field
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return maskData(ctx, m, res), nil
+}
+
+// namedQueryArgs returns the named query and its positional arguments, all the parameters must be passed
+func (s *MySQL) namedQueryArgs(m model.IModel, name string, params map[string]interface{}) (*namedQuery, []interface{}, error) {
+	s.namedQueriesMtx.RLock()
+	q := s.namedQueries[namedQueryKey(m, name)]
+	s.namedQueriesMtx.RUnlock()
+	if q == nil {
+		return nil, nil, qerror.Errorf("Unknown named query '%s' of model '%s'", name, m.GetId())
+	}
+
+	used := 0
+	args := make([]interface{}, len(q.params))
+	for i, param := range q.params {
+		value, exists := params[param]
+		if !exists {
+			return nil, nil, qerror.Errorf("The parameter '%s' of the named query '%s' of model '%s' is not passed", param, name, m.GetId())
+		}
+		if field := m.GetFieldDefinition(param); field != nil && isConvertedField(field, s.loc) {
+			value = fieldValue(field, value, s.loc)
+		}
+		args[i] = value
+		if indexOf(q.params[:i], param) < 0 {
+			used++
+		}
+	}
+	if used != len(params) {
+		var extra []string
+		for param := range params {
+			if indexOf(q.params, param) < 0 {
+				extra = append(extra, param)
+			}
+		}
+		sort.Strings(extra)
+		return nil, nil, qerror.Errorf("Unknown parameters %s of the named query '%s' of model '%s'", strings.Join(extra, ", "), name, m.GetId())
+	}
+
+	return q, args, nil
+}
+
+func namedQueryKey(m model.IModel, name string) string {
+	return m.GetId() + "." + name
+}
+
+// parseNamedQuery replaces the :name parameters of the template with the placeholders and returns the names
+// of the parameters in their order. The quoted strings, the quoted identifiers and the comments are kept as is.
+func parseNamedQuery(template string) (string, []string, error) {
+	var (
+		buf    strings.Builder
+		params []string
+	)
+	isNameStart := func(c byte) bool { return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' }
+	isNameChar := func(c byte) bool { return isNameStart(c) || '0' <= c && c <= '9' }
+
+	for i := 0; i < len(template); {
+		c := template[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			start := i
+			for i++; i < len(template) && template[i] != c; i++ {
+				if template[i] == '\\' && c != '`' {
+					i++
+				}
+			}
+			if i >= len(template) {
+				return "", nil, qerror.Errorf("unterminated quoted string")
+			}
+			i++
+			buf.WriteString(template[start:i])
+
+		case strings.HasPrefix(template[i:], "/*"):
+			end := strings.Index(template[i+2:], "*/")
+			if end < 0 {
+				return "", nil, qerror.Errorf("unterminated comment")
+			}
+			buf.WriteString(template[i : i+end+4])
+			i += end + 4
+
+		case c == '#' || strings.HasPrefix(template[i:], "-- "):
+			end := strings.IndexByte(template[i:], '\n')
+			if end < 0 {
+				end = len(template) - i
+			}
+			buf.WriteString(template[i : i+end])
+			i += end
+
+		case c == '?':
+			return "", nil, qerror.Errorf("the positional placeholders are not allowed, use :name")
+
+		case c == ':' && i+1 < len(template) && isNameStart(template[i+1]) && (i == 0 || template[i-1] != ':'):
+			end := i + 1
+			for end < len(template) && isNameChar(template[end]) {
+				end++
+			}
+			params = append(params, template[i+1:end])
+			buf.WriteByte('?')
+			i = end
+
+		default:
+			buf.WriteByte(c)
+			i++
+		}
+	}
+
+	return buf.String(), params, nil
+}
+
+func (m *BaseModel) ExecNamed(ctx context.Context, name string, params map[string]interface{}) (driver.Result, error) {
+	return m.db.ExecNamed(ctx, m, name, params)
+}
+
+func (m *BaseModel) QueryNamed(ctx context.Context, name string, params map[string]interface{}) (*model.Data, error) {
+	return m.db.QueryNamed(ctx, m, name, params)
+}
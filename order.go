@@ -0,0 +1,129 @@
+package mysql
+
+import (
+	"reflect"
+
+	"github.com/go-qbit/model"
+)
+
+// NullsOrder defines the position of the NULLs in the ordering, MySQL sorts them first in the ascending order
+// and last in the descending one
+type NullsOrder int
+
+const (
+	// NullsDefault keeps the MySQL position of the NULLs
+	NullsDefault NullsOrder = iota
+	// NullsFirst sorts the NULLs before the other values
+	NullsFirst
+	// NullsLast sorts the NULLs after the other values
+	NullsLast
+)
+
+// NullsFirst returns the term sorting the NULLs before the other values
+func (o OrderExpr) NullsFirst() OrderExpr {
+	o.Nulls = NullsFirst
+	return o
+}
+
+// NullsLast returns the term sorting the NULLs after the other values
+func (o OrderExpr) NullsLast() OrderExpr {
+	o.Nulls = NullsLast
+	return o
+}
+
+// writeOrder writes the ordering term, MySQL has no NULLS FIRST and NULLS LAST so the IS NULL sort key
+// is prepended if the position of the NULLs differs from the default one
+func writeOrder(buf *SqlBuffer, p *ExprProcessor, order OrderExpr) {
+	if order.Nulls == NullsFirst && order.Desc || order.Nulls == NullsLast && !order.Desc {
+		order.Expr.GetProcessor(p).(WriteFunc)(buf)
+		if order.Nulls == NullsFirst {
+			buf.WriteString(" IS NULL DESC,")
+		} else {
+			buf.WriteString(" IS NULL,")
+		}
+	}
+
+	order.Expr.GetProcessor(p).(WriteFunc)(buf)
+	if order.Desc {
+		buf.WriteString(" DESC")
+	}
+}
+
+// testModeOrder returns the primary key fields ordering the SELECT without the ordering in the test mode,
+// the DISTINCT and the derived table SELECTs are ordered only if they select the primary key
+func (s *MySQL) testModeOrder(m model.IModel, fieldsNames []string, options SelectOptions) []string {
+	if !s.testMode || len(options.GetAllOptions.OrderBy)+len(options.OrderBy) > 0 || len(options.GroupBy) > 0 {
+		return nil
+	}
+
+	pkNames := m.GetPKFieldsNames()
+	if options.Distinct || options.OuterFilter != nil {
+		for _, name := range pkNames {
+			if !containsString(fieldsNames, name) {
+				return nil
+			}
+		}
+	}
+
+	return pkNames
+}
+
+// orderTiebreaker returns the primary key fields appended to the ordering of the page so the rows with
+// the equal sort values are returned in the same order by every query. It is empty if the ordering
+// is provably unique: it has all the fields of the primary key or of a unique index of the NOT NULL fields.
+func (s *MySQL) orderTiebreaker(m model.IModel, options SelectOptions) []string {
+	if options.Limit == 0 || len(options.GetAllOptions.OrderBy)+len(options.OrderBy) == 0 ||
+		options.Distinct || len(options.GroupBy) > 0 || options.OuterFilter != nil {
+		return nil
+	}
+
+	ordered := make(map[string]bool)
+	for _, order := range options.GetAllOptions.OrderBy {
+		ordered[order.FieldName] = true
+	}
+	for _, order := range options.OrderBy {
+		if info := inspectExpr(order.Expr); info.isField && (info.model == nil || info.model.GetId() == m.GetId()) {
+			ordered[info.fieldName] = true
+		}
+	}
+	covers := func(fieldsNames []string) bool {
+		for _, name := range fieldsNames {
+			if !ordered[name] {
+				return false
+			}
+		}
+		return true
+	}
+
+	pkNames := m.GetPKFieldsNames()
+	if len(pkNames) == 0 || covers(pkNames) {
+		return nil
+	}
+
+	s.modelsMtx.RLock()
+	registered := s.models[m.GetId()]
+	s.modelsMtx.RUnlock()
+	if dbModel, ok := registered.(interface{ GetIndexes() []Index }); ok {
+		for _, index := range dbModel.GetIndexes() {
+			if !index.Unique || !covers(index.FieldNames) {
+				continue
+			}
+			notNull := true
+			for _, name := range index.FieldNames {
+				notNull = notNull && m.GetFieldDefinition(name).GetType().Kind() != reflect.Ptr
+			}
+			if notNull {
+				return nil
+			}
+		}
+	}
+
+	var res []string
+	for _, name := range pkNames {
+		if !ordered[name] {
+			res = append(res, name)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,172 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-qbit/model"
+)
+
+const (
+	DefaultOutboxModelId      = "_outbox"
+	DefaultOutboxBatchSize    = 100
+	DefaultOutboxPollInterval = time.Second
+)
+
+// NewOutboxModel returns the model of the outbox table, the id is DefaultOutboxModelId if it is empty.
+// The primary key and the changed fields are stored as JSON objects.
+func NewOutboxModel(s *MySQL, id string) *BaseModel {
+	if id == "" {
+		id = DefaultOutboxModelId
+	}
+
+	return NewBaseModel(s, id, []IMysqlFieldDefinition{
+		&BigUintField{Id: "id", Caption: "ID", NotNull: true, AutoIncrement: true},
+		&BigUintField{Id: "tx_id", Caption: "Transaction ID", NotNull: true},
+		&VarCharField{Id: "model", Caption: "Model", Length: 255, NotNull: true},
+		&VarCharField{Id: "op", Caption: "Operation", Length: 16, NotNull: true},
+		&JSONField{Id: "pk", Caption: "Primary key", NotNull: true},
+		&JSONField{Id: "fields", Caption: "Fields"},
+	}, nil, BaseModelOpts{
+		BaseModelOpts: model.BaseModelOpts{PkFieldsNames: []string{"id"}},
+	})
+}
+
+// SetOutbox makes the captured changes be written to the outbox model, see NewOutboxModel, in the transaction
+// of the write, so the relay publishes each committed change exactly once, see OutboxReader. The write observers
+// are notified as well. It must be called before the storage is used.
+func (s *MySQL) SetOutbox(m model.IModel) {
+	s.outbox = m
+}
+
+// writeOutbox inserts the changes into the outbox in the transaction of the context
+func (s *MySQL) writeOutbox(ctx context.Context, changes []Change) error {
+	rows := make([][]interface{}, len(changes))
+	for i, change := range changes {
+		pk, err := json.Marshal(change.PK)
+		if err != nil {
+			return err
+		}
+		var fields *string
+		if change.Fields != nil {
+			buf, err := json.Marshal(change.Fields)
+			if err != nil {
+				return err
+			}
+			fields = new(string)
+			*fields = string(buf)
+		}
+		rows[i] = []interface{}{change.TxId, change.Model, change.Op, string(pk), fields}
+	}
+
+	_, err := s.insertRows(ctx, s.outbox, model.NewData([]string{"tx_id", "model", "op", "pk", "fields"}, rows), model.AddOptions{})
+
+	return err
+}
+
+// OutboxRecord is the change read from the outbox, the values of PK and Fields are decoded from JSON
+type OutboxRecord struct {
+	Id uint64
+	Change
+}
+
+type OutboxReaderOpts struct {
+	// BatchSize is the max number of the records passed to the handler at once, DefaultOutboxBatchSize by default
+	BatchSize int
+	// PollInterval is the pause of Run after the empty outbox, DefaultOutboxPollInterval by default
+	PollInterval time.Duration
+}
+
+// OutboxReader passes the records of the outbox to the handler in the order of the writes and deletes them
+// after the handler succeeds. The batch is locked while it is handled, so the concurrent readers wait for each other.
+type OutboxReader struct {
+	storage *MySQL
+	model   model.IModel
+	opts    OutboxReaderOpts
+}
+
+func NewOutboxReader(s *MySQL, m model.IModel, opts OutboxReaderOpts) *OutboxReader {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultOutboxBatchSize
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultOutboxPollInterval
+	}
+
+	return &OutboxReader{storage: s, model: m, opts: opts}
+}
+
+// ReadBatch passes the oldest records to the handler and returns their number, the records are kept if the handler
+// fails, so it must tolerate the records it handled before
+func (r *OutboxReader) ReadBatch(ctx context.Context, handler func(ctx context.Context, records []OutboxRecord) error) (int, error) {
+	var n int
+	err := r.storage.DoInTransaction(ctx, func(ctx context.Context) error {
+		data, err := r.storage.Select(ctx, r.model, []string{"id", "tx_id", "model", "op", "pk", "fields"}, SelectOptions{
+			GetAllOptions: model.GetAllOptions{
+				OrderBy:   []model.Order{{FieldName: "id"}},
+				Limit:     uint64(r.opts.BatchSize),
+				ForUpdate: true,
+			},
+		})
+		if err != nil || data.Len() == 0 {
+			return err
+		}
+
+		records := make([]OutboxRecord, data.Len())
+		ids := make([]interface{}, data.Len())
+		for i, row := range data.Maps() {
+			records[i].Id, _ = derefValue(row["id"]).(uint64)
+			records[i].TxId, _ = derefValue(row["tx_id"]).(uint64)
+			records[i].Model, _ = derefValue(row["model"]).(string)
+			records[i].Op, _ = derefValue(row["op"]).(string)
+			if pk, _ := derefValue(row["pk"]).(string); pk != "" {
+				if err := json.Unmarshal([]byte(pk), &records[i].PK); err != nil {
+					return err
+				}
+			}
+			if fields, _ := derefValue(row["fields"]).(string); fields != "" {
+				if err := json.Unmarshal([]byte(fields), &records[i].Fields); err != nil {
+					return err
+				}
+			}
+			ids[i] = records[i].Id
+		}
+
+		if err := handler(ctx, records); err != nil {
+			return err
+		}
+		n = len(records)
+
+		return r.storage.Delete(ctx, r.model, In(r.model.FieldExpr("id"), ids))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// Run reads the batches until the context is done or the handler fails, the outbox is polled
+// with PollInterval while it is empty
+func (r *OutboxReader) Run(ctx context.Context, handler func(ctx context.Context, records []OutboxRecord) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := r.ReadBatch(ctx, handler)
+		if err != nil {
+			return err
+		}
+		if n >= r.opts.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-r.storage.after(r.opts.PollInterval):
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package mysql
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-qbit/qerror"
+)
+
+// ParallelWorkers is the default maximum number of the callbacks run by Parallel at once
+var ParallelWorkers = 4
+
+type ParallelOpts struct {
+	// Workers is the maximum number of the callbacks run at once, ParallelWorkers by default
+	Workers int
+	// FailInTransaction makes Parallel to return an error for the context with a transaction,
+	// otherwise the callbacks are run sequentially then
+	FailInTransaction bool
+}
+
+// Parallel runs the independent callbacks concurrently with the default options, see ParallelWithOpts
+func (s *MySQL) Parallel(ctx context.Context, funcs ...func(ctx context.Context) error) error {
+	return s.ParallelWithOpts(ctx, ParallelOpts{}, funcs...)
+}
+
+// ParallelWithOpts runs the callbacks concurrently and returns the first error, the context of the other callbacks
+// is cancelled then and the callbacks not started yet are skipped. A transaction serializes the statements,
+// so the callbacks with a transaction in the context are run sequentially or refused depending on the options.
+// The duration of each callback is passed to the metrics sink with the "parallel" operation.
+func (s *MySQL) ParallelWithOpts(ctx context.Context, opts ParallelOpts, funcs ...func(ctx context.Context) error) error {
+	if s.InTransaction(ctx) {
+		if opts.FailInTransaction {
+			return qerror.Errorf("Cannot run the callbacks in parallel inside a transaction")
+		}
+
+		for _, f := range funcs {
+			if err := s.runParallelFunc(ctx, f); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = ParallelWorkers
+	}
+	if workers > len(funcs) {
+		workers = len(funcs)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	queue := make(chan func(ctx context.Context) error)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for f := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+
+				if err := s.runParallelFunc(ctx, f); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	for _, f := range funcs {
+		queue <- f
+	}
+	close(queue)
+	wg.Wait()
+
+	return firstErr
+}
+
+// runParallelFunc calls the callback, a panic is returned as an error
+func (s *MySQL) runParallelFunc(ctx context.Context, f func(ctx context.Context) error) (err error) {
+	start := s.now()
+	defer func() {
+		if r := recover(); r != nil {
+			err = qerror.Errorf("Panic in the parallel callback: %v", r)
+		}
+
+		if holder, _ := s.metricsSink.Load().(metricsSinkHolder); holder.sink != nil {
+			s.observeQuery(ctx, holder.sink, "", "parallel", s.since(start), -1, err)
+		}
+	}()
+
+	return f(ctx)
+}
@@ -0,0 +1,342 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+type ParentStrategy int
+
+const (
+	// ParentJoin joins the parent table to the query
+	ParentJoin ParentStrategy = iota
+	// ParentBatch requests the parents with one IN query per IN chunk size rows after the query
+	ParentBatch
+)
+
+// Parent describes the belongs-to parent row loaded along with the rows, see WithParent
+type Parent struct {
+	Model model.IModel
+	// FkFieldName is the field of the model referencing the primary key of the parent model
+	FkFieldName string
+	// FieldsNames are the parent fields, the derivable ones are calculated
+	FieldsNames []string
+	Strategy    ParentStrategy
+	// Left keeps the rows without a parent, the parent fields are nil then. Otherwise such rows are dropped.
+	Left bool
+	// Prefix of the parent columns, the parent model id by default, e.g. "user.name"
+	Prefix string
+}
+
+// WithParent returns the option for SelectOptions.Parents which joins the parent model
+func WithParent(parentModel model.IModel, fkFieldName string, fieldsNames []string) Parent {
+	return Parent{Model: parentModel, FkFieldName: fkFieldName, FieldsNames: fieldsNames}
+}
+
+func (p *Parent) prefix() string {
+	if p.Prefix != "" {
+		return p.Prefix
+	}
+
+	return p.Model.GetId()
+}
+
+// tableAlias is the parent table name in the joined query, the model fields of the parent are qualified
+// with the table name, so it is used unless the parent is the model itself
+func (p *Parent) tableAlias(m model.IModel) string {
+	if p.Model.GetId() == m.GetId() {
+		return "_parent_" + p.prefix()
+	}
+
+	return TableName(p.Model)
+}
+
+func (p *Parent) column(fieldName string) string {
+	return p.prefix() + "." + fieldName
+}
+
+// storageFields returns the parent primary key, the requested storage fields and the fields the requested
+// derivable fields depend on
+func (p *Parent) storageFields() ([]string, error) {
+	pk := p.Model.GetPKFieldsNames()
+	if len(pk) != 1 {
+		return nil, qerror.Errorf("The parent model '%s' must have a single field primary key", p.Model.GetId())
+	}
+
+	res := []string{pk[0]}
+	add := func(name string) error {
+		field := p.Model.GetFieldDefinition(name)
+		if field == nil {
+			return qerror.Errorf("Unknown field '%s' in model '%s'", name, p.Model.GetId())
+		}
+		if field.IsDerivable() {
+			for _, dep := range field.GetDependsOn() {
+				if depField := p.Model.GetFieldDefinition(dep); depField == nil || depField.IsDerivable() {
+					return qerror.Errorf("The field '%s' in model '%s' depends on the unsupported field '%s'", name, p.Model.GetId(), dep)
+				}
+				if !containsString(res, dep) {
+					res = append(res, dep)
+				}
+			}
+		} else if !containsString(res, name) {
+			res = append(res, name)
+		}
+		return nil
+	}
+
+	for _, name := range p.FieldsNames {
+		if err := add(name); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// writeParentsJoins writes the joins of the parents, table is the name or the alias of the table of the model
+func (s *MySQL) writeParentsJoins(sqlBuf *SqlBuffer, m model.IModel, table string, parents []Parent) {
+	for i := range parents {
+		parent := &parents[i]
+		if parent.Strategy != ParentJoin {
+			continue
+		}
+		if err := s.checkStorage(parent.Model); err != nil {
+			sqlBuf.SetError(err)
+			return
+		}
+
+		if parent.Left {
+			sqlBuf.WriteString(" LEFT JOIN ")
+		} else {
+			sqlBuf.WriteString(" JOIN ")
+		}
+		sqlBuf.WriteTable(parent.Model)
+		if alias := parent.tableAlias(m); alias != TableName(parent.Model) {
+			sqlBuf.WriteString(" AS ")
+			sqlBuf.WriteIdentifier(alias)
+		}
+		sqlBuf.WriteString(" ON ")
+		sqlBuf.WriteString(QuoteIdent(parent.tableAlias(m), parent.Model.GetPKFieldsNames()[0]))
+		sqlBuf.WriteByte('=')
+		sqlBuf.WriteString(QuoteIdent(table, parent.FkFieldName))
+
+		// The parent out of the scope is not joined, it is NULL for the left join
+		if scope := sqlBuf.scoped(s, parent.Model, nil); scope != nil {
+			p := s.exprProcessor.ForModel(parent.Model)
+			p.qualify, p.alias = true, parent.tableAlias(m)
+			sqlBuf.WriteString(" AND (")
+			scope.GetProcessor(p).(WriteFunc)(sqlBuf)
+			sqlBuf.WriteByte(')')
+		}
+	}
+}
+
+func (s *MySQL) writeParentsColumns(sqlBuf *SqlBuffer, m model.IModel, parents []Parent) {
+	for i := range parents {
+		parent := &parents[i]
+		if parent.Strategy != ParentJoin {
+			continue
+		}
+
+		fieldsNames, err := parent.storageFields()
+		if err != nil {
+			sqlBuf.SetError(err)
+			return
+		}
+
+		for _, name := range fieldsNames {
+			sqlBuf.WriteByte(',')
+			sqlBuf.WriteString(QuoteIdent(parent.tableAlias(m), name))
+			sqlBuf.WriteString(" AS ")
+			sqlBuf.WriteIdentifier(parent.column(name))
+		}
+	}
+}
+
+// parentColumnField returns the nullable definition of the joined parent column or nil if it is not a parent column
+func parentColumnField(parents []Parent, name string) model.IFieldDefinition {
+	for i := range parents {
+		parent := &parents[i]
+		if parent.Strategy != ParentJoin || len(name) <= len(parent.prefix())+1 || name[:len(parent.prefix())+1] != parent.prefix()+"." {
+			continue
+		}
+
+		if field := parent.Model.GetFieldDefinition(name[len(parent.prefix())+1:]); field != nil && !field.IsDerivable() {
+			return field.CloneForFK(name, field.GetCaption(), false)
+		}
+	}
+
+	return nil
+}
+
+// attachParents replaces the joined parent columns and the hidden foreign keys with the requested parent fields
+func (s *MySQL) attachParents(ctx context.Context, m model.IModel, data *model.Data, parents []Parent, hidden []string) (*model.Data, error) {
+	parentRows := make([][]map[string]interface{}, len(parents)) // [parent][row]
+	for i := range parents {
+		parent := &parents[i]
+		storageFields, err := parent.storageFields()
+		if err != nil {
+			return nil, err
+		}
+
+		if parent.Strategy == ParentJoin {
+			parentRows[i] = make([]map[string]interface{}, data.Len())
+			for j, row := range data.Data() {
+				if derefValue(row[data.FieldNum(parent.column(storageFields[0]))]) == nil {
+					continue
+				}
+				parentRow := make(map[string]interface{}, len(storageFields))
+				for _, name := range storageFields {
+					parentRow[name] = derefValue(row[data.FieldNum(parent.column(name))])
+				}
+				parentRows[i][j] = parentRow
+			}
+			for _, name := range storageFields {
+				hidden = append(hidden, parent.column(name))
+			}
+			continue
+		}
+
+		if parentRows[i], err = s.queryParents(ctx, data, parent, storageFields); err != nil {
+			return nil, err
+		}
+	}
+
+	fields := make([]string, 0, len(data.Fields()))
+	for _, name := range data.Fields() {
+		if !containsString(hidden, name) {
+			fields = append(fields, name)
+		}
+	}
+	for i := range parents {
+		for _, name := range parents[i].FieldsNames {
+			fields = append(fields, parents[i].column(name))
+		}
+	}
+
+	res := model.NewEmptyData(fields)
+
+	// The derivable fields are computed for all the parent rows at once
+	derived := make([]map[string][]interface{}, len(parents))
+	for i := range parents {
+		parent := &parents[i]
+		var present []map[string]interface{}
+		for _, parentRow := range parentRows[i] {
+			if parentRow != nil {
+				present = append(present, parentRow)
+			}
+		}
+
+		for _, name := range parent.FieldsNames {
+			field := parent.Model.GetFieldDefinition(name)
+			if !field.IsDerivable() {
+				continue
+			}
+			values, err := calcDerivable(ctx, field, len(present), func(j int, row map[string]interface{}) {
+				for fieldName, value := range present[j] {
+					row[fieldName] = value
+				}
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			if derived[i] == nil {
+				derived[i] = make(map[string][]interface{})
+			}
+			derived[i][name] = make([]interface{}, len(parentRows[i]))
+			for j, k := 0, 0; j < len(parentRows[i]); j++ {
+				if parentRows[i][j] != nil {
+					derived[i][name][j], k = values[k], k+1
+				}
+			}
+		}
+	}
+
+rows:
+	for j, row := range data.Data() {
+		resRow := make([]interface{}, 0, len(fields))
+		for k, name := range data.Fields() {
+			if !containsString(hidden, name) {
+				resRow = append(resRow, row[k])
+			}
+		}
+
+		for i := range parents {
+			parent, parentRow := &parents[i], parentRows[i][j]
+			if parentRow == nil && !parent.Left {
+				continue rows
+			}
+
+			for _, name := range parent.FieldsNames {
+				var value interface{}
+				if values, isDerivable := derived[i][name]; isDerivable {
+					value = values[j]
+				} else if parentRow != nil {
+					value = parentRow[name]
+				}
+				resRow = append(resRow, value)
+			}
+		}
+
+		if err := res.Add(resRow); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// queryParents requests the parents of the rows by the foreign key values, the result is the parent row for each row
+func (s *MySQL) queryParents(ctx context.Context, data *model.Data, parent *Parent, storageFields []string) ([]map[string]interface{}, error) {
+	fkPos := data.FieldNum(parent.FkFieldName)
+
+	var ids []interface{}
+	seen := make(map[string]struct{})
+	for _, row := range data.Data() {
+		id := derefValue(row[fkPos])
+		if id == nil {
+			continue
+		}
+		if _, exists := seen[fmt.Sprint(id)]; !exists {
+			seen[fmt.Sprint(id)] = struct{}{}
+			ids = append(ids, id)
+		}
+	}
+
+	chunkSize := s.exprProcessor.InChunkSize
+	if chunkSize <= 0 {
+		chunkSize = len(ids)
+	}
+
+	byId := make(map[string]map[string]interface{}, len(ids))
+	for start := 0; start < len(ids); start += chunkSize {
+		end := start + chunkSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		parentData, err := s.Select(ctx, parent.Model, storageFields, SelectOptions{GetAllOptions: model.GetAllOptions{
+			Filter: In(parent.Model.FieldExpr(storageFields[0]), ids[start:end]),
+		}})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, parentRow := range parentData.Maps() {
+			byId[fmt.Sprint(parentRow[storageFields[0]])] = parentRow
+		}
+	}
+
+	res := make([]map[string]interface{}, data.Len())
+	for j, row := range data.Data() {
+		if id := derefValue(row[fkPos]); id != nil {
+			res[j] = byId[fmt.Sprint(id)]
+		}
+	}
+
+	return res, nil
+}
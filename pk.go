@@ -0,0 +1,217 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// ErrIncompletePK is matched by the errors of the primary keys missing a field
+var ErrIncompletePK = errors.New("incomplete primary key")
+
+func incompletePKError(m model.IModel, fieldName string) error {
+	return fmt.Errorf("%w: the field '%s' of the primary key of model '%s' is missing", ErrIncompletePK, fieldName, m.GetId())
+}
+
+// keyValues returns the values of the primary key fields in the order of GetPKFieldsNames. The key is the value
+// of the single-field primary key, the slice of the values in the order of GetPKFieldsNames or the map of the
+// values by the fields names.
+func keyValues(m model.IModel, key interface{}) ([]interface{}, error) {
+	pkNames := m.GetPKFieldsNames()
+	if len(pkNames) == 0 {
+		return nil, qerror.Errorf("Model '%s' has no primary key", m.GetId())
+	}
+
+	res := make([]interface{}, len(pkNames))
+	switch k := key.(type) {
+	case map[string]interface{}:
+		if len(k) > len(pkNames) {
+			for name := range k {
+				if indexOf(pkNames, name) < 0 {
+					return nil, qerror.Errorf("The field '%s' is not in the primary key of model '%s'", name, m.GetId())
+				}
+			}
+		}
+		for i, name := range pkNames {
+			res[i] = k[name]
+		}
+	case []interface{}:
+		if len(k) > len(pkNames) {
+			return nil, qerror.Errorf("The primary key of model '%s' has %d fields, got %d values", m.GetId(), len(pkNames), len(k))
+		}
+		copy(res, k)
+	default:
+		if len(pkNames) == 1 {
+			res[0] = key
+			break
+		}
+		values, err := sliceValues(key)
+		if err != nil {
+			return nil, qerror.Errorf("The primary key of model '%s' is composite, pass the slice or the map of the values: %s", m.GetId(), err.Error())
+		}
+		return keyValues(m, values)
+	}
+
+	for i, name := range pkNames {
+		if isNil(res[i]) {
+			return nil, incompletePKError(m, name)
+		}
+		res[i] = derefValue(res[i])
+	}
+
+	return res, nil
+}
+
+// PKFilter returns the filter of the row with the primary key, see GetByPK for the key format
+func PKFilter(m model.IModel, key interface{}) (model.IExpression, error) {
+	values, err := keyValues(m, key)
+	if err != nil {
+		return nil, err
+	}
+
+	pkNames := m.GetPKFieldsNames()
+	if len(pkNames) == 1 {
+		return In(m.FieldExpr(pkNames[0]), values), nil
+	}
+
+	conds := make([]model.IExpression, len(pkNames))
+	for i, name := range pkNames {
+		conds[i] = In(m.FieldExpr(name), []interface{}{values[i]})
+	}
+
+	return And(conds...), nil
+}
+
+// PKsFilter returns the filter of the rows with the primary keys, the composite keys are compared
+// with the tuple IN
+func PKsFilter(m model.IModel, keys []interface{}) (model.IExpression, error) {
+	pkNames := m.GetPKFieldsNames()
+	rows := make([][]interface{}, len(keys))
+	for i, key := range keys {
+		values, err := keyValues(m, key)
+		if err != nil {
+			return nil, err
+		}
+		rows[i] = values
+	}
+
+	if len(pkNames) == 1 {
+		ids := make([]interface{}, len(rows))
+		for i, row := range rows {
+			ids[i] = row[0]
+		}
+		return In(m.FieldExpr(pkNames[0]), ids), nil
+	}
+
+	ops := make([]model.IExpression, len(pkNames))
+	for i, name := range pkNames {
+		ops[i] = m.FieldExpr(name)
+	}
+
+	return Tuple(ops...).In(rows...), nil
+}
+
+// checkPKPresent returns ErrIncompletePK if a row misses the primary key field which is not auto-incremented
+func checkPKPresent(m model.IModel, data *model.Data) error {
+	for _, name := range m.GetPKFieldsNames() {
+		if field, ok := m.GetFieldDefinition(name).(IMysqlFieldDefinition); ok && field.IsAutoIncremented() {
+			continue
+		}
+
+		pos := data.FieldNum(name)
+		if pos < 0 {
+			return incompletePKError(m, name)
+		}
+		for i, row := range data.Data() {
+			if isNil(row[pos]) {
+				return fmt.Errorf("%w at row %d", incompletePKError(m, name), i+1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetByPK returns the fields of the row with the primary key or nil if there is no such row. The key is the value
+// of the single-field primary key, the slice of the values in the order of GetPKFieldsNames or the map
+// of the values by the fields names.
+func (s *MySQL) GetByPK(ctx context.Context, m model.IModel, key interface{}, fieldsNames []string) (map[string]interface{}, error) {
+	data, err := s.GetByPKs(ctx, m, []interface{}{key}, fieldsNames)
+	if err != nil || data.Len() == 0 {
+		return nil, err
+	}
+
+	return data.Maps()[0], nil
+}
+
+// GetByPKs returns the fields of the rows with the primary keys in the order of the keys, the missing rows are skipped
+func (s *MySQL) GetByPKs(ctx context.Context, m model.IModel, keys []interface{}, fieldsNames []string) (*model.Data, error) {
+	pkNames := m.GetPKFieldsNames()
+	if len(keys) == 0 {
+		return model.NewEmptyData(fieldsNames), nil
+	}
+
+	filter, err := PKsFilter(m, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := fieldsNames
+	for _, name := range pkNames {
+		selected = appendMissing(selected[:len(selected):len(selected)], name)
+	}
+
+	data, err := s.Select(ctx, m, selected, SelectOptions{GetAllOptions: model.GetAllOptions{Filter: filter}})
+	if err != nil {
+		return nil, err
+	}
+
+	pks := model.NewEmptyData(pkNames)
+	for _, key := range keys {
+		values, _ := keyValues(m, key)
+		if err := pks.Add(values); err != nil {
+			return nil, err
+		}
+	}
+
+	return orderByPKs(data, pks, fieldsNames)
+}
+
+// EditByPK sets the new values of the row with the primary key, see GetByPK for the key format
+func (s *MySQL) EditByPK(ctx context.Context, m model.IModel, key interface{}, newValues map[string]interface{}) error {
+	filter, err := PKFilter(m, key)
+	if err != nil {
+		return err
+	}
+
+	return s.Edit(ctx, m, filter, newValues)
+}
+
+// DeleteByPK deletes the row with the primary key, see GetByPK for the key format
+func (s *MySQL) DeleteByPK(ctx context.Context, m model.IModel, key interface{}) error {
+	filter, err := PKFilter(m, key)
+	if err != nil {
+		return err
+	}
+
+	return s.Delete(ctx, m, filter)
+}
+
+func (m *BaseModel) GetByPK(ctx context.Context, key interface{}, fieldsNames []string) (map[string]interface{}, error) {
+	return m.db.GetByPK(ctx, m, key, fieldsNames)
+}
+
+func (m *BaseModel) GetByPKs(ctx context.Context, keys []interface{}, fieldsNames []string) (*model.Data, error) {
+	return m.db.GetByPKs(ctx, m, keys, fieldsNames)
+}
+
+func (m *BaseModel) EditByPK(ctx context.Context, key interface{}, newValues map[string]interface{}) error {
+	return m.db.EditByPK(ctx, m, key, newValues)
+}
+
+func (m *BaseModel) DeleteByPK(ctx context.Context, key interface{}) error {
+	return m.db.DeleteByPK(ctx, m, key)
+}
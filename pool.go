@@ -0,0 +1,90 @@
+package mysql
+
+import (
+	"database/sql"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// PoolConfig is the connections pool configuration, DefaultPoolConfig returns the defaults:
+//   - MaxOpenConns is 0, the number of the open connections is not limited
+//   - MaxIdleConns is 2 as in database/sql
+//   - ConnMaxLifetime is 3 minutes, it must be below the idle timeout of the load balancers and of the MySQL
+//     wait_timeout, otherwise the first query on a silently closed connection fails
+//   - ConnMaxIdleTime is 1 minute
+//   - DialTimeout is 10 seconds, ReadTimeout and WriteTimeout are 0, the I/O is limited by the context only
+//
+// The zero values mean no limit for the durations and MaxOpenConns and the database/sql default for MaxIdleConns.
+// The timeouts are added to the DSN by Connect unless the DSN sets them.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	DialTimeout     time.Duration
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+}
+
+func DefaultPoolConfig() PoolConfig {
+	return PoolConfig{
+		MaxIdleConns:    2,
+		ConnMaxLifetime: 3 * time.Minute,
+		ConnMaxIdleTime: time.Minute,
+		DialTimeout:     10 * time.Second,
+	}
+}
+
+// SetPoolConfig changes the connections pool configuration, the timeouts are applied on the next Connect
+func (s *MySQL) SetPoolConfig(config PoolConfig) {
+	s.poolConfig = config
+
+	if s.db != nil {
+		s.applyPoolConfig()
+	}
+}
+
+func (s *MySQL) GetPoolConfig() PoolConfig {
+	return s.poolConfig
+}
+
+// Stats returns the connections pool statistics
+func (s *MySQL) Stats() sql.DBStats {
+	if s.db == nil {
+		return sql.DBStats{}
+	}
+
+	return s.db.Stats()
+}
+
+func (s *MySQL) applyPoolConfig() {
+	s.db.SetMaxOpenConns(s.poolConfig.MaxOpenConns)
+	s.db.SetMaxIdleConns(s.poolConfig.MaxIdleConns)
+	s.db.SetConnMaxLifetime(s.poolConfig.ConnMaxLifetime)
+	s.db.SetConnMaxIdleTime(s.poolConfig.ConnMaxIdleTime)
+}
+
+// applyTimeouts adds the configured timeouts to the DSN if it does not set them
+func (s *MySQL) applyTimeouts(dsn string) (string, error) {
+	if s.poolConfig.DialTimeout == 0 && s.poolConfig.ReadTimeout == 0 && s.poolConfig.WriteTimeout == 0 {
+		return dsn, nil
+	}
+
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = s.poolConfig.DialTimeout
+	}
+	if cfg.ReadTimeout == 0 {
+		cfg.ReadTimeout = s.poolConfig.ReadTimeout
+	}
+	if cfg.WriteTimeout == 0 {
+		cfg.WriteTimeout = s.poolConfig.WriteTimeout
+	}
+
+	return cfg.FormatDSN(), nil
+}
@@ -0,0 +1,161 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// ErrNoRows is returned by ClaimNext if there is no unclaimed row matching the filter
+var ErrNoRows = errors.New("no rows")
+
+// ClaimLockWaitTimeout is the innodb_lock_wait_timeout in seconds of the claims on the servers without SKIP LOCKED,
+// the claim finds no rows if the first matching row is not unlocked in time
+var ClaimLockWaitTimeout = 1
+
+// ClaimNext claims one row of the model as ClaimBatch does and returns its stored fields, ErrNoRows if there is
+// no unclaimed row
+func (s *MySQL) ClaimNext(ctx context.Context, m model.IModel, filter model.IExpression, orderBy []model.Order, updates map[string]interface{}) (map[string]interface{}, error) {
+	data, err := s.ClaimBatch(ctx, m, filter, orderBy, updates, 1)
+	if err != nil {
+		return nil, err
+	}
+	if data.Len() == 0 {
+		return nil, ErrNoRows
+	}
+
+	return data.Maps()[0], nil
+}
+
+// ClaimBatch claims up to limit rows of the model matching the filter in the order, e.g. the unclaimed jobs of the
+// queue table. The rows are selected FOR UPDATE SKIP LOCKED in the transaction of the context or the one started
+// by ClaimBatch, the updates are set to them (e.g. the status, the holder and the time of the claim) and the stored
+// fields of the claimed rows are returned as they are stored after the updates. The filter must not match
+// the claimed rows. On the servers without SKIP LOCKED (MySQL 5.7, MariaDB before 10.6) the claims wait
+// for the locked rows up to ClaimLockWaitTimeout and find no rows then.
+func (s *MySQL) ClaimBatch(ctx context.Context, m model.IModel, filter model.IExpression, orderBy []model.Order, updates map[string]interface{}, limit int) (*model.Data, error) {
+	if len(updates) == 0 {
+		return nil, qerror.Errorf("No claim updates for model '%s'", m.GetId())
+	}
+	if limit <= 0 {
+		return nil, qerror.Errorf("The claim limit must be positive")
+	}
+
+	fieldsNames := storedFieldsNames(m)
+	var res *model.Data
+	err := s.DoInTransaction(ctx, func(ctx context.Context) error {
+		pks, err := s.lockUnclaimed(ctx, m, filter, orderBy, uint64(limit))
+		if err != nil {
+			return err
+		}
+		if pks.Len() == 0 {
+			res = model.NewEmptyData(fieldsNames)
+			return nil
+		}
+
+		if err := s.editByPKs(ctx, m, pks, updates); err != nil {
+			return err
+		}
+		res, err = s.selectByPKs(ctx, m, pks, fieldsNames)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// ReleaseStale reopens the claimed rows the holders of which are gone, e.g. the jobs claimed long ago, by setting
+// the updates to the rows matching the stale filter. The rows locked by the alive holders are skipped on the servers
+// with SKIP LOCKED. The result is the number of the released rows.
+func (s *MySQL) ReleaseStale(ctx context.Context, m model.IModel, staleFilter model.IExpression, updates map[string]interface{}) (uint64, error) {
+	if len(updates) == 0 {
+		return 0, qerror.Errorf("No release updates for model '%s'", m.GetId())
+	}
+
+	var released uint64
+	err := s.DoInTransaction(ctx, func(ctx context.Context) error {
+		pks, err := s.lockUnclaimed(ctx, m, staleFilter, nil, 0)
+		if err != nil || pks.Len() == 0 {
+			return err
+		}
+		if err := s.editByPKs(ctx, m, pks, updates); err != nil {
+			return err
+		}
+		released = uint64(pks.Len())
+
+		return nil
+	})
+
+	return released, err
+}
+
+// lockUnclaimed locks up to limit rows matching the filter skipping the locked ones and returns their primary keys,
+// 0 locks all the rows
+func (s *MySQL) lockUnclaimed(ctx context.Context, m model.IModel, filter model.IExpression, orderBy []model.Order, limit uint64) (*model.Data, error) {
+	pkNames := m.GetPKFieldsNames()
+	if len(pkNames) == 0 {
+		return nil, qerror.Errorf("Model '%s' has no primary key", m.GetId())
+	}
+
+	options := SelectOptions{GetAllOptions: model.GetAllOptions{
+		Filter:    filter,
+		OrderBy:   orderBy,
+		Limit:     limit,
+		ForUpdate: true,
+	}}
+
+	skipLocked, _, err := s.supportsLockWait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if skipLocked {
+		options.LockWait = LockSkipLocked
+		return s.Select(ctx, m, pkNames, options)
+	}
+
+	// The lock wait timeout of the session is restored before the connection is returned to the pool
+	if _, err := s.Exec(ctx, "SET @claim_lock_wait_timeout=@@SESSION.innodb_lock_wait_timeout,"+
+		"SESSION innodb_lock_wait_timeout="+strconv.Itoa(ClaimLockWaitTimeout)); err != nil {
+		return nil, err
+	}
+	data, err := s.Select(ctx, m, pkNames, options)
+	if _, restoreErr := s.Exec(ctx, "SET SESSION innodb_lock_wait_timeout=@claim_lock_wait_timeout"); restoreErr != nil && err == nil {
+		return nil, restoreErr
+	}
+	if errors.Is(err, ErrLockWaitTimeout) {
+		return model.NewEmptyData(pkNames), nil
+	}
+
+	return data, err
+}
+
+// editByPKs sets the new values of the rows with the primary keys
+func (s *MySQL) editByPKs(ctx context.Context, m model.IModel, pks *model.Data, newValues map[string]interface{}) error {
+	keys := make([]interface{}, pks.Len())
+	for i, row := range pks.Data() {
+		keys[i] = row
+	}
+	filter, err := PKsFilter(m, keys)
+	if err != nil {
+		return err
+	}
+
+	return s.Edit(ctx, m, filter, newValues)
+}
+
+func (m *BaseModel) ClaimNext(ctx context.Context, filter model.IExpression, orderBy []model.Order, updates map[string]interface{}) (map[string]interface{}, error) {
+	return m.db.ClaimNext(ctx, m, filter, orderBy, updates)
+}
+
+func (m *BaseModel) ClaimBatch(ctx context.Context, filter model.IExpression, orderBy []model.Order, updates map[string]interface{}, limit int) (*model.Data, error) {
+	return m.db.ClaimBatch(ctx, m, filter, orderBy, updates, limit)
+}
+
+func (m *BaseModel) ReleaseStale(ctx context.Context, staleFilter model.IExpression, updates map[string]interface{}) (uint64, error) {
+	return m.db.ReleaseStale(ctx, m, staleFilter, updates)
+}
@@ -0,0 +1,399 @@
+package mysql
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// RecordingPolicy bounds and sanitizes the statements recorded outside of the storage: the timelog entries,
+// the db.statement attributes of the spans, the statements passed to the slow queries handler and the SQL
+// of StatementError. The literal values compared with or assigned to the columns of the sensitive fields
+// are always replaced with RedactedValue, the zero policy does nothing else.
+type RecordingPolicy struct {
+	// MaxLength is the length the statements are truncated to, the middle of the statement is cut keeping
+	// its head and tail. The statements are not truncated if it is zero.
+	MaxLength int
+	// CollapseInLists replaces the lists of the values of IN with "(…N values…)"
+	CollapseInLists bool
+	// StripComments removes the comments, the optimizer hints are kept
+	StripComments bool
+}
+
+// TruncationMark replaces the middle of the statements truncated by RecordingPolicy
+const TruncationMark = "…"
+
+// SetRecordingPolicy sets the policy of the recorded statements, see RecordingPolicy. It must be called before
+// the storage is used.
+func (s *MySQL) SetRecordingPolicy(policy RecordingPolicy) {
+	s.recordingPolicy = policy
+}
+
+// recordStatement returns the statement sanitized with the recording policy and the sensitive fields
+// of the registered models
+func (s *MySQL) recordStatement(query string) string {
+	return s.recordingPolicy.Sanitize(query, s.sensitiveColumns())
+}
+
+// sensitiveColumns returns the lower case names of the columns of the sensitive fields of the registered models,
+// the columns of the other models with the same names are redacted too
+func (s *MySQL) sensitiveColumns() map[string]bool {
+	s.modelsMtx.RLock()
+	defer s.modelsMtx.RUnlock()
+
+	var res map[string]bool
+	for _, m := range s.models {
+		for _, name := range m.GetFieldsNames() {
+			if isSensitiveField(m.GetFieldDefinition(name)) {
+				if res == nil {
+					res = make(map[string]bool)
+				}
+				res[strings.ToLower(name)] = true
+			}
+		}
+	}
+
+	return res
+}
+
+// recordedStatement is the statement of the timelog entry, it is interpolated and sanitized when the entry is shown
+type recordedStatement struct {
+	s     *MySQL
+	query string
+	args  []interface{}
+}
+
+func (r recordedStatement) String() string {
+	return r.s.recordStatement(InterpolateSQL(r.query, r.args, r.s.loc))
+}
+
+// Sanitize returns the statement with the policy applied, the literal values compared with or assigned
+// to the sensitiveColumns (lower case) are replaced with RedactedValue, e.g. in WHERE `password`='secret',
+// SET `password`='secret' and in the tuples of INSERT INTO `user` (`login`,`password`) VALUES ('ivan','secret').
+func (p RecordingPolicy) Sanitize(query string, sensitiveColumns map[string]bool) string {
+	if p.MaxLength <= 0 && !p.CollapseInLists && !p.StripComments && len(sensitiveColumns) == 0 {
+		return query
+	}
+
+	tokens := lexStatement(query)
+	// The indexes of the tokens other than the spaces and the comments
+	var sig []int
+	for i := range tokens {
+		if tokens[i].kind != tokenSpace && tokens[i].kind != tokenComment {
+			sig = append(sig, i)
+		}
+	}
+	tok := func(n int) *sqlToken {
+		if n < 0 || n >= len(sig) {
+			return &sqlToken{}
+		}
+		return &tokens[sig[n]]
+	}
+
+	if len(sensitiveColumns) > 0 {
+		redactSensitive(sig, tok, sensitiveColumns)
+	}
+
+	if p.CollapseInLists {
+		for n := 0; n < len(sig); n++ {
+			if !tok(n).isWord("IN") || tok(n+1).text != "(" {
+				continue
+			}
+			count, end, closed := 0, n+2, false
+			for ; tok(end).isValue() && (tok(end+1).text == "," || tok(end+1).text == ")"); end += 2 {
+				count++
+				if closed = tok(end+1).text == ")"; closed {
+					break
+				}
+			}
+			if !closed {
+				continue
+			}
+			tok(n + 1).text = "(…" + strconv.Itoa(count) + " values…)"
+			for i := sig[n+1] + 1; i <= sig[end+1]; i++ {
+				tokens[i].text = ""
+			}
+			n = end + 1
+		}
+	}
+
+	buf := &strings.Builder{}
+	buf.Grow(len(query))
+	for i, t := range tokens {
+		if p.StripComments && t.kind == tokenComment {
+			// The spaces around the comment are collapsed
+			if i > 0 && tokens[i-1].kind == tokenSpace && i+1 < len(tokens) && tokens[i+1].kind == tokenSpace {
+				tokens[i+1].text = ""
+			}
+			continue
+		}
+		buf.WriteString(t.text)
+	}
+	res := buf.String()
+
+	if p.MaxLength > 0 && len(res) > p.MaxLength {
+		res = truncateMiddle(res, p.MaxLength)
+	}
+
+	return res
+}
+
+// redactSensitive replaces the literal values compared with or assigned to the sensitive columns
+func redactSensitive(sig []int, tok func(n int) *sqlToken, sensitiveColumns map[string]bool) {
+	isSensitive := func(n int) bool {
+		t := tok(n)
+		return (t.kind == tokenIdent || t.kind == tokenWord) && sensitiveColumns[strings.ToLower(t.name())] &&
+			tok(n+1).text != "(" && tok(n+1).text != "."
+	}
+	redact := func(n int) {
+		if tok(n).isLiteral() {
+			tok(n).text = RedactedValue
+		}
+	}
+
+	for n := range sig {
+		if !isSensitive(n) {
+			continue
+		}
+
+		// `column` <op> 'value', `column` [NOT] IN ('value', ...) and `column` [NOT] LIKE 'value'
+		next := n + 1
+		if tok(next).isWord("NOT") {
+			next++
+		}
+		switch t := tok(next); {
+		case t.kind == tokenOperator || t.isWord("LIKE") || t.isWord("REGEXP"):
+			redact(next + 1)
+		case t.isWord("IN") && tok(next+1).text == "(":
+			for item := next + 2; item < len(sig) && tok(item).text != ")"; item++ {
+				redact(item)
+			}
+		}
+
+		// 'value' <op> `table`.`column`
+		first := n
+		for tok(first-1).text == "." && (tok(first-2).kind == tokenIdent || tok(first-2).kind == tokenWord) {
+			first -= 2
+		}
+		if tok(first-1).kind == tokenOperator {
+			redact(first - 2)
+		}
+	}
+
+	// INSERT INTO `table` (`column`, ...) VALUES ('value', ...), ...
+	for n := range sig {
+		if !tok(n).isWord("VALUES") && !tok(n).isWord("VALUE") || tok(n-1).text != ")" {
+			continue
+		}
+
+		start := n - 2
+		for start >= 0 && tok(start).text != "(" {
+			start--
+		}
+		if start < 1 || tok(start-1).kind != tokenIdent && tok(start-1).kind != tokenWord {
+			continue
+		}
+		var columns []bool
+		for c := start + 1; c < n-1; c++ {
+			if t := tok(c); t.text != "," {
+				columns = append(columns, (t.kind == tokenIdent || t.kind == tokenWord) && sensitiveColumns[strings.ToLower(t.name())])
+			}
+		}
+
+		depth, pos := 0, 0
+		for item := n + 1; item < len(sig); item++ {
+			t := tok(item)
+			if depth == 0 {
+				if t.text == "(" {
+					depth, pos = 1, 0
+					continue
+				}
+				if t.text == "," {
+					continue
+				}
+				break
+			}
+
+			switch t.text {
+			case "(":
+				depth++
+			case ")":
+				depth--
+			case ",":
+				if depth == 1 {
+					pos++
+				}
+			default:
+				if pos < len(columns) && columns[pos] {
+					redact(item)
+				}
+			}
+		}
+	}
+}
+
+// truncateMiddle cuts the middle of the statement replacing it with TruncationMark, the result is maxLength bytes
+// at most
+func truncateMiddle(query string, maxLength int) string {
+	if maxLength <= len(TruncationMark)+1 {
+		return truncateStatement(query, maxLength)
+	}
+
+	head := (maxLength - len(TruncationMark) + 1) / 2
+	tail := len(query) - (maxLength - len(TruncationMark) - head)
+	for head > 0 && !utf8.RuneStart(query[head]) {
+		head--
+	}
+	for tail < len(query) && !utf8.RuneStart(query[tail]) {
+		tail++
+	}
+
+	return query[:head] + TruncationMark + query[tail:]
+}
+
+type sqlTokenKind int
+
+const (
+	tokenOther sqlTokenKind = iota
+	tokenSpace
+	tokenComment
+	tokenString
+	tokenNumber
+	tokenIdent
+	tokenWord
+	tokenOperator
+	tokenPlaceholder
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+func (t *sqlToken) isWord(word string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, word)
+}
+
+// isLiteral reports whether the token is a literal value
+func (t *sqlToken) isLiteral() bool {
+	return t.kind == tokenString || t.kind == tokenNumber
+}
+
+// isValue reports whether the token is a literal value, a placeholder or NULL
+func (t *sqlToken) isValue() bool {
+	return t.isLiteral() || t.kind == tokenPlaceholder || t.isWord("NULL") || t.text == RedactedValue
+}
+
+// name returns the identifier without the quotes
+func (t *sqlToken) name() string {
+	if t.kind == tokenIdent && len(t.text) >= 2 {
+		return strings.ReplaceAll(t.text[1:len(t.text)-1], "``", "`")
+	}
+
+	return t.text
+}
+
+// comparisonOperators are the operators the values of the sensitive columns are redacted around, the longer first
+var comparisonOperators = []string{"<=>", "<=", ">=", "<>", "!=", ":=", "=", "<", ">"}
+
+// lexStatement splits the statement into the tokens, their texts make the statement up
+func lexStatement(query string) []sqlToken {
+	var res []sqlToken
+	isWordChar := func(c byte) bool {
+		return c == '_' || c == '$' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c >= 0x80
+	}
+	isDigit := func(c byte) bool { return '0' <= c && c <= '9' }
+	// quoted returns the end of the quoted string starting at i
+	quoted := func(i int) int {
+		quote := query[i]
+		for i++; i < len(query); i++ {
+			switch {
+			case query[i] == '\\' && quote != '`':
+				i++
+			case query[i] == quote:
+				if i+1 < len(query) && query[i+1] == quote {
+					i++
+					continue
+				}
+				return i + 1
+			}
+		}
+		return len(query)
+	}
+	lastKind := func() sqlTokenKind {
+		for i := len(res) - 1; i >= 0; i-- {
+			if res[i].kind != tokenSpace && res[i].kind != tokenComment {
+				if res[i].text == ")" {
+					return tokenIdent
+				}
+				return res[i].kind
+			}
+		}
+		return tokenOther
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		start, kind := i, tokenOther
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			for i < len(query) && strings.IndexByte(" \t\n\r", query[i]) >= 0 {
+				i++
+			}
+			kind = tokenSpace
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+			// The optimizer hints are not comments
+			if kind = tokenComment; strings.HasPrefix(query[start:], "/*+") {
+				kind = tokenOther
+			}
+		case c == '#' || strings.HasPrefix(query[i:], "-- "):
+			if end := strings.IndexByte(query[i:], '\n'); end < 0 {
+				i = len(query)
+			} else {
+				i += end
+			}
+			kind = tokenComment
+		case c == '\'' || c == '"':
+			i, kind = quoted(i), tokenString
+		case c == '`':
+			i, kind = quoted(i), tokenIdent
+		case c == '?':
+			i, kind = i+1, tokenPlaceholder
+		case isDigit(c) || (c == '-' || c == '.') && i+1 < len(query) && isDigit(query[i+1]) &&
+			lastKind() != tokenIdent && lastKind() != tokenWord && lastKind() != tokenNumber && lastKind() != tokenString:
+			for i++; i < len(query) && (isWordChar(query[i]) || query[i] == '.' ||
+				(query[i] == '+' || query[i] == '-') && (query[i-1] == 'e' || query[i-1] == 'E')); i++ {
+			}
+			kind = tokenNumber
+		case isWordChar(c):
+			for i < len(query) && isWordChar(query[i]) {
+				i++
+			}
+			kind = tokenWord
+			// The introducers and the hexadecimal and bit literals, e.g. _binary'...' and X'...'
+			word := query[start:i]
+			if i < len(query) && query[i] == '\'' && (word[0] == '_' || len(word) == 1 && strings.IndexByte("xXbBnN", word[0]) >= 0) {
+				i, kind = quoted(i), tokenString
+			}
+		default:
+			i++
+			for _, op := range comparisonOperators {
+				if strings.HasPrefix(query[start:], op) {
+					i, kind = start+len(op), tokenOperator
+					break
+				}
+			}
+		}
+
+		res = append(res, sqlToken{kind, query[start:i]})
+	}
+
+	return res
+}
@@ -0,0 +1,172 @@
+package mysql
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// ModelInfo is the description of the model registered on the storage, see MySQL.Models. It is a copy and
+// can be marshaled to JSON.
+type ModelInfo struct {
+	Id        string         `json:"id"`
+	Database  string         `json:"database,omitempty"`
+	Table     string         `json:"table"`
+	Fields    []FieldInfo    `json:"fields"`
+	PK        []string       `json:"pk"`
+	Indexes   []IndexInfo    `json:"indexes"`
+	Relations []RelationInfo `json:"relations"`
+}
+
+// FieldInfo is the description of the model field, SQLType is empty for the derivable fields.
+// Default is the default value of the column as it is declared.
+type FieldInfo struct {
+	Id            string   `json:"id"`
+	Caption       string   `json:"caption"`
+	SQLType       string   `json:"sqlType,omitempty"`
+	Nullable      bool     `json:"nullable"`
+	Default       *string  `json:"default,omitempty"`
+	AutoIncrement bool     `json:"autoIncrement,omitempty"`
+	Derivable     bool     `json:"derivable,omitempty"`
+	DependsOn     []string `json:"dependsOn,omitempty"`
+}
+
+// RelationInfo is the relation of the model to the ExtModel, Type is one-to-one, one-to-many, many-to-one
+// or many-to-many. Back is set for the relations declared by the other model.
+type RelationInfo struct {
+	ExtModel         string   `json:"extModel"`
+	Type             string   `json:"type"`
+	LocalFieldsNames []string `json:"localFields"`
+	FkFieldsNames    []string `json:"fkFields"`
+	JunctionModel    string   `json:"junctionModel,omitempty"`
+	Required         bool     `json:"required,omitempty"`
+	Back             bool     `json:"back,omitempty"`
+}
+
+var relationTypesNames = map[model.RelationType]string{
+	model.RELATION_ONE_TO_ONE:   "one-to-one",
+	model.RELATION_ONE_TO_MANY:  "one-to-many",
+	model.RELATION_MANY_TO_ONE:  "many-to-one",
+	model.RELATION_MANY_TO_MANY: "many-to-many",
+}
+
+// Models returns the descriptions of the models registered on the storage in the order of the ids
+func (s *MySQL) Models() []ModelInfo {
+	s.modelsMtx.RLock()
+	models := make([]model.IModel, 0, len(s.models))
+	for _, m := range s.models {
+		models = append(models, m)
+	}
+	s.modelsMtx.RUnlock()
+	sort.Slice(models, func(i, j int) bool { return models[i].GetId() < models[j].GetId() })
+
+	res := make([]ModelInfo, len(models))
+	for i, m := range models {
+		res[i] = describeModel(m)
+	}
+
+	return res
+}
+
+// Describe returns the description of the model, see MySQL.Models
+func (m *BaseModel) Describe() ModelInfo {
+	return describeModel(m)
+}
+
+func describeModel(m model.IModel) ModelInfo {
+	res := ModelInfo{
+		Id:        m.GetId(),
+		Database:  databaseOf(m),
+		Table:     TableName(m),
+		Fields:    []FieldInfo{},
+		PK:        append([]string{}, m.GetPKFieldsNames()...),
+		Indexes:   []IndexInfo{},
+		Relations: []RelationInfo{},
+	}
+
+	for _, name := range m.GetFieldsNames() {
+		field := m.GetFieldDefinition(name)
+		info := FieldInfo{
+			Id:        field.GetId(),
+			Caption:   field.GetCaption(),
+			Nullable:  field.GetType() != nil && field.GetType().Kind() == reflect.Ptr,
+			Derivable: field.IsDerivable(),
+			DependsOn: append([]string(nil), field.GetDependsOn()...),
+		}
+		if !field.IsDerivable() {
+			info.SQLType = field.GetStorageType()
+			info.Default = fieldDefault(field)
+		}
+		if dbField, ok := field.(IMysqlFieldDefinition); ok {
+			info.AutoIncrement = dbField.IsAutoIncremented()
+		}
+		res.Fields = append(res.Fields, info)
+	}
+
+	if bm, ok := m.(*BaseModel); ok {
+		for _, index := range declaredIndexes(bm) {
+			if index.Name != "PRIMARY" {
+				index.FieldsNames = append([]string{}, index.FieldsNames...)
+				res.Indexes = append(res.Indexes, index)
+			}
+		}
+		for _, index := range bm.GetFullTextIndexes() {
+			res.Indexes = append(res.Indexes, IndexInfo{
+				Model:       bm.GetId(),
+				Name:        bm.fullTextIndexName(index),
+				FieldsNames: append([]string{}, index.FieldNames...),
+				FullText:    true,
+			})
+		}
+	}
+
+	for _, name := range m.GetRelations() {
+		relation := m.GetRelation(name)
+		info := RelationInfo{
+			ExtModel:         relation.ExtModel.GetId(),
+			Type:             relationTypesNames[relation.RelationType],
+			LocalFieldsNames: append([]string{}, relation.LocalFieldsNames...),
+			FkFieldsNames:    append([]string{}, relation.FkFieldsNames...),
+			Required:         relation.IsRequired,
+			Back:             relation.IsBack,
+		}
+		if relation.JunctionModel != nil {
+			info.JunctionModel = relation.JunctionModel.GetId()
+		}
+		res.Relations = append(res.Relations, info)
+	}
+
+	return res
+}
+
+// fieldDefault returns the Default of the field definitions of the package formatted as it is declared,
+// nil if there is no default
+func fieldDefault(field model.IFieldDefinition) *string {
+	v := reflect.ValueOf(field)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	def := v.Elem().FieldByName("Default")
+	if !def.IsValid() || def.Kind() != reflect.Ptr || def.IsNil() {
+		return nil
+	}
+	res := fmt.Sprint(def.Elem().Interface())
+
+	return &res
+}
+
+// checkTableUnique checks that no other model of the storage has the table of the model, s.modelsMtx must be locked
+func (s *MySQL) checkTableUnique(m model.IModel) error {
+	table := QuoteTable(m)
+	for id, other := range s.models {
+		if id != m.GetId() && QuoteTable(other) == table {
+			return qerror.Errorf("The table %s of model '%s' is already used by model '%s'", table, m.GetId(), id)
+		}
+	}
+
+	return nil
+}
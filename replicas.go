@@ -0,0 +1,175 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+var (
+	// ReplicaMaxFailures is the number of the consecutive connection failures after which the replica is not used
+	ReplicaMaxFailures = 3
+	// ReplicaRetryInterval is the time after which a failed replica is tried again
+	ReplicaRetryInterval = 30 * time.Second
+)
+
+const (
+	ctx_force_primary_key = "MYSQL_FORCE_PRIMARY"
+	ctx_session_key       = "MYSQL_SESSION"
+)
+
+type replica struct {
+	db        *sql.DB
+	mtx       sync.Mutex
+	failures  int
+	deadUntil time.Time
+}
+
+func (r *replica) isAlive(now time.Time) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return r.failures < ReplicaMaxFailures || now.After(r.deadUntil)
+}
+
+// isConnFailure returns true for the errors other than the ones returned by the MySQL server and the ones
+// of the canceled statements
+func isConnFailure(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	return err != nil && !errors.As(err, &mysqlErr) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// report counts the consecutive connection failures
+func (r *replica) report(now time.Time, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if !isConnFailure(err) {
+		r.failures = 0
+		return
+	}
+
+	r.failures++
+	if r.failures >= ReplicaMaxFailures {
+		r.deadUntil = now.Add(ReplicaRetryInterval)
+	}
+}
+
+// setHealth applies the health check result, the failed replica is not used until ReplicaRetryInterval passes
+func (r *replica) setHealth(now time.Time, err error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if err == nil {
+		r.failures = 0
+		return
+	}
+
+	r.failures = ReplicaMaxFailures
+	r.deadUntil = now.Add(ReplicaRetryInterval)
+}
+
+type session struct {
+	window    time.Duration
+	lastWrite int64 // unix nanoseconds
+}
+
+// AddReplica connects to the read replica, the queries outside of the transactions are sent to the replicas
+// in turn. The replica uses the pool configuration of the storage.
+func (s *MySQL) AddReplica(dsn string) error {
+	dsn, err := s.applyTimeouts(dsn)
+	if err != nil {
+		return err
+	}
+
+	db, err := s.openDB(dsn)
+	if err != nil {
+		return err
+	}
+
+	config := s.poolConfig
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	s.AddReplicaDB(db)
+
+	return nil
+}
+
+// AddReplicaDB adds the connected read replica, see AddReplica
+func (s *MySQL) AddReplicaDB(db *sql.DB) {
+	s.replicasMtx.Lock()
+	defer s.replicasMtx.Unlock()
+
+	s.replicas = append(s.replicas, &replica{db: db})
+}
+
+// ReplicasAlive returns the number of the replicas used for the reads
+func (s *MySQL) ReplicasAlive() int {
+	s.replicasMtx.RLock()
+	defer s.replicasMtx.RUnlock()
+
+	res, now := 0, s.now()
+	for _, r := range s.replicas {
+		if r.isAlive(now) {
+			res++
+		}
+	}
+
+	return res
+}
+
+// ForcePrimary returns the context the queries with which are sent to the primary server
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctx_force_primary_key, true)
+}
+
+// WithSessionConsistency returns the context the reads with which are sent to the primary server during
+// the window after a write with the context, so the writes are read back
+func WithSessionConsistency(ctx context.Context, window time.Duration) context.Context {
+	return context.WithValue(ctx, ctx_session_key, &session{window: window})
+}
+
+func (s *MySQL) markWrite(ctx context.Context) {
+	if sess, ok := ctx.Value(ctx_session_key).(*session); ok {
+		atomic.StoreInt64(&sess.lastWrite, s.now().UnixNano())
+	}
+}
+
+// readReplica returns the replica for the read outside of the transaction or nil if the primary must be used
+func (s *MySQL) readReplica(ctx context.Context) *replica {
+	if forced, _ := ctx.Value(ctx_force_primary_key).(bool); forced {
+		return nil
+	}
+
+	if sess, ok := ctx.Value(ctx_session_key).(*session); ok {
+		if lastWrite := atomic.LoadInt64(&sess.lastWrite); lastWrite > 0 && s.since(time.Unix(0, lastWrite)) < sess.window {
+			return nil
+		}
+	}
+
+	s.replicasMtx.RLock()
+	defer s.replicasMtx.RUnlock()
+
+	if len(s.replicas) == 0 {
+		return nil
+	}
+
+	now := s.now()
+	next := atomic.AddUint32(&s.replicaNext, 1)
+	for i := range s.replicas {
+		if r := s.replicas[(int(next)+i)%len(s.replicas)]; r.isAlive(now) {
+			return r
+		}
+	}
+
+	return nil
+}
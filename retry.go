@@ -0,0 +1,94 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// RetryPolicy is the retry of the reads outside of the transactions failed with the transient connection errors:
+// driver.ErrBadConn, io.EOF, the driver invalid connection error and the MySQL errors with the listed numbers.
+// The writes, the statements in the transactions and the statements canceled with the context are never retried.
+type RetryPolicy struct {
+	// MaxRetries is the number of the retries, 0 disables them
+	MaxRetries int
+	// Backoff is the delay before the first retry, it is doubled for each next one
+	Backoff     time.Duration
+	MySQLErrors []uint16
+}
+
+// DefaultRetryPolicy returns 2 retries after 50ms and 100ms for the server shutdown (1053),
+// server has gone away (2006) and lost connection (2013) errors
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:  2,
+		Backoff:     50 * time.Millisecond,
+		MySQLErrors: []uint16{1053, 2006, 2013},
+	}
+}
+
+// SetRetryPolicy enables the retry of the reads, it must be called before the storage is used
+func (s *MySQL) SetRetryPolicy(policy RetryPolicy) {
+	s.retryPolicy = policy
+}
+
+func (p *RetryPolicy) isTransient(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, mysqldriver.ErrInvalidConn) {
+		return true
+	}
+
+	var mysqlErr *mysqldriver.MySQLError
+	if errors.As(err, &mysqlErr) {
+		for _, number := range p.MySQLErrors {
+			if mysqlErr.Number == number {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// queryWithRetry runs the read outside of the transaction, each failed attempt which is retried is passed to
+// the metrics sink with the retry operation
+func (s *MySQL) queryWithRetry(ctx context.Context, query string, a []interface{}) (*sql.Rows, error) {
+	backoff := s.retryPolicy.Backoff
+	for attempt := 0; ; attempt++ {
+		start := s.now()
+		res, err := s.queryReplicated(ctx, query, a)
+		if err == nil || ctx.Err() != nil || attempt >= s.retryPolicy.MaxRetries || !s.retryPolicy.isTransient(err) {
+			return res, err
+		}
+
+		if holder, _ := s.metricsSink.Load().(metricsSinkHolder); holder.sink != nil {
+			info, _ := ctx.Value(ctx_query_key).(queryInfo)
+			s.observeQuery(ctx, holder.sink, info.model, "retry", s.since(start), -1, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-s.after(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// queryReplicated runs the read on a replica, a replica which fails to connect is reported and the query is sent
+// to the primary server then
+func (s *MySQL) queryReplicated(ctx context.Context, query string, a []interface{}) (*sql.Rows, error) {
+	if r := s.readReplica(ctx); r != nil {
+		res, err := s.executor(r.db).QueryContext(ctx, query, a...)
+		r.report(s.now(), err)
+		if !isConnFailure(err) {
+			return res, err
+		}
+	}
+
+	return s.executor(s.db).QueryContext(ctx, query, a...)
+}
@@ -0,0 +1,236 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// AddReturning inserts the rows as Add does bypassing the write-behind queue and returns the fields of the inserted
+// rows in the order of the rows. MariaDB 10.5 and later returns them with INSERT ... RETURNING, otherwise the rows
+// are requested from the primary server by the primary keys after the insert.
+func (s *MySQL) AddReturning(ctx context.Context, m model.IModel, data *model.Data, opts model.AddOptions, fieldsNames []string) (*model.Data, error) {
+	if err := checkReturningFields(m, fieldsNames); err != nil {
+		return nil, err
+	}
+
+	useReturning, err := s.useReturning(ctx, 5)
+	if err != nil {
+		return nil, err
+	}
+	if useReturning && s.auditOf(ctx, m) == nil && len(s.writeCallbacks(m)) == 0 {
+		sqlBuf := s.ScopedSqlBuffer(ctx)
+		if _, err := s.writeInsertSQL(sqlBuf, m, data, opts); err != nil {
+			return nil, err
+		}
+		writeReturning(sqlBuf, fieldsNames)
+
+		return s.queryReturning(withQuery(ctx, m.GetId(), "insert"), m, sqlBuf)
+	}
+
+	pks, err := s.insert(ctx, m, data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.selectByPKs(ctx, m, pks, fieldsNames)
+}
+
+// AddAndFetch inserts the rows as AddReturning does and returns all the stored fields of the rows as the database
+// stored them including the server defaults and the generated columns. Without RETURNING the rows are selected
+// by the primary keys with one query in the transaction of the insert, the auto-incremented keys are the ones
+// of LAST_INSERT_ID.
+func (s *MySQL) AddAndFetch(ctx context.Context, m model.IModel, data *model.Data, opts model.AddOptions) ([]map[string]interface{}, error) {
+	var res *model.Data
+	err := s.DoInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		res, err = s.AddReturning(ctx, m, data, opts, storedFieldsNames(m))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Maps(), nil
+}
+
+// DeleteReturning deletes the rows as Delete does and returns their fields. MariaDB returns them with
+// DELETE ... RETURNING, otherwise the rows are selected FOR UPDATE and deleted in one transaction.
+func (s *MySQL) DeleteReturning(ctx context.Context, m model.IModel, filter model.IExpression, fieldsNames []string) (*model.Data, error) {
+	if err := checkReturningFields(m, fieldsNames); err != nil {
+		return nil, err
+	}
+
+	useReturning, err := s.useReturning(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if useReturning && s.auditOf(ctx, m) == nil && len(s.writeCallbacks(m)) == 0 {
+		sqlBuf := s.ScopedSqlBuffer(ctx)
+		sqlBuf.WriteString("DELETE FROM ")
+		sqlBuf.WriteTable(m)
+		if filter := sqlBuf.scoped(s, m, filter); filter != nil {
+			sqlBuf.WriteString(" WHERE ")
+			filter.GetProcessor(s.exprProcessor.ForModel(m)).(WriteFunc)(sqlBuf)
+		}
+		writeReturning(sqlBuf, fieldsNames)
+
+		return s.queryReturning(withQuery(ctx, m.GetId(), "delete"), m, sqlBuf)
+	}
+
+	var res *model.Data
+	err = s.DoInTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if res, err = s.Select(ctx, m, fieldsNames, SelectOptions{GetAllOptions: model.GetAllOptions{
+			Filter:    filter,
+			ForUpdate: true,
+		}}); err != nil {
+			return err
+		}
+
+		return s.Delete(ctx, m, filter)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// useReturning reports whether the server is MariaDB 10.minor or later, the statements of the dry run
+// are recorded without RETURNING
+func (s *MySQL) useReturning(ctx context.Context, minor int) (bool, error) {
+	if dryRunOf(ctx) != nil {
+		return false, nil
+	}
+
+	version, err := s.ServerVersion(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return version.MariaDB && version.AtLeast(10, minor), nil
+}
+
+// storedFieldsNames returns the names of the fields of the model which are not derivable
+func storedFieldsNames(m model.IModel) []string {
+	var res []string
+	for _, name := range m.GetFieldsNames() {
+		if !m.GetFieldDefinition(name).IsDerivable() {
+			res = append(res, name)
+		}
+	}
+
+	return res
+}
+
+func checkReturningFields(m model.IModel, fieldsNames []string) error {
+	if len(fieldsNames) == 0 {
+		return qerror.Errorf("No fields to return")
+	}
+	for _, name := range fieldsNames {
+		field := m.GetFieldDefinition(name)
+		if field == nil {
+			return qerror.Errorf("Unknown field '%s' in model '%s'", name, m.GetId())
+		}
+		if field.IsDerivable() {
+			return qerror.Errorf("The field '%s' in model '%s' is derivable and cannot be returned", name, m.GetId())
+		}
+	}
+
+	return nil
+}
+
+func writeReturning(sqlBuf *SqlBuffer, fieldsNames []string) {
+	sqlBuf.WriteString(" RETURNING ")
+	sqlBuf.WriteIdentifiersList(fieldsNames)
+}
+
+// queryReturning runs the statement with RETURNING and scans the rows through the fields
+func (s *MySQL) queryReturning(ctx context.Context, m model.IModel, sqlBuf *SqlBuffer) (*model.Data, error) {
+	if err := sqlBuf.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.RawQuery(ctx, sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanData(rows, func(_ int, name string) model.IFieldDefinition { return m.GetFieldDefinition(name) })
+}
+
+// selectByPKs requests the fields of the rows with the primary keys from the primary server, the result
+// is in the order of the keys, the missing rows are skipped
+func (s *MySQL) selectByPKs(ctx context.Context, m model.IModel, pks *model.Data, fieldsNames []string) (*model.Data, error) {
+	pkNames := pks.Fields()
+
+	selected := fieldsNames
+	for _, name := range pkNames {
+		selected = appendMissing(selected[:len(selected):len(selected)], name)
+	}
+	res := model.NewEmptyData(fieldsNames)
+	if pks.Len() == 0 {
+		return res, nil
+	}
+
+	keys := make([]interface{}, pks.Len())
+	for i, row := range pks.Data() {
+		keys[i] = row
+	}
+	filter, err := PKsFilter(m, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := s.Select(ForcePrimary(ctx), m, selected, SelectOptions{GetAllOptions: model.GetAllOptions{Filter: filter}})
+	if err != nil {
+		return nil, err
+	}
+
+	return orderByPKs(data, pks, fieldsNames)
+}
+
+// orderByPKs returns the fields of the selected rows in the order of the primary keys, the missing rows are skipped
+func orderByPKs(data, pks *model.Data, fieldsNames []string) (*model.Data, error) {
+	pkNames := pks.Fields()
+	res := model.NewEmptyData(fieldsNames)
+
+	byPK := make(map[string][]interface{}, data.Len())
+	for _, row := range data.Data() {
+		pk := make([]interface{}, len(pkNames))
+		for i, name := range pkNames {
+			pk[i] = derefValue(row[data.FieldNum(name)])
+		}
+		resRow := make([]interface{}, len(fieldsNames))
+		for i, name := range fieldsNames {
+			resRow[i] = row[data.FieldNum(name)]
+		}
+		byPK[fmt.Sprint(pk...)] = resRow
+	}
+
+	for _, row := range pks.Data() {
+		if resRow, exists := byPK[fmt.Sprint(row...)]; exists {
+			if err := res.Add(resRow); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func (m *BaseModel) AddReturning(ctx context.Context, data *model.Data, opts model.AddOptions, fieldsNames []string) (*model.Data, error) {
+	return m.db.AddReturning(ctx, m, data, opts, fieldsNames)
+}
+
+func (m *BaseModel) AddAndFetch(ctx context.Context, data *model.Data, opts model.AddOptions) ([]map[string]interface{}, error) {
+	return m.db.AddAndFetch(ctx, m, data, opts)
+}
+
+func (m *BaseModel) DeleteReturning(ctx context.Context, filter model.IExpression, fieldsNames []string) (*model.Data, error) {
+	return m.db.DeleteReturning(ctx, m, filter, fieldsNames)
+}
@@ -0,0 +1,225 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+const ctx_unscoped_key = "MYSQL_UNSCOPED"
+
+// ErrOutOfScope is matched by the errors of the written values of the scoped fields which differ from the scope ones
+var ErrOutOfScope = errors.New("out of scope")
+
+// ScopePolicy returns the filter of the rows of the model visible with the context, e.g. the tenant ones.
+// The filter is ANDed into every SELECT, UPDATE and DELETE of the model including the joins and the subqueries,
+// the fields compared with the values by Eq in the filter are set to the values by every INSERT. An error
+// fails the statement.
+type ScopePolicy func(ctx context.Context) (model.IExpression, error)
+
+// Unscoped disables the scope policies for the statements run with the context, e.g. for the admin jobs.
+// The statements are reported to the query hooks with QueryHookInfo.Unscoped.
+func Unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctx_unscoped_key, true)
+}
+
+func isUnscoped(ctx context.Context) bool {
+	unscoped, _ := ctx.Value(ctx_unscoped_key).(bool)
+	return unscoped
+}
+
+// ScopePolicy returns the scope policy of the model, see BaseModelOpts.Scope
+func (m *BaseModel) ScopePolicy() ScopePolicy {
+	return m.scope
+}
+
+// scopeSet is the scope filters of the statement, they are requested from the policies once
+type scopeSet struct {
+	ctx      context.Context
+	unscoped bool
+	filters  map[string]model.IExpression
+}
+
+// ScopedSqlBuffer returns the buffer the statements over the scoped models are written to, the scope filters
+// are requested with the context. The statements over the scoped models written to the buffer returned by
+// NewSqlBuffer fail. The tables of the sharded models are the shard ones of the context, see WithShard.
+func (s *MySQL) ScopedSqlBuffer(ctx context.Context) *SqlBuffer {
+	sqlBuf := NewSqlBuffer()
+	sqlBuf.scopes = &scopeSet{ctx: ctx, unscoped: isUnscoped(ctx)}
+	sqlBuf.shards = &shardRouting{storage: s, ctx: ctx}
+
+	return sqlBuf
+}
+
+// scopePolicy returns the policy of the registered model, the model passed to the storage by model.BaseModel
+// is not the registered one. The processor without the storage writes no scopes.
+func (s *MySQL) scopePolicy(m model.IModel) ScopePolicy {
+	if s == nil {
+		return nil
+	}
+
+	s.modelsMtx.RLock()
+	registered := s.models[m.GetId()]
+	s.modelsMtx.RUnlock()
+
+	if dbModel, ok := registered.(interface{ ScopePolicy() ScopePolicy }); ok {
+		return dbModel.ScopePolicy()
+	}
+
+	return nil
+}
+
+// scopeFilter returns the scope filter of the model, nil if the model is not scoped or the context is unscoped
+func (s *MySQL) scopeFilter(scopes *scopeSet, m model.IModel) (model.IExpression, error) {
+	policy := s.scopePolicy(m)
+	if policy == nil {
+		return nil, nil
+	}
+	if scopes == nil {
+		return nil, qerror.Errorf("The statement over the scoped model '%s' must be written to ScopedSqlBuffer", m.GetId())
+	}
+	if scopes.unscoped {
+		return nil, nil
+	}
+
+	if filter, exists := scopes.filters[m.GetId()]; exists {
+		return filter, nil
+	}
+	filter, err := policy(scopes.ctx)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return nil, qerror.Errorf("The scope policy of model '%s' returned no filter", m.GetId())
+	}
+	if scopes.filters == nil {
+		scopes.filters = make(map[string]model.IExpression)
+	}
+	scopes.filters[m.GetId()] = filter
+
+	return filter, nil
+}
+
+// scoped returns the filter ANDed with the scope filter of the model, the error is stored in the buffer
+func (b *SqlBuffer) scoped(s *MySQL, m model.IModel, filter model.IExpression) model.IExpression {
+	scope, err := s.scopeFilter(b.scopes, m)
+	if err != nil {
+		b.SetError(err)
+		return filter
+	}
+	if scope == nil {
+		return filter
+	}
+	if filter == nil {
+		return scope
+	}
+
+	return And(filter, scope)
+}
+
+// scopeValues returns the values of the fields of the model compared by Eq in the scope filter
+func scopeValues(m model.IModel, filter model.IExpression) map[string]interface{} {
+	res := make(map[string]interface{})
+	for _, op := range flattenLogical("AND", []model.IExpression{filter}, nil) {
+		info := inspectExpr(op)
+		if info.comparison != "=" {
+			continue
+		}
+		field, value := inspectExpr(info.ops[0]), inspectExpr(info.ops[1])
+		if !field.isField {
+			field, value = value, field
+		}
+		if field.isField && value.isValue && (field.model == nil || field.model.GetId() == m.GetId()) {
+			res[field.fieldName] = value.value
+		}
+	}
+
+	return res
+}
+
+// scopeRow checks the values of the scoped fields of the row and returns the values of the scoped fields
+// missing in the row
+func (b *SqlBuffer) scopeRow(s *MySQL, m model.IModel, values map[string]interface{}) (map[string]interface{}, error) {
+	scope, err := s.scopeFilter(b.scopes, m)
+	if err != nil || scope == nil {
+		return nil, err
+	}
+
+	missing := scopeValues(m, scope)
+	for name, value := range values {
+		scopeValue, exists := missing[name]
+		if !exists {
+			continue
+		}
+		if res, ok := compareValues(derefValue(value), scopeValue); !ok || res != 0 {
+			return nil, fmt.Errorf("%w: the value of the field '%s' of model '%s' differs from the scope one", ErrOutOfScope, name, m.GetId())
+		}
+		delete(missing, name)
+	}
+
+	return missing, nil
+}
+
+// scopeData returns the rows with the values of the scoped fields, the rows with the other values
+// of the scoped fields are refused
+func (b *SqlBuffer) scopeData(s *MySQL, m model.IModel, data *model.Data) (*model.Data, error) {
+	scope, err := s.scopeFilter(b.scopes, m)
+	if err != nil || scope == nil {
+		return data, err
+	}
+
+	values := scopeValues(m, scope)
+	var missing []string
+	for name := range values {
+		if data.FieldNum(name) < 0 {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+
+	rows := make([][]interface{}, data.Len())
+	for i, row := range data.Data() {
+		for j, name := range data.Fields() {
+			scopeValue, exists := values[name]
+			if !exists {
+				continue
+			}
+			if res, ok := compareValues(derefValue(row[j]), scopeValue); !ok || res != 0 {
+				return nil, fmt.Errorf("%w: the value of the field '%s' of model '%s' at row %d differs from the scope one",
+					ErrOutOfScope, name, m.GetId(), i+1)
+			}
+		}
+		if len(missing) == 0 {
+			rows[i] = row
+			continue
+		}
+		rows[i] = append(row[:len(row):len(row)], make([]interface{}, len(missing))...)
+		for j, name := range missing {
+			rows[i][len(row)+j] = values[name]
+		}
+	}
+	if len(missing) == 0 {
+		return data, nil
+	}
+
+	return model.NewData(append(data.Fields()[:len(data.Fields()):len(data.Fields())], missing...), rows), nil
+}
+
+// AddMulti sets the values of the scoped fields of the rows before model.BaseModel checks the required fields,
+// the unknown fields are reported as UnknownFieldError
+func (m *BaseModel) AddMulti(ctx context.Context, data *model.Data, opts model.AddOptions) (*model.Data, error) {
+	if err := checkFields(m, data.Fields(), PartValue); err != nil {
+		return nil, err
+	}
+
+	data, err := m.db.ScopedSqlBuffer(ctx).scopeData(m.db, m, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.BaseModel.AddMulti(ctx, data, opts)
+}
@@ -0,0 +1,264 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-qbit/qerror"
+)
+
+// ScriptSnippetLength is the length the statements are truncated to in ScriptError
+const ScriptSnippetLength = 80
+
+type ScriptOpts struct {
+	// Transaction runs each sequence of the statements without the implicit commit (see implicitCommitKeywords)
+	// in a transaction. MySQL commits the open transaction before DDL and the like, so the statements of the script
+	// are atomic per sequence only and the sequences committed before a failure are not rolled back.
+	Transaction bool
+}
+
+// ScriptError is the error of the failed statement of the script, Index is the number of the statement from 0,
+// Line is the line it starts at from 1
+type ScriptError struct {
+	Index   int
+	Line    int
+	Snippet string
+	Err     error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("statement %d at line %d (%s): %s", e.Index, e.Line, e.Snippet, e.Err.Error())
+}
+
+func (e *ScriptError) Unwrap() error { return e.Err }
+
+// implicitCommitKeywords are the first keywords of the statements which commit the open transaction
+var implicitCommitKeywords = []string{
+	"ALTER", "ANALYZE", "BEGIN", "COMMIT", "CREATE", "DROP", "FLUSH", "GRANT", "INSTALL", "LOCK", "OPTIMIZE",
+	"RENAME", "REPAIR", "REVOKE", "ROLLBACK", "START", "TRUNCATE", "UNINSTALL", "UNLOCK",
+}
+
+type scriptStatement struct {
+	sql  string
+	line int
+}
+
+// ExecScript executes the statements of the script separated by semicolons one by one on one connection,
+// so the multiStatements DSN parameter is not needed and the session variables are kept between the statements.
+// The delimiter is changed with the DELIMITER command as in the mysql client, the comments are removed except
+// the executable /*! */ ones and the optimizer hints. The script is executed in the transaction of the context
+// if there is one, opts.Transaction is ignored then, and the statements committing it implicitly fail
+// with ErrImplicitCommitInTx unless AllowImplicitCommit is set. The failed statement is reported with ScriptError.
+func (s *MySQL) ExecScript(ctx context.Context, script string, opts ScriptOpts) error {
+	statements, err := splitScript(script)
+	if err != nil {
+		return err
+	}
+
+	var exec Executor
+	if t, ok := ctx.Value(s.transactionKey()).(*transaction); ok {
+		exec, opts.Transaction = t.exec, false
+	} else {
+		conn, err := s.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		exec = s.executor(conn)
+	}
+
+	inTx := false
+	for i, statement := range statements {
+		if err := ctx.Err(); err != nil {
+			if inTx {
+				_ = s.execScriptStatement(ctx, exec, "ROLLBACK")
+			}
+			return err
+		}
+
+		if opts.Transaction {
+			implicitCommit := containsString(implicitCommitKeywords, firstKeyword(statement.sql))
+			if inTx && implicitCommit {
+				if err := s.execScriptStatement(ctx, exec, "COMMIT"); err != nil {
+					return s.scriptError(i-1, statements[i-1], err)
+				}
+				inTx = false
+			} else if !inTx && !implicitCommit {
+				if err := s.execScriptStatement(ctx, exec, "BEGIN"); err != nil {
+					return s.scriptError(i, statement, err)
+				}
+				inTx = true
+			}
+		}
+
+		if err := s.execScriptStatement(ctx, exec, statement.sql); err != nil {
+			if inTx {
+				_ = s.execScriptStatement(ctx, exec, "ROLLBACK")
+			}
+			return s.scriptError(i, statement, err)
+		}
+	}
+
+	if inTx {
+		if err := s.execScriptStatement(ctx, exec, "COMMIT"); err != nil {
+			return s.scriptError(len(statements)-1, statements[len(statements)-1], err)
+		}
+	}
+
+	return nil
+}
+
+// execScriptStatement executes the statement as Exec does but on the executor
+func (s *MySQL) execScriptStatement(ctx context.Context, exec Executor, query string) error {
+	if d := dryRunOf(ctx); d != nil {
+		d.record(query, nil)
+		return nil
+	}
+	if err := s.checkSnapshotWrite(ctx, query); err != nil {
+		return err
+	}
+	if err := s.checkImplicitCommit(ctx, query); err != nil {
+		return err
+	}
+	if err := s.checkQueryBudget(ctx); err != nil {
+		return err
+	}
+
+	start := s.now()
+	res, err := exec.ExecContext(ctx, query)
+
+	var rowsAffected int64
+	if err == nil {
+		rowsAffected, _ = res.RowsAffected()
+	}
+	s.statementDone(ctx, query, nil, start, rowsAffected, err)
+	if err != nil {
+		info := newQueryHookInfo(ctx, query, nil)
+		return s.statementError(ctx, info.Model, info.Op, query, nil, err)
+	}
+
+	return nil
+}
+
+func (s *MySQL) scriptError(index int, statement scriptStatement, err error) error {
+	return &ScriptError{
+		Index:   index,
+		Line:    statement.line,
+		Snippet: truncateStatement(strings.Join(strings.Fields(statement.sql), " "), ScriptSnippetLength),
+		Err:     err,
+	}
+}
+
+// firstKeyword returns the first word of the statement in the upper case
+func firstKeyword(query string) string {
+	end := strings.IndexFunc(query, func(r rune) bool { return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z') })
+	if end < 0 {
+		end = len(query)
+	}
+
+	return strings.ToUpper(query[:end])
+}
+
+// splitScript splits the script into the statements skipping the delimiters in the quoted strings, the quoted
+// identifiers and the comments
+func splitScript(script string) ([]scriptStatement, error) {
+	var (
+		res       []scriptStatement
+		buf       strings.Builder
+		delimiter = ";"
+		line      = 1
+		startLine = 0 // the line of the statement, 0 until it has a non-space character
+	)
+
+	flush := func() {
+		if startLine > 0 {
+			res = append(res, scriptStatement{strings.TrimSpace(buf.String()), startLine})
+		}
+		buf.Reset()
+		startLine = 0
+	}
+	// skipTo returns the position after the end or -1, the new lines are counted
+	skipTo := func(i int, end string) int {
+		pos := strings.Index(script[i:], end)
+		if pos < 0 {
+			return -1
+		}
+		line += strings.Count(script[i:i+pos+len(end)], "\n")
+		return i + pos + len(end)
+	}
+
+	for i := 0; i < len(script); {
+		c := script[i]
+
+		if startLine == 0 && len(script)-i > 10 && strings.EqualFold(script[i:i+9], "DELIMITER") && (script[i+9] == ' ' || script[i+9] == '\t') {
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			fields := strings.Fields(script[i+10 : i+end])
+			if len(fields) == 0 {
+				return nil, qerror.Errorf("line %d: DELIMITER without the delimiter", line)
+			}
+			delimiter = fields[0]
+			i += end
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(script[i:], delimiter):
+			flush()
+			i += len(delimiter)
+
+		case c == '\'' || c == '"' || c == '`':
+			if startLine == 0 {
+				startLine = line
+			}
+			start, quoteLine := i, line
+			for i++; i < len(script) && script[i] != c; i++ {
+				if script[i] == '\\' && c != '`' {
+					i++
+				}
+			}
+			if i >= len(script) {
+				return nil, qerror.Errorf("line %d: unterminated quoted string", quoteLine)
+			}
+			i++
+			line += strings.Count(script[start:i], "\n")
+			buf.WriteString(script[start:i])
+
+		case c == '#' || c == '-' && strings.HasPrefix(script[i:], "--") && (i+2 == len(script) || strings.IndexByte(" \t\r\n", script[i+2]) >= 0):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				end = len(script) - i
+			}
+			i += end
+
+		case strings.HasPrefix(script[i:], "/*"):
+			start, commentLine := i, line
+			if i = skipTo(i+2, "*/"); i < 0 {
+				return nil, qerror.Errorf("line %d: unterminated comment", commentLine)
+			}
+			if strings.HasPrefix(script[start:], "/*!") || strings.HasPrefix(script[start:], "/*+") {
+				if startLine == 0 {
+					startLine = commentLine
+				}
+				buf.WriteString(script[start:i])
+			} else {
+				buf.WriteByte(' ')
+			}
+
+		default:
+			if c == '\n' {
+				line++
+			} else if startLine == 0 && c != ' ' && c != '\t' && c != '\r' {
+				startLine = line
+			}
+			buf.WriteByte(c)
+			i++
+		}
+	}
+	flush()
+
+	return res, nil
+}
@@ -0,0 +1,563 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+const ctx_shard_key = "MYSQL_SHARD"
+
+// DefaultShardTablePattern names the shard tables by the table of the model and the shard number, e.g. event_03
+const DefaultShardTablePattern = "%s_%02d"
+
+// ErrCrossShard is matched by the errors of the statements of a transaction over the other shard of the model
+// than the one the transaction is bound to, see CrossShardError
+var ErrCrossShard = errors.New("cross-shard transaction")
+
+// CrossShardError is the error of the statement of the transaction over Shard of the model while the transaction
+// is bound to TxShard by the previous statements. Shard is -1 for the statements over all the shards, TxShard is -1
+// if the transaction is not bound yet.
+type CrossShardError struct {
+	Model   string
+	Shard   int
+	TxShard int
+}
+
+func (e *CrossShardError) Error() string {
+	if e.Shard < 0 {
+		return fmt.Sprintf("%s: the statement over all the shards of model '%s' in the transaction", ErrCrossShard, e.Model)
+	}
+
+	return fmt.Sprintf("%s: the statement over shard %d of model '%s' in the transaction bound to shard %d",
+		ErrCrossShard, e.Shard, e.Model, e.TxShard)
+}
+
+func (e *CrossShardError) Is(target error) bool { return target == ErrCrossShard }
+
+// ShardSpec splits the rows of the model across Count tables of the same database by the hash of the value
+// of KeyField. The tables are named by TablePattern of the table of the model and the shard number,
+// DefaultShardTablePattern if it is empty. The model is not sharded if Count is 0.
+//
+// The rows are added to the shards of their keys. The reads, the updates and the deletes comparing KeyField
+// with a value by Eq in the filter or in the scope are sent to its shard, the other ones are sent to all
+// the shards and the read rows are merged. The other statements over the model must be routed with WithShard.
+// A transaction is bound to the first shard of the model it uses, the statements over the other shards fail
+// with CrossShardError.
+type ShardSpec struct {
+	Count        int
+	KeyField     string
+	TablePattern string
+}
+
+// table returns the name of the shard table of the model table
+func (spec ShardSpec) table(base string, shard int) string {
+	pattern := spec.TablePattern
+	if pattern == "" {
+		pattern = DefaultShardTablePattern
+	}
+
+	return fmt.Sprintf(pattern, base, shard)
+}
+
+// shardOf returns the shard of the key, it is the FNV-1a hash of the decimal or the string form of the key
+// modulo the number of the shards
+func (spec ShardSpec) shardOf(modelId string, key interface{}) (int, error) {
+	key = derefValue(key)
+	if key == nil {
+		return 0, qerror.Errorf("The shard key field '%s' of model '%s' is NULL", spec.KeyField, modelId)
+	}
+
+	h := fnv.New32a()
+	switch v := key.(type) {
+	case []byte:
+		h.Write(v)
+	case string:
+		h.Write([]byte(v))
+	default:
+		rv := reflect.ValueOf(v)
+		switch {
+		case isUintKind(rv.Kind()):
+			h.Write(strconv.AppendUint(nil, rv.Uint(), 10))
+		case isIntegerKind(rv.Kind()):
+			h.Write(strconv.AppendInt(nil, rv.Int(), 10))
+		case rv.Kind() == reflect.String:
+			h.Write([]byte(rv.String()))
+		default:
+			fmt.Fprint(h, v)
+		}
+	}
+
+	return int(h.Sum32() % uint32(spec.Count)), nil
+}
+
+// ShardSpec returns the sharding of the model, see BaseModelOpts.Shard
+func (m *BaseModel) ShardSpec() ShardSpec {
+	return m.shard
+}
+
+// tables returns the names of the tables of the model, the shard tables of the sharded one
+func (m *BaseModel) tables() []string {
+	if m.shard.Count == 0 {
+		return []string{m.GetTableName()}
+	}
+
+	res := make([]string, m.shard.Count)
+	for i := range res {
+		res[i] = m.shard.table(m.GetTableName(), i)
+	}
+
+	return res
+}
+
+// shardSpec returns the sharding of the registered model
+func (s *MySQL) shardSpec(m model.IModel) ShardSpec {
+	if s == nil {
+		return ShardSpec{}
+	}
+
+	s.modelsMtx.RLock()
+	registered := s.models[m.GetId()]
+	s.modelsMtx.RUnlock()
+
+	if dbModel, ok := registered.(interface{ ShardSpec() ShardSpec }); ok {
+		return dbModel.ShardSpec()
+	}
+
+	return ShardSpec{}
+}
+
+// ShardForKey returns the shard of the rows of the model with the value of the shard key field
+func (s *MySQL) ShardForKey(m model.IModel, key interface{}) (int, error) {
+	spec := s.shardSpec(m)
+	if spec.Count == 0 {
+		return 0, qerror.Errorf("The model '%s' is not sharded", m.GetId())
+	}
+
+	return spec.shardOf(m.GetId(), key)
+}
+
+// WithShard routes the statements over the sharded model run with the context to the shard, e.g. the joins,
+// the subqueries and the raw statements written to ScopedSqlBuffer
+func WithShard(ctx context.Context, m model.IModel, shard int) context.Context {
+	prev, _ := ctx.Value(ctx_shard_key).(map[string]int)
+	res := make(map[string]int, len(prev)+1)
+	for id, prevShard := range prev {
+		res[id] = prevShard
+	}
+	res[m.GetId()] = shard
+
+	return context.WithValue(ctx, ctx_shard_key, res)
+}
+
+// routedShard returns the shard of the model the context routes the statements to
+func routedShard(ctx context.Context, m model.IModel) (int, bool) {
+	shards, _ := ctx.Value(ctx_shard_key).(map[string]int)
+	shard, exists := shards[m.GetId()]
+
+	return shard, exists
+}
+
+// shardRouting is the routing of the statement written to ScopedSqlBuffer to the shard tables
+type shardRouting struct {
+	storage *MySQL
+	ctx     context.Context
+}
+
+// writeTable writes the table of the model or its shard table the context routes the statement to,
+// it reports whether the shard table is written
+func (b *SqlBuffer) writeTable(m model.IModel) bool {
+	var spec ShardSpec
+	if b.shards != nil {
+		spec = b.shards.storage.shardSpec(m)
+	}
+	if spec.Count == 0 {
+		b.WriteString(QuoteTable(m))
+		return false
+	}
+
+	shard, ok := routedShard(b.shards.ctx, m)
+	switch {
+	case !ok:
+		b.SetError(qerror.Errorf("The statement over the sharded model '%s' must be routed to a shard, see WithShard", m.GetId()))
+	case shard < 0 || shard >= spec.Count:
+		b.SetError(qerror.Errorf("Invalid shard %d of model '%s'", shard, m.GetId()))
+	default:
+		if err := b.shards.storage.bindShard(b.shards.ctx, m, shard); err != nil {
+			b.SetError(err)
+		}
+	}
+	b.WriteString(quoteTableName(m, spec.table(TableName(m), shard)))
+
+	return true
+}
+
+// bindShard binds the transaction of the context to the shard of the model, the other shard fails
+func (s *MySQL) bindShard(ctx context.Context, m model.IModel, shard int) error {
+	t, _ := ctx.Value(s.transactionKey()).(*transaction)
+	if t == nil {
+		return nil
+	}
+
+	t.shardsMtx.Lock()
+	defer t.shardsMtx.Unlock()
+
+	if bound, exists := t.shards[m.GetId()]; exists && bound != shard {
+		return &CrossShardError{Model: m.GetId(), Shard: shard, TxShard: bound}
+	}
+	if t.shards == nil {
+		t.shards = make(map[string]int)
+	}
+	t.shards[m.GetId()] = shard
+
+	return nil
+}
+
+// checkFanOut fails the statement over all the shards of the model in a transaction
+func (s *MySQL) checkFanOut(ctx context.Context, m model.IModel) error {
+	t, _ := ctx.Value(s.transactionKey()).(*transaction)
+	if t == nil {
+		return nil
+	}
+
+	t.shardsMtx.Lock()
+	defer t.shardsMtx.Unlock()
+
+	txShard, exists := t.shards[m.GetId()]
+	if !exists {
+		txShard = -1
+	}
+
+	return &CrossShardError{Model: m.GetId(), Shard: -1, TxShard: txShard}
+}
+
+// filterShard returns the shard of the rows matching the filter and the scope, ok is false if the shard key
+// is not compared with a value by Eq
+func (s *MySQL) filterShard(ctx context.Context, m model.IModel, spec ShardSpec, filter model.IExpression) (shard int, ok bool, err error) {
+	sqlBuf := s.ScopedSqlBuffer(ctx)
+	if filter = sqlBuf.scoped(s, m, filter); sqlBuf.Err() != nil {
+		return 0, false, sqlBuf.Err()
+	}
+	if filter == nil {
+		return 0, false, nil
+	}
+
+	key, exists := scopeValues(m, filter)[spec.KeyField]
+	if !exists {
+		return 0, false, nil
+	}
+	if shard, err = spec.shardOf(m.GetId(), key); err != nil {
+		return 0, false, err
+	}
+
+	return shard, true, nil
+}
+
+// insertSharded adds the rows to the shards of their keys, the rows of the different shards are added
+// by the separate statements out of the transactions
+func (s *MySQL) insertSharded(ctx context.Context, m model.IModel, spec ShardSpec, data *model.Data, opts model.AddOptions) (*model.Data, error) {
+	data, err := s.ScopedSqlBuffer(ctx).scopeData(s, m, data)
+	if err != nil {
+		return nil, err
+	}
+	keyPos := data.FieldNum(spec.KeyField)
+	if keyPos < 0 {
+		return nil, qerror.Errorf("The rows of the sharded model '%s' must have the shard key field '%s'", m.GetId(), spec.KeyField)
+	}
+
+	rowsOf := make(map[int][]int)
+	for i, row := range data.Data() {
+		shard, err := spec.shardOf(m.GetId(), row[keyPos])
+		if err != nil {
+			return nil, err
+		}
+		rowsOf[shard] = append(rowsOf[shard], i)
+	}
+	shards := make([]int, 0, len(rowsOf))
+	for shard := range rowsOf {
+		shards = append(shards, shard)
+	}
+	sort.Ints(shards)
+
+	if len(shards) == 1 {
+		return s.insert(WithShard(ctx, m, shards[0]), m, data, opts)
+	}
+	if s.InTransaction(ctx) {
+		return nil, &CrossShardError{Model: m.GetId(), Shard: shards[1], TxShard: shards[0]}
+	}
+
+	res := make([][]interface{}, data.Len())
+	for _, shard := range shards {
+		rows := make([][]interface{}, len(rowsOf[shard]))
+		for i, pos := range rowsOf[shard] {
+			rows[i] = data.Data()[pos]
+		}
+		shardRes, err := s.insert(WithShard(ctx, m, shard), m, model.NewData(data.Fields(), rows), opts)
+		if err != nil {
+			return nil, err
+		}
+		for i, pos := range rowsOf[shard] {
+			res[pos] = shardRes.Data()[i]
+		}
+	}
+
+	return model.NewData(m.GetPKFieldsNames(), res), nil
+}
+
+// selectSharded reads the rows of the shard of the filter or of all the shards, the rows of the shards
+// are merged by the order of GetAllOptions.OrderBy and limited then
+func (s *MySQL) selectSharded(ctx context.Context, m model.IModel, spec ShardSpec, fieldsNames []string, options SelectOptions) (*model.Data, error) {
+	shard, ok, err := s.filterShard(ctx, m, spec, options.Filter)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return s.Select(WithShard(ctx, m, shard), m, fieldsNames, options)
+	}
+	if err := s.checkFanOut(ctx, m); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case len(options.GroupBy) > 0 || options.Having != nil:
+		return nil, qerror.Errorf("The grouped rows of the shards of model '%s' cannot be merged", m.GetId())
+	case options.Distinct:
+		return nil, qerror.Errorf("The distinct rows of the shards of model '%s' cannot be merged", m.GetId())
+	case options.OuterFilter != nil:
+		return nil, qerror.Errorf("OuterFilter cannot be used over the shards of model '%s'", m.GetId())
+	case options.RowsWoLimit != nil:
+		return nil, qerror.Errorf("The rows without the limit of the shards of model '%s' cannot be counted", m.GetId())
+	case len(options.OrderBy) > 0:
+		return nil, qerror.Errorf("The rows of the shards of model '%s' can be merged by the ordered fields only", m.GetId())
+	}
+	for _, order := range options.GetAllOptions.OrderBy {
+		if !containsString(fieldsNames, order.FieldName) {
+			return nil, qerror.Errorf("The ordered field '%s' of the sharded model '%s' must be selected", order.FieldName, m.GetId())
+		}
+	}
+
+	shardOptions := options
+	if options.Limit > 0 {
+		shardOptions.Limit, shardOptions.Offset = options.Limit+options.Offset, 0
+	}
+
+	results := make([]*model.Data, spec.Count)
+	for i := range results {
+		if results[i], err = s.Select(WithShard(ctx, m, i), m, fieldsNames, shardOptions); err != nil {
+			return nil, err
+		}
+	}
+	res := mergeShards(results, options.GetAllOptions.OrderBy)
+
+	rows := res.Data()
+	if options.Offset > 0 {
+		if options.Offset >= uint64(len(rows)) {
+			rows = nil
+		} else {
+			rows = rows[options.Offset:]
+		}
+	}
+	if options.Limit > 0 && uint64(len(rows)) > options.Limit {
+		rows = rows[:options.Limit]
+	}
+
+	return model.NewData(res.Fields(), rows), nil
+}
+
+// mergeShards merges the rows of the shards sorted by the order, the rows are concatenated without the order
+func mergeShards(results []*model.Data, order []model.Order) *model.Data {
+	var rowsCount int
+	for _, data := range results {
+		rowsCount += data.Len()
+	}
+	rows := make([][]interface{}, 0, rowsCount)
+	if len(order) == 0 {
+		for _, data := range results {
+			rows = append(rows, data.Data()...)
+		}
+		return model.NewData(results[0].Fields(), rows)
+	}
+
+	fieldsNums := make([]int, len(order))
+	for i, o := range order {
+		fieldsNums[i] = results[0].FieldNum(o.FieldName)
+	}
+	less := func(a, b []interface{}) bool {
+		for i, o := range order {
+			res := compareSortValues(a[fieldsNums[i]], b[fieldsNums[i]])
+			if o.Desc {
+				res = -res
+			}
+			if res != 0 {
+				return res < 0
+			}
+		}
+		return false
+	}
+
+	heads := make([]int, len(results))
+	for len(rows) < rowsCount {
+		next := -1
+		for i, data := range results {
+			if heads[i] < data.Len() && (next < 0 || less(data.Data()[heads[i]], results[next].Data()[heads[next]])) {
+				next = i
+			}
+		}
+		rows = append(rows, results[next].Data()[heads[next]])
+		heads[next]++
+	}
+
+	return model.NewData(results[0].Fields(), rows)
+}
+
+// compareSortValues compares the values as MySQL sorts them, NULL is less than the other values
+func compareSortValues(a, b interface{}) int {
+	a, b = derefValue(a), derefValue(b)
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	res, _ := compareValues(a, b)
+
+	return res
+}
+
+// editSharded updates the rows of the shard of the filter or of all the shards, the shard key may be changed
+// to the value of the same shard only
+func (s *MySQL) editSharded(ctx context.Context, m model.IModel, spec ShardSpec, filter model.IExpression, newValues map[string]interface{}, opts EditWhereOpts) (driver.Result, error) {
+	shard, ok, err := s.filterShard(ctx, m, spec, filter)
+	if err != nil {
+		return nil, err
+	}
+	if key, exists := newValues[spec.KeyField]; exists {
+		if _, isExpr := key.(model.IExpression); isExpr || !ok {
+			return nil, qerror.Errorf("The shard key field '%s' of model '%s' can be changed in the shard of the filter only", spec.KeyField, m.GetId())
+		}
+		newShard, err := spec.shardOf(m.GetId(), key)
+		if err != nil {
+			return nil, err
+		}
+		if newShard != shard {
+			return nil, qerror.Errorf("The rows of model '%s' cannot be moved to the other shard by the shard key field '%s'", m.GetId(), spec.KeyField)
+		}
+	}
+	if ok {
+		return s.execEdit(WithShard(ctx, m, shard), m, filter, newValues, opts)
+	}
+	if err := s.checkFanOut(ctx, m); err != nil {
+		return nil, err
+	}
+	if opts.Limit > 0 {
+		return nil, qerror.Errorf("The limited update of the sharded model '%s' must filter the rows of one shard", m.GetId())
+	}
+
+	var rowsAffected int64
+	for i := 0; i < spec.Count; i++ {
+		res, err := s.execEdit(WithShard(ctx, m, i), m, filter, newValues, opts)
+		if err != nil {
+			return nil, err
+		}
+		count, _ := res.RowsAffected()
+		rowsAffected += count
+	}
+
+	return driver.RowsAffected(rowsAffected), nil
+}
+
+// deleteSharded deletes the rows of the shard of the filter or of all the shards
+func (s *MySQL) deleteSharded(ctx context.Context, m model.IModel, spec ShardSpec, filter model.IExpression) (driver.Result, error) {
+	shard, ok, err := s.filterShard(ctx, m, spec, filter)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return s.execDelete(WithShard(ctx, m, shard), m, filter)
+	}
+	if err := s.checkFanOut(ctx, m); err != nil {
+		return nil, err
+	}
+
+	var rowsAffected int64
+	for i := 0; i < spec.Count; i++ {
+		res, err := s.execDelete(WithShard(ctx, m, i), m, filter)
+		if err != nil {
+			return nil, err
+		}
+		count, _ := res.RowsAffected()
+		rowsAffected += count
+	}
+
+	return driver.RowsAffected(rowsAffected), nil
+}
+
+// ShardRowCount is the number of the rows of the shard table visible with the context
+type ShardRowCount struct {
+	Shard int
+	Table string
+	Rows  uint64
+}
+
+// ShardRowCounts returns the numbers of the rows of the shards of the model, e.g. to check the balance
+// of the shards. It cannot be called in a transaction.
+func (s *MySQL) ShardRowCounts(ctx context.Context, m model.IModel) ([]ShardRowCount, error) {
+	spec := s.shardSpec(m)
+	if spec.Count == 0 {
+		return nil, qerror.Errorf("The model '%s' is not sharded", m.GetId())
+	}
+	if err := s.checkFanOut(ctx, m); err != nil {
+		return nil, err
+	}
+
+	res := make([]ShardRowCount, spec.Count)
+	for i := range res {
+		shardCtx := WithShard(ctx, m, i)
+		sqlBuf := s.ScopedSqlBuffer(shardCtx)
+		sqlBuf.WriteString("SELECT COUNT(*) FROM ")
+		sqlBuf.WriteTable(m)
+		if filter := sqlBuf.scoped(s, m, nil); filter != nil {
+			sqlBuf.WriteString(" WHERE ")
+			filter.GetProcessor(s.exprProcessor.ForModel(m)).(WriteFunc)(sqlBuf)
+		}
+		if err := sqlBuf.Err(); err != nil {
+			return nil, err
+		}
+
+		rows, err := s.RawQuery(withQuery(shardCtx, m.GetId(), "count"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = ShardRowCount{Shard: i, Table: spec.table(TableName(m), i)}
+		if rows.Next() {
+			err = rows.Scan(&res[i].Rows)
+		}
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+func (m *BaseModel) ShardForKey(key interface{}) (int, error) {
+	return m.db.ShardForKey(m, key)
+}
+
+func (m *BaseModel) ShardRowCounts(ctx context.Context) ([]ShardRowCount, error) {
+	return m.db.ShardRowCounts(ctx, m)
+}
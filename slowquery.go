@@ -0,0 +1,57 @@
+package mysql
+
+import (
+	"context"
+	"time"
+)
+
+// SlowQuery is the statement which took longer than the threshold including the wait for a pool connection,
+// InterpolatedSQL is set if SetInterpolateSQL is enabled. The statements are sanitized, see RecordingPolicy.
+type SlowQuery struct {
+	SQL             string
+	Args            []interface{}
+	InterpolatedSQL string
+	Duration        time.Duration
+	InTransaction   bool
+}
+
+type SlowQueryHandler func(ctx context.Context, query SlowQuery)
+
+// ArgsRedactor returns the arguments of the statement passed to the slow queries handler,
+// e.g. with the passwords replaced
+type ArgsRedactor func(query string, args []interface{}) []interface{}
+
+// SetSlowQueryHandler sets the handler of the statements taking longer than the threshold,
+// it must be called before the storage is used
+func (s *MySQL) SetSlowQueryHandler(threshold time.Duration, handler SlowQueryHandler) {
+	s.slowQueryThreshold = threshold
+	s.slowQueryHandler = handler
+}
+
+// SetArgsRedactor sets the function the arguments of the slow statements are passed through,
+// it must be called before the storage is used
+func (s *MySQL) SetArgsRedactor(redactor ArgsRedactor) {
+	s.argsRedactor = redactor
+}
+
+func (s *MySQL) checkSlowQuery(ctx context.Context, query string, args []interface{}, duration time.Duration, inTransaction bool) {
+	if s.slowQueryHandler == nil || s.slowQueryThreshold <= 0 || duration < s.slowQueryThreshold {
+		return
+	}
+
+	if s.argsRedactor != nil {
+		args = s.argsRedactor(query, append([]interface{}(nil), args...))
+	}
+
+	slowQuery := SlowQuery{
+		SQL:           s.recordStatement(query),
+		Args:          args,
+		Duration:      duration,
+		InTransaction: inTransaction,
+	}
+	if s.interpolateSQL {
+		slowQuery.InterpolatedSQL = s.recordStatement(InterpolateSQL(query, args, s.loc))
+	}
+
+	s.slowQueryHandler(ctx, slowQuery)
+}
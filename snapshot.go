@@ -0,0 +1,89 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/go-qbit/qerror"
+)
+
+// ErrReadOnlySnapshot is matched by the errors of the writes rejected in the consistent snapshot
+var ErrReadOnlySnapshot = errors.New("write in read-only snapshot")
+
+const snapshotBeginQuery = "START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY"
+
+// WithConsistentSnapshot runs f with the context the queries with which observe the same snapshot of the database
+// taken at the start. The queries run in the read-only transaction started with START TRANSACTION WITH CONSISTENT
+// SNAPSHOT on a replica or the primary server, see ForcePrimary, which is always rolled back after f. The writes
+// with the context fail with ErrReadOnlySnapshot without being sent to the server. The snapshot cannot be taken
+// in a started transaction, GetTransaction returns nil for it while Executor runs the statements in it.
+func (s *MySQL) WithConsistentSnapshot(ctx context.Context, f func(ctx context.Context) error) (err error) {
+	if ctx.Value(s.transactionKey()) != nil {
+		return qerror.Errorf("The consistent snapshot cannot be taken in a started transaction")
+	}
+	key, err := s.txStarted(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.txFinished(key)
+
+	db := s.db
+	r := s.readReplica(ctx)
+	if r != nil {
+		db = r.db
+	}
+	conn, err := db.Conn(ctx)
+	if r != nil {
+		r.report(s.now(), err)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	t := s.newTransaction(nil, nil, 0)
+	t.snapshot, t.exec = conn, s.executor(conn)
+
+	if err := s.execSnapshotStatement(ctx, t, "begin", snapshotBeginQuery); err != nil {
+		return err
+	}
+
+	// The snapshot is rolled back if f panics as well
+	defer func() {
+		if rollbackErr := s.execSnapshotStatement(ctx, t, "rollback", "ROLLBACK"); rollbackErr != nil {
+			// The connection in the unknown state is not returned to the pool
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			if err == nil {
+				err = rollbackErr
+			}
+		}
+	}()
+
+	return f(context.WithValue(ctx, s.transactionKey(), t))
+}
+
+// execSnapshotStatement runs the statement starting or finishing the snapshot between the hooks
+func (s *MySQL) execSnapshotStatement(ctx context.Context, t *transaction, op, query string) error {
+	_, err := s.withTxHooks(ctx, op, query, func(ctx context.Context) error {
+		start := s.now()
+		_, err := t.exec.ExecContext(ctx, query)
+		s.txStatementDone(ctx, op, query, 1, start, err)
+		return err
+	})
+	if err != nil {
+		return s.statementError(ctx, TxModel, op, query, nil, err)
+	}
+
+	return nil
+}
+
+// checkSnapshotWrite returns ErrReadOnlySnapshot for the write in the consistent snapshot of the context
+func (s *MySQL) checkSnapshotWrite(ctx context.Context, query string) error {
+	if t, _ := ctx.Value(s.transactionKey()).(*transaction); t != nil && t.snapshot != nil {
+		return fmt.Errorf("%w: %s", ErrReadOnlySnapshot, query)
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// MaxPlaceholders is the limit of the placeholders of a prepared statement in the MySQL protocol
+const MaxPlaceholders = 65535
+
+// ErrTooManyPlaceholders is returned by the statements with more placeholders than the budget,
+// see SetMaxPlaceholders
+var ErrTooManyPlaceholders = errors.New("too many placeholders")
+
+// SetMaxPlaceholders sets the placeholders budget of the statements, MaxPlaceholders by default.
+// Select splits the queries exceeding it by the IN list of the filter, the other statements fail
+// with ErrTooManyPlaceholders. It must be called before the storage is used.
+func (s *MySQL) SetMaxPlaceholders(n int) {
+	s.maxPlaceholders = n
+}
+
+func (s *MySQL) placeholdersBudget() int {
+	if s.maxPlaceholders <= 0 {
+		return MaxPlaceholders
+	}
+
+	return s.maxPlaceholders
+}
+
+// checkPlaceholders fails before the statement is sent if it has too many placeholders
+func (s *MySQL) checkPlaceholders(args []interface{}) error {
+	if budget := s.placeholdersBudget(); len(args) > budget {
+		return fmt.Errorf("%w: the statement has %d placeholders, the limit is %d", ErrTooManyPlaceholders, len(args), budget)
+	}
+
+	return nil
+}
+
+// selectSplit runs the query exceeding the placeholders budget as several queries with the chunks of the IN list
+// of the filter. The filter must be the IN expression or AND with one, the rest of the filter is repeated in each
+// query. The rows are concatenated and sorted by options.OrderBy in Go, the strings are compared by bytes then,
+// so the order may differ from the collation one. LIMIT and OFFSET are applied to the result.
+func (s *MySQL) selectSplit(ctx context.Context, m model.IModel, fieldsNames []string, options SelectOptions, args int) (*model.Data, error) {
+	switch {
+	case options.Distinct, len(options.GroupBy) > 0, options.Having != nil, options.OuterFilter != nil, len(options.OrderBy) > 0:
+		return nil, fmt.Errorf("%w: the query with DISTINCT, GROUP BY, HAVING, OuterFilter or the ordering expressions cannot be split",
+			ErrTooManyPlaceholders)
+	}
+
+	inExpr, build := splittableIn(options.Filter)
+	if inExpr == nil {
+		return nil, fmt.Errorf("%w: the filter has no IN list the query can be split by", ErrTooManyPlaceholders)
+	}
+
+	chunkSize := s.placeholdersBudget() - (args - len(inExpr.values))
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("%w: the filter exceeds the limit without the IN list", ErrTooManyPlaceholders)
+	}
+
+	// The values are deduplicated, so each row matches one chunk
+	values := make([]interface{}, 0, len(inExpr.values))
+	seen := make(map[string]struct{}, len(inExpr.values))
+	for _, value := range inExpr.values {
+		key := fmt.Sprintf("%T:%v", derefValue(value), derefValue(value))
+		if _, exists := seen[key]; !exists {
+			seen[key] = struct{}{}
+			values = append(values, value)
+		}
+	}
+
+	// The ordered fields are requested but not returned unless they are requested, the chunks are ordered
+	// with the tiebreaker of the page
+	orderBy := options.GetAllOptions.OrderBy
+	for _, fieldName := range s.orderTiebreaker(m, options) {
+		orderBy = append(orderBy[:len(orderBy):len(orderBy)], model.Order{FieldName: fieldName})
+	}
+	selected := fieldsNames
+	for _, order := range orderBy {
+		selected = appendMissing(selected[:len(selected):len(selected)], order.FieldName)
+	}
+
+	chunkOptions := options
+	chunkOptions.Offset = 0
+	if options.Limit > 0 {
+		chunkOptions.Limit = options.Limit + options.Offset
+	}
+
+	var (
+		res       *model.Data
+		foundRows uint64
+	)
+	for start := 0; start < len(values); start += chunkSize {
+		end := start + chunkSize
+		if end > len(values) {
+			end = len(values)
+		}
+
+		chunkOptions.Filter = build(values[start:end])
+		var chunkFoundRows uint64
+		if options.RowsWoLimit != nil {
+			chunkOptions.RowsWoLimit = &chunkFoundRows
+		}
+
+		data, err := s.Select(ctx, m, selected, chunkOptions)
+		if err != nil {
+			return nil, err
+		}
+		foundRows += chunkFoundRows
+
+		if res == nil {
+			res = model.NewEmptyData(data.Fields())
+		}
+		for _, row := range data.Data() {
+			if err := res.Add(row); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if options.RowsWoLimit != nil {
+		*options.RowsWoLimit = foundRows
+	}
+
+	rows := res.Data()
+	if len(orderBy) > 0 {
+		if err := sortRows(res, rows, orderBy); err != nil {
+			return nil, err
+		}
+	}
+	if options.Offset > 0 || options.Limit > 0 {
+		rows = limitRows(rows, options.Offset, options.Limit)
+	}
+
+	var (
+		fields    []string
+		positions []int
+	)
+	for i, name := range res.Fields() {
+		if containsString(fieldsNames, name) || !containsString(selected, name) {
+			fields, positions = append(fields, name), append(positions, i)
+		}
+	}
+	limited := model.NewEmptyData(fields)
+	for _, row := range rows {
+		resRow := make([]interface{}, len(positions))
+		for i, pos := range positions {
+			resRow[i] = row[pos]
+		}
+		if err := limited.Add(resRow); err != nil {
+			return nil, err
+		}
+	}
+
+	return limited, nil
+}
+
+// splittableIn returns the IN expression of the filter and the function building the filter with its values replaced
+func splittableIn(filter model.IExpression) (*in, func(values []interface{}) model.IExpression) {
+	switch e := filter.(type) {
+	case *in:
+		if e.not || e.err != nil {
+			return nil, nil
+		}
+		return e, func(values []interface{}) model.IExpression { return In(e.op, values) }
+
+	case *and:
+		pos := -1
+		for i, op := range e.ops {
+			if opIn, ok := op.(*in); ok && !opIn.not && opIn.err == nil && (pos < 0 || len(opIn.values) > len(e.ops[pos].(*in).values)) {
+				pos = i
+			}
+		}
+		if pos < 0 {
+			return nil, nil
+		}
+		inExpr := e.ops[pos].(*in)
+		return inExpr, func(values []interface{}) model.IExpression {
+			ops := append([]model.IExpression(nil), e.ops...)
+			ops[pos] = In(inExpr.op, values)
+			return And(ops...)
+		}
+	}
+
+	return nil, nil
+}
+
+// sortRows sorts the rows stably as ORDER BY does, NULL is less than the other values
+func sortRows(data *model.Data, rows [][]interface{}, orderBy []model.Order) error {
+	positions := make([]int, len(orderBy))
+	for i, order := range orderBy {
+		positions[i] = data.FieldNum(order.FieldName)
+	}
+
+	var err error
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, order := range orderBy {
+			a, b := derefValue(rows[i][positions[k]]), derefValue(rows[j][positions[k]])
+			var res int
+			switch {
+			case a == nil && b == nil:
+				continue
+			case a == nil:
+				res = -1
+			case b == nil:
+				res = 1
+			default:
+				var ok bool
+				if res, ok = compareValues(a, b); !ok {
+					err = qerror.Errorf("Cannot sort the rows by the field '%s' of the type %T", order.FieldName, a)
+					return false
+				}
+			}
+			if res != 0 {
+				return res < 0 != order.Desc
+			}
+		}
+		return false
+	})
+
+	return err
+}
+
+func limitRows(rows [][]interface{}, offset, limit uint64) [][]interface{} {
+	if offset >= uint64(len(rows)) {
+		return nil
+	}
+	rows = rows[offset:]
+	if limit > 0 && limit < uint64(len(rows)) {
+		rows = rows[:limit]
+	}
+
+	return rows
+}
@@ -2,12 +2,20 @@ package mysql
 
 import (
 	"bytes"
+	"context"
 	"strings"
 )
 
 type SqlBuffer struct {
 	*bytes.Buffer
 	args []interface{}
+	err  error
+	// windowFunctions is set if the statement has window functions, they require MySQL 8.0
+	windowFunctions bool
+	// scopes are the scope filters of the models, see ScopedSqlBuffer
+	scopes *scopeSet
+	// shards routes the statement to the shard tables of the sharded models, see ScopedSqlBuffer
+	shards *shardRouting
 }
 
 func NewSqlBuffer() *SqlBuffer {
@@ -16,9 +24,20 @@ func NewSqlBuffer() *SqlBuffer {
 	}
 }
 
+// context returns the context of the statement of ScopedSqlBuffer, the background one for the other buffers
+func (b *SqlBuffer) context() context.Context {
+	if b.scopes == nil {
+		return context.Background()
+	}
+
+	return b.scopes.ctx
+}
+
 func (b *SqlBuffer) Reset() {
 	b.Buffer.Reset()
 	b.args = b.args[:0]
+	b.err = nil
+	b.windowFunctions = false
 }
 
 func (b *SqlBuffer) GetSQL() string {
@@ -29,6 +48,17 @@ func (b *SqlBuffer) GetArgs() []interface{} {
 	return b.args
 }
 
+// SetError stores the first error occurred while the SQL was written, the statement must not be executed then
+func (b *SqlBuffer) SetError(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *SqlBuffer) Err() error {
+	return b.err
+}
+
 func (b *SqlBuffer) String() string {
 	buf := &bytes.Buffer{}
 	argPos := 0
@@ -45,7 +75,11 @@ func (b *SqlBuffer) String() string {
 		buf.WriteString(query[i : i+q])
 		i += q
 
-		buf.WriteString(Quote(args[argPos]))
+		literal, err := FormatLiteral(args[argPos], LiteralOpts{})
+		if err != nil {
+			literal = "?"
+		}
+		buf.WriteString(literal)
 		argPos++
 	}
 
@@ -53,7 +87,7 @@ func (b *SqlBuffer) String() string {
 }
 
 func (b *SqlBuffer) WriteIdentifier(identifier string) {
-	b.WriteString(QuoteIdentifier(identifier))
+	b.WriteString(QuoteIdent(identifier))
 }
 
 func (b *SqlBuffer) WriteValue(value interface{}) {
@@ -0,0 +1,165 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+var (
+	// ErrStreamInserterFull is returned by StreamInserter.Add dropping the row if the buffer is full
+	ErrStreamInserterFull = errors.New("the stream inserter buffer is full")
+	// ErrStreamInserterClosed is returned by StreamInserter.Add after Close
+	ErrStreamInserterClosed = errors.New("the stream inserter is closed")
+)
+
+type StreamInserterOpts struct {
+	// BatchSize is the maximum number of the rows in one INSERT, 1000 by default
+	BatchSize int
+	// FlushInterval is the maximum time a row waits for the INSERT, 1 second by default
+	FlushInterval time.Duration
+	// BufferSize is the maximum number of the buffered rows, 10 batches by default
+	BufferSize int
+	// DropWhenFull makes Add to drop the row if the buffer is full, otherwise it blocks
+	DropWhenFull bool
+	// OnError receives the errors of the INSERTs with the rows, otherwise the first error is returned by Close
+	OnError    func(err error, rows [][]interface{})
+	AddOptions model.AddOptions
+}
+
+// StreamInserter inserts the rows added one by one with the multi-row INSERTs on a background goroutine
+type StreamInserter struct {
+	s           *MySQL
+	ctx         context.Context
+	m           model.IModel
+	fieldsNames []string
+	opts        StreamInserterOpts
+
+	rows     chan []interface{}
+	done     chan struct{}
+	closeMtx sync.RWMutex
+	closed   bool
+	err      error
+}
+
+// NewStreamInserter starts the inserter of the rows with the fields values, the context is used by the INSERTs
+// and must not have a transaction
+func (s *MySQL) NewStreamInserter(ctx context.Context, m model.IModel, fieldsNames []string, opts StreamInserterOpts) *StreamInserter {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10 * opts.BatchSize
+	}
+
+	i := &StreamInserter{
+		s:           s,
+		ctx:         ctx,
+		m:           m,
+		fieldsNames: fieldsNames,
+		opts:        opts,
+		rows:        make(chan []interface{}, opts.BufferSize),
+		done:        make(chan struct{}),
+	}
+
+	s.addStreamInserter(i)
+	go i.run()
+
+	return i
+}
+
+// Add buffers the row, it blocks or returns ErrStreamInserterFull if the buffer is full
+func (i *StreamInserter) Add(row []interface{}) error {
+	if len(row) != len(i.fieldsNames) {
+		return qerror.Errorf("Invalid row size %d, must be %d", len(row), len(i.fieldsNames))
+	}
+
+	i.closeMtx.RLock()
+	defer i.closeMtx.RUnlock()
+
+	if i.closed {
+		return ErrStreamInserterClosed
+	}
+
+	if !i.opts.DropWhenFull {
+		i.rows <- row
+		return nil
+	}
+
+	select {
+	case i.rows <- row:
+		return nil
+	default:
+		return ErrStreamInserterFull
+	}
+}
+
+// Close inserts the buffered rows and waits for the INSERTs. The result is the first error of the INSERTs
+// unless OnError is set.
+func (i *StreamInserter) Close() error {
+	i.closeMtx.Lock()
+	if !i.closed {
+		i.closed = true
+		close(i.rows)
+	}
+	i.closeMtx.Unlock()
+
+	<-i.done
+	i.s.removeStreamInserter(i)
+
+	return i.err
+}
+
+func (i *StreamInserter) run() {
+	defer close(i.done)
+
+	ticks, stopTicker := i.s.newTicker(i.opts.FlushInterval)
+	defer stopTicker()
+
+	batch := make([][]interface{}, 0, i.opts.BatchSize)
+	for {
+		select {
+		case row, ok := <-i.rows:
+			if !ok {
+				i.flush(batch)
+				return
+			}
+
+			batch = append(batch, row)
+			if len(batch) >= i.opts.BatchSize {
+				i.flush(batch)
+				batch = make([][]interface{}, 0, i.opts.BatchSize)
+			}
+
+		case <-ticks:
+			if len(batch) > 0 {
+				i.flush(batch)
+				batch = make([][]interface{}, 0, i.opts.BatchSize)
+			}
+		}
+	}
+}
+
+func (i *StreamInserter) flush(batch [][]interface{}) {
+	if len(batch) == 0 {
+		return
+	}
+
+	_, err := i.s.Add(flushContext(i.ctx), i.m, model.NewData(i.fieldsNames, batch), i.opts.AddOptions)
+	if err == nil {
+		return
+	}
+
+	if i.opts.OnError != nil {
+		i.opts.OnError(err, batch)
+	} else if i.err == nil {
+		i.err = err
+	}
+}
@@ -0,0 +1,423 @@
+package mysql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+type StructsOpts struct {
+	OrderBy []model.Order
+	Limit   uint64
+	Offset  uint64
+}
+
+// structsMappings caches the struct fields of the model fields by structsKey
+var structsMappings sync.Map
+
+type structsKey struct {
+	modelId string
+	t       reflect.Type
+}
+
+// structField is the struct field the model field is assigned to, tagged is true for the fields with
+// the mysql tag
+type structField struct {
+	index  []int
+	t      reflect.Type
+	name   string
+	tagged bool
+}
+
+// GetAllToStructs reads the rows into dest which must be a pointer to a slice of structs or of pointers
+// to structs. The model fields are assigned to the struct fields with the `mysql:"field_id"` tag or
+// the snake-case name of which is the field id, `mysql:"-"` skips the struct field. The pointer struct fields
+// are nil for NULL. The derivable fields and the virtual fields of the context are computed, see WithVirtualFields.
+//
+// The scanned values are converted to the struct fields types: the numbers to the numbers of the other sizes
+// if they fit, DECIMAL values to the floats, DATE, DATETIME and TIMESTAMP values to time.Time, SET values
+// to []string and JSON values are unmarshalled into the non-string fields.
+//
+// All fields of the model mapped to the struct are read if fieldsNames is empty.
+func (s *MySQL) GetAllToStructs(ctx context.Context, m model.IModel, dest interface{}, fieldsNames []string, filter model.IExpression, opts StructsOpts) error {
+	destValue := reflect.ValueOf(dest)
+	if destValue.Kind() != reflect.Ptr || destValue.IsNil() || destValue.Elem().Kind() != reflect.Slice {
+		return qerror.Errorf("The destination must be a pointer to a slice of structs, not %T", dest)
+	}
+	slice := destValue.Elem()
+
+	elemType, isPtr := slice.Type().Elem(), false
+	if elemType.Kind() == reflect.Ptr {
+		elemType, isPtr = elemType.Elem(), true
+	}
+	if elemType.Kind() != reflect.Struct {
+		return qerror.Errorf("The destination must be a pointer to a slice of structs, not %T", dest)
+	}
+
+	virtual, err := virtualFieldsOf(ctx, m)
+	if err != nil {
+		return err
+	}
+	lookup := fieldLookup(m, virtual)
+
+	mapping, err := structMapping(m, lookup, len(virtual) > 0, elemType)
+	if err != nil {
+		return err
+	}
+
+	if len(fieldsNames) == 0 {
+		for _, name := range append(m.GetFieldsNames(), virtualFieldsNames(virtual)...) {
+			if _, exists := mapping[name]; exists {
+				fieldsNames = append(fieldsNames, name)
+			}
+		}
+	}
+
+	assigners := make([]func(reflect.Value, interface{}) error, len(fieldsNames))
+	index := make([][]int, len(fieldsNames))
+	for i, name := range fieldsNames {
+		sf, exists := mapping[name]
+		if !exists {
+			if lookup(name) == nil {
+				return qerror.Errorf("Unknown field '%s' in model '%s'", name, m.GetId())
+			}
+			return qerror.Errorf("The field '%s' in model '%s' has no field in struct %s", name, m.GetId(), elemType)
+		}
+		index[i] = sf.index
+		if assigners[i], err = s.structAssigner(m, lookup(name), elemType, sf); err != nil {
+			return err
+		}
+	}
+
+	slice.Set(slice.Slice(0, 0))
+
+	return s.streamRows(ctx, m, fieldsNames, model.GetAllOptions{
+		Filter:  filter,
+		OrderBy: opts.OrderBy,
+		Limit:   opts.Limit,
+		Offset:  opts.Offset,
+	}, true, func(values []interface{}) error {
+		elem := reflect.New(elemType)
+		for i, value := range values {
+			if err := assigners[i](elem.Elem().FieldByIndex(index[i]), value); err != nil {
+				return err
+			}
+		}
+
+		if isPtr {
+			slice.Set(reflect.Append(slice, elem))
+		} else {
+			slice.Set(reflect.Append(slice, elem.Elem()))
+		}
+
+		return nil
+	})
+}
+
+// structMapping returns the struct fields of the model fields returned by lookup, the result is cached unless
+// lookup returns the virtual fields
+func structMapping(m model.IModel, lookup func(name string) model.IFieldDefinition, virtual bool, t reflect.Type) (map[string]structField, error) {
+	key := structsKey{m.GetId(), t}
+	if !virtual {
+		if mapping, ok := structsMappings.Load(key); ok {
+			return mapping.(map[string]structField), nil
+		}
+	}
+
+	mapping := make(map[string]structField)
+	if err := addStructFields(m, lookup, t, nil, mapping); err != nil {
+		return nil, err
+	}
+	if !virtual {
+		structsMappings.Store(key, mapping)
+	}
+
+	return mapping, nil
+}
+
+// addStructFields adds the exported fields of the struct and of the embedded structs, the fields of the outer
+// struct and the tagged fields hide the others
+func addStructFields(m model.IModel, lookup func(name string) model.IFieldDefinition, t reflect.Type, parentIndex []int, mapping map[string]structField) error {
+	var embedded []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, tagged := f.Tag.Lookup("mysql")
+		if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		index := append(append([]int(nil), parentIndex...), i)
+		if f.Anonymous && !tagged && f.Type.Kind() == reflect.Struct {
+			f.Index = index
+			embedded = append(embedded, f)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := tag
+		if !tagged {
+			name = snakeCase(f.Name)
+		}
+		if lookup(name) == nil {
+			if tagged {
+				return qerror.Errorf("The field %s.%s is tagged with the unknown field '%s' of model '%s'", t, f.Name, name, m.GetId())
+			}
+			continue
+		}
+
+		if prev, exists := mapping[name]; exists && (len(prev.index) < len(index) || prev.tagged && !tagged) {
+			continue
+		} else if exists && len(prev.index) == len(index) && prev.tagged == tagged {
+			return qerror.Errorf("The fields %s.%s and %s.%s are both mapped to the field '%s' of model '%s'", t, prev.name, t, f.Name, name, m.GetId())
+		}
+		mapping[name] = structField{index: index, t: f.Type, name: f.Name, tagged: tagged}
+	}
+
+	for _, f := range embedded {
+		if err := addStructFields(m, lookup, f.Type, f.Index, mapping); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// snakeCase returns the snake-case name of the Go name, e.g. user_id for UserID
+func snakeCase(name string) string {
+	runes := []rune(name)
+
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1]) ||
+				i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsLower(runes[i+1])) {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// structAssigner returns the function converting the dereferenced value of the field to the struct field type
+func (s *MySQL) structAssigner(m model.IModel, field model.IFieldDefinition, structType reflect.Type, sf structField) (func(reflect.Value, interface{}) error, error) {
+	t := sf.t
+	isPtr := t.Kind() == reflect.Ptr
+	if isPtr {
+		t = t.Elem()
+	}
+
+	fail := func(value interface{}, reason string) error {
+		return qerror.Errorf("The value %v of the field '%s' in model '%s' cannot be assigned to %s.%s of type %s: %s",
+			value, field.GetId(), m.GetId(), structType, sf.name, sf.t, reason)
+	}
+
+	var convert func(interface{}) (reflect.Value, error)
+	switch scan := s.scanConverter(field, t); {
+	case scan != nil:
+		convert = func(value interface{}) (reflect.Value, error) {
+			res, err := scan(value)
+			if err != nil {
+				return reflect.Value{}, fail(value, err.Error())
+			}
+			rv := reflect.ValueOf(res)
+			if !rv.IsValid() || !rv.Type().AssignableTo(t) {
+				return reflect.Value{}, fail(value, fmt.Sprintf("the scan converter returned %T", res))
+			}
+			return rv, nil
+		}
+	case t.Kind() == reflect.Interface:
+		convert = func(value interface{}) (reflect.Value, error) { return reflect.ValueOf(value), nil }
+	case t == timeType:
+		convert = func(value interface{}) (reflect.Value, error) {
+			str, ok := value.(string)
+			if !ok {
+				return reflect.Value{}, fail(value, "not a date")
+			}
+
+			var (
+				res time.Time
+				err error
+			)
+			switch field.(type) {
+			case *DateField:
+				if str != "0000-00-00" {
+					res, err = time.Parse("2006-01-02", str)
+				}
+			case *DateTimeField, *TimeStampField:
+				res, err = s.ParseTime(field, str)
+			default:
+				return reflect.Value{}, fail(value, "the field is not DATE, DATETIME or TIMESTAMP")
+			}
+			if err != nil {
+				return reflect.Value{}, fail(value, err.Error())
+			}
+
+			return reflect.ValueOf(res), nil
+		}
+	default:
+		_, isJSON := field.(*JSONField)
+		_, isSet := field.(*SetField)
+		convert = func(value interface{}) (reflect.Value, error) {
+			rv := reflect.ValueOf(value)
+			switch {
+			case rv.Type().AssignableTo(t):
+				return rv, nil
+			case isJSON && t.Kind() != reflect.String && !(t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8):
+				res := reflect.New(t)
+				if err := json.Unmarshal([]byte(rv.String()), res.Interface()); err != nil {
+					return reflect.Value{}, fail(value, err.Error())
+				}
+				return res.Elem(), nil
+			case isSet && t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+				res := reflect.MakeSlice(t, 0, 0)
+				if str := rv.String(); str != "" {
+					for _, item := range strings.Split(str, ",") {
+						res = reflect.Append(res, reflect.ValueOf(item).Convert(t.Elem()))
+					}
+				}
+				return res, nil
+			}
+
+			return convertStructValue(rv, t, func(reason string) error { return fail(value, reason) })
+		}
+	}
+
+	return func(target reflect.Value, value interface{}) error {
+		if value == nil {
+			if !isPtr && t.Kind() != reflect.Interface {
+				return fail(value, "the struct field must be a pointer for NULL")
+			}
+			target.Set(reflect.Zero(sf.t))
+			return nil
+		}
+
+		converted, err := convert(value)
+		if err != nil {
+			return err
+		}
+
+		if isPtr {
+			ptr := reflect.New(t)
+			ptr.Elem().Set(converted)
+			converted = ptr
+		}
+		target.Set(converted)
+
+		return nil
+	}, nil
+}
+
+// convertStructValue converts the scalar value to the kind of t checking the numbers fit
+func convertStructValue(rv reflect.Value, t reflect.Type, fail func(reason string) error) (reflect.Value, error) {
+	res := reflect.New(t).Elem()
+
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := rv.Int()
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if res.OverflowInt(i) {
+				return res, fail("overflow")
+			}
+			res.SetInt(i)
+			return res, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if i < 0 || res.OverflowUint(uint64(i)) {
+				return res, fail("overflow")
+			}
+			res.SetUint(uint64(i))
+			return res, nil
+		case reflect.Float32, reflect.Float64:
+			res.SetFloat(float64(i))
+			return res, nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u := rv.Uint()
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if u > 1<<63-1 || res.OverflowInt(int64(u)) {
+				return res, fail("overflow")
+			}
+			res.SetInt(int64(u))
+			return res, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if res.OverflowUint(u) {
+				return res, fail("overflow")
+			}
+			res.SetUint(u)
+			return res, nil
+		case reflect.Float32, reflect.Float64:
+			res.SetFloat(float64(u))
+			return res, nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64 {
+			if res.OverflowFloat(rv.Float()) {
+				return res, fail("overflow")
+			}
+			res.SetFloat(rv.Float())
+			return res, nil
+		}
+	case reflect.String:
+		switch t.Kind() {
+		case reflect.String:
+			res.SetString(rv.String())
+			return res, nil
+		case reflect.Slice:
+			if t.Elem().Kind() == reflect.Uint8 {
+				res.SetBytes([]byte(rv.String()))
+				return res, nil
+			}
+		case reflect.Float32, reflect.Float64:
+			f, err := strconv.ParseFloat(rv.String(), 64)
+			if err != nil {
+				return res, fail(err.Error())
+			}
+			if res.OverflowFloat(f) {
+				return res, fail("overflow")
+			}
+			res.SetFloat(f)
+			return res, nil
+		}
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			switch {
+			case t.Kind() == reflect.String:
+				res.SetString(string(rv.Bytes()))
+				return res, nil
+			case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+				res.SetBytes(append([]byte(nil), rv.Bytes()...))
+				return res, nil
+			}
+		}
+	case reflect.Bool:
+		if t.Kind() == reflect.Bool {
+			res.SetBool(rv.Bool())
+			return res, nil
+		}
+	}
+
+	if rv.Type().ConvertibleTo(t) && rv.Kind() == t.Kind() {
+		return rv.Convert(t), nil
+	}
+
+	return res, fail("incompatible types")
+}
+
+func (m *BaseModel) GetAllToStructs(ctx context.Context, dest interface{}, fieldsNames []string, filter model.IExpression, opts StructsOpts) error {
+	return m.db.GetAllToStructs(ctx, m, dest, fieldsNames, filter, opts)
+}
@@ -0,0 +1,93 @@
+package mysql
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const ctx_query_tags_key = "MYSQL_QUERY_TAGS"
+
+type queryTags struct {
+	tags    map[string]string
+	comment string
+}
+
+// SetQueryTags sets the tags of every statement, e.g. the service name. The tags are sent to the server
+// as the leading comment of the statement in the sqlcommenter format: /*key='value',...*/ with the URL encoded
+// keys and values sorted by the keys. The logged statements and the keys of the results cache do not include
+// the comment. It must be called before the storage is used.
+func (s *MySQL) SetQueryTags(tags map[string]string) {
+	s.queryTags = queryTags{tags: tags, comment: queryTagsComment(tags)}
+}
+
+// WithQueryTags returns the context the statements of which are tagged with the tags in addition to the storage
+// and the outer context ones, the tags override the ones with the same keys
+func (s *MySQL) WithQueryTags(ctx context.Context, tags map[string]string) context.Context {
+	outer, ok := ctx.Value(ctx_query_tags_key).(queryTags)
+	if !ok {
+		outer = s.queryTags
+	}
+
+	merged := make(map[string]string, len(outer.tags)+len(tags))
+	for key, value := range outer.tags {
+		merged[key] = value
+	}
+	for key, value := range tags {
+		merged[key] = value
+	}
+
+	return context.WithValue(ctx, ctx_query_tags_key, queryTags{tags: merged, comment: queryTagsComment(merged)})
+}
+
+// WithoutQueryTags returns the context the statements of which are not tagged
+func WithoutQueryTags(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctx_query_tags_key, queryTags{})
+}
+
+// tagQuery prepends the comment with the tags of the context to the statement
+func (s *MySQL) tagQuery(ctx context.Context, query string) string {
+	tags, ok := ctx.Value(ctx_query_tags_key).(queryTags)
+	if !ok {
+		tags = s.queryTags
+	}
+	if tags.comment == "" {
+		return query
+	}
+
+	return tags.comment + query
+}
+
+func queryTagsComment(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buf := &strings.Builder{}
+	buf.WriteString("/*")
+	for i, key := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(encodeTag(key))
+		buf.WriteString("='")
+		buf.WriteString(encodeTag(tags[key]))
+		buf.WriteByte('\'')
+	}
+	buf.WriteString("*/ ")
+
+	return buf.String()
+}
+
+// encodeTag URL encodes the key or the value as sqlcommenter does, the encoded string contains
+// neither quotes nor the comment terminator
+func encodeTag(s string) string {
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
@@ -0,0 +1,54 @@
+package test
+
+import (
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/storage-mysql"
+)
+
+type Category struct {
+	*mysql.BaseModel
+}
+
+func NewCategory(storage *mysql.MySQL) *Category {
+	return &Category{
+		BaseModel: mysql.NewBaseModel(
+			storage,
+			"category",
+			[]mysql.IMysqlFieldDefinition{
+				&mysql.UintField{
+					Id:            "id",
+					Caption:       "ID",
+					NotNull:       true,
+					AutoIncrement: true,
+				},
+
+				&mysql.UintField{
+					Id:      "parent_id",
+					Caption: "Parent ID",
+				},
+
+				&mysql.VarCharField{
+					Id:      "name",
+					Caption: "Name",
+					Length:  64,
+					NotNull: true,
+				},
+
+				&mysql.IntField{
+					Id:      "position",
+					Caption: "Position",
+					NotNull: true,
+				},
+			},
+			nil,
+			mysql.BaseModelOpts{
+				BaseModelOpts: model.BaseModelOpts{
+					PkFieldsNames: []string{"id"},
+				},
+				Indexes: []mysql.Index{
+					{[]string{"parent_id", "position"}, false},
+				},
+			},
+		),
+	}
+}
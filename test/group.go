@@ -0,0 +1,43 @@
+package test
+
+import (
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/storage-mysql"
+)
+
+type Group struct {
+	*mysql.BaseModel
+}
+
+func NewGroup(storage *mysql.MySQL) *Group {
+	return &Group{
+		BaseModel: mysql.NewBaseModel(
+			storage,
+			"group",
+			[]mysql.IMysqlFieldDefinition{
+				&mysql.UintField{
+					Id:            "id",
+					Caption:       "ID",
+					NotNull:       true,
+					AutoIncrement: true,
+				},
+
+				&mysql.VarCharField{
+					Id:      "name",
+					Caption: "Name",
+					Length:  64,
+					NotNull: true,
+				},
+			},
+			nil,
+			mysql.BaseModelOpts{
+				BaseModelOpts: model.BaseModelOpts{
+					PkFieldsNames: []string{"id"},
+				},
+				Indexes: []mysql.Index{
+					{[]string{"name"}, true},
+				},
+			},
+		),
+	}
+}
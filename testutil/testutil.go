@@ -0,0 +1,202 @@
+// Package testutil contains the helpers of the tests working with the storage
+package testutil
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	mysql "github.com/go-qbit/storage-mysql"
+)
+
+// TB is the part of testing.TB used by the helpers
+type TB interface {
+	Helper()
+	Cleanup(func())
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+type ctxKey struct{}
+
+// rollbackTx is the transaction of WithRollback
+type rollbackTx struct {
+	t TB
+}
+
+// WithRollback runs the body in the transaction which is rolled back when the test ends, so the body sees its writes
+// while the other tests do not. DoInTransaction called by the body uses the savepoints of the transaction.
+// The test fails if the body finishes the transaction itself, see CommitGuard.
+func WithRollback(t TB, storage *mysql.MySQL, body func(ctx context.Context)) {
+	t.Helper()
+
+	ctx, err := storage.StartTransaction(context.Background())
+	if err != nil {
+		t.Fatalf("Cannot start the transaction: %v", err)
+		return
+	}
+	ctx = context.WithValue(ctx, ctxKey{}, &rollbackTx{t: t})
+
+	t.Cleanup(func() {
+		// The savepoints left by the body are rolled back one by one
+		for storage.InTransaction(ctx) {
+			var err error
+			if ctx, err = storage.Rollback(ctx); err != nil {
+				if errors.Is(err, mysql.ErrTxAlreadyFinished) {
+					t.Errorf("The transaction is committed or rolled back by the test body")
+				} else {
+					t.Errorf("Cannot roll back the transaction: %v", err)
+				}
+				return
+			}
+		}
+	})
+
+	body(ctx)
+}
+
+// CommitGuard is the query hook which vetoes the commit of the transaction of WithRollback and fails the test,
+// so the writes of the test body are never committed. It is added once with MySQL.AddQueryHook.
+type CommitGuard struct{}
+
+func (CommitGuard) BeforeQuery(ctx context.Context, info *mysql.QueryHookInfo) (context.Context, error) {
+	if info.Model != mysql.TxModel || info.Op != "commit" {
+		return ctx, nil
+	}
+
+	rollbackTx, _ := ctx.Value(ctxKey{}).(*rollbackTx)
+	if rollbackTx == nil {
+		return ctx, nil
+	}
+
+	rollbackTx.t.Errorf("The test body commits the transaction of WithRollback")
+
+	return ctx, errors.New("the transaction of WithRollback cannot be committed")
+}
+
+func (CommitGuard) AfterQuery(context.Context, *mysql.QueryHookInfo, driver.Result, error) {}
+
+// FakeClock is mysql.Clock the time of which is moved by the test only, the timers are fired by Set and Advance.
+// It is set with MySQL.SetClock and LRUCache.SetClock.
+type FakeClock struct {
+	mtx    sync.Mutex
+	now    time.Time
+	seq    int
+	timers map[int]fakeTimer
+}
+
+var _ mysql.Clock = &FakeClock{}
+
+type fakeTimer struct {
+	at  time.Time
+	seq int
+	f   func()
+}
+
+// NewFakeClock returns the clock frozen at now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, timers: make(map[int]fakeTimer)}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.seq++
+	seq := c.seq
+	c.timers[seq] = fakeTimer{at: c.now.Add(d), seq: seq, f: f}
+
+	return func() bool {
+		c.mtx.Lock()
+		defer c.mtx.Unlock()
+
+		_, pending := c.timers[seq]
+		delete(c.timers, seq)
+
+		return pending
+	}
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.AfterFunc(d, func() { ch <- c.Now() })
+
+	return ch
+}
+
+// NewTicker returns the ticker the next tick of which is scheduled when the tick is fired, so one tick is fired
+// by each Set and Advance
+func (c *FakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	ch := make(chan time.Time, 1)
+
+	var (
+		mtx     sync.Mutex
+		stopped bool
+		stop    func() bool
+		tick    func()
+	)
+	tick = func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if stopped {
+			return
+		}
+
+		select {
+		case ch <- c.Now():
+		default:
+		}
+		stop = c.AfterFunc(d, tick)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	stop = c.AfterFunc(d, tick)
+
+	return ch, func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		stopped = true
+		stop()
+	}
+}
+
+// Advance moves the time forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set sets the time, the timers due by it are fired in their order in the goroutines of their own
+func (c *FakeClock) Set(now time.Time) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.now = now
+
+	var due []fakeTimer
+	for seq, timer := range c.timers {
+		if !timer.at.After(now) {
+			due = append(due, timer)
+			delete(c.timers, seq)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].at.Equal(due[j].at) {
+			return due[i].at.Before(due[j].at)
+		}
+		return due[i].seq < due[j].seq
+	})
+	for _, timer := range due {
+		go timer.f()
+	}
+}
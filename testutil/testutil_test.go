@@ -0,0 +1,184 @@
+package testutil_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	mysql "github.com/go-qbit/storage-mysql"
+	"github.com/go-qbit/storage-mysql/testutil"
+)
+
+// fakeDriver records the statements, the transaction statements included
+type fakeDriver struct{}
+
+type fakeConn struct{}
+
+type fakeStmt struct{ query string }
+
+type fakeTx struct{}
+
+type fakeRows struct{}
+
+var (
+	fakeMtx     sync.Mutex
+	fakeQueries []string
+)
+
+func init() {
+	sql.Register("testutil_fake", fakeDriver{})
+}
+
+func fakeRecord(query string) {
+	fakeMtx.Lock()
+	defer fakeMtx.Unlock()
+	fakeQueries = append(fakeQueries, query)
+}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+func (fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{query}, nil }
+func (fakeConn) Close() error                              { return nil }
+func (fakeConn) Begin() (driver.Tx, error) {
+	fakeRecord("BEGIN")
+	return fakeTx{}, nil
+}
+
+func (fakeTx) Commit() error {
+	fakeRecord("COMMIT")
+	return nil
+}
+func (fakeTx) Rollback() error {
+	fakeRecord("ROLLBACK")
+	return nil
+}
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	fakeRecord(s.query)
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	fakeRecord(s.query)
+	return fakeRows{}, nil
+}
+
+func (fakeRows) Columns() []string         { return nil }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+func newFakeStorage(t *testing.T) *mysql.MySQL {
+	driverName := mysql.SqlDriver
+	mysql.SqlDriver = "testutil_fake"
+	defer func() { mysql.SqlDriver = driverName }()
+
+	storage := mysql.NewMySQL(mysql.PoolConfig{})
+	if !assert.NoError(t, storage.Connect("")) {
+		t.FailNow()
+	}
+
+	fakeMtx.Lock()
+	fakeQueries = nil
+	fakeMtx.Unlock()
+
+	return storage
+}
+
+func queries() []string {
+	fakeMtx.Lock()
+	defer fakeMtx.Unlock()
+
+	return append([]string(nil), fakeQueries...)
+}
+
+// recordingTB records the failures and runs the cleanups on finish
+type recordingTB struct {
+	errors   []string
+	cleanups []func()
+}
+
+func (tb *recordingTB) Helper()          {}
+func (tb *recordingTB) Cleanup(f func()) { tb.cleanups = append(tb.cleanups, f) }
+func (tb *recordingTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+func (tb *recordingTB) Fatalf(format string, args ...interface{}) { tb.Errorf(format, args...) }
+
+func (tb *recordingTB) finish() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
+
+func TestWithRollback(t *testing.T) {
+	storage := newFakeStorage(t)
+	// The savepoints are named without the transaction id
+	storage.SetPlainSavepointNames(true)
+	tb := &recordingTB{}
+
+	testutil.WithRollback(tb, storage, func(ctx context.Context) {
+		assert.True(t, storage.InTransaction(ctx))
+
+		_, err := storage.Exec(ctx, "DELETE FROM `user`")
+		assert.NoError(t, err)
+
+		assert.NoError(t, storage.DoInTransaction(ctx, func(ctx context.Context) error {
+			_, err := storage.Exec(ctx, "DELETE FROM `phone`")
+			return err
+		}))
+
+		// The savepoint left open is rolled back with the transaction
+		_, err = storage.StartTransaction(ctx)
+		assert.NoError(t, err)
+	})
+	assert.Equal(t, []string{"BEGIN", "DELETE FROM `user`", "SAVEPOINT SP1", "DELETE FROM `phone`", "RELEASE SAVEPOINT SP1", "SAVEPOINT SP1"}, queries())
+
+	tb.finish()
+	assert.Empty(t, tb.errors)
+	assert.Equal(t, []string{"ROLLBACK TO SAVEPOINT SP1", "ROLLBACK"}, queries()[6:])
+}
+
+func TestWithRollback_Commit(t *testing.T) {
+	storage := newFakeStorage(t)
+	tb := &recordingTB{}
+
+	testutil.WithRollback(tb, storage, func(ctx context.Context) {
+		_, err := storage.Commit(ctx)
+		assert.NoError(t, err)
+	})
+	tb.finish()
+
+	assert.Equal(t, []string{"BEGIN", "COMMIT"}, queries())
+	assert.Equal(t, []string{"The transaction is committed or rolled back by the test body"}, tb.errors)
+}
+
+func TestCommitGuard(t *testing.T) {
+	storage := newFakeStorage(t)
+	// The savepoints are named without the transaction id
+	storage.SetPlainSavepointNames(true)
+	storage.AddQueryHook(testutil.CommitGuard{})
+	tb := &recordingTB{}
+
+	testutil.WithRollback(tb, storage, func(ctx context.Context) {
+		// The savepoints are released
+		assert.NoError(t, storage.DoInTransaction(ctx, func(context.Context) error { return nil }))
+
+		_, err := storage.Commit(ctx)
+		assert.Error(t, err)
+	})
+	tb.finish()
+
+	assert.Equal(t, []string{"BEGIN", "SAVEPOINT SP1", "RELEASE SAVEPOINT SP1", "ROLLBACK"}, queries())
+	assert.Equal(t, []string{"The test body commits the transaction of WithRollback"}, tb.errors)
+
+	// The transactions outside of WithRollback are committed
+	assert.NoError(t, storage.DoInTransaction(context.Background(), func(context.Context) error { return nil }))
+	assert.Equal(t, []string{"BEGIN", "COMMIT"}, queries()[4:])
+}
@@ -0,0 +1,146 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+const ctx_max_execution_time_key = "MYSQL_MAX_EXECUTION_TIME"
+
+// ErrMaxExecutionTime is returned by the statement killed after the maximum execution time
+var ErrMaxExecutionTime = errors.New("maximum statement execution time exceeded")
+
+// SetMaxExecutionTime sets the maximum execution time of every statement including the InitDB ones, 0 disables it,
+// it must be called before the storage is used
+func (s *MySQL) SetMaxExecutionTime(maxExecutionTime time.Duration) {
+	s.maxExecutionTime = maxExecutionTime
+}
+
+// SetKillGracePeriod sets the time after KILL QUERY the statement is abandoned on the client side, it is a second
+// by default. It must be called before the storage is used.
+func (s *MySQL) SetKillGracePeriod(period time.Duration) {
+	s.killGracePeriod = period
+}
+
+// WithMaxExecutionTime overrides the maximum execution time of the statements run with the context.
+// The SELECTs get the MAX_EXECUTION_TIME optimizer hint (MySQL 5.7.8+), the other statements are killed
+// with KILL QUERY when the time is out.
+func WithMaxExecutionTime(ctx context.Context, maxExecutionTime time.Duration) context.Context {
+	return context.WithValue(ctx, ctx_max_execution_time_key, maxExecutionTime)
+}
+
+// IsMaxExecutionTimeExceeded reports whether the statement is interrupted by the MAX_EXECUTION_TIME hint
+// or killed after the maximum execution time
+func IsMaxExecutionTimeExceeded(err error) bool {
+	if errors.Is(err, ErrMaxExecutionTime) {
+		return true
+	}
+
+	var mysqlErr *mysqldriver.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 3024 // ER_QUERY_TIMEOUT
+}
+
+func (s *MySQL) maxExecutionTimeOf(ctx context.Context) time.Duration {
+	if maxExecutionTime, ok := ctx.Value(ctx_max_execution_time_key).(time.Duration); ok {
+		return maxExecutionTime
+	}
+
+	return s.maxExecutionTime
+}
+
+func writeMaxExecutionTimeHint(sqlBuf *SqlBuffer, maxExecutionTime time.Duration) {
+	ms := maxExecutionTime.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+
+	sqlBuf.WriteString("/*+ MAX_EXECUTION_TIME(")
+	sqlBuf.WriteLiteral(ms, LiteralOpts{})
+	sqlBuf.WriteString(") */ ")
+}
+
+// withMaxExecutionTimeHint adds the hint to the raw SELECT which has no one
+func withMaxExecutionTimeHint(query string, maxExecutionTime time.Duration) string {
+	if maxExecutionTime <= 0 || len(query) < 7 || !strings.EqualFold(query[:7], "SELECT ") ||
+		strings.Contains(query, "MAX_EXECUTION_TIME") {
+		return query
+	}
+
+	sqlBuf := NewSqlBuffer()
+	sqlBuf.WriteString("SELECT ")
+	writeMaxExecutionTimeHint(sqlBuf, maxExecutionTime)
+	sqlBuf.WriteString(query[7:])
+
+	return sqlBuf.GetSQL()
+}
+
+// execWithKill runs the statement on the connection the id of which is known and kills the statement
+// with KILL QUERY from another connection after the maximum execution time
+func (s *MySQL) execWithKill(ctx context.Context, t *transaction, maxExecutionTime time.Duration, query string, a []interface{}) (driver.Result, error) {
+	var (
+		execer Executor
+		connId uint64
+	)
+
+	if t == nil {
+		conn, err := s.db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+
+		if err := conn.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&connId); err != nil {
+			return nil, err
+		}
+		execer = s.executor(conn)
+	} else {
+		var err error
+		if connId, err = t.connectionId(ctx); err != nil {
+			return nil, err
+		}
+		execer = t.exec
+	}
+
+	// The kill is not run once the statement is done even if the timer has fired, the connection may run the next
+	// statement then. The statement waits for the kill which is running.
+	var (
+		mtx          sync.Mutex
+		done, killed bool
+	)
+	stop := s.afterFunc(maxExecutionTime, func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if done {
+			return
+		}
+		killed = true
+		// The kill is not canceled with the context of the statement it stops
+		_, _ = s.executor(s.db).ExecContext(context.Background(), "KILL QUERY "+strconv.FormatUint(connId, 10))
+	})
+	defer stop()
+
+	// The connection is closed by the driver if the statement still runs after the kill
+	ctx, cancel := context.WithTimeout(ctx, maxExecutionTime+s.killGracePeriod)
+	defer cancel()
+
+	res, err := execer.ExecContext(ctx, query, a...)
+
+	mtx.Lock()
+	done = true
+	wasKilled := killed
+	mtx.Unlock()
+
+	if err != nil && wasKilled {
+		return nil, ErrMaxExecutionTime
+	}
+
+	return res, err
+}
@@ -0,0 +1,83 @@
+package mysql
+
+import (
+	"time"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// utcTimeZone is the session time zone set by the storage with the location
+const utcTimeZone = "'+00:00'"
+
+// SetLocation sets the location the time.Time values written to DATETIME fields are converted to, it can be
+// overridden by DateTimeField.Location. DATETIME values are stored verbatim while the server converts TIMESTAMP
+// values from the session time zone, so the session time zone is set to UTC and TIMESTAMP values are converted to UTC.
+// The time.Time values are formatted by the storage whatever the loc parameter of the DSN is, see ParseTime for
+// the scanned values. It must be called before Connect.
+func (s *MySQL) SetLocation(loc *time.Location) {
+	s.exprProcessor.Location = loc
+}
+
+// applyTimeZone sets the session time zone to UTC if the location is set
+func (s *MySQL) applyTimeZone(dsn string) (string, error) {
+	if s.exprProcessor.Location == nil {
+		return dsn, nil
+	}
+
+	cfg, err := mysqldriver.ParseDSN(dsn)
+	if err != nil {
+		return "", err
+	}
+
+	if timeZone, exists := cfg.Params["time_zone"]; exists && timeZone != utcTimeZone {
+		return "", qerror.Errorf("The DSN time zone %s conflicts with the location, the session time zone must be UTC", timeZone)
+	}
+	if cfg.Params == nil {
+		cfg.Params = make(map[string]string)
+	}
+	cfg.Params["time_zone"] = utcTimeZone
+
+	return cfg.FormatDSN(), nil
+}
+
+// fieldLocation returns the location of the time.Time values of the field, nil means the values are formatted
+// in their own locations
+func fieldLocation(field model.IFieldDefinition, loc *time.Location) *time.Location {
+	switch f := field.(type) {
+	case *DateTimeField:
+		if f.Location != nil {
+			return f.Location
+		}
+		return loc
+	case *TimeStampField:
+		if loc != nil {
+			return time.UTC
+		}
+	}
+
+	return nil
+}
+
+// ParseTime parses the scanned value of the DATETIME or TIMESTAMP field in the location the values
+// of the field are written in, the zero date is parsed as the zero time
+func (s *MySQL) ParseTime(field model.IFieldDefinition, value string) (time.Time, error) {
+	switch field.(type) {
+	case *DateTimeField, *TimeStampField:
+	default:
+		return time.Time{}, qerror.Errorf("The field '%s' of type '%s' is neither DATETIME nor TIMESTAMP", field.GetId(), field.GetStorageType())
+	}
+
+	if value == "0000-00-00" || value == "0000-00-00 00:00:00" {
+		return time.Time{}, nil
+	}
+
+	loc := fieldLocation(field, s.exprProcessor.Location)
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	// The fractional seconds are parsed even though the layout has none
+	return time.ParseInLocation("2006-01-02 15:04:05", value, loc)
+}
@@ -0,0 +1,107 @@
+package mysql
+
+import (
+	"context"
+	"unicode/utf8"
+)
+
+// SpanAttribute is the attribute of a span, the keys follow the OpenTelemetry database semantic conventions
+type SpanAttribute struct {
+	Key   string
+	Value string
+}
+
+// Span is the span of a statement or of a transaction
+type Span interface {
+	AddEvent(name string, attrs []SpanAttribute)
+	End(err error)
+}
+
+// Tracer starts the client spans of the statements and the transactions, e.g. an adapter of an OpenTelemetry
+// TracerProvider. The parent is the transaction span of the statements in a transaction, otherwise it is nil
+// and the span of the context is the parent.
+type Tracer interface {
+	Start(ctx context.Context, parent Span, name string, attrs []SpanAttribute) Span
+}
+
+type TracingOpts struct {
+	// MaxStatementLength is the length the db.statement attribute sanitized with the recording policy
+	// is truncated to, 1024 by default
+	MaxStatementLength int
+	// OmitStatement disables the db.statement attribute
+	OmitStatement bool
+}
+
+// SetTracer sets the tracer of the statements and the transactions, nil disables the tracing.
+// The spans are started along with the timelog entries, it must be called before the storage is used.
+func (s *MySQL) SetTracer(tracer Tracer, opts TracingOpts) {
+	if opts.MaxStatementLength <= 0 {
+		opts.MaxStatementLength = 1024
+	}
+
+	s.tracer = tracer
+	s.tracingOpts = opts
+}
+
+// startStatementSpan starts the span of the statement, it returns nil if there is no tracer
+func (s *MySQL) startStatementSpan(ctx context.Context, query string) Span {
+	if s.tracer == nil {
+		return nil
+	}
+
+	info, ok := ctx.Value(ctx_query_key).(queryInfo)
+	if !ok {
+		info.op = statementOp(query)
+	}
+
+	name := info.op
+	attrs := make([]SpanAttribute, 0, 4)
+	attrs = append(attrs, SpanAttribute{"db.system", "mysql"}, SpanAttribute{"db.operation", info.op})
+	if info.model != "" {
+		name += " " + info.model
+		attrs = append(attrs, SpanAttribute{"db.sql.table", info.model})
+	}
+	if !s.tracingOpts.OmitStatement {
+		attrs = append(attrs, SpanAttribute{"db.statement", truncateStatement(s.recordStatement(query), s.tracingOpts.MaxStatementLength)})
+	}
+
+	var parent Span
+	if t, _ := ctx.Value(s.transactionKey()).(*transaction); t != nil {
+		parent = t.span
+	}
+
+	return s.tracer.Start(ctx, parent, name, attrs)
+}
+
+// startTransactionSpan starts the span which is ended by the commit or the rollback of the transaction
+func (s *MySQL) startTransactionSpan(ctx context.Context) Span {
+	if s.tracer == nil {
+		return nil
+	}
+
+	return s.tracer.Start(ctx, nil, "transaction", []SpanAttribute{{"db.system", "mysql"}})
+}
+
+// addTransactionEvent adds the savepoint statement event to the transaction span
+func addTransactionEvent(t *transaction, op, query string) {
+	if t.span != nil {
+		t.span.AddEvent(op, []SpanAttribute{{"db.statement", query}})
+	}
+}
+
+func endSpan(span Span, err error) {
+	if span != nil {
+		span.End(err)
+	}
+}
+
+func truncateStatement(query string, maxLength int) string {
+	if len(query) <= maxLength {
+		return query
+	}
+	for maxLength > 0 && !utf8.RuneStart(query[maxLength]) {
+		maxLength--
+	}
+
+	return query[:maxLength] + "..."
+}
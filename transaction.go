@@ -3,6 +3,8 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"strconv"
 	"sync"
 	"unsafe"
@@ -13,43 +15,145 @@ import (
 
 const ctx_transaction_key = "MYSQL_TRANSACTION"
 
+// ErrTxAlreadyFinished is matched by the errors of Commit and Rollback of the transaction committed or rolled back
+// directly on the *sql.Tx of GetTransaction, see Executor
+var ErrTxAlreadyFinished = errors.New("transaction already finished")
+
 type transaction struct {
 	tx           *sql.Tx
+	exec         Executor
 	savePoint    uint64
 	savePointMtx sync.Mutex
+	modified     map[string]struct{}
+	modifiedMtx  sync.Mutex
+	connId       uint64
+	connIdMtx    sync.Mutex
+	// id is Change.TxId
+	id uint64
+	// changes are delivered to the write observers after the commit, changesMarks are their numbers
+	// at the savepoints
+	changes      []Change
+	changesMarks []int
+	changesMtx   sync.Mutex
+	// span is the span of the transaction from BEGIN to COMMIT or ROLLBACK
+	span Span
+	// key is the key of the transaction started by the storage which Close waits for, see txStarted,
+	// it is 0 for the other transactions
+	key      uint64
+	finished bool
+	// savePointSuffix is the random part of the savepoint names telling them from the ones of the other
+	// layers sharing the transaction
+	savePointSuffix string
+	// namedSavePoint is the last number of the savepoints of CreateSavepoint, namedMarks are the numbers
+	// of the changes at them
+	namedSavePoint uint64
+	namedMarks     map[string]int
+	// snapshot is the connection of the read-only transaction of WithConsistentSnapshot, tx is nil for it
+	snapshot *sql.Conn
+	// shards are the shards of the sharded models the transaction is bound to, see ShardSpec
+	shards    map[string]int
+	shardsMtx sync.Mutex
+}
+
+// addModified remembers the model the cache of which is invalidated on the commit
+func (t *transaction) addModified(modelId string) {
+	t.modifiedMtx.Lock()
+	defer t.modifiedMtx.Unlock()
+
+	if t.modified == nil {
+		t.modified = make(map[string]struct{})
+	}
+	t.modified[modelId] = struct{}{}
+}
+
+// connectionId returns the id of the transaction connection which is requested once
+func (t *transaction) connectionId(ctx context.Context) (uint64, error) {
+	t.connIdMtx.Lock()
+	defer t.connIdMtx.Unlock()
+
+	if t.connId == 0 {
+		if err := t.exec.QueryRowContext(ctx, "SELECT CONNECTION_ID()").Scan(&t.connId); err != nil {
+			return 0, err
+		}
+	}
+
+	return t.connId, nil
+}
+
+// finish reports the end of the transaction to the storage once, t.savePointMtx must be locked
+func (t *transaction) finish(s *MySQL) {
+	if t.key != 0 && !t.finished {
+		t.finished = true
+		s.txFinished(t.key)
+	}
+}
+
+// depth returns 0 for nil, 1 for the transaction and 1 more for each savepoint
+func (t *transaction) depth() int {
+	if t == nil {
+		return 0
+	}
+
+	t.savePointMtx.Lock()
+	defer t.savePointMtx.Unlock()
+
+	return int(t.savePoint) + 1
 }
 
 func (s *MySQL) StartTransaction(ctx context.Context) (context.Context, error) {
 	t := ctx.Value(s.transactionKey())
 
 	if t == nil {
-		if debugSQL {
-			println("BEGIN")
-		}
-		ctx = timelog.Start(ctx, "BEGIN")
-		tx, err := s.db.Begin()
-		ctx = timelog.Finish(ctx)
+		key, err := s.txStarted(ctx)
 		if err != nil {
 			return nil, err
 		}
 
-		return context.WithValue(ctx, s.transactionKey(), &transaction{
-			tx: tx,
-		}), nil
+		var (
+			tx   *sql.Tx
+			span Span
+		)
+		ctx, err := s.withTxHooks(ctx, "begin", "BEGIN", func(ctx context.Context) (err error) {
+			ctx = timelog.Start(ctx, "BEGIN")
+			defer timelog.Finish(ctx)
+			span = s.startTransactionSpan(ctx)
+			start := s.now()
+			tx, err = s.db.BeginTx(ctx, nil)
+			s.txStatementDone(ctx, "begin", "BEGIN", 1, start, err)
+			return err
+		})
+		if err != nil {
+			s.txFinished(key)
+			endSpan(span, err)
+			return nil, s.statementError(ctx, TxModel, "begin", "BEGIN", nil, err)
+		}
+		if d := dryRunOf(ctx); d != nil {
+			d.record("BEGIN", nil)
+		}
+
+		return context.WithValue(ctx, s.transactionKey(), s.newTransaction(tx, span, key)), nil
 	} else {
 		t := t.(*transaction)
+
+		// The statement is reported after the mutex is unlocked
+		start := s.now()
+		var (
+			query string
+			depth int
+			err   error
+		)
+		defer func() { s.txStatementDone(ctx, "savepoint", query, depth, start, err) }()
+
 		t.savePointMtx.Lock()
 		defer t.savePointMtx.Unlock()
 
 		t.savePoint++
 
-		if debugSQL {
-			println("SAVEPOINT SP" + strconv.FormatUint(t.savePoint, 10))
-		}
-		_, err := t.tx.Exec("SAVEPOINT SP" + strconv.FormatUint(t.savePoint, 10))
-		if err != nil {
+		query, depth = "SAVEPOINT "+s.savePointName(t, t.savePoint), int(t.savePoint)+1
+		if err = s.execSavePointStatement(ctx, t, "savepoint", query); err != nil {
 			return nil, err
 		}
+		t.markChanges()
 
 		return ctx, nil
 	}
@@ -65,9 +169,7 @@ func (s *MySQL) UseTransaction(ctx context.Context, tx *sql.Tx) (context.Context
 		return nil, qerror.Errorf("Transaction already started")
 	}
 
-	return context.WithValue(ctx, s.transactionKey(), &transaction{
-		tx: tx,
-	}), nil
+	return context.WithValue(ctx, s.transactionKey(), s.newTransaction(tx, nil, 0)), nil
 }
 
 func (s *MySQL) Commit(ctx context.Context) (context.Context, error) {
@@ -78,34 +180,58 @@ func (s *MySQL) Commit(ctx context.Context) (context.Context, error) {
 	}
 
 	t := ct.(*transaction)
+
+	// The statement is reported after the mutex is unlocked
+	op, query, depth, start := "commit", "COMMIT", 1, s.now()
+	var err error
+	defer func() { s.txStatementDone(ctx, op, query, depth, start, err) }()
+
 	t.savePointMtx.Lock()
 	defer t.savePointMtx.Unlock()
 
 	if t.savePoint > 0 {
-		if debugSQL {
-			println("RELEASE SAVEPOINT SP" + strconv.FormatUint(t.savePoint, 10))
-		}
-		_, err := t.tx.Exec("RELEASE SAVEPOINT SP" + strconv.FormatUint(t.savePoint, 10))
-		if err != nil {
+		op, depth = "release", int(t.savePoint)+1
+		query = "RELEASE SAVEPOINT " + s.savePointName(t, t.savePoint)
+		if err = s.execSavePointStatement(ctx, t, op, query); err != nil {
 			return nil, err
 		}
 
 		t.savePoint--
+		t.releaseChanges()
 
 		return ctx, nil
 	}
-
-	if debugSQL {
-		println("COMMIT")
+	if t.snapshot != nil {
+		err = qerror.Errorf("The consistent snapshot is finished by WithConsistentSnapshot")
+		return nil, err
 	}
+
 	ctx = timelog.Start(ctx, "COMMIT")
-	err := t.tx.Commit()
+	_, err = s.withTxHooks(ctx, op, query, func(context.Context) error {
+		defer t.finish(s)
+		if d := dryRunOf(ctx); d != nil {
+			// Nothing is written in the dry run, the transaction keeps the reads consistent only
+			d.record(query, nil)
+			return t.tx.Rollback()
+		}
+		return txFinishedError(t.tx.Commit())
+	})
 	ctx = timelog.Finish(ctx)
+	addTransactionEvent(t, op, query)
+	endSpan(t.span, err)
 	if err != nil {
-		return nil, err
+		return nil, s.statementError(ctx, TxModel, op, query, nil, err)
+	}
+	// The session consistency window starts when the writes become visible
+	s.markWrite(ctx)
+	for modelId := range t.modified {
+		s.invalidateCache(modelId)
 	}
 
-	return context.WithValue(ctx, s.transactionKey(), nil), nil
+	ctx = context.WithValue(ctx, s.transactionKey(), nil)
+	s.notifyWriteObservers(ctx, t.changes)
+
+	return ctx, nil
 }
 
 func (s *MySQL) Rollback(ctx context.Context) (context.Context, error) {
@@ -116,31 +242,45 @@ func (s *MySQL) Rollback(ctx context.Context) (context.Context, error) {
 	}
 
 	t := ct.(*transaction)
+
+	// The statement is reported after the mutex is unlocked
+	op, query, depth, start := "rollback", "ROLLBACK", 1, s.now()
+	var err error
+	defer func() { s.txStatementDone(ctx, op, query, depth, start, err) }()
+
 	t.savePointMtx.Lock()
 	defer t.savePointMtx.Unlock()
 
 	if t.savePoint > 0 {
-		if debugSQL {
-			println("ROLLBACK TO SAVEPOINT SP" + strconv.FormatUint(t.savePoint, 10))
-		}
-		_, err := t.tx.Exec("ROLLBACK TO SAVEPOINT SP" + strconv.FormatUint(t.savePoint, 10))
-		if err != nil {
+		depth = int(t.savePoint) + 1
+		query = "ROLLBACK TO SAVEPOINT " + s.savePointName(t, t.savePoint)
+		if err = s.execSavePointStatement(ctx, t, op, query); err != nil {
 			return nil, err
 		}
 
 		t.savePoint--
+		t.discardChanges()
 
 		return ctx, nil
 	}
-
-	if debugSQL {
-		println("ROLLBACK")
+	if t.snapshot != nil {
+		err = qerror.Errorf("The consistent snapshot is finished by WithConsistentSnapshot")
+		return nil, err
 	}
+
 	ctx = timelog.Start(ctx, "ROLLBACK")
-	err := t.tx.Rollback()
+	_, err = s.withTxHooks(ctx, op, query, func(context.Context) error {
+		defer t.finish(s)
+		return txFinishedError(t.tx.Rollback())
+	})
 	ctx = timelog.Finish(ctx)
+	if d := dryRunOf(ctx); d != nil && err == nil {
+		d.record(query, nil)
+	}
+	addTransactionEvent(t, op, query)
+	endSpan(t.span, err)
 	if err != nil {
-		return nil, err
+		return nil, s.statementError(ctx, TxModel, op, query, nil, err)
 	}
 
 	return context.WithValue(ctx, s.transactionKey(), nil), nil
@@ -165,6 +305,32 @@ func (s *MySQL) DoInTransaction(ctx context.Context, f func(ctx context.Context)
 	return nil
 }
 
+// DoInTransactionRetry is DoInTransaction which runs the function again in a new transaction up to the retries times
+// if it fails with ErrDeadlock or ErrLockWaitTimeout. In a started transaction the function is not retried
+// since the outer transaction must be retried as a whole.
+func (s *MySQL) DoInTransactionRetry(ctx context.Context, retries int, f func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := s.DoInTransaction(ctx, f)
+		if err == nil || attempt >= retries || ctx.Value(s.transactionKey()) != nil ||
+			!(errors.Is(err, ErrDeadlock) || errors.Is(err, ErrLockWaitTimeout)) {
+			return err
+		}
+	}
+}
+
+// txFinishedError returns ErrTxAlreadyFinished for the error of the transaction finished directly
+func txFinishedError(err error) error {
+	if errors.Is(err, sql.ErrTxDone) {
+		return fmt.Errorf("%w: the transaction is committed or rolled back bypassing the storage", ErrTxAlreadyFinished)
+	}
+
+	return err
+}
+
+// GetTransaction returns the transaction of the context or nil.
+//
+// Deprecated: Commit and Rollback of the returned transaction break the savepoints of the nested transactions,
+// use Executor to run the statements and InTransaction to check for the transaction. It is kept for compatibility.
 func (s *MySQL) GetTransaction(ctx context.Context) *sql.Tx {
 	t := ctx.Value(s.transactionKey())
 
@@ -175,6 +341,172 @@ func (s *MySQL) GetTransaction(ctx context.Context) *sql.Tx {
 	return t.(*transaction).tx
 }
 
+// InTransaction reports whether the context has a transaction, the consistent snapshot included
+func (s *MySQL) InTransaction(ctx context.Context) bool {
+	t, _ := ctx.Value(s.transactionKey()).(*transaction)
+
+	return t != nil
+}
+
+// Executor returns the executor of the statements run in the transaction of the context if there is one,
+// or in the connections pool otherwise. The statements are run as is, bypassing the hooks, the logs and the metrics
+// of the storage. The transaction cannot be finished with it, see Commit and Rollback.
+func (s *MySQL) Executor(ctx context.Context) Executor {
+	if t, _ := ctx.Value(s.transactionKey()).(*transaction); t != nil {
+		return boundExecutor{t.exec}
+	}
+
+	return boundExecutor{s.executor(s.db)}
+}
+
+// boundExecutor hides the transaction or the connections pool of Executor, so it cannot be finished or closed
+type boundExecutor struct {
+	exec Executor
+}
+
+func (e boundExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return e.exec.ExecContext(ctx, query, args...)
+}
+
+func (e boundExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return e.exec.QueryContext(ctx, query, args...)
+}
+
+func (e boundExecutor) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return e.exec.QueryRowContext(ctx, query, args...)
+}
+
+// DefaultSavepointPrefix is the default prefix of the savepoint names
+const DefaultSavepointPrefix = "SP"
+
+// SetSavepointPrefix sets the prefix of the savepoint names, DefaultSavepointPrefix if it is empty.
+// The names are the prefix, the random part of the transaction and the number of the savepoint, e.g. SP_a9f3_1,
+// so the savepoints of the other layers sharing the transaction are not clobbered. It must be called before
+// the storage is used.
+func (s *MySQL) SetSavepointPrefix(prefix string) {
+	s.savePointPrefix = prefix
+}
+
+// SetPlainSavepointNames makes the nested transactions use the names without the random part, e.g. SP1.
+// It keeps the logs and the tests written for the old names and must be called before the storage is used.
+func (s *MySQL) SetPlainSavepointNames(enabled bool) {
+	s.plainSavePointNames = enabled
+}
+
+func (s *MySQL) savePointPrefixOrDefault() string {
+	if s.savePointPrefix == "" {
+		return DefaultSavepointPrefix
+	}
+
+	return s.savePointPrefix
+}
+
+// savePointName returns the name of the savepoint of the nested transaction of the level
+func (s *MySQL) savePointName(t *transaction, level uint64) string {
+	if s.plainSavePointNames {
+		return s.savePointPrefixOrDefault() + strconv.FormatUint(level, 10)
+	}
+
+	return s.savePointPrefixOrDefault() + "_" + t.savePointSuffix + "_" + strconv.FormatUint(level, 10)
+}
+
+// execSavePointStatement runs the savepoint statement of the transaction between the hooks
+func (s *MySQL) execSavePointStatement(ctx context.Context, t *transaction, op, query string) error {
+	addTransactionEvent(t, op, query)
+	_, err := s.withTxHooks(ctx, op, query, func(ctx context.Context) error {
+		_, err := t.exec.ExecContext(ctx, query)
+		return err
+	})
+	if d := dryRunOf(ctx); d != nil && err == nil {
+		d.record(query, nil)
+	}
+	if err != nil {
+		return s.statementError(ctx, TxModel, op, query, nil, err)
+	}
+
+	return nil
+}
+
+// CreateSavepoint creates the savepoint in the transaction of the context and returns its name unique
+// in the transaction. It is for the code running its own statements on the transaction with Executor,
+// the savepoint must be released or rolled back to with ReleaseSavepoint or RollbackSavepoint. The savepoints
+// are independent from the nested transactions.
+func (s *MySQL) CreateSavepoint(ctx context.Context) (string, error) {
+	t, _ := ctx.Value(s.transactionKey()).(*transaction)
+	if t == nil {
+		return "", qerror.Errorf("No started transaction")
+	}
+
+	t.savePointMtx.Lock()
+	t.namedSavePoint++
+	name := s.savePointPrefixOrDefault() + "_" + t.savePointSuffix + "_n" + strconv.FormatUint(t.namedSavePoint, 10)
+	t.savePointMtx.Unlock()
+
+	query, start := "SAVEPOINT "+name, s.now()
+	err := s.execSavePointStatement(ctx, t, "savepoint", query)
+	s.txStatementDone(ctx, "savepoint", query, t.depth(), start, err)
+	if err != nil {
+		return "", err
+	}
+	t.markNamedChanges(name)
+
+	return name, nil
+}
+
+// ReleaseSavepoint releases the savepoint created by CreateSavepoint keeping the changes made after it
+func (s *MySQL) ReleaseSavepoint(ctx context.Context, name string) error {
+	t, err := s.namedSavePointTransaction(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	query, start := "RELEASE SAVEPOINT "+name, s.now()
+	err = s.execSavePointStatement(ctx, t, "release", query)
+	s.txStatementDone(ctx, "release", query, t.depth(), start, err)
+	if err != nil {
+		return err
+	}
+	t.releaseNamedChanges(name)
+
+	return nil
+}
+
+// RollbackSavepoint rolls the transaction back to the savepoint created by CreateSavepoint, the savepoint
+// is kept as MySQL does
+func (s *MySQL) RollbackSavepoint(ctx context.Context, name string) error {
+	t, err := s.namedSavePointTransaction(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	query, start := "ROLLBACK TO SAVEPOINT "+name, s.now()
+	err = s.execSavePointStatement(ctx, t, "rollback", query)
+	s.txStatementDone(ctx, "rollback", query, t.depth(), start, err)
+	if err != nil {
+		return err
+	}
+	t.discardNamedChanges(name)
+
+	return nil
+}
+
+// namedSavePointTransaction returns the transaction of the context the savepoint of CreateSavepoint belongs to
+func (s *MySQL) namedSavePointTransaction(ctx context.Context, name string) (*transaction, error) {
+	t, _ := ctx.Value(s.transactionKey()).(*transaction)
+	if t == nil {
+		return nil, qerror.Errorf("No started transaction")
+	}
+
+	t.changesMtx.Lock()
+	_, exists := t.namedMarks[name]
+	t.changesMtx.Unlock()
+	if !exists {
+		return nil, qerror.Errorf("Unknown savepoint '%s'", name)
+	}
+
+	return t, nil
+}
+
 func (s *MySQL) transactionKey() string {
 	return ctx_transaction_key + strconv.FormatInt(int64(uintptr(unsafe.Pointer(s))), 10)
 }
@@ -0,0 +1,345 @@
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+// Tree is the helper for a model referencing itself by the parent field, see NewTree
+type Tree struct {
+	Model model.IModel
+	// ParentFieldName is the field referencing the primary key of the parent row, NULL for the roots
+	ParentFieldName string
+	// OrderFieldName is the field the siblings are sorted by, the primary key by default
+	OrderFieldName string
+	// Iterative requests the tree level by level instead of WITH RECURSIVE which MySQL 5.7 does not support
+	Iterative bool
+
+	s *MySQL
+}
+
+// NewTree returns the tree helper for the self-referencing model with a single field primary key
+func (s *MySQL) NewTree(m model.IModel, parentFieldName string) (*Tree, error) {
+	if len(m.GetPKFieldsNames()) != 1 {
+		return nil, qerror.Errorf("The model '%s' must have a single field primary key", m.GetId())
+	}
+	if field := m.GetFieldDefinition(parentFieldName); field == nil || field.IsDerivable() {
+		return nil, qerror.Errorf("Unknown field '%s' in model '%s'", parentFieldName, m.GetId())
+	}
+
+	return &Tree{Model: m, ParentFieldName: parentFieldName, s: s}, nil
+}
+
+func (t *Tree) pkFieldName() string {
+	return t.Model.GetPKFieldsNames()[0]
+}
+
+func (t *Tree) orderFieldName() string {
+	if t.OrderFieldName != "" {
+		return t.OrderFieldName
+	}
+
+	return t.pkFieldName()
+}
+
+// storageFields returns the requested fields with the fields required to build the tree
+func (t *Tree) storageFields(fieldsNames []string) []string {
+	res := append([]string{}, fieldsNames...)
+	for _, name := range []string{t.pkFieldName(), t.ParentFieldName, t.orderFieldName()} {
+		if !containsString(res, name) {
+			res = append(res, name)
+		}
+	}
+
+	return res
+}
+
+// GetSubtree returns the root row and its descendants up to maxDepth levels, all of them if maxDepth is 0.
+// The rows are in the depth-first order with the siblings sorted by OrderFieldName, the "depth" column is
+// the level relative to the root.
+func (t *Tree) GetSubtree(ctx context.Context, rootId interface{}, maxDepth int, fieldsNames []string) (*model.Data, error) {
+	rows, err := t.queryRows(ctx, rootId, maxDepth, fieldsNames, true)
+	if err != nil {
+		return nil, err
+	}
+
+	children := make(map[string][]map[string]interface{})
+	var root map[string]interface{}
+	for _, row := range rows {
+		if fmt.Sprint(row[t.pkFieldName()]) == fmt.Sprint(rootId) {
+			root = row
+			continue
+		}
+		parentKey := fmt.Sprint(row[t.ParentFieldName])
+		children[parentKey] = append(children[parentKey], row)
+	}
+
+	res := model.NewEmptyData(append(fieldsNames[:len(fieldsNames):len(fieldsNames)], "depth"))
+	if root == nil {
+		return res, nil
+	}
+
+	var add func(row map[string]interface{}, depth int) error
+	add = func(row map[string]interface{}, depth int) error {
+		if err := res.Add(t.dataRow(row, fieldsNames, depth)); err != nil {
+			return err
+		}
+
+		siblings := children[fmt.Sprint(row[t.pkFieldName()])]
+		t.sortSiblings(siblings)
+		for _, child := range siblings {
+			if err := add(child, depth+1); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := add(root, 0); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// GetAncestors returns the ancestors of the row from the root to the parent, the "depth" column is the level
+// relative to the root
+func (t *Tree) GetAncestors(ctx context.Context, id interface{}, fieldsNames []string) (*model.Data, error) {
+	rows, err := t.queryRows(ctx, id, 0, fieldsNames, false)
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]map[string]interface{}, len(rows))
+	for _, row := range rows {
+		byId[fmt.Sprint(row[t.pkFieldName()])] = row
+	}
+
+	var path []map[string]interface{}
+	if node := byId[fmt.Sprint(id)]; node != nil {
+		for parentId := node[t.ParentFieldName]; parentId != nil && len(path) < len(rows); {
+			parent := byId[fmt.Sprint(parentId)]
+			if parent == nil {
+				break
+			}
+			path = append(path, parent)
+			parentId = parent[t.ParentFieldName]
+		}
+	}
+
+	res := model.NewEmptyData(append(fieldsNames[:len(fieldsNames):len(fieldsNames)], "depth"))
+	for i := len(path) - 1; i >= 0; i-- {
+		if err := res.Add(t.dataRow(path[i], fieldsNames, len(path)-1-i)); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// MoveSubtree sets the new parent of the row, nil makes it a root. The parent must not be the row itself or
+// one of its descendants.
+func (t *Tree) MoveSubtree(ctx context.Context, id, newParentId interface{}) error {
+	return t.s.DoInTransaction(ctx, func(ctx context.Context) error {
+		if newParentId != nil {
+			if fmt.Sprint(newParentId) == fmt.Sprint(id) {
+				return qerror.Errorf("The row %v cannot be the parent of itself", id)
+			}
+
+			parent, err := t.s.Select(ctx, t.Model, []string{t.pkFieldName()}, SelectOptions{GetAllOptions: model.GetAllOptions{
+				Filter:    In(t.Model.FieldExpr(t.pkFieldName()), []interface{}{newParentId}),
+				ForUpdate: true,
+			}})
+			if err != nil {
+				return err
+			}
+			if parent.Len() == 0 {
+				return qerror.Errorf("Unknown parent %v in model '%s'", newParentId, t.Model.GetId())
+			}
+
+			ancestors, err := t.GetAncestors(ctx, newParentId, []string{t.pkFieldName()})
+			if err != nil {
+				return err
+			}
+			for _, row := range ancestors.Data() {
+				if fmt.Sprint(row[0]) == fmt.Sprint(id) {
+					return qerror.Errorf("The row %v cannot be moved to its descendant %v", id, newParentId)
+				}
+			}
+		}
+
+		return t.s.Edit(ctx, t.Model, In(t.Model.FieldExpr(t.pkFieldName()), []interface{}{id}), map[string]interface{}{
+			t.ParentFieldName: newParentId,
+		})
+	})
+}
+
+func (t *Tree) dataRow(row map[string]interface{}, fieldsNames []string, depth int) []interface{} {
+	res := make([]interface{}, 0, len(fieldsNames)+1)
+	for _, name := range fieldsNames {
+		res = append(res, row[name])
+	}
+
+	return append(res, depth)
+}
+
+func (t *Tree) sortSiblings(rows []map[string]interface{}) {
+	orderField, pkField := t.orderFieldName(), t.pkFieldName()
+	sort.SliceStable(rows, func(i, j int) bool {
+		if res, ok := compareValues(rows[i][orderField], rows[j][orderField]); ok && res != 0 {
+			return res < 0
+		}
+		res, _ := compareValues(rows[i][pkField], rows[j][pkField])
+		return res < 0
+	})
+}
+
+// queryRows returns the rows of the subtree or the row with its ancestors
+func (t *Tree) queryRows(ctx context.Context, id interface{}, maxDepth int, fieldsNames []string, down bool) ([]map[string]interface{}, error) {
+	for _, name := range fieldsNames {
+		if field := t.Model.GetFieldDefinition(name); field == nil || field.IsDerivable() {
+			return nil, qerror.Errorf("Unknown field '%s' in model '%s'", name, t.Model.GetId())
+		}
+	}
+	storageFields := t.storageFields(fieldsNames)
+
+	if t.Iterative {
+		return t.queryRowsIterative(ctx, id, maxDepth, storageFields, down)
+	}
+
+	sqlBuf := t.s.ScopedSqlBuffer(ctx)
+	if down {
+		t.WriteSubtreeSQL(sqlBuf, id, maxDepth, storageFields)
+	} else {
+		t.WriteAncestorsSQL(sqlBuf, id, storageFields)
+	}
+	if err := sqlBuf.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := t.s.RawQuery(withQuery(ctx, t.Model.GetId(), "select"), sqlBuf.GetSQL(), sqlBuf.GetArgs()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	data, err := scanData(rows, func(_ int, name string) model.IFieldDefinition { return t.Model.GetFieldDefinition(name) })
+	if err != nil {
+		return nil, err
+	}
+
+	return t.derefRows(data), nil
+}
+
+// queryRowsIterative requests one tree level per query, the levels are requested by the IN chunk size ids
+func (t *Tree) queryRowsIterative(ctx context.Context, id interface{}, maxDepth int, storageFields []string, down bool) ([]map[string]interface{}, error) {
+	var res []map[string]interface{}
+	seen := make(map[string]struct{})
+
+	ids := []interface{}{id}
+	for depth := 0; len(ids) > 0 && (!down || maxDepth <= 0 || depth <= maxDepth); depth++ {
+		filterField := t.pkFieldName()
+		if down && depth > 0 {
+			filterField = t.ParentFieldName
+		}
+
+		data, err := t.s.Select(ctx, t.Model, storageFields, SelectOptions{GetAllOptions: model.GetAllOptions{
+			Filter: In(t.Model.FieldExpr(filterField), ids),
+		}})
+		if err != nil {
+			return nil, err
+		}
+
+		ids = nil
+		for _, row := range t.derefRows(data) {
+			key := fmt.Sprint(row[t.pkFieldName()])
+			if _, exists := seen[key]; exists {
+				continue
+			}
+			seen[key] = struct{}{}
+			res = append(res, row)
+
+			if down {
+				ids = append(ids, row[t.pkFieldName()])
+			} else if row[t.ParentFieldName] != nil {
+				ids = append(ids, row[t.ParentFieldName])
+			}
+		}
+	}
+
+	return res, nil
+}
+
+func (t *Tree) derefRows(data *model.Data) []map[string]interface{} {
+	res := make([]map[string]interface{}, 0, data.Len())
+	for _, row := range data.Data() {
+		rowMap := make(map[string]interface{}, len(row))
+		for i, name := range data.Fields() {
+			rowMap[name] = derefValue(row[i])
+		}
+		res = append(res, rowMap)
+	}
+
+	return res
+}
+
+// WriteSubtreeSQL writes the WITH RECURSIVE query of the root row and its descendants up to maxDepth levels
+func (t *Tree) WriteSubtreeSQL(sqlBuf *SqlBuffer, rootId interface{}, maxDepth int, fieldsNames []string) {
+	t.writeRecursiveSQL(sqlBuf, rootId, maxDepth, fieldsNames, true)
+}
+
+// WriteAncestorsSQL writes the WITH RECURSIVE query of the row and its ancestors
+func (t *Tree) WriteAncestorsSQL(sqlBuf *SqlBuffer, id interface{}, fieldsNames []string) {
+	t.writeRecursiveSQL(sqlBuf, id, 0, fieldsNames, false)
+}
+
+func (t *Tree) writeRecursiveSQL(sqlBuf *SqlBuffer, id interface{}, maxDepth int, fieldsNames []string, down bool) {
+	sqlBuf.WriteString("WITH RECURSIVE `_tree` AS (SELECT ")
+	sqlBuf.WriteIdentifiersList(fieldsNames)
+	sqlBuf.WriteString(",0 AS `_depth` FROM ")
+	sqlBuf.WriteTable(t.Model)
+	sqlBuf.WriteString(" WHERE ")
+	sqlBuf.WriteIdentifier(t.pkFieldName())
+	sqlBuf.WriteByte('=')
+	sqlBuf.WriteValue(id)
+	scope := sqlBuf.scoped(t.s, t.Model, nil)
+	if scope != nil {
+		sqlBuf.WriteString(" AND (")
+		scope.GetProcessor(t.s.exprProcessor.ForModel(t.Model)).(WriteFunc)(sqlBuf)
+		sqlBuf.WriteByte(')')
+	}
+
+	sqlBuf.WriteString(" UNION ALL SELECT ")
+	for _, name := range fieldsNames {
+		sqlBuf.WriteString(QuoteIdent("_node", name))
+		sqlBuf.WriteByte(',')
+	}
+	sqlBuf.WriteString("`_tree`.`_depth`+1 FROM ")
+	sqlBuf.WriteTable(t.Model)
+	sqlBuf.WriteString(" AS `_node` JOIN `_tree` ON ")
+	if down {
+		sqlBuf.WriteString(QuoteIdent("_node", t.ParentFieldName) + "=" + QuoteIdent("_tree", t.pkFieldName()))
+		if maxDepth > 0 {
+			sqlBuf.WriteString(" WHERE `_tree`.`_depth`<")
+			sqlBuf.WriteValue(maxDepth)
+		}
+	} else {
+		sqlBuf.WriteString(QuoteIdent("_node", t.pkFieldName()) + "=" + QuoteIdent("_tree", t.ParentFieldName))
+	}
+	if scope != nil {
+		p := t.s.exprProcessor.ForModel(t.Model)
+		p.qualify, p.alias = true, "_node"
+		sqlBuf.WriteString(" AND (")
+		scope.GetProcessor(p).(WriteFunc)(sqlBuf)
+		sqlBuf.WriteByte(')')
+	}
+
+	sqlBuf.WriteString(") SELECT ")
+	sqlBuf.WriteIdentifiersList(fieldsNames)
+	sqlBuf.WriteString(" FROM `_tree`")
+}
@@ -0,0 +1,130 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-qbit/model"
+)
+
+// ErrUnknownField is matched by the errors of the fields unknown in the model, see UnknownFieldError
+var ErrUnknownField = errors.New("unknown field")
+
+// ExprPart is the part of the statement the field is used in
+type ExprPart string
+
+const (
+	PartCondition ExprPart = "condition"
+	PartOrder     ExprPart = "order"
+	PartGroup     ExprPart = "group"
+	PartSelect    ExprPart = "select"
+	PartValue     ExprPart = "value"
+)
+
+// UnknownFieldError is the error of the field unknown in the model, it is returned before the statement is sent.
+// Suggestion is the field of the model with the closest id, it is empty if no field is close enough.
+// The raw expressions are written as is and are not validated.
+type UnknownFieldError struct {
+	Model      string
+	Field      string
+	Part       ExprPart
+	Suggestion string
+}
+
+func (e *UnknownFieldError) Error() string {
+	res := fmt.Sprintf("Unknown field '%s' in model '%s' in the %s", e.Field, e.Model, e.Part)
+	if e.Suggestion != "" {
+		res += fmt.Sprintf(", did you mean '%s'?", e.Suggestion)
+	}
+
+	return res
+}
+
+func (e *UnknownFieldError) Is(target error) bool { return target == ErrUnknownField }
+
+// unknownFieldError returns UnknownFieldError with the closest field of the model suggested, the case is ignored
+func unknownFieldError(m model.IModel, fieldName string, part ExprPart) error {
+	res := &UnknownFieldError{Model: m.GetId(), Field: fieldName, Part: part}
+
+	best := -1
+	for _, name := range m.GetFieldsNames() {
+		d := editDistance(strings.ToLower(fieldName), strings.ToLower(name))
+		if d <= 2 && d < len(fieldName) && (best < 0 || d < best) {
+			res.Suggestion, best = name, d
+		}
+	}
+
+	return res
+}
+
+// checkFields returns UnknownFieldError for the first name which is not the field of the model
+func checkFields(m model.IModel, fieldsNames []string, part ExprPart) error {
+	for _, name := range fieldsNames {
+		if m.GetFieldDefinition(name) == nil {
+			return unknownFieldError(m, name, part)
+		}
+	}
+
+	return nil
+}
+
+// checkValuesFields returns UnknownFieldError for the first name in order of the values which is not the field
+// of the model
+func checkValuesFields(m model.IModel, values map[string]interface{}) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return checkFields(m, names, PartValue)
+}
+
+// Edit is model.BaseModel.Edit with the unknown fields of the values reported as UnknownFieldError
+func (m *BaseModel) Edit(ctx context.Context, filter model.IExpression, newValues map[string]interface{}) error {
+	if err := checkValuesFields(m, newValues); err != nil {
+		return err
+	}
+
+	return m.BaseModel.Edit(ctx, filter, newValues)
+}
+
+// editDistance returns the number of the inserted, deleted, substituted and transposed adjacent bytes
+// turning a into b
+func editDistance(a, b string) int {
+	prev2, prev, cur := make([]int, len(b)+1), make([]int, len(b)+1), make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minOf(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = minOf(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+
+	return prev[len(b)]
+}
+
+func minOf(values ...int) int {
+	res := values[0]
+	for _, v := range values[1:] {
+		if v < res {
+			res = v
+		}
+	}
+
+	return res
+}
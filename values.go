@@ -0,0 +1,289 @@
+package mysql
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+var (
+	timeType  = reflect.TypeOf(time.Time{})
+	bytesType = reflect.TypeOf([]byte{})
+)
+
+// checkFieldValue checks that the value can be compared with or written to the field
+func checkFieldValue(field model.IFieldDefinition, value interface{}) error {
+	if isNil(value) {
+		return nil
+	}
+
+	rv := reflect.Indirect(reflect.ValueOf(value))
+
+	fieldType := field.GetType()
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+
+	ok := false
+	switch fieldType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			ok = true
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if rv.Int() < 0 {
+				return qerror.Errorf("Negative value %d for the unsigned field '%s'", rv.Int(), field.GetId())
+			}
+			ok = true
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			ok = true
+		}
+	case reflect.Float32, reflect.Float64:
+		switch rv.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			ok = true
+		}
+	case reflect.String:
+		// Date, time and decimal fields are represented as strings
+		ok = rv.Kind() == reflect.String || rv.Type() == timeType || rv.Type() == bytesType
+	case reflect.Slice:
+		ok = rv.Kind() == reflect.String || rv.Type().ConvertibleTo(bytesType)
+	case reflect.Bool:
+		ok = rv.Kind() == reflect.Bool
+	default:
+		ok = rv.Type().ConvertibleTo(fieldType)
+	}
+
+	if !ok {
+		return qerror.Errorf("Invalid value type '%T' for the field '%s' of type '%s'", value, field.GetId(), field.GetStorageType())
+	}
+
+	return nil
+}
+
+// fieldValue converts the value to the representation expected by the field, time.Time values are formatted
+// according to the field storage type so DATE and DATETIME columns are compared correctly.
+// DATETIME and TIMESTAMP values are converted to the location of the field, see SetLocation.
+// The values of the sensitive and the masked fields are marked to be redacted or masked.
+func fieldValue(field model.IFieldDefinition, value interface{}, loc *time.Location) interface{} {
+	if t, ok := value.(*time.Time); ok && t != nil {
+		value = *t
+	}
+
+	if t, ok := value.(time.Time); ok {
+		switch field.(type) {
+		case *DateField:
+			value = t.Format("2006-01-02")
+		case *TimeField:
+			value = t.Format("15:04:05.999999")
+		case *YearField:
+			value = t.Format("2006")
+		case *DateTimeField, *TimeStampField:
+			if fieldLoc := fieldLocation(field, loc); fieldLoc != nil {
+				t = t.In(fieldLoc)
+			}
+			value = t.Format("2006-01-02 15:04:05.999999")
+		}
+	}
+
+	return sensitiveFieldValue(field, value)
+}
+
+// isConvertedField reports whether the values written to the field are passed through fieldValue, the time.Time
+// values of the fields without the location are formatted by the driver
+func isConvertedField(field model.IFieldDefinition, loc *time.Location) bool {
+	return isSensitiveField(field) || readMaskOf(field) != nil || fieldLocation(field, loc) != nil
+}
+
+// compareValues compares two scalar values of compatible types, ok is false if the values are not comparable
+func compareValues(a, b interface{}) (res int, ok bool) {
+	if ta, isTime := a.(time.Time); isTime {
+		tb, isTime := b.(time.Time)
+		if !isTime {
+			return 0, false
+		}
+		switch {
+		case ta.Before(tb):
+			return -1, true
+		case ta.After(tb):
+			return 1, true
+		default:
+			return 0, true
+		}
+	}
+
+	ra, rb := reflect.Indirect(reflect.ValueOf(a)), reflect.Indirect(reflect.ValueOf(b))
+	if !ra.IsValid() || !rb.IsValid() {
+		return 0, false
+	}
+
+	switch {
+	case isNumberKind(ra.Kind()) && isNumberKind(rb.Kind()):
+		if isIntegerKind(ra.Kind()) && isIntegerKind(rb.Kind()) {
+			return compareIntegers(ra, rb), true
+		}
+		fa, fb := toFloat(ra), toFloat(rb)
+		return compareOrdered(fa < fb, fa > fb), true
+	case ra.Kind() == reflect.String && rb.Kind() == reflect.String:
+		return compareOrdered(ra.String() < rb.String(), ra.String() > rb.String()), true
+	default:
+		return 0, false
+	}
+}
+
+func compareOrdered(less, greater bool) int {
+	switch {
+	case less:
+		return -1
+	case greater:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func isNumberKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+func isUintKind(k reflect.Kind) bool {
+	return k >= reflect.Uint && k <= reflect.Uintptr
+}
+
+func isIntegerKind(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Uintptr
+}
+
+// compareIntegers compares the integers exactly, the unsigned values above math.MaxInt64 are not converted to
+// float64 or int64
+func compareIntegers(a, b reflect.Value) int {
+	switch {
+	case isUintKind(a.Kind()) && isUintKind(b.Kind()):
+		return compareOrdered(a.Uint() < b.Uint(), a.Uint() > b.Uint())
+	case isUintKind(a.Kind()):
+		if b.Int() < 0 {
+			return 1
+		}
+		return compareOrdered(a.Uint() < uint64(b.Int()), a.Uint() > uint64(b.Int()))
+	case isUintKind(b.Kind()):
+		return -compareIntegers(b, a)
+	default:
+		return compareOrdered(a.Int() < b.Int(), a.Int() > b.Int())
+	}
+}
+
+func toFloat(rv reflect.Value) float64 {
+	switch {
+	case isUintKind(rv.Kind()):
+		return float64(rv.Uint())
+	case rv.Kind() == reflect.Float32 || rv.Kind() == reflect.Float64:
+		return rv.Float()
+	default:
+		return float64(rv.Int())
+	}
+}
+
+// derefValue returns the value a non-nil pointer points to, nil for a nil pointer and the value itself otherwise
+func derefValue(value interface{}) interface{} {
+	if isNil(value) {
+		return nil
+	}
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr {
+		return rv.Elem().Interface()
+	}
+
+	return value
+}
+
+// textValue checks the text value of the field, the values of DATE, TIME, DATETIME, TIMESTAMP and SET fields
+// must be valid and DATETIME and TIMESTAMP values in RFC 3339 are converted to the location of the field
+func (s *MySQL) textValue(field model.IFieldDefinition, value string) (string, error) {
+	switch f := field.(type) {
+	case *DateField:
+		return value, checkTimeLayout(value, "2006-01-02", "0000-00-00")
+	case *TimeField:
+		return value, checkTimeLayout(value, "15:04:05", "")
+	case *DateTimeField, *TimeStampField:
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			if loc := fieldLocation(field, s.exprProcessor.Location); loc != nil {
+				t = t.In(loc)
+			}
+			return t.Format("2006-01-02 15:04:05.999999"), nil
+		}
+		return value, checkTimeLayout(value, "2006-01-02 15:04:05", "0000-00-00 00:00:00")
+	case *SetField:
+		for _, item := range strings.Split(value, ",") {
+			if value != "" && !containsString(f.Values, item) {
+				return "", qerror.Errorf("'%s' is not one of %s", item, strings.Join(f.Values, ", "))
+			}
+		}
+	}
+
+	return value, nil
+}
+
+func checkTimeLayout(value, layout, zero string) error {
+	// The fractional seconds are parsed even though the layout has none
+	if _, err := time.Parse(layout, value); err != nil && value != zero {
+		return qerror.Errorf("'%s' does not match the format %s", value, layout)
+	}
+
+	return nil
+}
+
+// parseText converts the text value to the type of the field
+func (s *MySQL) parseText(field model.IFieldDefinition, value string) (interface{}, error) {
+	t := field.GetType()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	rv := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(value, 10, t.Bits())
+		if err != nil {
+			return nil, qerror.Errorf("cannot convert '%s' to %s", value, t.String())
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(value, 10, t.Bits())
+		if err != nil {
+			return nil, qerror.Errorf("cannot convert '%s' to %s", value, t.String())
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, t.Bits())
+		if err != nil {
+			return nil, qerror.Errorf("cannot convert '%s' to %s", value, t.String())
+		}
+		rv.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, qerror.Errorf("cannot convert '%s' to %s", value, t.String())
+		}
+		rv.SetBool(b)
+	case reflect.String:
+		return s.textValue(field, value)
+	case reflect.Slice:
+		if t != bytesType {
+			return nil, qerror.Errorf("cannot convert '%s' to %s", value, t.String())
+		}
+		return []byte(value), nil
+	default:
+		return nil, qerror.Errorf("cannot convert '%s' to %s", value, t.String())
+	}
+
+	return rv.Interface(), nil
+}
@@ -0,0 +1,148 @@
+package mysql
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-qbit/qerror"
+)
+
+// Flavor is the server kind, the features differing between MySQL and MariaDB branch on it
+type Flavor int
+
+const (
+	FlavorMySQL Flavor = iota
+	FlavorMariaDB
+)
+
+// ServerVersion is the version of the server reported by SELECT VERSION()
+type ServerVersion struct {
+	Raw                 string
+	Major, Minor, Patch int
+	MariaDB             bool
+}
+
+var serverVersionRe = regexp.MustCompile(`^(?:5\.5\.5-)?(\d+)\.(\d+)\.(\d+)`)
+
+// ParseServerVersion parses the version, e.g. "8.0.32", "5.7.44-log" or "10.6.12-MariaDB-log". The "5.5.5-" prefix
+// of the old MariaDB versions is skipped.
+func ParseServerVersion(raw string) (ServerVersion, error) {
+	res := ServerVersion{Raw: raw, MariaDB: strings.Contains(strings.ToLower(raw), "mariadb")}
+
+	match := serverVersionRe.FindStringSubmatch(raw)
+	if match == nil {
+		return ServerVersion{}, qerror.Errorf("Invalid server version '%s'", raw)
+	}
+	res.Major, _ = strconv.Atoi(match[1])
+	res.Minor, _ = strconv.Atoi(match[2])
+	res.Patch, _ = strconv.Atoi(match[3])
+
+	return res, nil
+}
+
+// AtLeast reports whether the version is major.minor or later
+func (v ServerVersion) AtLeast(major, minor int) bool {
+	return v.Major > major || v.Major == major && v.Minor >= minor
+}
+
+func (v ServerVersion) Flavor() Flavor {
+	if v.MariaDB {
+		return FlavorMariaDB
+	}
+
+	return FlavorMySQL
+}
+
+func (v ServerVersion) String() string { return v.Raw }
+
+// SetServerVersion sets the version of the server instead of requesting it.
+// It must be called before the storage is used.
+func (s *MySQL) SetServerVersion(raw string) error {
+	version, err := ParseServerVersion(raw)
+	if err != nil {
+		return err
+	}
+
+	s.serverVersionMtx.Lock()
+	defer s.serverVersionMtx.Unlock()
+	s.serverVersion = &version
+
+	return nil
+}
+
+// ServerVersion returns the version of the primary server, it is requested once
+func (s *MySQL) ServerVersion(ctx context.Context) (ServerVersion, error) {
+	s.serverVersionMtx.Lock()
+	defer s.serverVersionMtx.Unlock()
+
+	if s.serverVersion != nil {
+		return *s.serverVersion, nil
+	}
+
+	rows, err := s.RawQuery(ForcePrimary(ctx), "SELECT VERSION()")
+	if err != nil {
+		return ServerVersion{}, err
+	}
+	defer rows.Close()
+
+	var raw string
+	if rows.Next() {
+		if err := rows.Scan(&raw); err != nil {
+			return ServerVersion{}, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return ServerVersion{}, err
+	}
+
+	version, err := ParseServerVersion(raw)
+	if err != nil {
+		return ServerVersion{}, err
+	}
+	s.serverVersion = &version
+
+	return version, nil
+}
+
+// Flavor returns the kind of the primary server, see ServerVersion
+func (s *MySQL) Flavor(ctx context.Context) (Flavor, error) {
+	version, err := s.ServerVersion(ctx)
+
+	return version.Flavor(), err
+}
+
+// supportsLockWait reports whether the server supports NOWAIT and SKIP LOCKED
+func (s *MySQL) supportsLockWait(ctx context.Context) (bool, ServerVersion, error) {
+	version, err := s.ServerVersion(ctx)
+	if err != nil {
+		return false, version, err
+	}
+
+	return version.MariaDB && version.AtLeast(10, 6) || !version.MariaDB && version.AtLeast(8, 0), version, nil
+}
+
+// checkLockWait returns the error if the server does not support NOWAIT and SKIP LOCKED
+func (s *MySQL) checkLockWait(ctx context.Context) error {
+	supported, version, err := s.supportsLockWait(ctx)
+	if err != nil || supported {
+		return err
+	}
+
+	return qerror.Errorf("NOWAIT and SKIP LOCKED require MySQL 8.0 or MariaDB 10.6, the server version is %s", version.Raw)
+}
+
+// checkWindowFunctions returns the error if the server does not support the window functions
+func (s *MySQL) checkWindowFunctions(ctx context.Context) error {
+	version, err := s.ServerVersion(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version.MariaDB && version.AtLeast(10, 2) || !version.MariaDB && version.AtLeast(8, 0) {
+		return nil
+	}
+
+	return qerror.Errorf("The window functions require MySQL 8.0 or MariaDB 10.2, the server version is %s", version.Raw)
+}
@@ -0,0 +1,225 @@
+package mysql
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+const ctx_virtual_fields_key = "MYSQL_VIRTUAL_FIELDS"
+
+// WithVirtualFields adds the per-query derivable fields of the model to the reads with the context, e.g. the distance
+// from the point or the formatted label needed by one query. The fields are model.DerivableField or
+// BatchDerivableField, they may depend on the stored, the derivable and the other virtual fields and are requested
+// by their ids as the derivable fields of the model by GetAll, GetAllToStructs, ExportJSONL and GetAllColumnar
+// with Derivable. Their ids must not be the ids of the model fields.
+func WithVirtualFields(ctx context.Context, m model.IModel, fields ...model.IFieldDefinition) context.Context {
+	prev, _ := ctx.Value(ctx_virtual_fields_key).(map[string][]model.IFieldDefinition)
+	res := make(map[string][]model.IFieldDefinition, len(prev)+1)
+	for id, modelFields := range prev {
+		res[id] = modelFields
+	}
+	res[m.GetId()] = append(append([]model.IFieldDefinition(nil), prev[m.GetId()]...), fields...)
+
+	return context.WithValue(ctx, ctx_virtual_fields_key, res)
+}
+
+// virtualFieldsOf returns the virtual fields of the model added to the context by their ids
+func virtualFieldsOf(ctx context.Context, m model.IModel) (map[string]model.IFieldDefinition, error) {
+	all, _ := ctx.Value(ctx_virtual_fields_key).(map[string][]model.IFieldDefinition)
+	fields := all[m.GetId()]
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	res := make(map[string]model.IFieldDefinition, len(fields))
+	for _, field := range fields {
+		id := field.GetId()
+		switch {
+		case id == "" || strings.Contains(id, "."):
+			return nil, qerror.Errorf("Invalid id '%s' of the virtual field of model '%s'", id, m.GetId())
+		case !field.IsDerivable():
+			return nil, qerror.Errorf("The virtual field '%s' of model '%s' must be derivable", id, m.GetId())
+		case m.GetFieldDefinition(id) != nil:
+			return nil, qerror.Errorf("The virtual field '%s' collides with the field of model '%s'", id, m.GetId())
+		case res[id] != nil:
+			return nil, qerror.Errorf("Duplicate virtual field '%s' of model '%s'", id, m.GetId())
+		}
+		res[id] = field
+	}
+
+	return res, nil
+}
+
+// fieldLookup returns the function returning the field of the model or the virtual field by the id
+func fieldLookup(m model.IModel, virtual map[string]model.IFieldDefinition) func(name string) model.IFieldDefinition {
+	return func(name string) model.IFieldDefinition {
+		if field, exists := virtual[name]; exists {
+			return field
+		}
+		return m.GetFieldDefinition(name)
+	}
+}
+
+// virtualFieldsNames returns the sorted ids of the virtual fields
+func virtualFieldsNames(virtual map[string]model.IFieldDefinition) []string {
+	res := make([]string, 0, len(virtual))
+	for name := range virtual {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+
+	return res
+}
+
+// derivablePlan is the read of the fields resolved to the fields read from the storage and the derivable fields
+// computed from them in the order of their dependencies
+type derivablePlan struct {
+	read     []string
+	computed []model.IFieldDefinition
+}
+
+// resolveDerivable resolves the requested fields and their dependencies, the derivable fields for which compute
+// returns true are computed by the plan, the other fields are read
+func resolveDerivable(m model.IModel, lookup func(name string) model.IFieldDefinition, fieldsNames []string, compute func(field model.IFieldDefinition) bool) (*derivablePlan, error) {
+	const (
+		visiting = iota + 1
+		visited
+	)
+
+	p := &derivablePlan{}
+	state := make(map[string]int)
+	var visit func(name, dependent string) error
+	visit = func(name, dependent string) error {
+		field := lookup(name)
+		if field == nil {
+			if dependent != "" {
+				return qerror.Errorf("The derivable field '%s' in model '%s' depends on the unknown field '%s'", dependent, m.GetId(), name)
+			}
+			return qerror.Errorf("Unknown field '%s' in model '%s'", name, m.GetId())
+		}
+		if !field.IsDerivable() || !compute(field) {
+			p.read = appendMissing(p.read, name)
+			return nil
+		}
+
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return qerror.Errorf("The derivable field '%s' in model '%s' depends on itself", name, m.GetId())
+		}
+		state[name] = visiting
+		for _, depName := range field.GetDependsOn() {
+			if err := visit(depName, name); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		p.computed = append(p.computed, field)
+
+		return nil
+	}
+
+	for _, name := range fieldsNames {
+		if err := visit(name, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// calc computes the derivable fields of the plan for n rows, get returns the read value of the field of the row.
+// The values are returned by the ids of the fields.
+func (p *derivablePlan) calc(ctx context.Context, n int, get func(i int, name string) interface{}) (map[string][]interface{}, error) {
+	res := make(map[string][]interface{}, len(p.computed))
+	if n == 0 {
+		return res, nil
+	}
+
+	for _, field := range p.computed {
+		field := field
+		values, err := calcDerivable(ctx, field, n, func(i int, row map[string]interface{}) {
+			for _, depName := range field.GetDependsOn() {
+				if computed, exists := res[depName]; exists {
+					row[depName] = computed[i]
+				} else {
+					row[depName] = get(i, depName)
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		res[field.GetId()] = values
+	}
+
+	return res, nil
+}
+
+// getAllVirtual is model.BaseModel.GetAll computing the virtual fields of the context from the read rows,
+// the fields of the model are read as they are
+func (m *BaseModel) getAllVirtual(ctx context.Context, fieldsNames []string, opts model.GetAllOptions) (*model.Data, error) {
+	virtual, err := virtualFieldsOf(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	if len(virtual) == 0 {
+		return m.BaseModel.GetAll(ctx, fieldsNames, opts)
+	}
+
+	// The fields of the related models are read as they are
+	var local, read []string
+	for _, name := range fieldsNames {
+		if strings.Contains(name, ".") {
+			read = append(read, name)
+		} else {
+			local = append(local, name)
+		}
+	}
+
+	plan, err := resolveDerivable(m, fieldLookup(m, virtual), local, func(field model.IFieldDefinition) bool {
+		return virtual[field.GetId()] != nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := m.BaseModel.GetAll(ctx, append(plan.read, read...), opts)
+	if err != nil {
+		return nil, err
+	}
+	rows := data.Data()
+
+	values, err := plan.calc(ctx, len(rows), func(i int, name string) interface{} {
+		return rows[i][data.FieldNum(name)]
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var resFields []string
+	for _, name := range fieldsNames {
+		resFields = appendMissing(resFields, strings.SplitN(name, ".", 2)[0])
+	}
+	res := model.NewEmptyData(resFields)
+	for i, row := range rows {
+		resRow := make([]interface{}, len(resFields))
+		for j, name := range resFields {
+			if computed, exists := values[name]; exists {
+				resRow[j] = computed[i]
+			} else {
+				resRow[j] = row[data.FieldNum(name)]
+			}
+		}
+		if err := res.Add(resRow); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
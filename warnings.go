@@ -0,0 +1,173 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+const ctx_check_warnings_key = "MYSQL_CHECK_WARNINGS"
+
+var (
+	// ErrDataTruncated is matched by the errors of the values truncated or clamped by the server, see WithWarningsCheck
+	ErrDataTruncated = errors.New("data truncated")
+	// ErrNotStrictMode is returned if the session sql_mode is not strict, see Options.RequireStrictMode
+	ErrNotStrictMode = errors.New("the sql_mode is not strict")
+)
+
+// truncationCodes are the codes of the warnings of the truncated values, the values longer than the column
+// are the errors in the strict mode only
+var truncationCodes = map[uint16]bool{
+	1264: true, // ER_WARN_DATA_OUT_OF_RANGE
+	1265: true, // WARN_DATA_TRUNCATED
+	1292: true, // ER_TRUNCATED_WRONG_VALUE
+	1366: true, // ER_TRUNCATED_WRONG_VALUE_FOR_FIELD
+	1406: true, // ER_DATA_TOO_LONG
+}
+
+var warningColumnRe = regexp.MustCompile(`column '([^']*)' at row (\d+)`)
+
+// Warning is the row of SHOW WARNINGS
+type Warning struct {
+	Level   string
+	Code    uint16
+	Message string
+}
+
+// TruncationError is returned by the write the value of which is truncated by the server, Row is the 1-based
+// index of the inserted row or the number of the row processed by UPDATE. errors.Is matches it with
+// ErrDataTruncated and also with ErrDataTooLong for the too long values.
+type TruncationError struct {
+	Model   string
+	Field   string
+	Row     int
+	Warning Warning
+}
+
+func (e *TruncationError) Error() string {
+	return fmt.Sprintf("The value of the field '%s' in model '%s' at row %d is truncated: %s", e.Field, e.Model, e.Row, e.Warning.Message)
+}
+
+func (e *TruncationError) Is(target error) bool {
+	return target == ErrDataTruncated || target == ErrDataTooLong && e.Warning.Code == 1406
+}
+
+// WithWarningsCheck enables or disables the check of the warnings of the INSERTs and UPDATEs run with the context,
+// it overrides BaseModelOpts.CheckWarnings. The checked writes run in a transaction, the new one is rolled back
+// if a value is truncated.
+func WithWarningsCheck(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, ctx_check_warnings_key, enabled)
+}
+
+// ChecksWarnings reports whether the warnings of the writes are checked, see BaseModelOpts.CheckWarnings
+func (m *BaseModel) ChecksWarnings() bool {
+	return m.checkWarnings
+}
+
+// checksWarnings reports whether the warnings of the writes of the model are checked, nothing is written
+// in the dry run. The model passed to the storage by model.BaseModel is not the registered one.
+func (s *MySQL) checksWarnings(ctx context.Context, m model.IModel) bool {
+	if dryRunOf(ctx) != nil {
+		return false
+	}
+	if enabled, ok := ctx.Value(ctx_check_warnings_key).(bool); ok {
+		return enabled
+	}
+
+	s.modelsMtx.RLock()
+	registered := s.models[m.GetId()]
+	s.modelsMtx.RUnlock()
+	dbModel, ok := registered.(interface{ ChecksWarnings() bool })
+
+	return ok && dbModel.ChecksWarnings()
+}
+
+// checkWarnings returns the TruncationError of the first truncation warning of the last statement of the
+// transaction, SHOW WARNINGS must run on the connection of the statement, see captureWrite
+func (s *MySQL) checkWarnings(ctx context.Context, m model.IModel) error {
+	if !s.checksWarnings(ctx, m) {
+		return nil
+	}
+
+	rows, err := s.rawQuery(ctx, "SHOW WARNINGS", nil)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var truncation *TruncationError
+	for rows.Next() {
+		var w Warning
+		if err := rows.Scan(&w.Level, &w.Code, &w.Message); err != nil {
+			return err
+		}
+		if truncation != nil || !truncationCodes[w.Code] {
+			continue
+		}
+		// The warnings of the values compared in the WHERE clause have no column
+		if match := warningColumnRe.FindStringSubmatch(w.Message); match != nil {
+			row, _ := strconv.Atoi(match[2])
+			truncation = &TruncationError{Model: m.GetId(), Field: match[1], Row: row, Warning: w}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if truncation != nil {
+		return truncation
+	}
+
+	return nil
+}
+
+// SQLMode returns the sql_mode of the session of the primary server
+func (s *MySQL) SQLMode(ctx context.Context) (string, error) {
+	rows, err := s.RawQuery(ForcePrimary(ctx), "SELECT @@SESSION.sql_mode")
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var mode string
+	if rows.Next() {
+		if err := rows.Scan(&mode); err != nil {
+			return "", err
+		}
+	}
+
+	return mode, rows.Err()
+}
+
+// CheckStrictMode returns ErrNotStrictMode if the session sql_mode has neither STRICT_TRANS_TABLES
+// nor STRICT_ALL_TABLES
+func (s *MySQL) CheckStrictMode(ctx context.Context) error {
+	mode, err := s.SQLMode(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range strings.Split(mode, ",") {
+		if m == "STRICT_TRANS_TABLES" || m == "STRICT_ALL_TABLES" {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: '%s'", ErrNotStrictMode, mode)
+}
+
+// sqlModeParam returns the sql_mode DSN parameter, the driver sets it verbatim on every connection
+func sqlModeParam(mode string) (string, error) {
+	for _, r := range mode {
+		if !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '_' || r == ',') {
+			return "", qerror.Errorf("Invalid sql_mode '%s'", mode)
+		}
+	}
+
+	return FormatLiteral(mode, LiteralOpts{})
+}
@@ -0,0 +1,301 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-qbit/model"
+	"github.com/go-qbit/qerror"
+)
+
+var (
+	// ErrWriteBehindFull is returned by Add dropping the rows which do not fit the write-behind queue
+	ErrWriteBehindFull = errors.New("the write-behind queue is full")
+	// ErrWriteBehindClosed is returned by Add after the write-behind queue is drained
+	ErrWriteBehindClosed = errors.New("the write-behind queue is closed")
+)
+
+type WriteBehindOpts struct {
+	// BatchSize is the maximum number of the rows in one INSERT, 1000 by default
+	BatchSize int
+	// FlushInterval is the maximum time a row waits for the INSERT, 1 second by default
+	FlushInterval time.Duration
+	// BufferSize is the maximum number of the queued rows, 10 batches by default
+	BufferSize int
+	// DropWhenFull makes Add to drop the rows if the queue is full, otherwise it blocks
+	DropWhenFull bool
+	// MaxRetries is the number of the retries of a failed INSERT, 3 by default. The INSERTs failed with
+	// ErrDuplicateKey, ErrForeignKeyViolation or ErrDataTooLong are not retried.
+	MaxRetries int
+	// RetryBackoff is the delay before the first retry, it is doubled for each next one, 100ms by default
+	RetryBackoff time.Duration
+	// DeadLetter receives the rows which are not inserted after the retries or by Drain after the deadline
+	DeadLetter func(err error, data *model.Data)
+}
+
+type writeBehindRow struct {
+	fieldsNames []string
+	values      []interface{}
+	opts        model.AddOptions
+}
+
+type writeBehindBatch struct {
+	data *model.Data
+	opts model.AddOptions
+}
+
+type writeBehind struct {
+	s    *MySQL
+	m    model.IModel
+	opts WriteBehindOpts
+
+	rows     chan writeBehindRow
+	done     chan struct{}
+	abort    chan struct{}
+	closeMtx sync.RWMutex
+	closed   bool
+}
+
+// SetWriteBehind makes Add of the model outside of the transactions to queue the rows and return immediately,
+// the rows are inserted in batches by a background goroutine. The models with an auto-incremented primary key
+// are refused since their ids cannot be returned by Add. Drain must be called before the storage is disconnected.
+func (s *MySQL) SetWriteBehind(m model.IModel, opts WriteBehindOpts) error {
+	if hasAutoIncrementedPK(m) {
+		return qerror.Errorf("The model '%s' with the auto-incremented primary key cannot be written behind", m.GetId())
+	}
+
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 10 * opts.BatchSize
+	}
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = 3
+	}
+	if opts.RetryBackoff <= 0 {
+		opts.RetryBackoff = 100 * time.Millisecond
+	}
+
+	s.writeBehindsMtx.Lock()
+	defer s.writeBehindsMtx.Unlock()
+
+	if _, exists := s.writeBehinds[m.GetId()]; exists {
+		return qerror.Errorf("The model '%s' is already written behind", m.GetId())
+	}
+
+	w := &writeBehind{
+		s:     s,
+		m:     m,
+		opts:  opts,
+		rows:  make(chan writeBehindRow, opts.BufferSize),
+		done:  make(chan struct{}),
+		abort: make(chan struct{}),
+	}
+	if s.writeBehinds == nil {
+		s.writeBehinds = make(map[string]*writeBehind)
+	}
+	s.writeBehinds[m.GetId()] = w
+
+	go w.run()
+
+	return nil
+}
+
+func (m *BaseModel) SetWriteBehind(opts WriteBehindOpts) error {
+	return m.db.SetWriteBehind(m, opts)
+}
+
+// Drain stops queueing the rows of the write-behind models and waits until the queued ones are inserted.
+// When the context is done the rows left are passed to the dead letter callbacks and the context error is returned.
+func (s *MySQL) Drain(ctx context.Context) error {
+	s.writeBehindsMtx.RLock()
+	writeBehinds := make([]*writeBehind, 0, len(s.writeBehinds))
+	for _, w := range s.writeBehinds {
+		writeBehinds = append(writeBehinds, w)
+	}
+	s.writeBehindsMtx.RUnlock()
+
+	for _, w := range writeBehinds {
+		w.close()
+	}
+
+	for _, w := range writeBehinds {
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			for _, w := range writeBehinds {
+				w.stop()
+			}
+			for _, w := range writeBehinds {
+				<-w.done
+			}
+			return ctx.Err()
+		}
+	}
+
+	return nil
+}
+
+func (s *MySQL) getWriteBehind(ctx context.Context, modelId string) *writeBehind {
+	if ctx.Value(s.transactionKey()) != nil || dryRunOf(ctx) != nil {
+		return nil
+	}
+
+	s.writeBehindsMtx.RLock()
+	defer s.writeBehindsMtx.RUnlock()
+
+	return s.writeBehinds[modelId]
+}
+
+// add queues the rows and returns their primary keys
+func (w *writeBehind) add(data *model.Data, opts model.AddOptions) (*model.Data, error) {
+	fieldsPos := make(map[string]int)
+	for i, fieldName := range data.Fields() {
+		fieldsPos[fieldName] = i
+	}
+
+	pkFieldsNames := w.m.GetPKFieldsNames()
+	res := make([][]interface{}, data.Len())
+	for i, row := range data.Data() {
+		rowRes := make([]interface{}, len(pkFieldsNames))
+		for j, fieldName := range pkFieldsNames {
+			if fp, exists := fieldsPos[fieldName]; exists {
+				if rv := reflect.ValueOf(row[fp]); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+					rowRes[j] = rv.Elem().Interface()
+				} else {
+					rowRes[j] = row[fp]
+				}
+			}
+		}
+		res[i] = rowRes
+	}
+
+	w.closeMtx.RLock()
+	defer w.closeMtx.RUnlock()
+
+	if w.closed {
+		return nil, ErrWriteBehindClosed
+	}
+
+	for _, row := range data.Data() {
+		if !w.opts.DropWhenFull {
+			w.rows <- writeBehindRow{data.Fields(), row, opts}
+			continue
+		}
+
+		select {
+		case w.rows <- writeBehindRow{data.Fields(), row, opts}:
+		default:
+			return nil, ErrWriteBehindFull
+		}
+	}
+
+	return model.NewData(pkFieldsNames, res), nil
+}
+
+func (w *writeBehind) close() {
+	w.closeMtx.Lock()
+	defer w.closeMtx.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.rows)
+	}
+}
+
+func (w *writeBehind) stop() {
+	select {
+	case <-w.abort:
+	default:
+		close(w.abort)
+	}
+}
+
+func (w *writeBehind) run() {
+	defer close(w.done)
+
+	ticks, stopTicker := w.s.newTicker(w.opts.FlushInterval)
+	defer stopTicker()
+
+	// The rows are batched by the set of the fields and the options
+	batches := make(map[string]*writeBehindBatch)
+	flushAll := func() {
+		for key, batch := range batches {
+			w.flush(batch)
+			delete(batches, key)
+		}
+	}
+
+	for {
+		select {
+		case row, ok := <-w.rows:
+			if !ok {
+				flushAll()
+				return
+			}
+
+			key := strconv.FormatBool(row.opts.Replace) + ":" + strings.Join(row.fieldsNames, ",")
+			batch := batches[key]
+			if batch == nil {
+				batch = &writeBehindBatch{
+					data: model.NewData(row.fieldsNames, make([][]interface{}, 0, w.opts.BatchSize)),
+					opts: row.opts,
+				}
+				batches[key] = batch
+			}
+			_ = batch.data.Add(row.values)
+			if batch.data.Len() >= w.opts.BatchSize {
+				w.flush(batch)
+				delete(batches, key)
+			}
+
+		case <-ticks:
+			flushAll()
+		}
+	}
+}
+
+func (w *writeBehind) flush(batch *writeBehindBatch) {
+	backoff := w.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-w.abort:
+			w.deadLetter(context.Canceled, batch.data)
+			return
+		default:
+		}
+
+		// The rows are scoped by Add
+		_, err := w.s.insert(flushContext(Unscoped(context.Background())), w.m, batch.data, batch.opts)
+		if err == nil {
+			return
+		}
+		if attempt >= w.opts.MaxRetries || isPermanentError(err) {
+			w.deadLetter(err, batch.data)
+			return
+		}
+
+		select {
+		case <-w.abort:
+			w.deadLetter(err, batch.data)
+			return
+		case <-w.s.after(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *writeBehind) deadLetter(err error, batch *model.Data) {
+	if w.opts.DeadLetter != nil {
+		w.opts.DeadLetter(err, batch)
+	}
+}
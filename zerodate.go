@@ -0,0 +1,104 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ZeroDatePolicy defines the values read from the DATE, DATETIME and TIMESTAMP fields storing the zero date
+// '0000-00-00', the policy is applied regardless of the parseTime DSN parameter
+type ZeroDatePolicy int
+
+const (
+	// ZeroDateZeroTime returns the zero date as '0000-00-00' or '0000-00-00 00:00:00'
+	ZeroDateZeroTime ZeroDatePolicy = iota
+	// ZeroDateNull returns the zero date as NULL, the field must be nullable
+	ZeroDateNull
+	// ZeroDateError fails the read with the TemporalValueError matched by ErrZeroDate
+	ZeroDateError
+)
+
+var (
+	// ErrZeroDate is matched by the errors of the zero dates read from the fields with ZeroDateError
+	ErrZeroDate = errors.New("zero date")
+	// ErrZeroTime is matched by the errors of the zero time.Time values written to the NOT NULL fields
+	// without AllowZeroTime
+	ErrZeroTime = errors.New("zero time")
+)
+
+// ITemporalFieldDefinition is implemented by the DATE, DATETIME and TIMESTAMP fields
+type ITemporalFieldDefinition interface {
+	GetZeroDatePolicy() ZeroDatePolicy
+}
+
+// TemporalValueError is the error of the zero date read or written, errors.Is matches it with the Kind sentinel
+type TemporalValueError struct {
+	Kind  error
+	Field string
+	Value string
+}
+
+func (e *TemporalValueError) Error() string {
+	if e.Kind == ErrZeroTime {
+		return fmt.Sprintf("The zero time cannot be written to the NOT NULL field '%s', set AllowZeroTime to store the zero date", e.Field)
+	}
+
+	return fmt.Sprintf("The field '%s' contains the zero date '%s'", e.Field, e.Value)
+}
+
+func (e *TemporalValueError) Is(target error) bool { return target == e.Kind }
+
+// isZeroDate reports whether the scanned value is the zero date, the driver returns it as is or as the zero
+// time.Time with parseTime which is formatted as RFC 3339 when scanned into the string
+func isZeroDate(s string) bool {
+	return strings.HasPrefix(s, "0000-00-00") || s == time.Time{}.Format(time.RFC3339Nano)
+}
+
+// decodeTemporal applies the policy to the scanned value, zero is the zero date in the format of the field
+func decodeTemporal(field string, policy ZeroDatePolicy, zero string, v interface{}) (interface{}, error) {
+	switch val := v.(type) {
+	case string:
+		if !isZeroDate(val) {
+			return val, nil
+		}
+		if policy == ZeroDateError {
+			return nil, &TemporalValueError{ErrZeroDate, field, val}
+		}
+		return zero, nil
+	case *string:
+		if val == nil || !isZeroDate(*val) {
+			return val, nil
+		}
+		switch policy {
+		case ZeroDateError:
+			return nil, &TemporalValueError{ErrZeroDate, field, *val}
+		case ZeroDateNull:
+			return (*string)(nil), nil
+		}
+		return &zero, nil
+	}
+
+	return v, nil
+}
+
+// encodeTemporal refuses the zero time.Time if the field does not accept it
+func encodeTemporal(field string, refuseZero bool, v interface{}) (interface{}, error) {
+	if !refuseZero {
+		return v, nil
+	}
+
+	switch val := v.(type) {
+	case time.Time:
+		if val.IsZero() {
+			return nil, &TemporalValueError{Kind: ErrZeroTime, Field: field}
+		}
+	case *time.Time:
+		if val != nil && val.IsZero() {
+			return nil, &TemporalValueError{Kind: ErrZeroTime, Field: field}
+		}
+	}
+
+	return v, nil
+}